@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"github.com/yazhou/krunclaw/internal/vm/fakeqemu"
+)
+
+func main() {
+	os.Exit(fakeqemu.Main(os.Args[1:], os.Stderr))
+}
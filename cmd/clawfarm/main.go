@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,6 +11,10 @@ import (
 func main() {
 	cli := app.New(os.Stdout, os.Stderr)
 	if err := cli.Run(os.Args[1:]); err != nil {
+		var exitErr *app.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintf(os.Stderr, "clawfarm: %v\n", err)
 		os.Exit(1)
 	}
@@ -6,7 +6,6 @@ toolchain go1.24.5
 
 require (
 	github.com/gofrs/flock v0.13.0
+	golang.org/x/sys v0.37.0
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
 )
-
-require golang.org/x/sys v0.37.0 // indirect
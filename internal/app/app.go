@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -22,6 +21,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -29,9 +29,13 @@ import (
 
 	"github.com/yazhou/krunclaw/internal/clawbox"
 	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/fetch"
 	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/keychain"
 	"github.com/yazhou/krunclaw/internal/state"
 	"github.com/yazhou/krunclaw/internal/vm"
+	"github.com/yazhou/krunclaw/internal/vm/fakeqemu"
 )
 
 const (
@@ -55,15 +59,31 @@ var exportSecretScanPatterns = []struct {
 
 var runNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,47}$`)
 
+var hostNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]{0,251}[A-Za-z0-9])?$`)
+
 type App struct {
-	out     io.Writer
-	errOut  io.Writer
-	in      io.Reader
-	backend vm.Backend
+	out             io.Writer
+	errOut          io.Writer
+	in              io.Reader
+	backend         vm.Backend
+	keychain        keychain.Keychain
+	probeResources  func(diskPath string) (vm.HostResources, error)
+	startWatcher    func(dirs config.DirOverrides, id string, instanceDir string) (int, error)
+	startSupervisor func(dirs config.DirOverrides, id string, instanceDir string) (int, error)
+	executable      func() (string, error)
+	prepareLock     *sync.Mutex
+	bootPhase       func(phase string)
+	dirs            config.DirOverrides
 }
 
 func New(out io.Writer, errOut io.Writer) *App {
-	return NewWithIOAndBackend(out, errOut, os.Stdin, vm.NewQEMUBackend(out))
+	application := NewWithIOAndBackend(out, errOut, os.Stdin, vm.NewHostBackend(out))
+	application.keychain = keychain.System()
+	application.probeResources = vm.ProbeHostResources
+	application.startWatcher = startWorkspaceWatcher
+	application.startSupervisor = startCISupervisor
+	application.executable = os.Executable
+	return application
 }
 
 func NewWithBackend(out io.Writer, errOut io.Writer, backend vm.Backend) *App {
@@ -74,11 +94,24 @@ func NewWithIOAndBackend(out io.Writer, errOut io.Writer, in io.Reader, backend
 	return &App{out: out, errOut: errOut, in: in, backend: backend}
 }
 
-func (a *App) Run(args []string) error {
+func (a *App) Run(args []string) (err error) {
+	flags, args, err := parseGlobalFlags(args)
+	if err != nil {
+		return err
+	}
+	a.dirs = flags.Dirs
+	restoreDownloadLimits, err := applyDownloadLimits(flags.Downloads)
+	if err != nil {
+		return err
+	}
+	defer restoreDownloadLimits()
 	if len(args) == 0 {
 		a.printUsage()
 		return nil
 	}
+	if config.CrashReportsEnabled() {
+		defer a.captureCrash(args[0], &err)
+	}
 
 	switch args[0] {
 	case "image":
@@ -89,18 +122,87 @@ func (a *App) Run(args []string) error {
 		return a.runRun(args[1:])
 	case "ps":
 		return a.runPS(args[1:])
+	case "inspect":
+		return a.runInspect(args[1:])
 	case "suspend":
 		return a.runSuspend(args[1:])
 	case "resume":
 		return a.runResume(args[1:])
+	case "stop":
+		return a.runStop(args[1:])
+	case "start":
+		return a.runStart(args[1:])
+	case "restart":
+		return a.runRestart(args[1:])
 	case "rm":
 		return a.runRemove(args[1:])
+	case "clone":
+		return a.runClone(args[1:])
+	case "resize":
+		return a.runResize(args[1:])
+	case "update":
+		return a.runUpdate(args[1:])
 	case "export":
 		return a.runExport(args[1:])
+	case "cp":
+		return a.runCopy(args[1:])
+	case "doctor":
+		return a.runDoctor(args[1:])
+	case "logs":
+		return a.runLogs(args[1:])
+	case "audit":
+		return a.runAudit(args[1:])
+	case "env":
+		return a.runEnv(args[1:])
+	case "secret":
+		return a.runSecret(args[1:])
+	case "commit":
+		return a.runCommit(args[1:])
 	case "checkpoint":
 		return a.runCheckpoint(args[1:])
 	case "restore":
 		return a.runRestore(args[1:])
+	case "channels":
+		return a.runChannels(args[1:])
+	case "cron":
+		return a.runCron(args[1:])
+	case "drift":
+		return a.runDrift(args[1:])
+	case "host":
+		return a.runHost(args[1:])
+	case "system":
+		return a.runSystem(args[1:])
+	case "blob":
+		return a.runBlob(args[1:])
+	case "unlock":
+		return a.runUnlock(args[1:])
+	case "ide":
+		return a.runIDE(args[1:])
+	case "mcp":
+		return a.runMCP(args[1:])
+	case "ssh":
+		return a.runSSH(args[1:])
+	case "exec":
+		return a.runExec(args[1:])
+	case "ssh-config":
+		return a.runSSHConfig(args[1:])
+	case "version":
+		return a.runVersion(args[1:])
+	case "stats":
+		return a.runStats(args[1:])
+	case "bench":
+		return a.runBench(args[1:])
+	case "self-update":
+		return a.runSelfUpdate(args[1:])
+	case "ci-supervise":
+		return a.runCISupervise(args[1:])
+	case "workspace-watch":
+		return a.runWorkspaceWatch(args[1:])
+	case vm.FakeQEMUSubcommand:
+		if code := fakeqemu.Main(args[1:], a.errOut); code != 0 {
+			return &ExitError{Code: code}
+		}
+		return nil
 	case "help", "-h", "--help":
 		a.printUsage()
 		return nil
@@ -111,7 +213,7 @@ func (a *App) Run(args []string) error {
 
 func (a *App) runImage(args []string) error {
 	if len(args) == 0 {
-		return errors.New("usage: clawfarm image <ls|fetch>")
+		return errors.New("usage: clawfarm image <ls|fetch|import-oci>")
 	}
 
 	manager, err := a.imageManager()
@@ -121,13 +223,27 @@ func (a *App) runImage(args []string) error {
 
 	switch args[0] {
 	case "ls":
-		if len(args) != 1 {
-			return errors.New("usage: clawfarm image ls")
+		format := outputFormatTable
+		for index := 1; index < len(args); index++ {
+			parsed, next, ok, err := parseFormatFlag(args, index)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errors.New("usage: clawfarm image ls [--format table|json]")
+			}
+			format, index = parsed, next
 		}
 		items, err := manager.ListAvailable()
 		if err != nil {
 			return err
 		}
+		if format == outputFormatJSON {
+			if items == nil {
+				items = []images.Metadata{}
+			}
+			return writeJSON(a.out, items)
+		}
 		if len(items) == 0 {
 			fmt.Fprintln(a.out, "no images available")
 			return nil
@@ -160,6 +276,8 @@ func (a *App) runImage(args []string) error {
 		fmt.Fprintf(a.out, "  file:   %s\n", meta.RuntimeDisk)
 		fmt.Fprintf(a.out, "  format: %s\n", meta.DiskFormat)
 		return nil
+	case "import-oci":
+		return a.runImageImportOCI(manager, args[1:])
 	default:
 		return fmt.Errorf("unknown image subcommand %q", args[0])
 	}
@@ -176,48 +294,67 @@ type runTarget struct {
 	SpecJSONMode            bool
 	SkipMount               bool
 	SpecBaseImageURL        string
+	SpecBaseImageMirrors    []string
 	SpecBaseImageSHA256     string
 	SpecLayerArtifacts      []runArtifact
 	SpecProvisionCommands   []string
+	SpecProvisionTarget     string
+	SpecHash                string
 	OpenClawModelPrimary    string
 	OpenClawGatewayAuthMode string
 	OpenClawRequiredEnv     []string
+	OpenClawOptionalEnv     []string
+	OpenClawPackage         string
+	OpenClawIntegrity       string
+	OpenClawBundle          *runOpenClawBundleSpec
+	RunDefaults             *clawbox.RunDefaults
 	IsClawbox               bool
 }
 
 type runArtifact struct {
-	Label  string
-	URL    string
-	SHA256 string
+	Label   string
+	URL     string
+	Mirrors []string
+	SHA256  string
 }
 
+const (
+	provisionTargetHost  = "host"
+	provisionTargetGuest = "guest"
+)
+
 type runSpecJSONEnvelope struct {
-	Name      string          `json:"name,omitempty"`
-	Spec      runSpecJSONBody `json:"spec"`
-	Provision []string        `json:"provision,omitempty"`
+	Name            string          `json:"name,omitempty"`
+	Spec            runSpecJSONBody `json:"spec"`
+	Provision       []string        `json:"provision,omitempty"`
+	ProvisionTarget string          `json:"provision_target,omitempty"`
 }
 
 type runSpecJSONBody struct {
-	Name      string               `json:"name,omitempty"`
-	BaseImage clawbox.BaseImage    `json:"base_image"`
-	Layers    []clawbox.Layer      `json:"layers,omitempty"`
-	OpenClaw  clawbox.OpenClawSpec `json:"openclaw"`
-	Provision []string             `json:"provision,omitempty"`
+	Name            string               `json:"name,omitempty"`
+	BaseImage       clawbox.BaseImage    `json:"base_image"`
+	Layers          []clawbox.Layer      `json:"layers,omitempty"`
+	OpenClaw        clawbox.OpenClawSpec `json:"openclaw"`
+	RunDefaults     *clawbox.RunDefaults `json:"run_defaults,omitempty"`
+	Provision       []string             `json:"provision,omitempty"`
+	ProvisionTarget string               `json:"provision_target,omitempty"`
 }
 
 type preparedRunTarget struct {
-	ImageMeta         images.Metadata
-	MountSource       string
-	LayerPaths        []string
-	ProvisionCommands []string
+	ImageMeta              images.Metadata
+	MountSource            string
+	LayerPaths             []string
+	BlobDigests            []string
+	ProvisionCommands      []string
+	GuestProvisionCommands []string
 }
 
-func (a *App) resolveRunTarget(input string) (runTarget, error) {
-	if !isClawboxRunInput(input) {
+func (a *App) resolveRunTarget(input string, forceClawbox bool) (runTarget, error) {
+	if !forceClawbox && !isClawboxRunInput(input) {
 		return runTarget{Input: input, ImageRef: input}, nil
 	}
 
-	clawboxPath, err := resolveClawboxPath(input)
+	clawboxPath, err := a.resolveClawboxPath(input)
 	if err != nil {
 		return runTarget{}, err
 	}
@@ -242,18 +379,22 @@ func (a *App) resolveRunTarget(input string) (runTarget, error) {
 
 			return runTarget{
 				Input:                   input,
+				SpecHash:                specSHA256(body),
 				ImageRef:                strings.TrimSpace(header.Spec.BaseImage.Ref),
 				ClawID:                  clawID,
 				MountSource:             clawboxPath,
 				OpenClawModelPrimary:    strings.TrimSpace(header.Spec.OpenClaw.ModelPrimary),
 				OpenClawGatewayAuthMode: strings.TrimSpace(header.Spec.OpenClaw.GatewayAuthMode),
 				OpenClawRequiredEnv:     append([]string(nil), header.Spec.OpenClaw.RequiredEnv...),
+				OpenClawOptionalEnv:     append([]string(nil), header.Spec.OpenClaw.OptionalEnv...),
+				RunDefaults:             header.Spec.RunDefaults,
 				IsClawbox:               true,
 			}, nil
 		}
 
 		target, specErr := resolveRunTargetFromSpecJSON(input, clawboxPath, body)
 		if specErr == nil {
+			target.SpecHash = specSHA256(body)
 			return target, nil
 		}
 
@@ -262,7 +403,10 @@ func (a *App) resolveRunTarget(input string) (runTarget, error) {
 
 	target, tarErr := resolveRunTargetFromTarClawbox(input, clawboxPath)
 	if tarErr == nil {
-		return target, nil
+		target.SpecHash, tarErr = clawboxV2SpecHash(*target.ClawboxV2Spec)
+		if tarErr == nil {
+			return target, nil
+		}
 	}
 
 	return runTarget{}, fmt.Errorf("parse clawbox %s as tar.gz: %w", clawboxPath, tarErr)
@@ -300,7 +444,11 @@ func resolveRunTargetFromSpecJSON(input string, clawboxPath string, body []byte)
 	if decodeErr := decodeJSONStrict(body, &envelope); decodeErr == nil && strings.TrimSpace(envelope.Spec.BaseImage.Ref) != "" {
 		provision := append([]string(nil), envelope.Provision...)
 		provision = append(provision, envelope.Spec.Provision...)
-		return buildRunTargetFromSpecJSON(input, clawboxPath, envelope.Name, envelope.Spec, provision)
+		provisionTarget := envelope.ProvisionTarget
+		if strings.TrimSpace(provisionTarget) == "" {
+			provisionTarget = envelope.Spec.ProvisionTarget
+		}
+		return buildRunTargetFromSpecJSON(input, clawboxPath, envelope.Name, envelope.Spec, provision, provisionTarget)
 	}
 
 	var direct runSpecJSONBody
@@ -308,23 +456,32 @@ func resolveRunTargetFromSpecJSON(input string, clawboxPath string, body []byte)
 		if strings.TrimSpace(direct.BaseImage.Ref) == "" {
 			return runTarget{}, errors.New("spec-json missing base_image.ref")
 		}
-		return buildRunTargetFromSpecJSON(input, clawboxPath, direct.Name, direct, direct.Provision)
+		return buildRunTargetFromSpecJSON(input, clawboxPath, direct.Name, direct, direct.Provision, direct.ProvisionTarget)
 	}
 
 	return runTarget{}, errors.New("expected JSON clawbox header or JSON clawbox spec")
 }
 
-func buildRunTargetFromSpecJSON(input string, clawboxPath string, name string, spec runSpecJSONBody, provision []string) (runTarget, error) {
+func buildRunTargetFromSpecJSON(input string, clawboxPath string, name string, spec runSpecJSONBody, provision []string, provisionTarget string) (runTarget, error) {
 	runtimeSpec := clawbox.RuntimeSpec{
-		BaseImage: spec.BaseImage,
-		Layers:    append([]clawbox.Layer(nil), spec.Layers...),
-		OpenClaw:  spec.OpenClaw,
+		BaseImage:   spec.BaseImage,
+		Layers:      append([]clawbox.Layer(nil), spec.Layers...),
+		OpenClaw:    spec.OpenClaw,
+		RunDefaults: spec.RunDefaults,
 	}
 	resolvedName := resolveSpecJSONName(name, clawboxPath)
 	if err := validateRunSpecJSON(resolvedName, runtimeSpec); err != nil {
 		return runTarget{}, fmt.Errorf("invalid JSON clawbox spec: %w", err)
 	}
 
+	provisionTarget = strings.ToLower(strings.TrimSpace(provisionTarget))
+	if provisionTarget == "" {
+		provisionTarget = provisionTargetGuest
+	}
+	if provisionTarget != provisionTargetHost && provisionTarget != provisionTargetGuest {
+		return runTarget{}, fmt.Errorf("invalid JSON clawbox spec: provision_target %q must be host or guest", provisionTarget)
+	}
+
 	clawID, err := clawbox.ComputeClawID(clawboxPath, resolvedName)
 	if err != nil {
 		return runTarget{}, fmt.Errorf("compute CLAWID for %s: %w", clawboxPath, err)
@@ -333,9 +490,10 @@ func buildRunTargetFromSpecJSON(input string, clawboxPath string, name string, s
 	layerArtifacts := make([]runArtifact, 0, len(spec.Layers))
 	for index, layer := range spec.Layers {
 		layerArtifacts = append(layerArtifacts, runArtifact{
-			Label:  fmt.Sprintf("layer-%d", index+1),
-			URL:    strings.TrimSpace(layer.URL),
-			SHA256: strings.TrimSpace(layer.SHA256),
+			Label:   fmt.Sprintf("layer-%d", index+1),
+			URL:     strings.TrimSpace(layer.URL),
+			Mirrors: trimmedStrings(layer.Mirrors),
+			SHA256:  strings.TrimSpace(layer.SHA256),
 		})
 	}
 
@@ -346,12 +504,16 @@ func buildRunTargetFromSpecJSON(input string, clawboxPath string, name string, s
 		SpecJSONMode:            true,
 		SkipMount:               true,
 		SpecBaseImageURL:        strings.TrimSpace(spec.BaseImage.URL),
+		SpecBaseImageMirrors:    trimmedStrings(spec.BaseImage.Mirrors),
 		SpecBaseImageSHA256:     strings.TrimSpace(spec.BaseImage.SHA256),
 		SpecLayerArtifacts:      layerArtifacts,
 		SpecProvisionCommands:   normalizeProvisionCommands(provision),
+		SpecProvisionTarget:     provisionTarget,
 		OpenClawModelPrimary:    strings.TrimSpace(spec.OpenClaw.ModelPrimary),
 		OpenClawGatewayAuthMode: strings.TrimSpace(spec.OpenClaw.GatewayAuthMode),
 		OpenClawRequiredEnv:     append([]string(nil), spec.OpenClaw.RequiredEnv...),
+		OpenClawOptionalEnv:     append([]string(nil), spec.OpenClaw.OptionalEnv...),
+		RunDefaults:             spec.RunDefaults,
 		IsClawbox:               false,
 	}, nil
 }
@@ -403,6 +565,53 @@ func validateRunSpecJSON(name string, spec clawbox.RuntimeSpec) error {
 	return header.Validate()
 }
 
+func applyClawboxRunDefaults(defaults *clawbox.RunDefaults, args []string, cpus *int, memoryMiB *int, published *portList, volumes *volumeList) error {
+	if defaults == nil {
+		return nil
+	}
+	if defaults.CPUs > 0 && !hasCLIFlag(args, "--cpus") {
+		*cpus = defaults.CPUs
+	}
+	if defaults.MemoryMiB > 0 && !hasCLIFlag(args, "--memory-mib") {
+		*memoryMiB = defaults.MemoryMiB
+	}
+
+	usedHostPorts := map[int]struct{}{}
+	for _, mapping := range published.Mappings {
+		usedHostPorts[mapping.HostPort] = struct{}{}
+	}
+	for _, value := range defaults.Publish {
+		mapping, err := parsePortMapping(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		if _, exists := usedHostPorts[mapping.HostPort]; exists {
+			continue
+		}
+		usedHostPorts[mapping.HostPort] = struct{}{}
+		published.Values = append(published.Values, value)
+		published.Mappings = append(published.Mappings, mapping)
+	}
+
+	usedVolumeNames := map[string]struct{}{}
+	for _, mapping := range volumes.Mappings {
+		usedVolumeNames[mapping.Name] = struct{}{}
+	}
+	for _, value := range defaults.Volumes {
+		mapping, err := parseVolumeMapping(value)
+		if err != nil {
+			return err
+		}
+		if _, exists := usedVolumeNames[mapping.Name]; exists {
+			continue
+		}
+		usedVolumeNames[mapping.Name] = struct{}{}
+		volumes.Values = append(volumes.Values, value)
+		volumes.Mappings = append(volumes.Mappings, mapping)
+	}
+	return nil
+}
+
 func normalizeProvisionCommands(commands []string) []string {
 	result := make([]string, 0, len(commands))
 	for _, command := range commands {
@@ -415,6 +624,16 @@ func normalizeProvisionCommands(commands []string) []string {
 	return result
 }
 
+func trimmedStrings(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
+
 func (a *App) prepareRunTarget(ctx context.Context, manager *images.Manager, target runTarget) (preparedRunTarget, error) {
 	if target.ClawboxV2Mode && target.ClawboxV2Spec != nil {
 		if _, hasRunImage := target.ClawboxV2Spec.runImage(); hasRunImage {
@@ -449,7 +668,7 @@ func (a *App) prepareRunTarget(ctx context.Context, manager *images.Manager, tar
 		}, nil
 	}
 
-	blobsRoot, err := clawfarmBlobsRoot()
+	blobsRoot, err := a.blobsRoot()
 	if err != nil {
 		return preparedRunTarget{}, err
 	}
@@ -458,44 +677,45 @@ func (a *App) prepareRunTarget(ctx context.Context, manager *images.Manager, tar
 	}
 
 	baseArtifact := runArtifact{
-		Label:  "base",
-		URL:    strings.TrimSpace(target.SpecBaseImageURL),
-		SHA256: strings.TrimSpace(target.SpecBaseImageSHA256),
+		Label:   "base",
+		URL:     strings.TrimSpace(target.SpecBaseImageURL),
+		Mirrors: target.SpecBaseImageMirrors,
+		SHA256:  strings.TrimSpace(target.SpecBaseImageSHA256),
 	}
-	basePath, err := ensureSpecArtifact(ctx, blobsRoot, baseArtifact, a.out)
+	artifactPaths, err := ensureSpecArtifacts(ctx, blobsRoot, append([]runArtifact{baseArtifact}, target.SpecLayerArtifacts...), a.out)
 	if err != nil {
 		return preparedRunTarget{}, err
 	}
-
-	layerPaths := make([]string, 0, len(target.SpecLayerArtifacts))
-	for _, layer := range target.SpecLayerArtifacts {
-		layerPath, layerErr := ensureSpecArtifact(ctx, blobsRoot, layer, a.out)
-		if layerErr != nil {
-			return preparedRunTarget{}, layerErr
-		}
-		layerPaths = append(layerPaths, layerPath)
-	}
+	basePath := artifactPaths[0]
+	layerPaths := artifactPaths[1:]
+	a.enforceBlobCache(blobsRoot, blobDigests(artifactPaths))
 
 	imageMeta := images.Metadata{
 		Ref:         target.ImageRef,
 		Arch:        detectImageArch(target.ImageRef),
 		RuntimeDisk: basePath,
 		Ready:       true,
-		DiskFormat:  detectDiskFormatForPath(basePath),
+		DiskFormat:  diskutil.DetectFormat(basePath),
 	}
 
 	now := time.Now().UTC()
 	imageMeta.FetchedAtUTC = now
 	imageMeta.UpdatedAtUTC = now
 
-	return preparedRunTarget{
-		ImageMeta:         imageMeta,
-		LayerPaths:        layerPaths,
-		ProvisionCommands: append([]string(nil), target.SpecProvisionCommands...),
-	}, nil
+	prepared := preparedRunTarget{
+		ImageMeta:   imageMeta,
+		LayerPaths:  layerPaths,
+		BlobDigests: blobDigests(artifactPaths),
+	}
+	if target.SpecProvisionTarget == provisionTargetHost {
+		prepared.ProvisionCommands = append([]string(nil), target.SpecProvisionCommands...)
+	} else {
+		prepared.GuestProvisionCommands = append([]string(nil), target.SpecProvisionCommands...)
+	}
+	return prepared, nil
 }
 
-func ensureSpecArtifact(ctx context.Context, root string, artifact runArtifact, out io.Writer) (string, error) {
+func checkSpecArtifact(root string, artifact runArtifact, out io.Writer) (string, string, bool, error) {
 	label := strings.TrimSpace(artifact.Label)
 	if label == "" {
 		label = "artifact"
@@ -503,187 +723,67 @@ func ensureSpecArtifact(ctx context.Context, root string, artifact runArtifact,
 
 	rawURL := strings.TrimSpace(artifact.URL)
 	if rawURL == "" {
-		return "", fmt.Errorf("%s.url is required", label)
-	}
-	if _, err := url.ParseRequestURI(rawURL); err != nil {
-		return "", fmt.Errorf("invalid %s.url %q: %w", label, rawURL, err)
+		return "", "", false, fmt.Errorf("%s.url is required", label)
 	}
-
 	expectedSHA := strings.ToLower(strings.TrimSpace(artifact.SHA256))
 	if matched, _ := regexp.MatchString(`^[a-f0-9]{64}$`, expectedSHA); !matched {
-		return "", fmt.Errorf("invalid %s.sha256 %q: expected lowercase 64-char hex", label, artifact.SHA256)
+		return "", "", false, fmt.Errorf("invalid %s.sha256 %q: expected lowercase 64-char hex", label, artifact.SHA256)
+	}
+	if _, err := specArtifactScheme(rawURL, expectedSHA); err != nil {
+		return "", "", false, fmt.Errorf("invalid %s.url %q: %w", label, rawURL, err)
+	}
+	for _, mirror := range artifact.Mirrors {
+		if _, err := specArtifactScheme(mirror, expectedSHA); err != nil {
+			return "", "", false, fmt.Errorf("invalid %s mirror %q: %w", label, mirror, err)
+		}
 	}
 
 	artifactPath := filepath.Join(root, expectedSHA)
-	tempPath := artifactPath + ".tmp.download"
-	_ = os.Remove(tempPath)
 	if fileExistsAndNonEmpty(artifactPath) {
-		if err := verifyFileSHA256(artifactPath, expectedSHA); err == nil {
+		if err := diskutil.VerifySHA256(artifactPath, expectedSHA); err == nil {
+			now := time.Now()
+			_ = os.Chtimes(artifactPath, now, now)
 			if out != nil {
 				fmt.Fprintf(out, "using cached %s %s\n", label, artifactPath)
 			}
-			return artifactPath, nil
+			return artifactPath, expectedSHA, true, nil
 		}
 		_ = os.Remove(artifactPath)
 	}
-
-	if err := downloadFileWithProgress(ctx, rawURL, tempPath, out, label); err != nil {
-		return "", fmt.Errorf("download %s: %w", label, err)
-	}
-	if err := verifyFileSHA256(tempPath, expectedSHA); err != nil {
-		_ = os.Remove(tempPath)
-		return "", err
-	}
-	if err := os.Rename(tempPath, artifactPath); err != nil {
-		_ = os.Remove(tempPath)
-		return "", err
-	}
-
-	return artifactPath, nil
+	return artifactPath, expectedSHA, false, nil
 }
 
-func downloadFileWithProgress(ctx context.Context, rawURL string, destination string, out io.Writer, label string) error {
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
-	if err != nil {
-		return err
-	}
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed with status %s", response.Status)
-	}
-
-	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
-		return err
-	}
-
-	file, err := os.Create(destination)
-	if err != nil {
-		return err
-	}
-
-	cleanup := func() {
-		file.Close()
-		_ = os.Remove(destination)
+func downloadSpecArtifact(ctx context.Context, artifact runArtifact, artifactPath string, expectedSHA string, progress func(downloaded int64, total int64)) error {
+	label := strings.TrimSpace(artifact.Label)
+	if label == "" {
+		label = "artifact"
 	}
 
-	if out == nil {
-		if _, err := io.Copy(file, response.Body); err != nil {
-			cleanup()
-			return err
-		}
-	} else {
-		buffer := make([]byte, 1024*1024)
-		total := response.ContentLength
-		var downloaded int64
-		lastRender := time.Time{}
-		render := func(force bool) {
-			if !force && !lastRender.IsZero() && time.Since(lastRender) < 120*time.Millisecond {
-				return
-			}
-			lastRender = time.Now()
-			renderDownloadProgress(out, label, downloaded, total)
-		}
-
-		for {
-			readBytes, readErr := response.Body.Read(buffer)
-			if readBytes > 0 {
-				writtenBytes, writeErr := file.Write(buffer[:readBytes])
-				if writeErr != nil {
-					cleanup()
-					return writeErr
-				}
-				if writtenBytes != readBytes {
-					cleanup()
-					return io.ErrShortWrite
-				}
-				downloaded += int64(readBytes)
-				render(false)
-			}
-
-			if readErr == io.EOF {
-				render(true)
-				fmt.Fprintln(out)
-				break
-			}
-			if readErr != nil {
-				cleanup()
-				return readErr
-			}
+	options := fetch.Options{Progress: progress, SHA256: expectedSHA, Retries: fetch.DefaultRetries}
+	candidates := append([]string{strings.TrimSpace(artifact.URL)}, artifact.Mirrors...)
+	if len(candidates) == 1 {
+		if err := resolveSpecArtifact(ctx, candidates[0], artifactPath, options); err != nil {
+			return fmt.Errorf("download %s: %w", label, err)
 		}
+		return nil
 	}
 
-	if err := file.Close(); err != nil {
-		_ = os.Remove(destination)
-		return err
-	}
-
-	return nil
-}
-
-func clawfarmBlobsRoot() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(home, ".clawfarm", "blobs"), nil
-}
-
-func renderDownloadProgress(out io.Writer, label string, downloaded int64, total int64) {
-	if total > 0 {
-		percent := float64(downloaded) / float64(total) * 100
-		if percent > 100 {
-			percent = 100
-		}
-		barWidth := 28
-		filled := int(float64(downloaded) / float64(total) * float64(barWidth))
-		if filled > barWidth {
-			filled = barWidth
+	failures := make([]error, 0, len(candidates))
+	for _, candidate := range fetch.RankMirrors(ctx, candidates, nil) {
+		err := resolveSpecArtifact(ctx, candidate, artifactPath, options)
+		if err == nil {
+			return nil
 		}
-		bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
-		fmt.Fprintf(out, "\r%-8s [%s] %5.1f%% %s/%s", label, bar, percent, humanBytes(downloaded), humanBytes(total))
-		return
-	}
-	fmt.Fprintf(out, "\r%-8s downloaded %s", label, humanBytes(downloaded))
-}
-
-func humanBytes(value int64) string {
-	if value < 1024 {
-		return fmt.Sprintf("%dB", value)
-	}
-	units := []string{"KB", "MB", "GB", "TB"}
-	size := float64(value)
-	for _, unit := range units {
-		size /= 1024
-		if size < 1024 {
-			return fmt.Sprintf("%.1f%s", size, unit)
+		failures = append(failures, fmt.Errorf("%s: %w", candidate, err))
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	return fmt.Sprintf("%.1fPB", size/1024)
+	return fmt.Errorf("download %s from %d mirrors: %w", label, len(candidates), errors.Join(failures...))
 }
 
-func verifyFileSHA256(path string, expected string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
-		return err
-	}
-
-	actual := hex.EncodeToString(hasher.Sum(nil))
-	if !strings.EqualFold(actual, expected) {
-		return fmt.Errorf("sha256 mismatch for %s: expected %s got %s", path, expected, actual)
-	}
-	return nil
+func (a *App) blobsRoot() (string, error) {
+	return a.dirs.ResolveBlobsDir()
 }
 
 func fileExistsAndNonEmpty(path string) bool {
@@ -715,53 +815,22 @@ func detectImageArch(ref string) string {
 	return "amd64"
 }
 
-func detectDiskFormatForPath(imagePath string) string {
-	if qemuImgPath, err := exec.LookPath("qemu-img"); err == nil {
-		if format, detectErr := detectDiskFormatWithQEMU(qemuImgPath, imagePath); detectErr == nil {
-			return format
+// prepareInstanceImage gives the instance a qcow2 overlay on the shared base
+// image so it starts without copying gigabytes, and falls back to a full copy
+// without qemu-img. Host provision commands get a full copy because they may
+// write the image file directly.
+func (a *App) prepareInstanceImage(basePath string, instanceImagePath string, fullCopy bool) error {
+	if !fullCopy {
+		err := diskutil.CreateOverlay(basePath, instanceImagePath)
+		if err == nil {
+			fmt.Fprintf(a.out, "instance disk: overlay on %s\n", basePath)
+			return nil
+		}
+		if !errors.Is(err, diskutil.ErrQEMUImgMissing) {
+			return err
 		}
 	}
-	if format, err := detectDiskFormatByMagic(imagePath); err == nil {
-		return format
-	}
-	return "unknown"
-}
-
-func detectDiskFormatWithQEMU(qemuBinary string, imagePath string) (string, error) {
-	command := exec.Command(qemuBinary, "info", "--output=json", imagePath)
-	output, err := command.Output()
-	if err != nil {
-		return "", err
-	}
-
-	var payload struct {
-		Format string `json:"format"`
-	}
-	if err := json.Unmarshal(output, &payload); err != nil {
-		return "", err
-	}
-	if payload.Format == "" {
-		return "", errors.New("empty format")
-	}
-	return payload.Format, nil
-}
-
-func detectDiskFormatByMagic(imagePath string) (string, error) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	header := make([]byte, 4)
-	if _, err := io.ReadFull(file, header); err != nil {
-		return "", err
-	}
-
-	if string(header) == "QFI\xfb" {
-		return "qcow2", nil
-	}
-	return "raw", nil
+	return diskutil.CopyFile(basePath, instanceImagePath)
 }
 
 func (a *App) runProvisionCommands(ctx context.Context, instanceDir string, baseImagePath string, instanceImagePath string, layerPaths []string, commands []string) error {
@@ -807,34 +876,27 @@ func isClawboxRunInput(input string) bool {
 	return trimmed == "." || strings.HasSuffix(trimmed, ".clawbox")
 }
 
-func resolveClawboxPath(input string) (string, error) {
+func (a *App) resolveClawboxPath(input string) (string, error) {
 	trimmed := strings.TrimSpace(input)
 	if trimmed == "." {
-		entries, err := os.ReadDir(".")
+		workingDir, err := os.Getwd()
 		if err != nil {
 			return "", err
 		}
-		matches := make([]string, 0, len(entries))
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			name := entry.Name()
-			if strings.HasSuffix(name, ".clawbox") {
-				matches = append(matches, name)
-			}
+		searchDir, matches, err := findClawboxFilesUpward(workingDir)
+		if err != nil {
+			return "", err
 		}
 		switch len(matches) {
 		case 0:
-			return "", errors.New("current directory does not contain a .clawbox file")
+			return "", errors.New("no .clawbox file found in the current directory or its parents (up to the repository root); pass --clawbox path")
 		case 1:
-			absolutePath, err := filepath.Abs(matches[0])
-			if err != nil {
-				return "", err
+			if searchDir != workingDir {
+				fmt.Fprintf(a.errOut, "using %s\n", matches[0])
 			}
-			return absolutePath, nil
+			return matches[0], nil
 		default:
-			return "", fmt.Errorf("current directory has multiple .clawbox files, choose one explicitly: %s", strings.Join(matches, ", "))
+			return a.pickClawboxFile(searchDir, matches)
 		}
 	}
 
@@ -852,6 +914,68 @@ func resolveClawboxPath(input string) (string, error) {
 	return absolutePath, nil
 }
 
+func findClawboxFilesUpward(startDir string) (string, []string, error) {
+	dir := startDir
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", nil, err
+		}
+		matches := make([]string, 0, len(entries))
+		isRepositoryRoot := false
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == ".git" {
+				isRepositoryRoot = true
+			}
+			if entry.IsDir() {
+				continue
+			}
+			if strings.HasSuffix(name, ".clawbox") {
+				matches = append(matches, filepath.Join(dir, name))
+			}
+		}
+		if len(matches) > 0 {
+			return dir, matches, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if isRepositoryRoot || parent == dir {
+			return dir, nil, nil
+		}
+		dir = parent
+	}
+}
+
+func (a *App) pickClawboxFile(searchDir string, matches []string) (string, error) {
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		names = append(names, filepath.Base(match))
+	}
+	if !a.canPromptForInput() {
+		return "", fmt.Errorf("%s has multiple .clawbox files, choose one explicitly with --clawbox: %s", searchDir, strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(a.out, "multiple .clawbox files in %s:\n", searchDir)
+	for index, name := range names {
+		fmt.Fprintf(a.out, "  %d) %s\n", index+1, name)
+	}
+	reader := bufio.NewReader(a.in)
+	for attempt := 1; attempt <= 3; attempt++ {
+		fmt.Fprintf(a.out, "choose clawbox [1-%d]: ", len(matches))
+		line, err := reader.ReadString('\n')
+		if err != nil && strings.TrimSpace(line) == "" {
+			return "", fmt.Errorf("read clawbox choice: %w", err)
+		}
+		choice, convErr := strconv.Atoi(strings.TrimSpace(line))
+		if convErr == nil && choice >= 1 && choice <= len(matches) {
+			return matches[choice-1], nil
+		}
+		fmt.Fprintf(a.errOut, "invalid choice %q\n", strings.TrimSpace(line))
+	}
+	return "", fmt.Errorf("no clawbox selected after 3 attempts (choose one explicitly with --clawbox: %s)", strings.Join(names, ", "))
+}
+
 func (a *App) runNew(args []string) error {
 	if len(args) == 0 {
 		return errors.New("usage: clawfarm new <image-ref> [--workspace=. --port=18789 --publish host:guest] [--run \"cmd\" --volume name:/guest/path]")
@@ -872,6 +996,19 @@ func (a *App) runNew(args []string) error {
 }
 
 func (a *App) runRun(args []string) error {
+	_, err := a.runInstance(context.Background(), args, 0)
+	return err
+}
+
+// runInstance creates and boots an instance from `clawfarm run` arguments and
+// returns its saved state. The state is returned alongside the error whenever
+// the instance got as far as being recorded, so callers can inspect or clean
+// it up. ctx bounds image preparation, hooks, the VM start and readiness.
+// replicaIndex is the 1-based replica number --replicas assigns, or 0.
+func (a *App) runInstance(ctx context.Context, args []string, replicaIndex int) (instance state.Instance, runErr error) {
+	if devcontainerPath, rest, found := takeCLIFlagValue(args, "--devcontainer"); found {
+		return a.runDevcontainer(ctx, devcontainerPath, rest)
+	}
 	args = normalizeRunArgs(args)
 
 	flags := flag.NewFlagSet("run", flag.ContinueOnError)
@@ -883,8 +1020,35 @@ func (a *App) runRun(args []string) error {
 	memoryMiB := defaultMemoryMiB
 	readyTimeoutSecs := defaultReadyTimeoutSecs
 	noWait := false
+	ciMode := false
+	backendName := vm.BackendQEMU
+	encryptDisk := false
+	diskKeyFile := ""
+	rootfsMode := vm.RootfsReadWrite
+	stateMode := vm.StateModeMount
+	shareOwnership := vm.ShareOwnershipPassthrough
+	hardened := false
+	clawboxFile := ""
+	allowHostProvision := false
+	trustClawbox := false
+	saveAnswersProfile := ""
+	waitForResources := false
+	noWorkspace := false
+	workspaceWatch := false
+	enableSSH := false
+	sshAgentKeys := false
+	guestUser := vm.GuestUser{Name: config.GuestUser(), Sudo: config.GuestSudo()}
+	if guestUser.Name == "" {
+		guestUser.Name = vm.DefaultGuestUserName
+	}
+	if guestUser.Sudo == "" {
+		guestUser.Sudo = vm.GuestSudoNoPassword
+	}
+	volumeFrom := ""
 	runName := ""
+	replicas := 1
 	openClawPackage := "openclaw@latest"
+	openClawIntegrity := ""
 	openClawConfigPath := ""
 	openClawEnvFile := ""
 	openClawAgentWorkspace := "/workspace"
@@ -906,18 +1070,54 @@ func (a *App) runRun(args []string) error {
 	openClawWhatsAppVerifyToken := ""
 	openClawWhatsAppAppSecret := ""
 	var published portList
+	var extraHosts hostList
+	var dnsServers dnsServerList
+	var waitTargets waitTargetList
+	var extraGatewayFlags gatewayList
+	requiredHostPorts := hostRequirementList{kind: "port"}
+	requiredHostCmds := hostRequirementList{kind: "cmd"}
+	requireTimeoutSecs := defaultRequireTimeoutSecs
+	var preStartHooks stringList
+	var cronJobs stringList
+	var diskSize string
+	var postReadyHooks stringList
 	var runCommands stringList
+	var sshKeyFiles stringList
+	var ignoredEnvKeys stringList
+	ignoreAllRequiredEnv := false
+	var runAs string
+	var rescueTimeout time.Duration
 	var volumes volumeList
 	var openClawEnvironment envVarList
+	var readyPath string
+	var readyStatus int
+	var readyJSON readinessJSONList
 
 	flags.StringVar(&workspace, "workspace", ".", "workspace path to mount")
+	flags.BoolVar(&noWorkspace, "no-workspace", false, "do not share any host directory; the guest gets an empty /workspace")
+	flags.BoolVar(&workspaceWatch, "workspace-watch", false, "relay host workspace changes so file watchers inside the guest fire")
 	flags.IntVar(&gatewayPort, "port", defaultGatewayPort, "host gateway port")
 	flags.IntVar(&cpus, "cpus", defaultCPUs, "vCPU count")
 	flags.IntVar(&memoryMiB, "memory-mib", defaultMemoryMiB, "memory size in MiB")
+	flags.StringVar(&diskSize, "disk-size", "", "grow the instance disk to this size, e.g. 40G (default: the image size)")
 	flags.IntVar(&readyTimeoutSecs, "ready-timeout-secs", defaultReadyTimeoutSecs, "gateway readiness timeout in seconds")
+	flags.StringVar(&readyPath, "ready-path", "", "gateway path probed for readiness, e.g. /healthz")
+	flags.IntVar(&readyStatus, "ready-status", 0, "HTTP status the readiness probe must return (default: any response)")
+	flags.Var(&readyJSON, "ready-json", "JSON field the readiness response must match (field.path=value, * for any non-empty value; repeatable)")
+	flags.StringVar(&backendName, "backend", vm.BackendQEMU, "vm backend: qemu, vzf (macOS Virtualization.framework via vfkit) or test (scriptable fake QEMU for integration tests)")
 	flags.BoolVar(&noWait, "no-wait", false, "start and return without waiting for readiness")
+	flags.BoolVar(&ciMode, "ci", false, "ephemeral CI mode: shorter timeouts, remove the instance when clawfarm exits")
+	flags.BoolVar(&waitForResources, "wait-for-resources", false, "wait for enough free host memory and disk instead of failing")
+	flags.BoolVar(&encryptDisk, "encrypt-disk", false, "encrypt the instance disk at rest (LUKS qcow2)")
+	flags.BoolVar(&hardened, "hardened", false, "enable the QEMU seccomp sandbox (runs QEMU as $CLAWFARM_QEMU_USER when set)")
+	flags.StringVar(&rootfsMode, "rootfs", vm.RootfsReadWrite, "root filesystem mode (rw|ro-overlay)")
+	flags.StringVar(&stateMode, "state-mode", vm.StateModeMount, "OpenClaw state persistence (mount|disk|none)")
+	flags.StringVar(&shareOwnership, "share-ownership", vm.ShareOwnershipPassthrough, "ownership of files the guest writes to shared folders (passthrough|mapped)")
+	flags.StringVar(&diskKeyFile, "disk-key-file", "", "host path of the disk encryption key (implies --encrypt-disk)")
 	flags.StringVar(&runName, "name", "", "instance name (used in CLAWID prefix)")
-	flags.StringVar(&openClawPackage, "openclaw-package", "openclaw@latest", "OpenClaw package spec")
+	flags.IntVar(&replicas, "replicas", 1, "launch N instances concurrently with -1..-N name suffixes and consecutive gateway ports")
+	flags.StringVar(&openClawPackage, "openclaw-package", "openclaw@latest", "OpenClaw package spec or host path to an npm .tgz")
+	flags.StringVar(&openClawIntegrity, "openclaw-integrity", "", "sha512 integrity the OpenClaw package must match (needs an exact version or a .tgz)")
 	flags.StringVar(&openClawConfigPath, "openclaw-config", "", "host path to OpenClaw JSON config")
 	flags.StringVar(&openClawEnvFile, "openclaw-env-file", "", "host path to OpenClaw .env file")
 	flags.StringVar(&openClawAgentWorkspace, "openclaw-agent-workspace", "/workspace", "OpenClaw agents.defaults.workspace")
@@ -939,51 +1139,159 @@ func (a *App) runRun(args []string) error {
 	flags.StringVar(&openClawWhatsAppVerifyToken, "openclaw-whatsapp-verify-token", "", "WhatsApp verify token (maps to WHATSAPP_VERIFY_TOKEN)")
 	flags.StringVar(&openClawWhatsAppAppSecret, "openclaw-whatsapp-app-secret", "", "WhatsApp app secret (maps to WHATSAPP_APP_SECRET)")
 	flags.Var(&openClawEnvironment, "openclaw-env", "OpenClaw env override KEY=VALUE (repeatable)")
-	flags.Var(&runCommands, "run", "run command inside guest over SSH as root (repeatable)")
+	flags.Var(&ignoredEnvKeys, "ignore-required-env", "run without this clawbox required_env key, warning instead of failing (repeatable)")
+	flags.BoolVar(&ignoreAllRequiredEnv, "ignore-all-required-env", false, "run without any missing clawbox required_env keys, warning instead of failing")
+	flags.Var(&runCommands, "run", "run command inside guest over SSH as --run-as user (repeatable)")
+	flags.BoolVar(&enableSSH, "ssh", false, "forward SSH to the guest even without --run (for clawfarm ide)")
+	flags.StringVar(&runAs, "run-as", runAsRoot, "user for --run commands: root or claw (the guest user)")
+	flags.StringVar(&guestUser.Name, "guest-user", guestUser.Name, "login user created in the guest (default $CLAWFARM_GUEST_USER or claw)")
+	flags.StringVar(&guestUser.Sudo, "guest-sudo", guestUser.Sudo, "sudo policy for the guest user: nopasswd, password or none (default $CLAWFARM_GUEST_SUDO)")
+	flags.BoolVar(&guestUser.PasswordLogin, "ssh-password-login", false, "allow SSH password login for the guest user (disabled by default)")
+	flags.Var(&sshKeyFiles, "ssh-authorized-key", "host public key file authorized for the guest user; implies --ssh (repeatable, default $CLAWFARM_SSH_AUTHORIZED_KEYS)")
+	flags.BoolVar(&sshAgentKeys, "ssh-agent-keys", false, "authorize the public keys loaded in the host ssh-agent; implies --ssh")
+	flags.DurationVar(&rescueTimeout, "rescue-timeout", 0, "close the rescue shell after this long (e.g. 15m; 0 waits forever)")
 	flags.Var(&volumes, "volume", "volume mapping name:/guest/abs/path (repeatable)")
+	flags.StringVar(&volumeFrom, "volume-from", "", "reattach volumes preserved by rm --keep-volumes from this CLAWID")
 	flags.Var(&published, "publish", "host:guest mapping (repeatable)")
 	flags.Var(&published, "port-forward", "alias of --publish (repeatable)")
+	flags.Var(&extraHosts, "add-host", "guest /etc/hosts entry name:ip (repeatable)")
+	flags.Var(&dnsServers, "dns", "guest DNS server IP (repeatable)")
+	flags.Var(&cronJobs, "cron", "scheduled guest job \"<min> <hour> <dom> <mon> <dow> <command>\" (repeatable)")
+	flags.Var(&preStartHooks, "pre-start-hook", "host command run before the VM starts (repeatable, default $CLAWFARM_PRE_START_HOOK)")
+	flags.Var(&postReadyHooks, "post-ready-hook", "host command run after readiness (repeatable, default $CLAWFARM_POST_READY_HOOK)")
+	flags.BoolVar(&allowHostProvision, "allow-host-provision", false, "allow a JSON clawbox spec with provision_target \"host\" to run its provision commands on this machine")
+	flags.BoolVar(&trustClawbox, "trust", false, "trust a clawbox that carries provision steps without asking (remembered by spec hash)")
+	flags.StringVar(&saveAnswersProfile, "save-answers", "", "load and save non-secret prompt answers under this profile name")
+	flags.StringVar(&clawboxFile, "clawbox", "", "explicit .clawbox file to run instead of a positional input")
+	flags.Var(&waitTargets, "wait-for", "extra readiness target port:<port> (checked inside the guest over ssh; forwarded host ports map to their guest port) or http(s) URL (repeatable)")
+	flags.Var(&extraGatewayFlags, "gateway", "additional gateway name=host:guest[/path], forwarded and readiness-checked like the main gateway (repeatable)")
+	flags.Var(&requiredHostPorts, "require-host-port", "host service that must accept TCP connections before boot, <port> or <host>:<port> (repeatable)")
+	flags.Var(&requiredHostCmds, "require-host-cmd", "host command that must succeed before boot (repeatable)")
+	flags.IntVar(&requireTimeoutSecs, "require-timeout-secs", defaultRequireTimeoutSecs, "how long to wait for --require-host-port/--require-host-cmd (0 checks once)")
 
 	if err := flags.Parse(args); err != nil {
-		return err
+		return instance, err
 	}
-	if flags.NArg() != 1 {
-		return errors.New("usage: clawfarm run <ref|file.clawbox|.> [--workspace=. --port=18789 --publish host:guest] [--run \"cmd\" --volume name:/guest/abs/path] [--openclaw-config path --openclaw-env-file path --openclaw-env KEY=VALUE] [--openclaw-openai-api-key ... --openclaw-discord-token ...]")
+	runInput := strings.TrimSpace(clawboxFile)
+	if runInput != "" && flags.NArg() != 0 {
+		return instance, errors.New("pass either <ref|file.clawbox|.> or --clawbox, not both")
+	}
+	if runInput == "" && flags.NArg() == 1 {
+		runInput = flags.Arg(0)
+	}
+	if runInput == "" {
+		return instance, errors.New("usage: clawfarm run <ref|file.clawbox|.> [--workspace=. --port=18789 --publish host:guest] [--run \"cmd\" --volume name:/guest/abs/path] [--openclaw-config path --openclaw-env-file path --openclaw-env KEY=VALUE] [--openclaw-openai-api-key ... --openclaw-discord-token ...]")
 	}
 	if gatewayPort < 1 || gatewayPort > 65535 {
-		return fmt.Errorf("invalid gateway port %d: expected 1-65535", gatewayPort)
+		return instance, fmt.Errorf("invalid gateway port %d: expected 1-65535", gatewayPort)
+	}
+	if replicas < 1 {
+		return instance, errors.New("replicas must be >= 1")
+	}
+	if replicas > 1 {
+		_, replicaArgs, _ := takeCLIFlagValue(args, "--replicas")
+		return instance, a.runReplicas(replicaArgs, replicas)
 	}
+	actionsReport := a.beginActionsRun()
+	defer func() {
+		a.finishActionsRun(actionsReport, runErr)
+	}()
 	if cpus < 1 {
-		return errors.New("cpus must be >= 1")
+		return instance, errors.New("cpus must be >= 1")
 	}
 	if memoryMiB < 512 {
-		return errors.New("memory-mib must be >= 512")
+		return instance, errors.New("memory-mib must be >= 512")
 	}
 	if readyTimeoutSecs < 1 {
-		return errors.New("ready-timeout-secs must be >= 1")
+		return instance, errors.New("ready-timeout-secs must be >= 1")
+	}
+	readiness, err := buildReadiness(readyPath, readyStatus, readyJSON)
+	if err != nil {
+		return instance, err
+	}
+	if requireTimeoutSecs < 0 {
+		return instance, errors.New("require-timeout-secs must be >= 0")
+	}
+	for _, job := range cronJobs.Values {
+		if _, _, err := vm.SplitCronJob(job); err != nil {
+			return instance, err
+		}
+	}
+	var diskSizeBytes int64
+	if strings.TrimSpace(diskSize) != "" {
+		diskSizeBytes, err = diskutil.ParseSize(diskSize)
+		if err != nil {
+			return instance, fmt.Errorf("invalid --disk-size: %w", err)
+		}
+	}
+	sshReadyTimeout := defaultSSHReadyTimeout
+	if ciMode {
+		if noWait {
+			return instance, errors.New("--ci removes the instance when clawfarm exits; it cannot be combined with --no-wait")
+		}
+		if !hasCLIFlag(args, "--ready-timeout-secs") {
+			readyTimeoutSecs = ciReadyTimeoutSecs
+		}
+		sshReadyTimeout = ciSSHReadyTimeout
+	}
+	if rootfsMode != vm.RootfsReadWrite && rootfsMode != vm.RootfsReadOnlyOverlay {
+		return instance, fmt.Errorf("invalid --rootfs %q: expected rw or ro-overlay", rootfsMode)
+	}
+	if stateMode != vm.StateModeMount && stateMode != vm.StateModeDisk && stateMode != vm.StateModeNone {
+		return instance, fmt.Errorf("invalid --state-mode %q: expected mount, disk, or none", stateMode)
+	}
+	if shareOwnership != vm.ShareOwnershipPassthrough && shareOwnership != vm.ShareOwnershipMapped {
+		return instance, fmt.Errorf("invalid --share-ownership %q: expected passthrough or mapped", shareOwnership)
+	}
+	if stateMode == vm.StateModeDisk && rootfsMode == vm.RootfsReadOnlyOverlay {
+		return instance, errors.New("--state-mode disk cannot persist state with --rootfs ro-overlay; use mount or none")
+	}
+	backendName = strings.ToLower(strings.TrimSpace(backendName))
+	if err := vm.ValidateBackendName(backendName); err != nil {
+		return instance, fmt.Errorf("invalid --backend: %w", err)
+	}
+	if backendName == vm.BackendVZF && (encryptDisk || hardened || rootfsMode == vm.RootfsReadOnlyOverlay) {
+		return instance, errors.New("--backend vzf cannot be combined with --encrypt-disk, --hardened, or --rootfs ro-overlay")
 	}
 	if openClawGatewayAuthMode != "" && openClawGatewayAuthMode != "token" && openClawGatewayAuthMode != "password" && openClawGatewayAuthMode != "none" {
-		return fmt.Errorf("invalid --openclaw-gateway-auth-mode %q: expected token, password, or none", openClawGatewayAuthMode)
+		return instance, fmt.Errorf("invalid --openclaw-gateway-auth-mode %q: expected token, password, or none", openClawGatewayAuthMode)
 	}
 	normalizedRunName, err := normalizeRunName(runName)
 	if err != nil {
-		return err
+		return instance, err
 	}
 	runName = normalizedRunName
-
-	workspacePath, err := filepath.Abs(workspace)
-	if err != nil {
-		return err
+	if strings.TrimSpace(diskKeyFile) != "" {
+		encryptDisk = true
+		diskKeyFile, err = filepath.Abs(diskKeyFile)
+		if err != nil {
+			return instance, err
+		}
 	}
-	if info, err := os.Stat(workspacePath); err != nil {
-		return fmt.Errorf("workspace %s: %w", workspacePath, err)
-	} else if !info.IsDir() {
-		return fmt.Errorf("workspace %s is not a directory", workspacePath)
+
+	workspacePath := ""
+	if noWorkspace {
+		if hasCLIFlag(args, "--workspace") {
+			return instance, errors.New("--no-workspace cannot be combined with --workspace")
+		}
+		if workspaceWatch {
+			return instance, errors.New("--workspace-watch requires a workspace")
+		}
+	} else {
+		workspacePath, err = filepath.Abs(workspace)
+		if err != nil {
+			return instance, err
+		}
+		if info, err := os.Stat(workspacePath); err != nil {
+			return instance, fmt.Errorf("workspace %s: %w", workspacePath, err)
+		} else if !info.IsDir() {
+			return instance, fmt.Errorf("workspace %s is not a directory", workspacePath)
+		}
 	}
 
 	rawOpenClawConfig, err := loadOpenClawConfig(openClawConfigPath)
 	if err != nil {
-		return err
+		return instance, err
 	}
 
 	openClawConfig, err := buildOpenClawConfig(rawOpenClawConfig, openClawConfigOptions{
@@ -994,12 +1302,16 @@ func (a *App) runRun(args []string) error {
 		GatewayAuthMode: openClawGatewayAuthMode,
 	})
 	if err != nil {
-		return err
+		return instance, err
 	}
 
 	openClawEnv, err := parseOpenClawEnvFile(openClawEnvFile)
 	if err != nil {
-		return err
+		return instance, err
+	}
+	envFileKeys := make([]string, 0, len(openClawEnv))
+	for key := range openClawEnv {
+		envFileKeys = append(envFileKeys, key)
 	}
 	for key, value := range openClawEnvironment.Values {
 		openClawEnv[key] = value
@@ -1028,80 +1340,304 @@ func (a *App) runRun(args []string) error {
 
 	manager, err := a.imageManager()
 	if err != nil {
-		return err
+		return instance, err
 	}
 
-	runTarget, err := a.resolveRunTarget(flags.Arg(0))
+	runTarget, err := a.resolveRunTarget(runInput, strings.TrimSpace(clawboxFile) != "")
 	if err != nil {
-		return err
+		return instance, err
 	}
 	if openClawModelPrimary == "" && runTarget.OpenClawModelPrimary != "" {
 		openClawConfig, err = setOpenClawModelPrimary(openClawConfig, runTarget.OpenClawModelPrimary)
 		if err != nil {
-			return err
+			return instance, err
 		}
 	}
 	if openClawGatewayAuthMode == "" && runTarget.OpenClawGatewayAuthMode != "" {
 		openClawConfig, err = setOpenClawGatewayAuthMode(openClawConfig, runTarget.OpenClawGatewayAuthMode)
 		if err != nil {
-			return err
+			return instance, err
 		}
 	}
 
-	ref := runTarget.ImageRef
-	preparedTarget, err := a.prepareRunTarget(context.Background(), manager, runTarget)
-	if err != nil {
-		if !runTarget.SpecJSONMode && errors.Is(err, images.ErrImageNotFetched) {
-			return fmt.Errorf("image %s is not ready, run `clawfarm image fetch %s` first", ref, ref)
+	openClawBundleSHA256 := ""
+	if runTarget.OpenClawBundle != nil && !hasCLIFlag(args, "--openclaw-package") {
+		openClawPackage = "clawbox:///" + normalizedTarPath(runTarget.OpenClawBundle.Path)
+		openClawBundleSHA256 = strings.ToLower(strings.TrimSpace(runTarget.OpenClawBundle.SHA256))
+	} else if runTarget.OpenClawPackage != "" && !hasCLIFlag(args, "--openclaw-package") {
+		openClawPackage = runTarget.OpenClawPackage
+		if !hasCLIFlag(args, "--openclaw-integrity") {
+			openClawIntegrity = runTarget.OpenClawIntegrity
 		}
-		return err
-	}
-	imageMeta := preparedTarget.ImageMeta
-	if imageMeta.Arch == "" {
-		imageMeta.Arch = detectImageArch(ref)
 	}
-
-	openClawConfig, err = a.preflightOpenClawInputs(openClawConfig, openClawEnv, runTarget.OpenClawRequiredEnv)
+	var openClawPinned openClawPin
+	clawboxOpenClawEntry := ""
+	if strings.HasPrefix(openClawPackage, "clawbox:///") {
+		clawboxOpenClawEntry = normalizedTarPath(strings.TrimPrefix(openClawPackage, "clawbox:///"))
+		if clawboxOpenClawEntry == "" || runTarget.ClawboxPath == "" {
+			return instance, fmt.Errorf("openclaw package %q must point into a .clawbox", openClawPackage)
+		}
+	} else {
+		openClawPinned, err = resolveOpenClawPin(openClawPackage, openClawIntegrity)
+		if err != nil {
+			return instance, err
+		}
+	}
+
+	if err := applyClawboxRunDefaults(runTarget.RunDefaults, args, &cpus, &memoryMiB, &published, &volumes); err != nil {
+		return instance, fmt.Errorf("%s run_defaults: %w", runTarget.Input, err)
+	}
+
+	ignoredRequiredEnv, err := a.ignoreRequiredEnv(&runTarget, openClawEnv, ignoredEnvKeys.Values, ignoreAllRequiredEnv)
 	if err != nil {
-		return err
+		return instance, err
+	}
+
+	if strings.TrimSpace(openClawEnvFile) != "" {
+		requiredKeys, err := requiredOpenClawEnvKeys(openClawConfig, runTarget.OpenClawRequiredEnv)
+		if err != nil {
+			return instance, err
+		}
+		if err := a.validateOpenClawEnvFile(openClawEnvFile, envFileKeys, openClawEnv, requiredKeys, runTarget); err != nil {
+			return instance, err
+		}
+	}
+
+	if runTarget.SpecProvisionTarget == provisionTargetHost && len(runTarget.SpecProvisionCommands) > 0 && !allowHostProvision {
+		return instance, fmt.Errorf("%s runs %d provision command(s) on this host (provision_target \"host\"); re-run with --allow-host-provision if you trust it", runTarget.Input, len(runTarget.SpecProvisionCommands))
+	}
+
+	if err := a.confirmClawboxTrust(runTarget, trustClawbox, gatewayPort, published.Mappings); err != nil {
+		return instance, err
+	}
+
+	ref := runTarget.ImageRef
+	if a.prepareLock != nil {
+		a.prepareLock.Lock()
+	}
+	preparedTarget, err := a.prepareRunTarget(ctx, manager, runTarget)
+	if a.prepareLock != nil {
+		a.prepareLock.Unlock()
+	}
+	if err != nil {
+		if !runTarget.SpecJSONMode && errors.Is(err, images.ErrImageNotFetched) {
+			return instance, fmt.Errorf("image %s is not ready, run `clawfarm image fetch %s` first", ref, ref)
+		}
+		return instance, err
+	}
+	imageMeta := preparedTarget.ImageMeta
+	if imageMeta.Arch == "" {
+		imageMeta.Arch = detectImageArch(ref)
+	}
+
+	var answerStore *state.AnswerStore
+	answerDefaults := map[string]string{}
+	if strings.TrimSpace(saveAnswersProfile) != "" {
+		answerStore, err = a.answerStore()
+		if err != nil {
+			return instance, err
+		}
+		answerDefaults, err = answerStore.Load(saveAnswersProfile)
+		if err != nil {
+			return instance, fmt.Errorf("load answers profile %s: %w", saveAnswersProfile, err)
+		}
 	}
+	openClawConfig, answers, err := a.preflightOpenClawInputs(openClawConfig, openClawEnv, runTarget.OpenClawRequiredEnv, answerDefaults)
+	if err != nil {
+		return instance, err
+	}
+	if answerStore != nil && len(answers) > 0 {
+		for key, value := range answers {
+			answerDefaults[key] = value
+		}
+		if err := answerStore.Save(saveAnswersProfile, answerDefaults); err != nil {
+			return instance, fmt.Errorf("save answers profile %s: %w", saveAnswersProfile, err)
+		}
+		fmt.Fprintf(a.out, "saved %d non-secret answer(s) to profile %s\n", len(answers), saveAnswersProfile)
+	}
+	runtimeRequirements, err := parseOpenClawRuntimeRequirements(openClawConfig)
+	if err != nil {
+		return instance, err
+	}
+	gatewayAuth := strings.ToLower(strings.TrimSpace(runtimeRequirements.GatewayAuthMode))
 
 	store, clawsRoot, err := a.instanceStore()
 	if err != nil {
-		return err
+		return instance, err
+	}
+	defer func() {
+		a.recordRunStats(runErr)
+		a.refreshSSHConfig()
+	}()
+	if err := a.checkHostResources(clawsRoot, memoryMiB, waitForResources); err != nil {
+		return instance, err
+	}
+	hostRequirements := append(append([]hostRequirement{}, requiredHostPorts.Requirements...), requiredHostCmds.Requirements...)
+	if err := a.checkHostRequirements(hostRequirements, time.Duration(requireTimeoutSecs)*time.Second); err != nil {
+		return instance, err
 	}
 	lockManager, err := a.lockManager()
 	if err != nil {
-		return err
+		return instance, err
 	}
 
-	vmPublished := make([]vm.PortMapping, 0, len(published.Mappings))
+	vmPublished := make([]vm.PortMapping, 0, len(published.Mappings)+len(extraGatewayFlags.Gateways))
 	for _, mapping := range published.Mappings {
 		vmPublished = append(vmPublished, vm.PortMapping{HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
 	}
+	gateways := []state.Gateway{{Name: state.PrimaryGatewayName, HostPort: gatewayPort, GuestPort: gatewayPort}}
+	for _, gateway := range extraGatewayFlags.Gateways {
+		for _, mapping := range vmPublished {
+			if mapping.HostPort == gateway.HostPort {
+				return instance, fmt.Errorf("--gateway %s host port %d is already used by --publish", gateway.Name, gateway.HostPort)
+			}
+		}
+		if gateway.HostPort == gatewayPort {
+			return instance, fmt.Errorf("--gateway %s host port %d is already used by the main gateway", gateway.Name, gateway.HostPort)
+		}
+		vmPublished = append(vmPublished, vm.PortMapping{HostPort: gateway.HostPort, GuestPort: gateway.GuestPort})
+		gateways = append(gateways, gateway)
+	}
 	requestedRunCommands := normalizeProvisionCommands(runCommands.Values)
-	runCommandsRequireSSH := len(requestedRunCommands) > 0
+	if err := vm.ValidateGuestUser(guestUser); err != nil {
+		return instance, err
+	}
+	if len(requestedRunCommands) > 0 && guestUser.Sudo != vm.GuestSudoNoPassword {
+		return instance, fmt.Errorf("--run needs --guest-sudo %s: clawfarm runs commands through sudo", vm.GuestSudoNoPassword)
+	}
+	if len(sshKeyFiles.Values) == 0 {
+		sshKeyFiles.Values = config.SSHAuthorizedKeyFiles()
+	}
+	guestAuthorizedKeys, err := loadGuestAuthorizedKeys(sshKeyFiles.Values, sshAgentKeys)
+	if err != nil {
+		return instance, err
+	}
+	runCommandsRequireSSH := len(requestedRunCommands) > 0 || enableSSH || len(guestAuthorizedKeys) > 0 || waitTargets.needsSSH()
+	if strings.TrimSpace(runAs) == guestUser.Name {
+		runAs = runAsClaw
+	}
+	runAs, err = normalizeRunAs(runAs)
+	if err != nil {
+		return instance, err
+	}
+	if runAs == runAsClaw {
+		runAs = guestUser.Name
+	}
+	if rescueTimeout < 0 {
+		return instance, errors.New("--rescue-timeout must be >= 0")
+	}
 	requestedVolumeMappings := append([]volumeMapping(nil), volumes.Mappings...)
+	var preservedVolumes []state.VolumeMount
+	volumeFrom = strings.TrimSpace(volumeFrom)
+	if volumeFrom != "" {
+		preservedVolumes, err = loadPreservedVolumes(clawsRoot, volumeFrom)
+		if err != nil {
+			return instance, err
+		}
+		requestedVolumeMappings, err = mergeVolumeFrom(requestedVolumeMappings, preservedVolumes, volumeFrom)
+		if err != nil {
+			return instance, err
+		}
+	}
+	vmExtraHosts := make([]vm.HostEntry, 0, len(extraHosts.Entries))
+	for _, entry := range extraHosts.Entries {
+		vmExtraHosts = append(vmExtraHosts, vm.HostEntry{Name: entry.Name, IP: entry.IP})
+	}
 
 	id := runTarget.ClawID
+	if id != "" && replicaIndex > 0 {
+		id = fmt.Sprintf("%s-r%d", id, replicaIndex)
+	}
 	if id == "" {
 		id, err = newClawID(runName)
 		if err != nil {
-			return err
+			return instance, err
+		}
+	}
+	if ciMode {
+		if _, loadErr := store.Load(id); errors.Is(loadErr, state.ErrNotFound) {
+			defer a.registerCIInstance(id)()
 		}
 	}
 	instanceDir := filepath.Join(clawsRoot, id)
-	statePath := filepath.Join(instanceDir, "state")
-	instanceImagePath := filepath.Join(instanceDir, "instance.img")
+	statePath := ""
+	if stateMode == vm.StateModeMount {
+		statePath = filepath.Join(instanceDir, "state")
+	}
 	mountSource := preparedTarget.MountSource
 	if mountSource == "" {
 		mountSource = imageMeta.RuntimeDisk
 	}
 
-	var startResult vm.StartResult
-	var instance state.Instance
-	sshHostPort := 0
-	sshPrivateKeyPath := ""
+	qemuUser := ""
+	if hardened {
+		qemuUser = config.QEMUUser()
+	}
+
+	launch := &runLaunch{
+		id:                id,
+		ref:               ref,
+		clawsRoot:         clawsRoot,
+		instanceDir:       instanceDir,
+		instanceImagePath: filepath.Join(instanceDir, "instance.img"),
+		target:            runTarget,
+		prepared:          preparedTarget,
+		imageMeta:         imageMeta,
+		spec: vm.StartSpec{
+			Backend:             backendName,
+			InstanceID:          id,
+			InstanceDir:         instanceDir,
+			ImageArch:           imageMeta.Arch,
+			RootfsMode:          rootfsMode,
+			Hardened:            hardened,
+			QEMUUser:            qemuUser,
+			ShareOwnership:      shareOwnership,
+			RootfsTarPath:       imageMeta.RootfsTar,
+			WorkspacePath:       workspacePath,
+			NoWorkspace:         noWorkspace,
+			StatePath:           statePath,
+			StateMode:           stateMode,
+			GatewayHostPort:     gatewayPort,
+			GatewayGuestPort:    gatewayPort,
+			PublishedPorts:      vmPublished,
+			DNSServers:          dnsServers.Values,
+			ExtraHosts:          vmExtraHosts,
+			CPUs:                cpus,
+			MemoryMiB:           memoryMiB,
+			OpenClawConfig:      openClawConfig,
+			OpenClawEnvironment: openClawEnv,
+			SSHAuthorizedKeys:   guestAuthorizedKeys,
+			GuestUser:           guestUser,
+			CronJobs:            cronJobs.Values,
+			DiskSizeBytes:       diskSizeBytes,
+		},
+		volumeMappings:       requestedVolumeMappings,
+		gateways:             gateways,
+		readiness:            readiness,
+		publishedPorts:       published.Mappings,
+		extraHosts:           extraHosts.Entries,
+		ignoredRequiredEnv:   ignoredRequiredEnv,
+		gatewayAuth:          gatewayAuth,
+		encryptDisk:          encryptDisk,
+		diskKeyFile:          diskKeyFile,
+		workspaceWatch:       workspaceWatch,
+		preStartHooks:        resolveHookCommands(preStartHooks.Values, config.PreStartHook()),
+		postReadyHooks:       resolveHookCommands(postReadyHooks.Values, config.PostReadyHook()),
+		openClawPin:          openClawPinned,
+		openClawIntegrity:    openClawIntegrity,
+		openClawBundleSHA256: openClawBundleSHA256,
+		clawboxOpenClawEntry: clawboxOpenClawEntry,
+		needsSSH:             runCommandsRequireSSH,
+		runCommands:          requestedRunCommands,
+		runAs:                runAs,
+		rescueTimeout:        rescueTimeout,
+		sshTimeout:           sshReadyTimeout,
+		noWait:               noWait,
+		ciMode:               ciMode,
+		readyTimeout:         time.Duration(readyTimeoutSecs) * time.Second,
+		waitTargets:          waitTargets.Targets,
+	}
+
 	err = lockManager.WithInstanceLock(id, func() error {
 		existing, loadErr := store.Load(id)
 		if loadErr != nil && !errors.Is(loadErr, state.ErrNotFound) {
@@ -1110,9 +1646,12 @@ func (a *App) runRun(args []string) error {
 		if loadErr == nil && existing.PID > 0 && a.backend.IsRunning(existing.PID) {
 			return state.ErrBusy
 		}
+		launch.spec.UncleanShutdown = loadErr == nil && existing.PID > 0
 
-		if err := ensureDir(statePath); err != nil {
-			return err
+		if statePath != "" {
+			if err := ensureDir(statePath); err != nil {
+				return err
+			}
 		}
 
 		acquireRequest := state.AcquireRequest{
@@ -1122,247 +1661,156 @@ func (a *App) runRun(args []string) error {
 		if !runTarget.SkipMount {
 			acquireRequest.SourcePath = mountSource
 		}
-		if err := lockManager.AcquireWhileLocked(context.Background(), acquireRequest); err != nil {
+		if err := lockManager.AcquireWhileLocked(ctx, acquireRequest); err != nil {
 			return err
 		}
-
-		sourceDiskPath := instanceImagePath
-		clawPath := ""
-		cloudInitProvision := []string{}
-		effectivePublished := append([]vm.PortMapping(nil), vmPublished...)
-		vmVolumeMounts := make([]vm.VolumeMount, 0, len(requestedVolumeMappings))
-		for _, volume := range requestedVolumeMappings {
-			hostVolumePath := filepath.Join(instanceDir, "volumes", volume.Name)
-			if err := ensureDir(hostVolumePath); err != nil {
+		// The lease goes back if any phase fails before the instance is
+		// saved; after that, stop and rm own it.
+		saved := false
+		defer func() {
+			if !saved {
 				_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
-				return err
 			}
-			vmVolumeMounts = append(vmVolumeMounts, vm.VolumeMount{
-				Name:      volume.Name,
-				HostPath:  hostVolumePath,
-				GuestPath: volume.GuestPath,
-			})
-		}
+		}()
 
-		sshAuthorizedKeys := []string{}
-		if runCommandsRequireSSH {
-			selectedSSHHostPort, portErr := findAvailableLoopbackPort()
-			if portErr != nil {
-				_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
-				return portErr
-			}
-			sshHostPort = selectedSSHHostPort
-			effectivePublished = append(effectivePublished, vm.PortMapping{HostPort: sshHostPort, GuestPort: 22})
-
-			generatedKeyPath, publicKey, keyErr := generateInstanceSSHKeyPair(instanceDir)
-			if keyErr != nil {
-				_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
-				return keyErr
-			}
-			sshPrivateKeyPath = generatedKeyPath
-			sshAuthorizedKeys = append(sshAuthorizedKeys, publicKey)
-		}
-
-		if runTarget.ClawboxV2Mode && runTarget.ClawboxV2Spec != nil {
-			importedRunDiskPath, importErr := importRunClawboxV2(runTarget, id, clawsRoot, imageMeta.RuntimeDisk)
-			if importErr != nil {
-				_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
-				return importErr
-			}
-			sourceDiskPath = importedRunDiskPath
-
-			clawDir := filepath.Join(clawsRoot, id, "claw")
-			if dirExists(clawDir) {
-				clawPath = clawDir
-			}
-
-			cloudInitProvision = runTarget.ClawboxV2Spec.provisionScripts()
-		} else {
-			if err := copyFile(imageMeta.RuntimeDisk, instanceImagePath); err != nil {
-				_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
+		keepPreservedVolumes := func() {}
+		if len(preservedVolumes) > 0 {
+			restoreVolumes, err := reattachPreservedVolumes(preservedVolumes, instanceDir)
+			if err != nil {
 				return err
 			}
+			// Until the booted instance is saved, any early return hands the
+			// volumes back to --volume-from.
+			defer func() {
+				if restoreVolumes == nil {
+					return
+				}
+				if err := restoreVolumes(); err != nil {
+					fmt.Fprintf(a.errOut, "warning: %v\n", err)
+				}
+			}()
+			keepPreservedVolumes = func() {
+				restoreVolumes = nil
+				if err := forgetPreservedVolumes(clawsRoot, volumeFrom); err != nil {
+					fmt.Fprintf(a.errOut, "warning: remove preserved volume manifest of %s: %v\n", volumeFrom, err)
+				}
+			}
 		}
 
-		if err := a.runProvisionCommands(context.Background(), instanceDir, imageMeta.RuntimeDisk, instanceImagePath, preparedTarget.LayerPaths, preparedTarget.ProvisionCommands); err != nil {
-			_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
+		if err := a.prepareRunGuest(launch); err != nil {
 			return err
 		}
-
-		startResult, err = a.backend.Start(context.Background(), vm.StartSpec{
-			InstanceID:          id,
-			InstanceDir:         instanceDir,
-			ImageArch:           imageMeta.Arch,
-			SourceDiskPath:      sourceDiskPath,
-			ClawPath:            clawPath,
-			WorkspacePath:       workspacePath,
-			StatePath:           statePath,
-			GatewayHostPort:     gatewayPort,
-			GatewayGuestPort:    gatewayPort,
-			PublishedPorts:      effectivePublished,
-			VolumeMounts:        vmVolumeMounts,
-			CPUs:                cpus,
-			MemoryMiB:           memoryMiB,
-			OpenClawPackage:     openClawPackage,
-			OpenClawConfig:      openClawConfig,
-			OpenClawEnvironment: openClawEnv,
-			SSHAuthorizedKeys:   sshAuthorizedKeys,
-			CloudInitProvision:  cloudInitProvision,
-		})
-		if err != nil {
-			_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
+		if err := a.prepareRunImage(launch); err != nil {
 			return err
 		}
-		if err := lockManager.AcquireWhileLocked(context.Background(), state.AcquireRequest{
-			ClawID:     id,
-			InstanceID: id,
-			PID:        startResult.PID,
-		}); err != nil {
-			stopCtx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
-			defer cancel()
-			_ = a.backend.Stop(stopCtx, startResult.PID)
-			_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
+		if err := a.provisionRunDisk(ctx, launch); err != nil {
 			return err
 		}
-
-		now := time.Now().UTC()
-		instance = state.Instance{
-			ID:             id,
-			ImageRef:       ref,
-			WorkspacePath:  workspacePath,
-			StatePath:      statePath,
-			GatewayPort:    gatewayPort,
-			PublishedPorts: published.Mappings,
-			Status:         "booting",
-			Backend:        "qemu",
-			PID:            startResult.PID,
-			DiskPath:       startResult.DiskPath,
-			SeedISOPath:    startResult.SeedISOPath,
-			SerialLogPath:  startResult.SerialLogPath,
-			QEMULogPath:    startResult.QEMULogPath,
-			MonitorPath:    startResult.MonitorPath,
-			QEMUAccel:      startResult.Accel,
-			CreatedAtUTC:   now,
-			UpdatedAtUTC:   now,
-		}
-		if noWait {
-			instance.Status = "running"
+		if err := encryptRunDisk(launch); err != nil {
+			return err
 		}
-		if err := store.Save(instance); err != nil {
-			stopCtx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
-			defer cancel()
-			_ = a.backend.Stop(stopCtx, startResult.PID)
-			_ = lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id})
+		if err := a.runPreStartPhase(ctx, launch); err != nil {
 			return err
 		}
-
-		if runCommandsRequireSSH {
-			if err := a.runCommandsViaSSH(id, sshHostPort, sshPrivateKeyPath, requestedRunCommands); err != nil {
-				instance.Status = "unhealthy"
-				instance.LastError = err.Error()
-				instance.UpdatedAtUTC = time.Now().UTC()
-				if saveErr := store.Save(instance); saveErr != nil {
-					return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
-				}
-				return err
-			}
+		if err := a.bootRunInstance(ctx, store, lockManager, launch); err != nil {
+			return err
 		}
-		return nil
+		saved = true
+		keepPreservedVolumes()
+		return a.startRunServices(store, launch)
 	})
+	instance = launch.instance
 	if err != nil {
-		return err
+		return instance, err
 	}
 
 	fmt.Fprintf(a.out, "CLAWID: %s\n", id)
-	fmt.Fprintf(a.out, "image: %s (%s)\n", ref, imageMeta.Arch)
-	fmt.Fprintf(a.out, "workspace: %s\n", workspacePath)
-	fmt.Fprintf(a.out, "state: %s\n", statePath)
-	fmt.Fprintf(a.out, "gateway: http://127.0.0.1:%d/\n", gatewayPort)
-	fmt.Fprintf(a.out, "vm pid: %d\n", startResult.PID)
-	fmt.Fprintf(a.out, "serial log: %s\n", startResult.SerialLogPath)
-	if len(instance.PublishedPorts) > 0 {
-		for _, mapping := range instance.PublishedPorts {
-			fmt.Fprintf(a.out, "publish: 127.0.0.1:%d -> %d\n", mapping.HostPort, mapping.GuestPort)
-		}
-	}
-	for _, volume := range requestedVolumeMappings {
-		hostVolumePath := filepath.Join(instanceDir, "volumes", volume.Name)
-		fmt.Fprintf(a.out, "volume: %s -> %s\n", hostVolumePath, volume.GuestPath)
-	}
+	reportedSSHPort := 0
 	if runCommandsRequireSSH {
-		fmt.Fprintf(a.out, "ssh: claw@127.0.0.1:%d\n", sshHostPort)
-	}
-
-	if noWait {
-		fmt.Fprintln(a.out, "status: running (not waiting for gateway readiness)")
-		return nil
-	}
-
-	address := fmt.Sprintf("127.0.0.1:%d", gatewayPort)
-	httpURL := fmt.Sprintf("http://%s/", address)
-	waitCtx, cancel := context.WithTimeout(context.Background(), time.Duration(readyTimeoutSecs)*time.Second)
-	defer cancel()
-	if err := vm.WaitForHTTP(waitCtx, httpURL); err != nil {
-		instance.Status = "unhealthy"
-		instance.LastError = err.Error()
-		instance.UpdatedAtUTC = time.Now().UTC()
-		if saveErr := store.Save(instance); saveErr != nil {
-			return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
-		}
-		return fmt.Errorf("gateway is not reachable yet at %s (%v); check %s", httpURL, err, instance.SerialLogPath)
-	}
-
-	instance.Status = "ready"
-	instance.LastError = ""
-	instance.UpdatedAtUTC = time.Now().UTC()
-	if err := store.Save(instance); err != nil {
-		return err
+		reportedSSHPort = launch.sshHostPort
 	}
-
-	fmt.Fprintf(a.out, "status: ready (%s)\n", httpURL)
-	return nil
+	actionsReport.setInstance(id, ref, gatewayPort, reportedSSHPort, instance.SSHUser())
+	a.printRunSummary(launch)
+	err = a.waitForRunReady(ctx, store, launch)
+	return launch.instance, err
 }
 
 func (a *App) runPS(args []string) error {
-	if len(args) != 0 {
-		return errors.New("usage: clawfarm ps")
+	wide := false
+	format := outputFormatTable
+	for index := 0; index < len(args); index++ {
+		if args[index] == "--wide" {
+			wide = true
+			continue
+		}
+		parsed, next, ok, err := parseFormatFlag(args, index)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("usage: clawfarm ps [--wide] [--format table|json]")
+		}
+		format, index = parsed, next
 	}
 	store, _, err := a.instanceStore()
 	if err != nil {
 		return err
 	}
-	instances, err := store.List()
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	instances, err := a.reconciledInstances(store)
 	if err != nil {
 		return err
 	}
-	if len(instances) == 0 {
+	if len(instances) == 0 && format == outputFormatTable {
 		fmt.Fprintln(a.out, "no instances")
 		return nil
 	}
+	if format == outputFormatJSON {
+		inspections := make([]instanceInspection, 0, len(instances))
+		for _, instance := range instances {
+			inspections = append(inspections, inspectInstance(instance, lockManager))
+		}
+		return writeJSON(a.out, inspections)
+	}
 
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	if wide {
+		fmt.Fprintln(tw, "CLAWID\tIMAGE\tSTATUS\tGATEWAY\tPID\tUPDATED(UTC)\tMODEL\tTASK\tTOKENS\tREPORTED(UTC)\tLOCK\tLAST_ERROR")
+	} else {
+		fmt.Fprintln(tw, "CLAWID\tIMAGE\tSTATUS\tGATEWAY\tPID\tUPDATED(UTC)\tLAST_ERROR")
+	}
+	for _, instance := range instances {
+		status, lastError := psStatusColumns(instance)
+		if wide {
+			guest, _ := readGuestStatus(instance)
+			lockState, _ := lockManager.Inspect(instance.ID)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", instance.ID, instance.ImageRef, status, formatGatewayColumn(instance), instance.PID, instance.UpdatedAtUTC.Format(time.RFC3339), strings.Join(guest.columns(), "\t"), lockHolderColumn(lockState), lastError)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", instance.ID, instance.ImageRef, status, formatGatewayColumn(instance), instance.PID, instance.UpdatedAtUTC.Format(time.RFC3339), lastError)
+	}
+	return tw.Flush()
+}
+
+func (a *App) reconciledInstances(store *state.Store) ([]state.Instance, error) {
+	instances, err := store.List()
+	if err != nil {
+		return nil, err
+	}
 	for index := range instances {
 		updated, changed := a.reconcileInstanceStatus(instances[index])
 		if changed {
 			updated.UpdatedAtUTC = time.Now().UTC()
 			if err := store.Save(updated); err != nil {
-				return err
+				return nil, err
 			}
 			instances[index] = updated
 		}
 	}
-
-	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
-	fmt.Fprintln(tw, "CLAWID\tIMAGE\tSTATUS\tGATEWAY\tPID\tUPDATED(UTC)\tLAST_ERROR")
-	for _, instance := range instances {
-		lastError := instance.LastError
-		if lastError == "" {
-			lastError = "-"
-		} else {
-			lastError = strings.ReplaceAll(lastError, "\n", " ")
-		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\t127.0.0.1:%d\t%d\t%s\t%s\n", instance.ID, instance.ImageRef, instance.Status, instance.GatewayPort, instance.PID, instance.UpdatedAtUTC.Format(time.RFC3339), lastError)
-	}
-	return tw.Flush()
+	return instances, nil
 }
 
 func (a *App) reconcileInstanceStatus(instance state.Instance) (state.Instance, bool) {
@@ -1374,6 +1822,7 @@ func (a *App) reconcileInstanceStatus(instance state.Instance) (state.Instance,
 	isRunning := a.backend.IsRunning(instance.PID)
 	if !isRunning && instance.Status != "exited" {
 		instance.Status = "exited"
+		instance.DirtyShutdown = true
 		changed = true
 		return instance, changed
 	}
@@ -1381,13 +1830,39 @@ func (a *App) reconcileInstanceStatus(instance state.Instance) (state.Instance,
 		return instance, false
 	}
 
-	if instance.Status == "suspended" {
+	if instance.Status == "suspended" || instance.Status == "rescue" {
 		return instance, false
 	}
 
-	url := fmt.Sprintf("http://127.0.0.1:%d/", instance.GatewayPort)
-	isHealthy, healthError := probeGatewayHealth(url, 300*time.Millisecond)
-	if isHealthy {
+	url := fmt.Sprintf("http://127.0.0.1:%d/", instance.GatewayPort())
+	health, healthError := probeGatewayHealth(url, readGatewayCredential(instance.GatewayCredentialPath), 300*time.Millisecond)
+	if health == gatewayHealthReady && instance.Readiness != nil {
+		readiness := gatewayReadiness(instance)
+		if err := vm.CheckHTTPReadiness(readiness.URL(url), readiness, 300*time.Millisecond); err != nil {
+			health, healthError = gatewayHealthDown, fmt.Sprintf("gateway readiness check failed: %v", err)
+		}
+	}
+	if health == gatewayHealthReady {
+		extraError, extraChanged := reconcileExtraGateways(&instance, 300*time.Millisecond)
+		changed = changed || extraChanged
+		if extraError != "" {
+			if instance.Status != "unhealthy" || instance.LastError != extraError {
+				instance.Status = "unhealthy"
+				instance.LastError = extraError
+				changed = true
+			}
+			return instance, changed
+		}
+		pressure, sampled := a.checkGuestDiskPressure(&instance)
+		changed = changed || sampled
+		if pressure != "" {
+			if instance.Status != instanceStatusDiskPressure || instance.LastError != pressure {
+				instance.Status = instanceStatusDiskPressure
+				instance.LastError = pressure
+				changed = true
+			}
+			return instance, changed
+		}
 		if instance.Status != "ready" || instance.LastError != "" {
 			instance.Status = "ready"
 			instance.LastError = ""
@@ -1395,15 +1870,44 @@ func (a *App) reconcileInstanceStatus(instance state.Instance) (state.Instance,
 		}
 		return instance, changed
 	}
+	if health == gatewayHealthInstalling {
+		if instance.Status != instanceStatusInstalling || instance.LastError != "" {
+			instance.Status = instanceStatusInstalling
+			instance.LastError = ""
+			changed = true
+		}
+		return instance, changed
+	}
+	if health == gatewayHealthInstallFailed {
+		message := installFailedError(instance)
+		if instance.Status != instanceStatusInstallFailed || instance.LastError != message {
+			instance.Status = instanceStatusInstallFailed
+			instance.LastError = message
+			changed = true
+		}
+		return instance, changed
+	}
+	if health == gatewayHealthUnauthorized {
+		if instance.Status != "unauthorized" || instance.LastError != healthError {
+			instance.Status = "unauthorized"
+			instance.LastError = healthError
+			changed = true
+		}
+		return instance, changed
+	}
+
+	if time.Since(instance.WokeAtUTC) < unhealthyGracePeriod {
+		return instance, changed
+	}
 
 	shouldMarkUnhealthy := false
-	if instance.Status == "ready" {
+	if instance.Status == "ready" || instance.Status == instanceStatusDiskPressure {
 		shouldMarkUnhealthy = true
 	}
-	if (instance.Status == "booting" || instance.Status == "running") && (instance.LastError != "" || time.Since(instance.CreatedAtUTC) >= unhealthyGracePeriod) {
+	if (instance.Status == "booting" || instance.Status == "running" || instance.Status == instanceStatusInstalling) && (instance.LastError != "" || time.Since(instance.BootedAt()) >= unhealthyGracePeriod) {
 		shouldMarkUnhealthy = true
 	}
-	if instance.Status == "unhealthy" {
+	if instance.Status == "unhealthy" || instance.Status == "unauthorized" {
 		shouldMarkUnhealthy = true
 	}
 
@@ -1423,18 +1927,45 @@ func (a *App) reconcileInstanceStatus(instance state.Instance) (state.Instance,
 	return instance, changed
 }
 
-func probeGatewayHealth(url string, timeout time.Duration) (bool, string) {
+const (
+	gatewayHealthDown          = "down"
+	gatewayHealthReady         = "ready"
+	gatewayHealthUnauthorized  = "unauthorized"
+	gatewayHealthInstalling    = "installing"
+	gatewayHealthInstallFailed = "install-failed"
+)
+
+func probeGatewayHealth(url string, credential string, timeout time.Duration) (string, string) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return gatewayHealthDown, err.Error()
+	}
+	if credential != "" {
+		request.Header.Set("Authorization", "Bearer "+credential)
+	}
 	client := &http.Client{Timeout: timeout}
-	response, err := client.Get(url)
+	response, err := client.Do(request)
 	if err != nil {
-		return false, err.Error()
+		return gatewayHealthDown, err.Error()
 	}
 	_ = response.Body.Close()
 
+	if vm.IsInstallFailedGateway(response.Header) {
+		return gatewayHealthInstallFailed, ""
+	}
+	if vm.IsFallbackGateway(response.Header) {
+		return gatewayHealthInstalling, ""
+	}
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		if credential == "" {
+			return gatewayHealthUnauthorized, fmt.Sprintf("gateway is up but rejected the probe with HTTP %d (no stored gateway credential)", response.StatusCode)
+		}
+		return gatewayHealthUnauthorized, fmt.Sprintf("gateway is up but rejected the stored credential with HTTP %d", response.StatusCode)
+	}
 	if response.StatusCode >= 200 && response.StatusCode < 500 {
-		return true, ""
+		return gatewayHealthReady, ""
 	}
-	return false, fmt.Sprintf("gateway returned HTTP %d", response.StatusCode)
+	return gatewayHealthDown, fmt.Sprintf("gateway returned HTTP %d", response.StatusCode)
 }
 
 func (a *App) runSuspend(args []string) error {
@@ -1470,16 +2001,17 @@ func (a *App) updateInstanceStateWithSignal(id string, status string) error {
 	}
 
 	if status == "suspended" {
-		if err := a.backend.Suspend(instance.PID); err != nil {
+		if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
 			return err
 		}
 	} else {
-		if err := a.backend.Resume(instance.PID); err != nil {
+		if err := a.backend.Resume(instanceProcess(instance)); err != nil {
 			return err
 		}
 	}
 
 	instance.Status = status
+	instance.SleepSuspended = false
 	instance.UpdatedAtUTC = time.Now().UTC()
 	if err := store.Save(instance); err != nil {
 		return err
@@ -1489,10 +2021,28 @@ func (a *App) updateInstanceStateWithSignal(id string, status string) error {
 }
 
 func (a *App) runRemove(args []string) error {
-	if len(args) != 1 {
-		return errors.New("usage: clawfarm rm <clawid>")
+	keepData := false
+	keepVolumes := false
+	positionals := make([]string, 0, len(args))
+	for _, arg := range args {
+		trimmed := strings.TrimSpace(arg)
+		switch {
+		case trimmed == "":
+			continue
+		case trimmed == "--keep-data":
+			keepData = true
+		case trimmed == "--keep-volumes":
+			keepVolumes = true
+		case strings.HasPrefix(trimmed, "--"):
+			return fmt.Errorf("unknown rm flag %q", trimmed)
+		default:
+			positionals = append(positionals, trimmed)
+		}
 	}
-	store, _, err := a.instanceStore()
+	if len(positionals) != 1 {
+		return errors.New("usage: clawfarm rm <clawid> [--keep-data] [--keep-volumes]")
+	}
+	store, clawsRoot, err := a.instanceStore()
 	if err != nil {
 		return err
 	}
@@ -1501,7 +2051,10 @@ func (a *App) runRemove(args []string) error {
 		return err
 	}
 
-	id := args[0]
+	id := positionals[0]
+	var stoppedPorts []auditPort
+	preservedPath := ""
+	preservedCount := 0
 	err = lockManager.WithInstanceLock(id, func() error {
 		instance, loadErr := store.Load(id)
 		if loadErr != nil {
@@ -1510,30 +2063,70 @@ func (a *App) runRemove(args []string) error {
 			}
 			return loadErr
 		}
+		if !keepData {
+			clones, err := a.overlayClonesOf(filepath.Join(clawsRoot, id))
+			if err != nil {
+				return err
+			}
+			if len(clones) > 0 {
+				return fmt.Errorf("checkpoints of %s back overlay clones %s; remove those first", id, strings.Join(clones, ", "))
+			}
+		}
 
 		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
 			stopCtx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
 			defer cancel()
-			if err := a.backend.Stop(stopCtx, instance.PID); err != nil {
+			if err := a.backend.Stop(stopCtx, instanceProcess(instance)); err != nil {
 				return err
 			}
+			stoppedPorts = auditPorts(instance)
 		}
+		stopWorkspaceWatcher(filepath.Join(clawsRoot, id), instance)
 		if err := lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: instance.ID}); err != nil {
 			return err
 		}
 
+		if keepVolumes {
+			var preserveErr error
+			preservedPath, preservedCount, preserveErr = preserveInstanceVolumes(clawsRoot, instance)
+			if preserveErr != nil {
+				return fmt.Errorf("preserve volumes: %w", preserveErr)
+			}
+		}
+		if keepData {
+			return store.Forget(id)
+		}
 		if err := store.Delete(id); err != nil {
 			if errors.Is(err, state.ErrNotFound) {
 				return fmt.Errorf("instance %s not found", id)
 			}
 			return err
 		}
+		if isManagedDiskKey(clawsRoot, instance.DiskKeyPath) {
+			if err := os.Remove(instance.DiskKeyPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
+	a.refreshSSHConfig()
+
+	for _, port := range stoppedPorts {
+		if vm.IsTCPReachable(fmt.Sprintf("127.0.0.1:%d", port.HostPort), 500*time.Millisecond) {
+			fmt.Fprintf(a.errOut, "warning: 127.0.0.1:%d (%s) is still accepting connections after removing %s\n", port.HostPort, port.Role, id)
+		}
+	}
 
+	if preservedCount > 0 {
+		fmt.Fprintf(a.out, "preserved %d volume(s) in %s (reattach with run --volume-from %s)\n", preservedCount, preservedPath, id)
+	}
+	if keepData {
+		fmt.Fprintf(a.out, "removed %s (data kept in %s)\n", id, filepath.Join(clawsRoot, id))
+		return nil
+	}
 	fmt.Fprintf(a.out, "removed %s\n", id)
 	return nil
 }
@@ -1541,6 +2134,7 @@ func (a *App) runRemove(args []string) error {
 func (a *App) runExport(args []string) error {
 	allowSecrets := false
 	exportName := ""
+	compression := clawboxCompressionGzip
 	positionals := make([]string, 0, len(args))
 	for index := 0; index < len(args); index++ {
 		trimmed := strings.TrimSpace(args[index])
@@ -1557,6 +2151,22 @@ func (a *App) runExport(args []string) error {
 			exportName = strings.TrimSpace(args[index])
 		case strings.HasPrefix(trimmed, "--name="):
 			exportName = strings.TrimSpace(strings.TrimPrefix(trimmed, "--name="))
+		case trimmed == "--compression":
+			if index+1 >= len(args) {
+				return errors.New("missing value for --compression")
+			}
+			index++
+			parsed, err := parseClawboxCompression(args[index])
+			if err != nil {
+				return err
+			}
+			compression = parsed
+		case strings.HasPrefix(trimmed, "--compression="):
+			parsed, err := parseClawboxCompression(strings.TrimPrefix(trimmed, "--compression="))
+			if err != nil {
+				return err
+			}
+			compression = parsed
 		case strings.HasPrefix(trimmed, "--"):
 			return fmt.Errorf("unknown export flag %q", trimmed)
 		default:
@@ -1564,7 +2174,7 @@ func (a *App) runExport(args []string) error {
 		}
 	}
 	if len(positionals) != 2 {
-		return errors.New("usage: clawfarm export <clawid> <output.clawbox> [--allow-secrets] [--name <name>]")
+		return errors.New("usage: clawfarm export <clawid> <output.clawbox> [--allow-secrets] [--name <name>] [--compression gzip|zstd]")
 	}
 	id := positionals[0]
 	outputPath := positionals[1]
@@ -1579,7 +2189,7 @@ func (a *App) runExport(args []string) error {
 		return err
 	}
 
-	store, _, err := a.instanceStore()
+	store, clawsRoot, err := a.instanceStore()
 	if err != nil {
 		return err
 	}
@@ -1589,12 +2199,17 @@ func (a *App) runExport(args []string) error {
 	}
 
 	err = lockManager.WithInstanceLock(id, func() error {
-		if _, loadErr := store.Load(id); loadErr != nil {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
 			if errors.Is(loadErr, state.ErrNotFound) {
 				return fmt.Errorf("instance %s not found", id)
 			}
 			return loadErr
 		}
+		instanceDir := filepath.Join(clawsRoot, id)
+		if fileExistsAndNonEmpty(filepath.Join(instanceDir, clawboxSpecV2Path)) {
+			return a.exportClawboxV2(instance, instanceDir, absOutputPath, exportName, allowSecrets, compression)
+		}
 
 		lockState, inspectErr := lockManager.Inspect(id)
 		if inspectErr != nil {
@@ -1628,7 +2243,7 @@ func (a *App) runExport(args []string) error {
 		}
 
 		if strings.TrimSpace(exportName) == "" {
-			return copyFile(absSourcePath, absOutputPath)
+			return diskutil.CopyFile(absSourcePath, absOutputPath)
 		}
 		if _, computeErr := clawbox.ComputeClawID(absSourcePath, exportName); computeErr != nil {
 			return fmt.Errorf("invalid --name %q: %w", exportName, computeErr)
@@ -1651,34 +2266,66 @@ func (a *App) runExport(args []string) error {
 }
 
 func (a *App) runCheckpoint(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "ls":
+			return a.runCheckpointList(args[1:])
+		case "rm":
+			return a.runCheckpointRemove(args[1:])
+		}
+	}
 	args = normalizeRunArgs(args)
 
 	flags := flag.NewFlagSet("checkpoint", flag.ContinueOnError)
 	flags.SetOutput(a.errOut)
 
 	checkpointName := ""
+	withMemory := false
+	keep := 0
 	flags.StringVar(&checkpointName, "name", "", "checkpoint name")
+	flags.BoolVar(&withMemory, "with-memory", false, "also save the running VM's memory")
+	flags.IntVar(&keep, "keep", 0, "after checkpointing, delete all but the newest N checkpoints")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
 	if flags.NArg() != 1 {
-		return errors.New("usage: clawfarm checkpoint <clawid> --name <name>")
+		return errors.New("usage: clawfarm checkpoint <clawid> --name <name> [--with-memory] [--keep N]")
+	}
+	if keep < 0 {
+		return errors.New("keep must be >= 0")
 	}
 	id := strings.TrimSpace(flags.Arg(0))
-	checkpointName = strings.TrimSpace(checkpointName)
-	if err := validateCheckpointName(checkpointName); err != nil {
+	checkpointPath, err := a.checkpointInstance(id, strings.TrimSpace(checkpointName), withMemory)
+	if err != nil {
 		return err
 	}
 
+	if withMemory {
+		fmt.Fprintf(a.out, "checkpointed %s -> %s (with memory)\n", id, checkpointPath)
+	} else {
+		fmt.Fprintf(a.out, "checkpointed %s -> %s\n", id, checkpointPath)
+	}
+	if keep > 0 {
+		return a.pruneCheckpoints(id, keep)
+	}
+	return nil
+}
+
+func (a *App) checkpointInstance(id string, checkpointName string, withMemory bool) (string, error) {
+	if err := validateCheckpointName(checkpointName); err != nil {
+		return "", err
+	}
+
 	store, clawsRoot, err := a.instanceStore()
 	if err != nil {
-		return err
+		return "", err
 	}
 	lockManager, err := a.lockManager()
 	if err != nil {
-		return err
+		return "", err
 	}
 	checkpointPath := checkpointPathForName(clawsRoot, id, checkpointName)
+	memoryPath := checkpointMemoryPath(checkpointPath)
 
 	err = lockManager.WithInstanceLock(id, func() error {
 		instance, loadErr := store.Load(id)
@@ -1691,18 +2338,33 @@ func (a *App) runCheckpoint(args []string) error {
 		if strings.TrimSpace(instance.DiskPath) == "" {
 			return fmt.Errorf("instance %s has no disk path", id)
 		}
+		if clones, err := a.overlayClonesOf(checkpointPath); err != nil {
+			return err
+		} else if len(clones) > 0 {
+			return fmt.Errorf("checkpoint %s backs overlay clones %s; pick another name", checkpointName, strings.Join(clones, ", "))
+		}
+
+		running := instance.PID > 0 && a.backend.IsRunning(instance.PID)
+		if withMemory {
+			if !running {
+				return fmt.Errorf("instance %s is not running; --with-memory needs a live VM", id)
+			}
+			if instance.Backend == vm.BackendVZF {
+				return errors.New("--backend vzf does not support memory snapshots")
+			}
+		}
 
 		suspended := false
-		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
-			if err := a.backend.Suspend(instance.PID); err != nil {
+		if running {
+			if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
 				return err
 			}
 			suspended = true
 		}
 
-		if err := copyFile(instance.DiskPath, checkpointPath); err != nil {
+		if err := a.writeCheckpoint(instance, checkpointPath, memoryPath, withMemory); err != nil {
 			if suspended {
-				if resumeErr := a.backend.Resume(instance.PID); resumeErr != nil {
+				if resumeErr := a.backend.Resume(instanceProcess(instance)); resumeErr != nil {
 					return fmt.Errorf("%w (and failed to resume VM: %v)", err, resumeErr)
 				}
 			}
@@ -1710,37 +2372,129 @@ func (a *App) runCheckpoint(args []string) error {
 		}
 
 		if suspended {
-			if err := a.backend.Resume(instance.PID); err != nil {
+			if err := a.backend.Resume(instanceProcess(instance)); err != nil {
 				return err
 			}
 		}
-		return nil
+		return store.ShareFiles(id)
 	})
+	if err != nil {
+		return "", err
+	}
+	return checkpointPath, nil
+}
+
+// writeCheckpoint copies the disk of a suspended or stopped instance and,
+// with withMemory, first migrates its RAM into memoryPath. A disk-only
+// checkpoint drops memory saved under the same name, which would no longer
+// match the disk.
+func (a *App) writeCheckpoint(instance state.Instance, checkpointPath string, memoryPath string, withMemory bool) error {
+	if withMemory {
+		if err := os.MkdirAll(filepath.Dir(memoryPath), 0o755); err != nil {
+			return err
+		}
+		if err := vm.SaveMemoryState(context.Background(), instance.MonitorPath, memoryPath); err != nil {
+			return err
+		}
+	} else if err := os.Remove(memoryPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return diskutil.CopyFile(instance.DiskPath, checkpointPath)
+}
+
+func (a *App) runRestore(args []string) error {
+	flagArgs := []string{}
+	positional := []string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			flagArgs = append(flagArgs, arg)
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.SetOutput(a.errOut)
+
+	withMemory := false
+	flags.BoolVar(&withMemory, "with-memory", false, "resume the VM from the checkpoint's saved memory")
+	if err := flags.Parse(flagArgs); err != nil {
+		return err
+	}
+	if len(positional) != 2 || flags.NArg() != 0 {
+		return errors.New("usage: clawfarm restore <clawid> <checkpoint> [--with-memory]")
+	}
+	id := strings.TrimSpace(positional[0])
+	checkpointName := strings.TrimSpace(positional[1])
+	if withMemory {
+		instance, checkpointPath, err := a.restoreInstanceWithMemory(id, checkpointName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "restored %s from %s (with memory)\n", id, checkpointPath)
+		fmt.Fprintf(a.out, "vm pid: %d\n", instance.PID)
+		return nil
+	}
+	checkpointPath, err := a.restoreInstance(id, checkpointName)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(a.out, "checkpointed %s -> %s\n", id, checkpointPath)
+	fmt.Fprintf(a.out, "restored %s from %s\n", id, checkpointPath)
 	return nil
 }
 
-func (a *App) runRestore(args []string) error {
-	if len(args) != 2 {
-		return errors.New("usage: clawfarm restore <clawid> <checkpoint>")
+// restoreInstanceWithMemory replaces the instance disk with the checkpoint
+// and boots the VM from the checkpoint's saved memory, so the guest carries on
+// from the moment the checkpoint was taken. A running VM is stopped first.
+func (a *App) restoreInstanceWithMemory(id string, checkpointName string) (state.Instance, string, error) {
+	if err := validateCheckpointName(checkpointName); err != nil {
+		return state.Instance{}, "", err
+	}
+	_, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return state.Instance{}, "", err
+	}
+	memoryPath := checkpointMemoryPath(checkpointPathForName(clawsRoot, id, checkpointName))
+	if _, err := os.Stat(memoryPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state.Instance{}, "", fmt.Errorf("checkpoint %s of %s has no saved memory; create it with clawfarm checkpoint %s --name %s --with-memory", checkpointName, id, id, checkpointName)
+		}
+		return state.Instance{}, "", err
+	}
+
+	running, err := a.instanceRunning(id)
+	if err != nil {
+		return state.Instance{}, "", err
 	}
-	id := strings.TrimSpace(args[0])
-	checkpointName := strings.TrimSpace(args[1])
+	if running {
+		if _, err := a.stopInstance(id, 0, true); err != nil {
+			return state.Instance{}, "", err
+		}
+	}
+	checkpointPath, err := a.restoreInstance(id, checkpointName)
+	if err != nil {
+		return state.Instance{}, "", err
+	}
+	instance, err := a.startInstanceFrom(id, true, memoryPath)
+	if err != nil {
+		return state.Instance{}, "", err
+	}
+	return instance, checkpointPath, nil
+}
+
+func (a *App) restoreInstance(id string, checkpointName string) (string, error) {
 	if err := validateCheckpointName(checkpointName); err != nil {
-		return err
+		return "", err
 	}
 
 	store, clawsRoot, err := a.instanceStore()
 	if err != nil {
-		return err
+		return "", err
 	}
 	lockManager, err := a.lockManager()
 	if err != nil {
-		return err
+		return "", err
 	}
 	checkpointPath := checkpointPathForName(clawsRoot, id, checkpointName)
 
@@ -1764,15 +2518,15 @@ func (a *App) runRestore(args []string) error {
 
 		suspended := false
 		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
-			if err := a.backend.Suspend(instance.PID); err != nil {
+			if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
 				return err
 			}
 			suspended = true
 		}
 
-		if err := copyFile(checkpointPath, instance.DiskPath); err != nil {
+		if err := diskutil.CopyFile(checkpointPath, instance.DiskPath); err != nil {
 			if suspended {
-				if resumeErr := a.backend.Resume(instance.PID); resumeErr != nil {
+				if resumeErr := a.backend.Resume(instanceProcess(instance)); resumeErr != nil {
 					return fmt.Errorf("%w (and failed to resume VM: %v)", err, resumeErr)
 				}
 			}
@@ -1780,7 +2534,7 @@ func (a *App) runRestore(args []string) error {
 		}
 
 		if suspended {
-			if err := a.backend.Resume(instance.PID); err != nil {
+			if err := a.backend.Resume(instanceProcess(instance)); err != nil {
 				return err
 			}
 		}
@@ -1789,11 +2543,9 @@ func (a *App) runRestore(args []string) error {
 		return store.Save(instance)
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	fmt.Fprintf(a.out, "restored %s from %s\n", id, checkpointPath)
-	return nil
+	return checkpointPath, nil
 }
 
 func scanPotentialSecretsFromFile(path string) ([]string, error) {
@@ -1841,39 +2593,93 @@ func checkpointPathForName(instancesRoot string, id string, checkpointName strin
 	return filepath.Join(instancesRoot, id, "checkpoints", fileName)
 }
 
+// checkpointMemoryPath is where a --with-memory checkpoint keeps the VM state
+// saved next to its disk.
+func checkpointMemoryPath(checkpointPath string) string {
+	return strings.TrimSuffix(checkpointPath, filepath.Ext(checkpointPath)) + ".vmstate"
+}
+
+func listCheckpoints(instancesRoot string, id string) ([]checkpointState, error) {
+	entries, err := os.ReadDir(filepath.Join(instancesRoot, id, "checkpoints"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	checkpoints := []checkpointState{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".qcow2") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		checkpoint := checkpointState{
+			Name:         strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			Path:         filepath.Join(instancesRoot, id, "checkpoints", entry.Name()),
+			SizeBytes:    info.Size(),
+			CreatedAtUTC: info.ModTime().UTC(),
+		}
+		if memoryInfo, err := os.Stat(checkpointMemoryPath(checkpoint.Path)); err == nil {
+			checkpoint.WithMemory = true
+			checkpoint.SizeBytes += memoryInfo.Size()
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].CreatedAtUTC.Before(checkpoints[j].CreatedAtUTC)
+	})
+	return checkpoints, nil
+}
+
 func (a *App) imageManager() (*images.Manager, error) {
-	cacheDir, err := config.CacheDir()
+	cacheDir, err := a.dirs.ResolveCacheDir()
 	if err != nil {
 		return nil, err
 	}
 	if err := ensureDir(cacheDir); err != nil {
 		return nil, err
 	}
-	return images.NewManager(cacheDir, a.out), nil
+	return images.NewManager(cacheDir, a.out).WithDependents(a.imageDependents), nil
 }
 
 func (a *App) instanceStore() (*state.Store, string, error) {
-	dataDir, err := config.DataDir()
+	clawsRoot, err := a.clawsRoot()
 	if err != nil {
 		return nil, "", err
 	}
-	clawsRoot := filepath.Join(dataDir, "claws")
-	if err := ensureDir(clawsRoot); err != nil {
-		return nil, "", err
-	}
-	return state.NewStore(clawsRoot), clawsRoot, nil
+	return state.NewStore(clawsRoot).WithShared(config.SharedDataDir()), clawsRoot, nil
 }
 
 func (a *App) lockManager() (*state.LockManager, error) {
-	dataDir, err := config.DataDir()
+	clawsRoot, err := a.clawsRoot()
 	if err != nil {
 		return nil, err
 	}
+	return state.NewLockManager(clawsRoot, nil).WithShared(config.SharedDataDir()), nil
+}
+
+func (a *App) clawsRoot() (string, error) {
+	dataDir, err := a.dirs.ResolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	shared := config.SharedDataDir()
+	if _, err := state.ClaimDataDir(dataDir, shared); err != nil {
+		return "", err
+	}
 	clawsRoot := filepath.Join(dataDir, "claws")
 	if err := ensureDir(clawsRoot); err != nil {
-		return nil, err
+		return "", err
+	}
+	if shared {
+		if err := state.MakeShared(clawsRoot); err != nil {
+			return "", err
+		}
 	}
-	return state.NewLockManager(clawsRoot, nil), nil
+	return clawsRoot, nil
 }
 
 func ensureDir(path string) error {
@@ -1913,11 +2719,16 @@ func (a *App) printUsage() {
 	fmt.Fprintln(a.out, "clawfarm - run full OpenClaw inside a lightweight VM")
 	fmt.Fprintln(a.out, "")
 	fmt.Fprintln(a.out, "Usage:")
-	fmt.Fprintln(a.out, "  clawfarm image ls")
+	fmt.Fprintln(a.out, "  clawfarm [--data-dir path --cache-dir path --context name --download-limit 20MB/s] <command> ...")
+	fmt.Fprintln(a.out, "  clawfarm image ls [--format table|json]")
 	fmt.Fprintln(a.out, "  clawfarm image fetch <ref>")
+	fmt.Fprintln(a.out, "  clawfarm image import-oci <docker://image[:tag]> [--tag name:tag] [--base ubuntu:24.04]")
 	fmt.Fprintln(a.out, "  clawfarm new <image-ref> [--workspace=. --port=18789 --publish host:guest]")
-	fmt.Fprintln(a.out, "              [--run \"cmd\" --run \"cmd\" --volume name:/guest/abs/path]")
-	fmt.Fprintln(a.out, "  clawfarm run <ref|file.clawbox|.> [--workspace=. --port=18789 --publish host:guest]")
+	fmt.Fprintln(a.out, "              [--run \"cmd\" --run \"cmd\" --volume name:/guest/abs/path --volume-from <old-clawid>]")
+	fmt.Fprintln(a.out, "  clawfarm run <ref|file.clawbox|.> [--workspace=. | --no-workspace] [--workspace-watch] [--port=18789 --publish host:guest]")
+	fmt.Fprintln(a.out, "             [--name web --replicas 3] [--ssh] [--ci] [--gateway ui=18790:3000/health]")
+	fmt.Fprintln(a.out, "             [--ready-path /healthz --ready-status 200 --ready-json status=ok --ready-json model.loaded=true]")
+	fmt.Fprintln(a.out, "  clawfarm run --devcontainer .devcontainer/devcontainer.json [run flags]")
 	fmt.Fprintln(a.out, "             [--openclaw-config path --openclaw-agent-workspace /workspace --openclaw-model-primary openai/gpt-5]")
 	fmt.Fprintln(a.out, "             [--openclaw-gateway-mode local --openclaw-gateway-auth-mode token --openclaw-gateway-token xxx]")
 	fmt.Fprintln(a.out, "             [--openclaw-openai-api-key xxx --openclaw-anthropic-api-key xxx --openclaw-openrouter-api-key xxx]")
@@ -1926,19 +2737,64 @@ func (a *App) printUsage() {
 	fmt.Fprintln(a.out, "             [--openclaw-whatsapp-phone-number-id xxx --openclaw-whatsapp-access-token xxx]")
 	fmt.Fprintln(a.out, "             [--openclaw-whatsapp-verify-token xxx --openclaw-whatsapp-app-secret xxx]")
 	fmt.Fprintln(a.out, "             [--openclaw-env-file path --openclaw-env KEY=VALUE]")
-	fmt.Fprintln(a.out, "  clawfarm ps")
+	fmt.Fprintln(a.out, "             [--ignore-required-env KEY --ignore-all-required-env]")
+	fmt.Fprintln(a.out, "             [--run \"cmd\" --run-as root|claw --rescue-timeout 15m]")
+	fmt.Fprintln(a.out, "             [--guest-user dev --guest-sudo nopasswd|password|none --ssh-password-login]")
+	fmt.Fprintln(a.out, "             [--ssh-authorized-key ~/.ssh/id_ed25519.pub --ssh-agent-keys]")
+	fmt.Fprintln(a.out, "             [--add-host name:ip --dns 10.0.0.2] [--cron \"0 * * * * openclaw run ...\"]")
+	fmt.Fprintln(a.out, "             [--pre-start-hook \"cmd\" --post-ready-hook \"cmd\"]")
+	fmt.Fprintln(a.out, "             [--wait-for port:8080 --wait-for http://127.0.0.1:8080/health] [--wait-for-resources]")
+	fmt.Fprintln(a.out, "             [--require-host-port 5432 --require-host-cmd \"ollama list\" --require-timeout-secs 60]")
+	fmt.Fprintln(a.out, "             [--disk-size 40G --encrypt-disk --disk-key-file path --rootfs ro-overlay --hardened]")
+	fmt.Fprintln(a.out, "             [--state-mode mount|disk|none] [--share-ownership passthrough|mapped] [--backend qemu|vzf|test]")
+	fmt.Fprintln(a.out, "             [--trust --allow-host-provision] [--save-answers profile]")
+	fmt.Fprintln(a.out, "  clawfarm run --clawbox path/to/file.clawbox [run flags]")
+	fmt.Fprintln(a.out, "  clawfarm ps [--wide] [--format table|json]")
+	fmt.Fprintln(a.out, "  clawfarm inspect <clawid>")
 	fmt.Fprintln(a.out, "  clawfarm suspend <clawid>")
 	fmt.Fprintln(a.out, "  clawfarm resume <clawid>")
-	fmt.Fprintln(a.out, "  clawfarm rm <clawid>")
-	fmt.Fprintln(a.out, "  clawfarm export <clawid> <output.clawbox> [--allow-secrets] [--name <name>]")
-	fmt.Fprintln(a.out, "  clawfarm checkpoint <clawid> --name <name>")
-	fmt.Fprintln(a.out, "  clawfarm restore <clawid> <checkpoint>")
+	fmt.Fprintln(a.out, "  clawfarm host watch [--interval 5s] | host sleep | host wake   (recover instances after host sleep)")
+	fmt.Fprintln(a.out, "  clawfarm stop <clawid> [--timeout 60s] [--force]")
+	fmt.Fprintln(a.out, "  clawfarm start <clawid> [--no-wait] [--ready-timeout-secs N]")
+	fmt.Fprintln(a.out, "  clawfarm restart <clawid> [--timeout 60s] [--force] [--no-wait] [--ready-timeout-secs N]")
+	fmt.Fprintln(a.out, "  clawfarm rm <clawid> [--keep-data] [--keep-volumes]")
+	fmt.Fprintln(a.out, "  clawfarm clone <clawid> [--checkpoint name [--overlay]] [--name prefix] [--port N] [--no-start] [--no-wait]")
+	fmt.Fprintln(a.out, "  clawfarm resize <clawid> --disk-size 40G")
+	fmt.Fprintln(a.out, "  clawfarm update <clawid> [--cpus N] [--memory-mib M]")
+	fmt.Fprintln(a.out, "  clawfarm unlock <clawid> --force")
+	fmt.Fprintln(a.out, "  clawfarm ide <clawid> [--editor vscode|cursor|jetbrains] [--print]")
+	fmt.Fprintln(a.out, "  clawfarm ssh [-t] <clawid> [command...]")
+	fmt.Fprintln(a.out, "  clawfarm exec [--user root|claw] [--workdir dir] [--env KEY=VALUE] <clawid> -- <command...>")
+	fmt.Fprintln(a.out, "  clawfarm ssh-config [--install]")
+	fmt.Fprintln(a.out, "  clawfarm mcp serve [--allow-image ubuntu:24.04 --max-instances 4] [run flags]")
+	fmt.Fprintln(a.out, "  clawfarm cp [--stdin] <src> <dst>   (one side is <clawid>:/guest/abs/path)")
+	fmt.Fprintln(a.out, "  clawfarm doctor")
+	fmt.Fprintln(a.out, "  clawfarm logs <clawid> [--source serial|qemu|bootstrap|gateway|install] [--follow] [--since 10m] [--tail 100]")
+	fmt.Fprintln(a.out, "  clawfarm audit <clawid>")
+	fmt.Fprintln(a.out, "  clawfarm drift [--clawid <clawid>]")
+	fmt.Fprintln(a.out, "  clawfarm env template <file.clawbox|.>")
+	fmt.Fprintln(a.out, "  clawfarm cron ls|add|rm <clawid> [\"<schedule> <command>\"|<number>]")
+	fmt.Fprintln(a.out, "  clawfarm channels verify <clawid> [--public-url https://...] [--env-file path]")
+	fmt.Fprintln(a.out, "  clawfarm secret ls|rm <KEY>")
+	fmt.Fprintln(a.out, "  clawfarm export <clawid> <output.clawbox> [--allow-secrets] [--name <name>] [--compression gzip|zstd]")
+	fmt.Fprintln(a.out, "  clawfarm commit <clawid> <name:tag>")
+	fmt.Fprintln(a.out, "  clawfarm checkpoint <clawid> --name <name> [--with-memory] [--keep N]")
+	fmt.Fprintln(a.out, "  clawfarm checkpoint ls <clawid> | checkpoint rm <clawid> --name <name>")
+	fmt.Fprintln(a.out, "  clawfarm restore <clawid> <checkpoint> [--with-memory]")
+	fmt.Fprintln(a.out, "  clawfarm system df|dirs")
+	fmt.Fprintln(a.out, "  clawfarm blob ls|prune|pin <digest>|unpin <digest>")
+	fmt.Fprintln(a.out, "  clawfarm stats --host [--reset]")
+	fmt.Fprintln(a.out, "  clawfarm bench boot <ref> [--iterations 5 --variant name=\"--cpus 4\" --ssh --keep] [run flags]")
+	fmt.Fprintln(a.out, "  clawfarm version [--check]")
+	fmt.Fprintln(a.out, "  clawfarm self-update [--force]")
 	fmt.Fprintln(a.out, "")
 	fmt.Fprintln(a.out, "Examples:")
 	fmt.Fprintln(a.out, "  clawfarm image fetch ubuntu:24.04")
 	fmt.Fprintln(a.out, "  clawfarm new ubuntu:24.04 --run \"echo hello\" --volume .openclaw:/root/.openclaw")
 	fmt.Fprintln(a.out, "  clawfarm run ubuntu:24.04 --workspace=. --publish 8080:80")
 	fmt.Fprintln(a.out, "  clawfarm run ubuntu:24.04 --openclaw-openai-api-key $OPENAI_API_KEY --openclaw-discord-token $DISCORD_TOKEN")
+	fmt.Fprintln(a.out, "  pbpaste | clawfarm cp --stdin claw-1234:/workspace/notes.txt")
+	fmt.Fprintln(a.out, "  clawfarm commit claw-1234 mydev:v1 && clawfarm run mydev:v1")
 	fmt.Fprintln(a.out, "  clawfarm checkpoint claw-1234 --name before-upgrade")
 	fmt.Fprintln(a.out, "  clawfarm restore claw-1234 before-upgrade")
 }
@@ -2050,6 +2906,121 @@ func parsePortMapping(input string) (state.PortMapping, error) {
 	return state.PortMapping{HostPort: host, GuestPort: guest}, nil
 }
 
+type hostList struct {
+	Values  []string
+	Entries []state.HostEntry
+}
+
+func (l *hostList) String() string {
+	return strings.Join(l.Values, ",")
+}
+
+func (l *hostList) Set(value string) error {
+	entry, err := parseHostEntry(value)
+	if err != nil {
+		return err
+	}
+	l.Values = append(l.Values, value)
+	l.Entries = append(l.Entries, entry)
+	return nil
+}
+
+func parseHostEntry(input string) (state.HostEntry, error) {
+	parts := strings.SplitN(strings.TrimSpace(input), ":", 2)
+	if len(parts) != 2 {
+		return state.HostEntry{}, fmt.Errorf("invalid add-host value %q: expected name:ip", input)
+	}
+
+	name := strings.TrimSpace(parts[0])
+	if !hostNamePattern.MatchString(name) {
+		return state.HostEntry{}, fmt.Errorf("invalid add-host name %q", name)
+	}
+	ip := strings.TrimSpace(parts[1])
+	if net.ParseIP(ip) == nil {
+		return state.HostEntry{}, fmt.Errorf("invalid add-host ip %q", ip)
+	}
+	return state.HostEntry{Name: name, IP: ip}, nil
+}
+
+type dnsServerList struct {
+	Values []string
+}
+
+func (l *dnsServerList) String() string {
+	return strings.Join(l.Values, ",")
+}
+
+func (l *dnsServerList) Set(value string) error {
+	trimmed := strings.TrimSpace(value)
+	if net.ParseIP(trimmed) == nil {
+		return fmt.Errorf("invalid dns server %q: expected IP address", value)
+	}
+	l.Values = append(l.Values, trimmed)
+	return nil
+}
+
+type waitTarget struct {
+	Label string
+	Port  int
+	URL   string
+}
+
+type waitTargetList struct {
+	Values  []string
+	Targets []waitTarget
+}
+
+func (l *waitTargetList) String() string {
+	return strings.Join(l.Values, ",")
+}
+
+// needsSSH reports whether any target is a port, which is probed from inside
+// the guest over ssh.
+func (l *waitTargetList) needsSSH() bool {
+	for _, target := range l.Targets {
+		if target.Port > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *waitTargetList) Set(value string) error {
+	target, err := parseWaitTarget(value)
+	if err != nil {
+		return err
+	}
+	l.Values = append(l.Values, value)
+	l.Targets = append(l.Targets, target)
+	return nil
+}
+
+func parseWaitTarget(input string) (waitTarget, error) {
+	trimmed := strings.TrimSpace(input)
+	if strings.HasPrefix(trimmed, "port:") {
+		port, err := strconv.Atoi(strings.TrimPrefix(trimmed, "port:"))
+		if err != nil || port < 1 || port > 65535 {
+			return waitTarget{}, fmt.Errorf("invalid wait-for value %q: expected port:<1-65535>", input)
+		}
+		return waitTarget{Label: trimmed, Port: port}, nil
+	}
+	if strings.HasPrefix(trimmed, "http://") || strings.HasPrefix(trimmed, "https://") {
+		parsed, err := url.Parse(trimmed)
+		if err != nil || parsed.Host == "" {
+			return waitTarget{}, fmt.Errorf("invalid wait-for value %q: expected an absolute http(s) URL", input)
+		}
+		return waitTarget{Label: trimmed, URL: trimmed}, nil
+	}
+	return waitTarget{}, fmt.Errorf("invalid wait-for value %q: expected port:<port> or http(s)://host:port/path", input)
+}
+
+func waitForTarget(ctx context.Context, instance state.Instance, target waitTarget) error {
+	if target.URL != "" {
+		return vm.WaitForHTTP(ctx, target.URL)
+	}
+	return waitForGuestPort(ctx, instance, guestPortFor(instance, target.Port))
+}
+
 type envVarList struct {
 	Values map[string]string
 }
@@ -2156,110 +3127,103 @@ type openClawRuntimeRequirements struct {
 	GatewayAuthMode string
 }
 
-func (a *App) preflightOpenClawInputs(openClawConfig string, openClawEnv map[string]string, requiredEnvKeys []string) (string, error) {
-	requirements, err := parseOpenClawRuntimeRequirements(openClawConfig)
-	if err != nil {
-		return "", err
+type openClawInput struct {
+	Key      string
+	Label    string
+	FlagName string
+	EnvKey   string
+	Secret   bool
+}
+
+const openClawModelPrimaryAnswerKey = "model_primary"
+
+func (input openClawInput) missingHint() string {
+	if input.EnvKey != "" {
+		return fmt.Sprintf("%s (set %s or --openclaw-env %s=...)", input.Label, input.FlagName, input.EnvKey)
 	}
+	return fmt.Sprintf("%s (set %s)", input.Label, input.FlagName)
+}
 
-	canPrompt := a.canPromptForInput()
-	promptFile := a.promptInputFile()
-	var reader *bufio.Reader
-	if canPrompt {
-		reader = bufio.NewReader(a.in)
+func openClawEnvInput(envKey string, label string) openClawInput {
+	return openClawInput{
+		Key:      envKey,
+		Label:    label,
+		FlagName: requiredFlagForEnvKey(envKey),
+		EnvKey:   envKey,
+		Secret:   isSecretOpenClawEnvKey(envKey),
+	}
+}
+
+func (a *App) preflightOpenClawInputs(openClawConfig string, openClawEnv map[string]string, requiredEnvKeys []string, defaults map[string]string) (string, map[string]string, error) {
+	requirements, err := parseOpenClawRuntimeRequirements(openClawConfig)
+	if err != nil {
+		return "", nil, err
 	}
 
 	modelPrimary := strings.TrimSpace(requirements.ModelPrimary)
-	if modelPrimary == "" {
-		modelPrimary, err = a.resolveRequiredInput(reader, canPrompt, promptFile,
-			"OpenClaw primary model (provider/model, e.g. openai/gpt-5)",
-			"--openclaw-model-primary",
-			"",
-			false)
-		if err != nil {
-			return "", err
+	missing := make([]openClawInput, 0)
+	queued := map[string]struct{}{}
+	queue := func(input openClawInput) {
+		if _, exists := queued[input.Key]; exists {
+			return
 		}
-		openClawConfig, err = setOpenClawModelPrimary(openClawConfig, modelPrimary)
+		if value, found := a.lookupKeychainSecret(input); found {
+			openClawEnv[input.EnvKey] = value
+			return
+		}
+		queued[input.Key] = struct{}{}
+		missing = append(missing, input)
+	}
+	queueProvider := func(model string) error {
+		providerEnvKey, providerLabel, err := providerEnvRequirementForModel(model)
 		if err != nil {
-			return "", err
+			return err
 		}
+		if providerEnvKey != "" && strings.TrimSpace(openClawEnv[providerEnvKey]) == "" {
+			queue(openClawEnvInput(providerEnvKey, fmt.Sprintf("%s for model %s", providerLabel, model)))
+		}
+		return nil
 	}
 
-	providerEnvKey, providerLabel, err := providerEnvRequirementForModel(modelPrimary)
-	if err != nil {
-		return "", err
-	}
-	if providerEnvKey != "" && strings.TrimSpace(openClawEnv[providerEnvKey]) == "" {
-		flagHint := requiredFlagForEnvKey(providerEnvKey)
-		value, resolveErr := a.resolveRequiredInput(reader, canPrompt, promptFile,
-			fmt.Sprintf("%s for model %s", providerLabel, modelPrimary),
-			flagHint,
-			providerEnvKey,
-			true)
-		if resolveErr != nil {
-			return "", resolveErr
-		}
-		openClawEnv[providerEnvKey] = value
+	if modelPrimary == "" {
+		queue(openClawInput{
+			Key:      openClawModelPrimaryAnswerKey,
+			Label:    "OpenClaw primary model (provider/model, e.g. openai/gpt-5)",
+			FlagName: "--openclaw-model-primary",
+		})
+	} else if err := queueProvider(modelPrimary); err != nil {
+		return "", nil, err
 	}
 
 	switch strings.ToLower(strings.TrimSpace(requirements.GatewayAuthMode)) {
 	case "", "none":
 	case "token":
 		if strings.TrimSpace(openClawEnv["OPENCLAW_GATEWAY_TOKEN"]) == "" {
-			value, resolveErr := a.resolveRequiredInput(reader, canPrompt, promptFile,
-				"OpenClaw gateway token",
-				"--openclaw-gateway-token",
-				"OPENCLAW_GATEWAY_TOKEN",
-				true)
-			if resolveErr != nil {
-				return "", resolveErr
-			}
-			openClawEnv["OPENCLAW_GATEWAY_TOKEN"] = value
+			queue(openClawEnvInput("OPENCLAW_GATEWAY_TOKEN", "OpenClaw gateway token"))
 		}
 	case "password":
 		if strings.TrimSpace(openClawEnv["OPENCLAW_GATEWAY_PASSWORD"]) == "" {
-			value, resolveErr := a.resolveRequiredInput(reader, canPrompt, promptFile,
-				"OpenClaw gateway password",
-				"--openclaw-gateway-password",
-				"OPENCLAW_GATEWAY_PASSWORD",
-				true)
-			if resolveErr != nil {
-				return "", resolveErr
-			}
-			openClawEnv["OPENCLAW_GATEWAY_PASSWORD"] = value
+			queue(openClawEnvInput("OPENCLAW_GATEWAY_PASSWORD", "OpenClaw gateway password"))
 		}
 	default:
-		return "", fmt.Errorf("invalid gateway.auth.mode %q in OpenClaw config: expected token, password, or none", requirements.GatewayAuthMode)
+		return "", nil, fmt.Errorf("invalid gateway.auth.mode %q in OpenClaw config: expected token, password, or none", requirements.GatewayAuthMode)
 	}
 
-	requiredEnvKeys = normalizeRequiredEnvKeys(requiredEnvKeys)
-	for _, envKey := range requiredEnvKeys {
-		if strings.TrimSpace(openClawEnv[envKey]) != "" {
-			continue
-		}
-		flagHint := requiredFlagForEnvKey(envKey)
-		value, resolveErr := a.resolveRequiredInput(reader, canPrompt, promptFile,
-			requiredOpenClawEnvLabel(envKey),
-			flagHint,
-			envKey,
-			isSecretOpenClawEnvKey(envKey))
-		if resolveErr != nil {
-			return "", resolveErr
+	for _, envKey := range normalizeRequiredEnvKeys(requiredEnvKeys) {
+		if strings.TrimSpace(openClawEnv[envKey]) == "" {
+			queue(openClawEnvInput(envKey, requiredOpenClawEnvLabel(envKey)))
 		}
-		openClawEnv[envKey] = value
 	}
 
 	whatsAppRequired := []struct {
-		envKey   string
-		flagName string
-		label    string
+		envKey string
+		label  string
 	}{
-		{envKey: "WHATSAPP_PHONE_NUMBER_ID", flagName: "--openclaw-whatsapp-phone-number-id", label: "WhatsApp phone number id"},
-		{envKey: "WHATSAPP_ACCESS_TOKEN", flagName: "--openclaw-whatsapp-access-token", label: "WhatsApp access token"},
-		{envKey: "WHATSAPP_VERIFY_TOKEN", flagName: "--openclaw-whatsapp-verify-token", label: "WhatsApp verify token"},
-		{envKey: "WHATSAPP_APP_SECRET", flagName: "--openclaw-whatsapp-app-secret", label: "WhatsApp app secret"},
+		{envKey: "WHATSAPP_PHONE_NUMBER_ID", label: "WhatsApp phone number id"},
+		{envKey: "WHATSAPP_ACCESS_TOKEN", label: "WhatsApp access token"},
+		{envKey: "WHATSAPP_VERIFY_TOKEN", label: "WhatsApp verify token"},
+		{envKey: "WHATSAPP_APP_SECRET", label: "WhatsApp app secret"},
 	}
-
 	presentCount := 0
 	for _, item := range whatsAppRequired {
 		if strings.TrimSpace(openClawEnv[item.envKey]) != "" {
@@ -2268,45 +3232,115 @@ func (a *App) preflightOpenClawInputs(openClawConfig string, openClawEnv map[str
 	}
 	if presentCount > 0 && presentCount < len(whatsAppRequired) {
 		for _, item := range whatsAppRequired {
-			if strings.TrimSpace(openClawEnv[item.envKey]) != "" {
+			if strings.TrimSpace(openClawEnv[item.envKey]) == "" {
+				queue(openClawEnvInput(item.envKey, item.label))
+			}
+		}
+	}
+
+	answers := map[string]string{}
+	if len(missing) == 0 {
+		return openClawConfig, answers, nil
+	}
+
+	canPrompt := a.canPromptForInput()
+	if !canPrompt {
+		unresolved := make([]string, 0)
+		for index := 0; index < len(missing); index++ {
+			input := missing[index]
+			value := ""
+			if !input.Secret {
+				value = strings.TrimSpace(defaults[input.Key])
+			}
+			if value == "" {
+				unresolved = append(unresolved, input.missingHint())
 				continue
 			}
-			value, resolveErr := a.resolveRequiredInput(reader, canPrompt, promptFile, item.label, item.flagName, item.envKey, isSecretOpenClawEnvKey(item.envKey))
-			if resolveErr != nil {
-				return "", resolveErr
+			answers[input.Key] = value
+			if input.Key == openClawModelPrimaryAnswerKey {
+				if err := queueProvider(value); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+		if len(unresolved) == 1 {
+			return "", nil, fmt.Errorf("missing required OpenClaw parameter: %s", unresolved[0])
+		}
+		if len(unresolved) > 1 {
+			return "", nil, fmt.Errorf("missing required OpenClaw parameters: %s", strings.Join(unresolved, "; "))
+		}
+	} else {
+		labels := make([]string, 0, len(missing))
+		for _, input := range missing {
+			labels = append(labels, input.Label)
+		}
+		fmt.Fprintf(a.out, "openclaw: %d required input(s) missing: %s\n", len(missing), strings.Join(labels, ", "))
+
+		reader := bufio.NewReader(a.in)
+		promptFile := a.promptInputFile()
+		for index := 0; index < len(missing); index++ {
+			input := missing[index]
+			defaultValue := ""
+			if !input.Secret {
+				defaultValue = strings.TrimSpace(defaults[input.Key])
+			}
+			value, err := a.resolveRequiredInput(reader, promptFile, input, defaultValue)
+			if err != nil {
+				return "", nil, err
+			}
+			a.offerKeychainStore(reader, input, value)
+			answers[input.Key] = value
+			if input.Key == openClawModelPrimaryAnswerKey {
+				if err := queueProvider(value); err != nil {
+					return "", nil, err
+				}
 			}
-			openClawEnv[item.envKey] = value
 		}
 	}
 
-	return openClawConfig, nil
-}
+	for _, input := range missing {
+		value := answers[input.Key]
+		if input.Key == openClawModelPrimaryAnswerKey {
+			openClawConfig, err = setOpenClawModelPrimary(openClawConfig, value)
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		openClawEnv[input.EnvKey] = value
+	}
 
-func (a *App) resolveRequiredInput(reader *bufio.Reader, canPrompt bool, promptFile *os.File, label string, flagName string, envKey string, secret bool) (string, error) {
-	if !canPrompt || reader == nil {
-		if envKey != "" {
-			return "", fmt.Errorf("missing required OpenClaw parameter: %s (set %s or --openclaw-env %s=...)", label, flagName, envKey)
+	saved := map[string]string{}
+	for _, input := range missing {
+		if !input.Secret {
+			saved[input.Key] = answers[input.Key]
 		}
-		return "", fmt.Errorf("missing required OpenClaw parameter: %s (set %s)", label, flagName)
 	}
+	return openClawConfig, saved, nil
+}
 
+func (a *App) resolveRequiredInput(reader *bufio.Reader, promptFile *os.File, input openClawInput, defaultValue string) (string, error) {
 	for attempt := 1; attempt <= 3; attempt++ {
-		fmt.Fprintf(a.out, "openclaw> %s: ", label)
-		value, err := a.readPromptValue(reader, promptFile, secret)
+		if defaultValue != "" {
+			fmt.Fprintf(a.out, "openclaw> %s [%s]: ", input.Label, defaultValue)
+		} else {
+			fmt.Fprintf(a.out, "openclaw> %s: ", input.Label)
+		}
+		value, err := a.readPromptValue(reader, promptFile, input.Secret)
 		if err != nil {
 			return "", err
 		}
 		trimmed := strings.TrimSpace(value)
+		if trimmed == "" {
+			trimmed = defaultValue
+		}
 		if trimmed != "" {
 			return trimmed, nil
 		}
-		fmt.Fprintf(a.errOut, "invalid value: %s cannot be empty\n", label)
+		fmt.Fprintf(a.errOut, "invalid value: %s cannot be empty\n", input.Label)
 	}
 
-	if envKey != "" {
-		return "", fmt.Errorf("missing required OpenClaw parameter after 3 attempts: %s (set %s or --openclaw-env %s=...)", label, flagName, envKey)
-	}
-	return "", fmt.Errorf("missing required OpenClaw parameter after 3 attempts: %s (set %s)", label, flagName)
+	return "", fmt.Errorf("missing required OpenClaw parameter after 3 attempts: %s", input.missingHint())
 }
 
 func (a *App) readPromptValue(reader *bufio.Reader, promptFile *os.File, secret bool) (string, error) {
@@ -2676,6 +3710,100 @@ func findAvailableLoopbackPort() (int, error) {
 	return tcpAddress.Port, nil
 }
 
+func persistedVolumeMounts(mounts []vm.VolumeMount) []state.VolumeMount {
+	if len(mounts) == 0 {
+		return nil
+	}
+	result := make([]state.VolumeMount, 0, len(mounts))
+	for _, mount := range mounts {
+		result = append(result, state.VolumeMount{Name: mount.Name, HostPath: mount.HostPath, GuestPath: mount.GuestPath})
+	}
+	return result
+}
+
+func sortedEnvKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeGatewayCredential(instanceDir string, authMode string, openClawEnv map[string]string) (string, error) {
+	credential := ""
+	switch authMode {
+	case "token":
+		credential = strings.TrimSpace(openClawEnv["OPENCLAW_GATEWAY_TOKEN"])
+	case "password":
+		credential = strings.TrimSpace(openClawEnv["OPENCLAW_GATEWAY_PASSWORD"])
+	}
+	if credential == "" {
+		return "", nil
+	}
+
+	credentialPath := filepath.Join(instanceDir, "gateway.credential")
+	if err := os.WriteFile(credentialPath, []byte(credential), 0o600); err != nil {
+		return "", err
+	}
+	return credentialPath, nil
+}
+
+func readGatewayCredential(path string) string {
+	if path == "" {
+		return ""
+	}
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(payload))
+}
+
+// diskKeysRoot holds the generated LUKS keys of encrypted instances. It sits
+// beside the claws directory, never inside an instance directory, so copying
+// or exporting an instance does not carry the key along with its disk.
+func diskKeysRoot(clawsRoot string) string {
+	return filepath.Join(filepath.Dir(clawsRoot), "disk-keys")
+}
+
+func defaultDiskKeyPath(clawsRoot string, id string) string {
+	return filepath.Join(diskKeysRoot(clawsRoot), id+".key")
+}
+
+// isManagedDiskKey reports whether keyPath is a key clawfarm generated, as
+// opposed to one passed with --disk-key-file.
+func isManagedDiskKey(clawsRoot string, keyPath string) bool {
+	return keyPath != "" && filepath.Dir(keyPath) == diskKeysRoot(clawsRoot)
+}
+
+func ensureInstanceDiskKey(clawsRoot string, id string, keyPath string) (string, error) {
+	if keyPath == "" {
+		keyPath = defaultDiskKeyPath(clawsRoot, id)
+	}
+
+	if payload, err := os.ReadFile(keyPath); err == nil {
+		if len(strings.TrimSpace(string(payload))) == 0 {
+			return "", fmt.Errorf("disk key file %s is empty", keyPath)
+		}
+		return keyPath, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(keyPath, []byte(hex.EncodeToString(buffer)), 0o600); err != nil {
+		return "", err
+	}
+	return keyPath, nil
+}
+
 func generateInstanceSSHKeyPair(instanceDir string) (string, string, error) {
 	sshKeygenPath, err := exec.LookPath("ssh-keygen")
 	if err != nil {
@@ -2728,10 +3856,11 @@ func generateInstanceSSHKeyPair(instanceDir string) (string, string, error) {
 	return privateKeyPath, trimmedPublicKey, nil
 }
 
-func (a *App) runCommandsViaSSH(clawID string, sshHostPort int, sshPrivateKeyPath string, commands []string) error {
+func (a *App) runCommandsViaSSH(instance state.Instance, commands []string, options runCommandOptions) error {
 	if len(commands) == 0 {
 		return nil
 	}
+	clawID, sshHostPort, sshPrivateKeyPath, sshUser := instance.ID, instance.SSHHostPort, instance.SSHKeyPath, instance.SSHUser()
 	if sshHostPort <= 0 {
 		return errors.New("invalid ssh port for --run")
 	}
@@ -2743,31 +3872,73 @@ func (a *App) runCommandsViaSSH(clawID string, sshHostPort int, sshPrivateKeyPat
 	}
 
 	fmt.Fprintf(a.out, "run: waiting for ssh on 127.0.0.1:%d\n", sshHostPort)
-	sshReadyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	sshTimeout := options.SSHTimeout
+	if sshTimeout <= 0 {
+		sshTimeout = defaultSSHReadyTimeout
+	}
+	sshReadyCtx, cancel := context.WithTimeout(context.Background(), sshTimeout)
 	defer cancel()
-	if err := waitForSSHReady(sshReadyCtx, sshHostPort, sshPrivateKeyPath); err != nil {
+	if err := waitForSSHReady(sshReadyCtx, sshHostPort, sshPrivateKeyPath, sshUser); err != nil {
 		return fmt.Errorf("%s: wait for ssh readiness: %w", clawID, err)
 	}
+	a.markBootPhase(bootPhaseSSH)
 
 	fmt.Fprintln(a.out, "run: waiting for guest bootstrap readiness")
-	bootstrapReadyCtx, bootstrapReadyCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	bootstrapReadyCtx, bootstrapReadyCancel := context.WithTimeout(context.Background(), sshTimeout)
 	defer bootstrapReadyCancel()
-	if err := waitForGuestBootstrapReady(bootstrapReadyCtx, sshHostPort, sshPrivateKeyPath, bootstrapReadyMarker); err != nil {
+	if err := waitForGuestBootstrapReady(bootstrapReadyCtx, sshHostPort, sshPrivateKeyPath, sshUser, bootstrapReadyMarker); err != nil {
 		return fmt.Errorf("%s: wait for guest bootstrap readiness: %w", clawID, err)
 	}
 
+	completed, err := a.completedRunMarkers(sshHostPort, sshPrivateKeyPath, sshUser)
+	if err != nil {
+		return fmt.Errorf("%s: %w", clawID, err)
+	}
+
 commandLoop:
 	for index, command := range commands {
 		trimmedCommand := strings.TrimSpace(command)
 		if trimmedCommand == "" {
 			continue
 		}
+		marker := runCommandMarker(index, trimmedCommand)
+		if completed[marker] {
+			fmt.Fprintf(a.out, "run[%d/%d]: already completed in this guest; skipping\n", index+1, len(commands))
+			continue
+		}
 
-		fmt.Fprintf(a.out, "run[%d/%d]: %s\n", index+1, len(commands), trimmedCommand)
-		if err := a.runSSHCommand(sshHostPort, sshPrivateKeyPath, trimmedCommand, true); err == nil {
+		fmt.Fprintf(a.out, "run[%d/%d] (%s): %s\n", index+1, len(commands), options.RunAs, trimmedCommand)
+		logPath := runCommandLogPath(options.InstanceDir, index, trimmedCommand)
+		fmt.Fprintf(a.out, "run[%d/%d]: log %s\n", index+1, len(commands), logPath)
+		endGroup := a.actionsGroup(fmt.Sprintf("run[%d/%d]: %s", index+1, len(commands), trimmedCommand))
+		err := a.runLoggedSSHCommand(instance, index, trimmedCommand, options.RunAs, logPath)
+		for attempt := 1; err != nil && isSSHConnectionError(err) && attempt <= maxRunReconnects; attempt++ {
+			fmt.Fprintf(a.out, "run[%d/%d]: ssh connection lost; reconnecting\n", index+1, len(commands))
+			reconnectCtx, reconnectCancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			reconnectErr := a.reconnectSSH(reconnectCtx, sshHostPort, sshPrivateKeyPath, sshUser)
+			reconnectCancel()
+			if reconnectErr != nil {
+				endGroup()
+				return fmt.Errorf("%s: run command %d: reconnect after ssh drop: %w", clawID, index+1, reconnectErr)
+			}
+			completed, err = a.completedRunMarkers(sshHostPort, sshPrivateKeyPath, sshUser)
+			if err != nil {
+				endGroup()
+				return fmt.Errorf("%s: %w", clawID, err)
+			}
+			if completed[marker] {
+				fmt.Fprintf(a.out, "run[%d/%d]: finished before the connection dropped; not re-running\n", index+1, len(commands))
+				err = nil
+				break
+			}
+			fmt.Fprintf(a.out, "run[%d/%d]: did not finish; re-running\n", index+1, len(commands))
+			err = a.runLoggedSSHCommand(instance, index, trimmedCommand, options.RunAs, logPath)
+		}
+		endGroup()
+		if err == nil {
 			continue
 		} else {
-			commandErr := fmt.Errorf("run command %d failed: %w", index+1, err)
+			commandErr := fmt.Errorf("run command %d failed (log: %s): %w", index+1, logPath, err)
 			if !a.canPromptForInput() {
 				return commandErr
 			}
@@ -2782,7 +3953,18 @@ commandLoop:
 				case runFailureActionContinue:
 					continue commandLoop
 				case runFailureActionRescue:
-					if rescueErr := a.openRescueShellViaSSH(sshHostPort, sshPrivateKeyPath); rescueErr != nil {
+					if options.SetRescue != nil {
+						options.SetRescue(true)
+					}
+					rescueErr := a.openRescueShellViaSSH(instance, options.RescueTimeout)
+					if options.SetRescue != nil {
+						options.SetRescue(false)
+					}
+					if errors.Is(rescueErr, errRescueTimeout) {
+						fmt.Fprintf(a.errOut, "run: rescue shell closed after %s\n", options.RescueTimeout)
+						return commandErr
+					}
+					if rescueErr != nil {
 						fmt.Fprintf(a.errOut, "run rescue shell failed: %v\n", rescueErr)
 					}
 				case runFailureActionExit:
@@ -2797,13 +3979,13 @@ commandLoop:
 	return nil
 }
 
-func waitForSSHReady(ctx context.Context, sshHostPort int, sshPrivateKeyPath string) error {
+func waitForSSHReady(ctx context.Context, sshHostPort int, sshPrivateKeyPath string, sshUser string) error {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
 	var lastErr error
 	for {
-		if err := runSSHProbe(sshHostPort, sshPrivateKeyPath); err == nil {
+		if err := runSSHProbe(sshHostPort, sshPrivateKeyPath, sshUser); err == nil {
 			return nil
 		} else {
 			lastErr = err
@@ -2820,14 +4002,14 @@ func waitForSSHReady(ctx context.Context, sshHostPort int, sshPrivateKeyPath str
 	}
 }
 
-func waitForGuestBootstrapReady(ctx context.Context, sshHostPort int, sshPrivateKeyPath string, markerPath string) error {
+func waitForGuestBootstrapReady(ctx context.Context, sshHostPort int, sshPrivateKeyPath string, sshUser string, markerPath string) error {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
 	checkCommand := fmt.Sprintf("test -f %s", shellSingleQuote(markerPath))
 	var lastErr error
 	for {
-		if err := runSSHProbeWithCommand(sshHostPort, sshPrivateKeyPath, checkCommand); err == nil {
+		if err := runSSHProbeWithCommand(sshHostPort, sshPrivateKeyPath, sshUser, checkCommand); err == nil {
 			return nil
 		} else {
 			lastErr = err
@@ -2844,12 +4026,12 @@ func waitForGuestBootstrapReady(ctx context.Context, sshHostPort int, sshPrivate
 	}
 }
 
-func runSSHProbe(sshHostPort int, sshPrivateKeyPath string) error {
-	return runSSHProbeWithCommand(sshHostPort, sshPrivateKeyPath, "true")
+func runSSHProbe(sshHostPort int, sshPrivateKeyPath string, sshUser string) error {
+	return runSSHProbeWithCommand(sshHostPort, sshPrivateKeyPath, sshUser, "true")
 }
 
-func runSSHProbeWithCommand(sshHostPort int, sshPrivateKeyPath string, remoteCommand string) error {
-	args := append(sshBaseArgs(sshHostPort, sshPrivateKeyPath), "-T", "claw@127.0.0.1", remoteCommand)
+func runSSHProbeWithCommand(sshHostPort int, sshPrivateKeyPath string, sshUser string, remoteCommand string) error {
+	args := append(sshBaseArgs(sshHostPort, sshPrivateKeyPath), "-T", sshDestination(sshUser), remoteCommand)
 	sshCommand := exec.Command("ssh", args...)
 	output, err := sshCommand.CombinedOutput()
 	if err == nil {
@@ -2863,20 +4045,27 @@ func runSSHProbeWithCommand(sshHostPort int, sshPrivateKeyPath string, remoteCom
 	return errors.New(message)
 }
 
-func (a *App) runSSHCommand(sshHostPort int, sshPrivateKeyPath string, command string, allocateTTY bool) error {
-	remoteCommand := fmt.Sprintf("sudo -n bash -lc %s", shellSingleQuote(command))
-	args := sshBaseArgs(sshHostPort, sshPrivateKeyPath)
+func (a *App) runSSHCommand(instance state.Instance, command string, allocateTTY bool, logWriter io.Writer) error {
+	remoteCommand, err := guestSudoCommand(instance, "bash -lc "+shellSingleQuote(command))
+	if err != nil {
+		return err
+	}
+	args := sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath)
 	if allocateTTY {
 		args = append(args, "-tt")
 	} else {
 		args = append(args, "-T")
 	}
-	args = append(args, "claw@127.0.0.1", remoteCommand)
+	args = append(args, sshDestination(instance.SSHUser()), remoteCommand)
 
 	sshCommand := exec.Command("ssh", args...)
 	sshCommand.Stdin = a.in
 	sshCommand.Stdout = a.out
 	sshCommand.Stderr = a.errOut
+	if logWriter != nil {
+		sshCommand.Stdout = io.MultiWriter(a.out, logWriter)
+		sshCommand.Stderr = io.MultiWriter(a.errOut, logWriter)
+	}
 
 	if err := sshCommand.Run(); err != nil {
 		return fmt.Errorf("ssh command failed: %w", err)
@@ -2884,16 +4073,32 @@ func (a *App) runSSHCommand(sshHostPort int, sshPrivateKeyPath string, command s
 	return nil
 }
 
-func (a *App) openRescueShellViaSSH(sshHostPort int, sshPrivateKeyPath string) error {
-	args := sshBaseArgs(sshHostPort, sshPrivateKeyPath)
-	args = append(args, "-tt", "claw@127.0.0.1", "sudo -n -i")
+func (a *App) openRescueShellViaSSH(instance state.Instance, timeout time.Duration) error {
+	rootShell, err := guestSudoCommand(instance, "-i")
+	if err != nil {
+		return err
+	}
+	args := sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath)
+	args = append(args, "-tt", sshDestination(instance.SSHUser()), rootShell)
 
-	fmt.Fprintln(a.out, "run: opening rescue shell as root (exit shell to continue)")
-	command := exec.Command("ssh", args...)
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		fmt.Fprintf(a.out, "run: opening rescue shell as root (exit shell to continue; closes after %s)\n", timeout)
+	} else {
+		fmt.Fprintln(a.out, "run: opening rescue shell as root (exit shell to continue)")
+	}
+	command := exec.CommandContext(ctx, "ssh", args...)
 	command.Stdin = a.in
 	command.Stdout = a.out
 	command.Stderr = a.errOut
-	return command.Run()
+	err = command.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errRescueTimeout
+	}
+	return err
 }
 
 func (a *App) promptRunFailureAction(index int, command string) (runFailureAction, error) {
@@ -2946,6 +4151,13 @@ func sshBaseArgs(sshHostPort int, sshPrivateKeyPath string) []string {
 	}
 }
 
+func sshDestination(sshUser string) string {
+	if sshUser == "" {
+		sshUser = runAsClaw
+	}
+	return sshUser + "@127.0.0.1"
+}
+
 func shellSingleQuote(value string) string {
 	return "'" + strings.ReplaceAll(value, "'", "'\"'\"'") + "'"
 }
@@ -2963,6 +4175,62 @@ func normalizeRunArgs(args []string) []string {
 	return reordered
 }
 
+// globalFlags are the flags accepted before the command name.
+type globalFlags struct {
+	Dirs      config.DirOverrides
+	Downloads config.DownloadSettings
+}
+
+func parseGlobalFlags(args []string) (globalFlags, []string, error) {
+	flags := globalFlags{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		name, value, hasValue := strings.Cut(args[0], "=")
+		var target *string
+		switch name {
+		case "--data-dir":
+			target = &flags.Dirs.DataDir
+		case "--cache-dir":
+			target = &flags.Dirs.CacheDir
+		case "--context":
+			target = &flags.Dirs.Context
+		case "--download-limit":
+			target = &flags.Downloads.Limit
+		default:
+			return flags, args, nil
+		}
+		args = args[1:]
+		if !hasValue {
+			if len(args) == 0 {
+				return flags, nil, fmt.Errorf("%s requires a value", name)
+			}
+			value = args[0]
+			args = args[1:]
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return flags, nil, fmt.Errorf("%s requires a value", name)
+		}
+		switch name {
+		case "--context":
+			if _, err := config.NormalizeContext(value); err != nil {
+				return flags, nil, err
+			}
+		case "--download-limit":
+			if _, err := config.ParseBandwidth(value); err != nil {
+				return flags, nil, fmt.Errorf("invalid --download-limit: %w", err)
+			}
+		default:
+			absolute, err := filepath.Abs(value)
+			if err != nil {
+				return flags, nil, err
+			}
+			value = absolute
+		}
+		*target = value
+	}
+	return flags, args, nil
+}
+
 func hasCLIFlag(args []string, flagName string) bool {
 	for index := 0; index < len(args); index++ {
 		value := strings.TrimSpace(args[index])
@@ -2975,36 +4243,3 @@ func hasCLIFlag(args []string, flagName string) bool {
 	}
 	return false
 }
-
-func copyFile(sourcePath string, destinationPath string) error {
-	sourceFile, err := os.Open(sourcePath)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	if err := os.MkdirAll(filepath.Dir(destinationPath), 0o755); err != nil {
-		return err
-	}
-
-	temporaryPath := destinationPath + ".tmp"
-	targetFile, err := os.Create(temporaryPath)
-	if err != nil {
-		return err
-	}
-	if _, err := io.Copy(targetFile, sourceFile); err != nil {
-		targetFile.Close()
-		_ = os.Remove(temporaryPath)
-		return err
-	}
-	if err := targetFile.Close(); err != nil {
-		_ = os.Remove(temporaryPath)
-		return err
-	}
-
-	if err := os.Rename(temporaryPath, destinationPath); err != nil {
-		_ = os.Remove(temporaryPath)
-		return err
-	}
-	return nil
-}
@@ -5,34 +5,59 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/yazhou/krunclaw/internal/clawbox"
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/images"
 	"github.com/yazhou/krunclaw/internal/state"
 	"github.com/yazhou/krunclaw/internal/vm"
+	"github.com/yazhou/krunclaw/internal/vm/fakeqemu"
 )
 
 const testClawboxSHA256 = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
 
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == vm.FakeQEMUSubcommand {
+		os.Exit(fakeqemu.Main(os.Args[2:], os.Stderr))
+	}
+	for _, name := range []string{"GITHUB_ACTIONS", "GITHUB_OUTPUT", "GITHUB_STEP_SUMMARY"} {
+		_ = os.Unsetenv(name)
+	}
+	os.Exit(m.Run())
+}
+
 type fakeBackend struct {
 	mu           sync.Mutex
 	nextPID      int
 	running      map[int]bool
 	lastSpec     vm.StartSpec
+	suspended    vm.Process
 	startEntered chan struct{}
 	startGate    <-chan struct{}
 }
@@ -69,29 +94,31 @@ func (f *fakeBackend) Start(_ context.Context, spec vm.StartSpec) (vm.StartResul
 		PIDFilePath:   filepath.Join(spec.InstanceDir, "qemu.pid"),
 		MonitorPath:   filepath.Join(spec.InstanceDir, "qemu-monitor.sock"),
 		Accel:         "tcg",
+		Command:       []string{"qemu-system-x86_64", "-pidfile", filepath.Join(spec.InstanceDir, "qemu.pid")},
 	}, nil
 }
 
-func (f *fakeBackend) Stop(_ context.Context, pid int) error {
+func (f *fakeBackend) Stop(_ context.Context, process vm.Process) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	delete(f.running, pid)
+	delete(f.running, process.PID)
 	return nil
 }
 
-func (f *fakeBackend) Suspend(pid int) error {
+func (f *fakeBackend) Suspend(process vm.Process) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if !f.running[pid] {
+	if !f.running[process.PID] {
 		return os.ErrNotExist
 	}
+	f.suspended = process
 	return nil
 }
 
-func (f *fakeBackend) Resume(pid int) error {
+func (f *fakeBackend) Resume(process vm.Process) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if !f.running[pid] {
+	if !f.running[process.PID] {
 		return os.ErrNotExist
 	}
 	return nil
@@ -178,6 +205,9 @@ func TestRunFlowAndInstanceLifecycle(t *testing.T) {
 	if !strings.Contains(out.String(), "suspended") {
 		t.Fatalf("suspend output missing status: %s", out.String())
 	}
+	if backend.suspended.Backend != vm.BackendQEMU || !strings.HasSuffix(backend.suspended.MonitorPath, filepath.Join(id, "qemu-monitor.sock")) {
+		t.Fatalf("expected suspend to reach the recorded monitor socket, got %+v", backend.suspended)
+	}
 
 	out.Reset()
 	if err := application.Run([]string{"resume", id}); err != nil {
@@ -196,6 +226,146 @@ func TestRunFlowAndInstanceLifecycle(t *testing.T) {
 	}
 }
 
+func TestRemoveDeletesInstanceTreeAndReportsOrphanedListeners(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	orphanPort := listener.Addr().(*net.TCPAddr).Port
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--publish", fmt.Sprintf("%d:80", orphanPort)}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instanceDir := filepath.Join(data, "claws", id)
+	if err := os.MkdirAll(filepath.Join(instanceDir, "ssh"), 0o700); err != nil {
+		t.Fatalf("create ssh dir: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"rm", id}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	if _, err := os.Stat(instanceDir); !os.IsNotExist(err) {
+		t.Fatalf("expected instance dir to be removed, stat err=%v", err)
+	}
+	if !strings.Contains(errOut.String(), fmt.Sprintf("127.0.0.1:%d (publish) is still accepting connections", orphanPort)) {
+		t.Fatalf("expected orphaned listener warning, got: %s", errOut.String())
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	keptID := parseClawIDFromRunOutput(out.String())
+	keptDir := filepath.Join(data, "claws", keptID)
+
+	out.Reset()
+	if err := application.Run([]string{"rm", keptID, "--keep-data"}); err != nil {
+		t.Fatalf("rm --keep-data failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "data kept in "+keptDir) {
+		t.Fatalf("expected kept data path in rm output: %s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(keptDir, "instance.img")); err != nil {
+		t.Fatalf("expected instance disk to be kept: %v", err)
+	}
+	if _, err := state.NewStore(filepath.Join(data, "claws")).Load(keptID); !errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected instance metadata to be removed, got %v", err)
+	}
+}
+
+func TestRemoveKeepVolumesAndReattachWithVolumeFrom(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--volume", "data:/data"}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	oldID := parseClawIDFromRunOutput(out.String())
+	if err := os.WriteFile(filepath.Join(data, "claws", oldID, "volumes", "data", "notes.txt"), []byte("keep me"), 0o644); err != nil {
+		t.Fatalf("write volume file: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"rm", oldID, "--keep-volumes"}); err != nil {
+		t.Fatalf("rm --keep-volumes failed: %v", err)
+	}
+	preservedDir := filepath.Join(data, "volumes", oldID)
+	if !strings.Contains(out.String(), "preserved 1 volume(s) in "+preservedDir) {
+		t.Fatalf("expected preserved volume message, got: %s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(preservedDir, "data", "notes.txt")); err != nil {
+		t.Fatalf("expected preserved volume data: %v", err)
+	}
+
+	failing := NewWithBackend(&bytes.Buffer{}, &bytes.Buffer{}, failingStartBackend{backend})
+	if err := failing.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--volume-from", oldID}); err == nil {
+		t.Fatal("expected new to fail when the VM does not boot")
+	}
+	if _, err := os.Stat(filepath.Join(preservedDir, "data", "notes.txt")); err != nil {
+		t.Fatalf("expected a failed boot to hand the volume back: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(preservedDir, preservedVolumesManifest)); err != nil {
+		t.Fatalf("expected the preserved manifest to survive a failed boot: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--volume-from", oldID}); err != nil {
+		t.Fatalf("new --volume-from failed: %v", err)
+	}
+	newID := parseClawIDFromRunOutput(out.String())
+	payload, err := os.ReadFile(filepath.Join(data, "claws", newID, "volumes", "data", "notes.txt"))
+	if err != nil || string(payload) != "keep me" {
+		t.Fatalf("expected reattached volume data, got %q err=%v", payload, err)
+	}
+	if len(backend.lastSpec.VolumeMounts) != 1 || backend.lastSpec.VolumeMounts[0].GuestPath != "/data" {
+		t.Fatalf("expected reattached volume mount at /data, got %#v", backend.lastSpec.VolumeMounts)
+	}
+	if _, err := os.Stat(preservedDir); !os.IsNotExist(err) {
+		t.Fatalf("expected preserved dir to be consumed, stat err=%v", err)
+	}
+
+	err = application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--volume-from", oldID})
+	if err == nil || !strings.Contains(err.Error(), "no preserved volumes") {
+		t.Fatalf("expected missing preserved volumes error, got %v", err)
+	}
+}
+
 func TestNewCreatesVolumeDirectoryWhenMissing(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
@@ -249,6 +419,171 @@ func TestNewCreatesVolumeDirectoryWhenMissing(t *testing.T) {
 	}
 }
 
+func TestRunPersistsExtraHostsAndDNS(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--add-host", "db.internal:10.0.0.5", "--dns", "10.0.0.2"})
+	if err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from new output: %s", out.String())
+	}
+
+	if len(backend.lastSpec.ExtraHosts) != 1 || backend.lastSpec.ExtraHosts[0] != (vm.HostEntry{Name: "db.internal", IP: "10.0.0.5"}) {
+		t.Fatalf("unexpected extra hosts passed to backend: %#v", backend.lastSpec.ExtraHosts)
+	}
+	if strings.Join(backend.lastSpec.DNSServers, ",") != "10.0.0.2" {
+		t.Fatalf("unexpected dns servers passed to backend: %#v", backend.lastSpec.DNSServers)
+	}
+
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if len(instance.ExtraHosts) != 1 || instance.ExtraHosts[0].Name != "db.internal" {
+		t.Fatalf("unexpected persisted extra hosts: %#v", instance.ExtraHosts)
+	}
+	if instance.HostAlias == nil || *instance.HostAlias != (state.HostEntry{Name: "host.clawfarm.internal", IP: "10.0.2.2"}) {
+		t.Fatalf("unexpected persisted host alias: %#v", instance.HostAlias)
+	}
+	if !strings.Contains(out.String(), "host alias: host.clawfarm.internal -> 10.0.2.2") {
+		t.Fatalf("expected host alias in run output, got:\n%s", out.String())
+	}
+	if strings.Join(instance.DNSServers, ",") != "10.0.0.2" {
+		t.Fatalf("unexpected persisted dns servers: %#v", instance.DNSServers)
+	}
+}
+
+func TestRunEncryptDiskCreatesKeyAndPassesItToBackend(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	toolDir := t.TempDir()
+	argsLog := filepath.Join(toolDir, "qemu-img.args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsLog + "\nfor last; do :; done\nprintf 'QFI\\373encrypted' > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--encrypt-disk"})
+	if err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from new output: %s", out.String())
+	}
+
+	keyPath := filepath.Join(data, "disk-keys", id+".key")
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("expected disk key file: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected disk key mode 0600, got %o", info.Mode().Perm())
+	}
+	if backend.lastSpec.DiskKeyPath != keyPath {
+		t.Fatalf("expected backend disk key path %s, got %q", keyPath, backend.lastSpec.DiskKeyPath)
+	}
+
+	logged, err := os.ReadFile(argsLog)
+	if err != nil {
+		t.Fatalf("expected qemu-img to be invoked: %v", err)
+	}
+	if !strings.Contains(string(logged), "encrypt.format=luks") {
+		t.Fatalf("expected luks encryption options, got: %s", logged)
+	}
+	disk, err := os.ReadFile(filepath.Join(data, "claws", id, "instance.img"))
+	if err != nil {
+		t.Fatalf("read instance disk: %v", err)
+	}
+	if string(disk) != "QFI\xfbencrypted" {
+		t.Fatalf("expected instance disk to be replaced by encrypted image, got %q", disk)
+	}
+
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.DiskKeyPath != keyPath {
+		t.Fatalf("expected persisted disk key path %s, got %q", keyPath, instance.DiskKeyPath)
+	}
+	if !strings.Contains(out.String(), "disk: encrypted") {
+		t.Fatalf("expected encrypted disk line in output: %s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(data, "claws", id, "disk.key")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no disk key inside the instance directory, got %v", err)
+	}
+
+	if err := application.Run([]string{"rm", id}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	if _, err := os.Stat(keyPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected rm to delete the generated disk key, got %v", err)
+	}
+}
+
+func TestRunRejectsInvalidRootfsMode(t *testing.T) {
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--rootfs", "ro"})
+	if err == nil || !strings.Contains(err.Error(), "invalid --rootfs") {
+		t.Fatalf("expected invalid rootfs error, got %v", err)
+	}
+}
+
+func TestRunRejectsInvalidAddHost(t *testing.T) {
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--add-host", "db.internal:not-an-ip"})
+	if err == nil {
+		t.Fatal("expected add-host validation error")
+	}
+	if !strings.Contains(err.Error(), "invalid add-host ip") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestRunAndRemoveUpdateMountStateFile(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
@@ -545,6 +880,67 @@ func TestRunClawboxRequiredEnvFailsFastWhenMissing(t *testing.T) {
 	}
 }
 
+func TestRunClawboxIgnoreRequiredEnvDowngradesToWarning(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+	mutateTestClawboxFile(t, clawboxPath, func(header *clawbox.Header) {
+		header.Spec.OpenClaw.GatewayAuthMode = "none"
+		header.Spec.OpenClaw.RequiredEnv = []string{"MATRIX_TOKEN", "CUSTOM_REQUIRED_TOKEN"}
+	})
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}
+
+	err := application.Run(append(baseArgs, "--ignore-required-env", "MATRIX_TOKEN"))
+	if err == nil || !strings.Contains(err.Error(), "CUSTOM_REQUIRED_TOKEN") || strings.Contains(err.Error(), "MATRIX_TOKEN") {
+		t.Fatalf("expected only the unignored key to fail preflight, got %v", err)
+	}
+	if err := application.Run(append(baseArgs, "--ignore-required-env", "SLACK_TOKEN")); err == nil || !strings.Contains(err.Error(), "does not declare it") {
+		t.Fatalf("expected an undeclared key to be rejected, got %v", err)
+	}
+
+	errOut.Reset()
+	out.Reset()
+	if err := application.Run(append(baseArgs, "--ignore-required-env", "matrix_token", "--openclaw-env", "CUSTOM_REQUIRED_TOKEN=set")); err != nil {
+		t.Fatalf("run with ignored key failed: %v", err)
+	}
+	if !strings.Contains(errOut.String(), "without required env MATRIX_TOKEN") {
+		t.Fatalf("expected a warning for the ignored key, got %q", errOut.String())
+	}
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(parseClawIDFromRunOutput(out.String()))
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if !reflect.DeepEqual(instance.IgnoredRequiredEnv, []string{"MATRIX_TOKEN"}) {
+		t.Fatalf("expected ignored keys in instance state, got %v", instance.IgnoredRequiredEnv)
+	}
+	if err := application.Run([]string{"rm", instance.ID}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+
+	errOut.Reset()
+	out.Reset()
+	if err := application.Run(append(baseArgs, "--ignore-all-required-env")); err != nil {
+		t.Fatalf("run with --ignore-all-required-env failed: %v", err)
+	}
+	instance, err = state.NewStore(filepath.Join(data, "claws")).Load(parseClawIDFromRunOutput(out.String()))
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if !reflect.DeepEqual(instance.IgnoredRequiredEnv, []string{"MATRIX_TOKEN", "CUSTOM_REQUIRED_TOKEN"}) {
+		t.Fatalf("expected every missing key to be ignored, got %v", instance.IgnoredRequiredEnv)
+	}
+}
+
 func TestRunClawboxRequiredEnvCanBeProvidedByOpenClawEnv(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
@@ -607,24 +1003,121 @@ func TestRunDotFailsWhenMultipleClawboxFilesExist(t *testing.T) {
 	}
 }
 
-func TestRunJSONSpecClawboxDownloadsAndRunsWithoutMount(t *testing.T) {
+func TestRunDotFindsClawboxInParentAndPicksAmongMultiple(t *testing.T) {
+	cache := t.TempDir()
 	data := t.TempDir()
-	home := t.TempDir()
-	if err := os.Setenv("HOME", home); err != nil {
-		t.Fatalf("set HOME env: %v", err)
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
 	}
-	defer os.Unsetenv("HOME")
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
 	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
 		t.Fatalf("set data env: %v", err)
 	}
 	defer os.Unsetenv("CLAWFARM_DATA_DIR")
 
-	basePayload := []byte("json-spec-base-image")
-	baseSHA := sha256Hex(basePayload)
-	layerPayload := []byte("json-spec-layer")
-	layerSHA := sha256Hex(layerPayload)
-
-	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	seedFetchedImage(t, cache)
+	repoRoot := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repoRoot, ".git"), 0o755); err != nil {
+		t.Fatalf("mkdir .git: %v", err)
+	}
+	firstPath := writeTestClawboxFile(t, repoRoot, "a.clawbox", "demo-openclaw-a", "ubuntu:24.04")
+	subdir := filepath.Join(repoRoot, "src", "pkg")
+	if err := os.MkdirAll(subdir, 0o755); err != nil {
+		t.Fatalf("mkdir subdir: %v", err)
+	}
+
+	originalWD, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	defer os.Chdir(originalWD)
+	if err := os.Chdir(subdir); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	expectedClawID := func(path string) string {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read clawbox: %v", err)
+		}
+		header, err := clawbox.ParseHeaderJSON(body)
+		if err != nil {
+			t.Fatalf("parse clawbox: %v", err)
+		}
+		id, err := header.ClawID(path)
+		if err != nil {
+			t.Fatalf("clawid: %v", err)
+		}
+		return id
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	runArgs := []string{"run", ".", "--workspace=" + subdir, "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "gateway-token"}
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run from subdirectory failed: %v", err)
+	}
+	if id := parseClawIDFromRunOutput(out.String()); id != expectedClawID(firstPath) {
+		t.Fatalf("expected parent clawbox a.clawbox to be used, got CLAWID %s", id)
+	}
+	if !strings.Contains(errOut.String(), "using ") {
+		t.Fatalf("expected note about the discovered parent clawbox: %s", errOut.String())
+	}
+
+	secondPath := writeTestClawboxFile(t, repoRoot, "b.clawbox", "demo-openclaw-b", "ubuntu:24.04")
+	out.Reset()
+	err = application.Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "multiple .clawbox files") || !strings.Contains(err.Error(), "--clawbox") {
+		t.Fatalf("expected non-interactive multiple clawbox error, got %v", err)
+	}
+
+	out.Reset()
+	picker := NewWithIOAndBackend(&out, &errOut, strings.NewReader("7\n2\n"), backend)
+	if err := picker.Run(runArgs); err != nil {
+		t.Fatalf("run with picker failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "2) b.clawbox") || !strings.Contains(errOut.String(), `invalid choice "7"`) {
+		t.Fatalf("expected picker prompt and retry: %s / %s", out.String(), errOut.String())
+	}
+	if !strings.Contains(out.String(), "CLAWID: "+expectedClawID(secondPath)) {
+		t.Fatalf("expected picked clawbox b to run: %s", out.String())
+	}
+
+	out.Reset()
+	backend.running = map[int]bool{}
+	explicitArgs := append([]string{"run", "--clawbox", firstPath}, runArgs[2:]...)
+	if err := application.Run(explicitArgs); err != nil {
+		t.Fatalf("run with --clawbox failed: %v", err)
+	}
+	if id := parseClawIDFromRunOutput(out.String()); id != expectedClawID(firstPath) {
+		t.Fatalf("expected --clawbox to select a.clawbox, got CLAWID %s", id)
+	}
+
+	err = application.Run(append([]string{"run", "--clawbox", firstPath, "ubuntu:24.04"}, runArgs[2:]...))
+	if err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Fatalf("expected conflicting input error, got %v", err)
+	}
+}
+
+func TestRunJSONSpecClawboxDownloadsAndRunsWithoutMount(t *testing.T) {
+	data := t.TempDir()
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	basePayload := []byte("json-spec-base-image")
+	baseSHA := sha256Hex(basePayload)
+	layerPayload := []byte("json-spec-layer")
+	layerSHA := sha256Hex(layerPayload)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		switch request.URL.Path {
 		case "/base.img":
 			_, _ = writer.Write(basePayload)
@@ -660,6 +1153,7 @@ func TestRunJSONSpecClawboxDownloadsAndRunsWithoutMount(t *testing.T) {
       "required_env": ["OPENAI_API_KEY"]
     }
   },
+  "provision_target": "host",
   "provision": [
     "echo provisioned > provisioned.txt"
   ]
@@ -673,7 +1167,12 @@ func TestRunJSONSpecClawboxDownloadsAndRunsWithoutMount(t *testing.T) {
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
 
-	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}); err != nil {
+	err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
+	if err == nil || !strings.Contains(err.Error(), "--allow-host-provision") {
+		t.Fatalf("expected host provision to require --allow-host-provision, got %v", err)
+	}
+
+	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--allow-host-provision", "--trust"}); err != nil {
 		t.Fatalf("run command failed: %v", err)
 	}
 
@@ -694,17 +1193,24 @@ func TestRunJSONSpecClawboxDownloadsAndRunsWithoutMount(t *testing.T) {
 		t.Fatalf("json spec run should not set mount source, got %q", mountState.SourcePath)
 	}
 
-	baseBlobPath := filepath.Join(home, ".clawfarm", "blobs", baseSHA)
+	blobsRoot, err := config.BlobsDir()
+	if err != nil {
+		t.Fatalf("resolve blobs dir: %v", err)
+	}
+	if !strings.HasPrefix(blobsRoot, home+string(filepath.Separator)) {
+		t.Fatalf("expected blobs under HOME %s, got %s", home, blobsRoot)
+	}
+	baseBlobPath := filepath.Join(blobsRoot, baseSHA)
 	if _, err := os.Stat(baseBlobPath); err != nil {
 		t.Fatalf("expected base blob file %s: %v", baseBlobPath, err)
 	}
-	layerBlobPath := filepath.Join(home, ".clawfarm", "blobs", layerSHA)
+	layerBlobPath := filepath.Join(blobsRoot, layerSHA)
 	if _, err := os.Stat(layerBlobPath); err != nil {
 		t.Fatalf("expected layer blob file %s: %v", layerBlobPath, err)
 	}
 }
 
-func TestRunJSONSpecClawboxUsesCachedArtifactsWithoutRedownload(t *testing.T) {
+func TestRunJSONSpecClawboxProvisionsInsideGuestByDefault(t *testing.T) {
 	data := t.TempDir()
 	home := t.TempDir()
 	if err := os.Setenv("HOME", home); err != nil {
@@ -716,20 +1222,17 @@ func TestRunJSONSpecClawboxUsesCachedArtifactsWithoutRedownload(t *testing.T) {
 	}
 	defer os.Unsetenv("CLAWFARM_DATA_DIR")
 
-	basePayload := []byte("json-spec-cached-base")
+	basePayload := []byte("json-spec-guest-provision-base")
 	baseSHA := sha256Hex(basePayload)
-
-	requestCount := 0
 	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		requestCount++
 		_, _ = writer.Write(basePayload)
 	}))
 	defer server.Close()
 
 	workspace := t.TempDir()
-	specPath := filepath.Join(workspace, "cached-json.clawbox")
+	specPath := filepath.Join(workspace, "guest-json.clawbox")
 	specContent := `{
-  "name": "cached-json",
+  "name": "guest-json",
   "spec": {
     "base_image": {
       "ref": "ubuntu:24.04",
@@ -739,10 +1242,12 @@ func TestRunJSONSpecClawboxUsesCachedArtifactsWithoutRedownload(t *testing.T) {
     "openclaw": {
       "install_root": "/claw",
       "model_primary": "openai/gpt-5",
-      "gateway_auth_mode": "none",
-      "required_env": ["OPENAI_API_KEY"]
+      "gateway_auth_mode": "none"
     }
-  }
+  },
+  "provision": [
+    "echo provisioned > provisioned.txt"
+  ]
 }`
 	if err := os.WriteFile(specPath, []byte(specContent), 0o644); err != nil {
 		t.Fatalf("write json spec clawbox: %v", err)
@@ -753,36 +1258,29 @@ func TestRunJSONSpecClawboxUsesCachedArtifactsWithoutRedownload(t *testing.T) {
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
 
-	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("first run failed: %v", err)
-	}
-	if requestCount != 1 {
-		t.Fatalf("expected first run to download once, got %d requests", requestCount)
+	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
 	}
-
-	firstID := parseClawIDFromRunOutput(out.String())
-	if firstID == "" {
-		t.Fatalf("failed to parse first CLAWID from run output: %s", out.String())
+	id := parseClawIDFromRunOutput(out.String())
+	if _, err := os.Stat(filepath.Join(data, "claws", id, "provisioned.txt")); !os.IsNotExist(err) {
+		t.Fatalf("guest provision command must not run on the host, stat err: %v", err)
 	}
-
-	out.Reset()
-	if err := application.Run([]string{"rm", firstID}); err != nil {
-		t.Fatalf("rm first instance failed: %v", err)
+	if len(backend.lastSpec.CloudInitProvision) != 1 || backend.lastSpec.CloudInitProvision[0] != "echo provisioned > provisioned.txt" {
+		t.Fatalf("expected provision command in cloud-init, got %#v", backend.lastSpec.CloudInitProvision)
 	}
 
-	out.Reset()
-	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("second run failed: %v", err)
+	invalidPath := filepath.Join(workspace, "invalid-target.clawbox")
+	if err := os.WriteFile(invalidPath, []byte(strings.Replace(specContent, `"provision": [`, `"provision_target": "vm",
+  "provision": [`, 1)), 0o644); err != nil {
+		t.Fatalf("write invalid spec: %v", err)
 	}
-	if requestCount != 1 {
-		t.Fatalf("expected second run to reuse cache without download, got %d requests", requestCount)
-	}
-	if !strings.Contains(out.String(), "using cached base") {
-		t.Fatalf("expected cached marker in output, got: %s", out.String())
+	err := application.Run([]string{"run", invalidPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
+	if err == nil || !strings.Contains(err.Error(), "provision_target") {
+		t.Fatalf("expected invalid provision_target error, got %v", err)
 	}
 }
 
-func TestRunJSONSpecClawboxFailsOnSHA256Mismatch(t *testing.T) {
+func TestRunUntrustedClawboxRequiresConfirmationOnce(t *testing.T) {
 	data := t.TempDir()
 	home := t.TempDir()
 	if err := os.Setenv("HOME", home); err != nil {
@@ -794,20 +1292,22 @@ func TestRunJSONSpecClawboxFailsOnSHA256Mismatch(t *testing.T) {
 	}
 	defer os.Unsetenv("CLAWFARM_DATA_DIR")
 
+	basePayload := []byte("json-spec-trust-base")
+	baseSHA := sha256Hex(basePayload)
 	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		_, _ = writer.Write([]byte("wrong-content"))
+		_, _ = writer.Write(basePayload)
 	}))
 	defer server.Close()
 
 	workspace := t.TempDir()
-	specPath := filepath.Join(workspace, "sha-mismatch.clawbox")
+	specPath := filepath.Join(workspace, "trust-json.clawbox")
 	specContent := `{
-  "name": "sha-mismatch",
+  "name": "trust-json",
   "spec": {
     "base_image": {
       "ref": "ubuntu:24.04",
       "url": "` + server.URL + `/base.img",
-      "sha256": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+      "sha256": "` + baseSHA + `"
     },
     "openclaw": {
       "install_root": "/claw",
@@ -815,7 +1315,10 @@ func TestRunJSONSpecClawboxFailsOnSHA256Mismatch(t *testing.T) {
       "gateway_auth_mode": "none",
       "required_env": ["OPENAI_API_KEY"]
     }
-  }
+  },
+  "provision": [
+    "curl -fsSL https://example.invalid/install.sh | sh"
+  ]
 }`
 	if err := os.WriteFile(specPath, []byte(specContent), 0o644); err != nil {
 		t.Fatalf("write json spec clawbox: %v", err)
@@ -824,21 +1327,45 @@ func TestRunJSONSpecClawboxFailsOnSHA256Mismatch(t *testing.T) {
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	runArgs := []string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--publish", "8080:80", "--openclaw-openai-api-key", "test-key"}
 
-	err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
-	if err == nil {
-		t.Fatal("expected sha mismatch error")
+	err := NewWithBackend(&out, &errOut, backend).Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "re-run with --trust") {
+		t.Fatalf("expected untrusted clawbox error, got %v", err)
 	}
-	if !strings.Contains(err.Error(), "sha256 mismatch") {
-		t.Fatalf("unexpected error: %v", err)
+	summary := out.String()
+	for _, expected := range []string{"spec sha256 " + specSHA256([]byte(specContent)), "1. curl -fsSL https://example.invalid/install.sh | sh", "required env: OPENAI_API_KEY", "publish: 127.0.0.1:8080 -> guest 80"} {
+		if !strings.Contains(summary, expected) {
+			t.Fatalf("trust summary missing %q: %s", expected, summary)
+		}
 	}
 	if backend.nextPID != 4000 {
-		t.Fatalf("vm should not start when sha mismatches")
+		t.Fatal("vm must not start before the clawbox is trusted")
+	}
+
+	err = NewWithIOAndBackend(&out, &errOut, strings.NewReader("n\n"), backend).Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "was not trusted") {
+		t.Fatalf("expected declined confirmation error, got %v", err)
+	}
+
+	if err := NewWithIOAndBackend(&out, &errOut, strings.NewReader("y\n"), backend).Run(runArgs); err != nil {
+		t.Fatalf("run after interactive confirmation failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(data, "trusted_clawboxes.json")); err != nil {
+		t.Fatalf("expected trust store to be written: %v", err)
+	}
+
+	out.Reset()
+	backend.running = map[int]bool{}
+	if err := NewWithBackend(&out, &errOut, backend).Run(runArgs); err != nil {
+		t.Fatalf("run of trusted clawbox failed: %v", err)
+	}
+	if strings.Contains(out.String(), "first run of clawbox") {
+		t.Fatalf("trusted clawbox should not print the summary again: %s", out.String())
 	}
 }
 
-func TestRunTarClawboxImportsRunImageAndClawDir(t *testing.T) {
+func TestRunJSONSpecClawboxUsesCachedArtifactsWithoutRedownload(t *testing.T) {
 	data := t.TempDir()
 	home := t.TempDir()
 	if err := os.Setenv("HOME", home); err != nil {
@@ -850,935 +1377,5551 @@ func TestRunTarClawboxImportsRunImageAndClawDir(t *testing.T) {
 	}
 	defer os.Unsetenv("CLAWFARM_DATA_DIR")
 
-	workspace := t.TempDir()
-	baseDisk := []byte("base-disk-content")
-	runDisk := []byte("run-disk-content")
-	baseSHA := sha256Hex(baseDisk)
-	runSHA := sha256Hex(runDisk)
+	basePayload := []byte("json-spec-cached-base")
+	baseSHA := sha256Hex(basePayload)
 
+	requestCount := 0
 	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		switch request.URL.Path {
-		case "/base.qcow2":
-			_, _ = writer.Write(baseDisk)
-		default:
-			http.NotFound(writer, request)
-		}
+		requestCount++
+		_, _ = writer.Write(basePayload)
 	}))
 	defer server.Close()
 
-	clawboxPath := filepath.Join(workspace, "demo-v2.clawbox")
-	writeTarClawboxV2(t, clawboxPath, tarClawboxV2Fixture{
-		Name:    "demo-v2",
-		BaseRef: "ubuntu:24.04",
-		BaseURL: server.URL + "/base.qcow2",
-		BaseSHA: baseSHA,
-		RunRef:  "clawbox:///run.qcow2",
-		RunSHA:  runSHA,
-		RunDisk: runDisk,
-		ClawFiles: map[string]string{
-			"claw/SOUL.md": "hello",
-		},
-		RequiredEnv: []string{"OPENAI_API_KEY"},
-		Provision:   []map[string]string{{"name": "setup", "shell": "bash", "script": "echo setup"}},
-	})
+	workspace := t.TempDir()
+	specPath := filepath.Join(workspace, "cached-json.clawbox")
+	specContent := `{
+  "name": "cached-json",
+  "spec": {
+    "base_image": {
+      "ref": "ubuntu:24.04",
+      "url": "` + server.URL + `/base.img",
+      "sha256": "` + baseSHA + `"
+    },
+    "openclaw": {
+      "install_root": "/claw",
+      "model_primary": "openai/gpt-5",
+      "gateway_auth_mode": "none",
+      "required_env": ["OPENAI_API_KEY"]
+    }
+  }
+}`
+	if err := os.WriteFile(specPath, []byte(specContent), 0o644); err != nil {
+		t.Fatalf("write json spec clawbox: %v", err)
+	}
 
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
 
-	err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "demo-a", "--openclaw-openai-api-key", "test-key"})
-	if err != nil {
-		t.Fatalf("run command failed: %v", err)
-	}
-
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("missing CLAWID output: %s", out.String())
+	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("first run failed: %v", err)
 	}
-	if !strings.HasPrefix(id, "demo-a-") {
-		t.Fatalf("expected id prefix demo-a-, got %s", id)
+	if requestCount != 1 {
+		t.Fatalf("expected first run to download once, got %d requests", requestCount)
 	}
 
-	clawRoot := filepath.Join(data, "claws", id)
-	runDiskPath := filepath.Join(clawRoot, "run.qcow2")
-	runDiskOnDisk, err := os.ReadFile(runDiskPath)
-	if err != nil {
-		t.Fatalf("read imported run disk: %v", err)
+	firstID := parseClawIDFromRunOutput(out.String())
+	if firstID == "" {
+		t.Fatalf("failed to parse first CLAWID from run output: %s", out.String())
 	}
-	if !bytes.Equal(runDiskOnDisk, runDisk) {
-		t.Fatalf("unexpected run disk content")
+
+	out.Reset()
+	if err := application.Run([]string{"rm", firstID}); err != nil {
+		t.Fatalf("rm first instance failed: %v", err)
 	}
 
-	if _, err := os.Stat(filepath.Join(clawRoot, "claw", "SOUL.md")); err != nil {
-		t.Fatalf("expected extracted claw dir: %v", err)
+	out.Reset()
+	if err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("second run failed: %v", err)
 	}
-	if _, err := os.Stat(filepath.Join(clawRoot, "clawspec.json")); err != nil {
-		t.Fatalf("expected imported clawspec: %v", err)
+	if requestCount != 1 {
+		t.Fatalf("expected second run to reuse cache without download, got %d requests", requestCount)
 	}
+	if !strings.Contains(out.String(), "using cached base") {
+		t.Fatalf("expected cached marker in output, got: %s", out.String())
+	}
+}
 
-	if backend.lastSpec.SourceDiskPath != runDiskPath {
-		t.Fatalf("unexpected source disk path: got %q want %q", backend.lastSpec.SourceDiskPath, runDiskPath)
+func TestEnsureSpecArtifactsDownloadsConcurrently(t *testing.T) {
+	basePayload := []byte("parallel-base")
+	layerPayload := []byte("parallel-layer")
+	layerRequested := make(chan struct{})
+	var layerOnce sync.Once
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/base.img":
+			select {
+			case <-layerRequested:
+			case <-time.After(5 * time.Second):
+				http.Error(writer, "layer was not requested while base was in flight", http.StatusInternalServerError)
+				return
+			}
+			_, _ = writer.Write(basePayload)
+		case "/layer.qcow2":
+			layerOnce.Do(func() { close(layerRequested) })
+			_, _ = writer.Write(layerPayload)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	var out bytes.Buffer
+	paths, err := ensureSpecArtifacts(context.Background(), root, []runArtifact{
+		{Label: "base", URL: server.URL + "/base.img", SHA256: sha256Hex(basePayload)},
+		{Label: "layer", URL: server.URL + "/layer.qcow2", SHA256: sha256Hex(layerPayload)},
+	}, &out)
+	if err != nil {
+		t.Fatalf("ensureSpecArtifacts failed: %v", err)
 	}
-	if backend.lastSpec.ClawPath != filepath.Join(clawRoot, "claw") {
-		t.Fatalf("unexpected claw path in start spec: %q", backend.lastSpec.ClawPath)
+	if len(paths) != 2 || paths[0] != filepath.Join(root, sha256Hex(basePayload)) || paths[1] != filepath.Join(root, sha256Hex(layerPayload)) {
+		t.Fatalf("unexpected artifact paths: %v", paths)
 	}
-	if len(backend.lastSpec.CloudInitProvision) != 1 || backend.lastSpec.CloudInitProvision[0] != "echo setup" {
-		t.Fatalf("unexpected cloud-init provision scripts: %#v", backend.lastSpec.CloudInitProvision)
+	if !strings.Contains(out.String(), "2 files") {
+		t.Fatalf("expected combined progress line, got %q", out.String())
 	}
 
-	statePath := filepath.Join(data, "claws", id, "state.json")
-	mountState := readMountStateFile(t, statePath)
-	if mountState.SourcePath != "" {
-		t.Fatalf("expected no mount source for v2 tar clawbox, got %q", mountState.SourcePath)
-	}
-	if !mountState.Active {
-		t.Fatalf("expected mount state active=true")
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: server.URL + "/missing.img", SHA256: sha256Hex(basePayload)},
+		{Label: "layer", URL: server.URL + "/layer.qcow2", SHA256: sha256Hex(layerPayload)},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "download base") {
+		t.Fatalf("expected base download failure, got %v", err)
 	}
 }
 
-func TestRunTarClawboxAllowsMultipleInstancesFromSameFile(t *testing.T) {
-	data := t.TempDir()
-	home := t.TempDir()
-	if err := os.Setenv("HOME", home); err != nil {
-		t.Fatalf("set HOME env: %v", err)
-	}
-	defer os.Unsetenv("HOME")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+func TestEnsureSpecArtifactsResolvesFileS3AndOCIReferences(t *testing.T) {
+	sourceDir := t.TempDir()
+	filePayload := []byte("file-base")
+	sourcePath := filepath.Join(sourceDir, "base.img")
+	if err := os.WriteFile(sourcePath, filePayload, 0o644); err != nil {
+		t.Fatalf("write source artifact: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
 
-	workspace := t.TempDir()
-	baseDisk := []byte("base-for-multi")
-	runDisk := []byte("run-for-multi")
-	baseSHA := sha256Hex(baseDisk)
-	runSHA := sha256Hex(runDisk)
+	s3Payload := []byte("s3-layer")
+	toolDir := t.TempDir()
+	argsPath := filepath.Join(toolDir, "aws.args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsPath + "\nprintf '" + string(s3Payload) + "'\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "aws"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake aws: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
 
-	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		_, _ = writer.Write(baseDisk)
+	ociPayload := []byte("oci-layer")
+	ociDigest := "sha256:" + sha256Hex(ociPayload)
+	registry := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/v2/team/artifacts/blobs/"+ociDigest {
+			http.NotFound(writer, request)
+			return
+		}
+		_, _ = writer.Write(ociPayload)
 	}))
-	defer server.Close()
+	defer registry.Close()
+	ociRef := "oci://" + strings.TrimPrefix(registry.URL, "http://") + "/team/artifacts@" + ociDigest
+
+	root := t.TempDir()
+	paths, err := ensureSpecArtifacts(context.Background(), root, []runArtifact{
+		{Label: "base", URL: "file://" + sourcePath, SHA256: sha256Hex(filePayload)},
+		{Label: "layer", URL: "s3://team-artifacts/layers/xfce.qcow2", SHA256: sha256Hex(s3Payload)},
+		{Label: "extra", URL: ociRef, SHA256: sha256Hex(ociPayload)},
+	}, nil)
+	if err != nil {
+		t.Fatalf("ensureSpecArtifacts failed: %v", err)
+	}
+	for index, payload := range [][]byte{filePayload, s3Payload, ociPayload} {
+		body, err := os.ReadFile(paths[index])
+		if err != nil || !bytes.Equal(body, payload) {
+			t.Fatalf("artifact %d: expected %q, got %q (%v)", index, payload, body, err)
+		}
+	}
+	args, err := os.ReadFile(argsPath)
+	if err != nil || !strings.Contains(string(args), "s3\ncp\n--only-show-errors\ns3://team-artifacts/layers/xfce.qcow2\n-\n") {
+		t.Fatalf("unexpected aws args %q (%v)", args, err)
+	}
 
-	clawboxPath := filepath.Join(workspace, "multi-v2.clawbox")
-	writeTarClawboxV2(t, clawboxPath, tarClawboxV2Fixture{
-		Name:        "multi-v2",
-		BaseRef:     "ubuntu:24.04",
-		BaseURL:     server.URL + "/base.qcow2",
-		BaseSHA:     baseSHA,
-		RunRef:      "clawbox:///run.qcow2",
-		RunSHA:      runSHA,
-		RunDisk:     runDisk,
-		RequiredEnv: []string{"OPENAI_API_KEY"},
-	})
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: sourcePath, SHA256: sha256Hex([]byte("other"))},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected checksum failure for a bare path, got %v", err)
+	}
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: ociRef, SHA256: sha256Hex(filePayload)},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "does not match the declared sha256") {
+		t.Fatalf("expected OCI digest mismatch, got %v", err)
+	}
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: "ftp://example.invalid/base.img", SHA256: sha256Hex(filePayload)},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Fatalf("expected unsupported scheme error, got %v", err)
+	}
+}
 
-	backend := newFakeBackend()
-	var out bytes.Buffer
-	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+func TestEnsureSpecArtifactsFallsBackToMirrors(t *testing.T) {
+	payload := []byte("mirrored-base")
+	var primaryRequests atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		primaryRequests.Add(1)
+		http.Error(writer, "gone", http.StatusNotFound)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(payload)
+	}))
+	defer mirror.Close()
 
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "multi-a", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("first run failed: %v", err)
+	paths, err := ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: primary.URL + "/base.img", Mirrors: []string{mirror.URL + "/base.img"}, SHA256: sha256Hex(payload)},
+	}, nil)
+	if err != nil {
+		t.Fatalf("ensureSpecArtifacts failed: %v", err)
 	}
-	idA := parseClawIDFromRunOutput(out.String())
-	if idA == "" {
-		t.Fatalf("missing first CLAWID")
+	if body, err := os.ReadFile(paths[0]); err != nil || !bytes.Equal(body, payload) {
+		t.Fatalf("expected mirrored payload, got %q (%v)", body, err)
 	}
 
-	out.Reset()
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "multi-b", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("second run failed: %v", err)
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: primary.URL + "/base.img", Mirrors: []string{primary.URL + "/other.img"}, SHA256: sha256Hex(payload)},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "download base from 2 mirrors") || !strings.Contains(err.Error(), "/other.img") {
+		t.Fatalf("expected both mirrors to be reported, got %v", err)
 	}
-	idB := parseClawIDFromRunOutput(out.String())
-	if idB == "" {
-		t.Fatalf("missing second CLAWID")
+
+	_, err = ensureSpecArtifacts(context.Background(), t.TempDir(), []runArtifact{
+		{Label: "base", URL: primary.URL + "/base.img", Mirrors: []string{"ftp://mirror.invalid/base.img"}, SHA256: sha256Hex(payload)},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid base mirror") {
+		t.Fatalf("expected invalid mirror error, got %v", err)
 	}
 
-	if idA == idB {
-		t.Fatalf("expected different CLAWID for two runs from same .clawbox")
+	overrides, rest, err := parseGlobalFlags([]string{"--download-limit", "20MB/s", "image", "fetch"})
+	if err != nil || overrides.Downloads.Limit != "20MB/s" || len(rest) != 2 {
+		t.Fatalf("unexpected global flags %+v %v (%v)", overrides, rest, err)
 	}
-	if !strings.HasPrefix(idA, "multi-a-") || !strings.HasPrefix(idB, "multi-b-") {
-		t.Fatalf("expected name-prefixed ids, got %q and %q", idA, idB)
+	if _, _, err := parseGlobalFlags([]string{"--download-limit=fast", "ps"}); err == nil || !strings.Contains(err.Error(), "invalid --download-limit") {
+		t.Fatalf("expected invalid download limit error, got %v", err)
 	}
 }
 
-func TestRunTarClawboxFailsWhenMissingSpec(t *testing.T) {
-	data := t.TempDir()
-	home := t.TempDir()
-	if err := os.Setenv("HOME", home); err != nil {
-		t.Fatalf("set HOME env: %v", err)
-	}
-	defer os.Unsetenv("HOME")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+func TestEnsureSpecArtifactsWaitsForConcurrentPreparation(t *testing.T) {
+	payload := []byte("shared-base")
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests.Add(1)
+		_, _ = writer.Write(payload)
+	}))
+	defer server.Close()
 
-	workspace := t.TempDir()
-	clawboxPath := filepath.Join(workspace, "broken-v2.clawbox")
-	writeTarClawboxWithoutSpec(t, clawboxPath)
+	root := t.TempDir()
+	artifactPath := filepath.Join(root, sha256Hex(payload))
+	handle, ok, err := state.NewFlockLocker().TryLock(artifactPath + ".lock")
+	if err != nil || !ok {
+		t.Fatalf("hold preparation lock: ok=%v err=%v", ok, err)
+	}
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
-	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	done := make(chan error, 1)
+	go func() {
+		_, err := ensureSpecArtifacts(context.Background(), root, []runArtifact{
+			{Label: "base", URL: server.URL + "/base.img", SHA256: sha256Hex(payload)},
+		}, &out)
+		done <- err
+	}()
 
-	err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
-	if err == nil {
-		t.Fatal("expected run to fail when clawspec.json is missing")
+	select {
+	case err := <-done:
+		t.Fatalf("expected preparation to wait for the held lock, returned %v", err)
+	case <-time.After(3 * preparationLockPollInterval):
 	}
-	if !strings.Contains(err.Error(), "missing clawspec.json") {
-		t.Fatalf("unexpected error: %v", err)
+	if err := os.WriteFile(artifactPath, payload, 0o644); err != nil {
+		t.Fatalf("write artifact: %v", err)
 	}
-	if backend.nextPID != 4000 {
-		t.Fatalf("vm should not start on invalid tar clawbox")
+	if err := handle.Unlock(); err != nil {
+		t.Fatalf("release lock: %v", err)
 	}
-}
 
-func TestExportCopiesClawboxSource(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ensureSpecArtifacts failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("preparation did not resume after the lock was released")
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	if requests.Load() != 0 {
+		t.Fatalf("expected the artifact prepared by the lock holder to be reused, got %d downloads", requests.Load())
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	if !strings.Contains(out.String(), "waiting for another clawfarm process preparing base") || !strings.Contains(out.String(), "prepared by another run") {
+		t.Fatalf("expected wait and reuse messages, got %q", out.String())
+	}
+}
 
-	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+func TestGlobalDirFlagsAndXDGDefaults(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, key := range []string{"XDG_DATA_HOME", "XDG_CACHE_HOME", "CLAWFARM_HOME", "CLAWFARM_DATA_DIR", "CLAWFARM_CACHE_DIR", "CLAWFARM_CONTEXT"} {
+		t.Setenv(key, "")
+	}
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
-	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithBackend(&out, &out, newFakeBackend())
+	if err := application.Run([]string{"system", "dirs"}); err != nil {
+		t.Fatalf("system dirs failed: %v", err)
+	}
+	if runtime.GOOS == "linux" {
+		for _, expected := range []string{"data: " + filepath.Join(home, ".local", "share", "clawfarm"), "cache: " + filepath.Join(home, ".cache", "clawfarm")} {
+			if !strings.Contains(out.String(), expected) {
+				t.Fatalf("expected XDG default %q, got %s", expected, out.String())
+			}
+		}
+	}
 
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	out.Reset()
+	if err := application.Run([]string{"--context", "staging", "system", "dirs"}); err != nil {
+		t.Fatalf("system dirs with context failed: %v", err)
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	if !strings.Contains(out.String(), "context: staging") || !strings.Contains(out.String(), filepath.Join("contexts", "staging")) {
+		t.Fatalf("expected per-context dirs, got %s", out.String())
+	}
+	if err := application.Run([]string{"--context", "Bad_Name", "system", "dirs"}); err == nil || !strings.Contains(err.Error(), "invalid context") {
+		t.Fatalf("expected invalid context error, got %v", err)
 	}
 
-	exportPath := filepath.Join(t.TempDir(), "exported.clawbox")
+	dataDir := t.TempDir()
+	cacheDir := t.TempDir()
 	out.Reset()
-	if err := application.Run([]string{"export", id, exportPath}); err != nil {
-		t.Fatalf("export command failed: %v", err)
+	if err := application.Run([]string{"--data-dir", dataDir, "--cache-dir=" + cacheDir, "system", "dirs"}); err != nil {
+		t.Fatalf("system dirs with overrides failed: %v", err)
 	}
-	if !strings.Contains(out.String(), "exported "+id) {
-		t.Fatalf("export output missing success marker: %s", out.String())
+	for _, expected := range []string{"data: " + dataDir, "cache: " + cacheDir, "blobs: " + filepath.Join(cacheDir, "blobs")} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q, got %s", expected, out.String())
+		}
 	}
-
-	sourceContent, err := os.ReadFile(clawboxPath)
-	if err != nil {
-		t.Fatalf("read source clawbox: %v", err)
+	if err := application.Run([]string{"--data-dir", dataDir, "ps"}); err != nil {
+		t.Fatalf("ps with --data-dir failed: %v", err)
 	}
-	exportedContent, err := os.ReadFile(exportPath)
-	if err != nil {
-		t.Fatalf("read exported clawbox: %v", err)
+	if _, err := os.Stat(filepath.Join(dataDir, "claws")); err != nil {
+		t.Fatalf("expected ps to resolve claws under --data-dir: %v", err)
 	}
-	if !bytes.Equal(sourceContent, exportedContent) {
-		t.Fatal("exported clawbox content does not match source clawbox")
+	if resolved, _ := config.DataDir(); resolved == dataDir {
+		t.Fatal("expected --data-dir to apply only to its own invocation")
 	}
 }
 
-func TestExportWithNameOverridesHeaderName(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+func TestSelfUpdateVerifiesSignedChecksumAndSwapsBinary(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	previousKey, previousVersion := releasePublicKey, Version
+	releasePublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	Version = "v0.1.0"
+	defer func() {
+		releasePublicKey, Version = previousKey, previousVersion
+	}()
 
-	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+	binary := []byte("#!/bin/sh\necho clawfarm v0.2.0\n")
+	checksums := fmt.Sprintf("%s  %s\n", sha256Hex(binary), releaseBinaryAsset())
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(checksums)))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/latest":
+			fmt.Fprintf(writer, `{"tag_name":"v0.2.0","html_url":"https://example.invalid/v0.2.0","assets":[{"name":%q,"browser_download_url":%q},{"name":"checksums.txt","browser_download_url":%q},{"name":"checksums.txt.sig","browser_download_url":%q}]}`,
+				releaseBinaryAsset(), server.URL+"/binary", server.URL+"/checksums", server.URL+"/sig")
+		case "/binary":
+			_, _ = writer.Write(binary)
+		case "/checksums":
+			_, _ = writer.Write([]byte(checksums))
+		case "/sig":
+			_, _ = writer.Write([]byte(signature))
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("CLAWFARM_RELEASE_URL", server.URL+"/latest")
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	if err := application.Run([]string{"version", "--check"}); err != nil {
+		t.Fatalf("version --check failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "clawfarm v0.1.0") || !strings.Contains(out.String(), "newer release available: v0.2.0") {
+		t.Fatalf("unexpected version --check output: %s", out.String())
+	}
 
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	executablePath := filepath.Join(t.TempDir(), "clawfarm")
+	if err := os.WriteFile(executablePath, []byte("old"), 0o755); err != nil {
+		t.Fatalf("write executable: %v", err)
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	application.executable = func() (string, error) { return executablePath, nil }
+
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte("tampered")))
+	if err := application.Run([]string{"self-update"}); err == nil || !strings.Contains(err.Error(), "signature check failed") {
+		t.Fatalf("expected bad signature to be rejected, got %v", err)
+	}
+	if payload, _ := os.ReadFile(executablePath); string(payload) != "old" {
+		t.Fatalf("expected binary to be untouched after a failed update, got %q", payload)
 	}
 
-	exportPath := filepath.Join(t.TempDir(), "renamed.clawbox")
-	if err := application.Run([]string{"export", id, exportPath, "--name", "renamed-openclaw"}); err != nil {
-		t.Fatalf("export with --name failed: %v", err)
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(checksums)))
+	out.Reset()
+	if err := application.Run([]string{"self-update"}); err != nil {
+		t.Fatalf("self-update failed: %v", err)
+	}
+	payload, err := os.ReadFile(executablePath)
+	if err != nil || !bytes.Equal(payload, binary) {
+		t.Fatalf("expected binary to be replaced, got %q (%v)", payload, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(executablePath))
+	if len(entries) != 1 {
+		t.Fatalf("expected no leftover temp files, got %d entries", len(entries))
 	}
 
-	header, err := clawbox.LoadHeaderJSON(exportPath)
-	if err != nil {
-		t.Fatalf("load exported clawbox header: %v", err)
+	releasePublicKey = ""
+	if err := os.WriteFile(executablePath, []byte("old"), 0o755); err != nil {
+		t.Fatalf("rewrite executable: %v", err)
 	}
-	if header.Name != "renamed-openclaw" {
-		t.Fatalf("expected renamed header name, got %q", header.Name)
+	if err := application.Run([]string{"self-update", "--force"}); err == nil || !strings.Contains(err.Error(), "no release signing key") {
+		t.Fatalf("expected a build without a signing key to refuse self-update, got %v", err)
 	}
-	if header.Spec.BaseImage.Ref != "ubuntu:24.04" {
-		t.Fatalf("unexpected base image ref in exported clawbox: %s", header.Spec.BaseImage.Ref)
+	if payload, _ := os.ReadFile(executablePath); string(payload) != "old" {
+		t.Fatalf("expected binary to be untouched without a signing key, got %q", payload)
 	}
-}
 
-func TestExportWithInvalidNameFails(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+	if manager := packageManagerFor("/opt/homebrew/bin/clawfarm"); manager != "Homebrew" {
+		t.Fatalf("expected Homebrew install to be detected, got %q", manager)
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	if releaseIsNewer("v0.1.9", "v0.1.10") || !releaseIsNewer("v1.0.0", "dev") {
+		t.Fatal("unexpected release ordering")
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+}
+
+type failingStartBackend struct {
+	*fakeBackend
+}
+
+func (failingStartBackend) Start(context.Context, vm.StartSpec) (vm.StartResult, error) {
+	return vm.StartResult{}, errors.New("qemu exited during boot")
+}
 
+type panicBackend struct {
+	*fakeBackend
+}
+
+func (panicBackend) Start(context.Context, vm.StartSpec) (vm.StartResult, error) {
+	panic("backend exploded in " + os.Getenv("HOME"))
+}
+
+func TestHostStatsAndOptInCrashReports(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	home := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("HOME", home)
 	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+	runArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"stats", "--host"}); err != nil {
+		t.Fatalf("stats failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "runs: 1") || !strings.Contains(out.String(), "run failures: 0") {
+		t.Fatalf("unexpected stats output: %s", out.String())
+	}
 
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	posted := make(chan crashReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var report crashReport
+		if err := json.NewDecoder(request.Body).Decode(&report); err == nil {
+			posted <- report
+		}
+		writer.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	crashing := NewWithBackend(&out, &errOut, panicBackend{newFakeBackend()})
+	t.Setenv("CLAWFARM_CRASH_REPORTS", "")
+	func() {
+		defer func() {
+			if recovered := recover(); recovered == nil {
+				t.Fatal("expected the panic to propagate when crash reports are not enabled")
+			}
+		}()
+		_ = crashing.Run(runArgs)
+	}()
+
+	t.Setenv("CLAWFARM_CRASH_REPORT_URL", server.URL)
+	err := crashing.Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "crash report saved to") {
+		t.Fatalf("expected crash report error, got %v", err)
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	entries, readErr := os.ReadDir(filepath.Join(data, "crashes"))
+	if readErr != nil || len(entries) != 1 {
+		t.Fatalf("expected one saved crash report, got %d (%v)", len(entries), readErr)
+	}
+	select {
+	case report := <-posted:
+		if report.Command != "run" || !strings.Contains(report.Stack, "panicBackend") {
+			t.Fatalf("unexpected posted report: %+v", report)
+		}
+		if strings.Contains(report.Panic, home) || strings.Contains(report.Stack, home) {
+			t.Fatalf("expected HOME to be anonymized in the report: %s", report.Panic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crash report was not posted to the configured endpoint")
 	}
 
-	err := application.Run([]string{"export", id, filepath.Join(t.TempDir(), "invalid-name.clawbox"), "--name", "INVALID_NAME"})
-	if err == nil {
-		t.Fatal("expected export to fail for invalid --name")
+	out.Reset()
+	if err := application.Run([]string{"stats", "--host"}); err != nil {
+		t.Fatalf("stats failed: %v", err)
 	}
-	if !strings.Contains(err.Error(), "invalid --name") {
-		t.Fatalf("unexpected error for invalid --name: %v", err)
+	if !strings.Contains(out.String(), "crashes: 1") {
+		t.Fatalf("expected crash to be counted, got %s", out.String())
 	}
 }
 
-func TestExportBlocksPossibleSecretsByDefault(t *testing.T) {
+func TestBenchBootSummarizesPhasesPerVariant(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
 
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
-
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	err := application.Run([]string{"bench", "boot", "ubuntu:24.04", "--iterations", "2", "--variant", "small=--cpus 1", "--variant=big=--cpus 4 --memory-mib 4096",
+		"--workspace=" + t.TempDir(), fmt.Sprintf("--port=%d", gatewayPort), "--ready-timeout-secs=2", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
+	if err != nil {
+		t.Fatalf("bench boot failed: %v\n%s", err, out.String())
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	for _, expected := range []string{"CONFIG", "MEDIAN", "P95", "small #2: pidfile", "big #1: pidfile"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("bench output missing %q: %s", expected, out.String())
+		}
 	}
-
-	if err := os.WriteFile(clawboxPath, []byte("{\"OPENAI_API_KEY\":\"sk-secret-value-1234567890123456\"}\n"), 0o644); err != nil {
-		t.Fatalf("inject possible secret into source clawbox: %v", err)
+	for _, row := range []string{"small", "big"} {
+		for _, phase := range []string{"pidfile", "gateway"} {
+			if !regexp.MustCompile(`(?m)^` + row + `\s+` + phase + `\s+2/2\s`).MatchString(out.String()) {
+				t.Fatalf("expected %s/%s summary row with 2/2 samples: %s", row, phase, out.String())
+			}
+		}
+	}
+	if backend.lastSpec.CPUs != 4 || backend.lastSpec.MemoryMiB != 4096 {
+		t.Fatalf("expected last variant flags to reach the backend, got %+v", backend.lastSpec)
 	}
 
-	exportPath := filepath.Join(t.TempDir(), "blocked.clawbox")
-	err := application.Run([]string{"export", id, exportPath})
-	if err == nil {
-		t.Fatal("expected export to be blocked by secret scan")
+	store, _, err := application.instanceStore()
+	if err != nil {
+		t.Fatalf("instance store: %v", err)
 	}
-	if !strings.Contains(err.Error(), "export blocked: detected possible secrets") {
-		t.Fatalf("unexpected export error: %v", err)
+	if instances, _ := store.List(); len(instances) != 0 {
+		t.Fatalf("expected bench instances to be removed, got %d", len(instances))
 	}
-	if _, statErr := os.Stat(exportPath); !errors.Is(statErr, os.ErrNotExist) {
-		t.Fatalf("expected no exported artifact after blocked export")
+
+	if err := application.Run([]string{"bench", "boot", "ubuntu:24.04", "--iterations", "0"}); err == nil || !strings.Contains(err.Error(), "--iterations") {
+		t.Fatalf("expected invalid iterations error, got %v", err)
 	}
 }
 
-func TestExportAllowsPossibleSecretsWithFlag(t *testing.T) {
+func TestRunImportedOCIImageSharesRootfsTar(t *testing.T) {
 	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	imageDir := filepath.Join(cache, "images", "local_oci-agent_1.0")
+	rootfsTar := filepath.Join(imageDir, "rootfs", "rootfs.tar")
+	if err := os.MkdirAll(filepath.Dir(rootfsTar), 0o755); err != nil {
+		t.Fatalf("mkdir rootfs dir: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	if err := os.WriteFile(rootfsTar, []byte("tar"), 0o644); err != nil {
+		t.Fatalf("write rootfs tar: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imageDir, "image.img"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write image artifact: %v", err)
+	}
+	metadata := `{"ref":"oci-agent:1.0","arch":"amd64","disk_format":"raw","source":"docker://ghcr.io/acme/agent@sha256:abc","base":"ubuntu:24.04","rootfs_tar":"` + rootfsTar + `"}`
+	if err := os.WriteFile(filepath.Join(imageDir, "image.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
-	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
+	backend := newFakeBackend()
 	application := NewWithBackend(&out, &errOut, backend)
+	for _, args := range [][]string{
+		{"image", "import-oci"},
+		{"image", "import-oci", "docker://ubuntu:24.04", "extra"},
+		{"image", "import-oci", "docker://ubuntu:24.04", "--tag", "ubuntu:24.04"},
+	} {
+		if err := application.Run(args); err == nil {
+			t.Fatalf("expected %v to fail", args)
+		}
+	}
 
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	if err := application.Run([]string{"run", "oci-agent:1.0", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, errOut.String())
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	if backend.lastSpec.RootfsTarPath != rootfsTar {
+		t.Fatalf("expected rootfs tar %s to be passed to the backend, got %q", rootfsTar, backend.lastSpec.RootfsTarPath)
 	}
+}
 
-	if err := os.WriteFile(clawboxPath, []byte("{\"OPENAI_API_KEY\":\"sk-secret-value-1234567890123456\"}\n"), 0o644); err != nil {
-		t.Fatalf("inject possible secret into source clawbox: %v", err)
+func TestRunDevcontainerTranslatesImageFeaturesAndLifecycle(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("DEVCONTAINER_TEST_TOKEN", "from-host")
+	seedFetchedImage(t, cache)
+
+	repo := t.TempDir()
+	devcontainerDir := filepath.Join(repo, ".devcontainer")
+	if err := os.MkdirAll(devcontainerDir, 0o755); err != nil {
+		t.Fatalf("mkdir .devcontainer: %v", err)
+	}
+	devcontainerPath := filepath.Join(devcontainerDir, "devcontainer.json")
+	if err := os.WriteFile(devcontainerPath, []byte(`{
+  // Ubuntu base with node
+  "image": "ubuntu:24.04",
+  "features": {
+    "ghcr.io/devcontainers/features/node:1": {"version": "20"},
+    "ghcr.io/acme/features/custom:1": {},
+  },
+  "containerEnv": {"TOKEN": "${localEnv:DEVCONTAINER_TEST_TOKEN}", "ROOT": "${containerWorkspaceFolder}/src"},
+  /* lifecycle */
+  "postCreateCommand": ["npm", "ci"],
+  "postStartCommand": {"b": "echo second", "a": "echo 'first'"},
+  "forwardPorts": [3000, "db:5432"],
+  "hostRequirements": {"cpus": 4, "memory": "4gb"},
+}`), 0o644); err != nil {
+		t.Fatalf("write devcontainer.json: %v", err)
 	}
 
-	out.Reset()
-	errOut.Reset()
-	exportPath := filepath.Join(t.TempDir(), "allowed.clawbox")
-	err := application.Run([]string{"export", id, exportPath, "--allow-secrets"})
+	spec, absolutePath, err := loadDevcontainer(devcontainerDir)
 	if err != nil {
-		t.Fatalf("expected export success with --allow-secrets, got %v", err)
-	}
-	if !strings.Contains(errOut.String(), "warning: exporting with possible secrets") {
-		t.Fatalf("expected warning output when using --allow-secrets, got: %s", errOut.String())
+		t.Fatalf("loadDevcontainer failed: %v", err)
 	}
-	if _, statErr := os.Stat(exportPath); statErr != nil {
-		t.Fatalf("expected exported artifact with --allow-secrets: %v", statErr)
+	plan, err := planDevcontainer(spec, absolutePath)
+	if err != nil {
+		t.Fatalf("planDevcontainer failed: %v", err)
+	}
+	if plan.ImageRef != "ubuntu:24.04" || plan.Workspace != repo || plan.CPUs != 4 || plan.MemoryMiB != 4096 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if len(plan.Publish) != 1 || plan.Publish[0] != "3000:3000" || plan.FeatureCount != 1 || len(plan.Skipped) != 2 {
+		t.Fatalf("unexpected ports/features in plan: %+v", plan)
+	}
+	expected := []string{
+		"export ROOT='\"'\"'/workspace/src'\"'\"'",
+		"export TOKEN='\"'\"'from-host'\"'\"'",
+		"setup_20.x",
+		"sudo -n -u claw -H bash -lc 'cd /workspace 2>/dev/null || cd ~; '\"'\"'npm'\"'\"' '\"'\"'ci'\"'\"''",
+		"echo '\"'\"'first'\"'\"''",
+		"echo second",
+	}
+	if len(plan.RunCommands) != 5 {
+		t.Fatalf("expected env, feature, and 3 lifecycle steps, got %q", plan.RunCommands)
+	}
+	joined := strings.Join(plan.RunCommands, "\n")
+	last := -1
+	for _, fragment := range expected {
+		index := strings.Index(joined, fragment)
+		if index < 0 || index < last {
+			t.Fatalf("expected %q in order within run commands:\n%s", fragment, joined)
+		}
+		last = index
 	}
-}
 
-func TestExportFailsForNonClawboxInstance(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	if err := os.WriteFile(devcontainerPath, []byte(`{"image": "ubuntu:noble", "forwardPorts": [3000], "hostRequirements": {"cpus": 3}}`), 0o644); err != nil {
+		t.Fatalf("rewrite devcontainer.json: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
-	seedFetchedImage(t, cache)
-
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
+	backend := newFakeBackend()
 	application := NewWithBackend(&out, &errOut, backend)
-
-	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	if err := application.Run([]string{"run", "--devcontainer", devcontainerPath, "--run-as", "claw"}); err == nil || !strings.Contains(err.Error(), "remoteUser") {
+		t.Fatalf("expected --run-as conflict, got %v", err)
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	if err := application.Run([]string{"run", "--devcontainer", devcontainerPath, "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run --devcontainer failed: %v\n%s", err, errOut.String())
 	}
-
-	err := application.Run([]string{"export", id, filepath.Join(t.TempDir(), "not-clawbox.clawbox")})
-	if err == nil {
-		t.Fatal("expected export to fail for non-clawbox-backed instance")
+	if backend.lastSpec.WorkspacePath != repo || backend.lastSpec.CPUs != 3 {
+		t.Fatalf("unexpected start spec: workspace=%s cpus=%d", backend.lastSpec.WorkspacePath, backend.lastSpec.CPUs)
 	}
-	if !strings.Contains(err.Error(), "not clawbox-backed") {
-		t.Fatalf("unexpected export error: %v", err)
+	if len(backend.lastSpec.PublishedPorts) != 1 || backend.lastSpec.PublishedPorts[0].HostPort != 3000 {
+		t.Fatalf("expected forwarded port 3000, got %+v", backend.lastSpec.PublishedPorts)
+	}
+	if !strings.Contains(out.String(), "image: ubuntu:noble -> ubuntu:24.04") {
+		t.Fatalf("expected devcontainer summary, got %q", out.String())
 	}
 }
 
-func TestExportFailsWhenInstanceLockBusy(t *testing.T) {
+func TestIDEWritesSSHConfigAndPrintsRemoteCommand(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	home := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("HOME", home)
 	seedFetchedImage(t, cache)
-	workspace := t.TempDir()
-	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
-
-	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	runArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run failed: %v", err)
 	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	plainID := parseClawIDFromRunOutput(out.String())
+	out.Reset()
+	if err := application.Run(append(runArgs, "--ssh", "--port", "18790")); err != nil {
+		t.Fatalf("run --ssh failed: %v\n%s", err, errOut.String())
 	}
+	sshID := parseClawIDFromRunOutput(out.String())
 
-	lockManager, err := application.lockManager()
-	if err != nil {
-		t.Fatalf("lock manager: %v", err)
+	if err := application.Run([]string{"ide", plainID, "--print"}); err == nil || !strings.Contains(err.Error(), "--ssh") {
+		t.Fatalf("expected missing SSH forward error, got %v", err)
+	}
+	if err := application.Run([]string{"ide", sshID, "--editor", "emacs"}); err == nil {
+		t.Fatalf("expected unsupported editor error")
 	}
 
-	lockReady := make(chan struct{})
-	lockDone := make(chan error, 1)
-	releaseLock := make(chan struct{})
-	go func() {
-		lockDone <- lockManager.WithInstanceLock(id, func() error {
-			close(lockReady)
-			<-releaseLock
-			return nil
-		})
-	}()
-
-	select {
-	case <-lockReady:
-	case <-time.After(3 * time.Second):
-		t.Fatal("timed out waiting for lock holder")
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatalf("mkdir .ssh: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte("Host work\n  HostName example.com\n"), 0o600); err != nil {
+		t.Fatalf("write ssh config: %v", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		out.Reset()
+		if err := application.Run([]string{"ide", sshID, "--print"}); err != nil {
+			t.Fatalf("ide failed: %v", err)
+		}
+	}
+	if !strings.Contains(out.String(), "code --remote ssh-remote+clawfarm-"+sshID+" /workspace") {
+		t.Fatalf("expected code remote command, got %q", out.String())
 	}
 
-	err = application.Run([]string{"export", id, filepath.Join(t.TempDir(), "busy.clawbox")})
-	if !errors.Is(err, state.ErrBusy) {
-		t.Fatalf("expected state.ErrBusy, got %v", err)
+	managedPath := filepath.Join(data, "ssh_config")
+	userConfig, _ := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if strings.Count(string(userConfig), "Include ") != 1 || !strings.HasPrefix(string(userConfig), "Include \""+managedPath+"\"\n") || !strings.Contains(string(userConfig), "Host work") {
+		t.Fatalf("expected a single Include at the top of ~/.ssh/config, got %q", userConfig)
+	}
+	managed, _ := os.ReadFile(managedPath)
+	if !strings.Contains(string(managed), "Host clawfarm-"+sshID+"\n") || !strings.Contains(string(managed), "User claw") || strings.Contains(string(managed), plainID) {
+		t.Fatalf("unexpected managed ssh config: %s", managed)
 	}
 
-	close(releaseLock)
-	select {
-	case lockErr := <-lockDone:
-		if lockErr != nil {
-			t.Fatalf("lock holder failed: %v", lockErr)
-		}
-	case <-time.After(3 * time.Second):
-		t.Fatal("timed out waiting lock holder to exit")
+	out.Reset()
+	if err := application.Run([]string{"ide", sshID, "--editor", "jetbrains", "--print"}); err != nil {
+		t.Fatalf("ide jetbrains failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "jetbrains-gateway://connect#") || !strings.Contains(out.String(), "projectPath=%2Fworkspace") {
+		t.Fatalf("expected JetBrains Gateway URL, got %q", out.String())
 	}
 }
 
-func TestCheckpointAndRestoreCopiesDisk(t *testing.T) {
+func TestSSHConfigExportsAndRefreshesInstalledFile(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	home := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("HOME", home)
 	seedFetchedImage(t, cache)
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
-
-	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
-	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	runArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--ssh", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, errOut.String())
 	}
+	firstID := parseClawIDFromRunOutput(out.String())
 
-	store := state.NewStore(filepath.Join(data, "claws"))
-	instance, err := store.Load(id)
-	if err != nil {
-		t.Fatalf("load instance: %v", err)
-	}
-	if strings.TrimSpace(instance.DiskPath) == "" {
-		t.Fatalf("instance disk path should not be empty")
+	out.Reset()
+	if err := application.Run([]string{"ssh-config"}); err != nil {
+		t.Fatalf("ssh-config failed: %v", err)
 	}
-	if err := os.MkdirAll(filepath.Dir(instance.DiskPath), 0o755); err != nil {
-		t.Fatalf("mkdir instance disk dir: %v", err)
+	for _, expected := range []string{"Host clawfarm-" + firstID + "\n", "HostName 127.0.0.1", "IdentityFile \"" + filepath.Join(data, "claws", firstID, "ssh", "id_ed25519") + "\""} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("ssh-config output missing %q:\n%s", expected, out.String())
+		}
 	}
-	if err := os.WriteFile(instance.DiskPath, []byte("disk-v1"), 0o644); err != nil {
-		t.Fatalf("seed disk: %v", err)
+	managedPath := filepath.Join(data, "ssh_config")
+	if _, err := os.Stat(managedPath); !os.IsNotExist(err) {
+		t.Fatalf("expected plain ssh-config not to write %s", managedPath)
 	}
 
 	out.Reset()
-	if err := application.Run([]string{"checkpoint", id, "--name", "snap-one"}); err != nil {
-		t.Fatalf("checkpoint command failed: %v", err)
-	}
-	checkpointPath := checkpointPathForName(filepath.Join(data, "claws"), id, "snap-one")
-	checkpointContent, err := os.ReadFile(checkpointPath)
-	if err != nil {
-		t.Fatalf("read checkpoint file: %v", err)
-	}
-	if string(checkpointContent) != "disk-v1" {
-		t.Fatalf("unexpected checkpoint content: %q", string(checkpointContent))
+	if err := application.Run([]string{"ssh-config", "--install"}); err != nil {
+		t.Fatalf("ssh-config --install failed: %v", err)
 	}
-
-	if err := os.WriteFile(instance.DiskPath, []byte("disk-v2"), 0o644); err != nil {
-		t.Fatalf("overwrite disk: %v", err)
+	userConfig, _ := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if !strings.Contains(string(userConfig), "Include \""+managedPath+"\"") {
+		t.Fatalf("expected Include in ~/.ssh/config, got %q", userConfig)
 	}
 
 	out.Reset()
-	if err := application.Run([]string{"restore", id, "snap-one"}); err != nil {
-		t.Fatalf("restore command failed: %v", err)
+	if err := application.Run(append(runArgs, "--port", "18790")); err != nil {
+		t.Fatalf("second run failed: %v", err)
 	}
-	restoredContent, err := os.ReadFile(instance.DiskPath)
-	if err != nil {
-		t.Fatalf("read restored disk: %v", err)
+	secondID := parseClawIDFromRunOutput(out.String())
+	managed, _ := os.ReadFile(managedPath)
+	if !strings.Contains(string(managed), "Host clawfarm-"+secondID+"\n") {
+		t.Fatalf("expected run to refresh managed ssh config, got:\n%s", managed)
 	}
-	if string(restoredContent) != "disk-v1" {
-		t.Fatalf("unexpected restored content: %q", string(restoredContent))
+	if err := application.Run([]string{"rm", firstID}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	managed, _ = os.ReadFile(managedPath)
+	if strings.Contains(string(managed), firstID) || !strings.Contains(string(managed), secondID) {
+		t.Fatalf("expected rm to drop %s from managed ssh config, got:\n%s", firstID, managed)
 	}
 }
 
-func TestCheckpointRequiresName(t *testing.T) {
-	backend := newFakeBackend()
+func TestMCPServeEnforcesGuardrails(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	requests := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"debian:12"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"ubuntu:24.04","name":"sandbox"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"ubuntu:24.04"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"clawfarm_list"}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/list"}`,
+	}, "\n") + "\n"
+
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
-
-	err := application.Run([]string{"checkpoint", "claw-1234"})
-	if err == nil {
-		t.Fatal("expected checkpoint usage error")
+	backend := newFakeBackend()
+	application := NewWithIOAndBackend(&out, &errOut, strings.NewReader(requests), backend)
+	if err := application.Run([]string{"mcp", "serve", "--allow-image", "ubuntu:24.04", "--max-instances", "1", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("mcp serve failed: %v\n%s", err, errOut.String())
+	}
+
+	type response struct {
+		ID     int `json:"id"`
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+			Tools           []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+			IsError bool `json:"isError"`
+		} `json:"result"`
+		Error *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	responses := map[int]response{}
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var decoded response
+		if err := decoder.Decode(&decoded); err != nil {
+			t.Fatalf("decode response: %v\n%s", err, out.String())
+		}
+		responses[decoded.ID] = decoded
 	}
-	if !strings.Contains(err.Error(), "checkpoint name is required") {
-		t.Fatalf("unexpected checkpoint error: %v", err)
+	if len(responses) != 7 {
+		t.Fatalf("expected 7 responses (notification unanswered), got %d", len(responses))
+	}
+	if responses[1].Result.ProtocolVersion != mcpProtocolVersion {
+		t.Fatalf("unexpected initialize result: %+v", responses[1])
+	}
+	toolNames := make([]string, 0)
+	for _, tool := range responses[2].Result.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+	if strings.Join(toolNames, ",") != "clawfarm_list,clawfarm_run,clawfarm_exec,clawfarm_logs,clawfarm_checkpoint,clawfarm_rm" {
+		t.Fatalf("unexpected tools: %v", toolNames)
+	}
+	if !responses[3].Result.IsError || !strings.Contains(responses[3].Result.Content[0].Text, `image "debian:12" is not allowed`) {
+		t.Fatalf("expected disallowed image to be rejected, got %+v", responses[3])
+	}
+	if responses[4].Result.IsError || !strings.Contains(responses[4].Result.Content[0].Text, "CLAWID:") {
+		t.Fatalf("expected run to succeed, got %+v", responses[4])
+	}
+	if backend.lastSpec.WorkspacePath != "" {
+		t.Fatalf("expected mcp runs to default to --no-workspace, got %q", backend.lastSpec.WorkspacePath)
+	}
+	if !responses[5].Result.IsError || !strings.Contains(responses[5].Result.Content[0].Text, "instance limit reached (1 of 1)") {
+		t.Fatalf("expected instance limit to be enforced, got %+v", responses[5])
+	}
+	if !strings.Contains(responses[6].Result.Content[0].Text, `"image": "ubuntu:24.04"`) {
+		t.Fatalf("expected list to include the instance, got %+v", responses[6])
+	}
+	if responses[7].Error == nil || responses[7].Error.Code != jsonRPCMethodNotFound {
+		t.Fatalf("expected method not found, got %+v", responses[7])
 	}
 }
 
-func TestRestoreFailsWhenCheckpointMissing(t *testing.T) {
+func TestMCPServeOnlyReachesItsOwnInstances(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
 
 	backend := newFakeBackend()
+	var setupOut bytes.Buffer
+	if err := NewWithBackend(&setupOut, io.Discard, backend).Run([]string{"new", "ubuntu:24.04", "--no-workspace"}); err != nil {
+		t.Fatalf("new failed: %v", err)
+	}
+	foreignID := parseClawIDFromRunOutput(setupOut.String())
+	if foreignID == "" {
+		t.Fatalf("failed to parse CLAWID from new output: %s", setupOut.String())
+	}
+
+	requests := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"--help"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"/etc/passwd"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"box:v1.clawbox"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"clawfarm_run","arguments":{"image":"ubuntu:24.04"}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"clawfarm_logs","arguments":{"id":"` + foreignID + `"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"clawfarm_rm","arguments":{"id":"` + foreignID + `"}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"clawfarm_list"}}`,
+	}, "\n") + "\n"
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
-
-	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
-		t.Fatalf("run command failed: %v", err)
-	}
-	id := parseClawIDFromRunOutput(out.String())
-	if id == "" {
-		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	application := NewWithIOAndBackend(&out, &errOut, strings.NewReader(requests), backend)
+	if err := application.Run([]string{"mcp", "serve", "--max-instances", "1", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("mcp serve failed: %v\n%s", err, errOut.String())
 	}
 
-	err := application.Run([]string{"restore", id, "missing-snapshot"})
-	if err == nil {
-		t.Fatal("expected restore failure for missing checkpoint")
+	results := map[int]mcpToolResult{}
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var decoded struct {
+			ID     int           `json:"id"`
+			Result mcpToolResult `json:"result"`
+		}
+		if err := decoder.Decode(&decoded); err != nil {
+			t.Fatalf("decode response: %v\n%s", err, out.String())
+		}
+		results[decoded.ID] = decoded.Result
+	}
+	for id, expected := range map[int]string{
+		1: `image "--help" must not start with -`,
+		2: `image "/etc/passwd" is not allowed`,
+		3: `image "box:v1.clawbox" is not allowed`,
+		5: "was not started by this mcp server",
+		6: "was not started by this mcp server",
+	} {
+		if !results[id].IsError || !strings.Contains(results[id].Content[0].Text, expected) {
+			t.Fatalf("request %d: expected %q, got %+v", id, expected, results[id])
+		}
 	}
-	if !strings.Contains(err.Error(), "checkpoint missing-snapshot not found") {
-		t.Fatalf("unexpected restore error: %v", err)
+	if results[4].IsError {
+		t.Fatalf("expected the foreign instance not to count against the limit, got %+v", results[4])
+	}
+	ownID := parseClawIDFromRunOutput(results[4].Content[0].Text)
+	if list := results[7].Content[0].Text; !strings.Contains(list, ownID) || strings.Contains(list, foreignID) {
+		t.Fatalf("expected list to show only %s, got %s", ownID, list)
+	}
+	if _, err := state.NewStore(filepath.Join(data, "claws")).Load(foreignID); err != nil {
+		t.Fatalf("expected the foreign instance to survive: %v", err)
 	}
 }
 
-func TestRunRequiresImage(t *testing.T) {
+func TestRunWritesGitHubActionsOutputsAndSummary(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	actionsDir := t.TempDir()
+	outputPath := filepath.Join(actionsDir, "output")
+	summaryPath := filepath.Join(actionsDir, "summary.md")
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_OUTPUT", outputPath)
+	t.Setenv("GITHUB_STEP_SUMMARY", summaryPath)
+	seedFetchedImage(t, cache)
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
-
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
-	if err == nil {
-		t.Fatalf("expected error for missing image")
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--port", "18795", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, errOut.String())
 	}
-	if !strings.Contains(err.Error(), "image ubuntu:24.04 is not ready") {
-		t.Fatalf("unexpected error: %v", err)
+	id := parseClawIDFromRunOutput(out.String())
+	outputs, _ := os.ReadFile(outputPath)
+	if string(outputs) != "clawid="+id+"\ngateway-url=http://127.0.0.1:18795/\n" {
+		t.Fatalf("unexpected GITHUB_OUTPUT: %q", outputs)
+	}
+	summary, _ := os.ReadFile(summaryPath)
+	for _, expected := range []string{"### clawfarm run: " + id, "| CLAWID | `" + id + "` |", "| Gateway | http://127.0.0.1:18795/ |", ":white_check_mark: ok", "| pidfile | "} {
+		if !strings.Contains(string(summary), expected) {
+			t.Fatalf("step summary missing %q:\n%s", expected, summary)
+		}
+	}
+	if application.bootPhase != nil {
+		t.Fatal("expected boot phase recorder to be restored after run")
 	}
-}
-
-func TestNewRejectsRelativeVolumeGuestPath(t *testing.T) {
-	backend := newFakeBackend()
-	var out bytes.Buffer
-	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
 
-	err := application.Run([]string{"new", "ubuntu:24.04", "--volume", ".openclaw:root/.openclaw"})
-	if err == nil {
-		t.Fatal("expected new command to fail for non-absolute guest volume path")
+	out.Reset()
+	if err := application.Run([]string{"run", "missing:1", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err == nil {
+		t.Fatal("expected run of missing image to fail")
 	}
-	if !strings.Contains(err.Error(), "guest path must be absolute") {
-		t.Fatalf("unexpected error: %v", err)
+	summary, _ = os.ReadFile(summaryPath)
+	if !strings.Contains(string(summary), ":x: ") {
+		t.Fatalf("expected failed run in step summary:\n%s", summary)
+	}
+	if !strings.Contains(out.String(), "::error title=clawfarm run::") {
+		t.Fatalf("expected ::error annotation, got %s", out.String())
+	}
+
+	report := &actionsRunReport{started: time.Now(), phases: map[string]time.Duration{}}
+	report.setInstance("claw-1", "ubuntu:24.04", 18795, 2222, "dev")
+	if !strings.Contains(report.summary(nil), "| SSH | `dev@127.0.0.1:2222` |") {
+		t.Fatalf("expected the guest user in the ssh row:\n%s", report.summary(nil))
 	}
 }
 
-func TestRunWaitTimeout(t *testing.T) {
+func TestRunCIModeRemovesInstanceOnExit(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
 
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
+	supervised := ""
+	application.startSupervisor = func(_ config.DirOverrides, id string, instanceDir string) (int, error) {
+		supervised = id
+		return 0, nil
+	}
 
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--port=65530", "--ready-timeout-secs=1", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
-	if err == nil {
-		t.Fatalf("expected timeout error")
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), fmt.Sprintf("--port=%d", gatewayPort), "--ci", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--no-wait")); err == nil || !strings.Contains(err.Error(), "cannot be combined with --no-wait") {
+		t.Fatalf("expected --ci --no-wait to be rejected, got %v", err)
 	}
-	if !strings.Contains(err.Error(), "gateway is not reachable yet") {
-		t.Fatalf("unexpected error: %v", err)
+	if err := application.Run(baseArgs); err != nil {
+		t.Fatalf("ci run failed: %v\n%s", err, errOut.String())
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if supervised != id {
+		t.Fatalf("expected supervisor for %s, got %q", id, supervised)
+	}
+	if !strings.Contains(out.String(), "ci: removed "+id) {
+		t.Fatalf("expected ci cleanup in output, got:\n%s", out.String())
+	}
+	if _, err := state.NewStore(filepath.Join(data, "claws")).Load(id); !errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected ci instance to be removed, got %v", err)
+	}
+	if backend.IsRunning(backend.nextPID) {
+		t.Fatal("expected ci instance VM to be stopped")
+	}
+	if len(ciInstances.cleanup) != 0 || ciInstances.notify != nil {
+		t.Fatal("expected ci signal handler to be released")
 	}
 }
 
-func TestImageLSShowsDownloadedMarker(t *testing.T) {
+func TestRunRequiresHostServicesBeforeBoot(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	defer listener.Close()
+	openPort := listener.Addr().(*net.TCPAddr).Port
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	_ = closed.Close()
 
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
 
-	if err := application.Run([]string{"image", "ls"}); err != nil {
-		t.Fatalf("image ls failed: %v", err)
+	err = application.Run(append(append([]string(nil), baseArgs...), "--require-host-port", strconv.Itoa(closedPort), "--require-host-cmd", "echo ollama is not running >&2; exit 1", "--require-timeout-secs", "0"))
+	if err == nil {
+		t.Fatal("expected unmet host requirements to fail the run")
 	}
-	if !strings.Contains(out.String(), "ubuntu:24.04") {
-		t.Fatalf("image ls missing available image: %s", out.String())
+	for _, expected := range []string{fmt.Sprintf("port 127.0.0.1:%d: nothing is listening", closedPort), `cmd "echo ollama is not running >&2; exit 1"`, "(ollama is not running)"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in error, got %v", expected, err)
+		}
 	}
-	if !strings.Contains(out.String(), "	no") && !strings.Contains(out.String(), "  no") {
-		t.Fatalf("image ls missing non-downloaded marker: %s", out.String())
+	if backend.lastSpec.InstanceID != "" {
+		t.Fatalf("expected no VM start when requirements fail, got %+v", backend.lastSpec)
 	}
 
-	seedFetchedImage(t, cache)
-	out.Reset()
-	if err := application.Run([]string{"image", "ls"}); err != nil {
-		t.Fatalf("image ls failed: %v", err)
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--require-host-port", strconv.Itoa(openPort), "--require-host-cmd", "true")); err != nil {
+		t.Fatalf("run with met requirements failed: %v", err)
 	}
-	if !strings.Contains(out.String(), "	yes") && !strings.Contains(out.String(), "  yes") {
-		t.Fatalf("image ls missing downloaded marker: %s", out.String())
+	if backend.lastSpec.InstanceID == "" {
+		t.Fatal("expected VM start once requirements are met")
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--require-host-port", "localhost:0"))
+	if err == nil || !strings.Contains(err.Error(), "invalid require-host-port value") {
+		t.Fatalf("expected invalid require-host-port error, got %v", err)
 	}
 }
 
-func TestRunPassesExpandedOpenClawParameters(t *testing.T) {
+func TestRunWaitsForEachGatewayAndReportsItsStatus(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
 
-	configPath := filepath.Join(t.TempDir(), "openclaw.json")
-	config := `{"agents":{"defaults":{"workspace":"/existing"}},"gateway":{"mode":"local"}}`
-	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
-		t.Fatalf("write config: %v", err)
-	}
-	envFile := filepath.Join(t.TempDir(), "openclaw.env")
-	envBody := "OPENAI_API_KEY=file-key\n# comment\nexport TELEGRAM_TOKEN=file-telegram\n"
-	if err := os.WriteFile(envFile, []byte(envBody), 0o644); err != nil {
-		t.Fatalf("write env file: %v", err)
-	}
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+	uiPaths := make(chan string, 16)
+	ui := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		select {
+		case uiPaths <- request.URL.Path:
+		default:
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	uiPort := ui.Listener.Addr().(*net.TCPAddr).Port
 
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), fmt.Sprintf("--port=%d", gatewayPort), "--ready-timeout-secs=2", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
 
-	err := application.Run([]string{
-		"run", "ubuntu:24.04",
-		"--workspace=.",
-		"--no-wait",
-		"--openclaw-config", configPath,
-		"--openclaw-env-file", envFile,
-		"--openclaw-agent-workspace", "/workspace",
-		"--openclaw-model-primary", "openai/gpt-5",
-		"--openclaw-gateway-auth-mode", "token",
-		"--openclaw-gateway-token", "token-from-flag",
-		"--openclaw-openai-api-key", "openai-from-flag",
-		"--openclaw-anthropic-api-key", "anthropic-from-flag",
-		"--openclaw-google-generative-ai-api-key", "google-from-flag",
-		"--openclaw-xai-api-key", "xai-from-flag",
-		"--openclaw-openrouter-api-key", "openrouter-from-flag",
-		"--openclaw-zai-api-key", "zai-from-flag",
-		"--openclaw-discord-token", "discord-from-flag",
-		"--openclaw-telegram-token", "telegram-from-flag",
-		"--openclaw-whatsapp-phone-number-id", "whatsapp-phone-id",
-		"--openclaw-whatsapp-access-token", "whatsapp-access-token",
-		"--openclaw-whatsapp-verify-token", "whatsapp-verify-token",
-		"--openclaw-whatsapp-app-secret", "whatsapp-app-secret",
-		"--openclaw-env", "OPENAI_API_KEY=from-openclaw-env",
-	})
-	if err != nil {
-		t.Fatalf("run command failed: %v", err)
-	}
-
-	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"primary": "openai/gpt-5"`) {
-		t.Fatalf("missing primary model in config: %s", backend.lastSpec.OpenClawConfig)
+	err := application.Run(append(append([]string(nil), baseArgs...), "--gateway", fmt.Sprintf("ui=%d:3000", gatewayPort)))
+	if err == nil || !strings.Contains(err.Error(), "already used by the main gateway") {
+		t.Fatalf("expected gateway port collision error, got %v", err)
 	}
-	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"workspace": "/workspace"`) {
-		t.Fatalf("missing workspace override in config: %s", backend.lastSpec.OpenClawConfig)
-	}
-	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"mode": "token"`) {
-		t.Fatalf("missing auth mode in config: %s", backend.lastSpec.OpenClawConfig)
+	err = application.Run(append(append([]string(nil), baseArgs...), "--gateway", "ui:3000"))
+	if err == nil || !strings.Contains(err.Error(), "invalid --gateway value") {
+		t.Fatalf("expected invalid --gateway error, got %v", err)
 	}
 
-	if backend.lastSpec.OpenClawEnvironment["OPENAI_API_KEY"] != "openai-from-flag" {
-		t.Fatalf("expected explicit OpenAI arg to override env file and --openclaw-env")
-	}
-	if backend.lastSpec.OpenClawEnvironment["OPENCLAW_GATEWAY_TOKEN"] != "token-from-flag" {
-		t.Fatalf("missing gateway token env")
-	}
-	if backend.lastSpec.OpenClawEnvironment["ANTHROPIC_API_KEY"] != "anthropic-from-flag" {
-		t.Fatalf("missing Anthropic key env")
-	}
-	if backend.lastSpec.OpenClawEnvironment["GOOGLE_GENERATIVE_AI_API_KEY"] != "google-from-flag" {
-		t.Fatalf("missing Google key env")
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--gateway", fmt.Sprintf("ui=%d:3000/health", uiPort))); err != nil {
+		t.Fatalf("run with extra gateway failed: %v\n%s", err, errOut.String())
 	}
-	if backend.lastSpec.OpenClawEnvironment["XAI_API_KEY"] != "xai-from-flag" {
-		t.Fatalf("missing xAI key env")
-	}
-	if backend.lastSpec.OpenClawEnvironment["OPENROUTER_API_KEY"] != "openrouter-from-flag" {
-		t.Fatalf("missing OpenRouter key env")
+	if path := <-uiPaths; path != "/health" {
+		t.Fatalf("expected ui readiness probe on /health, got %q", path)
 	}
-	if backend.lastSpec.OpenClawEnvironment["ZAI_API_KEY"] != "zai-from-flag" {
-		t.Fatalf("missing Z.AI key env")
+	id := parseClawIDFromRunOutput(out.String())
+	if !strings.Contains(out.String(), fmt.Sprintf("gateway ui: http://127.0.0.1:%d/health (guest port 3000)", uiPort)) {
+		t.Fatalf("expected extra gateway in run output, got:\n%s", out.String())
 	}
-	if backend.lastSpec.OpenClawEnvironment["DISCORD_TOKEN"] != "discord-from-flag" {
-		t.Fatalf("missing Discord token env")
+	forwarded := false
+	for _, mapping := range backend.lastSpec.PublishedPorts {
+		if mapping == (vm.PortMapping{HostPort: uiPort, GuestPort: 3000}) {
+			forwarded = true
+		}
 	}
-	if backend.lastSpec.OpenClawEnvironment["TELEGRAM_TOKEN"] != "telegram-from-flag" {
-		t.Fatalf("expected explicit Telegram arg to override env file")
+	if !forwarded {
+		t.Fatalf("expected ui gateway to be forwarded, got %+v", backend.lastSpec.PublishedPorts)
 	}
-	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_PHONE_NUMBER_ID"] != "whatsapp-phone-id" {
-		t.Fatalf("missing WhatsApp phone number id env")
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
 	}
-	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_ACCESS_TOKEN"] != "whatsapp-access-token" {
-		t.Fatalf("missing WhatsApp access token env")
+	if len(instance.Gateways) != 2 || instance.Gateways[1].Name != "ui" || instance.Gateways[1].Status != "ready" || len(instance.PublishedPorts) != 0 {
+		t.Fatalf("unexpected persisted gateways: %+v (published %+v)", instance.Gateways, instance.PublishedPorts)
 	}
-	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_VERIFY_TOKEN"] != "whatsapp-verify-token" {
-		t.Fatalf("missing WhatsApp verify token env")
+
+	ui.Close()
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
 	}
-	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_APP_SECRET"] != "whatsapp-app-secret" {
-		t.Fatalf("missing WhatsApp app secret env")
+	if !strings.Contains(out.String(), fmt.Sprintf("127.0.0.1:%d,ui=%d(unhealthy)", gatewayPort, uiPort)) || !strings.Contains(out.String(), "gateway ui is unreachable") {
+		t.Fatalf("expected ps to report the ui gateway as unhealthy, got:\n%s", out.String())
 	}
 }
 
-func TestRunFailsFastForMissingRequiredOpenClawParameters(t *testing.T) {
+func TestRunPinsOpenClawPackageTarball(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
-	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
 
+	packageTarball := npmPackageTarball(t, "1.4.2")
+	packagePath := filepath.Join(t.TempDir(), "openclaw-1.4.2.tgz")
+	if err := os.WriteFile(packagePath, packageTarball, 0o644); err != nil {
+		t.Fatalf("write package: %v", err)
+	}
+	digest := sha512.Sum512(packageTarball)
+	integrity := "sha512-" + base64.StdEncoding.EncodeToString(digest[:])
+
 	backend := newFakeBackend()
 	var out bytes.Buffer
-	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
 
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
-	if err == nil {
-		t.Fatalf("expected missing parameter error")
+	wrongDigest := sha512.Sum512([]byte("tampered"))
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--openclaw-package", packagePath, "--openclaw-integrity", "sha512-" + base64.StdEncoding.EncodeToString(wrongDigest[:])})
+	if err == nil || !strings.Contains(err.Error(), "integrity mismatch") {
+		t.Fatalf("expected integrity mismatch, got %v", err)
 	}
-	if !strings.Contains(err.Error(), "--openclaw-model-primary") {
-		t.Fatalf("unexpected error: %v", err)
+	err = application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--openclaw-package", "openclaw@latest", "--openclaw-integrity", integrity})
+	if err == nil || !strings.Contains(err.Error(), "requires an exact version") {
+		t.Fatalf("expected exact version error, got %v", err)
 	}
-	if backend.nextPID != 4000 {
-		t.Fatalf("vm should not start before preflight validation")
+
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--openclaw-package", packagePath, "--openclaw-integrity", integrity}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	stagedPath := filepath.Join(data, "claws", id, "openclaw", "openclaw-1.4.2.tgz")
+	if backend.lastSpec.OpenClawTarballPath != stagedPath || backend.lastSpec.OpenClawIntegrity != integrity {
+		t.Fatalf("unexpected openclaw pin passed to backend: %q %q", backend.lastSpec.OpenClawTarballPath, backend.lastSpec.OpenClawIntegrity)
+	}
+	if staged, err := os.ReadFile(stagedPath); err != nil || !bytes.Equal(staged, packageTarball) {
+		t.Fatalf("expected package staged into the instance dir: %v", err)
 	}
-}
 
-func TestRunPromptsForMissingRequiredOpenClawParameters(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	want := state.OpenClawPackage{Source: "tarball", Spec: "openclaw-1.4.2.tgz", Version: "1.4.2", Integrity: integrity}
+	if instance.OpenClawPackage == nil || *instance.OpenClawPackage != want {
+		t.Fatalf("unexpected recorded openclaw package: %#v", instance.OpenClawPackage)
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	if !strings.Contains(out.String(), "openclaw: openclaw-1.4.2.tgz 1.4.2 from tarball ("+integrity+")") {
+		t.Fatalf("expected pinned package in run output, got:\n%s", out.String())
+	}
+}
 
+func TestLogsTailsFollowsAndFiltersInstanceLogs(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
 	seedFetchedImage(t, cache)
 
 	backend := newFakeBackend()
 	var out bytes.Buffer
-	var errOut bytes.Buffer
-	input := strings.NewReader("openai/gpt-5\nprompt-openai-key\n")
-	application := NewWithIOAndBackend(&out, &errOut, input, backend)
-
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
-	if err != nil {
-		t.Fatalf("run should succeed with prompted values: %v", err)
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
 	}
-	promptOutput := out.String()
+	id := parseClawIDFromRunOutput(out.String())
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if err := os.WriteFile(instance.SerialLogPath, []byte("boot 1\nboot 2\nboot 3\n"), 0o644); err != nil {
+		t.Fatalf("write serial log: %v", err)
+	}
+	if err := os.WriteFile(instance.QEMULogPath, []byte("qemu warning\n"), 0o644); err != nil {
+		t.Fatalf("write qemu log: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"logs", id, "--tail", "2"}); err != nil {
+		t.Fatalf("logs failed: %v", err)
+	}
+	if out.String() != "boot 2\nboot 3\n" {
+		t.Fatalf("unexpected serial tail: %q", out.String())
+	}
+	out.Reset()
+	if err := application.Run([]string{"logs", id, "--source=qemu"}); err != nil {
+		t.Fatalf("logs --source qemu failed: %v", err)
+	}
+	if out.String() != "qemu warning\n" {
+		t.Fatalf("unexpected qemu log: %q", out.String())
+	}
+
+	stale := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(instance.QEMULogPath, stale, stale); err != nil {
+		t.Fatalf("age qemu log: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"logs", id, "--source", "qemu", "--since", "10m"}); err != nil {
+		t.Fatalf("logs --since failed: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no output for a log untouched since --since, got %q", out.String())
+	}
+
+	var followed bytes.Buffer
+	follower := NewWithBackend(&followed, &bytes.Buffer{}, backend)
+	done := make(chan error, 1)
+	go func() {
+		done <- follower.Run([]string{"logs", id, "-f", "--tail", "1"})
+	}()
+	time.Sleep(2 * logsFollowInterval)
+	file, err := os.OpenFile(instance.SerialLogPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open serial log: %v", err)
+	}
+	if _, err := file.WriteString("login: \n"); err != nil {
+		t.Fatalf("append serial log: %v", err)
+	}
+	file.Close()
+	time.Sleep(2 * logsFollowInterval)
+	_ = backend.Stop(context.Background(), instanceProcess(instance))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("logs --follow failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("logs --follow did not stop after the instance stopped")
+	}
+	if followed.String() != "boot 3\nlogin: \n" {
+		t.Fatalf("unexpected followed output: %q", followed.String())
+	}
+
+	if err := application.Run([]string{"logs", id, "--source", "bootstrap"}); err == nil || !strings.Contains(err.Error(), "is not running") {
+		t.Fatalf("expected guest log to require a running instance, got %v", err)
+	}
+	if err := application.Run([]string{"logs", id, "--source", "kernel"}); err == nil || !strings.Contains(err.Error(), "invalid --source") {
+		t.Fatalf("expected invalid source error, got %v", err)
+	}
+}
+
+func TestChannelsVerifyFallsBackToHostAndPrintsWebhookSetup(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/telegram/botgood-token/getMe":
+			writer.WriteHeader(http.StatusOK)
+		case request.URL.Path == "/discord/users/@me" && request.Header.Get("Authorization") == "Bot bad-token":
+			writer.WriteHeader(http.StatusUnauthorized)
+		default:
+			writer.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+	original := chatChannelAPIBase
+	chatChannelAPIBase = map[string]string{"discord": server.URL + "/discord", "telegram": server.URL + "/telegram", "whatsapp": server.URL + "/whatsapp"}
+	defer func() { chatChannelAPIBase = original }()
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	err := application.Run([]string{"channels", "verify", id})
+	if err == nil || !strings.Contains(err.Error(), "--env-file") {
+		t.Fatalf("expected unreachable guest to suggest --env-file, got %v", err)
+	}
+
+	envFile := filepath.Join(t.TempDir(), "channels.env")
+	if err := os.WriteFile(envFile, []byte("DISCORD_TOKEN=bad-token\nTELEGRAM_TOKEN=good-token\n"), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+	out.Reset()
+	err = application.Run([]string{"channels", "verify", id, "--env-file", envFile, "--public-url", "https://claw.example.com/"})
+	if err == nil || !strings.Contains(err.Error(), "failed for discord") {
+		t.Fatalf("expected rejected discord token to fail verification, got %v", err)
+	}
+	for _, expected := range []string{
+		"discord: failed (credentials rejected, HTTP 401)",
+		"telegram: ok",
+		"verified from: host",
+		"setWebhook?url=https://claw.example.com/webhooks/telegram",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in channels output, got %s", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "whatsapp") || strings.Contains(out.String(), "good-token") {
+		t.Fatalf("expected only configured channels and no secrets in output, got %s", out.String())
+	}
+
+	err = application.Run([]string{"channels", "verify", id, "--public-url", "http://claw.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "https") {
+		t.Fatalf("expected plain http public url to be rejected, got %v", err)
+	}
+}
+
+func TestSSHOpensShellInRunningInstance(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	argsPath := filepath.Join(toolDir, "ssh.args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsPath + "\nexit 3\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	err := application.Run([]string{"ssh", id})
+	if err == nil || !strings.Contains(err.Error(), "has no ssh access") {
+		t.Fatalf("expected missing ssh access error, got %v", err)
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = keyPath
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	err = application.Run([]string{"ssh", id, "--", "uname", "-a"})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected remote exit status 3, got %v", err)
+	}
+	payload, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("read ssh args: %v", err)
+	}
+	args := string(payload)
+	for _, expected := range []string{"-p\n2222\n", "-T\n", "claw@127.0.0.1\n", "uname -a\n"} {
+		if !strings.Contains(args, expected) {
+			t.Fatalf("expected ssh args to contain %q, got %q", expected, args)
+		}
+	}
+}
+
+func TestCronJobsRenderAtBootAndEditOverSSH(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	crontabPath := filepath.Join(toolDir, "crontab")
+	script := "#!/bin/sh\ncat > " + crontabPath + "\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--cron", "0 * * * *"})
+	if err == nil || !strings.Contains(err.Error(), "five schedule fields") {
+		t.Fatalf("expected cron job without a command to be rejected, got %v", err)
+	}
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--cron", "0 * * * * openclaw run sync"}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if jobs := backend.lastSpec.CronJobs; len(jobs) != 1 || jobs[0] != "0 * * * * openclaw run sync" {
+		t.Fatalf("expected cron job in start spec, got %v", jobs)
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	err = application.Run([]string{"cron", "add", id, "@daily", "true"})
+	if err == nil || !strings.Contains(err.Error(), "running instance") {
+		t.Fatalf("expected cron add to need ssh access, got %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = keyPath
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	if err := application.Run([]string{"cron", "add", id, "*/5 * * * * date +%s"}); err != nil {
+		t.Fatalf("cron add failed: %v", err)
+	}
+	crontab, err := os.ReadFile(crontabPath)
+	if err != nil {
+		t.Fatalf("read guest crontab: %v", err)
+	}
+	if !strings.Contains(string(crontab), "0 * * * * root bash -lc") || !strings.Contains(string(crontab), `date +\%s`) {
+		t.Fatalf("expected both jobs in the guest crontab with escaped %%, got %s", crontab)
+	}
+	out.Reset()
+	if err := application.Run([]string{"cron", "ls", id}); err != nil {
+		t.Fatalf("cron ls failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "1\t0 * * * * openclaw run sync") || !strings.Contains(out.String(), "2\t*/5 * * * * date +%s") {
+		t.Fatalf("unexpected cron ls output %q", out.String())
+	}
+
+	if err := application.Run([]string{"cron", "rm", id, "3"}); err == nil {
+		t.Fatal("expected removing a missing cron job to fail")
+	}
+	if err := application.Run([]string{"cron", "rm", id, "1"}); err != nil {
+		t.Fatalf("cron rm failed: %v", err)
+	}
+	instance, err = store.Load(id)
+	if err != nil || len(instance.CronJobs) != 1 || instance.CronJobs[0] != "*/5 * * * * date +%s" {
+		t.Fatalf("expected one remaining cron job, got %v (%v)", instance.CronJobs, err)
+	}
+	spec, err := readInstanceStartSpec(filepath.Join(data, "claws", id))
+	if err != nil || len(spec.CronJobs) != 1 {
+		t.Fatalf("expected start spec to track cron edits, got %v (%v)", spec.CronJobs, err)
+	}
+}
+
+func TestHostSleepSuspendsAndWakeResyncsClockWithGracePeriod(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	sshLog := filepath.Join(toolDir, "ssh.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + sshLog + "\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	ids := make([]string, 0, 2)
+	for range 2 {
+		out.Reset()
+		if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--no-wait"}); err != nil {
+			t.Fatalf("new command failed: %v", err)
+		}
+		ids = append(ids, parseClawIDFromRunOutput(out.String()))
+	}
+	if err := application.Run([]string{"suspend", ids[1]}); err != nil {
+		t.Fatalf("suspend failed: %v", err)
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(ids[0])
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = keyPath
+	instance.Status = "ready"
+	instance.CreatedAtUTC = time.Now().UTC().Add(-time.Hour)
+	instance.StartedAtUTC = instance.CreatedAtUTC
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"host", "sleep"}); err != nil {
+		t.Fatalf("host sleep failed: %v", err)
+	}
+	if !strings.Contains(out.String(), ids[0]+" -> suspended for host sleep") || strings.Contains(out.String(), ids[1]) {
+		t.Fatalf("expected only the running instance to be suspended, got %q", out.String())
+	}
+	instance, err = store.Load(ids[0])
+	if err != nil || instance.Status != "suspended" || !instance.SleepSuspended {
+		t.Fatalf("expected instance suspended for host sleep, got %+v (%v)", instance, err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"host", "wake"}); err != nil {
+		t.Fatalf("host wake failed: %v", err)
+	}
+	if !strings.Contains(out.String(), ids[0]+" -> woke (clock resynced)") || strings.Contains(out.String(), ids[1]) {
+		t.Fatalf("unexpected host wake output %q", out.String())
+	}
+	logged, err := os.ReadFile(sshLog)
+	if err != nil || !strings.Contains(string(logged), "date -u -s @") {
+		t.Fatalf("expected guest clock to be set over ssh, got %q (%v)", logged, err)
+	}
+	user, err := store.Load(ids[1])
+	if err != nil || user.Status != "suspended" || !user.WokeAtUTC.IsZero() {
+		t.Fatalf("expected user-suspended instance to stay suspended, got %+v (%v)", user, err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	instance, err = store.Load(ids[0])
+	if err != nil || instance.Status != "running" || instance.SleepSuspended || instance.WokeAtUTC.IsZero() {
+		t.Fatalf("expected woken instance to stay running during the grace period, got %+v (%v)", instance, err)
+	}
+}
+
+func TestDiskSizeGrowsInstanceDiskAndResizeNeedsStoppedInstance(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	logPath := filepath.Join(toolDir, "qemu-img.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\ncase \"$1\" in\n" +
+		"create) eval last=\\${$#}; printf 'QFI\\373' > \"$last\" ;;\n" +
+		"info) echo '{\"format\":\"qcow2\",\"virtual-size\":3221225472}' ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--disk-size", "lots"}); err == nil || !strings.Contains(err.Error(), "invalid --disk-size") {
+		t.Fatalf("expected invalid disk size to be rejected, got %v", err)
+	}
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--disk-size", "20G"}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if backend.lastSpec.DiskSizeBytes != 20<<30 {
+		t.Fatalf("expected disk size in start spec, got %d", backend.lastSpec.DiskSizeBytes)
+	}
+	logged, err := os.ReadFile(logPath)
+	if err != nil || !strings.Contains(string(logged), filepath.Join(data, "claws", id, "instance.img")+" 21474836480") {
+		t.Fatalf("expected instance disk to be grown to 20G, got %q (%v)", logged, err)
+	}
+
+	err = application.Run([]string{"resize", id, "--disk-size", "40G"})
+	if err == nil || !strings.Contains(err.Error(), "stop it before resizing") {
+		t.Fatalf("expected resize of a running instance to fail, got %v", err)
+	}
+	if err := application.Run([]string{"stop", id}); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"resize", id, "--disk-size=40G"}); err != nil {
+		t.Fatalf("resize failed: %v", err)
+	}
+	if !strings.Contains(out.String(), id+" disk grown to 40.0GB") {
+		t.Fatalf("unexpected resize output %q", out.String())
+	}
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil || instance.DiskSizeBytes != 40<<30 {
+		t.Fatalf("expected resized disk size in state, got %d (%v)", instance.DiskSizeBytes, err)
+	}
+	spec, err := readInstanceStartSpec(filepath.Join(data, "claws", id))
+	if err != nil || spec.DiskSizeBytes != 40<<30 {
+		t.Fatalf("expected start spec to grow the rootfs on next start, got %d (%v)", spec.DiskSizeBytes, err)
+	}
+}
+
+func TestPSMarksDiskPressureFromGuestDFAndPostsEvent(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+	events := make(chan string, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		events <- string(body)
+	}))
+	defer webhook.Close()
+	t.Setenv("CLAWFARM_EVENT_WEBHOOK", webhook.URL)
+
+	toolDir := t.TempDir()
+	sshLog := filepath.Join(toolDir, "ssh.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + sshLog + "\nprintf 'Filesystem 1024-blocks Used Available Capacity Mounted on\\n/dev/vda1 10000000 9600000 400000 96%% /\\n'\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", fmt.Sprintf("--port=%d", gatewayPort)}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = keyPath
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	for range 2 {
+		out.Reset()
+		if err := application.Run([]string{"ps"}); err != nil {
+			t.Fatalf("ps failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "disk-pressure") || !strings.Contains(out.String(), "guest rootfs 96% full (390.6MB free)") {
+			t.Fatalf("expected disk-pressure in ps, got %q", out.String())
+		}
+	}
+	logged, err := os.ReadFile(sshLog)
+	if err != nil || strings.Count(string(logged), "df -P -k /") != 1 {
+		t.Fatalf("expected one df sample within the check interval, got %q (%v)", logged, err)
+	}
+	select {
+	case event := <-events:
+		if !strings.Contains(event, `"type":"disk-pressure"`) || !strings.Contains(event, id) {
+			t.Fatalf("unexpected webhook event %s", event)
+		}
+	default:
+		t.Fatal("expected a disk-pressure webhook event")
+	}
+	if len(events) != 0 {
+		t.Fatal("expected the event to be sent only on the transition into disk-pressure")
+	}
+	recorded, err := os.ReadFile(filepath.Join(data, "claws", id, "events.jsonl"))
+	if err != nil || !strings.Contains(string(recorded), "disk-pressure") {
+		t.Fatalf("expected event in events.jsonl, got %q (%v)", recorded, err)
+	}
+	instance, err = store.Load(id)
+	if err != nil || instance.Status != "disk-pressure" || instance.DiskUsedPercent != 96 {
+		t.Fatalf("expected disk-pressure state, got %+v (%v)", instance, err)
+	}
+}
+
+func TestDriftDiffsGuestOpenClawConfigAgainstLaunchConfig(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	script := "#!/bin/sh\ncat <<'EOF'\n{\"agents\":{\"defaults\":{\"workspace\":\"/workspace\",\"model\":{\"primary\":\"ollama/llama3\"},\"sandbox\":{\"mode\":\"all\"}}},\"gateway\":{\"mode\":\"local\",\"port\":18790,\"auth\":{\"mode\":\"none\"}}}\nEOF\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	ids := []string{}
+	for _, workspace := range []string{t.TempDir(), t.TempDir()} {
+		out.Reset()
+		if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=" + workspace, "--port", "18789"}); err != nil {
+			t.Fatalf("new command failed: %v", err)
+		}
+		ids = append(ids, parseClawIDFromRunOutput(out.String()))
+	}
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(ids[0])
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = keyPath
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"drift"}); err != nil {
+		t.Fatalf("drift failed: %v", err)
+	}
+	for _, expected := range []string{
+		ids[0] + ": 2 differences",
+		`  + agents.defaults.sandbox.mode: "all"`,
+		"  ~ gateway.port: 18789 -> 18790",
+		ids[1] + ": skipped (instance " + ids[1] + " has no ssh access",
+		"1 of 1 checked instances drifted",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected %q in drift output, got:\n%s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"drift", "--clawid", ids[1]}); err != nil {
+		t.Fatalf("drift --clawid failed: %v", err)
+	}
+	if strings.Contains(out.String(), ids[0]) {
+		t.Fatalf("expected --clawid to limit the report, got:\n%s", out.String())
+	}
+	if err := application.Run([]string{"drift", "--clawid", "missing"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected unknown clawid to fail, got %v", err)
+	}
+}
+
+func TestExecRunsCommandAndPropagatesExitCode(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	toolDir := t.TempDir()
+	argsPath := filepath.Join(toolDir, "ssh.args")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsPath + "\necho guest-output\nexit 7\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = filepath.Join(t.TempDir(), "id_ed25519")
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	if err := application.Run([]string{"exec", id}); err == nil || !strings.Contains(err.Error(), "usage: clawfarm exec") {
+		t.Fatalf("expected usage error without a command, got %v", err)
+	}
+	if err := application.Run([]string{"exec", "--env", "1BAD=x", id, "--", "true"}); err == nil || !strings.Contains(err.Error(), "invalid --env") {
+		t.Fatalf("expected invalid env error, got %v", err)
+	}
+
+	out.Reset()
+	err = application.Run([]string{"exec", "--user=root", "-w", "/workspace", "-e", "MODE=test", id, "--", "sh", "-c", "echo it's $MODE", "--flag"})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 7 {
+		t.Fatalf("expected guest exit status 7, got %v", err)
+	}
+	if out.String() != "guest-output\n" {
+		t.Fatalf("expected streamed guest output, got %q", out.String())
+	}
+	payload, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("read ssh args: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n")
+	remote := lines[len(lines)-1]
+	want := "sudo -n bash -lc " + shellSingleQuote("cd '/workspace' && exec env 'MODE=test' 'sh' '-c' "+shellSingleQuote("echo it's $MODE")+" '--flag'")
+	if remote != want {
+		t.Fatalf("unexpected remote command:\n got %s\nwant %s", remote, want)
+	}
+	if !strings.Contains(string(payload), "-T\nclaw@127.0.0.1\n") {
+		t.Fatalf("expected non-interactive ssh session, got %q", payload)
+	}
+}
+
+func TestStopPowersDownAndStartRebootsExistingInstance(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--publish", "8080:80"}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	firstSpec := backend.lastSpec
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+
+	monitorDir, err := os.MkdirTemp("", "clawmon")
+	if err != nil {
+		t.Fatalf("create monitor dir: %v", err)
+	}
+	defer os.RemoveAll(monitorDir)
+	instance.MonitorPath = filepath.Join(monitorDir, "m.sock")
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+	listener, err := net.Listen("unix", instance.MonitorPath)
+	if err != nil {
+		t.Fatalf("listen on monitor socket: %v", err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+		_ = encoder.Encode(map[string]any{"QMP": map[string]any{"capabilities": []string{}}})
+		for {
+			var request struct {
+				Execute string `json:"execute"`
+			}
+			if err := decoder.Decode(&request); err != nil {
+				return
+			}
+			_ = encoder.Encode(map[string]any{"return": map[string]any{}})
+			if request.Execute != "qmp_capabilities" {
+				commands <- request.Execute
+				_ = backend.Stop(context.Background(), instanceProcess(instance))
+				return
+			}
+		}
+	}()
+
+	out.Reset()
+	if err := application.Run([]string{"stop", id}); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	if command := <-commands; command != "system_powerdown" {
+		t.Fatalf("expected ACPI powerdown over QMP, got %q", command)
+	}
+	if !strings.Contains(out.String(), id+" -> stopped") || strings.Contains(out.String(), "forced") {
+		t.Fatalf("unexpected stop output: %q", out.String())
+	}
+	stopped, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load stopped instance: %v", err)
+	}
+	if stopped.Status != "stopped" || stopped.PID != 0 || stopped.DirtyShutdown {
+		t.Fatalf("unexpected stopped instance: status=%q pid=%d dirty=%v", stopped.Status, stopped.PID, stopped.DirtyShutdown)
+	}
+	if err := application.Run([]string{"stop", id}); err == nil || !strings.Contains(err.Error(), "is not running") {
+		t.Fatalf("expected stopping a stopped instance to fail, got %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"start", id, "--no-wait"}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	restartSpec := backend.lastSpec
+	if restartSpec.SourceDiskPath != instance.DiskPath || restartSpec.UncleanShutdown {
+		t.Fatalf("expected start to boot the existing disk cleanly, got disk=%q unclean=%v", restartSpec.SourceDiskPath, restartSpec.UncleanShutdown)
+	}
+	if restartSpec.GatewayHostPort != firstSpec.GatewayHostPort || !reflect.DeepEqual(restartSpec.PublishedPorts, firstSpec.PublishedPorts) || restartSpec.ImageArch != firstSpec.ImageArch {
+		t.Fatalf("expected start to reuse recorded config, got %#v", restartSpec)
+	}
+	started, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load started instance: %v", err)
+	}
+	if started.Status != "running" || started.PID == instance.PID || !backend.IsRunning(started.PID) || started.StartedAtUTC.IsZero() {
+		t.Fatalf("unexpected started instance: status=%q pid=%d", started.Status, started.PID)
+	}
+	if err := application.Run([]string{"start", id}); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("expected starting a running instance to fail, got %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"stop", id, "--force"}); err != nil {
+		t.Fatalf("stop --force failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "(forced)") {
+		t.Fatalf("expected forced stop output, got %q", out.String())
+	}
+	if err := application.Run([]string{"start", id, "--no-wait"}); err != nil {
+		t.Fatalf("start after forced stop failed: %v", err)
+	}
+	if !backend.lastSpec.UncleanShutdown {
+		t.Fatal("expected start after a forced stop to check the disk")
+	}
+}
+
+func TestBlobCacheEvictsLeastRecentlyUsedUnreferencedBlobs(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("CLAWFARM_BLOB_CACHE_MAX_BYTES", "250")
+
+	root := filepath.Join(cache, "blobs")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatalf("create blobs dir: %v", err)
+	}
+	writeBlob := func(name string, age time.Duration) string {
+		payload := bytes.Repeat([]byte(name), 100)
+		digest := sha256Hex(payload)
+		path := filepath.Join(root, digest)
+		if err := os.WriteFile(path, payload, 0o644); err != nil {
+			t.Fatalf("write blob %s: %v", name, err)
+		}
+		usedAt := time.Now().Add(-age)
+		if err := os.Chtimes(path, usedAt, usedAt); err != nil {
+			t.Fatalf("age blob %s: %v", name, err)
+		}
+		return digest
+	}
+	referenced := writeBlob("a", 40*24*time.Hour)
+	pinned := writeBlob("b", 50*24*time.Hour)
+	older := writeBlob("c", 30*24*time.Hour)
+	oldest := writeBlob("d", 60*24*time.Hour)
+	recent := writeBlob("e", time.Hour)
+
+	if err := state.NewStore(filepath.Join(data, "claws")).Save(state.Instance{ID: "claw-ref", Blobs: []string{referenced}}); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	if err := application.Run([]string{"blob", "pin", pinned[:10]}); err != nil {
+		t.Fatalf("blob pin failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"blob", "ls"}); err != nil {
+		t.Fatalf("blob ls failed: %v", err)
+	}
+	listing := out.String()
+	for _, expected := range []string{pinned[:12] + "  100B", "yes", "claw-ref", "total: 500B of 250B cap"} {
+		if !strings.Contains(listing, expected) {
+			t.Fatalf("expected blob ls to contain %q, got:\n%s", expected, listing)
+		}
+	}
+
+	out.Reset()
+	application.enforceBlobCache(root, nil)
+	if !strings.Contains(out.String(), "evicted 2 least recently used blob(s), freed 200B") {
+		t.Fatalf("unexpected eviction output: %q", out.String())
+	}
+	for digest, kept := range map[string]bool{referenced: true, pinned: true, older: false, oldest: false, recent: true} {
+		if _, err := os.Stat(filepath.Join(root, digest)); (err == nil) != kept {
+			t.Fatalf("blob %s kept=%v, want %v", digest[:12], err == nil, kept)
+		}
+	}
+
+	t.Setenv("CLAWFARM_BLOB_CACHE_KEEP_DAYS", "0")
+	if err := application.Run([]string{"blob", "unpin", pinned}); err != nil {
+		t.Fatalf("blob unpin failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"blob", "prune"}); err != nil {
+		t.Fatalf("blob prune failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "removed 2 blob(s), freed 200B") {
+		t.Fatalf("unexpected prune output: %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(root, referenced)); err != nil {
+		t.Fatalf("expected blob referenced by an instance to survive prune: %v", err)
+	}
+}
+
+func TestBlobPruneKeepsBlobsOfKeptDataDirs(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("CLAWFARM_BLOB_CACHE_KEEP_DAYS", "0")
+
+	root := filepath.Join(cache, "blobs")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		t.Fatalf("create blobs dir: %v", err)
+	}
+	payload := []byte("kept layer")
+	digest := sha256Hex(payload)
+	if err := os.WriteFile(filepath.Join(root, digest), payload, 0o644); err != nil {
+		t.Fatalf("write blob: %v", err)
+	}
+	store := state.NewStore(filepath.Join(data, "claws"))
+	if err := store.Save(state.Instance{ID: "claw-kept", Blobs: []string{digest}}); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	if err := application.Run([]string{"rm", "claw-kept", "--keep-data"}); err != nil {
+		t.Fatalf("rm --keep-data failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"blob", "ls"}); err != nil {
+		t.Fatalf("blob ls failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "claw-kept (kept data)") {
+		t.Fatalf("expected kept data dir to reference the blob, got:\n%s", out.String())
+	}
+	if err := application.Run([]string{"blob", "prune"}); err != nil {
+		t.Fatalf("blob prune failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, digest)); err != nil {
+		t.Fatalf("expected blob used by a kept data dir to survive prune: %v", err)
+	}
+
+	if err := store.Delete("claw-kept"); err != nil {
+		t.Fatalf("delete kept data dir: %v", err)
+	}
+	if err := application.Run([]string{"blob", "prune"}); err != nil {
+		t.Fatalf("blob prune failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, digest)); !os.IsNotExist(err) {
+		t.Fatalf("expected blob to be pruned once the kept data dir is gone, stat err=%v", err)
+	}
+}
+
+func TestRestartRebootsInstanceAndWaitsForGateway(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", fmt.Sprintf("--port=%d", gatewayPort)}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	store := state.NewStore(filepath.Join(data, "claws"))
+	before, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"restart", id, "--force", "--ready-timeout-secs=2"}); err != nil {
+		t.Fatalf("restart failed: %v", err)
+	}
+	output := out.String()
+	if !strings.Contains(output, id+" -> stopped (forced)") || !strings.Contains(output, fmt.Sprintf("status: ready (http://127.0.0.1:%d/)", gatewayPort)) {
+		t.Fatalf("unexpected restart output: %s", output)
+	}
+	after, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load restarted instance: %v", err)
+	}
+	if after.Status != "ready" || after.PID == before.PID || backend.IsRunning(before.PID) || !backend.IsRunning(after.PID) {
+		t.Fatalf("unexpected restarted instance: status=%q pid=%d (was %d)", after.Status, after.PID, before.PID)
+	}
+	if backend.lastSpec.GatewayHostPort != gatewayPort || backend.lastSpec.SourceDiskPath != before.DiskPath {
+		t.Fatalf("expected restart to reuse the saved start spec, got %#v", backend.lastSpec)
+	}
+
+	if _, err := application.stopInstance(id, time.Second, true); err != nil {
+		t.Fatalf("stop instance: %v", err)
+	}
+	gateway.Close()
+	out.Reset()
+	err = application.Run([]string{"restart", id, "--ready-timeout-secs=1"})
+	if err == nil || !strings.Contains(err.Error(), "gateway is not reachable yet") {
+		t.Fatalf("expected readiness failure after restart, got %v", err)
+	}
+	if strings.Contains(out.String(), "-> stopped") {
+		t.Fatalf("expected restart of a stopped instance to only start it, got %s", out.String())
+	}
+	if unhealthy, _ := store.Load(id); unhealthy.Status != "unhealthy" || unhealthy.LastError == "" {
+		t.Fatalf("expected unhealthy status after failed re-probe, got %q (%q)", unhealthy.Status, unhealthy.LastError)
+	}
+}
+
+func TestSystemDFReportsImagesAndClaws(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+	if err := os.Setenv("HOME", t.TempDir()); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	out.Reset()
+	if err := application.Run([]string{"system", "df"}); err != nil {
+		t.Fatalf("system df failed: %v", err)
+	}
+	for _, expected := range []string{"APPARENT", "ALLOCATED", "image", "ubuntu:24.04", "claw", id, "total"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("system df output missing %q: %s", expected, out.String())
+		}
+	}
+}
+
+func TestRunShareOwnershipMappedReachesBackendAndAudit(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--share-ownership", "root")); err == nil || !strings.Contains(err.Error(), "--share-ownership") {
+		t.Fatalf("expected invalid --share-ownership error, got %v", err)
+	}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--share-ownership", "mapped")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if backend.lastSpec.ShareOwnership != vm.ShareOwnershipMapped {
+		t.Fatalf("expected mapped share ownership in start spec, got %q", backend.lastSpec.ShareOwnership)
+	}
+
+	id := parseClawIDFromRunOutput(out.String())
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "ownership: mapped") {
+		t.Fatalf("expected audit to report mapped ownership, got %s", out.String())
+	}
+}
+
+func TestRunWorkspaceWatchStartsRelayAndSharesWatchDir(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	watchedDir := ""
+	application.startWatcher = func(_ config.DirOverrides, id string, instanceDir string) (int, error) {
+		watchedDir = instanceDir
+		return 4242, nil
+	}
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--no-workspace", "--workspace-watch", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err == nil || !strings.Contains(err.Error(), "requires a workspace") {
+		t.Fatalf("expected --workspace-watch to require a workspace, got %v", err)
+	}
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--workspace-watch", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if watchedDir != filepath.Join(data, "claws", id) {
+		t.Fatalf("expected watcher for instance dir, got %q", watchedDir)
+	}
+	if backend.lastSpec.WatchPath != filepath.Join(data, "claws", id, "watch") {
+		t.Fatalf("expected watch share in start spec, got %q", backend.lastSpec.WatchPath)
+	}
+	if !strings.Contains(out.String(), "workspace watch: relaying host changes (pid 4242)") {
+		t.Fatalf("expected workspace watch line, got %s", out.String())
+	}
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.WatchPID != 4242 || !instance.WorkspaceWatch {
+		t.Fatalf("expected watch pid and setting to be recorded, got %d %v", instance.WatchPID, instance.WorkspaceWatch)
+	}
+
+	if err := application.Run([]string{"stop", id}); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	watchedDir = ""
+	if err := application.Run([]string{"start", id, "--no-wait"}); err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	if watchedDir != filepath.Join(data, "claws", id) {
+		t.Fatalf("expected start to restart the watcher, got %q", watchedDir)
+	}
+	if instance, err = state.NewStore(filepath.Join(data, "claws")).Load(id); err != nil || instance.WatchPID != 4242 {
+		t.Fatalf("expected restarted watch pid to be recorded, got %+v (%v)", instance, err)
+	}
+}
+
+func TestStopWorkspaceWatcherOnlySignalsTheLockHolder(t *testing.T) {
+	sleeper := exec.Command("sleep", "30")
+	if err := sleeper.Start(); err != nil {
+		t.Skipf("sleep not available: %v", err)
+	}
+	defer sleeper.Process.Kill()
+	exited := make(chan struct{})
+	go func() {
+		_ = sleeper.Wait()
+		close(exited)
+	}()
+	instanceDir := t.TempDir()
+	instance := state.Instance{ID: "claw-watch", WatchPID: sleeper.Process.Pid}
+
+	stopWorkspaceWatcher(instanceDir, instance)
+	handle, locked, err := state.NewFlockLocker().TryLock(workspaceWatchLockPath(instanceDir))
+	if err != nil || !locked {
+		t.Fatalf("lock watch file: %v %v", locked, err)
+	}
+	defer handle.Unlock()
+	if err := os.WriteFile(workspaceWatchPIDPath(instanceDir), []byte("1\n"), 0o644); err != nil {
+		t.Fatalf("write pid file: %v", err)
+	}
+	stopWorkspaceWatcher(instanceDir, instance)
+	select {
+	case <-exited:
+		t.Fatal("expected a process that does not hold the watch lock to survive")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(workspaceWatchPIDPath(instanceDir), []byte(strconv.Itoa(sleeper.Process.Pid)+"\n"), 0o644); err != nil {
+		t.Fatalf("write pid file: %v", err)
+	}
+	stopWorkspaceWatcher(instanceDir, instance)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the recorded watcher to be signalled")
+	}
+}
+
+func TestDiffWorkspaceSnapshotsReportsChangedAddedAndRemoved(t *testing.T) {
+	now := time.Now()
+	previous := map[string]workspaceStamp{
+		"src/app.js":  {ModTime: now, Size: 10},
+		"README.md":   {ModTime: now, Size: 5},
+		"old/file.js": {ModTime: now, Size: 1},
+	}
+	current := map[string]workspaceStamp{
+		"src/app.js": {ModTime: now.Add(time.Second), Size: 10},
+		"README.md":  {ModTime: now, Size: 5},
+		"src/new.js": {ModTime: now, Size: 3},
+	}
+	changed := diffWorkspaceSnapshots(previous, current)
+	if !reflect.DeepEqual(changed, []string{"old/file.js", "src/app.js", "src/new.js"}) {
+		t.Fatalf("unexpected changes: %v", changed)
+	}
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "node_modules", "pkg"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "node_modules", "pkg", "index.js"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "main.go"), []byte("package main"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	snapshot, err := scanWorkspace(root)
+	if err != nil {
+		t.Fatalf("scanWorkspace failed: %v", err)
+	}
+	if _, ok := snapshot["main.go"]; !ok {
+		t.Fatalf("expected main.go in snapshot: %v", snapshot)
+	}
+	if _, ok := snapshot["node_modules/pkg/index.js"]; ok {
+		t.Fatalf("node_modules should be skipped: %v", snapshot)
+	}
+}
+
+func TestRescueStatusSurvivesPSAndTimeoutIsValidated(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--rescue-timeout", "-1s")); err == nil || !strings.Contains(err.Error(), "--rescue-timeout") {
+		t.Fatalf("expected negative --rescue-timeout to be rejected, got %v", err)
+	}
+	if err := application.Run(baseArgs); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	instance.Status = "rescue"
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "rescue") {
+		t.Fatalf("expected ps to keep rescue status, got %s", out.String())
+	}
+}
+
+func TestRunReplicasLaunchesSuffixedInstancesOnConsecutivePorts(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--name", "web", "--port", "19100", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--replicas", "2", "--publish", "8080:80")); err == nil || !strings.Contains(err.Error(), "--replicas") {
+		t.Fatalf("expected --publish with --replicas to be rejected, got %v", err)
+	}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--replicas", "3")); err != nil {
+		t.Fatalf("run --replicas failed: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "replicas: 3/3 started") {
+		t.Fatalf("expected aggregate summary, got %s", out.String())
+	}
+
+	store, _, err := application.instanceStore()
+	if err != nil {
+		t.Fatalf("instance store: %v", err)
+	}
+	instances, err := store.List()
+	if err != nil {
+		t.Fatalf("list instances: %v", err)
+	}
+	if len(instances) != 3 {
+		t.Fatalf("expected 3 instances, got %d", len(instances))
+	}
+	ports := map[int]bool{}
+	for _, instance := range instances {
+		ports[instance.GatewayPort()] = true
+		if !strings.HasPrefix(instance.ID, "web-") {
+			t.Fatalf("expected replica id with web- prefix, got %s", instance.ID)
+		}
+	}
+	for _, port := range []int{19100, 19101, 19102} {
+		if !ports[port] {
+			t.Fatalf("expected a replica on gateway port %d, got %v", port, ports)
+		}
+	}
+	for _, name := range []string{"[web-1]", "[web-2]", "[web-3]"} {
+		if !strings.Contains(out.String(), name) {
+			t.Fatalf("expected output prefixed with %s, got %s", name, out.String())
+		}
+	}
+}
+
+func TestUnlockForceRefusesRunningInstanceAndClearsStaleLock(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "lock: mounted by pid 4001") {
+		t.Fatalf("expected audit to report the lock, got %s", out.String())
+	}
+
+	if err := application.Run([]string{"unlock", id}); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected unlock without --force to refuse, got %v", err)
+	}
+	if err := application.Run([]string{"unlock", id, "--force"}); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("expected unlock --force to refuse a running instance, got %v", err)
+	}
+
+	if err := backend.Stop(context.Background(), vm.Process{PID: 4001}); err != nil {
+		t.Fatalf("stop backend: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"unlock", id, "--force"}); err != nil {
+		t.Fatalf("unlock --force failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "unlocked "+id) {
+		t.Fatalf("unexpected unlock output: %s", out.String())
+	}
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "lock: free") {
+		t.Fatalf("expected lock to be free after unlock, got %s", out.String())
+	}
+}
+
+func TestRunJSONSpecClawboxFailsOnSHA256Mismatch(t *testing.T) {
+	data := t.TempDir()
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write([]byte("wrong-content"))
+	}))
+	defer server.Close()
+
+	workspace := t.TempDir()
+	specPath := filepath.Join(workspace, "sha-mismatch.clawbox")
+	specContent := `{
+  "name": "sha-mismatch",
+  "spec": {
+    "base_image": {
+      "ref": "ubuntu:24.04",
+      "url": "` + server.URL + `/base.img",
+      "sha256": "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+    },
+    "openclaw": {
+      "install_root": "/claw",
+      "model_primary": "openai/gpt-5",
+      "gateway_auth_mode": "none",
+      "required_env": ["OPENAI_API_KEY"]
+    }
+  }
+}`
+	if err := os.WriteFile(specPath, []byte(specContent), 0o644); err != nil {
+		t.Fatalf("write json spec clawbox: %v", err)
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", specPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
+	if err == nil {
+		t.Fatal("expected sha mismatch error")
+	}
+	if !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("vm should not start when sha mismatches")
+	}
+}
+
+func TestRunTarClawboxImportsRunImageAndClawDir(t *testing.T) {
+	data := t.TempDir()
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-disk-content")
+	runDisk := []byte("run-disk-content")
+	baseSHA := sha256Hex(baseDisk)
+	runSHA := sha256Hex(runDisk)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/base.qcow2":
+			_, _ = writer.Write(baseDisk)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+
+	clawboxPath := filepath.Join(workspace, "demo-v2.clawbox")
+	writeTarClawboxV2(t, clawboxPath, tarClawboxV2Fixture{
+		Name:    "demo-v2",
+		BaseRef: "ubuntu:24.04",
+		BaseURL: server.URL + "/base.qcow2",
+		BaseSHA: baseSHA,
+		RunRef:  "clawbox:///run.qcow2",
+		RunSHA:  runSHA,
+		RunDisk: runDisk,
+		ClawFiles: map[string]string{
+			"claw/SOUL.md": "hello",
+		},
+		RequiredEnv: []string{"OPENAI_API_KEY"},
+		Provision:   []map[string]string{{"name": "setup", "shell": "bash", "script": "echo setup"}},
+	})
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "demo-a", "--openclaw-openai-api-key", "test-key", "--trust"})
+	if err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("missing CLAWID output: %s", out.String())
+	}
+	if !strings.HasPrefix(id, "demo-a-") {
+		t.Fatalf("expected id prefix demo-a-, got %s", id)
+	}
+
+	clawRoot := filepath.Join(data, "claws", id)
+	runDiskPath := filepath.Join(clawRoot, "run.qcow2")
+	runDiskOnDisk, err := os.ReadFile(runDiskPath)
+	if err != nil {
+		t.Fatalf("read imported run disk: %v", err)
+	}
+	if !bytes.Equal(runDiskOnDisk, runDisk) {
+		t.Fatalf("unexpected run disk content")
+	}
+
+	if _, err := os.Stat(filepath.Join(clawRoot, "claw", "SOUL.md")); err != nil {
+		t.Fatalf("expected extracted claw dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(clawRoot, "clawspec.json")); err != nil {
+		t.Fatalf("expected imported clawspec: %v", err)
+	}
+
+	if backend.lastSpec.SourceDiskPath != runDiskPath {
+		t.Fatalf("unexpected source disk path: got %q want %q", backend.lastSpec.SourceDiskPath, runDiskPath)
+	}
+	if backend.lastSpec.ClawPath != filepath.Join(clawRoot, "claw") {
+		t.Fatalf("unexpected claw path in start spec: %q", backend.lastSpec.ClawPath)
+	}
+	if len(backend.lastSpec.CloudInitProvision) != 1 || backend.lastSpec.CloudInitProvision[0] != "echo setup" {
+		t.Fatalf("unexpected cloud-init provision scripts: %#v", backend.lastSpec.CloudInitProvision)
+	}
+
+	statePath := filepath.Join(data, "claws", id, "state.json")
+	mountState := readMountStateFile(t, statePath)
+	if mountState.SourcePath != "" {
+		t.Fatalf("expected no mount source for v2 tar clawbox, got %q", mountState.SourcePath)
+	}
+	if !mountState.Active {
+		t.Fatalf("expected mount state active=true")
+	}
+}
+
+func TestRunTarClawboxInstallsBundledOpenClawOffline(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-disk-content")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+
+	bundle := npmPackageTarball(t, "2.0.1")
+	digest := sha512.Sum512(bundle)
+	clawboxPath := filepath.Join(workspace, "offline.clawbox")
+	fixture := tarClawboxV2Fixture{
+		Name:           "offline",
+		BaseURL:        server.URL + "/base.qcow2",
+		BaseSHA:        sha256Hex(baseDisk),
+		RunRef:         "clawbox:///run.qcow2",
+		RunSHA:         sha256Hex([]byte("run-disk")),
+		RunDisk:        []byte("run-disk"),
+		ClawFiles:      map[string]string{"openclaw/bundle.tgz": string(bundle)},
+		OpenClawBundle: map[string]string{"path": "openclaw/bundle.tgz", "sha256": sha256Hex([]byte("other"))},
+	}
+	writeTarClawboxV2(t, clawboxPath, fixture)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	runArgs := []string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}
+	if err := application.Run(runArgs); err == nil || !strings.Contains(err.Error(), "openclaw.bundle: sha256 mismatch") {
+		t.Fatalf("expected bundle sha256 mismatch, got %v", err)
+	}
+
+	fixture.OpenClawBundle["sha256"] = sha256Hex(bundle)
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	out.Reset()
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if !backend.lastSpec.OpenClawOffline || backend.lastSpec.OpenClawTarballPath != filepath.Join(data, "claws", id, "openclaw", "bundle.tgz") {
+		t.Fatalf("expected offline bundle install, got offline=%v tarball=%q", backend.lastSpec.OpenClawOffline, backend.lastSpec.OpenClawTarballPath)
+	}
+
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	want := state.OpenClawPackage{Source: "bundle", Spec: "bundle.tgz", Version: "2.0.1", Integrity: "sha512-" + base64.StdEncoding.EncodeToString(digest[:])}
+	if instance.OpenClawPackage == nil || *instance.OpenClawPackage != want {
+		t.Fatalf("unexpected recorded openclaw package: %#v", instance.OpenClawPackage)
+	}
+}
+
+func TestRunTarClawboxRejectsOversizedAndLinkEntries(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-disk-content")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+
+	runDisk := bytes.Repeat([]byte("r"), 4096)
+	fixture := tarClawboxV2Fixture{
+		Name:    "limits",
+		BaseURL: server.URL + "/base.qcow2",
+		BaseSHA: sha256Hex(baseDisk),
+		RunRef:  "clawbox:///run.qcow2",
+		RunSHA:  sha256Hex(runDisk),
+		RunDisk: runDisk,
+	}
+	clawboxPath := filepath.Join(workspace, "limits.clawbox")
+	writeTarClawboxV2(t, clawboxPath, fixture)
+
+	application := NewWithBackend(&bytes.Buffer{}, &bytes.Buffer{}, newFakeBackend())
+	runArgs := []string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}
+
+	t.Setenv("CLAWFARM_CLAWBOX_MAX_ENTRY_BYTES", "1024")
+	err := application.Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "refusing .clawbox entry run.qcow2: 4.0KB exceeds the 1.0KB per-entry limit") {
+		t.Fatalf("expected per-entry limit error, got %v", err)
+	}
+	t.Setenv("CLAWFARM_CLAWBOX_MAX_ENTRY_BYTES", "")
+	t.Setenv("CLAWFARM_CLAWBOX_MAX_TOTAL_BYTES", "4100")
+	fixture.ClawFiles = map[string]string{"claw/notes.md": "more than four bytes"}
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	err = application.Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "total limit") {
+		t.Fatalf("expected total limit error, got %v", err)
+	}
+	t.Setenv("CLAWFARM_CLAWBOX_MAX_TOTAL_BYTES", "")
+
+	fixture.ClawFiles = nil
+	fixture.ClawSymlinks = map[string]string{"claw/passwd": "/etc/passwd"}
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	err = application.Run(runArgs)
+	if err == nil || !strings.Contains(err.Error(), "refusing .clawbox entry claw/passwd: links are not allowed") {
+		t.Fatalf("expected symlink rejection, got %v", err)
+	}
+}
+
+func TestTarClawboxManifestIsVerifiedOnImportAndWrittenOnExport(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-disk-content")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+
+	fixture := tarClawboxV2Fixture{
+		Name:      "signed",
+		BaseURL:   server.URL + "/base.qcow2",
+		BaseSHA:   sha256Hex(baseDisk),
+		RunRef:    "clawbox:///run.qcow2",
+		RunSHA:    sha256Hex([]byte("run-disk")),
+		RunDisk:   []byte("run-disk"),
+		ClawFiles: map[string]string{"claw/SOUL.md": "be kind"},
+		Manifest:  []map[string]string{{"path": "claw/SOUL.md", "sha256": sha256Hex([]byte("be evil"))}},
+	}
+	clawboxPath := filepath.Join(workspace, "signed.clawbox")
+	writeTarClawboxV2(t, clawboxPath, fixture)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	runArgs := []string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}
+	if err := application.Run(runArgs); err == nil || !strings.Contains(err.Error(), "claw/SOUL.md failed manifest verification") {
+		t.Fatalf("expected manifest mismatch, got %v", err)
+	}
+
+	fixture.Manifest[0]["sha256"] = sha256Hex([]byte("be kind"))
+	fixture.ClawFiles["claw/extra.md"] = "sneaky"
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	if err := application.Run(runArgs); err == nil || !strings.Contains(err.Error(), "claw/extra.md is not listed in the clawspec manifest") {
+		t.Fatalf("expected unlisted file error, got %v", err)
+	}
+
+	delete(fixture.ClawFiles, "claw/extra.md")
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	out.Reset()
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if err := os.WriteFile(instance.DiskPath, []byte("booted-disk"), 0o644); err != nil {
+		t.Fatalf("write instance disk: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "claws", id, "claw", "MEMORY.md"), []byte("learned things"), 0o644); err != nil {
+		t.Fatalf("write claw file: %v", err)
+	}
+
+	exportedPath := filepath.Join(workspace, "exported.clawbox")
+	if err := application.Run([]string{"export", id, exportedPath, "--name", "signed-copy"}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	spec, err := parseRunClawboxSpecV2(exportedPath)
+	if err != nil {
+		t.Fatalf("parse exported clawbox: %v", err)
+	}
+	wantManifest := []runClawboxFileV2{
+		{Path: "claw/MEMORY.md", SHA256: sha256Hex([]byte("learned things"))},
+		{Path: "claw/SOUL.md", SHA256: sha256Hex([]byte("be kind"))},
+	}
+	if spec.Name != "signed-copy" || !reflect.DeepEqual(spec.Manifest, wantManifest) {
+		t.Fatalf("unexpected exported spec: name=%q manifest=%#v", spec.Name, spec.Manifest)
+	}
+	if runImage, ok := spec.runImage(); !ok || runImage.SHA256 != sha256Hex([]byte("booted-disk")) {
+		t.Fatalf("expected exported run image to capture the instance disk, got %#v", runImage)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"run", exportedPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}); err != nil {
+		t.Fatalf("run exported clawbox failed: %v", err)
+	}
+}
+
+func TestTarClawboxZstdIsDetectedOnImportAndProducedOnExport(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	toolDir := t.TempDir()
+	script := "#!/bin/sh\nfor arg in \"$@\"; do\n  if [ \"$arg\" = \"-d\" ]; then\n    tail -c +5\n    exit $?\n  fi\ndone\nprintf '\\050\\265\\057\\375'\ncat\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "zstd"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake zstd: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-disk-content")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+	clawboxPath := filepath.Join(workspace, "demo.clawbox")
+	writeTarClawboxV2(t, clawboxPath, tarClawboxV2Fixture{
+		Name:      "demo",
+		BaseURL:   server.URL + "/base.qcow2",
+		BaseSHA:   sha256Hex(baseDisk),
+		RunRef:    "clawbox:///run.qcow2",
+		RunSHA:    sha256Hex([]byte("run-disk")),
+		RunDisk:   []byte("run-disk"),
+		ClawFiles: map[string]string{"claw/SOUL.md": "be kind"},
+	})
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	runArgs := []string{"--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}
+	if err := application.Run(append([]string{"run", clawboxPath}, runArgs...)); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if err := os.WriteFile(instance.DiskPath, []byte("booted-disk"), 0o644); err != nil {
+		t.Fatalf("write instance disk: %v", err)
+	}
+
+	magicOf := func(path string) []byte {
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return payload[:4]
+	}
+	gzipPath := filepath.Join(workspace, "gzip.clawbox")
+	if err := application.Run([]string{"export", id, gzipPath}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if !bytes.Equal(magicOf(gzipPath)[:2], []byte{0x1f, 0x8b}) {
+		t.Fatalf("expected gzip by default even with zstd installed, got % x", magicOf(gzipPath))
+	}
+	zstdPath := filepath.Join(workspace, "zstd.clawbox")
+	if err := application.Run([]string{"export", id, zstdPath, "--compression=zstd"}); err != nil {
+		t.Fatalf("export --compression zstd failed: %v", err)
+	}
+	if !bytes.Equal(magicOf(zstdPath), zstdMagic) {
+		t.Fatalf("expected zstd output, got % x", magicOf(zstdPath))
+	}
+	if err := application.Run([]string{"export", id, gzipPath, "--compression", "brotli"}); err == nil || !strings.Contains(err.Error(), "invalid --compression") {
+		t.Fatalf("expected invalid compression error, got %v", err)
+	}
+
+	spec, err := parseRunClawboxSpecV2(zstdPath)
+	if err != nil {
+		t.Fatalf("parse zstd clawbox: %v", err)
+	}
+	if spec.Name != "demo" || len(spec.Manifest) != 1 {
+		t.Fatalf("unexpected zstd clawbox spec: %#v", spec)
+	}
+	out.Reset()
+	if err := application.Run(append([]string{"run", zstdPath}, runArgs...)); err != nil {
+		t.Fatalf("run zstd clawbox failed: %v", err)
+	}
+	importedID := parseClawIDFromRunOutput(out.String())
+	soul, err := os.ReadFile(filepath.Join(data, "claws", importedID, "claw", "SOUL.md"))
+	if err != nil || string(soul) != "be kind" {
+		t.Fatalf("expected claw files from zstd clawbox, got %q (%v)", soul, err)
+	}
+}
+
+func TestRunTarClawboxStreamsSparseRunDisk(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	baseDisk := []byte("base-disk-content")
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+
+	runDisk := make([]byte, 0, 4<<20)
+	runDisk = append(runDisk, []byte("QFI\xfb")...)
+	runDisk = append(runDisk, make([]byte, 2<<20)...)
+	runDisk = append(runDisk, []byte("payload")...)
+	runDisk = append(runDisk, make([]byte, 1<<20)...)
+
+	workspace := t.TempDir()
+	fixture := tarClawboxV2Fixture{
+		Name:    "sparse",
+		BaseURL: server.URL + "/base.qcow2",
+		BaseSHA: sha256Hex(baseDisk),
+		RunRef:  "clawbox:///run.qcow2",
+		RunSHA:  sha256Hex([]byte("something else")),
+		RunDisk: runDisk,
+	}
+	clawboxPath := filepath.Join(workspace, "sparse.clawbox")
+	writeTarClawboxV2(t, clawboxPath, fixture)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	runArgs := []string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--trust"}
+	if err := application.Run(runArgs); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected run disk sha256 mismatch, got %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(data, "claws", "*", "run.qcow2*")); len(leftovers) != 0 {
+		t.Fatalf("expected a failed run disk import to be removed, got %v", leftovers)
+	}
+
+	fixture.RunSHA = sha256Hex(runDisk)
+	writeTarClawboxV2(t, clawboxPath, fixture)
+	out.Reset()
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	runDiskPath := filepath.Join(data, "claws", id, "run.qcow2")
+	imported, err := os.ReadFile(runDiskPath)
+	if err != nil {
+		t.Fatalf("read imported run disk: %v", err)
+	}
+	if !bytes.Equal(imported, runDisk) {
+		t.Fatalf("imported run disk differs from the clawbox entry")
+	}
+	if backend.lastSpec.SourceDiskPath != runDiskPath {
+		t.Fatalf("expected the VM to boot the streamed run disk, got %q", backend.lastSpec.SourceDiskPath)
+	}
+	if runtime.GOOS == "linux" {
+		info, err := os.Stat(runDiskPath)
+		if err != nil {
+			t.Fatalf("stat run disk: %v", err)
+		}
+		if stat := info.Sys().(*syscall.Stat_t); stat.Blocks*512 >= int64(len(runDisk)) {
+			t.Fatalf("expected zero blocks to be left as holes, got %d allocated bytes for %d", stat.Blocks*512, len(runDisk))
+		}
+	}
+}
+
+func TestRunTarClawboxAllowsMultipleInstancesFromSameFile(t *testing.T) {
+	data := t.TempDir()
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	workspace := t.TempDir()
+	baseDisk := []byte("base-for-multi")
+	runDisk := []byte("run-for-multi")
+	baseSHA := sha256Hex(baseDisk)
+	runSHA := sha256Hex(runDisk)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_, _ = writer.Write(baseDisk)
+	}))
+	defer server.Close()
+
+	clawboxPath := filepath.Join(workspace, "multi-v2.clawbox")
+	writeTarClawboxV2(t, clawboxPath, tarClawboxV2Fixture{
+		Name:        "multi-v2",
+		BaseRef:     "ubuntu:24.04",
+		BaseURL:     server.URL + "/base.qcow2",
+		BaseSHA:     baseSHA,
+		RunRef:      "clawbox:///run.qcow2",
+		RunSHA:      runSHA,
+		RunDisk:     runDisk,
+		RequiredEnv: []string{"OPENAI_API_KEY"},
+	})
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "multi-a", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	idA := parseClawIDFromRunOutput(out.String())
+	if idA == "" {
+		t.Fatalf("missing first CLAWID")
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--name", "multi-b", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	idB := parseClawIDFromRunOutput(out.String())
+	if idB == "" {
+		t.Fatalf("missing second CLAWID")
+	}
+
+	if idA == idB {
+		t.Fatalf("expected different CLAWID for two runs from same .clawbox")
+	}
+	if !strings.HasPrefix(idA, "multi-a-") || !strings.HasPrefix(idB, "multi-b-") {
+		t.Fatalf("expected name-prefixed ids, got %q and %q", idA, idB)
+	}
+}
+
+func TestRunTarClawboxFailsWhenMissingSpec(t *testing.T) {
+	data := t.TempDir()
+	home := t.TempDir()
+	if err := os.Setenv("HOME", home); err != nil {
+		t.Fatalf("set HOME env: %v", err)
+	}
+	defer os.Unsetenv("HOME")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	workspace := t.TempDir()
+	clawboxPath := filepath.Join(workspace, "broken-v2.clawbox")
+	writeTarClawboxWithoutSpec(t, clawboxPath)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key"})
+	if err == nil {
+		t.Fatal("expected run to fail when clawspec.json is missing")
+	}
+	if !strings.Contains(err.Error(), "missing clawspec.json") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("vm should not start on invalid tar clawbox")
+	}
+}
+
+func TestExportCopiesClawboxSource(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	exportPath := filepath.Join(t.TempDir(), "exported.clawbox")
+	out.Reset()
+	if err := application.Run([]string{"export", id, exportPath}); err != nil {
+		t.Fatalf("export command failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "exported "+id) {
+		t.Fatalf("export output missing success marker: %s", out.String())
+	}
+
+	sourceContent, err := os.ReadFile(clawboxPath)
+	if err != nil {
+		t.Fatalf("read source clawbox: %v", err)
+	}
+	exportedContent, err := os.ReadFile(exportPath)
+	if err != nil {
+		t.Fatalf("read exported clawbox: %v", err)
+	}
+	if !bytes.Equal(sourceContent, exportedContent) {
+		t.Fatal("exported clawbox content does not match source clawbox")
+	}
+}
+
+func TestExportWithNameOverridesHeaderName(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	exportPath := filepath.Join(t.TempDir(), "renamed.clawbox")
+	if err := application.Run([]string{"export", id, exportPath, "--name", "renamed-openclaw"}); err != nil {
+		t.Fatalf("export with --name failed: %v", err)
+	}
+
+	header, err := clawbox.LoadHeaderJSON(exportPath)
+	if err != nil {
+		t.Fatalf("load exported clawbox header: %v", err)
+	}
+	if header.Name != "renamed-openclaw" {
+		t.Fatalf("expected renamed header name, got %q", header.Name)
+	}
+	if header.Spec.BaseImage.Ref != "ubuntu:24.04" {
+		t.Fatalf("unexpected base image ref in exported clawbox: %s", header.Spec.BaseImage.Ref)
+	}
+}
+
+func TestExportWithInvalidNameFails(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	err := application.Run([]string{"export", id, filepath.Join(t.TempDir(), "invalid-name.clawbox"), "--name", "INVALID_NAME"})
+	if err == nil {
+		t.Fatal("expected export to fail for invalid --name")
+	}
+	if !strings.Contains(err.Error(), "invalid --name") {
+		t.Fatalf("unexpected error for invalid --name: %v", err)
+	}
+}
+
+func TestExportBlocksPossibleSecretsByDefault(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	if err := os.WriteFile(clawboxPath, []byte("{\"OPENAI_API_KEY\":\"sk-secret-value-1234567890123456\"}\n"), 0o644); err != nil {
+		t.Fatalf("inject possible secret into source clawbox: %v", err)
+	}
+
+	exportPath := filepath.Join(t.TempDir(), "blocked.clawbox")
+	err := application.Run([]string{"export", id, exportPath})
+	if err == nil {
+		t.Fatal("expected export to be blocked by secret scan")
+	}
+	if !strings.Contains(err.Error(), "export blocked: detected possible secrets") {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+	if _, statErr := os.Stat(exportPath); !errors.Is(statErr, os.ErrNotExist) {
+		t.Fatalf("expected no exported artifact after blocked export")
+	}
+}
+
+func TestExportAllowsPossibleSecretsWithFlag(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	if err := os.WriteFile(clawboxPath, []byte("{\"OPENAI_API_KEY\":\"sk-secret-value-1234567890123456\"}\n"), 0o644); err != nil {
+		t.Fatalf("inject possible secret into source clawbox: %v", err)
+	}
+
+	out.Reset()
+	errOut.Reset()
+	exportPath := filepath.Join(t.TempDir(), "allowed.clawbox")
+	err := application.Run([]string{"export", id, exportPath, "--allow-secrets"})
+	if err != nil {
+		t.Fatalf("expected export success with --allow-secrets, got %v", err)
+	}
+	if !strings.Contains(errOut.String(), "warning: exporting with possible secrets") {
+		t.Fatalf("expected warning output when using --allow-secrets, got: %s", errOut.String())
+	}
+	if _, statErr := os.Stat(exportPath); statErr != nil {
+		t.Fatalf("expected exported artifact with --allow-secrets: %v", statErr)
+	}
+}
+
+func TestExportFailsForNonClawboxInstance(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	err := application.Run([]string{"export", id, filepath.Join(t.TempDir(), "not-clawbox.clawbox")})
+	if err == nil {
+		t.Fatal("expected export to fail for non-clawbox-backed instance")
+	}
+	if !strings.Contains(err.Error(), "not clawbox-backed") {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+}
+
+func TestExportFailsWhenInstanceLockBusy(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo-openclaw.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", clawboxPath, "--workspace=" + workspace, "--no-wait", "--openclaw-openai-api-key", "test-key", "--openclaw-gateway-token", "test-gateway-token"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	lockManager, err := application.lockManager()
+	if err != nil {
+		t.Fatalf("lock manager: %v", err)
+	}
+
+	lockReady := make(chan struct{})
+	lockDone := make(chan error, 1)
+	releaseLock := make(chan struct{})
+	go func() {
+		lockDone <- lockManager.WithInstanceLock(id, func() error {
+			close(lockReady)
+			<-releaseLock
+			return nil
+		})
+	}()
+
+	select {
+	case <-lockReady:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for lock holder")
+	}
+
+	err = application.Run([]string{"export", id, filepath.Join(t.TempDir(), "busy.clawbox")})
+	if !errors.Is(err, state.ErrBusy) {
+		t.Fatalf("expected state.ErrBusy, got %v", err)
+	}
+
+	close(releaseLock)
+	select {
+	case lockErr := <-lockDone:
+		if lockErr != nil {
+			t.Fatalf("lock holder failed: %v", lockErr)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting lock holder to exit")
+	}
+}
+
+func TestCheckpointAndRestoreCopiesDisk(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if strings.TrimSpace(instance.DiskPath) == "" {
+		t.Fatalf("instance disk path should not be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(instance.DiskPath), 0o755); err != nil {
+		t.Fatalf("mkdir instance disk dir: %v", err)
+	}
+	if err := os.WriteFile(instance.DiskPath, []byte("disk-v1"), 0o644); err != nil {
+		t.Fatalf("seed disk: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"checkpoint", id, "--name", "snap-one"}); err != nil {
+		t.Fatalf("checkpoint command failed: %v", err)
+	}
+	checkpointPath := checkpointPathForName(filepath.Join(data, "claws"), id, "snap-one")
+	checkpointContent, err := os.ReadFile(checkpointPath)
+	if err != nil {
+		t.Fatalf("read checkpoint file: %v", err)
+	}
+	if string(checkpointContent) != "disk-v1" {
+		t.Fatalf("unexpected checkpoint content: %q", string(checkpointContent))
+	}
+
+	if err := os.WriteFile(instance.DiskPath, []byte("disk-v2"), 0o644); err != nil {
+		t.Fatalf("overwrite disk: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"restore", id, "snap-one"}); err != nil {
+		t.Fatalf("restore command failed: %v", err)
+	}
+	restoredContent, err := os.ReadFile(instance.DiskPath)
+	if err != nil {
+		t.Fatalf("read restored disk: %v", err)
+	}
+	if string(restoredContent) != "disk-v1" {
+		t.Fatalf("unexpected restored content: %q", string(restoredContent))
+	}
+}
+
+func TestCloneForksInstanceWithFreshPortsAndIdentity(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, backend)
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	sourceID := parseClawIDFromRunOutput(out.String())
+	clawsRoot := filepath.Join(data, "claws")
+	store := state.NewStore(clawsRoot)
+	source, err := store.Load(sourceID)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	sourceDir := filepath.Join(clawsRoot, sourceID)
+	if err := os.WriteFile(source.DiskPath, []byte("disk"), 0o644); err != nil {
+		t.Fatalf("seed disk: %v", err)
+	}
+	source.SSHHostPort = 2222
+	source.SSHKeyPath = filepath.Join(sourceDir, "ssh", "id_ed25519")
+	if err := os.MkdirAll(filepath.Dir(source.SSHKeyPath), 0o700); err != nil {
+		t.Fatalf("create ssh dir: %v", err)
+	}
+	if err := os.WriteFile(source.SSHKeyPath+".pub", []byte("ssh-ed25519 AAAAsource clawfarm-run\n"), 0o644); err != nil {
+		t.Fatalf("write source key: %v", err)
+	}
+	if err := store.Save(source); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+	spec, err := readInstanceStartSpec(sourceDir)
+	if err != nil {
+		t.Fatalf("read start spec: %v", err)
+	}
+	spec.SSHAuthorizedKeys = []string{"ssh-ed25519 AAAAsource clawfarm-run"}
+	spec.PublishedPorts = append(spec.PublishedPorts, vm.PortMapping{HostPort: 2222, GuestPort: 22})
+	spec.StatePath = filepath.Join(sourceDir, "state")
+	if err := os.MkdirAll(spec.StatePath, 0o755); err != nil {
+		t.Fatalf("create state dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(spec.StatePath, "openclaw.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatalf("write state: %v", err)
+	}
+	if err := writeInstanceStartSpec(sourceDir, spec); err != nil {
+		t.Fatalf("write start spec: %v", err)
+	}
+
+	toolDir := t.TempDir()
+	script := "#!/bin/sh\nfor last; do :; done\ncase \"$1\" in\ncreate) echo overlay > \"$last\" ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if err := application.Run([]string{"clone", sourceID, "--overlay"}); err == nil || !strings.Contains(err.Error(), "--overlay needs --checkpoint") {
+		t.Fatalf("expected --overlay to need a checkpoint, got %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"clone", sourceID, "--name", "fork", "--no-start"}); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	match := regexp.MustCompile(`cloned \S+ -> (fork-[0-9a-f]+)`).FindStringSubmatch(out.String())
+	if match == nil {
+		t.Fatalf("unexpected clone output %q", out.String())
+	}
+	cloneID := match[1]
+	clone, err := store.Load(cloneID)
+	if err != nil {
+		t.Fatalf("load clone: %v", err)
+	}
+	cloneDir := filepath.Join(clawsRoot, cloneID)
+	if clone.Status != instanceStatusStopped || clone.PID != 0 || !clone.DirtyShutdown || clone.ClonedFrom != sourceID {
+		t.Fatalf("unexpected clone state %+v", clone)
+	}
+	if disk, err := os.ReadFile(clone.DiskPath); err != nil || string(disk) != "disk" || filepath.Dir(clone.DiskPath) != cloneDir {
+		t.Fatalf("expected a copied disk in %s, got %s %q (%v)", cloneDir, clone.DiskPath, disk, err)
+	}
+	if clone.GatewayPort() == source.GatewayPort() || clone.GatewayPort() <= 0 || clone.SSHHostPort == 2222 || clone.SSHHostPort <= 0 {
+		t.Fatalf("expected fresh host ports, got gateway %d ssh %d", clone.GatewayPort(), clone.SSHHostPort)
+	}
+	if clone.SSHKeyPath != filepath.Join(cloneDir, "ssh", "id_ed25519") {
+		t.Fatalf("expected a new ssh key, got %s", clone.SSHKeyPath)
+	}
+	cloneSpec, err := readInstanceStartSpec(cloneDir)
+	if err != nil {
+		t.Fatalf("read clone start spec: %v", err)
+	}
+	if cloneSpec.InstanceID != cloneID || cloneSpec.SourceDiskPath != clone.DiskPath || cloneSpec.GatewayHostPort != clone.GatewayPort() || cloneSpec.GatewayGuestPort != spec.GatewayGuestPort {
+		t.Fatalf("unexpected clone start spec %+v", cloneSpec)
+	}
+	if len(cloneSpec.SSHAuthorizedKeys) != 1 || strings.Contains(cloneSpec.SSHAuthorizedKeys[0], "AAAAsource") {
+		t.Fatalf("expected the source key to be replaced, got %v", cloneSpec.SSHAuthorizedKeys)
+	}
+	if mapping := cloneSpec.PublishedPorts[len(cloneSpec.PublishedPorts)-1]; mapping.HostPort != clone.SSHHostPort || mapping.GuestPort != 22 {
+		t.Fatalf("expected the ssh forward to move to %d, got %+v", clone.SSHHostPort, mapping)
+	}
+	if info, err := os.Stat(filepath.Join(cloneDir, "state", "openclaw.json")); err != nil || info.Mode().Perm() != 0o600 || cloneSpec.StatePath != filepath.Join(cloneDir, "state") {
+		t.Fatalf("expected the state dir to be copied privately, got %v (%v)", info, err)
+	}
+
+	if err := application.Run([]string{"start", cloneID, "--no-wait"}); err != nil {
+		t.Fatalf("start clone failed: %v", err)
+	}
+	if backend.lastSpec.InstanceID != cloneID || backend.lastSpec.SourceDiskPath != clone.DiskPath || !backend.lastSpec.UncleanShutdown {
+		t.Fatalf("expected the clone to boot its own disk with a disk check, got %+v", backend.lastSpec)
+	}
+
+	if err := application.Run([]string{"checkpoint", sourceID, "--name", "base"}); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"clone", sourceID, "--checkpoint", "base", "--overlay", "--no-start"}); err != nil {
+		t.Fatalf("overlay clone failed: %v", err)
+	}
+	overlayID := regexp.MustCompile(`-> (\S+)`).FindStringSubmatch(out.String())[1]
+	overlay, err := store.Load(overlayID)
+	if err != nil {
+		t.Fatalf("load overlay clone: %v", err)
+	}
+	checkpointPath := checkpointPathForName(clawsRoot, sourceID, "base")
+	if overlay.BackingCheckpoint != checkpointPath || overlay.ClonedFrom != sourceID+"@base" || overlay.DirtyShutdown {
+		t.Fatalf("unexpected overlay clone state %+v", overlay)
+	}
+	if disk, err := os.ReadFile(overlay.DiskPath); err != nil || string(disk) != "overlay\n" {
+		t.Fatalf("expected a qcow2 overlay disk, got %q (%v)", disk, err)
+	}
+	for _, args := range [][]string{
+		{"checkpoint", "rm", sourceID, "--name", "base"},
+		{"checkpoint", sourceID, "--name", "base"},
+		{"rm", sourceID},
+	} {
+		if err := application.Run(args); err == nil || !strings.Contains(err.Error(), overlayID) {
+			t.Fatalf("expected %v to be refused while %s depends on the checkpoint, got %v", args, overlayID, err)
+		}
+	}
+	if _, err := os.Stat(checkpointPath); err != nil {
+		t.Fatalf("expected the backing checkpoint to survive: %v", err)
+	}
+}
+
+func TestCheckpointListRemoveAndKeep(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &bytes.Buffer{}, newFakeBackend())
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if err := os.WriteFile(instance.DiskPath, []byte("disk"), 0o644); err != nil {
+		t.Fatalf("seed disk: %v", err)
+	}
+
+	clawsRoot := filepath.Join(data, "claws")
+	created := time.Now().Add(-time.Hour)
+	for index, name := range []string{"one", "two", "three"} {
+		args := []string{"checkpoint", id, "--name", name}
+		if name == "three" {
+			args = append(args, "--keep", "2")
+		}
+		out.Reset()
+		if err := application.Run(args); err != nil {
+			t.Fatalf("checkpoint %s failed: %v", name, err)
+		}
+		stamp := created.Add(time.Duration(index) * time.Minute)
+		if err := os.Chtimes(checkpointPathForName(clawsRoot, id, name), stamp, stamp); err != nil {
+			t.Fatalf("age checkpoint: %v", err)
+		}
+		if name == "two" {
+			if err := os.WriteFile(checkpointMemoryPath(checkpointPathForName(clawsRoot, id, name)), []byte("memory"), 0o644); err != nil {
+				t.Fatalf("write memory state: %v", err)
+			}
+		}
+	}
+	if !strings.Contains(out.String(), "pruned checkpoint one of "+id) {
+		t.Fatalf("expected --keep 2 to prune the oldest checkpoint, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"checkpoint", "ls", id}); err != nil {
+		t.Fatalf("checkpoint ls failed: %v", err)
+	}
+	if strings.Contains(out.String(), "one") || !regexp.MustCompile(`(?m)^two\s+\S+\s+10B\s+yes$`).MatchString(out.String()) || !strings.Contains(out.String(), "2 checkpoints, 14B total") {
+		t.Fatalf("unexpected checkpoint ls output:\n%s", out.String())
+	}
+
+	if err := application.Run([]string{"checkpoint", "rm", id, "--name", "two"}); err != nil {
+		t.Fatalf("checkpoint rm failed: %v", err)
+	}
+	if _, err := os.Stat(checkpointMemoryPath(checkpointPathForName(clawsRoot, id, "two"))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected memory state to be removed with its checkpoint, got %v", err)
+	}
+	err = application.Run([]string{"checkpoint", "rm", id, "--name", "two"})
+	if err == nil || !strings.Contains(err.Error(), "checkpoint two not found") {
+		t.Fatalf("expected removing a missing checkpoint to fail, got %v", err)
+	}
+	checkpoints, err := listCheckpoints(clawsRoot, id)
+	if err != nil || len(checkpoints) != 1 || checkpoints[0].Name != "three" {
+		t.Fatalf("expected only checkpoint three to remain, got %+v (%v)", checkpoints, err)
+	}
+}
+
+func TestCommitCreatesRunnableImage(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if err := os.WriteFile(instance.DiskPath, []byte("prepared-disk"), 0o644); err != nil {
+		t.Fatalf("seed disk: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"commit", id, "mydev:v1"}); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "committed "+id+" -> mydev:v1") {
+		t.Fatalf("unexpected commit output: %s", out.String())
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"new", "mydev:v1", "--workspace=."}); err != nil {
+		t.Fatalf("new from committed image failed: %v", err)
+	}
+	childID := parseClawIDFromRunOutput(out.String())
+	payload, err := os.ReadFile(filepath.Join(data, "claws", childID, "instance.img"))
+	if err != nil || string(payload) != "prepared-disk" {
+		t.Fatalf("expected child disk from committed image, got %q err=%v", payload, err)
+	}
+
+	if err := application.Run([]string{"commit", id, "ubuntu:mine"}); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("expected reserved name error, got %v", err)
+	}
+}
+
+func TestCheckpointRequiresName(t *testing.T) {
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"checkpoint", "claw-1234"})
+	if err == nil {
+		t.Fatal("expected checkpoint usage error")
+	}
+	if !strings.Contains(err.Error(), "checkpoint name is required") {
+		t.Fatalf("unexpected checkpoint error: %v", err)
+	}
+}
+
+func TestRestoreFailsWhenCheckpointMissing(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from run output: %s", out.String())
+	}
+
+	err := application.Run([]string{"restore", id, "missing-snapshot"})
+	if err == nil {
+		t.Fatal("expected restore failure for missing checkpoint")
+	}
+	if !strings.Contains(err.Error(), "checkpoint missing-snapshot not found") {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+}
+
+func TestRunRequiresImage(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
+	if err == nil {
+		t.Fatalf("expected error for missing image")
+	}
+	if !strings.Contains(err.Error(), "image ubuntu:24.04 is not ready") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNewRejectsRelativeVolumeGuestPath(t *testing.T) {
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"new", "ubuntu:24.04", "--volume", ".openclaw:root/.openclaw"})
+	if err == nil {
+		t.Fatal("expected new command to fail for non-absolute guest volume path")
+	}
+	if !strings.Contains(err.Error(), "guest path must be absolute") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunWaitTimeout(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--port=65530", "--ready-timeout-secs=1", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
+	if err == nil {
+		t.Fatalf("expected timeout error")
+	}
+	if !strings.Contains(err.Error(), "gateway is not reachable yet") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRunWaitsForExtraReadinessTargets(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	// The fake guest is this host: port probes run locally, everything else
+	// over ssh succeeds.
+	toolDir := t.TempDir()
+	sshScript := "#!/bin/bash\ncase \"${@: -1}\" in *dev/tcp*) exec bash -c \"${@: -1}\";; esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(toolDir, "ssh"), []byte(sshScript), 0o755); err != nil {
+		t.Fatalf("write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", toolDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	guestService, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer guestService.Close()
+	guestServicePort := guestService.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=.", fmt.Sprintf("--port=%d", gatewayPort), "--ready-timeout-secs=3", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	healthURL := gateway.URL + "/health"
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--wait-for", healthURL, "--wait-for", fmt.Sprintf("port:%d", guestServicePort))); err != nil {
+		t.Fatalf("run with reachable wait-for targets failed: %v\n%s", err, errOut.String())
+	}
+	if !strings.Contains(out.String(), "status: ready (") || !strings.Contains(out.String(), healthURL) {
+		t.Fatalf("expected ready status listing wait-for targets: %s", out.String())
+	}
+
+	out.Reset()
+	err = application.Run(append(append([]string(nil), baseArgs...), "--wait-for", fmt.Sprintf("port:%d", closedPort)))
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("readiness target port:%d is not reachable yet", closedPort)) {
+		t.Fatalf("expected unreachable wait-for error, got %v", err)
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--wait-for", "tcp:8080"))
+	if err == nil || !strings.Contains(err.Error(), "invalid wait-for value") {
+		t.Fatalf("expected invalid wait-for error, got %v", err)
+	}
+	instance := state.Instance{Gateways: []state.Gateway{{Name: state.PrimaryGatewayName, HostPort: 18790, GuestPort: 18789}}, PublishedPorts: []state.PortMapping{{HostPort: 8080, GuestPort: 80}}}
+	for port, expected := range map[int]int{18790: 18789, 8080: 80, 5432: 5432} {
+		if got := guestPortFor(instance, port); got != expected {
+			t.Fatalf("guestPortFor(%d) = %d, want %d", port, got, expected)
+		}
+	}
+}
+
+func TestRunExecutesPreStartAndPostReadyHooks(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	gatewayPort := gateway.Listener.Addr().(*net.TCPAddr).Port
+
+	hookLog := filepath.Join(t.TempDir(), "hooks.log")
+	t.Setenv("CLAWFARM_POST_READY_HOOK", "echo post-ready $CLAWFARM_CLAWID $CLAWFARM_VM_PID $CLAWFARM_GATEWAY_URL >> "+hookLog)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=.", fmt.Sprintf("--port=%d", gatewayPort), "--ready-timeout-secs=2", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--pre-start-hook", "echo $CLAWFARM_HOOK $CLAWFARM_CLAWID $CLAWFARM_GATEWAY_PORT >> "+hookLog)); err != nil {
+		t.Fatalf("run with hooks failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	payload, err := os.ReadFile(hookLog)
+	if err != nil {
+		t.Fatalf("read hook log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(payload)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two hook invocations, got %q", string(payload))
+	}
+	if lines[0] != fmt.Sprintf("pre-start %s %d", id, gatewayPort) {
+		t.Fatalf("unexpected pre-start hook env: %q", lines[0])
+	}
+	if lines[1] != fmt.Sprintf("post-ready %s %d http://127.0.0.1:%d/", id, backend.nextPID, gatewayPort) {
+		t.Fatalf("unexpected post-ready hook env: %q", lines[1])
+	}
+	if len(backend.running) != 1 {
+		t.Fatalf("expected one running VM, got %d", len(backend.running))
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--pre-start-hook", "exit 3"))
+	if err == nil || !strings.Contains(err.Error(), "pre-start hook 1 failed") {
+		t.Fatalf("expected pre-start hook failure, got %v", err)
+	}
+	if len(backend.running) != 1 {
+		t.Fatalf("expected failed pre-start hook to skip VM start, got %d running", len(backend.running))
+	}
+}
+
+func TestRunNoWorkspaceSkipsHostShare(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--no-workspace", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run with --no-workspace failed: %v", err)
+	}
+	if !backend.lastSpec.NoWorkspace || backend.lastSpec.WorkspacePath != "" {
+		t.Fatalf("expected workspace share disabled in spec, got NoWorkspace=%v WorkspacePath=%q", backend.lastSpec.NoWorkspace, backend.lastSpec.WorkspacePath)
+	}
+	if !strings.Contains(out.String(), "workspace: none") {
+		t.Fatalf("expected run output to report no workspace: %s", out.String())
+	}
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--no-workspace", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Fatalf("expected conflicting workspace flags error, got %v", err)
+	}
+}
+
+func TestRunStateModeDiskSkipsHostStateDir(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--state-mode", "disk")); err != nil {
+		t.Fatalf("run with --state-mode disk failed: %v", err)
+	}
+	if backend.lastSpec.StateMode != vm.StateModeDisk || backend.lastSpec.StatePath != "" {
+		t.Fatalf("expected disk state mode without a host state path, got %q %q", backend.lastSpec.StateMode, backend.lastSpec.StatePath)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if _, err := os.Stat(filepath.Join(data, "claws", id, "state")); !os.IsNotExist(err) {
+		t.Fatalf("did not expect a host state directory, stat err: %v", err)
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.StateMode != vm.StateModeDisk {
+		t.Fatalf("expected state mode recorded in metadata, got %q", instance.StateMode)
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--state-mode", "tmp"))
+	if err == nil || !strings.Contains(err.Error(), "invalid --state-mode") {
+		t.Fatalf("expected invalid state mode error, got %v", err)
+	}
+	err = application.Run(append(append([]string(nil), baseArgs...), "--state-mode", "disk", "--rootfs", "ro-overlay"))
+	if err == nil || !strings.Contains(err.Error(), "ro-overlay") {
+		t.Fatalf("expected disk state with ro-overlay rootfs to be rejected, got %v", err)
+	}
+}
+
+func TestRunBackendFlagSelectsVZF(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &out, backend)
+
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--backend", "vzf")); err != nil {
+		t.Fatalf("run with --backend vzf failed: %v", err)
+	}
+	if backend.lastSpec.Backend != vm.BackendVZF {
+		t.Fatalf("expected vzf backend in start spec, got %q", backend.lastSpec.Backend)
+	}
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(parseClawIDFromRunOutput(out.String()))
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.Backend != vm.BackendVZF {
+		t.Fatalf("expected vzf backend recorded in metadata, got %q", instance.Backend)
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--backend", "firecracker"))
+	if err == nil || !strings.Contains(err.Error(), "invalid --backend") {
+		t.Fatalf("expected invalid backend error, got %v", err)
+	}
+	err = application.Run(append(append([]string(nil), baseArgs...), "--backend", "vzf", "--hardened"))
+	if err == nil || !strings.Contains(err.Error(), "--backend vzf cannot be combined") {
+		t.Fatalf("expected vzf with --hardened to be rejected, got %v", err)
+	}
+}
+
+func TestRunConfiguresGuestUserAndAuthorizedKeys(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	t.Setenv("CLAWFARM_GUEST_SUDO", "password")
+	seedFetchedImage(t, cache)
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519.pub")
+	hostKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHostKeyForTests dev@laptop"
+	if err := os.WriteFile(keyFile, []byte("# laptop\n"+hostKey+"\n"), 0o644); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &out, backend)
+	baseArgs := []string{"run", "ubuntu:24.04", "--workspace=" + t.TempDir(), "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(append(append([]string(nil), baseArgs...), "--guest-user", "dev", "--ssh-authorized-key", keyFile)); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, out.String())
+	}
+
+	spec := backend.lastSpec
+	if spec.GuestUser.Name != "dev" || spec.GuestUser.Sudo != vm.GuestSudoPassword || spec.GuestUser.PasswordLogin {
+		t.Fatalf("unexpected guest user in start spec: %+v", spec.GuestUser)
+	}
+	if len(spec.SSHAuthorizedKeys) != 2 || spec.SSHAuthorizedKeys[0] != hostKey {
+		t.Fatalf("expected host key plus the instance key, got %v", spec.SSHAuthorizedKeys)
+	}
+	if !strings.Contains(out.String(), "ssh: dev@127.0.0.1:") {
+		t.Fatalf("expected ssh endpoint for the guest user, got %s", out.String())
+	}
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(parseClawIDFromRunOutput(out.String()))
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.SSHUser() != "dev" || !strings.Contains(renderSSHConfig([]state.Instance{instance}), "User dev") {
+		t.Fatalf("expected guest user to be recorded for ssh, got %q", instance.GuestUser)
+	}
+
+	err = application.Run(append(append([]string(nil), baseArgs...), "--run", "true"))
+	if err == nil || !strings.Contains(err.Error(), "--guest-sudo nopasswd") {
+		t.Fatalf("expected --run to require passwordless sudo, got %v", err)
+	}
+	err = application.Run(append(append([]string(nil), baseArgs...), "--guest-user", "root"))
+	if err == nil || !strings.Contains(err.Error(), "invalid guest user") {
+		t.Fatalf("expected root guest user to be rejected, got %v", err)
+	}
+}
+
+func TestTestBackendRunsLifecycleEndToEnd(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	port, err := findAvailableLoopbackPort()
+	if err != nil {
+		t.Fatalf("find port: %v", err)
+	}
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	backend := vm.NewHostBackend(&out)
+	application := NewWithBackend(&out, &errOut, backend)
+	err = application.Run([]string{"run", "ubuntu:24.04", "--backend", "test", "--workspace=" + t.TempDir(), "--port", strconv.Itoa(port), "--ready-timeout-secs", "20", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
+	if err != nil {
+		t.Fatalf("run --backend test failed: %v\n%s%s", err, out.String(), errOut.String())
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	defer func() {
+		_ = syscall.Kill(instance.PID, syscall.SIGKILL)
+	}()
+	if instance.Backend != vm.BackendTest || instance.QEMUAccel != "test" || !backend.IsRunning(instance.PID) {
+		t.Fatalf("expected a running fake qemu process, got %+v", instance)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), id) || !strings.Contains(out.String(), "ready") {
+		t.Fatalf("expected ps to report the instance ready, got %s", out.String())
+	}
+
+	if err := application.Run([]string{"checkpoint", id, "--name", "before"}); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"restore", id, "before"}); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "restored "+id) || !backend.IsRunning(instance.PID) {
+		t.Fatalf("expected restore to keep the fake guest running, got %s", out.String())
+	}
+	if status, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port)); err != nil || status.StatusCode != http.StatusOK {
+		t.Fatalf("expected fake gateway to answer after restore, got %v %v", status, err)
+	} else {
+		_ = status.Body.Close()
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"update", id, "--memory-mib", "2048", "--cpus", "4"}); err != nil {
+		t.Fatalf("update failed: %v\n%s", err, errOut.String())
+	}
+	if !strings.Contains(out.String(), id+": memory 2048 MiB applied live") || !strings.Contains(out.String(), id+": cpus 4 takes effect on next start") {
+		t.Fatalf("unexpected update output %q", out.String())
+	}
+	if log, err := os.ReadFile(instance.QEMULogPath); err != nil || !strings.Contains(string(log), "balloon target 2048 MiB") {
+		t.Fatalf("expected fake qemu to inflate the balloon, got %q (%v)", log, err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"update", id, "--memory-mib", "8192"}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if !strings.Contains(out.String(), id+": memory 8192 MiB takes effect on next start") {
+		t.Fatalf("expected memory above the boot size to wait for a restart, got %q", out.String())
+	}
+	if spec, err := readInstanceStartSpec(filepath.Join(data, "claws", id)); err != nil || spec.CPUs != 4 || spec.MemoryMiB != 8192 {
+		t.Fatalf("expected update to be recorded for the next start, got %+v (%v)", spec, err)
+	}
+
+	err = application.Run([]string{"restore", id, "before", "--with-memory"})
+	if err == nil || !strings.Contains(err.Error(), "has no saved memory") {
+		t.Fatalf("expected disk-only checkpoint to be rejected for --with-memory, got %v", err)
+	}
+	if err := application.Run([]string{"checkpoint", id, "--name", "live", "--with-memory"}); err != nil {
+		t.Fatalf("checkpoint --with-memory failed: %v\n%s", err, errOut.String())
+	}
+	if _, err := os.Stat(filepath.Join(data, "claws", id, "checkpoints", "live.vmstate")); err != nil {
+		t.Fatalf("expected saved memory next to the checkpoint disk: %v", err)
+	}
+	previousPID := instance.PID
+	out.Reset()
+	if err := application.Run([]string{"restore", id, "live", "--with-memory"}); err != nil {
+		t.Fatalf("restore --with-memory failed: %v\n%s", err, errOut.String())
+	}
+	if instance, err = store.Load(id); err != nil {
+		t.Fatalf("reload instance: %v", err)
+	}
+	if !strings.Contains(out.String(), "(with memory)") || instance.PID == previousPID || !backend.IsRunning(instance.PID) || instance.DirtyShutdown {
+		t.Fatalf("expected restore to boot a new VM from saved memory, got %+v\n%s", instance, out.String())
+	}
+	if log, err := os.ReadFile(instance.QEMULogPath); err != nil || !strings.Contains(string(log), "restored memory state from") {
+		t.Fatalf("expected fake qemu to load the saved memory, got %q (%v)", log, err)
+	}
+
+	if err := application.Run([]string{"rm", id}); err != nil {
+		t.Fatalf("rm failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.IsRunning(instance.PID) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if backend.IsRunning(instance.PID) {
+		t.Fatalf("expected rm to stop fake qemu pid %d", instance.PID)
+	}
+	if _, err := store.Load(id); !errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected instance to be removed, got %v", err)
+	}
+}
+
+func TestImageLSShowsDownloadedMarker(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"image", "ls"}); err != nil {
+		t.Fatalf("image ls failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "ubuntu:24.04") {
+		t.Fatalf("image ls missing available image: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "	no") && !strings.Contains(out.String(), "  no") {
+		t.Fatalf("image ls missing non-downloaded marker: %s", out.String())
+	}
+
+	seedFetchedImage(t, cache)
+	out.Reset()
+	if err := application.Run([]string{"image", "ls"}); err != nil {
+		t.Fatalf("image ls failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "	yes") && !strings.Contains(out.String(), "  yes") {
+		t.Fatalf("image ls missing downloaded marker: %s", out.String())
+	}
+}
+
+func TestRunPassesExpandedOpenClawParameters(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	configPath := filepath.Join(t.TempDir(), "openclaw.json")
+	config := `{"agents":{"defaults":{"workspace":"/existing"}},"gateway":{"mode":"local"}}`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	envFile := filepath.Join(t.TempDir(), "openclaw.env")
+	envBody := "OPENAI_API_KEY=file-key\n# comment\nexport TELEGRAM_TOKEN=file-telegram\n"
+	if err := os.WriteFile(envFile, []byte(envBody), 0o644); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{
+		"run", "ubuntu:24.04",
+		"--workspace=.",
+		"--no-wait",
+		"--openclaw-config", configPath,
+		"--openclaw-env-file", envFile,
+		"--openclaw-agent-workspace", "/workspace",
+		"--openclaw-model-primary", "openai/gpt-5",
+		"--openclaw-gateway-auth-mode", "token",
+		"--openclaw-gateway-token", "token-from-flag",
+		"--openclaw-openai-api-key", "openai-from-flag",
+		"--openclaw-anthropic-api-key", "anthropic-from-flag",
+		"--openclaw-google-generative-ai-api-key", "google-from-flag",
+		"--openclaw-xai-api-key", "xai-from-flag",
+		"--openclaw-openrouter-api-key", "openrouter-from-flag",
+		"--openclaw-zai-api-key", "zai-from-flag",
+		"--openclaw-discord-token", "discord-from-flag",
+		"--openclaw-telegram-token", "telegram-from-flag",
+		"--openclaw-whatsapp-phone-number-id", "whatsapp-phone-id",
+		"--openclaw-whatsapp-access-token", "whatsapp-access-token",
+		"--openclaw-whatsapp-verify-token", "whatsapp-verify-token",
+		"--openclaw-whatsapp-app-secret", "whatsapp-app-secret",
+		"--openclaw-env", "OPENAI_API_KEY=from-openclaw-env",
+	})
+	if err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+
+	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"primary": "openai/gpt-5"`) {
+		t.Fatalf("missing primary model in config: %s", backend.lastSpec.OpenClawConfig)
+	}
+	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"workspace": "/workspace"`) {
+		t.Fatalf("missing workspace override in config: %s", backend.lastSpec.OpenClawConfig)
+	}
+	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"mode": "token"`) {
+		t.Fatalf("missing auth mode in config: %s", backend.lastSpec.OpenClawConfig)
+	}
+
+	if backend.lastSpec.OpenClawEnvironment["OPENAI_API_KEY"] != "openai-from-flag" {
+		t.Fatalf("expected explicit OpenAI arg to override env file and --openclaw-env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["OPENCLAW_GATEWAY_TOKEN"] != "token-from-flag" {
+		t.Fatalf("missing gateway token env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["ANTHROPIC_API_KEY"] != "anthropic-from-flag" {
+		t.Fatalf("missing Anthropic key env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["GOOGLE_GENERATIVE_AI_API_KEY"] != "google-from-flag" {
+		t.Fatalf("missing Google key env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["XAI_API_KEY"] != "xai-from-flag" {
+		t.Fatalf("missing xAI key env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["OPENROUTER_API_KEY"] != "openrouter-from-flag" {
+		t.Fatalf("missing OpenRouter key env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["ZAI_API_KEY"] != "zai-from-flag" {
+		t.Fatalf("missing Z.AI key env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["DISCORD_TOKEN"] != "discord-from-flag" {
+		t.Fatalf("missing Discord token env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["TELEGRAM_TOKEN"] != "telegram-from-flag" {
+		t.Fatalf("expected explicit Telegram arg to override env file")
+	}
+	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_PHONE_NUMBER_ID"] != "whatsapp-phone-id" {
+		t.Fatalf("missing WhatsApp phone number id env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_ACCESS_TOKEN"] != "whatsapp-access-token" {
+		t.Fatalf("missing WhatsApp access token env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_VERIFY_TOKEN"] != "whatsapp-verify-token" {
+		t.Fatalf("missing WhatsApp verify token env")
+	}
+	if backend.lastSpec.OpenClawEnvironment["WHATSAPP_APP_SECRET"] != "whatsapp-app-secret" {
+		t.Fatalf("missing WhatsApp app secret env")
+	}
+}
+
+func TestRunFailsFastForMissingRequiredOpenClawParameters(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
+	if err == nil {
+		t.Fatalf("expected missing parameter error")
+	}
+	if !strings.Contains(err.Error(), "--openclaw-model-primary") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("vm should not start before preflight validation")
+	}
+}
+
+func TestRunPromptsForMissingRequiredOpenClawParameters(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	input := strings.NewReader("openai/gpt-5\nprompt-openai-key\n")
+	application := NewWithIOAndBackend(&out, &errOut, input, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait"})
+	if err != nil {
+		t.Fatalf("run should succeed with prompted values: %v", err)
+	}
+	promptOutput := out.String()
 	if !strings.Contains(promptOutput, "openclaw> OpenClaw primary model") {
 		t.Fatalf("missing model prompt output: %s", promptOutput)
 	}
-	if !strings.Contains(promptOutput, "*****************") {
-		t.Fatalf("missing masked secret output: %s", promptOutput)
+	if !strings.Contains(promptOutput, "*****************") {
+		t.Fatalf("missing masked secret output: %s", promptOutput)
+	}
+	if strings.Contains(promptOutput, "prompt-openai-key") {
+		t.Fatalf("secret should not be printed in clear text: %s", promptOutput)
+	}
+	if backend.lastSpec.OpenClawEnvironment["OPENAI_API_KEY"] != "prompt-openai-key" {
+		t.Fatalf("missing prompted OPENAI_API_KEY")
+	}
+	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"primary": "openai/gpt-5"`) {
+		t.Fatalf("missing prompted primary model in config: %s", backend.lastSpec.OpenClawConfig)
+	}
+}
+
+func TestRunSaveAnswersPersistsNonSecretAnswersAsDefaults(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	input := strings.NewReader("openai/gpt-5\nprompt-openai-key\n")
+	err := NewWithIOAndBackend(&out, &errOut, input, backend).Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--save-answers", "dev"})
+	if err != nil {
+		t.Fatalf("run should succeed with prompted values: %v", err)
+	}
+	if !strings.Contains(out.String(), "openclaw: 1 required input(s) missing: OpenClaw primary model") {
+		t.Fatalf("missing batch prompt summary: %s", out.String())
+	}
+
+	payload, err := os.ReadFile(filepath.Join(data, "answers", "dev.json"))
+	if err != nil {
+		t.Fatalf("read answers profile: %v", err)
+	}
+	if !strings.Contains(string(payload), `"model_primary": "openai/gpt-5"`) {
+		t.Fatalf("expected model answer in profile: %s", payload)
+	}
+	if strings.Contains(string(payload), "prompt-openai-key") {
+		t.Fatalf("secret answers must not be saved: %s", payload)
+	}
+
+	backend.running = map[int]bool{}
+	out.Reset()
+	err = NewWithBackend(&out, &errOut, backend).Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--save-answers", "dev"})
+	if err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") || strings.Contains(err.Error(), "--openclaw-model-primary") {
+		t.Fatalf("expected only the secret to be missing with saved defaults, got %v", err)
+	}
+
+	err = NewWithBackend(&out, &errOut, backend).Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--save-answers", "dev", "--openclaw-openai-api-key", "flag-key"})
+	if err != nil {
+		t.Fatalf("run with saved answers failed: %v", err)
+	}
+	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"primary": "openai/gpt-5"`) {
+		t.Fatalf("saved model answer not applied: %s", backend.lastSpec.OpenClawConfig)
+	}
+}
+
+type fakeKeychain struct {
+	values map[string]string
+}
+
+func (k *fakeKeychain) Name() string {
+	return "fake keychain"
+}
+
+func (k *fakeKeychain) Get(account string) (string, bool, error) {
+	value, ok := k.values[account]
+	return value, ok, nil
+}
+
+func (k *fakeKeychain) Set(account string, value string) error {
+	k.values[account] = value
+	return nil
+}
+
+func (k *fakeKeychain) Delete(account string) error {
+	if _, ok := k.values[account]; !ok {
+		return errors.New("not found")
+	}
+	delete(k.values, account)
+	return nil
+}
+
+func TestRunStoresPromptedSecretInKeychainAndReusesIt(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	keys := &fakeKeychain{values: map[string]string{}}
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	runArgs := []string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5"}
+
+	prompting := NewWithIOAndBackend(&out, &errOut, strings.NewReader("prompt-openai-key\ny\n"), backend)
+	prompting.keychain = keys
+	if err := prompting.Run(runArgs); err != nil {
+		t.Fatalf("run with prompt failed: %v", err)
+	}
+	if keys.values["OPENAI_API_KEY"] != "prompt-openai-key" {
+		t.Fatalf("expected prompted key in keychain, got %v", keys.values)
+	}
+
+	backend.running = map[int]bool{}
+	out.Reset()
+	reusing := NewWithBackend(&out, &errOut, backend)
+	reusing.keychain = keys
+	if err := reusing.Run(runArgs); err != nil {
+		t.Fatalf("run reusing keychain secret failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "using OPENAI_API_KEY from fake keychain") {
+		t.Fatalf("expected keychain reuse notice, got %s", out.String())
+	}
+	if backend.lastSpec.OpenClawEnvironment["OPENAI_API_KEY"] != "prompt-openai-key" {
+		t.Fatalf("keychain secret not injected: %v", backend.lastSpec.OpenClawEnvironment)
+	}
+
+	out.Reset()
+	if err := reusing.Run([]string{"secret", "ls"}); err != nil {
+		t.Fatalf("secret ls failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "OPENAI_API_KEY") || strings.Contains(out.String(), "prompt-openai-key") {
+		t.Fatalf("unexpected secret ls output: %s", out.String())
+	}
+	if err := reusing.Run([]string{"secret", "rm", "openai_api_key"}); err != nil {
+		t.Fatalf("secret rm failed: %v", err)
+	}
+	if _, ok := keys.values["OPENAI_API_KEY"]; ok {
+		t.Fatalf("expected key removed from keychain")
+	}
+	if err := reusing.Run([]string{"secret", "rm", "OPENAI_API_KEY"}); err == nil {
+		t.Fatalf("expected removing an unknown secret to fail")
+	}
+}
+
+func TestRunFailsEarlyWhenHostMemoryIsInsufficient(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	probedPath := ""
+	application.probeResources = func(diskPath string) (vm.HostResources, error) {
+		probedPath = diskPath
+		return vm.HostResources{AvailableMemoryMiB: 2048, FreeDiskMiB: 100000}, nil
+	}
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--memory-mib", "4096", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "sk-test"})
+	if err == nil || !strings.Contains(err.Error(), "insufficient host resources") || !strings.Contains(err.Error(), "--wait-for-resources") {
+		t.Fatalf("expected insufficient resources error, got %v", err)
+	}
+	if probedPath != filepath.Join(data, "claws") {
+		t.Fatalf("expected disk probe under data dir, got %s", probedPath)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("vm should not start when resources are insufficient")
+	}
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--memory-mib", "1024", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "sk-test"}); err != nil {
+		t.Fatalf("run within available memory failed: %v", err)
+	}
+}
+
+func TestRunRejectsUnsupportedModelProvider(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "foo/bar"})
+	if err == nil {
+		t.Fatalf("expected unsupported provider error")
+	}
+	if !strings.Contains(err.Error(), "unsupported model provider") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("vm should not start for invalid model provider")
+	}
+}
+
+func TestPSShowsUnhealthyStatusWhenGatewayUnavailable(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--port=65531", "--ready-timeout-secs=1", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
+	if err == nil {
+		t.Fatalf("expected run timeout error")
+	}
+	if !strings.Contains(err.Error(), "gateway is not reachable yet") {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+
+	out.Reset()
+	err = application.Run([]string{"ps"})
+	if err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+
+	psOutput := out.String()
+	if !strings.Contains(psOutput, "LAST_ERROR") {
+		t.Fatalf("ps output missing LAST_ERROR column: %s", psOutput)
+	}
+	if !strings.Contains(psOutput, "unhealthy") {
+		t.Fatalf("ps output missing unhealthy status: %s", psOutput)
+	}
+	if !strings.Contains(psOutput, "timeout waiting") && !strings.Contains(psOutput, "connection refused") && !strings.Contains(psOutput, "gateway is unreachable") {
+		t.Fatalf("ps output missing error detail: %s", psOutput)
+	}
+}
+
+func TestPSSendsStoredGatewayCredentialAndReportsUnauthorized(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer gateway-secret" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	port := gateway.Listener.Addr().(*net.TCPAddr).Port
+
+	backend := newFakeBackend()
+	backend.running[5000] = true
+	backend.running[5001] = true
+
+	instanceStore := filepath.Join(data, "claws")
+	now := time.Now().UTC().Format(time.RFC3339)
+	writeInstance := func(id string, pid int, credentialPath string) {
+		if err := os.MkdirAll(filepath.Join(instanceStore, id), 0o755); err != nil {
+			t.Fatalf("mkdir instance: %v", err)
+		}
+		metadata := `{"id":"` + id + `","image_ref":"ubuntu:24.04","workspace_path":".","state_path":".","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"ready","backend":"qemu","pid":` + strconv.Itoa(pid) + `,"gateway_auth":"token","gateway_credential_path":"` + credentialPath + `","created_at_utc":"` + now + `","updated_at_utc":"` + now + `"}`
+		if err := os.WriteFile(filepath.Join(instanceStore, id, "instance.json"), []byte(metadata), 0o644); err != nil {
+			t.Fatalf("write metadata: %v", err)
+		}
+	}
+	credentialPath := filepath.Join(instanceStore, "claw-authok", "gateway.credential")
+	writeInstance("claw-authok", 5000, credentialPath)
+	writeInstance("claw-noauth", 5001, "")
+	if err := os.WriteFile(credentialPath, []byte("gateway-secret"), 0o600); err != nil {
+		t.Fatalf("write credential: %v", err)
+	}
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+
+	for _, line := range strings.Split(out.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "claw-authok"):
+			if !strings.Contains(line, "ready") {
+				t.Fatalf("expected credentialed probe to report ready: %s", line)
+			}
+		case strings.HasPrefix(line, "claw-noauth"):
+			if !strings.Contains(line, "unauthorized") || !strings.Contains(line, "HTTP 401") || strings.Contains(line, "unhealthy") {
+				t.Fatalf("expected distinct unauthorized status: %s", line)
+			}
+		}
+	}
+}
+
+func TestPSWideShowsGuestReportedStatus(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	statusPayload := `{"task":"triage open issues","model":"openai/gpt-5","tokens_used":12345,"updated_at":"2026-01-02T03:04:05Z"}`
+	if err := os.WriteFile(filepath.Join(data, "claws", id, "state", "status.json"), []byte(statusPayload), 0o644); err != nil {
+		t.Fatalf("write guest status: %v", err)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"ps", "--wide"}); err != nil {
+		t.Fatalf("ps --wide failed: %v", err)
+	}
+	for _, expected := range []string{"MODEL", "TASK", "TOKENS", "triage open issues", "12345", "2026-01-02T03:04:05Z"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("ps --wide output missing %q: %s", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if strings.Contains(out.String(), "triage open issues") {
+		t.Fatalf("plain ps should not show guest status: %s", out.String())
+	}
+
+	columns := guestStatus{Model: "gpt\x1b]0;pwned\x07-5", Task: "\u202erésumé\t✓ " + strings.Repeat("é", 60)}.columns()
+	if columns[0] != "gpt ]0;pwned -5" {
+		t.Fatalf("expected control characters stripped from the model, got %q", columns[0])
+	}
+	if !utf8.ValidString(columns[1]) || utf8.RuneCountInString(columns[1]) != guestTaskMaxRunes || !strings.HasSuffix(columns[1], "...") {
+		t.Fatalf("expected the task cut to %d runes, got %q", guestTaskMaxRunes, columns[1])
+	}
+	if strings.ContainsAny(columns[1], "\u202e\t") {
+		t.Fatalf("expected format and control characters stripped from the task, got %q", columns[1])
+	}
+}
+
+func TestJSONFormatForPSImageListAndInspect(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"ps", "--format", "json"}); err != nil {
+		t.Fatalf("ps --format json failed: %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Fatalf("expected empty json array, got %q", out.String())
+	}
+
+	seedFetchedImage(t, cache)
+	out.Reset()
+	if err := application.Run([]string{"image", "ls", "--format=json"}); err != nil {
+		t.Fatalf("image ls --format=json failed: %v", err)
+	}
+	var imageItems []images.Metadata
+	if err := json.Unmarshal(out.Bytes(), &imageItems); err != nil {
+		t.Fatalf("decode image ls json: %v\n%s", err, out.String())
+	}
+	if len(imageItems) == 0 || imageItems[0].Ref != "ubuntu:24.04" || !imageItems[0].Ready {
+		t.Fatalf("unexpected image ls json: %+v", imageItems)
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+
+	out.Reset()
+	if err := application.Run([]string{"ps", "--wide", "--format", "json"}); err != nil {
+		t.Fatalf("ps --format json failed: %v", err)
+	}
+	var listed []map[string]any
+	if err := json.Unmarshal(out.Bytes(), &listed); err != nil {
+		t.Fatalf("decode ps json: %v\n%s", err, out.String())
+	}
+	if len(listed) != 1 || listed[0]["id"] != id || listed[0]["image_ref"] != "ubuntu:24.04" {
+		t.Fatalf("unexpected ps json: %s", out.String())
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"inspect", id}); err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	var inspected struct {
+		ID     string          `json:"id"`
+		PID    int             `json:"pid"`
+		Lock   state.LockState `json:"lock"`
+		Mounts []mountState    `json:"mounts"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &inspected); err != nil {
+		t.Fatalf("decode inspect json: %v\n%s", err, out.String())
+	}
+	if inspected.ID != id || inspected.PID <= 0 {
+		t.Fatalf("unexpected inspect output: %s", out.String())
+	}
+	if !inspected.Lock.Active || inspected.Lock.InstanceID != id {
+		t.Fatalf("expected active lock state, got %+v", inspected.Lock)
+	}
+	if len(inspected.Mounts) == 0 || inspected.Mounts[0].Name != "workspace" || inspected.Mounts[0].GuestPath != "/workspace" || !inspected.Mounts[0].HostExists {
+		t.Fatalf("unexpected mounts: %+v", inspected.Mounts)
+	}
+
+	if err := application.Run([]string{"ps", "--format", "yaml"}); err == nil || !strings.Contains(err.Error(), "expected table or json") {
+		t.Fatalf("expected invalid format error, got %v", err)
+	}
+	if err := application.Run([]string{"inspect", "missing-claw"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestInspectReportsCheckpointsSpecAndQEMUCommand(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	instanceDir := filepath.Join(data, "claws", id)
+	if err := os.WriteFile(filepath.Join(instanceDir, "rootfs.qcow2"), []byte("disk"), 0o644); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(instanceDir, clawboxSpecV2Path), []byte(`{"schema_version":2,"name":"demo"}`), 0o644); err != nil {
+		t.Fatalf("write clawbox spec: %v", err)
 	}
-	if strings.Contains(promptOutput, "prompt-openai-key") {
-		t.Fatalf("secret should not be printed in clear text: %s", promptOutput)
+	if err := application.Run([]string{"checkpoint", id, "--name", "before-upgrade"}); err != nil {
+		t.Fatalf("checkpoint failed: %v", err)
 	}
-	if backend.lastSpec.OpenClawEnvironment["OPENAI_API_KEY"] != "prompt-openai-key" {
-		t.Fatalf("missing prompted OPENAI_API_KEY")
+
+	out.Reset()
+	if err := application.Run([]string{"inspect", id}); err != nil {
+		t.Fatalf("inspect failed: %v", err)
 	}
-	if !strings.Contains(backend.lastSpec.OpenClawConfig, `"primary": "openai/gpt-5"`) {
-		t.Fatalf("missing prompted primary model in config: %s", backend.lastSpec.OpenClawConfig)
+	var inspected struct {
+		QEMUCommand []string          `json:"qemu_command"`
+		Checkpoints []checkpointState `json:"checkpoints"`
+		ClawboxSpec map[string]any    `json:"clawbox_spec"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &inspected); err != nil {
+		t.Fatalf("decode inspect json: %v\n%s", err, out.String())
+	}
+	if len(inspected.QEMUCommand) == 0 || inspected.QEMUCommand[0] != "qemu-system-x86_64" {
+		t.Fatalf("expected effective qemu command, got %v", inspected.QEMUCommand)
+	}
+	if len(inspected.Checkpoints) != 1 || inspected.Checkpoints[0].Name != "before-upgrade" || inspected.Checkpoints[0].SizeBytes != 4 {
+		t.Fatalf("unexpected checkpoints: %+v", inspected.Checkpoints)
+	}
+	if inspected.ClawboxSpec["name"] != "demo" {
+		t.Fatalf("expected resolved clawbox spec, got %v", inspected.ClawboxSpec)
+	}
+}
+
+func TestRunWaitsForReadinessContractBeforeReportingReady(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", cache)
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+	seedFetchedImage(t, cache)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	var healthChecks atomic.Int32
+	var modelLoaded atomic.Bool
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/healthz" {
+			_, _ = writer.Write([]byte("<html>Directory listing for /</html>"))
+			return
+		}
+		if healthChecks.Add(1) == 1 {
+			_, _ = writer.Write([]byte(`{"status":"starting","model":{"loaded":false}}`))
+			return
+		}
+		modelLoaded.Store(true)
+		_, _ = writer.Write([]byte(`{"status":"ok","model":{"loaded":true}}`))
+	})}
+	defer server.Close()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+	runArgs := []string{"run", "ubuntu:24.04", "--workspace=.", "--port", strconv.Itoa(port), "--ready-timeout-secs", "10",
+		"--ready-path", "/healthz", "--ready-json", "status=ok", "--ready-json", "model.loaded=true",
+		"--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"}
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, out.String())
+	}
+	if !modelLoaded.Load() || healthChecks.Load() < 2 {
+		t.Fatalf("expected run to wait for the readiness contract, got %d health checks", healthChecks.Load())
+	}
+
+	id := parseClawIDFromRunOutput(out.String())
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.Status != "ready" || instance.Readiness == nil || instance.Readiness.Path != "/healthz" || instance.Readiness.JSON["model.loaded"] != "true" {
+		t.Fatalf("unexpected persisted readiness: %+v status=%s", instance.Readiness, instance.Status)
+	}
+
+	if err := application.Run([]string{"run", "ubuntu:24.04", "--ready-path", "healthz"}); err == nil || !strings.Contains(err.Error(), "must start with /") {
+		t.Fatalf("expected invalid ready-path error, got %v", err)
+	}
+}
+
+func TestPSReportsInstallingWhileFallbackGatewayAnswers(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	var installed atomic.Bool
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		if !installed.Load() {
+			writer.Header().Set(vm.GatewayMarkerHeader, vm.GatewayMarkerFallback)
+		}
+		_, _ = writer.Write([]byte("ok"))
+	})}
+	defer server.Close()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	backend := newFakeBackend()
+	backend.running[5000] = true
+	instanceDir := filepath.Join(data, "claws", "claw-installing")
+	if err := os.MkdirAll(instanceDir, 0o755); err != nil {
+		t.Fatalf("mkdir instance: %v", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	metadata := `{"id":"claw-installing","image_ref":"ubuntu:24.04","workspace_path":".","state_path":".","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"booting","backend":"qemu","pid":5000,"created_at_utc":"` + now + `","updated_at_utc":"` + now + `"}`
+	if err := os.WriteFile(filepath.Join(instanceDir, "instance.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "installing") || strings.Contains(out.String(), "ready") {
+		t.Fatalf("expected installing status while the fallback server answers, got %s", out.String())
+	}
+
+	installed.Store(true)
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "ready") {
+		t.Fatalf("expected ready once OpenClaw serves the gateway, got %s", out.String())
+	}
+}
+
+func TestPSReportsInstallFailedWithGuestExcerpt(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set(vm.GatewayMarkerHeader, vm.GatewayMarkerInstallFailed)
+		_, _ = writer.Write([]byte("ok"))
+	})}
+	defer server.Close()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	backend := newFakeBackend()
+	backend.running[5000] = true
+	instanceDir := filepath.Join(data, "claws", "claw-install-failed")
+	stateDir := filepath.Join(instanceDir, "state")
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		t.Fatalf("mkdir state: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, "clawfarm-install.status"), []byte("failed\nnode tarball: download failed\nnpm ERR! network request to registry.npmjs.org failed\n"), 0o644); err != nil {
+		t.Fatalf("write install status: %v", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	metadata := `{"id":"claw-install-failed","image_ref":"ubuntu:24.04","workspace_path":".","state_path":"` + stateDir + `","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"installing","backend":"qemu","pid":5000,"created_at_utc":"` + now + `","updated_at_utc":"` + now + `"}`
+	if err := os.WriteFile(filepath.Join(instanceDir, "instance.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "install-failed") || !strings.Contains(out.String(), "npm ERR! network request") {
+		t.Fatalf("expected install-failed with the guest excerpt, got %s", out.String())
+	}
+
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load("claw-install-failed")
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if instance.Status != "install-failed" || !strings.Contains(instance.LastError, "registry.npmjs.org") {
+		t.Fatalf("expected install-failed to be persisted, got %+v", instance)
+	}
+}
+
+func TestPSShowsGuestInstallPhase(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("CLAWFARM_CACHE_DIR", t.TempDir())
+	t.Setenv("CLAWFARM_DATA_DIR", data)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set(vm.GatewayMarkerHeader, vm.GatewayMarkerFallback)
+		_, _ = writer.Write([]byte("ok"))
+	})}
+	defer server.Close()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	backend := newFakeBackend()
+	backend.running[5000] = true
+	instanceDir := filepath.Join(data, "claws", "claw-install")
+	statePath := filepath.Join(instanceDir, "state")
+	if err := os.MkdirAll(statePath, 0o755); err != nil {
+		t.Fatalf("mkdir state: %v", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	metadata := `{"id":"claw-install","image_ref":"ubuntu:24.04","workspace_path":".","state_path":"` + statePath + `","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"booting","backend":"qemu","pid":5000,"created_at_utc":"` + now + `","updated_at_utc":"` + now + `"}`
+	if err := os.WriteFile(filepath.Join(instanceDir, "instance.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+	statusPath := filepath.Join(statePath, installStatusFileName)
+	if err := os.WriteFile(statusPath, []byte("npm\n"), 0o644); err != nil {
+		t.Fatalf("write install status: %v", err)
+	}
+
+	var out bytes.Buffer
+	application := NewWithBackend(&out, &out, backend)
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "installing (install: npm)") {
+		t.Fatalf("expected install phase in status column, got %s", out.String())
+	}
+
+	if err := os.WriteFile(statusPath, []byte("failed\nnpm ERR! code E404\nnpm ERR! 404 Not Found - openclaw@9.9.9\n"), 0o644); err != nil {
+		t.Fatalf("write install status: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"ps"}); err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "installing (install failed)") || !strings.Contains(out.String(), "npm ERR! 404 Not Found - openclaw@9.9.9") {
+		t.Fatalf("expected install failure and excerpt in ps, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := application.Run([]string{"inspect", "claw-install"}); err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	var inspection struct {
+		Install installProgress `json:"install"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &inspection); err != nil {
+		t.Fatalf("decode inspect: %v", err)
+	}
+	if inspection.Install.Phase != installPhaseFailed || !strings.Contains(inspection.Install.Error, "npm ERR! code E404") {
+		t.Fatalf("unexpected install progress: %+v", inspection.Install)
+	}
+
+	if command := guestLogCommand(logsOptions{Source: logSourceInstall, Tail: 50}); command != "tail -n 50 /var/log/clawfarm-openclaw-install.log" {
+		t.Fatalf("unexpected install log command: %s", command)
+	}
+}
+
+func TestPSMarksHTTP5xxAsUnhealthy(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusInternalServerError)
+	})}
+	defer server.Close()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	backend := newFakeBackend()
+	backend.nextPID = 4999
+	backend.running[5000] = true
+
+	instanceStore := filepath.Join(data, "claws")
+	if err := os.MkdirAll(filepath.Join(instanceStore, "claw-test5xx"), 0o755); err != nil {
+		t.Fatalf("mkdir instance: %v", err)
+	}
+
+	metadata := `{"id":"claw-test5xx","image_ref":"ubuntu:24.04","workspace_path":".","state_path":".","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"ready","backend":"qemu","pid":5000,"created_at_utc":"` + time.Now().UTC().Format(time.RFC3339) + `","updated_at_utc":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
+	if err := os.WriteFile(filepath.Join(instanceStore, "claw-test5xx", "instance.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err = application.Run([]string{"ps"})
+	if err != nil {
+		t.Fatalf("ps failed: %v", err)
+	}
+
+	psOutput := out.String()
+	if !strings.Contains(psOutput, "unhealthy") {
+		t.Fatalf("ps output missing unhealthy status: %s", psOutput)
+	}
+	if !strings.Contains(psOutput, "HTTP 500") {
+		t.Fatalf("ps output missing HTTP 500 error: %s", psOutput)
+	}
+}
+
+func TestParseCopyTarget(t *testing.T) {
+	cases := []struct {
+		in     string
+		clawID string
+		path   string
+	}{
+		{in: "notes.txt", clawID: "", path: "notes.txt"},
+		{in: "./dir/notes.txt", clawID: "", path: "./dir/notes.txt"},
+		{in: "claw-1234:/workspace/notes.txt", clawID: "claw-1234", path: "/workspace/notes.txt"},
+		{in: "demo-a-1234:/tmp/../root/x", clawID: "demo-a-1234", path: "/root/x"},
+	}
+
+	for _, tc := range cases {
+		got, err := parseCopyTarget(tc.in)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.in, err)
+		}
+		if got.ClawID != tc.clawID || got.Path != tc.path {
+			t.Fatalf("%s: unexpected target %+v", tc.in, got)
+		}
+	}
+
+	if _, err := parseCopyTarget("claw-1234:relative/path"); err == nil {
+		t.Fatal("expected relative guest path to be rejected")
+	}
+}
+
+func TestCopyCommandsQuoteGuestPaths(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is required")
+	}
+	guestPath := filepath.Join(t.TempDir(), "my notes", "it's $HOME; `x`.txt")
+	upload := exec.Command("sh", "-c", copyToGuestCommand(guestPath))
+	upload.Stdin = strings.NewReader("hello\n")
+	if output, err := upload.CombinedOutput(); err != nil {
+		t.Fatalf("copy to guest command failed: %v\n%s", err, output)
+	}
+	if content, err := os.ReadFile(guestPath); err != nil || string(content) != "hello\n" {
+		t.Fatalf("expected the file at the quoted path, got %q (%v)", content, err)
+	}
+
+	download, err := exec.Command("sh", "-c", copyFromGuestCommand(guestPath)).Output()
+	if err != nil || string(download) != "hello\n" {
+		t.Fatalf("copy from guest command returned %q (%v)", download, err)
+	}
+
+	if got := remoteShellCommand("cat", "a b", "it's"); got != `'cat' 'a b' 'it'"'"'s'` {
+		t.Fatalf("unexpected remote command %s", got)
+	}
+}
+
+func TestGuestSudoCommandFollowsInstancePolicy(t *testing.T) {
+	for _, policy := range []string{"", vm.GuestSudoNoPassword} {
+		command, err := guestSudoCommand(state.Instance{ID: "claw-1", GuestSudo: policy}, "cat /etc/shadow")
+		if err != nil || command != "sudo -n cat /etc/shadow" {
+			t.Fatalf("policy %q: got %q (%v)", policy, command, err)
+		}
+	}
+	for _, policy := range []string{vm.GuestSudoPassword, vm.GuestSudoNone} {
+		instance := state.Instance{ID: "claw-1", GuestUser: "dev", GuestSudo: policy}
+		if _, err := guestSudoCommand(instance, "true"); err == nil || !strings.Contains(err.Error(), "--guest-sudo "+policy) {
+			t.Fatalf("policy %q: expected a clear sudo error, got %v", policy, err)
+		}
+		if _, err := execRemoteCommand(instance, execOptions{User: runAsRoot}, []string{"id"}); err == nil {
+			t.Fatalf("policy %q: expected exec as root to be refused", policy)
+		}
+		if command, err := execRemoteCommand(instance, execOptions{User: runAsClaw}, []string{"id"}); err != nil || strings.Contains(command, "sudo") {
+			t.Fatalf("policy %q: expected exec as the guest user without sudo, got %q (%v)", policy, command, err)
+		}
 	}
 }
 
-func TestRunRejectsUnsupportedModelProvider(t *testing.T) {
+func TestCopyFailsWithoutSSHAccess(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
 	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
@@ -1795,66 +6938,72 @@ func TestRunRejectsUnsupportedModelProvider(t *testing.T) {
 	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithIOAndBackend(&out, &errOut, strings.NewReader("hello\n"), backend)
 
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--no-wait", "--openclaw-model-primary", "foo/bar"})
-	if err == nil {
-		t.Fatalf("expected unsupported provider error")
+	if err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=."}); err != nil {
+		t.Fatalf("new command failed: %v", err)
 	}
-	if !strings.Contains(err.Error(), "unsupported model provider") {
-		t.Fatalf("unexpected error: %v", err)
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from new output: %s", out.String())
 	}
-	if backend.nextPID != 4000 {
-		t.Fatalf("vm should not start for invalid model provider")
+
+	err := application.Run([]string{"cp", "--stdin", id + ":/workspace/hello.txt"})
+	if err == nil {
+		t.Fatal("expected cp to fail without ssh access")
+	}
+	if !strings.Contains(err.Error(), "has no ssh access") {
+		t.Fatalf("unexpected cp error: %v", err)
 	}
 }
 
-func TestPSShowsUnhealthyStatusWhenGatewayUnavailable(t *testing.T) {
-	cache := t.TempDir()
-	data := t.TempDir()
-	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
-		t.Fatalf("set cache env: %v", err)
+func TestDoctorReportsSandboxSupportAndMissingTools(t *testing.T) {
+	toolDir := t.TempDir()
+	qemuName, err := vm.QEMUSystemBinary(runtime.GOARCH)
+	if err != nil {
+		t.Skipf("unsupported host arch: %v", err)
 	}
-	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
-	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
-		t.Fatalf("set data env: %v", err)
+	qemuScript := "#!/bin/sh\nif [ \"$1\" = \"-sandbox\" ]; then echo 'There is no option group sandbox' >&2; exit 1; fi\nexit 0\n"
+	tools := map[string]string{
+		qemuName:     qemuScript,
+		"qemu-img":   "#!/bin/sh\nexit 0\n",
+		"ssh-keygen": "#!/bin/sh\nexit 0\n",
 	}
-	defer os.Unsetenv("CLAWFARM_DATA_DIR")
-
-	seedFetchedImage(t, cache)
+	for name, script := range tools {
+		if err := os.WriteFile(filepath.Join(toolDir, name), []byte(script), 0o755); err != nil {
+			t.Fatalf("write fake %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", toolDir)
+	t.Setenv("CLAWFARM_QEMU_USER", "")
 
-	backend := newFakeBackend()
 	var out bytes.Buffer
 	var errOut bytes.Buffer
-	application := NewWithBackend(&out, &errOut, backend)
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
 
-	err := application.Run([]string{"run", "ubuntu:24.04", "--workspace=.", "--port=65531", "--ready-timeout-secs=1", "--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"})
-	if err == nil {
-		t.Fatalf("expected run timeout error")
+	if err := application.Run([]string{"doctor"}); err != nil {
+		t.Fatalf("expected doctor to pass with only warnings, got %v\n%s", err, out.String())
 	}
-	if !strings.Contains(err.Error(), "gateway is not reachable yet") {
-		t.Fatalf("unexpected run error: %v", err)
+	output := out.String()
+	for _, expected := range []string{"qemu seccomp sandbox unavailable", "CLAWFARM_QEMU_USER not set"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in doctor output:\n%s", expected, output)
+		}
 	}
-
-	out.Reset()
-	err = application.Run([]string{"ps"})
-	if err != nil {
-		t.Fatalf("ps failed: %v", err)
+	if strings.Contains(output, "hdiutil") {
+		t.Fatalf("expected the seed ISO to no longer need hdiutil:\n%s", output)
 	}
 
-	psOutput := out.String()
-	if !strings.Contains(psOutput, "LAST_ERROR") {
-		t.Fatalf("ps output missing LAST_ERROR column: %s", psOutput)
+	if err := os.Remove(filepath.Join(toolDir, qemuName)); err != nil {
+		t.Fatalf("remove fake qemu: %v", err)
 	}
-	if !strings.Contains(psOutput, "unhealthy") {
-		t.Fatalf("ps output missing unhealthy status: %s", psOutput)
-	}
-	if !strings.Contains(psOutput, "timeout waiting") && !strings.Contains(psOutput, "connection refused") && !strings.Contains(psOutput, "gateway is unreachable") {
-		t.Fatalf("ps output missing error detail: %s", psOutput)
+	out.Reset()
+	if err := application.Run([]string{"doctor"}); err == nil || !strings.Contains(err.Error(), "doctor found 1 problem(s)") {
+		t.Fatalf("expected one doctor failure for missing qemu, got %v\n%s", err, out.String())
 	}
 }
 
-func TestPSMarksHTTP5xxAsUnhealthy(t *testing.T) {
+func TestAuditReportsExposureWithoutSecretValues(t *testing.T) {
 	cache := t.TempDir()
 	data := t.TempDir()
 	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
@@ -1868,50 +7017,64 @@ func TestPSMarksHTTP5xxAsUnhealthy(t *testing.T) {
 
 	seedFetchedImage(t, cache)
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatalf("listen: %v", err)
-	}
-	defer listener.Close()
-
-	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
-		writer.WriteHeader(http.StatusInternalServerError)
-	})}
-	defer server.Close()
-	go func() {
-		_ = server.Serve(listener)
-	}()
-
-	port := listener.Addr().(*net.TCPAddr).Port
 	backend := newFakeBackend()
-	backend.nextPID = 4999
-	backend.running[5000] = true
-
-	instanceStore := filepath.Join(data, "claws")
-	if err := os.MkdirAll(filepath.Join(instanceStore, "claw-test5xx"), 0o755); err != nil {
-		t.Fatalf("mkdir instance: %v", err)
-	}
-
-	metadata := `{"id":"claw-test5xx","image_ref":"ubuntu:24.04","workspace_path":".","state_path":".","gateway_port":` + strconv.Itoa(port) + `,"published_ports":[],"status":"ready","backend":"qemu","pid":5000,"created_at_utc":"` + time.Now().UTC().Format(time.RFC3339) + `","updated_at_utc":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
-	if err := os.WriteFile(filepath.Join(instanceStore, "claw-test5xx", "instance.json"), []byte(metadata), 0o644); err != nil {
-		t.Fatalf("write metadata: %v", err)
-	}
-
 	var out bytes.Buffer
 	var errOut bytes.Buffer
 	application := NewWithBackend(&out, &errOut, backend)
 
-	err = application.Run([]string{"ps"})
+	err := application.Run([]string{"new", "ubuntu:24.04", "--workspace=.", "--publish", "8080:80", "--volume", "data:/data", "--openclaw-env", "OPENAI_API_KEY=sk-audit-secret", "--openclaw-env", "APP_MODE=dev"})
 	if err != nil {
-		t.Fatalf("ps failed: %v", err)
+		t.Fatalf("new command failed: %v", err)
+	}
+	id := parseClawIDFromRunOutput(out.String())
+	if id == "" {
+		t.Fatalf("failed to parse CLAWID from new output: %s", out.String())
 	}
 
-	psOutput := out.String()
-	if !strings.Contains(psOutput, "unhealthy") {
-		t.Fatalf("ps output missing unhealthy status: %s", psOutput)
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	report := out.String()
+	for _, expected := range []string{
+		"127.0.0.1:8080 -> guest 80 (publish, loopback only",
+		"outbound: open",
+		"OPENAI_API_KEY (secret)",
+		"APP_MODE\n",
+		"volume data:",
+		"-> /data (read-write 9p)",
+		"qemu sandbox: off",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("expected %q in audit report:\n%s", expected, report)
+		}
 	}
-	if !strings.Contains(psOutput, "HTTP 500") {
-		t.Fatalf("ps output missing HTTP 500 error: %s", psOutput)
+	if strings.Contains(report, "sk-audit-secret") {
+		t.Fatalf("audit report leaked secret value:\n%s", report)
+	}
+
+	store := state.NewStore(filepath.Join(data, "claws"))
+	instance, err := store.Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	instance.PID = 0
+	instance.GuestUser = "dev"
+	instance.SSHHostPort = 2222
+	instance.SSHKeyPath = "/keys/id_ed25519"
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("save instance: %v", err)
+	}
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "ssh: key-only login as dev (key: /keys/id_ed25519)") {
+		t.Fatalf("expected the guest user in the ssh line:\n%s", out.String())
+	}
+
+	if err := application.Run([]string{"audit", "missing-claw"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found error, got %v", err)
 	}
 }
 
@@ -1959,6 +7122,209 @@ func mutateTestClawboxFile(t *testing.T, path string, mutate func(*clawbox.Heade
 	}
 }
 
+func TestRunAppliesClawboxRunDefaultsUnderCLIFlags(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo.clawbox", "demo-openclaw", "ubuntu:24.04")
+	header, err := clawbox.LoadHeaderJSON(clawboxPath)
+	if err != nil {
+		t.Fatalf("load clawbox header: %v", err)
+	}
+	header.Spec.RunDefaults = &clawbox.RunDefaults{
+		CPUs:      4,
+		MemoryMiB: 4096,
+		Publish:   []string{"8080:80", "9090:90"},
+		Volumes:   []string{"cache:/cache"},
+	}
+	if err := clawbox.SaveHeaderJSON(clawboxPath, header); err != nil {
+		t.Fatalf("save clawbox header: %v", err)
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+
+	err = application.Run([]string{
+		"run", clawboxPath,
+		"--workspace=.",
+		"--no-wait",
+		"--cpus", "2",
+		"--publish", "8080:8000",
+		"--openclaw-openai-api-key", "sk-test",
+		"--openclaw-gateway-token", "gateway-token",
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	spec := backend.lastSpec
+	if spec.CPUs != 2 {
+		t.Fatalf("expected --cpus to override run_defaults, got %d", spec.CPUs)
+	}
+	if spec.MemoryMiB != 4096 {
+		t.Fatalf("expected run_defaults memory, got %d", spec.MemoryMiB)
+	}
+	expectedPorts := []vm.PortMapping{{HostPort: 8080, GuestPort: 8000}, {HostPort: 9090, GuestPort: 90}}
+	if !reflect.DeepEqual(spec.PublishedPorts, expectedPorts) {
+		t.Fatalf("unexpected published ports: %+v", spec.PublishedPorts)
+	}
+	if len(spec.VolumeMounts) != 1 || spec.VolumeMounts[0].Name != "cache" || spec.VolumeMounts[0].GuestPath != "/cache" {
+		t.Fatalf("expected run_defaults volume, got %+v", spec.VolumeMounts)
+	}
+}
+
+func TestRunRecordsDirtyShutdownAndRequestsDiskCheck(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	clawboxPath := writeTestClawboxFile(t, t.TempDir(), "demo.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, backend)
+	runArgs := []string{"run", clawboxPath, "--workspace=.", "--no-wait", "--openclaw-openai-api-key", "sk-test", "--openclaw-gateway-token", "gateway-token"}
+
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if backend.lastSpec.UncleanShutdown {
+		t.Fatalf("first boot should not request a disk check")
+	}
+
+	id := parseClawIDFromRunOutput(out.String())
+	backend.running = map[int]bool{}
+	out.Reset()
+	if err := application.Run([]string{"audit", id}); err != nil {
+		t.Fatalf("audit failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "last shutdown: not graceful (next boot checks the disk") {
+		t.Fatalf("expected audit to flag the dirty shutdown, got %s", out.String())
+	}
+
+	out.Reset()
+	if err := application.Run(runArgs); err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if !backend.lastSpec.UncleanShutdown {
+		t.Fatalf("expected disk check after the previous VM died without rm")
+	}
+	if !strings.Contains(out.String(), "last shutdown: not graceful") {
+		t.Fatalf("expected run output to explain the slower boot, got %s", out.String())
+	}
+	instance, err := state.NewStore(filepath.Join(data, "claws")).Load(id)
+	if err != nil {
+		t.Fatalf("load instance: %v", err)
+	}
+	if !instance.DirtyShutdown {
+		t.Fatalf("expected dirty_shutdown to be recorded")
+	}
+}
+
+func TestEnvTemplateListsRequiredAndOptionalKeys(t *testing.T) {
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo.clawbox", "demo-openclaw", "ubuntu:24.04")
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithBackend(&out, &errOut, newFakeBackend())
+
+	if err := application.Run([]string{"env", "template", clawboxPath}); err != nil {
+		t.Fatalf("env template failed: %v", err)
+	}
+	template := out.String()
+	for _, expected := range []string{
+		"# OpenAI API key (secret)\nOPENAI_API_KEY=\n",
+		"# OpenClaw gateway token (secret)\nOPENCLAW_GATEWAY_TOKEN=\n",
+		"# --- optional ---\n# OpenClaw env DISCORD_TOKEN (secret)\n# DISCORD_TOKEN=\n",
+	} {
+		if !strings.Contains(template, expected) {
+			t.Fatalf("expected %q in template, got:\n%s", expected, template)
+		}
+	}
+	if strings.Index(template, "--- required ---") > strings.Index(template, "--- optional ---") {
+		t.Fatalf("expected required section before optional, got:\n%s", template)
+	}
+
+	envPath := filepath.Join(workspace, ".env")
+	if err := os.WriteFile(envPath, []byte(template), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+	parsed, err := parseOpenClawEnvFile(envPath)
+	if err != nil {
+		t.Fatalf("template should parse as an env file: %v", err)
+	}
+	if _, ok := parsed["DISCORD_TOKEN"]; ok {
+		t.Fatalf("optional keys should stay commented out, got %v", parsed)
+	}
+}
+
+func TestRunEnvFileReportsAllMissingKeysWithoutPrompting(t *testing.T) {
+	cache := t.TempDir()
+	data := t.TempDir()
+	if err := os.Setenv("CLAWFARM_CACHE_DIR", cache); err != nil {
+		t.Fatalf("set cache env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_CACHE_DIR")
+	if err := os.Setenv("CLAWFARM_DATA_DIR", data); err != nil {
+		t.Fatalf("set data env: %v", err)
+	}
+	defer os.Unsetenv("CLAWFARM_DATA_DIR")
+
+	seedFetchedImage(t, cache)
+	workspace := t.TempDir()
+	clawboxPath := writeTestClawboxFile(t, workspace, "demo.clawbox", "demo-openclaw", "ubuntu:24.04")
+	envFile := filepath.Join(workspace, ".env")
+	if err := os.WriteFile(envFile, []byte("OPENAI_APIKEY=sk-typo\nDISCORD_TOKEN=discord\n"), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+
+	backend := newFakeBackend()
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	application := NewWithIOAndBackend(&out, &errOut, strings.NewReader("prompted-key\nprompted-token\n"), backend)
+
+	err := application.Run([]string{"run", clawboxPath, "--workspace=.", "--no-wait", "--openclaw-env-file", envFile})
+	if err == nil {
+		t.Fatal("expected missing env keys to fail the run")
+	}
+	for _, expected := range []string{
+		"missing required keys: OPENAI_API_KEY, OPENCLAW_GATEWAY_TOKEN",
+		"OPENAI_APIKEY (did you mean OPENAI_API_KEY?)",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("expected %q in error, got %v", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "DISCORD_TOKEN") {
+		t.Fatalf("declared optional key should not be reported, got %v", err)
+	}
+	if backend.nextPID != 4000 {
+		t.Fatalf("backend should not start, next pid is %d", backend.nextPID)
+	}
+}
+
 func writeTestClawboxFile(t *testing.T, dir string, fileName string, name string, baseImageRef string) string {
 	t.Helper()
 
@@ -2037,6 +7403,10 @@ type tarClawboxV2Fixture struct {
 	RequiredEnv []string
 	ClawFiles   map[string]string
 	Provision   []map[string]string
+
+	OpenClawBundle map[string]string
+	ClawSymlinks   map[string]string
+	Manifest       []map[string]string
 }
 
 func writeTarClawboxV2(t *testing.T, path string, fixture tarClawboxV2Fixture) {
@@ -2101,6 +7471,12 @@ func writeTarClawboxV2(t *testing.T, path string, fixture tarClawboxV2Fixture) {
 	if len(fixture.Provision) > 0 {
 		spec["provision"] = fixture.Provision
 	}
+	if fixture.OpenClawBundle != nil {
+		spec["openclaw"].(map[string]interface{})["bundle"] = fixture.OpenClawBundle
+	}
+	if fixture.Manifest != nil {
+		spec["manifest"] = fixture.Manifest
+	}
 
 	payload, err := json.Marshal(spec)
 	if err != nil {
@@ -2118,6 +7494,11 @@ func writeTarClawboxV2(t *testing.T, path string, fixture tarClawboxV2Fixture) {
 	for name, content := range fixture.ClawFiles {
 		writeTarRegularFile(t, tarWriter, name, []byte(content), 0o644)
 	}
+	for name, target := range fixture.ClawSymlinks {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Linkname: target, Typeflag: tar.TypeSymlink, Mode: 0o777}); err != nil {
+			t.Fatalf("write tar symlink %s: %v", name, err)
+		}
+	}
 
 	if err := tarWriter.Close(); err != nil {
 		t.Fatalf("close tar writer: %v", err)
@@ -2160,6 +7541,22 @@ func writeTarClawboxWithoutSpec(t *testing.T, path string) {
 	}
 }
 
+func npmPackageTarball(t *testing.T, version string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	gzWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzWriter)
+	writeTarRegularFile(t, tarWriter, "package/package.json", []byte(`{"name":"openclaw","version":"`+version+`"}`), 0o644)
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatalf("close gzip writer: %v", err)
+	}
+	return buffer.Bytes()
+}
+
 func writeTarRegularFile(t *testing.T, writer *tar.Writer, name string, content []byte, mode int64) {
 	t.Helper()
 	if err := writer.WriteHeader(&tar.Header{
@@ -2173,3 +7570,43 @@ func writeTarRegularFile(t *testing.T, writer *tar.Writer, name string, content
 		t.Fatalf("write tar body for %s: %v", name, err)
 	}
 }
+
+func TestRunCommandMarkersTrackCompletion(t *testing.T) {
+	marker := runCommandMarker(0, "apt-get update")
+	if marker != runCommandMarker(0, "apt-get update") {
+		t.Fatalf("marker should be stable for the same command")
+	}
+	if marker == runCommandMarker(1, "apt-get update") || marker == runCommandMarker(0, "apt-get upgrade") {
+		t.Fatalf("marker should change with index and command")
+	}
+
+	wrapped := wrapRunCommand(0, "apt-get update", runAsRoot)
+	if !strings.Contains(wrapped, "(apt-get update) && touch '/var/lib/clawfarm/run/"+marker+"'") {
+		t.Fatalf("wrapped command should touch marker only after success: %s", wrapped)
+	}
+	asClaw := wrapRunCommand(0, "npm install", runAsClaw)
+	if !strings.Contains(asClaw, "sudo -n -u claw -H bash -lc") || !strings.Contains(asClaw, "npm install") {
+		t.Fatalf("expected claw user wrapper, got %s", asClaw)
+	}
+	if _, err := normalizeRunAs("nobody"); err == nil {
+		t.Fatalf("expected invalid --run-as to be rejected")
+	}
+
+	logPath := runCommandLogPath("/claws/claw-1", 1, "apt-get install -y ripgrep && echo ok")
+	if logPath != "/claws/claw-1/run-logs/02-apt-get-install-y-ripgrep-echo-ok.log" {
+		t.Fatalf("unexpected run log path %s", logPath)
+	}
+
+	completed := parseRunMarkers(marker + "\nnot-a-marker\n")
+	if !completed[marker] || len(completed) != 1 {
+		t.Fatalf("unexpected parsed markers: %v", completed)
+	}
+
+	if isSSHConnectionError(errors.New("exit status 255")) {
+		t.Fatalf("plain errors are not ssh connection errors")
+	}
+	err := exec.Command("sh", "-c", "exit 255").Run()
+	if !isSSHConnectionError(fmt.Errorf("ssh command failed: %w", err)) {
+		t.Fatalf("expected exit 255 to be treated as a dropped ssh connection")
+	}
+}
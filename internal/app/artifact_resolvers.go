@@ -0,0 +1,143 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/fetch"
+	"github.com/yazhou/krunclaw/internal/images"
+)
+
+// artifactResolver fetches a spec artifact reference into destination. Every
+// resolver streams through fetch.Save, so options.SHA256 is always verified
+// before the artifact lands in the blob cache.
+type artifactResolver func(ctx context.Context, ref string, destination string, options fetch.Options) error
+
+var specArtifactResolvers = map[string]artifactResolver{
+	"http":  fetch.Download,
+	"https": fetch.Download,
+	"file":  resolveFileArtifact,
+	"s3":    resolveS3Artifact,
+	"gs":    resolveGCSArtifact,
+	"oci":   images.DownloadOCIBlob,
+}
+
+// specArtifactScheme validates an artifact reference and returns the scheme
+// of the resolver that fetches it. Absolute paths are treated as file://.
+func specArtifactScheme(rawRef string, expectedSHA string) (string, error) {
+	if filepath.IsAbs(rawRef) {
+		return "file", nil
+	}
+	parsed, err := url.Parse(rawRef)
+	if err != nil {
+		return "", err
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if _, ok := specArtifactResolvers[scheme]; !ok {
+		return "", fmt.Errorf("unsupported scheme %q: expected http(s)://, file://, s3://, gs://, oci:// or an absolute path", parsed.Scheme)
+	}
+	switch scheme {
+	case "http", "https":
+		if _, err := url.ParseRequestURI(rawRef); err != nil {
+			return "", err
+		}
+	case "file":
+		if parsed.Path == "" || parsed.Host != "" && parsed.Host != "localhost" {
+			return "", errors.New("expected file:///<absolute path>")
+		}
+	case "s3", "gs":
+		if parsed.Host == "" || strings.Trim(parsed.Path, "/") == "" {
+			return "", fmt.Errorf("expected %s://<bucket>/<object>", scheme)
+		}
+	case "oci":
+		ref, err := images.ParseOCIBlobRef(rawRef)
+		if err != nil {
+			return "", err
+		}
+		if ref.Digest != "sha256:"+expectedSHA {
+			return "", fmt.Errorf("blob digest %s does not match the declared sha256", ref.Digest)
+		}
+	}
+	return scheme, nil
+}
+
+func resolveSpecArtifact(ctx context.Context, rawRef string, destination string, options fetch.Options) error {
+	scheme, err := specArtifactScheme(rawRef, options.SHA256)
+	if err != nil {
+		return err
+	}
+	return specArtifactResolvers[scheme](ctx, rawRef, destination, options)
+}
+
+func resolveFileArtifact(ctx context.Context, rawRef string, destination string, options fetch.Options) error {
+	sourcePath := rawRef
+	if !filepath.IsAbs(rawRef) {
+		parsed, err := url.Parse(rawRef)
+		if err != nil {
+			return err
+		}
+		sourcePath = parsed.Path
+	}
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return fetch.Save(ctx, file, info.Size(), destination, options)
+}
+
+// resolveS3Artifact streams the object through the aws CLI, which picks up
+// credentials from the standard chain (environment, profiles, SSO, instance
+// roles).
+func resolveS3Artifact(ctx context.Context, rawRef string, destination string, options fetch.Options) error {
+	if _, err := exec.LookPath("aws"); err != nil {
+		return errors.New("s3:// artifacts need the aws CLI on PATH")
+	}
+	return saveCommandOutput(ctx, exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", rawRef, "-"), destination, options)
+}
+
+// resolveGCSArtifact streams the object through gcloud, or gsutil on older
+// SDKs, both of which use application default credentials.
+func resolveGCSArtifact(ctx context.Context, rawRef string, destination string, options fetch.Options) error {
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		return saveCommandOutput(ctx, exec.CommandContext(ctx, "gcloud", "storage", "cat", rawRef), destination, options)
+	}
+	if _, err := exec.LookPath("gsutil"); err == nil {
+		return saveCommandOutput(ctx, exec.CommandContext(ctx, "gsutil", "cat", rawRef), destination, options)
+	}
+	return errors.New("gs:// artifacts need gcloud or gsutil on PATH")
+}
+
+func saveCommandOutput(ctx context.Context, command *exec.Cmd, destination string, options fetch.Options) error {
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return err
+	}
+	saveErr := fetch.Save(ctx, fetch.Throttle(ctx, stdout), 0, destination, options)
+	if saveErr != nil {
+		_ = command.Process.Kill()
+	}
+	if err := command.Wait(); err != nil && saveErr != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%s: %s", filepath.Base(command.Path), message)
+		}
+		return fmt.Errorf("%s: %w", filepath.Base(command.Path), err)
+	}
+	return saveErr
+}
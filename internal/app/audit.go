@@ -0,0 +1,188 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+type auditPort struct {
+	Role      string
+	HostPort  int
+	GuestPort int
+}
+
+func (a *App) runAudit(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: clawfarm audit <clawid>")
+	}
+	id := strings.TrimSpace(args[0])
+
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+	running := instance.PID > 0 && a.backend.IsRunning(instance.PID)
+
+	fmt.Fprintf(a.out, "CLAWID: %s\n", instance.ID)
+	fmt.Fprintf(a.out, "image: %s\n", instance.ImageRef)
+	fmt.Fprintf(a.out, "status: %s\n", instance.Status)
+	if lockManager, lockErr := a.lockManager(); lockErr == nil {
+		if lockState, inspectErr := lockManager.Inspect(instance.ID); inspectErr == nil {
+			fmt.Fprintf(a.out, "lock: %s\n", describeLockState(lockState))
+		}
+	}
+	switch {
+	case instance.PID > 0 && !running:
+		fmt.Fprintln(a.out, "last shutdown: not graceful (next boot checks the disk and reports recorded guest filesystem errors)")
+	case instance.DirtyShutdown:
+		fmt.Fprintln(a.out, "last shutdown: not graceful (this boot checked the disk and reported recorded guest filesystem errors)")
+	default:
+		fmt.Fprintln(a.out, "last shutdown: graceful")
+	}
+	fmt.Fprintf(a.out, "audited: %s\n", time.Now().UTC().Format(time.RFC3339))
+
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "host ports:")
+	for _, port := range auditPorts(instance) {
+		listening := "not listening"
+		switch {
+		case !running:
+		case instance.SSHHostPort <= 0:
+			listening = "listener unknown without ssh"
+		case guestPortListening(instance, port.GuestPort) == nil:
+			listening = "listening"
+		}
+		fmt.Fprintf(a.out, "  127.0.0.1:%d -> guest %d (%s, loopback only, %s)\n", port.HostPort, port.GuestPort, port.Role, listening)
+	}
+
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "network:")
+	fmt.Fprintln(a.out, "  outbound: open (qemu user-mode NAT, no egress filtering)")
+	fmt.Fprintln(a.out, "  inbound: only the forwarded loopback ports above")
+	if len(instance.DNSServers) > 0 {
+		fmt.Fprintf(a.out, "  dns: %s\n", strings.Join(instance.DNSServers, ", "))
+	} else {
+		fmt.Fprintln(a.out, "  dns: qemu user-mode resolver (host resolver)")
+	}
+	if instance.HostAlias != nil {
+		fmt.Fprintf(a.out, "  host alias: %s -> %s (reaches services on this machine)\n", instance.HostAlias.Name, instance.HostAlias.IP)
+	}
+	for _, entry := range instance.ExtraHosts {
+		fmt.Fprintf(a.out, "  host entry: %s -> %s\n", entry.Name, entry.IP)
+	}
+
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "injected environment (names only):")
+	if len(instance.InjectedEnv) == 0 {
+		fmt.Fprintln(a.out, "  none")
+	}
+	for _, key := range instance.InjectedEnv {
+		if looksLikeSecretEnvKey(key) {
+			fmt.Fprintf(a.out, "  %s (secret)\n", key)
+			continue
+		}
+		fmt.Fprintf(a.out, "  %s\n", key)
+	}
+	for _, key := range instance.IgnoredRequiredEnv {
+		fmt.Fprintf(a.out, "  %s (required, missing: ignored at run)\n", key)
+	}
+
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "host mounts:")
+	if instance.ShareOwnership == vm.ShareOwnershipMapped {
+		fmt.Fprintln(a.out, "  ownership: mapped (host files owned by the qemu user; guest owners kept in xattrs)")
+	}
+	if instance.WorkspacePath == "" {
+		fmt.Fprintln(a.out, "  workspace: none (guest /workspace is empty)")
+	} else {
+		fmt.Fprintf(a.out, "  workspace: %s -> /workspace (read-write 9p)\n", instance.WorkspacePath)
+	}
+	switch instance.StateMode {
+	case vm.StateModeDisk:
+		fmt.Fprintln(a.out, "  state: not shared (kept inside the instance disk)")
+	case vm.StateModeNone:
+		fmt.Fprintln(a.out, "  state: not shared (ephemeral tmpfs)")
+	default:
+		fmt.Fprintf(a.out, "  state: %s -> /root/.openclaw (read-write 9p)\n", instance.StatePath)
+	}
+	for _, volume := range instance.Volumes {
+		fmt.Fprintf(a.out, "  volume %s: %s -> %s (read-write 9p)\n", volume.Name, volume.HostPath, volume.GuestPath)
+	}
+
+	fmt.Fprintln(a.out, "")
+	fmt.Fprintln(a.out, "isolation:")
+	rootfs := "read-write"
+	if instance.RootfsMode == vm.RootfsReadOnlyOverlay {
+		rootfs = "read-only (writes discarded on shutdown)"
+	}
+	fmt.Fprintf(a.out, "  rootfs: %s\n", rootfs)
+	if instance.DiskKeyPath != "" {
+		fmt.Fprintf(a.out, "  disk encryption: on (key: %s)\n", instance.DiskKeyPath)
+	} else {
+		fmt.Fprintln(a.out, "  disk encryption: off")
+	}
+	if instance.Hardened {
+		fmt.Fprintln(a.out, "  qemu sandbox: on")
+	} else {
+		fmt.Fprintln(a.out, "  qemu sandbox: off")
+	}
+	if instance.SSHHostPort > 0 {
+		fmt.Fprintf(a.out, "  ssh: key-only login as %s (key: %s)\n", instance.SSHUser(), instance.SSHKeyPath)
+	} else {
+		fmt.Fprintln(a.out, "  ssh: disabled")
+	}
+
+	runLogs, _ := filepath.Glob(filepath.Join(clawsRoot, instance.ID, "run-logs", "*.log"))
+	if len(runLogs) > 0 {
+		fmt.Fprintln(a.out, "")
+		fmt.Fprintln(a.out, "run logs:")
+		for _, path := range runLogs {
+			fmt.Fprintf(a.out, "  %s\n", path)
+		}
+	}
+	return nil
+}
+
+func auditPorts(instance state.Instance) []auditPort {
+	ports := make([]auditPort, 0, len(instance.Gateways)+len(instance.PublishedPorts)+1)
+	for index, gateway := range instance.Gateways {
+		role := "gateway"
+		if index > 0 {
+			role = "gateway:" + gateway.Name
+		}
+		ports = append(ports, auditPort{Role: role, HostPort: gateway.HostPort, GuestPort: gateway.GuestPort})
+	}
+	for _, mapping := range instance.PublishedPorts {
+		if mapping.HostPort == instance.GatewayPort() {
+			continue
+		}
+		ports = append(ports, auditPort{Role: "publish", HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
+	}
+	if instance.SSHHostPort > 0 {
+		ports = append(ports, auditPort{Role: "ssh", HostPort: instance.SSHHostPort, GuestPort: 22})
+	}
+	return ports
+}
+
+func looksLikeSecretEnvKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, marker := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "CREDENTIAL"} {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
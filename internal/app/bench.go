@@ -0,0 +1,227 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	bootPhasePIDFile       = "pidfile"
+	bootPhaseSSH           = "ssh"
+	bootPhaseGateway       = "gateway"
+	defaultBenchIterations = 3
+	maxBenchVariantName    = 40
+)
+
+var bootPhases = []string{bootPhasePIDFile, bootPhaseSSH, bootPhaseGateway}
+
+type benchVariant struct {
+	Name string
+	Args []string
+}
+
+type benchSample struct {
+	Phases map[string]time.Duration
+	Err    error
+}
+
+func (a *App) markBootPhase(phase string) {
+	if a.bootPhase != nil {
+		a.bootPhase(phase)
+	}
+}
+
+func (a *App) runBench(args []string) error {
+	if len(args) == 0 || args[0] != "boot" {
+		return errors.New("usage: clawfarm bench boot <ref> [--iterations N] [--variant name=\"run flags\"] [--ssh] [run flags]")
+	}
+	return a.runBenchBoot(args[1:])
+}
+
+func (a *App) runBenchBoot(args []string) error {
+	iterations := defaultBenchIterations
+	withSSH := false
+	variants := make([]benchVariant, 0)
+	runArgs := make([]string, 0, len(args))
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--iterations", "--variant":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", name)
+				}
+				index++
+				value = args[index]
+			}
+			if name == "--iterations" {
+				parsed, err := strconv.Atoi(strings.TrimSpace(value))
+				if err != nil || parsed < 1 {
+					return fmt.Errorf("invalid --iterations %q: expected a positive integer", value)
+				}
+				iterations = parsed
+				continue
+			}
+			variant, err := parseBenchVariant(value)
+			if err != nil {
+				return err
+			}
+			for _, existing := range variants {
+				if existing.Name == variant.Name {
+					return fmt.Errorf("duplicate --variant %q", variant.Name)
+				}
+			}
+			variants = append(variants, variant)
+		case "--ssh":
+			withSSH = true
+		case "--replicas", "--name":
+			return fmt.Errorf("%s cannot be used with bench boot", name)
+		default:
+			runArgs = append(runArgs, arg)
+		}
+	}
+	if len(runArgs) == 0 {
+		return errors.New("usage: clawfarm bench boot <ref> [--iterations N] [--variant name=\"run flags\"] [--ssh] [run flags]")
+	}
+	if len(variants) == 0 {
+		variants = append(variants, benchVariant{Name: "default"})
+	}
+	runArgs = normalizeRunArgs(runArgs)
+
+	results := make([][]benchSample, len(variants))
+	for variantIndex, variant := range variants {
+		for iteration := 1; iteration <= iterations; iteration++ {
+			sample := a.benchBootOnce(variant, runArgs, withSSH)
+			results[variantIndex] = append(results[variantIndex], sample)
+			if sample.Err != nil {
+				fmt.Fprintf(a.out, "%s #%d: failed: %v\n", variant.Name, iteration, sample.Err)
+				continue
+			}
+			fmt.Fprintf(a.out, "%s #%d: %s\n", variant.Name, iteration, formatBenchSample(sample))
+		}
+	}
+
+	fmt.Fprintln(a.out, "")
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONFIG\tPHASE\tOK\tMIN\tMEDIAN\tP95")
+	failed := 0
+	for variantIndex, variant := range variants {
+		for _, sample := range results[variantIndex] {
+			if sample.Err != nil {
+				failed++
+			}
+		}
+		for _, phase := range bootPhases {
+			durations := make([]time.Duration, 0, iterations)
+			for _, sample := range results[variantIndex] {
+				if duration, ok := sample.Phases[phase]; ok && sample.Err == nil {
+					durations = append(durations, duration)
+				}
+			}
+			if len(durations) == 0 {
+				continue
+			}
+			sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+			fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\t%s\t%s\n", variant.Name, phase, len(durations), iterations,
+				formatBenchDuration(durations[0]),
+				formatBenchDuration(medianDuration(durations)),
+				formatBenchDuration(percentileDuration(durations, 95)),
+			)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d bench boots failed", failed, iterations*len(variants))
+	}
+	return nil
+}
+
+func (a *App) benchBootOnce(variant benchVariant, runArgs []string, withSSH bool) benchSample {
+	var output bytes.Buffer
+	sample := benchSample{Phases: map[string]time.Duration{}}
+	started := time.Now()
+
+	child := *a
+	child.out = &output
+	child.errOut = &output
+	child.in = nil
+	child.bootPhase = func(phase string) {
+		if _, seen := sample.Phases[phase]; !seen {
+			sample.Phases[phase] = time.Since(started)
+		}
+	}
+
+	args := append([]string{"--name", "bench-" + variant.Name}, variant.Args...)
+	if withSSH {
+		args = append(args, "--run", "true")
+	}
+	args = append(args, runArgs...)
+	instance, err := child.runInstance(context.Background(), args, 0)
+	sample.Err = err
+
+	if id := instance.ID; id != "" {
+		var removeOutput bytes.Buffer
+		cleanup := *a
+		cleanup.out = &removeOutput
+		cleanup.errOut = &removeOutput
+		if err := cleanup.runRemove([]string{id}); err != nil {
+			fmt.Fprintf(a.errOut, "warning: remove bench instance %s: %v\n", id, err)
+		}
+	}
+	return sample
+}
+
+func parseBenchVariant(value string) (benchVariant, error) {
+	name, flags, found := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return benchVariant{}, fmt.Errorf("invalid --variant %q: expected name=\"run flags\"", value)
+	}
+	if len(name) > maxBenchVariantName || !runNamePattern.MatchString(name) {
+		return benchVariant{}, fmt.Errorf("invalid --variant name %q: use up to %d lowercase letters, digits, and '-'", name, maxBenchVariantName)
+	}
+	return benchVariant{Name: name, Args: strings.Fields(flags)}, nil
+}
+
+func formatBenchSample(sample benchSample) string {
+	parts := make([]string, 0, len(bootPhases))
+	for _, phase := range bootPhases {
+		if duration, ok := sample.Phases[phase]; ok {
+			parts = append(parts, fmt.Sprintf("%s %s", phase, formatBenchDuration(duration)))
+		}
+	}
+	if len(parts) == 0 {
+		return "no boot phases observed"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBenchDuration(duration time.Duration) string {
+	return duration.Round(time.Millisecond).String()
+}
+
+func medianDuration(sorted []time.Duration) time.Duration {
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return (sorted[middle-1] + sorted[middle]) / 2
+}
+
+func percentileDuration(sorted []time.Duration, percentile int) time.Duration {
+	rank := (percentile*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,320 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+)
+
+const blobPinsFile = "pins.json"
+
+var blobDigestPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+type blobEntry struct {
+	Digest   string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+	Pinned   bool
+	UsedBy   []string
+}
+
+func (entry blobEntry) evictable(keep time.Duration, now time.Time) bool {
+	return !entry.Pinned && len(entry.UsedBy) == 0 && now.Sub(entry.LastUsed) >= keep
+}
+
+func (a *App) runBlob(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: clawfarm blob <ls|pin|unpin|prune>")
+	}
+	root, err := a.blobsRoot()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "ls":
+		if len(args) != 1 {
+			return errors.New("usage: clawfarm blob ls")
+		}
+		return a.runBlobList(root)
+	case "pin", "unpin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: clawfarm blob %s <digest>", args[0])
+		}
+		return a.runBlobPin(root, args[1], args[0] == "pin")
+	case "prune":
+		if len(args) != 1 {
+			return errors.New("usage: clawfarm blob prune")
+		}
+		removed, freed, err := a.evictBlobs(root, 0, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "removed %d blob(s), freed %s\n", len(removed), diskutil.HumanBytes(freed))
+		return nil
+	default:
+		return fmt.Errorf("unknown blob subcommand %q", args[0])
+	}
+}
+
+func (a *App) runBlobList(root string) error {
+	entries, err := a.listBlobs(root)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := config.BlobCacheMaxBytes()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(a.out, "no blobs")
+		return nil
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIGEST\tSIZE\tLAST_USED(UTC)\tPINNED\tUSED_BY")
+	for _, entry := range entries {
+		total += entry.Size
+		pinned := "-"
+		if entry.Pinned {
+			pinned = "yes"
+		}
+		usedBy := "-"
+		if len(entry.UsedBy) > 0 {
+			usedBy = strings.Join(entry.UsedBy, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Digest[:12], diskutil.HumanBytes(entry.Size), entry.LastUsed.UTC().Format(time.RFC3339), pinned, usedBy)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "total: %s of %s cap\n", diskutil.HumanBytes(total), diskutil.HumanBytes(maxBytes))
+	return nil
+}
+
+func (a *App) runBlobPin(root string, prefix string, pin bool) error {
+	entries, err := a.listBlobs(root)
+	if err != nil {
+		return err
+	}
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	matches := []string{}
+	for _, entry := range entries {
+		if prefix != "" && strings.HasPrefix(entry.Digest, prefix) {
+			matches = append(matches, entry.Digest)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("blob %s not found", prefix)
+	case 1:
+	default:
+		return fmt.Errorf("blob prefix %s is ambiguous (%d matches)", prefix, len(matches))
+	}
+
+	pins, err := readBlobPins(root)
+	if err != nil {
+		return err
+	}
+	if pin {
+		pins[matches[0]] = true
+	} else {
+		delete(pins, matches[0])
+	}
+	if err := writeBlobPins(root, pins); err != nil {
+		return err
+	}
+	if pin {
+		fmt.Fprintf(a.out, "pinned %s\n", matches[0])
+	} else {
+		fmt.Fprintf(a.out, "unpinned %s\n", matches[0])
+	}
+	return nil
+}
+
+func (a *App) listBlobs(root string) ([]blobEntry, error) {
+	dirEntries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pins, err := readBlobPins(root)
+	if err != nil {
+		return nil, err
+	}
+	usedBy, err := a.blobReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]blobEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || !blobDigestPattern.MatchString(dirEntry.Name()) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, blobEntry{
+			Digest:   dirEntry.Name(),
+			Path:     filepath.Join(root, dirEntry.Name()),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+			Pinned:   pins[dirEntry.Name()],
+			UsedBy:   usedBy[dirEntry.Name()],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].Digest < entries[j].Digest
+		}
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+func (a *App) blobReferences() (map[string][]string, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	kept, err := store.KeptBlobs()
+	if err != nil {
+		return nil, err
+	}
+	references := map[string][]string{}
+	for _, instance := range instances {
+		for _, digest := range instance.Blobs {
+			references[digest] = append(references[digest], instance.ID)
+		}
+	}
+	// Data dirs kept by rm --keep-data still hold disks and checkpoints that
+	// were built from these blobs.
+	keptIDs := make([]string, 0, len(kept))
+	for id := range kept {
+		keptIDs = append(keptIDs, id)
+	}
+	sort.Strings(keptIDs)
+	for _, id := range keptIDs {
+		for _, digest := range kept[id] {
+			references[digest] = append(references[digest], id+" (kept data)")
+		}
+	}
+	return references, nil
+}
+
+func (a *App) evictBlobs(root string, maxBytes int64, protect map[string]bool) ([]blobEntry, int64, error) {
+	keep, err := config.BlobCacheKeepDuration()
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := a.listBlobs(root)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	now := time.Now()
+	removed := []blobEntry{}
+	var freed int64
+	for _, entry := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if protect[entry.Digest] || !entry.evictable(keep, now) {
+			continue
+		}
+		if err := os.Remove(entry.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, freed, err
+		}
+		total -= entry.Size
+		freed += entry.Size
+		removed = append(removed, entry)
+	}
+	return removed, freed, nil
+}
+
+func (a *App) enforceBlobCache(root string, justUsed []string) {
+	maxBytes, err := config.BlobCacheMaxBytes()
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: blob cache limit ignored: %v\n", err)
+		return
+	}
+	protect := map[string]bool{}
+	for _, digest := range justUsed {
+		protect[digest] = true
+	}
+	removed, freed, err := a.evictBlobs(root, maxBytes, protect)
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: blob cache eviction failed: %v\n", err)
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(a.out, "blob cache: evicted %d least recently used blob(s), freed %s\n", len(removed), diskutil.HumanBytes(freed))
+	}
+}
+
+func readBlobPins(root string) (map[string]bool, error) {
+	payload, err := os.ReadFile(filepath.Join(root, blobPinsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return map[string]bool{}, nil
+		}
+		return nil, err
+	}
+	digests := []string{}
+	if err := json.Unmarshal(payload, &digests); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", blobPinsFile, err)
+	}
+	pins := make(map[string]bool, len(digests))
+	for _, digest := range digests {
+		pins[digest] = true
+	}
+	return pins, nil
+}
+
+func writeBlobPins(root string, pins map[string]bool) error {
+	digests := make([]string, 0, len(pins))
+	for digest := range pins {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	payload, err := json.MarshalIndent(digests, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(root); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, blobPinsFile), append(payload, '\n'), 0o644)
+}
+
+func blobDigests(paths []string) []string {
+	digests := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if digest := filepath.Base(path); blobDigestPattern.MatchString(digest) {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
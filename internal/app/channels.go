@@ -0,0 +1,258 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	channelsVerifyUsage = "usage: clawfarm channels verify <clawid> [--public-url https://...] [--env-file path]"
+	channelProbeTimeout = 10 * time.Second
+	guestOpenClawEnv    = "/etc/clawfarm/openclaw.env"
+)
+
+// chatChannelAPIBase is the API endpoint each chat channel is probed against;
+// tests point it at a local server.
+var chatChannelAPIBase = map[string]string{
+	"discord":  "https://discord.com/api/v10",
+	"telegram": "https://api.telegram.org",
+	"whatsapp": "https://graph.facebook.com/v19.0",
+}
+
+var channelPlaceholderPattern = regexp.MustCompile(`\{([A-Z_]+)\}`)
+
+// chatChannel describes a read-only API call that succeeds only with valid
+// credentials. Path and Header reference env keys as {KEY}.
+type chatChannel struct {
+	Name   string
+	Keys   []string
+	Path   string
+	Header string
+}
+
+var chatChannels = []chatChannel{
+	{Name: "discord", Keys: []string{"DISCORD_TOKEN"}, Path: "/users/@me", Header: "Authorization: Bot {DISCORD_TOKEN}"},
+	{Name: "telegram", Keys: []string{"TELEGRAM_TOKEN"}, Path: "/bot{TELEGRAM_TOKEN}/getMe"},
+	{Name: "whatsapp", Keys: []string{"WHATSAPP_PHONE_NUMBER_ID", "WHATSAPP_ACCESS_TOKEN", "WHATSAPP_VERIFY_TOKEN", "WHATSAPP_APP_SECRET"}, Path: "/{WHATSAPP_PHONE_NUMBER_ID}", Header: "Authorization: Bearer {WHATSAPP_ACCESS_TOKEN}"},
+}
+
+type channelResult struct {
+	Name   string
+	Status int
+	Error  string
+}
+
+func (a *App) runChannels(args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New(channelsVerifyUsage)
+	}
+	flags := flag.NewFlagSet("channels verify", flag.ContinueOnError)
+	flags.SetOutput(a.errOut)
+	publicURL := ""
+	envFile := ""
+	flags.StringVar(&publicURL, "public-url", "", "public or tunnel URL that forwards to the instance gateway")
+	flags.StringVar(&envFile, "env-file", "", "verify from the host with credentials from this env file when the guest is unreachable")
+	if err := flags.Parse(normalizeRunArgs(args[1:])); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(channelsVerifyUsage)
+	}
+	id := strings.TrimSpace(flags.Arg(0))
+	publicURL = strings.TrimRight(strings.TrimSpace(publicURL), "/")
+	if publicURL != "" && !strings.HasPrefix(publicURL, "https://") {
+		return fmt.Errorf("invalid --public-url %q: chat platforms only deliver webhooks over https", publicURL)
+	}
+
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+
+	results, guestErr := a.verifyChannelsInGuest(id)
+	origin := "guest"
+	if guestErr != nil {
+		if envFile == "" {
+			return fmt.Errorf("%w; pass --env-file to verify the credentials from the host", guestErr)
+		}
+		fmt.Fprintf(a.errOut, "warning: cannot verify from inside %s (%v); verifying from the host\n", id, guestErr)
+		env, err := parseOpenClawEnvFile(envFile)
+		if err != nil {
+			return err
+		}
+		results = verifyChannelsOnHost(context.Background(), env)
+		origin = "host"
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(a.out, "no chat channels configured")
+		return nil
+	}
+	failed := []string{}
+	configured := map[string]bool{}
+	for _, result := range results {
+		configured[result.Name] = true
+		if result.Error != "" {
+			failed = append(failed, result.Name)
+			fmt.Fprintf(a.out, "%s: failed (%s)\n", result.Name, result.Error)
+			continue
+		}
+		fmt.Fprintf(a.out, "%s: ok\n", result.Name)
+	}
+	fmt.Fprintf(a.out, "verified from: %s\n", origin)
+
+	webhookBase := publicURL
+	if webhookBase == "" {
+		webhookBase = fmt.Sprintf("http://127.0.0.1:%d", instance.GatewayPort())
+	}
+	fmt.Fprintln(a.out, "")
+	if configured["discord"] {
+		fmt.Fprintln(a.out, "discord: connects out over the Discord gateway; no webhook needed")
+	}
+	if configured["telegram"] {
+		fmt.Fprintf(a.out, "telegram: long polling works without setup; for webhooks run\n")
+		fmt.Fprintf(a.out, "  curl \"https://api.telegram.org/bot$TELEGRAM_TOKEN/setWebhook?url=%s/webhooks/telegram\"\n", webhookBase)
+	}
+	if configured["whatsapp"] {
+		fmt.Fprintln(a.out, "whatsapp: in the Meta app dashboard under WhatsApp > Configuration set")
+		fmt.Fprintf(a.out, "  callback URL: %s/webhooks/whatsapp\n", webhookBase)
+		fmt.Fprintln(a.out, "  verify token: the WHATSAPP_VERIFY_TOKEN value")
+	}
+	if publicURL == "" && (configured["telegram"] || configured["whatsapp"]) {
+		fmt.Fprintln(a.out, "note: the gateway only listens on this host; expose it through a tunnel and rerun with --public-url")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("chat channel check failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// verifyChannelsInGuest probes the channels configured in the guest's
+// OpenClaw env file from inside the VM, so the credentials never leave it.
+func (a *App) verifyChannelsInGuest(id string) ([]channelResult, error) {
+	instance, err := a.loadSSHReadyInstance(id)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := guestSudoCommand(instance, "bash -c "+shellSingleQuote(channelProbeScript()))
+	if err != nil {
+		return nil, err
+	}
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), probe)
+	output, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("probe chat channels in %s: %w", id, err)
+	}
+
+	results := []channelResult{}
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		name, code, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		status, _ := strconv.Atoi(code)
+		results = append(results, channelResult{Name: name, Status: status, Error: channelStatusError(status)})
+	}
+	return results, nil
+}
+
+func channelProbeScript() string {
+	lines := []string{
+		"set -a; . " + guestOpenClawEnv + "; set +a",
+		"probe() { name=$1; shift; code=$(curl -sS -o /dev/null -m " + strconv.Itoa(int(channelProbeTimeout.Seconds())) + " -w '%{http_code}' \"$@\" 2>/dev/null) || code=000; echo \"$name $code\"; }",
+	}
+	for _, channel := range chatChannels {
+		conditions := make([]string, 0, len(channel.Keys))
+		for _, key := range channel.Keys {
+			conditions = append(conditions, fmt.Sprintf("[ -n \"${%s:-}\" ]", key))
+		}
+		command := "probe " + channel.Name
+		if channel.Header != "" {
+			command += " -H " + channelShellTemplate(channel.Header)
+		}
+		command += " " + channelShellTemplate(chatChannelAPIBase[channel.Name]+channel.Path)
+		lines = append(lines, strings.Join(conditions, " && ")+" && "+command)
+	}
+	lines = append(lines, "true")
+	return strings.Join(lines, "\n")
+}
+
+func channelShellTemplate(template string) string {
+	return "\"" + channelPlaceholderPattern.ReplaceAllString(template, "$${${1}}") + "\""
+}
+
+func verifyChannelsOnHost(ctx context.Context, env map[string]string) []channelResult {
+	client := &http.Client{Timeout: channelProbeTimeout}
+	results := []channelResult{}
+	for _, channel := range chatChannels {
+		if !channelConfigured(channel, env) {
+			continue
+		}
+		expand := func(template string) string {
+			return channelPlaceholderPattern.ReplaceAllStringFunc(template, func(match string) string {
+				return strings.TrimSpace(env[match[1:len(match)-1]])
+			})
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, expand(chatChannelAPIBase[channel.Name]+channel.Path), nil)
+		if err != nil {
+			results = append(results, channelResult{Name: channel.Name, Error: err.Error()})
+			continue
+		}
+		if channel.Header != "" {
+			name, value, _ := strings.Cut(expand(channel.Header), ":")
+			request.Header.Set(name, strings.TrimSpace(value))
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			results = append(results, channelResult{Name: channel.Name, Error: channelStatusError(0)})
+			continue
+		}
+		_ = response.Body.Close()
+		results = append(results, channelResult{Name: channel.Name, Status: response.StatusCode, Error: channelStatusError(response.StatusCode)})
+	}
+	return results
+}
+
+func channelConfigured(channel chatChannel, env map[string]string) bool {
+	for _, key := range channel.Keys {
+		if strings.TrimSpace(env[key]) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func channelStatusError(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return ""
+	case status == 0:
+		return "API unreachable"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return fmt.Sprintf("credentials rejected, HTTP %d", status)
+	case status == http.StatusNotFound:
+		return "not found, HTTP 404; check the token or phone number id"
+	default:
+		return fmt.Sprintf("HTTP %d", status)
+	}
+}
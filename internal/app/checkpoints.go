@@ -0,0 +1,145 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+func (a *App) runCheckpointList(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: clawfarm checkpoint ls <clawid>")
+	}
+	id := strings.TrimSpace(args[0])
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	if _, err := store.Load(id); err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+	checkpoints, err := listCheckpoints(clawsRoot, id)
+	if err != nil {
+		return err
+	}
+	if len(checkpoints) == 0 {
+		fmt.Fprintf(a.out, "no checkpoints for %s\n", id)
+		return nil
+	}
+
+	var total int64
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCREATED(UTC)\tSIZE\tMEMORY")
+	for _, checkpoint := range checkpoints {
+		memory := "no"
+		if checkpoint.WithMemory {
+			memory = "yes"
+		}
+		total += checkpoint.SizeBytes
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", checkpoint.Name, checkpoint.CreatedAtUTC.Format(time.RFC3339), diskutil.HumanBytes(checkpoint.SizeBytes), memory)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "%d checkpoints, %s total\n", len(checkpoints), diskutil.HumanBytes(total))
+	return nil
+}
+
+func (a *App) runCheckpointRemove(args []string) error {
+	flags := flag.NewFlagSet("checkpoint rm", flag.ContinueOnError)
+	flags.SetOutput(a.errOut)
+	checkpointName := ""
+	flags.StringVar(&checkpointName, "name", "", "checkpoint name")
+	if err := flags.Parse(normalizeRunArgs(args)); err != nil {
+		return err
+	}
+	checkpointName = strings.TrimSpace(checkpointName)
+	if flags.NArg() != 1 || checkpointName == "" {
+		return errors.New("usage: clawfarm checkpoint rm <clawid> --name <name>")
+	}
+	if err := validateCheckpointName(checkpointName); err != nil {
+		return err
+	}
+	id := strings.TrimSpace(flags.Arg(0))
+	_, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	err = lockManager.WithInstanceLock(id, func() error {
+		checkpointPath := checkpointPathForName(clawsRoot, id, checkpointName)
+		clones, err := a.overlayClonesOf(checkpointPath)
+		if err != nil {
+			return err
+		}
+		if len(clones) > 0 {
+			return fmt.Errorf("checkpoint %s backs overlay clones %s; remove those first", checkpointName, strings.Join(clones, ", "))
+		}
+		return removeCheckpoint(checkpointPath)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("checkpoint %s not found for %s", checkpointName, id)
+		}
+		return err
+	}
+	fmt.Fprintf(a.out, "removed checkpoint %s of %s\n", checkpointName, id)
+	return nil
+}
+
+// pruneCheckpoints deletes the oldest checkpoints of an instance until only
+// the newest keep remain.
+func (a *App) pruneCheckpoints(id string, keep int) error {
+	_, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	return lockManager.WithInstanceLock(id, func() error {
+		checkpoints, err := listCheckpoints(clawsRoot, id)
+		if err != nil {
+			return err
+		}
+		for index := 0; index < len(checkpoints)-keep; index++ {
+			clones, err := a.overlayClonesOf(checkpoints[index].Path)
+			if err != nil {
+				return err
+			}
+			if len(clones) > 0 {
+				fmt.Fprintf(a.out, "kept checkpoint %s of %s: it backs overlay clones %s\n", checkpoints[index].Name, id, strings.Join(clones, ", "))
+				continue
+			}
+			if err := removeCheckpoint(checkpoints[index].Path); err != nil {
+				return err
+			}
+			fmt.Fprintf(a.out, "pruned checkpoint %s of %s\n", checkpoints[index].Name, id)
+		}
+		return nil
+	})
+}
+
+func removeCheckpoint(checkpointPath string) error {
+	if err := os.Remove(checkpointPath); err != nil {
+		return err
+	}
+	if err := os.Remove(checkpointMemoryPath(checkpointPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	ciReadyTimeoutSecs      = 300
+	ciSSHReadyTimeout       = 2 * time.Minute
+	defaultSSHReadyTimeout  = 5 * time.Minute
+	ciSupervisePollInterval = time.Second
+)
+
+type ciRegistry struct {
+	mu      sync.Mutex
+	cleanup map[string]func()
+	notify  chan os.Signal
+}
+
+var ciInstances = &ciRegistry{cleanup: map[string]func(){}}
+
+func (r *ciRegistry) add(id string, cleanup func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanup[id] = cleanup
+	if r.notify != nil {
+		return
+	}
+	r.notify = make(chan os.Signal, 1)
+	signal.Notify(r.notify, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go r.wait(r.notify)
+}
+
+func (r *ciRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cleanup, id)
+	if len(r.cleanup) == 0 && r.notify != nil {
+		signal.Stop(r.notify)
+		close(r.notify)
+		r.notify = nil
+	}
+}
+
+func (r *ciRegistry) wait(notify chan os.Signal) {
+	received, ok := <-notify
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	pending := make([]func(), 0, len(r.cleanup))
+	for _, cleanup := range r.cleanup {
+		pending = append(pending, cleanup)
+	}
+	r.mu.Unlock()
+	for _, cleanup := range pending {
+		cleanup()
+	}
+	code := 1
+	if signalNumber, isSyscall := received.(syscall.Signal); isSyscall {
+		code = 128 + int(signalNumber)
+	}
+	os.Exit(code)
+}
+
+func (a *App) registerCIInstance(id string) func() {
+	ciInstances.add(id, func() {
+		fmt.Fprintf(a.errOut, "ci: interrupted; stopping %s\n", id)
+		a.stopCIInstance(id)
+		a.removeCIInstance(id)
+	})
+	return func() {
+		ciInstances.remove(id)
+		a.removeCIInstance(id)
+	}
+}
+
+func (a *App) stopCIInstance(id string) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return
+	}
+	instance, err := store.Load(id)
+	if err != nil || instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+		return
+	}
+	stopCtx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
+	defer cancel()
+	if err := a.backend.Stop(stopCtx, instanceProcess(instance)); err != nil {
+		fmt.Fprintf(a.errOut, "warning: ci: stop %s: %v\n", id, err)
+	}
+}
+
+func (a *App) removeCIInstance(id string) {
+	var output bytes.Buffer
+	child := *a
+	child.out = &output
+	child.errOut = &output
+	child.in = nil
+	err := child.runRemove([]string{id})
+	if err == nil {
+		fmt.Fprintf(a.out, "ci: removed %s\n", id)
+		return
+	}
+	store, _, storeErr := a.instanceStore()
+	if storeErr == nil {
+		if _, loadErr := store.Load(id); errors.Is(loadErr, state.ErrNotFound) {
+			return
+		}
+	}
+	fmt.Fprintf(a.errOut, "warning: ci: remove %s: %v\n", id, err)
+}
+
+func startCISupervisor(dirs config.DirOverrides, id string, instanceDir string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(instanceDir, "ci-supervise.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	dataDir, err := dirs.ResolveDataDir()
+	if err != nil {
+		return 0, err
+	}
+	cacheDir, err := dirs.ResolveCacheDir()
+	if err != nil {
+		return 0, err
+	}
+	command := exec.Command(executable, "--data-dir", dataDir, "--cache-dir", cacheDir, "ci-supervise", strconv.Itoa(os.Getpid()), id)
+	command.Stdout = logFile
+	command.Stderr = logFile
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := command.Start(); err != nil {
+		return 0, err
+	}
+	pid := command.Process.Pid
+	_ = command.Process.Release()
+	return pid, nil
+}
+
+func (a *App) runCISupervise(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: clawfarm ci-supervise <parent-pid> <clawid>")
+	}
+	parentPID, err := strconv.Atoi(args[0])
+	if err != nil || parentPID <= 0 {
+		return fmt.Errorf("invalid parent pid %q", args[0])
+	}
+	id := args[1]
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	for parentAlive(parentPID) {
+		if _, err := store.Load(id); errors.Is(err, state.ErrNotFound) {
+			return nil
+		}
+		time.Sleep(ciSupervisePollInterval)
+	}
+	if _, err := store.Load(id); errors.Is(err, state.ErrNotFound) {
+		return nil
+	}
+	fmt.Fprintf(a.out, "ci: parent %d exited; removing %s\n", parentPID, id)
+	return a.runRemove([]string{id})
+}
+
+func parentAlive(pid int) bool {
+	if os.Getppid() != pid {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Exports default to gzip, which every clawfarm version and host can read;
+// zstd is opt-in because importing it needs the zstd binary.
+const (
+	clawboxCompressionGzip = "gzip"
+	clawboxCompressionZstd = "zstd"
+)
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func parseClawboxCompression(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", clawboxCompressionGzip, "gz":
+		return clawboxCompressionGzip, nil
+	case clawboxCompressionZstd, "zst":
+		return clawboxCompressionZstd, nil
+	}
+	return "", fmt.Errorf("invalid --compression %q: expected gzip or zstd", value)
+}
+
+func openClawboxStream(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(zstdMagic))
+	count, _ := io.ReadFull(file, magic)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if count == len(zstdMagic) && bytes.Equal(magic, zstdMagic) {
+		reader, err := newZstdReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("open .clawbox as gzip stream: %w", err)
+	}
+	return &gzipFileReader{Reader: gzReader, file: file}, nil
+}
+
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+type zstdReader struct {
+	command *exec.Cmd
+	stdout  io.ReadCloser
+	stderr  bytes.Buffer
+	file    *os.File
+	done    bool
+}
+
+func newZstdReader(file *os.File) (*zstdReader, error) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, errors.New(".clawbox is zstd-compressed; install zstd to import it")
+	}
+	reader := &zstdReader{file: file}
+	reader.command = exec.Command("zstd", "-d", "-c", "-q")
+	reader.command.Stdin = file
+	reader.command.Stderr = &reader.stderr
+	stdout, err := reader.command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	reader.stdout = stdout
+	if err := reader.command.Start(); err != nil {
+		return nil, fmt.Errorf("start zstd: %w", err)
+	}
+	return reader, nil
+}
+
+func (r *zstdReader) Read(buffer []byte) (int, error) {
+	count, err := r.stdout.Read(buffer)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if waitErr := r.command.Wait(); waitErr != nil {
+			return count, fmt.Errorf("decompress .clawbox with zstd: %v: %s", waitErr, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return count, err
+}
+
+func (r *zstdReader) Close() error {
+	if !r.done {
+		r.done = true
+		_ = r.command.Process.Kill()
+		_ = r.command.Wait()
+	}
+	return r.file.Close()
+}
+
+func newClawboxWriter(file *os.File, compression string) (io.WriteCloser, error) {
+	if compression != clawboxCompressionZstd {
+		return gzip.NewWriter(file), nil
+	}
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, errors.New("zstd compression requires the zstd binary; install it or pass --compression gzip")
+	}
+	writer := &zstdWriter{}
+	writer.command = exec.Command("zstd", "-c", "-q", "-T0", "-3")
+	writer.command.Stdout = file
+	writer.command.Stderr = &writer.stderr
+	stdin, err := writer.command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	writer.stdin = stdin
+	if err := writer.command.Start(); err != nil {
+		return nil, fmt.Errorf("start zstd: %w", err)
+	}
+	return writer, nil
+}
+
+type zstdWriter struct {
+	command *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  bytes.Buffer
+}
+
+func (w *zstdWriter) Write(payload []byte) (int, error) {
+	return w.stdin.Write(payload)
+}
+
+func (w *zstdWriter) Close() error {
+	closeErr := w.stdin.Close()
+	if err := w.command.Wait(); err != nil {
+		return fmt.Errorf("compress .clawbox with zstd: %v: %s", err, strings.TrimSpace(w.stderr.String()))
+	}
+	return closeErr
+}
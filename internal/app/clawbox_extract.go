@@ -0,0 +1,143 @@
+package app
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/fetch"
+)
+
+const clawboxProgressThreshold int64 = 64 << 20
+
+type clawboxExtractor struct {
+	maxEntryBytes int64
+	maxTotalBytes int64
+	extracted     int64
+	out           io.Writer
+}
+
+func newClawboxExtractor(out io.Writer) (*clawboxExtractor, error) {
+	maxEntryBytes, err := config.ClawboxMaxEntryBytes()
+	if err != nil {
+		return nil, err
+	}
+	maxTotalBytes, err := config.ClawboxMaxTotalBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &clawboxExtractor{maxEntryBytes: maxEntryBytes, maxTotalBytes: maxTotalBytes, out: out}, nil
+}
+
+func (e *clawboxExtractor) checkEntry(name string, header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeDir:
+	case tar.TypeSymlink, tar.TypeLink:
+		return fmt.Errorf("refusing .clawbox entry %s: links are not allowed (points to %s)", name, header.Linkname)
+	case tar.TypeChar, tar.TypeBlock:
+		return fmt.Errorf("refusing .clawbox entry %s: device files are not allowed", name)
+	case tar.TypeFifo:
+		return fmt.Errorf("refusing .clawbox entry %s: named pipes are not allowed", name)
+	default:
+		return fmt.Errorf("refusing .clawbox entry %s: unsupported tar entry type %q", name, header.Typeflag)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	if header.Size > e.maxEntryBytes {
+		return fmt.Errorf("refusing .clawbox entry %s: %s exceeds the %s per-entry limit (raise CLAWFARM_CLAWBOX_MAX_ENTRY_BYTES to allow it)", name, diskutil.HumanBytes(header.Size), diskutil.HumanBytes(e.maxEntryBytes))
+	}
+	if e.extracted+header.Size > e.maxTotalBytes {
+		return fmt.Errorf("refusing .clawbox entry %s: extracting it would exceed the %s total limit (raise CLAWFARM_CLAWBOX_MAX_TOTAL_BYTES to allow it)", name, diskutil.HumanBytes(e.maxTotalBytes))
+	}
+	return nil
+}
+
+func (e *clawboxExtractor) extract(reader io.Reader, header *tar.Header, path string, mode os.FileMode, label string) error {
+	if e.out != nil && label != "" && header.Size >= clawboxProgressThreshold {
+		progress := &clawboxProgressReader{reader: reader, out: e.out, label: label, total: header.Size}
+		reader = progress
+		defer progress.finish()
+	}
+	if err := writeTarRegularFileToPath(reader, path, mode); err != nil {
+		return err
+	}
+	e.extracted += header.Size
+	return nil
+}
+
+func (e *clawboxExtractor) extractDisk(reader io.Reader, header *tar.Header, path string, expectedSHA256 string, label string) error {
+	if e.out != nil && label != "" && header.Size >= clawboxProgressThreshold {
+		progress := &clawboxProgressReader{reader: reader, out: e.out, label: label, total: header.Size}
+		reader = progress
+		defer progress.finish()
+	}
+	if err := ensureDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		file.Close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	hasher := sha256.New()
+	written, err := diskutil.WriteSparse(file, io.TeeReader(reader, hasher))
+	if err != nil {
+		return fail(err)
+	}
+	if written != header.Size {
+		return fail(fmt.Errorf("short read for %s: got %d of %d bytes", path, written, header.Size))
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(actual, strings.TrimSpace(expectedSHA256)) {
+		return fail(fmt.Errorf("sha256 mismatch for %s: expected %s got %s", path, expectedSHA256, actual))
+	}
+	if err := file.Truncate(written); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	e.extracted += header.Size
+	return nil
+}
+
+type clawboxProgressReader struct {
+	reader     io.Reader
+	out        io.Writer
+	label      string
+	total      int64
+	read       int64
+	lastRender time.Time
+}
+
+func (r *clawboxProgressReader) Read(buffer []byte) (int, error) {
+	readBytes, err := r.reader.Read(buffer)
+	r.read += int64(readBytes)
+	if time.Since(r.lastRender) >= 120*time.Millisecond {
+		r.lastRender = time.Now()
+		fetch.RenderProgress(r.out, r.label, r.read, r.total)
+	}
+	return readBytes, err
+}
+
+func (r *clawboxProgressReader) finish() {
+	fetch.RenderProgress(r.out, r.label, r.read, r.total)
+	fmt.Fprintln(r.out)
+}
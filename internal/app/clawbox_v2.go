@@ -3,7 +3,9 @@ package app
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,11 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/yazhou/krunclaw/internal/clawbox"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
 )
 
 const (
@@ -30,6 +37,13 @@ type runClawboxSpecV2 struct {
 	Images        []runClawboxImageV2   `json:"image"`
 	Provision     []runProvisionStepV2  `json:"provision,omitempty"`
 	OpenClaw      runOpenClawConfigSpec `json:"openclaw"`
+	RunDefaults   *clawbox.RunDefaults  `json:"run_defaults,omitempty"`
+	Manifest      []runClawboxFileV2    `json:"manifest,omitempty"`
+}
+
+type runClawboxFileV2 struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
 }
 
 type runClawboxImageV2 struct {
@@ -45,10 +59,18 @@ type runProvisionStepV2 struct {
 }
 
 type runOpenClawConfigSpec struct {
-	ModelPrimary    string   `json:"model_primary,omitempty"`
-	GatewayAuthMode string   `json:"gateway_auth_mode,omitempty"`
-	RequiredEnv     []string `json:"required_env,omitempty"`
-	OptionalEnv     []string `json:"optional_env,omitempty"`
+	ModelPrimary    string                 `json:"model_primary,omitempty"`
+	GatewayAuthMode string                 `json:"gateway_auth_mode,omitempty"`
+	RequiredEnv     []string               `json:"required_env,omitempty"`
+	OptionalEnv     []string               `json:"optional_env,omitempty"`
+	Package         string                 `json:"package,omitempty"`
+	Integrity       string                 `json:"integrity,omitempty"`
+	Bundle          *runOpenClawBundleSpec `json:"bundle,omitempty"`
+}
+
+type runOpenClawBundleSpec struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
 }
 
 func resolveRunTargetFromTarClawbox(input string, clawboxPath string) (runTarget, error) {
@@ -75,24 +97,23 @@ func resolveRunTargetFromTarClawbox(input string, clawboxPath string) (runTarget
 		OpenClawModelPrimary:    strings.TrimSpace(spec.OpenClaw.ModelPrimary),
 		OpenClawGatewayAuthMode: strings.TrimSpace(spec.OpenClaw.GatewayAuthMode),
 		OpenClawRequiredEnv:     append([]string(nil), spec.OpenClaw.RequiredEnv...),
+		OpenClawOptionalEnv:     append([]string(nil), spec.OpenClaw.OptionalEnv...),
+		OpenClawPackage:         strings.TrimSpace(spec.OpenClaw.Package),
+		OpenClawIntegrity:       strings.TrimSpace(spec.OpenClaw.Integrity),
+		OpenClawBundle:          spec.OpenClaw.Bundle,
+		RunDefaults:             spec.RunDefaults,
 		IsClawbox:               true,
 	}, nil
 }
 
 func parseRunClawboxSpecV2(clawboxPath string) (runClawboxSpecV2, error) {
-	file, err := os.Open(clawboxPath)
+	stream, err := openClawboxStream(clawboxPath)
 	if err != nil {
 		return runClawboxSpecV2{}, err
 	}
-	defer file.Close()
-
-	gzReader, err := gzip.NewReader(file)
-	if err != nil {
-		return runClawboxSpecV2{}, fmt.Errorf("open .clawbox as gzip stream: %w", err)
-	}
-	defer gzReader.Close()
+	defer stream.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	tarReader := tar.NewReader(stream)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -170,6 +191,45 @@ func (spec runClawboxSpecV2) validate() error {
 			return fmt.Errorf("openclaw.gateway_auth_mode %q is invalid", spec.OpenClaw.GatewayAuthMode)
 		}
 	}
+	if integrity := strings.TrimSpace(spec.OpenClaw.Integrity); integrity != "" && !vm.OpenClawIntegrityPattern.MatchString(integrity) {
+		return fmt.Errorf("openclaw.integrity %q must be sha512-<base64>", spec.OpenClaw.Integrity)
+	}
+	if strings.HasPrefix(strings.TrimSpace(spec.OpenClaw.Package), "clawbox:///") && strings.TrimSpace(spec.OpenClaw.Integrity) == "" {
+		return errors.New("openclaw.integrity is required when openclaw.package is bundled in the .clawbox")
+	}
+	if bundle := spec.OpenClaw.Bundle; bundle != nil {
+		if strings.TrimSpace(spec.OpenClaw.Package) != "" {
+			return errors.New("openclaw.bundle and openclaw.package are mutually exclusive")
+		}
+		if bundlePath := normalizedTarPath(bundle.Path); bundlePath == "" || bundlePath == "." {
+			return errors.New("openclaw.bundle.path is required")
+		}
+		if !isOpenClawTarballSpec(bundle.Path) {
+			return fmt.Errorf("openclaw.bundle.path %q must be an npm .tgz", bundle.Path)
+		}
+		if !sha256LowerHexPattern.MatchString(strings.ToLower(strings.TrimSpace(bundle.SHA256))) {
+			return errors.New("openclaw.bundle.sha256 must be lowercase 64-char hex")
+		}
+	}
+	seenManifest := map[string]struct{}{}
+	for index, entry := range spec.Manifest {
+		entryPath := normalizedTarPath(entry.Path)
+		if entryPath != entry.Path || !strings.HasPrefix(entryPath, "claw/") {
+			return fmt.Errorf("manifest[%d].path %q must be a clean path under claw/", index, entry.Path)
+		}
+		if _, exists := seenManifest[entryPath]; exists {
+			return fmt.Errorf("duplicate manifest path %q", entry.Path)
+		}
+		seenManifest[entryPath] = struct{}{}
+		if !sha256LowerHexPattern.MatchString(entry.SHA256) {
+			return fmt.Errorf("manifest[%d].sha256 must be lowercase 64-char hex", index)
+		}
+	}
+	if spec.RunDefaults != nil {
+		if err := spec.RunDefaults.Validate("run_defaults"); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -211,10 +271,14 @@ func (spec runClawboxSpecV2) provisionScripts() []string {
 	return result
 }
 
-func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallbackBaseDiskPath string) (string, error) {
+func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallbackBaseDiskPath string, out io.Writer) (string, error) {
 	if !target.ClawboxV2Mode || target.ClawboxV2Spec == nil {
 		return "", nil
 	}
+	extractor, err := newClawboxExtractor(out)
+	if err != nil {
+		return "", err
+	}
 
 	spec := target.ClawboxV2Spec
 	clawDir := filepath.Join(clawsRoot, clawID)
@@ -241,20 +305,18 @@ func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallb
 
 	runDiskPath := filepath.Join(clawDir, "run.qcow2")
 	foundRunDisk := false
-
-	file, err := os.Open(target.ClawboxPath)
-	if err != nil {
-		return "", err
+	expectedFiles := map[string]string{}
+	for _, entry := range spec.Manifest {
+		expectedFiles[entry.Path] = entry.SHA256
 	}
-	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	stream, err := openClawboxStream(target.ClawboxPath)
 	if err != nil {
-		return "", fmt.Errorf("open .clawbox as gzip stream: %w", err)
+		return "", err
 	}
-	defer gzReader.Close()
+	defer stream.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	tarReader := tar.NewReader(stream)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -270,21 +332,13 @@ func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallb
 		}
 
 		if hasRunImage && name == runArchivePath {
-			if header.Typeflag != tar.TypeReg {
-				return "", fmt.Errorf("run image %s must be a regular file", name)
-			}
-			tempPath := runDiskPath + ".tmp.download"
-			_ = os.Remove(tempPath)
-			if err := writeTarRegularFileToPath(tarReader, tempPath, header.FileInfo().Mode().Perm()); err != nil {
-				_ = os.Remove(tempPath)
+			if err := extractor.checkEntry(name, header); err != nil {
 				return "", err
 			}
-			if err := verifyFileSHA256(tempPath, runImage.SHA256); err != nil {
-				_ = os.Remove(tempPath)
-				return "", err
+			if header.Typeflag != tar.TypeReg {
+				return "", fmt.Errorf("run image %s must be a regular file", name)
 			}
-			if err := os.Rename(tempPath, runDiskPath); err != nil {
-				_ = os.Remove(tempPath)
+			if err := extractor.extractDisk(tarReader, header, runDiskPath, runImage.SHA256, "run disk"); err != nil {
 				return "", err
 			}
 			foundRunDisk = true
@@ -294,6 +348,9 @@ func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallb
 		if !strings.HasPrefix(name, "claw/") {
 			continue
 		}
+		if err := extractor.checkEntry(name, header); err != nil {
+			return "", err
+		}
 		targetPath, err := safeJoinWithin(clawDir, name)
 		if err != nil {
 			return "", err
@@ -304,10 +361,34 @@ func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallb
 				return "", err
 			}
 		case tar.TypeReg:
-			if err := writeTarRegularFileToPath(tarReader, targetPath, header.FileInfo().Mode().Perm()); err != nil {
+			if len(spec.Manifest) == 0 {
+				if err := extractor.extract(tarReader, header, targetPath, header.FileInfo().Mode().Perm(), ""); err != nil {
+					return "", err
+				}
+				continue
+			}
+			expectedSHA, listed := expectedFiles[name]
+			if !listed {
+				return "", fmt.Errorf("clawbox file %s is not listed in the clawspec manifest", name)
+			}
+			hasher := sha256.New()
+			if err := extractor.extract(io.TeeReader(tarReader, hasher), header, targetPath, header.FileInfo().Mode().Perm(), ""); err != nil {
 				return "", err
 			}
+			if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expectedSHA {
+				_ = os.Remove(targetPath)
+				return "", fmt.Errorf("clawbox file %s failed manifest verification: expected sha256 %s, got %s", name, expectedSHA, actual)
+			}
+			delete(expectedFiles, name)
+		}
+	}
+	if len(expectedFiles) > 0 {
+		missing := make([]string, 0, len(expectedFiles))
+		for name := range expectedFiles {
+			missing = append(missing, name)
 		}
+		sort.Strings(missing)
+		return "", fmt.Errorf("clawbox is missing manifest files: %s", strings.Join(missing, ", "))
 	}
 
 	if foundRunDisk {
@@ -319,7 +400,7 @@ func importRunClawboxV2(target runTarget, clawID string, clawsRoot string, fallb
 	if fallbackBaseDiskPath == "" {
 		return "", errors.New("cannot initialize run.qcow2: base disk path is empty")
 	}
-	if err := copyFile(fallbackBaseDiskPath, runDiskPath); err != nil {
+	if err := diskutil.CopyFile(fallbackBaseDiskPath, runDiskPath); err != nil {
 		return "", err
 	}
 	return runDiskPath, nil
@@ -389,3 +470,214 @@ func sortedImageNames(images []runClawboxImageV2) string {
 	sort.Strings(names)
 	return strings.Join(names, ",")
 }
+
+func (a *App) exportClawboxV2(instance state.Instance, instanceDir string, outputPath string, exportName string, allowSecrets bool, compression string) error {
+	payload, err := os.ReadFile(filepath.Join(instanceDir, clawboxSpecV2Path))
+	if err != nil {
+		return err
+	}
+	spec := runClawboxSpecV2{}
+	if err := json.Unmarshal(payload, &spec); err != nil {
+		return fmt.Errorf("parse %s: %w", clawboxSpecV2Path, err)
+	}
+	if exportName != "" {
+		spec.Name = exportName
+	}
+	if strings.TrimSpace(instance.DiskKeyPath) != "" {
+		return fmt.Errorf("instance %s has an encrypted disk and cannot be exported", instance.ID)
+	}
+
+	files, findings, err := collectClawboxV2Files(filepath.Join(instanceDir, "claw"))
+	if err != nil {
+		return err
+	}
+	if len(findings) > 0 && !allowSecrets {
+		return fmt.Errorf("export blocked: detected possible secrets (%s); use --allow-secrets to override", strings.Join(findings, ", "))
+	}
+	if len(findings) > 0 {
+		fmt.Fprintf(a.errOut, "warning: exporting with possible secrets due to --allow-secrets (%s)\n", strings.Join(findings, ", "))
+	}
+	spec.Manifest = make([]runClawboxFileV2, 0, len(files))
+	for _, file := range files {
+		spec.Manifest = append(spec.Manifest, runClawboxFileV2{Path: file.Path, SHA256: file.SHA256})
+	}
+
+	runDiskPath := filepath.Join(instanceDir, "export-run.qcow2.tmp")
+	defer os.Remove(runDiskPath)
+	if err := a.copyInstanceDisk(instance, runDiskPath); err != nil {
+		return err
+	}
+	runSHA, err := diskutil.FileSHA256(runDiskPath)
+	if err != nil {
+		return err
+	}
+	images := make([]runClawboxImageV2, 0, len(spec.Images)+1)
+	for _, image := range spec.Images {
+		if !strings.EqualFold(strings.TrimSpace(image.Name), "run") {
+			images = append(images, image)
+		}
+	}
+	spec.Images = append(images, runClawboxImageV2{Name: "run", Ref: "clawbox:///run.qcow2", SHA256: runSHA})
+
+	entries := []clawboxV2ArchiveEntry{{Name: "run.qcow2", Path: runDiskPath}}
+	if packageRef := strings.TrimSpace(spec.OpenClaw.Package); strings.HasPrefix(packageRef, "clawbox:///") {
+		entries = append(entries, bundledOpenClawEntry(instanceDir, strings.TrimPrefix(packageRef, "clawbox:///")))
+	}
+	if spec.OpenClaw.Bundle != nil {
+		entries = append(entries, bundledOpenClawEntry(instanceDir, spec.OpenClaw.Bundle.Path))
+	}
+	for _, file := range files {
+		entries = append(entries, clawboxV2ArchiveEntry{Name: file.Path, Path: file.HostPath})
+	}
+	if err := spec.validate(); err != nil {
+		return fmt.Errorf("invalid %s: %w", clawboxSpecV2Path, err)
+	}
+	return writeClawboxV2Archive(outputPath, spec, entries, compression)
+}
+
+type clawboxV2File struct {
+	Path     string
+	HostPath string
+	SHA256   string
+}
+
+type clawboxV2ArchiveEntry struct {
+	Name string
+	Path string
+}
+
+func bundledOpenClawEntry(instanceDir string, archivePath string) clawboxV2ArchiveEntry {
+	name := normalizedTarPath(archivePath)
+	return clawboxV2ArchiveEntry{Name: name, Path: filepath.Join(instanceDir, openClawPackageDir, filepath.Base(filepath.FromSlash(name)))}
+}
+
+func collectClawboxV2Files(clawDir string) ([]clawboxV2File, []string, error) {
+	files := []clawboxV2File{}
+	findingsSet := map[string]struct{}{}
+	if !dirExists(clawDir) {
+		return files, nil, nil
+	}
+	err := filepath.WalkDir(clawDir, func(hostPath string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(filepath.Dir(clawDir), hostPath)
+		if err != nil {
+			return err
+		}
+		payload, err := os.ReadFile(hostPath)
+		if err != nil {
+			return err
+		}
+		for _, finding := range scanPotentialSecrets(string(payload)) {
+			findingsSet[finding] = struct{}{}
+		}
+		sum := sha256.Sum256(payload)
+		files = append(files, clawboxV2File{Path: filepath.ToSlash(relativePath), HostPath: hostPath, SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	findings := make([]string, 0, len(findingsSet))
+	for finding := range findingsSet {
+		findings = append(findings, finding)
+	}
+	sort.Strings(findings)
+	return files, findings, nil
+}
+
+func (a *App) copyInstanceDisk(instance state.Instance, destinationPath string) error {
+	if strings.TrimSpace(instance.DiskPath) == "" {
+		return fmt.Errorf("instance %s has no disk path", instance.ID)
+	}
+	suspended := false
+	if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
+		if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
+			return err
+		}
+		suspended = true
+	}
+	var copyErr error
+	if diskutil.BackingFile(instance.DiskPath) != "" {
+		copyErr = diskutil.Convert(context.Background(), instance.DiskPath, destinationPath, "qcow2", nil)
+	} else {
+		copyErr = diskutil.CopyFile(instance.DiskPath, destinationPath)
+	}
+	if suspended {
+		if err := a.backend.Resume(instanceProcess(instance)); err != nil && copyErr == nil {
+			return err
+		}
+	}
+	return copyErr
+}
+
+func writeClawboxV2Archive(outputPath string, spec runClawboxSpecV2, entries []clawboxV2ArchiveEntry, compression string) error {
+	payload, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := outputPath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	compressor, err := newClawboxWriter(file, compression)
+	if err != nil {
+		file.Close()
+		_ = os.Remove(tempPath)
+		return err
+	}
+	fail := func(err error) error {
+		compressor.Close()
+		file.Close()
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: clawboxSpecV2Path, Mode: 0o644, Size: int64(len(payload)), Typeflag: tar.TypeReg}); err != nil {
+		return fail(err)
+	}
+	if _, err := tarWriter.Write(payload); err != nil {
+		return fail(err)
+	}
+	for _, entry := range entries {
+		if err := appendFileToTar(tarWriter, entry.Name, entry.Path); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fail(err)
+	}
+	if err := compressor.Close(); err != nil {
+		file.Close()
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, outputPath)
+}
+
+func appendFileToTar(tarWriter *tar.Writer, name string, path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tarWriter, source)
+	return err
+}
@@ -0,0 +1,412 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const cloneUsage = "usage: clawfarm clone <clawid> [--checkpoint name [--overlay]] [--name prefix] [--port N] [--no-start] [--no-wait]"
+
+type cloneOptions struct {
+	Checkpoint string
+	Overlay    bool
+	Name       string
+	Port       int
+}
+
+func (a *App) runClone(args []string) error {
+	flags := flag.NewFlagSet("clone", flag.ContinueOnError)
+	flags.SetOutput(a.errOut)
+	options := cloneOptions{}
+	noStart := false
+	noWait := false
+	flags.StringVar(&options.Checkpoint, "checkpoint", "", "fork from a named checkpoint instead of the current disk")
+	flags.BoolVar(&options.Overlay, "overlay", false, "back the new disk with the checkpoint as a qcow2 overlay instead of copying it")
+	flags.StringVar(&options.Name, "name", "", "CLAWID prefix for the new instance")
+	flags.IntVar(&options.Port, "port", 0, "host gateway port (default: a free port)")
+	flags.BoolVar(&noStart, "no-start", false, "create the instance without starting it")
+	flags.BoolVar(&noWait, "no-wait", false, "do not wait for gateway readiness")
+	if err := flags.Parse(normalizeRunArgs(args)); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(cloneUsage)
+	}
+	options.Checkpoint = strings.TrimSpace(options.Checkpoint)
+	if options.Checkpoint != "" {
+		if err := validateCheckpointName(options.Checkpoint); err != nil {
+			return err
+		}
+	} else if options.Overlay {
+		return errors.New("--overlay needs --checkpoint: a running disk keeps changing under the overlay")
+	}
+	if options.Port < 0 || options.Port > 65535 {
+		return fmt.Errorf("invalid --port %d", options.Port)
+	}
+
+	sourceID := strings.TrimSpace(flags.Arg(0))
+	if strings.TrimSpace(options.Name) == "" {
+		options.Name = sourceID
+	}
+	clone, err := a.cloneInstance(sourceID, options)
+	if err != nil {
+		return err
+	}
+	source := sourceID
+	if options.Checkpoint != "" {
+		source += "@" + options.Checkpoint
+	}
+	fmt.Fprintf(a.out, "cloned %s -> %s\n", source, clone.ID)
+	if noStart {
+		fmt.Fprintf(a.out, "start it with: clawfarm start %s\n", clone.ID)
+		return nil
+	}
+	instance, err := a.startInstance(clone.ID, noWait)
+	if err != nil {
+		return err
+	}
+	return a.reportStartedInstance(instance, noWait, defaultReadyTimeoutSecs)
+}
+
+// cloneInstance creates a stopped instance whose disk starts as a copy of the
+// source's current disk or checkpoint. The clone gets its own CLAWID, host
+// ports and SSH key; the new instance id also makes cloud-init treat it as a
+// new machine on first boot, giving it a fresh hostname and SSH host keys.
+func (a *App) cloneInstance(sourceID string, options cloneOptions) (state.Instance, error) {
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	id, err := newClawID(options.Name)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	sourceDir := filepath.Join(clawsRoot, sourceID)
+	instanceDir := filepath.Join(clawsRoot, id)
+
+	var clone state.Instance
+	err = lockManager.WithInstanceLock(sourceID, func() error {
+		source, loadErr := store.Load(sourceID)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", sourceID)
+			}
+			return loadErr
+		}
+		spec, specErr := readInstanceStartSpec(sourceDir)
+		if specErr != nil {
+			if errors.Is(specErr, os.ErrNotExist) {
+				return fmt.Errorf("instance %s has no recorded start configuration to clone", sourceID)
+			}
+			return specErr
+		}
+		sourceDisk := source.DiskPath
+		if options.Checkpoint != "" {
+			sourceDisk = checkpointPathForName(clawsRoot, sourceID, options.Checkpoint)
+			if _, err := os.Stat(sourceDisk); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("checkpoint %s not found for %s", options.Checkpoint, sourceID)
+				}
+				return err
+			}
+		} else if strings.TrimSpace(sourceDisk) == "" {
+			return fmt.Errorf("instance %s has no disk path", sourceID)
+		}
+		if options.Overlay && source.DiskKeyPath != "" {
+			return fmt.Errorf("instance %s has an encrypted disk, which cannot be cloned as an overlay", sourceID)
+		}
+		if err := os.Mkdir(instanceDir, 0o755); err != nil {
+			return err
+		}
+
+		// A running source is paused so its disk and host-side state are
+		// copied at one consistent point.
+		running := source.PID > 0 && a.backend.IsRunning(source.PID)
+		if running {
+			if err := a.backend.Suspend(instanceProcess(source)); err != nil {
+				_ = os.RemoveAll(instanceDir)
+				return err
+			}
+		}
+		var cloneErr error
+		clone, cloneErr = a.writeClone(source, spec, sourceDir, sourceDisk, id, instanceDir, options)
+		if running {
+			if err := a.backend.Resume(instanceProcess(source)); err != nil && cloneErr == nil {
+				cloneErr = fmt.Errorf("resume %s: %w", sourceID, err)
+			}
+		}
+		if cloneErr != nil {
+			_ = os.RemoveAll(instanceDir)
+			_ = os.Remove(defaultDiskKeyPath(clawsRoot, id))
+			return cloneErr
+		}
+		clone.DirtyShutdown = running && options.Checkpoint == ""
+		return store.Save(clone)
+	})
+	if err != nil {
+		return state.Instance{}, err
+	}
+	a.refreshSSHConfig()
+	return clone, nil
+}
+
+func (a *App) writeClone(source state.Instance, spec vm.StartSpec, sourceDir string, sourceDisk string, id string, instanceDir string, options cloneOptions) (state.Instance, error) {
+	diskPath := filepath.Join(instanceDir, "instance.img")
+	if options.Overlay {
+		if err := diskutil.CreateOverlay(sourceDisk, diskPath); err != nil {
+			if errors.Is(err, diskutil.ErrQEMUImgMissing) {
+				return state.Instance{}, errors.New("--overlay needs qemu-img")
+			}
+			return state.Instance{}, err
+		}
+	} else if err := diskutil.CopyFile(sourceDisk, diskPath); err != nil {
+		return state.Instance{}, err
+	}
+
+	rebase := func(path string) (string, error) {
+		relative, err := filepath.Rel(sourceDir, path)
+		if path == "" || err != nil || relative == "." || strings.HasPrefix(relative, "..") {
+			return path, nil
+		}
+		target := filepath.Join(instanceDir, relative)
+		return target, copyInstancePath(path, target)
+	}
+	// The clone's disk is encrypted with the source's key; it gets its own
+	// copy so removing either instance leaves the other readable. Keys the
+	// user supplied with --disk-key-file stay shared.
+	clawsRoot := filepath.Dir(instanceDir)
+	if isManagedDiskKey(clawsRoot, spec.DiskKeyPath) || strings.HasPrefix(spec.DiskKeyPath, sourceDir+string(filepath.Separator)) {
+		keyPath := defaultDiskKeyPath(clawsRoot, id)
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+			return state.Instance{}, err
+		}
+		if err := copyInstancePath(spec.DiskKeyPath, keyPath); err != nil {
+			return state.Instance{}, err
+		}
+		spec.DiskKeyPath = keyPath
+	}
+	var err error
+	for _, field := range []*string{&spec.StatePath, &spec.ClawPath, &spec.WorkspacePath, &spec.OpenClawTarballPath} {
+		if *field, err = rebase(*field); err != nil {
+			return state.Instance{}, err
+		}
+	}
+	for index := range spec.VolumeMounts {
+		if spec.VolumeMounts[index].HostPath, err = rebase(spec.VolumeMounts[index].HostPath); err != nil {
+			return state.Instance{}, err
+		}
+	}
+	credentialPath, err := rebase(source.GatewayCredentialPath)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	if spec.WatchPath != "" {
+		spec.WatchPath = workspaceWatchDir(instanceDir)
+		if err := ensureDir(spec.WatchPath); err != nil {
+			return state.Instance{}, err
+		}
+	}
+
+	ports, err := cloneHostPorts(spec, options.Port)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	if spec.GatewayGuestPort == 0 {
+		spec.GatewayGuestPort = spec.GatewayHostPort
+	}
+	spec.GatewayHostPort = ports[spec.GatewayHostPort]
+	for index := range spec.PublishedPorts {
+		spec.PublishedPorts[index].HostPort = ports[spec.PublishedPorts[index].HostPort]
+	}
+
+	sshKeyPath := ""
+	if source.SSHKeyPath != "" {
+		keyPath, publicKey, err := generateInstanceSSHKeyPair(instanceDir)
+		if err != nil {
+			return state.Instance{}, err
+		}
+		sshKeyPath = keyPath
+		if sourceKey, err := os.ReadFile(source.SSHKeyPath + ".pub"); err == nil {
+			spec.SSHAuthorizedKeys = slices.DeleteFunc(spec.SSHAuthorizedKeys, func(key string) bool {
+				return strings.TrimSpace(key) == strings.TrimSpace(string(sourceKey))
+			})
+		}
+		spec.SSHAuthorizedKeys = append(spec.SSHAuthorizedKeys, publicKey)
+	}
+
+	spec.InstanceID = id
+	spec.InstanceDir = instanceDir
+	spec.SourceDiskPath = diskPath
+	if err := writeInstanceStartSpec(instanceDir, spec); err != nil {
+		return state.Instance{}, err
+	}
+
+	now := time.Now().UTC()
+	clone := source
+	clone.ID = id
+	clone.Status = instanceStatusStopped
+	clone.PID = 0
+	clone.WatchPID = 0
+	clone.DiskPath = diskPath
+	clone.DiskKeyPath = spec.DiskKeyPath
+	clone.StatePath = spec.StatePath
+	clone.WorkspacePath = spec.WorkspacePath
+	clone.GatewayCredentialPath = credentialPath
+	clone.Volumes = persistedVolumeMounts(spec.VolumeMounts)
+	clone.Gateways = slices.Clone(source.Gateways)
+	for index := range clone.Gateways {
+		clone.Gateways[index].HostPort = ports[clone.Gateways[index].HostPort]
+		clone.Gateways[index].Status = ""
+		clone.Gateways[index].LastError = ""
+	}
+	clone.PublishedPorts = slices.Clone(source.PublishedPorts)
+	for index := range clone.PublishedPorts {
+		clone.PublishedPorts[index].HostPort = ports[clone.PublishedPorts[index].HostPort]
+	}
+	clone.SSHHostPort = ports[source.SSHHostPort]
+	clone.SSHKeyPath = sshKeyPath
+	clone.ClonedFrom = source.ID
+	if options.Checkpoint != "" {
+		clone.ClonedFrom += "@" + options.Checkpoint
+	}
+	clone.BackingCheckpoint = ""
+	if options.Overlay {
+		clone.BackingCheckpoint = sourceDisk
+	}
+	clone.SeedISOPath = ""
+	clone.SerialLogPath = ""
+	clone.QEMULogPath = ""
+	clone.MonitorPath = ""
+	clone.QEMUAccel = ""
+	clone.QEMUCommand = nil
+	clone.BootMemoryMiB = 0
+	clone.LastError = ""
+	clone.SleepSuspended = false
+	clone.DiskUsedPercent = 0
+	clone.DiskCheckedAtUTC = time.Time{}
+	clone.CreatedAtUTC = now
+	clone.StartedAtUTC = time.Time{}
+	clone.UpdatedAtUTC = now
+	clone.WokeAtUTC = time.Time{}
+	return clone, nil
+}
+
+// cloneHostPorts maps every host port the source forwards to a free loopback
+// port, so the clone can run next to its source. gatewayPort pins the
+// primary gateway instead.
+func cloneHostPorts(spec vm.StartSpec, gatewayPort int) (map[int]int, error) {
+	ports := map[int]int{0: 0}
+	taken := map[int]bool{}
+	if gatewayPort > 0 {
+		ports[spec.GatewayHostPort] = gatewayPort
+		taken[gatewayPort] = true
+	}
+	hostPorts := []int{spec.GatewayHostPort}
+	for _, mapping := range spec.PublishedPorts {
+		hostPorts = append(hostPorts, mapping.HostPort)
+	}
+	for _, hostPort := range hostPorts {
+		if _, ok := ports[hostPort]; ok {
+			continue
+		}
+		for {
+			port, err := findAvailableLoopbackPort()
+			if err != nil {
+				return nil, err
+			}
+			if !taken[port] {
+				ports[hostPort] = port
+				taken[port] = true
+				break
+			}
+		}
+	}
+	return ports, nil
+}
+
+// copyInstancePath copies a file or directory tree owned by the source
+// instance, keeping permissions so keys and credentials stay private.
+func copyInstancePath(sourcePath string, targetPath string) error {
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return copyInstanceFile(sourcePath, targetPath, info)
+	}
+	return filepath.WalkDir(sourcePath, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		relative, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(targetPath, relative)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyInstanceFile(path, target, info)
+	})
+}
+
+func copyInstanceFile(sourcePath string, targetPath string, info fs.FileInfo) error {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(sourcePath)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, targetPath)
+	case !info.Mode().IsRegular():
+		return nil
+	}
+	if err := diskutil.CopyFile(sourcePath, targetPath); err != nil {
+		return err
+	}
+	return os.Chmod(targetPath, info.Mode().Perm())
+}
+
+// overlayClonesOf lists the instances cloned with --overlay whose disk is
+// backed by backingPath, or by any checkpoint inside it when it is an
+// instance directory. Those checkpoints must not change or disappear.
+func (a *App) overlayClonesOf(backingPath string) ([]string, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	clones := []string{}
+	for _, instance := range instances {
+		backing := instance.BackingCheckpoint
+		if backing != "" && (backing == backingPath || strings.HasPrefix(backing, backingPath+string(filepath.Separator))) {
+			clones = append(clones, instance.ID)
+		}
+	}
+	return clones, nil
+}
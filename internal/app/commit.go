@@ -0,0 +1,123 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+func (a *App) runCommit(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: clawfarm commit <clawid> <name:tag>")
+	}
+	id := strings.TrimSpace(args[0])
+	ref := strings.TrimSpace(args[1])
+	if _, err := images.ParseLocalRef(ref); err != nil {
+		return err
+	}
+
+	manager, err := a.imageManager()
+	if err != nil {
+		return err
+	}
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+
+	var committed images.Metadata
+	err = lockManager.WithInstanceLock(id, func() error {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		if strings.TrimSpace(instance.DiskPath) == "" {
+			return fmt.Errorf("instance %s has no disk path", id)
+		}
+		if instance.DiskKeyPath != "" {
+			return fmt.Errorf("instance %s has an encrypted disk; commit would store it unreadable", id)
+		}
+		if instance.RootfsMode == vm.RootfsReadOnlyOverlay {
+			return fmt.Errorf("instance %s uses a read-only rootfs; its changes are discarded and cannot be committed", id)
+		}
+
+		arch := detectImageArch(instance.ImageRef)
+		if base, resolveErr := manager.Resolve(instance.ImageRef); resolveErr == nil && base.Arch != "" {
+			arch = base.Arch
+		}
+
+		suspended := false
+		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
+			if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
+				return err
+			}
+			suspended = true
+		}
+
+		var commitErr error
+		committed, commitErr = manager.Commit(ref, instance.DiskPath, arch, "commit:"+id)
+		if suspended {
+			if resumeErr := a.backend.Resume(instanceProcess(instance)); resumeErr != nil {
+				if commitErr != nil {
+					return fmt.Errorf("%w (and failed to resume VM: %v)", commitErr, resumeErr)
+				}
+				return resumeErr
+			}
+		}
+		return commitErr
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.out, "committed %s -> %s (%s)\n", id, committed.Ref, committed.RuntimeDisk)
+	return nil
+}
+
+// imageDependents lists the instances whose disk, or one of whose checkpoints,
+// is a qcow2 overlay backed by diskPath.
+func (a *App) imageDependents(diskPath string) ([]string, error) {
+	absoluteDiskPath, err := filepath.Abs(diskPath)
+	if err != nil {
+		return nil, err
+	}
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	dependents := []string{}
+	for _, instance := range instances {
+		disks := []string{instance.DiskPath}
+		checkpoints, err := listCheckpoints(clawsRoot, instance.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, checkpoint := range checkpoints {
+			disks = append(disks, checkpoint.Path)
+		}
+		for _, disk := range disks {
+			if disk != "" && filepath.Clean(diskutil.BackingFile(disk)) == absoluteDiskPath {
+				dependents = append(dependents, instance.ID)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+var copyTargetClawIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{2,127}$`)
+
+type copyTarget struct {
+	ClawID string
+	Path   string
+}
+
+func (target copyTarget) isGuest() bool {
+	return target.ClawID != ""
+}
+
+func (a *App) runCopy(args []string) error {
+	fromStdin := false
+	positionals := make([]string, 0, len(args))
+	for _, arg := range args {
+		trimmed := strings.TrimSpace(arg)
+		switch {
+		case trimmed == "":
+			continue
+		case trimmed == "--stdin":
+			fromStdin = true
+		case strings.HasPrefix(trimmed, "--"):
+			return fmt.Errorf("unknown cp flag %q", trimmed)
+		default:
+			positionals = append(positionals, trimmed)
+		}
+	}
+
+	usage := errors.New("usage: clawfarm cp <host-path> <clawid>:/guest/path | clawfarm cp <clawid>:/guest/path <host-path> | clawfarm cp --stdin <clawid>:/guest/path")
+	if fromStdin {
+		if len(positionals) != 1 {
+			return usage
+		}
+		destination, err := parseCopyTarget(positionals[0])
+		if err != nil {
+			return err
+		}
+		if !destination.isGuest() {
+			return errors.New("cp --stdin requires a <clawid>:/guest/path destination")
+		}
+		if a.in == nil {
+			return errors.New("cp --stdin requires standard input")
+		}
+		return a.copyToGuest(a.in, destination)
+	}
+
+	if len(positionals) != 2 {
+		return usage
+	}
+	source, err := parseCopyTarget(positionals[0])
+	if err != nil {
+		return err
+	}
+	destination, err := parseCopyTarget(positionals[1])
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case source.isGuest() && destination.isGuest():
+		return errors.New("cp between two instances is not supported")
+	case !source.isGuest() && !destination.isGuest():
+		return errors.New("cp requires one side to be <clawid>:/guest/path")
+	case destination.isGuest():
+		file, err := os.Open(source.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory: cp only supports regular files", source.Path)
+		}
+		return a.copyToGuest(file, destination)
+	default:
+		return a.copyFromGuest(source, destination.Path)
+	}
+}
+
+func parseCopyTarget(input string) (copyTarget, error) {
+	trimmed := strings.TrimSpace(input)
+	if trimmed == "" {
+		return copyTarget{}, errors.New("cp path must not be empty")
+	}
+
+	separator := strings.Index(trimmed, ":")
+	if separator <= 0 || !copyTargetClawIDPattern.MatchString(trimmed[:separator]) {
+		return copyTarget{Path: trimmed}, nil
+	}
+
+	guestPath := strings.TrimSpace(trimmed[separator+1:])
+	if !path.IsAbs(guestPath) {
+		return copyTarget{}, fmt.Errorf("invalid cp target %q: guest path must be absolute", input)
+	}
+	return copyTarget{ClawID: trimmed[:separator], Path: path.Clean(guestPath)}, nil
+}
+
+func (a *App) copyToGuest(reader io.Reader, destination copyTarget) error {
+	instance, err := a.loadSSHReadyInstance(destination.ClawID)
+	if err != nil {
+		return err
+	}
+
+	upload, err := guestSudoCommand(instance, copyToGuestCommand(destination.Path))
+	if err != nil {
+		return err
+	}
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), upload)
+	command := exec.Command("ssh", args...)
+	command.Stdin = reader
+	command.Stderr = a.errOut
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("copy to %s:%s: %w", destination.ClawID, destination.Path, err)
+	}
+
+	fmt.Fprintf(a.out, "copied -> %s:%s\n", destination.ClawID, destination.Path)
+	return nil
+}
+
+func (a *App) copyFromGuest(source copyTarget, hostPath string) error {
+	instance, err := a.loadSSHReadyInstance(source.ClawID)
+	if err != nil {
+		return err
+	}
+	download, err := guestSudoCommand(instance, copyFromGuestCommand(source.Path))
+	if err != nil {
+		return err
+	}
+
+	absHostPath, err := filepath.Abs(hostPath)
+	if err != nil {
+		return err
+	}
+	if info, statErr := os.Stat(absHostPath); statErr == nil && info.IsDir() {
+		absHostPath = filepath.Join(absHostPath, path.Base(source.Path))
+	}
+	if err := ensureDir(filepath.Dir(absHostPath)); err != nil {
+		return err
+	}
+
+	temporaryPath := absHostPath + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), download)
+	command := exec.Command("ssh", args...)
+	command.Stdout = file
+	command.Stderr = a.errOut
+	runErr := command.Run()
+	closeErr := file.Close()
+	if runErr != nil {
+		_ = os.Remove(temporaryPath)
+		return fmt.Errorf("copy from %s:%s: %w", source.ClawID, source.Path, runErr)
+	}
+	if closeErr != nil {
+		_ = os.Remove(temporaryPath)
+		return closeErr
+	}
+	if err := os.Rename(temporaryPath, absHostPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+
+	fmt.Fprintf(a.out, "copied %s:%s -> %s\n", source.ClawID, source.Path, absHostPath)
+	return nil
+}
+
+// remoteShellCommand joins words into one command line for the guest shell
+// ssh hands it to, quoting each word so paths with spaces, quotes or shell
+// metacharacters reach the guest unchanged.
+func remoteShellCommand(words ...string) string {
+	quoted := make([]string, len(words))
+	for index, word := range words {
+		quoted[index] = shellSingleQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// copyToGuestCommand writes stdin to guestPath, creating its parent directory.
+// Callers run it through guestSudoCommand.
+func copyToGuestCommand(guestPath string) string {
+	script := "mkdir -p -- " + shellSingleQuote(path.Dir(guestPath)) + " && cat > " + shellSingleQuote(guestPath)
+	return remoteShellCommand("sh", "-c", script)
+}
+
+func copyFromGuestCommand(guestPath string) string {
+	return remoteShellCommand("cat", "--", guestPath)
+}
+
+func (a *App) loadSSHReadyInstance(id string) (state.Instance, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return state.Instance{}, fmt.Errorf("instance %s not found", id)
+		}
+		return state.Instance{}, err
+	}
+	if instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+		return state.Instance{}, fmt.Errorf("instance %s is not running", id)
+	}
+	if instance.SSHHostPort <= 0 || strings.TrimSpace(instance.SSHKeyPath) == "" {
+		return state.Instance{}, fmt.Errorf("instance %s has no ssh access (start it with --ssh or --run to enable ssh)", id)
+	}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return state.Instance{}, errors.New("ssh client is required")
+	}
+	return instance, nil
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const cronUsage = "usage: clawfarm cron ls|add|rm <clawid> [\"<schedule> <command>\"|<number>]"
+
+func (a *App) runCron(args []string) error {
+	if len(args) < 2 {
+		return errors.New(cronUsage)
+	}
+	id := strings.TrimSpace(args[1])
+	switch args[0] {
+	case "ls":
+		if len(args) != 2 {
+			return errors.New(cronUsage)
+		}
+		return a.listCronJobs(id)
+	case "add":
+		if len(args) < 3 {
+			return errors.New(cronUsage)
+		}
+		job := strings.Join(args[2:], " ")
+		if _, _, err := vm.SplitCronJob(job); err != nil {
+			return err
+		}
+		var number int
+		err := a.updateCronJobs(id, func(jobs []string) ([]string, error) {
+			number = len(jobs) + 1
+			return append(jobs, strings.TrimSpace(job)), nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "added cron job %d to %s\n", number, id)
+		return nil
+	case "rm":
+		if len(args) != 3 {
+			return errors.New(cronUsage)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(args[2]))
+		if err != nil {
+			return fmt.Errorf("invalid cron job number %q: see clawfarm cron ls %s", args[2], id)
+		}
+		err = a.updateCronJobs(id, func(jobs []string) ([]string, error) {
+			if number < 1 || number > len(jobs) {
+				return nil, fmt.Errorf("%s has no cron job %d", id, number)
+			}
+			return append(append([]string(nil), jobs[:number-1]...), jobs[number:]...), nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "removed cron job %d from %s\n", number, id)
+		return nil
+	default:
+		return fmt.Errorf("unknown cron subcommand %q", args[0])
+	}
+}
+
+func (a *App) listCronJobs(id string) error {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+	if len(instance.CronJobs) == 0 {
+		fmt.Fprintf(a.out, "no cron jobs for %s\n", id)
+		return nil
+	}
+	for index, job := range instance.CronJobs {
+		fmt.Fprintf(a.out, "%d\t%s\n", index+1, job)
+	}
+	return nil
+}
+
+// updateCronJobs rewrites the guest crontab of a running instance and records
+// the new job list. Jobs are only installed by cloud-init on first boot, so
+// the VM has to be up for an edit to reach the guest.
+func (a *App) updateCronJobs(id string, update func([]string) ([]string, error)) error {
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	return lockManager.WithInstanceLock(id, func() error {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		jobs, err := update(append([]string(nil), instance.CronJobs...))
+		if err != nil {
+			return err
+		}
+		if err := a.installGuestCrontab(id, jobs); err != nil {
+			return err
+		}
+
+		instanceDir := filepath.Join(clawsRoot, id)
+		if spec, specErr := readInstanceStartSpec(instanceDir); specErr == nil {
+			spec.CronJobs = jobs
+			if err := writeInstanceStartSpec(instanceDir, spec); err != nil {
+				return err
+			}
+		}
+		instance.CronJobs = jobs
+		return store.Save(instance)
+	})
+}
+
+func (a *App) installGuestCrontab(id string, jobs []string) error {
+	instance, err := a.loadSSHReadyInstance(id)
+	if err != nil {
+		return fmt.Errorf("%w; cron jobs can only be edited on a running instance", err)
+	}
+	script := "rm -f " + vm.CrontabPath
+	if len(jobs) > 0 {
+		temporaryPath := vm.CrontabPath + ".tmp"
+		script = fmt.Sprintf("cat > %s && chmod 0644 %s && mv -f %s %s", temporaryPath, temporaryPath, temporaryPath, vm.CrontabPath)
+	}
+	remoteCommand, err := guestSudoCommand(instance, "sh -c "+shellSingleQuote(script))
+	if err != nil {
+		return err
+	}
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), remoteCommand)
+	command := exec.Command("ssh", args...)
+	command.Stdin = strings.NewReader(vm.RenderCrontab(jobs))
+	command.Stderr = a.errOut
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("update crontab in %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const devcontainerFeaturePrefix = "ghcr.io/devcontainers/features/"
+
+var devcontainerLifecycleHooks = []string{"onCreateCommand", "updateContentCommand", "postCreateCommand", "postStartCommand"}
+
+var devcontainerVariablePattern = regexp.MustCompile(`\$\{([A-Za-z]+)(?::([^}:]+))?(?::([^}]*))?\}`)
+
+type devcontainerSpec struct {
+	Image             string                     `json:"image"`
+	Build             json.RawMessage            `json:"build"`
+	DockerComposeFile json.RawMessage            `json:"dockerComposeFile"`
+	Features          map[string]json.RawMessage `json:"features"`
+	ForwardPorts      []json.RawMessage          `json:"forwardPorts"`
+	ContainerEnv      map[string]string          `json:"containerEnv"`
+	RemoteEnv         map[string]string          `json:"remoteEnv"`
+	RemoteUser        string                     `json:"remoteUser"`
+	ContainerUser     string                     `json:"containerUser"`
+	HostRequirements  struct {
+		CPUs   int    `json:"cpus"`
+		Memory string `json:"memory"`
+	} `json:"hostRequirements"`
+	Lifecycle map[string]json.RawMessage `json:"-"`
+}
+
+type devcontainerPlan struct {
+	ImageRef     string
+	Workspace    string
+	RunCommands  []string
+	Publish      []string
+	CPUs         int
+	MemoryMiB    int
+	Skipped      []string
+	FeatureCount int
+}
+
+func (a *App) runDevcontainer(ctx context.Context, path string, args []string) (state.Instance, error) {
+	if hasCLIFlag(args, "--run-as") {
+		return state.Instance{}, errors.New("--run-as cannot be combined with --devcontainer: remoteUser selects the user for lifecycle commands")
+	}
+	if hasCLIFlag(args, "--guest-user") {
+		return state.Instance{}, errors.New("--guest-user cannot be combined with --devcontainer: lifecycle commands run as the claw user")
+	}
+	if hasCLIFlag(args, "--clawbox") {
+		return state.Instance{}, errors.New("--clawbox cannot be combined with --devcontainer")
+	}
+	spec, absolutePath, err := loadDevcontainer(path)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	plan, err := planDevcontainer(spec, absolutePath)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	fmt.Fprintf(a.out, "devcontainer: %s\n", absolutePath)
+	imageRef, err := a.resolveDevcontainerImage(plan.ImageRef)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	fmt.Fprintf(a.out, "  image: %s -> %s\n", spec.Image, imageRef)
+	fmt.Fprintf(a.out, "  features: %d, lifecycle and feature steps: %d\n", plan.FeatureCount, len(plan.RunCommands))
+	for _, skipped := range plan.Skipped {
+		fmt.Fprintf(a.errOut, "warning: devcontainer %s is not supported and was skipped\n", skipped)
+	}
+
+	runArgs := make([]string, 0, len(args)+2*len(plan.RunCommands)+8)
+	if !hasCLIFlag(args, "--workspace") && !hasCLIFlag(args, "--no-workspace") {
+		runArgs = append(runArgs, "--workspace", plan.Workspace)
+	}
+	if plan.CPUs > 0 && !hasCLIFlag(args, "--cpus") {
+		runArgs = append(runArgs, "--cpus", strconv.Itoa(plan.CPUs))
+	}
+	if plan.MemoryMiB > 0 && !hasCLIFlag(args, "--memory-mib") {
+		runArgs = append(runArgs, "--memory-mib", strconv.Itoa(plan.MemoryMiB))
+	}
+	for _, mapping := range plan.Publish {
+		runArgs = append(runArgs, "--publish", mapping)
+	}
+	for _, command := range plan.RunCommands {
+		runArgs = append(runArgs, "--run", command)
+	}
+	runArgs = append(runArgs, args...)
+	runArgs = append(runArgs, imageRef)
+	return a.runInstance(ctx, runArgs, 0)
+}
+
+func loadDevcontainer(path string) (devcontainerSpec, string, error) {
+	absolutePath, err := filepath.Abs(strings.TrimSpace(path))
+	if err != nil {
+		return devcontainerSpec{}, "", err
+	}
+	if info, statErr := os.Stat(absolutePath); statErr == nil && info.IsDir() {
+		absolutePath = filepath.Join(absolutePath, "devcontainer.json")
+	}
+	payload, err := os.ReadFile(absolutePath)
+	if err != nil {
+		return devcontainerSpec{}, "", err
+	}
+	cleaned := stripJSONComments(payload)
+
+	var spec devcontainerSpec
+	if err := json.Unmarshal(cleaned, &spec); err != nil {
+		return devcontainerSpec{}, "", fmt.Errorf("parse %s: %w", absolutePath, err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(cleaned, &raw); err != nil {
+		return devcontainerSpec{}, "", fmt.Errorf("parse %s: %w", absolutePath, err)
+	}
+	spec.Lifecycle = map[string]json.RawMessage{}
+	for _, hook := range devcontainerLifecycleHooks {
+		if value, ok := raw[hook]; ok {
+			spec.Lifecycle[hook] = value
+		}
+	}
+	return spec, absolutePath, nil
+}
+
+func planDevcontainer(spec devcontainerSpec, path string) (devcontainerPlan, error) {
+	if len(spec.DockerComposeFile) > 0 {
+		return devcontainerPlan{}, errors.New("devcontainer dockerComposeFile is not supported: reference a single image with \"image\"")
+	}
+	if len(spec.Build) > 0 && strings.TrimSpace(spec.Image) == "" {
+		return devcontainerPlan{}, errors.New("devcontainer build is not supported: push the built image and reference it with \"image\"")
+	}
+	if strings.TrimSpace(spec.Image) == "" {
+		return devcontainerPlan{}, fmt.Errorf("%s has no \"image\"", path)
+	}
+
+	workspace := filepath.Dir(path)
+	if filepath.Base(workspace) == ".devcontainer" {
+		workspace = filepath.Dir(workspace)
+	}
+	plan := devcontainerPlan{Workspace: workspace, CPUs: spec.HostRequirements.CPUs}
+	expand := func(value string) string {
+		return expandDevcontainerVariables(value, workspace)
+	}
+
+	image := expand(strings.TrimSpace(spec.Image))
+	plan.ImageRef = "docker://" + strings.TrimPrefix(image, "docker://")
+	if parsed, err := images.ParseOCIRef(plan.ImageRef); err != nil {
+		return devcontainerPlan{}, fmt.Errorf("devcontainer image: %w", err)
+	} else if parsed.Registry == "docker.io" && parsed.Repository == "library/ubuntu" && (parsed.Tag == "24.04" || parsed.Tag == "noble") {
+		plan.ImageRef = images.SupportedRefs()[0]
+	}
+
+	if strings.TrimSpace(spec.HostRequirements.Memory) != "" {
+		memoryMiB, err := parseDevcontainerMemory(spec.HostRequirements.Memory)
+		if err != nil {
+			return devcontainerPlan{}, err
+		}
+		plan.MemoryMiB = memoryMiB
+	}
+
+	for _, raw := range spec.ForwardPorts {
+		var port int
+		if err := json.Unmarshal(raw, &port); err != nil || port < 1 || port > 65535 {
+			plan.Skipped = append(plan.Skipped, "forwardPorts entry "+string(raw))
+			continue
+		}
+		plan.Publish = append(plan.Publish, fmt.Sprintf("%d:%d", port, port))
+	}
+
+	env := map[string]string{}
+	for key, value := range spec.ContainerEnv {
+		env[key] = expand(value)
+	}
+	for key, value := range spec.RemoteEnv {
+		env[key] = expand(value)
+	}
+	if len(env) > 0 {
+		plan.RunCommands = append(plan.RunCommands, renderDevcontainerEnvCommand(env))
+	}
+
+	featureIDs := make([]string, 0, len(spec.Features))
+	for id := range spec.Features {
+		featureIDs = append(featureIDs, id)
+	}
+	sort.Strings(featureIDs)
+	for _, id := range featureIDs {
+		options := map[string]any{}
+		_ = json.Unmarshal(spec.Features[id], &options)
+		command, ok := devcontainerFeatureCommand(id, options)
+		if !ok {
+			plan.Skipped = append(plan.Skipped, "feature "+id)
+			continue
+		}
+		plan.FeatureCount++
+		plan.RunCommands = append(plan.RunCommands, command)
+	}
+
+	remoteUser := strings.TrimSpace(spec.RemoteUser)
+	if remoteUser == "" {
+		remoteUser = strings.TrimSpace(spec.ContainerUser)
+	}
+	for _, hook := range devcontainerLifecycleHooks {
+		raw, ok := spec.Lifecycle[hook]
+		if !ok {
+			continue
+		}
+		commands, err := devcontainerLifecycleCommands(raw)
+		if err != nil {
+			return devcontainerPlan{}, fmt.Errorf("devcontainer %s: %w", hook, err)
+		}
+		for _, command := range commands {
+			plan.RunCommands = append(plan.RunCommands, wrapDevcontainerCommand(expand(command), remoteUser))
+		}
+	}
+	return plan, nil
+}
+
+func (a *App) resolveDevcontainerImage(imageRef string) (string, error) {
+	if !strings.HasPrefix(imageRef, "docker://") {
+		return imageRef, nil
+	}
+	parsed, err := images.ParseOCIRef(imageRef)
+	if err != nil {
+		return "", err
+	}
+	manager, err := a.imageManager()
+	if err != nil {
+		return "", err
+	}
+	localRef := parsed.DefaultLocalRef()
+	if meta, err := manager.Resolve(localRef); err == nil && meta.RootfsTar != "" && strings.HasPrefix(meta.Source, "docker://"+parsed.Registry+"/"+parsed.Repository+"@") {
+		fmt.Fprintf(a.out, "using imported image %s for %s\n", localRef, imageRef)
+		return localRef, nil
+	}
+	fmt.Fprintf(a.out, "importing %s\n", imageRef)
+	meta, err := manager.ImportOCI(context.Background(), images.OCIImportOptions{Source: imageRef, Ref: localRef})
+	if err != nil {
+		return "", fmt.Errorf("import devcontainer image: %w", err)
+	}
+	return meta.Ref, nil
+}
+
+func devcontainerLifecycleCommands(raw json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if strings.TrimSpace(single) == "" {
+			return nil, nil
+		}
+		return []string{single}, nil
+	}
+	var argv []string
+	if err := json.Unmarshal(raw, &argv); err == nil {
+		if len(argv) == 0 {
+			return nil, nil
+		}
+		quoted := make([]string, 0, len(argv))
+		for _, arg := range argv {
+			quoted = append(quoted, shellSingleQuote(arg))
+		}
+		return []string{strings.Join(quoted, " ")}, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, errors.New("expected a string, an array, or an object of commands")
+	}
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	commands := make([]string, 0, len(names))
+	for _, name := range names {
+		nested, err := devcontainerLifecycleCommands(named[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		commands = append(commands, nested...)
+	}
+	return commands, nil
+}
+
+// wrapDevcontainerCommand builds a --run command, and --run already insists
+// on --guest-sudo nopasswd, so the sudo here cannot prompt.
+func wrapDevcontainerCommand(command string, remoteUser string) string {
+	script := "cd /workspace 2>/dev/null || cd ~; " + command
+	if remoteUser == runAsRoot {
+		return script
+	}
+	return fmt.Sprintf("sudo -n -u %s -H bash -lc %s", runAsClaw, shellSingleQuote(script))
+}
+
+func renderDevcontainerEnvCommand(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("export %s=%s", key, shellSingleQuote(env[key])))
+	}
+	return fmt.Sprintf("printf '%%s\\n' %s >/etc/profile.d/clawfarm-devcontainer.sh", joinShellQuoted(lines))
+}
+
+func joinShellQuoted(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, shellSingleQuote(value))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func devcontainerFeatureCommand(id string, options map[string]any) (string, bool) {
+	name := strings.TrimPrefix(id, devcontainerFeaturePrefix)
+	if name == id {
+		return "", false
+	}
+	if index := strings.IndexAny(name, ":@"); index >= 0 {
+		name = name[:index]
+	}
+	version := "lts"
+	if value, ok := options["version"].(string); ok && strings.TrimSpace(value) != "" {
+		version = strings.TrimSpace(value)
+	}
+	aptInstall := func(packages ...string) string {
+		return "DEBIAN_FRONTEND=noninteractive apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends " + strings.Join(packages, " ")
+	}
+	switch name {
+	case "common-utils":
+		return aptInstall("ca-certificates", "curl", "git", "jq", "less", "sudo", "unzip", "zip", "wget"), true
+	case "git":
+		return aptInstall("git"), true
+	case "github-cli":
+		return aptInstall("gh"), true
+	case "python":
+		return aptInstall("python3", "python3-pip", "python3-venv"), true
+	case "go":
+		return aptInstall("golang-go"), true
+	case "docker-in-docker", "docker-outside-of-docker":
+		return aptInstall("docker.io") + " && usermod -aG docker " + runAsClaw, true
+	case "node":
+		major := "22"
+		if version != "lts" && version != "latest" {
+			major = strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+		}
+		if _, err := strconv.Atoi(major); err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("if ! command -v node >/dev/null 2>&1 || ! node --version | grep -q '^v%s\\.'; then curl -fsSL https://deb.nodesource.com/setup_%s.x | bash - && %s; fi", major, major, aptInstall("nodejs")), true
+	default:
+		return "", false
+	}
+}
+
+func expandDevcontainerVariables(value string, workspace string) string {
+	return devcontainerVariablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		parts := devcontainerVariablePattern.FindStringSubmatch(match)
+		switch parts[1] {
+		case "containerWorkspaceFolder":
+			return "/workspace"
+		case "containerWorkspaceFolderBasename":
+			return "workspace"
+		case "localWorkspaceFolder":
+			return workspace
+		case "localWorkspaceFolderBasename":
+			return filepath.Base(workspace)
+		case "localEnv", "env":
+			if current, ok := os.LookupEnv(parts[2]); ok {
+				return current
+			}
+			return parts[3]
+		default:
+			return match
+		}
+	})
+}
+
+func parseDevcontainerMemory(value string) (int, error) {
+	trimmed := strings.ToLower(strings.TrimSpace(value))
+	units := []struct {
+		suffix string
+		mib    float64
+	}{{"tb", 1024 * 1024}, {"gb", 1024}, {"mb", 1}, {"kb", 1.0 / 1024}}
+	for _, unit := range units {
+		if number, found := strings.CutSuffix(trimmed, unit.suffix); found {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil || parsed <= 0 {
+				break
+			}
+			return int(parsed * unit.mib), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid devcontainer hostRequirements.memory %q: expected e.g. 4gb", value)
+}
+
+func stripJSONComments(payload []byte) []byte {
+	output := make([]byte, 0, len(payload))
+	inString := false
+	for index := 0; index < len(payload); index++ {
+		current := payload[index]
+		if inString {
+			output = append(output, current)
+			if current == '\\' && index+1 < len(payload) {
+				index++
+				output = append(output, payload[index])
+			} else if current == '"' {
+				inString = false
+			}
+			continue
+		}
+		if current == '/' && index+1 < len(payload) && payload[index+1] == '/' {
+			for index < len(payload) && payload[index] != '\n' {
+				index++
+			}
+			if index < len(payload) {
+				output = append(output, '\n')
+			}
+			continue
+		}
+		if current == '/' && index+1 < len(payload) && payload[index+1] == '*' {
+			index += 2
+			for index+1 < len(payload) && !(payload[index] == '*' && payload[index+1] == '/') {
+				index++
+			}
+			index++
+			continue
+		}
+		if current == '"' {
+			inString = true
+		}
+		if current == '}' || current == ']' {
+			trimmed := len(output)
+			for trimmed > 0 && strings.ContainsRune(" \t\r\n", rune(output[trimmed-1])) {
+				trimmed--
+			}
+			if trimmed > 0 && output[trimmed-1] == ',' {
+				output = append(output[:trimmed-1], output[trimmed:]...)
+			}
+		}
+		output = append(output, current)
+	}
+	return output
+}
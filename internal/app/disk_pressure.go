@@ -0,0 +1,141 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	instanceStatusDiskPressure = "disk-pressure"
+	guestDiskCheckInterval     = time.Minute
+	guestDiskCheckTimeout      = 5 * time.Second
+	instanceEventsFileName     = "events.jsonl"
+)
+
+type instanceEvent struct {
+	Type    string    `json:"type"`
+	ClawID  string    `json:"clawid"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// checkGuestDiskPressure samples the guest rootfs usage over SSH at most once
+// per guestDiskCheckInterval and returns the disk-pressure message when it is
+// above the threshold. Between samples, and when a sample fails, the previous
+// verdict stands.
+func (a *App) checkGuestDiskPressure(instance *state.Instance) (string, bool) {
+	previous := ""
+	if instance.Status == instanceStatusDiskPressure {
+		previous = instance.LastError
+	}
+	if instance.SSHHostPort <= 0 || strings.TrimSpace(instance.SSHKeyPath) == "" || time.Since(instance.DiskCheckedAtUTC) < guestDiskCheckInterval {
+		return previous, false
+	}
+	threshold, err := config.DiskPressurePercent()
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: %v\n", err)
+		return previous, false
+	}
+
+	instance.DiskCheckedAtUTC = time.Now().UTC()
+	usedPercent, availableBytes, err := guestRootfsUsage(*instance)
+	if err != nil {
+		return previous, true
+	}
+	instance.DiskUsedPercent = usedPercent
+	if usedPercent < threshold {
+		return "", true
+	}
+	message := fmt.Sprintf("guest rootfs %d%% full (%s free); free space or grow it with clawfarm resize %s --disk-size", usedPercent, diskutil.HumanBytes(availableBytes), instance.ID)
+	if previous == "" {
+		a.emitInstanceEvent(instanceEvent{Type: instanceStatusDiskPressure, ClawID: instance.ID, Message: message, Time: instance.DiskCheckedAtUTC})
+	}
+	return message, true
+}
+
+func guestRootfsUsage(instance state.Instance) (int, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), guestDiskCheckTimeout)
+	defer cancel()
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), "df -P -k /")
+	output, err := exec.CommandContext(ctx, "ssh", args...).Output()
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseDFOutput(string(output))
+}
+
+// parseDFOutput reads the capacity and available space from POSIX df -P -k
+// output.
+func parseDFOutput(output string) (int, int64, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 5 {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	availableKiB, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	usedPercent, err := strconv.Atoi(strings.TrimSuffix(fields[4], "%"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	return usedPercent, availableKiB * 1024, nil
+}
+
+// emitInstanceEvent appends the event to the instance's events.jsonl and
+// POSTs it to $CLAWFARM_EVENT_WEBHOOK when set. Failures only warn, so an
+// unreachable webhook never breaks ps.
+func (a *App) emitInstanceEvent(event instanceEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if _, clawsRoot, err := a.instanceStore(); err == nil {
+		if err := appendInstanceEvent(filepath.Join(clawsRoot, event.ClawID, instanceEventsFileName), payload); err != nil {
+			fmt.Fprintf(a.errOut, "warning: record %s event for %s: %v\n", event.Type, event.ClawID, err)
+		}
+	}
+	webhook := config.EventWebhook()
+	if webhook == "" {
+		return
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err == nil {
+		_ = response.Body.Close()
+		if response.StatusCode >= 300 {
+			err = errors.New(response.Status)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: deliver %s event for %s: %v\n", event.Type, event.ClawID, err)
+	}
+}
+
+func appendInstanceEvent(path string, payload []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(payload, '\n'))
+	return err
+}
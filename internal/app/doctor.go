@@ -0,0 +1,132 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+func (a *App) runDoctor(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: clawfarm doctor")
+	}
+
+	checks := collectDoctorChecks(a.dirs)
+	failures := 0
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+	for _, check := range checks {
+		if check.Status == doctorFail {
+			failures++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, check.Status, check.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("doctor found %d problem(s)", failures)
+	}
+	return nil
+}
+
+func collectDoctorChecks(dirs config.DirOverrides) []doctorCheck {
+	checks := []doctorCheck{}
+
+	qemuPath := ""
+	qemuName, err := vm.QEMUSystemBinary(runtime.GOARCH)
+	if err != nil {
+		checks = append(checks, doctorCheck{Name: "qemu", Status: doctorFail, Detail: err.Error()})
+	} else if path, lookErr := exec.LookPath(qemuName); lookErr != nil {
+		checks = append(checks, doctorCheck{Name: "qemu", Status: doctorFail, Detail: qemuName + " not found in PATH"})
+	} else {
+		qemuPath = path
+		checks = append(checks, doctorCheck{Name: "qemu", Status: doctorOK, Detail: path})
+	}
+
+	checks = append(checks, lookPathCheck("qemu-img", doctorWarn, "needed for disk format detection and --encrypt-disk"))
+	if runtime.GOOS == "linux" {
+		if err := vm.KVMStatus(); err != nil {
+			checks = append(checks, doctorCheck{Name: "kvm", Status: doctorWarn, Detail: err.Error() + "; guests will run under slow tcg emulation"})
+		} else {
+			checks = append(checks, doctorCheck{Name: "kvm", Status: doctorOK, Detail: "hardware acceleration available"})
+		}
+	}
+	checks = append(checks, lookPathCheck("ssh-keygen", doctorWarn, "needed for --run"))
+
+	if qemuPath != "" {
+		if err := vm.CheckQEMUSandbox(qemuPath); err != nil {
+			checks = append(checks, doctorCheck{Name: "sandbox", Status: doctorWarn, Detail: err.Error() + "; --hardened will fail"})
+		} else {
+			checks = append(checks, doctorCheck{Name: "sandbox", Status: doctorOK, Detail: "qemu seccomp sandbox available for --hardened"})
+		}
+	}
+
+	checks = append(checks, qemuUserCheck(config.QEMUUser()))
+	checks = append(checks, hostResourcesCheck(dirs))
+	return checks
+}
+
+func lookPathCheck(name string, missingStatus string, purpose string) doctorCheck {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return doctorCheck{Name: name, Status: missingStatus, Detail: "not found in PATH: " + purpose}
+	}
+	return doctorCheck{Name: name, Status: doctorOK, Detail: path}
+}
+
+func hostResourcesCheck(dirs config.DirOverrides) doctorCheck {
+	dataDir, err := dirs.ResolveDataDir()
+	if err != nil {
+		return doctorCheck{Name: "resources", Status: doctorWarn, Detail: err.Error()}
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return doctorCheck{Name: "resources", Status: doctorWarn, Detail: err.Error()}
+	}
+	resources, err := vm.ProbeHostResources(dataDir)
+	if err != nil {
+		return doctorCheck{Name: "resources", Status: doctorWarn, Detail: err.Error()}
+	}
+	detail := fmt.Sprintf("%d MiB memory available, %d MiB free under %s", resources.AvailableMemoryMiB, resources.FreeDiskMiB, dataDir)
+	if shortfall := resources.Shortfall(vm.ResourceRequest{MemoryMiB: defaultMemoryMiB, DiskPath: dataDir}); shortfall != nil {
+		return doctorCheck{Name: "resources", Status: doctorWarn, Detail: fmt.Sprintf("default --memory-mib %d will not fit: %v", defaultMemoryMiB, shortfall)}
+	}
+	return doctorCheck{Name: "resources", Status: doctorOK, Detail: detail}
+}
+
+func qemuUserCheck(name string) doctorCheck {
+	if name == "" {
+		return doctorCheck{Name: "qemu-user", Status: doctorWarn, Detail: "CLAWFARM_QEMU_USER not set; --hardened keeps QEMU under the invoking user"}
+	}
+	account, err := user.Lookup(name)
+	if err != nil {
+		return doctorCheck{Name: "qemu-user", Status: doctorFail, Detail: fmt.Sprintf("CLAWFARM_QEMU_USER %s: %v", name, err)}
+	}
+	if account.Uid == "0" {
+		return doctorCheck{Name: "qemu-user", Status: doctorFail, Detail: fmt.Sprintf("CLAWFARM_QEMU_USER %s is root", name)}
+	}
+	if os.Geteuid() != 0 {
+		return doctorCheck{Name: "qemu-user", Status: doctorWarn, Detail: fmt.Sprintf("QEMU can only drop to %s when clawfarm runs as root", name)}
+	}
+	return doctorCheck{Name: "qemu-user", Status: doctorOK, Detail: fmt.Sprintf("QEMU drops privileges to %s (uid %s)", name, account.Uid)}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	driftUsage          = "usage: clawfarm drift [--clawid <clawid>]"
+	guestOpenClawConfig = "/etc/clawfarm/openclaw.json"
+)
+
+type configDifference struct {
+	Path     string
+	Launched string
+	Current  string
+}
+
+func (a *App) runDrift(args []string) error {
+	clawIDs := map[string]bool{}
+	for index := 0; index < len(args); index++ {
+		name, value, hasValue := strings.Cut(args[index], "=")
+		if name != "--clawid" {
+			return errors.New(driftUsage)
+		}
+		if !hasValue {
+			if index+1 >= len(args) {
+				return errors.New("--clawid requires a value")
+			}
+			index++
+			value = args[index]
+		}
+		clawIDs[strings.TrimSpace(value)] = true
+	}
+
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return err
+	}
+	for id := range clawIDs {
+		if _, err := store.Load(id); err != nil {
+			if errors.Is(err, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return err
+		}
+	}
+
+	if len(instances) == 0 {
+		fmt.Fprintln(a.out, "no instances")
+		return nil
+	}
+
+	checked, drifted := 0, 0
+	for _, instance := range instances {
+		if len(clawIDs) > 0 && !clawIDs[instance.ID] {
+			continue
+		}
+		spec, err := readInstanceStartSpec(filepath.Join(clawsRoot, instance.ID))
+		if err != nil {
+			fmt.Fprintf(a.out, "%s: skipped (no recorded launch config)\n", instance.ID)
+			continue
+		}
+		current, err := a.fetchGuestOpenClawConfig(instance.ID)
+		if err != nil {
+			fmt.Fprintf(a.out, "%s: skipped (%v)\n", instance.ID, err)
+			continue
+		}
+		checked++
+		differences := diffOpenClawConfigs(vm.EffectiveOpenClawConfig(spec.OpenClawConfig, spec.GatewayGuestPort), current)
+		if len(differences) == 0 {
+			fmt.Fprintf(a.out, "%s: in sync\n", instance.ID)
+			continue
+		}
+		drifted++
+		noun := "differences"
+		if len(differences) == 1 {
+			noun = "difference"
+		}
+		fmt.Fprintf(a.out, "%s: %d %s\n", instance.ID, len(differences), noun)
+		for _, difference := range differences {
+			switch {
+			case difference.Launched == "":
+				fmt.Fprintf(a.out, "  + %s: %s\n", difference.Path, difference.Current)
+			case difference.Current == "":
+				fmt.Fprintf(a.out, "  - %s: %s\n", difference.Path, difference.Launched)
+			default:
+				fmt.Fprintf(a.out, "  ~ %s: %s -> %s\n", difference.Path, difference.Launched, difference.Current)
+			}
+		}
+	}
+	fmt.Fprintf(a.out, "%d of %d checked instances drifted from their launch config\n", drifted, checked)
+	return nil
+}
+
+func (a *App) fetchGuestOpenClawConfig(id string) (string, error) {
+	instance, err := a.loadSSHReadyInstance(id)
+	if err != nil {
+		return "", err
+	}
+	readConfig, err := guestSudoCommand(instance, "cat "+guestOpenClawConfig)
+	if err != nil {
+		return "", err
+	}
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), readConfig)
+	output, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", guestOpenClawConfig, err)
+	}
+	return string(output), nil
+}
+
+// diffOpenClawConfigs compares two OpenClaw configs key by key. Configs that
+// are not JSON objects are compared as a whole.
+func diffOpenClawConfigs(launched string, current string) []configDifference {
+	launchedValues, launchedErr := flattenConfigJSON(launched)
+	currentValues, currentErr := flattenConfigJSON(current)
+	if launchedErr != nil || currentErr != nil {
+		if strings.TrimSpace(launched) == strings.TrimSpace(current) {
+			return nil
+		}
+		return []configDifference{{Path: "(file)", Launched: "<launch config>", Current: "<edited config>"}}
+	}
+
+	paths := map[string]struct{}{}
+	for path := range launchedValues {
+		paths[path] = struct{}{}
+	}
+	for path := range currentValues {
+		paths[path] = struct{}{}
+	}
+	differences := []configDifference{}
+	for path := range paths {
+		if launchedValues[path] != currentValues[path] {
+			differences = append(differences, configDifference{Path: path, Launched: launchedValues[path], Current: currentValues[path]})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool { return differences[i].Path < differences[j].Path })
+	return differences
+}
+
+func flattenConfigJSON(payload string) (map[string]string, error) {
+	var root map[string]any
+	if err := json.Unmarshal([]byte(payload), &root); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	var walk func(prefix string, value any)
+	walk = func(prefix string, value any) {
+		if object, ok := value.(map[string]any); ok && (prefix == "" || len(object) > 0) {
+			for key, child := range object {
+				path := key
+				if prefix != "" {
+					path = prefix + "." + key
+				}
+				walk(path, child)
+			}
+			return
+		}
+		encoded, _ := json.Marshal(value)
+		values[prefix] = string(encoded)
+	}
+	walk("", root)
+	return values, nil
+}
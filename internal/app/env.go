@@ -0,0 +1,271 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const answersDirName = "answers"
+
+type envTemplateEntry struct {
+	Key      string
+	Label    string
+	Required bool
+}
+
+func (a *App) runEnv(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: clawfarm env template <file.clawbox|.>")
+	}
+	switch args[0] {
+	case "template":
+		if len(args) != 2 {
+			return errors.New("usage: clawfarm env template <file.clawbox|.>")
+		}
+		return a.runEnvTemplate(strings.TrimSpace(args[1]))
+	default:
+		return fmt.Errorf("unknown env subcommand %q", args[0])
+	}
+}
+
+func (a *App) runEnvTemplate(input string) error {
+	target, err := a.resolveRunTarget(input, true)
+	if err != nil {
+		return err
+	}
+	entries, err := clawboxEnvTemplateEntries(target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.out, "# OpenClaw environment for %s\n", input)
+	fmt.Fprintf(a.out, "# fill in every required value, then: clawfarm run %s --openclaw-env-file .env\n", input)
+	fmt.Fprintln(a.out, "# values marked (secret) are credentials; keep this file out of version control")
+
+	for _, required := range []bool{true, false} {
+		section := "optional"
+		if required {
+			section = "required"
+		}
+		printed := false
+		for _, entry := range entries {
+			if entry.Required != required {
+				continue
+			}
+			if !printed {
+				fmt.Fprintln(a.out, "")
+				fmt.Fprintf(a.out, "# --- %s ---\n", section)
+				printed = true
+			}
+			comment := entry.Label
+			if isSecretOpenClawEnvKey(entry.Key) {
+				comment += " (secret)"
+			}
+			fmt.Fprintf(a.out, "# %s\n", comment)
+			if required {
+				fmt.Fprintf(a.out, "%s=\n", entry.Key)
+			} else {
+				fmt.Fprintf(a.out, "# %s=\n", entry.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func clawboxEnvTemplateEntries(target runTarget) ([]envTemplateEntry, error) {
+	required := make([]string, 0, len(target.OpenClawRequiredEnv)+2)
+	labels := map[string]string{}
+
+	if modelPrimary := strings.TrimSpace(target.OpenClawModelPrimary); modelPrimary != "" {
+		envKey, label, err := providerEnvRequirementForModel(modelPrimary)
+		if err != nil {
+			return nil, err
+		}
+		if envKey != "" {
+			required = append(required, envKey)
+			labels[envKey] = label
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(target.OpenClawGatewayAuthMode)) {
+	case "token":
+		required = append(required, "OPENCLAW_GATEWAY_TOKEN")
+	case "password":
+		required = append(required, "OPENCLAW_GATEWAY_PASSWORD")
+	}
+	required = normalizeRequiredEnvKeys(append(required, target.OpenClawRequiredEnv...))
+
+	entries := make([]envTemplateEntry, 0, len(required)+len(target.OpenClawOptionalEnv))
+	seen := map[string]struct{}{}
+	for _, key := range required {
+		seen[key] = struct{}{}
+		label := labels[key]
+		if label == "" {
+			label = requiredOpenClawEnvLabel(key)
+		}
+		entries = append(entries, envTemplateEntry{Key: key, Label: label, Required: true})
+	}
+	for _, key := range normalizeRequiredEnvKeys(target.OpenClawOptionalEnv) {
+		if _, exists := seen[key]; exists {
+			continue
+		}
+		entries = append(entries, envTemplateEntry{Key: key, Label: requiredOpenClawEnvLabel(key), Required: false})
+	}
+	return entries, nil
+}
+
+func requiredOpenClawEnvKeys(openClawConfig string, requiredEnv []string) ([]string, error) {
+	requirements, err := parseOpenClawRuntimeRequirements(openClawConfig)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(requiredEnv)+2)
+	if modelPrimary := strings.TrimSpace(requirements.ModelPrimary); modelPrimary != "" {
+		envKey, _, err := providerEnvRequirementForModel(modelPrimary)
+		if err != nil {
+			return nil, err
+		}
+		if envKey != "" {
+			keys = append(keys, envKey)
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(requirements.GatewayAuthMode)) {
+	case "token":
+		keys = append(keys, "OPENCLAW_GATEWAY_TOKEN")
+	case "password":
+		keys = append(keys, "OPENCLAW_GATEWAY_PASSWORD")
+	}
+	return normalizeRequiredEnvKeys(append(keys, requiredEnv...)), nil
+}
+
+// ignoreRequiredEnv drops the clawbox required_env keys the user chose to run
+// without and that are still unset, so preflight warns instead of failing.
+// It returns the dropped keys for the instance record.
+func (a *App) ignoreRequiredEnv(target *runTarget, openClawEnv map[string]string, keys []string, all bool) ([]string, error) {
+	declared := normalizeRequiredEnvKeys(target.OpenClawRequiredEnv)
+	declaredSet := map[string]bool{}
+	for _, key := range declared {
+		declaredSet[key] = true
+	}
+	ignore := map[string]bool{}
+	for _, key := range normalizeRequiredEnvKeys(keys) {
+		if !declaredSet[key] {
+			return nil, fmt.Errorf("--ignore-required-env %s: %s does not declare it in required_env", key, target.Input)
+		}
+		ignore[key] = true
+	}
+	if !all && len(ignore) == 0 {
+		return nil, nil
+	}
+
+	kept := make([]string, 0, len(declared))
+	ignored := make([]string, 0)
+	for _, key := range declared {
+		if (!all && !ignore[key]) || strings.TrimSpace(openClawEnv[key]) != "" {
+			kept = append(kept, key)
+			continue
+		}
+		if value, found := a.lookupKeychainSecret(openClawEnvInput(key, requiredOpenClawEnvLabel(key))); found {
+			openClawEnv[key] = value
+			kept = append(kept, key)
+			continue
+		}
+		ignored = append(ignored, key)
+	}
+	target.OpenClawRequiredEnv = kept
+	if len(ignored) > 0 {
+		fmt.Fprintf(a.errOut, "warning: running %s without required env %s\n", target.Input, strings.Join(ignored, ", "))
+	}
+	return ignored, nil
+}
+
+func (a *App) validateOpenClawEnvFile(path string, fileKeys []string, openClawEnv map[string]string, required []string, target runTarget) error {
+	missing := make([]string, 0)
+	for _, key := range required {
+		if strings.TrimSpace(openClawEnv[key]) == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	known := map[string]struct{}{}
+	for _, key := range append(append([]string(nil), required...), normalizeRequiredEnvKeys(target.OpenClawOptionalEnv)...) {
+		known[key] = struct{}{}
+	}
+	extra := make([]string, 0)
+	for _, key := range fileKeys {
+		upper := strings.ToUpper(key)
+		if _, exists := known[upper]; exists || requiredFlagForEnvKey(upper) != "--openclaw-env" {
+			continue
+		}
+		extra = append(extra, key)
+	}
+	sort.Strings(extra)
+
+	extraNotes := make([]string, 0, len(extra))
+	for _, key := range extra {
+		if suggestion := closestEnvKey(key, missing); suggestion != "" {
+			extraNotes = append(extraNotes, fmt.Sprintf("%s (did you mean %s?)", key, suggestion))
+			continue
+		}
+		extraNotes = append(extraNotes, key)
+	}
+
+	if len(missing) == 0 {
+		declaresEnv := len(target.OpenClawRequiredEnv) > 0 || len(target.OpenClawOptionalEnv) > 0
+		if declaresEnv && len(extraNotes) > 0 {
+			fmt.Fprintf(a.errOut, "warning: --openclaw-env-file %s sets keys the clawbox does not declare: %s\n", path, strings.Join(extraNotes, ", "))
+		}
+		return nil
+	}
+	message := fmt.Sprintf("--openclaw-env-file %s is missing required keys: %s", path, strings.Join(missing, ", "))
+	if len(extraNotes) > 0 {
+		message += fmt.Sprintf("; undeclared keys: %s", strings.Join(extraNotes, ", "))
+	}
+	return errors.New(message)
+}
+
+func closestEnvKey(key string, candidates []string) string {
+	upper := strings.ToUpper(key)
+	best := ""
+	bestDistance := 3
+	for _, candidate := range candidates {
+		distance := editDistance(upper, candidate)
+		if distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+func editDistance(left string, right string) int {
+	previous := make([]int, len(right)+1)
+	for index := range previous {
+		previous[index] = index
+	}
+	for i := 1; i <= len(left); i++ {
+		current := make([]int, len(right)+1)
+		current[0] = i
+		for j := 1; j <= len(right); j++ {
+			cost := 1
+			if left[i-1] == right[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(right)]
+}
+
+func (a *App) answerStore() (*state.AnswerStore, error) {
+	clawsRoot, err := a.clawsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return state.NewAnswerStore(filepath.Join(filepath.Dir(clawsRoot), answersDirName)), nil
+}
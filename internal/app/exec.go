@@ -0,0 +1,114 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const execUsage = "usage: clawfarm exec [--user root|claw] [--workdir dir] [--env KEY=VALUE] <clawid> -- <command...>"
+
+var execEnvNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type execOptions struct {
+	User    string
+	Workdir string
+	Env     []string
+}
+
+func (a *App) runExec(args []string) error {
+	options := execOptions{User: runAsClaw}
+	id := ""
+	command := []string{}
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if id != "" {
+			if arg == "--" && len(command) == 0 {
+				continue
+			}
+			command = append(command, args[index:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-u", "--user", "-w", "--workdir", "-e", "--env":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", name)
+				}
+				index++
+				value = args[index]
+			}
+			if err := options.set(name, value); err != nil {
+				return err
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return errors.New(execUsage)
+			}
+			id = arg
+		}
+	}
+	if id == "" || len(command) == 0 {
+		return errors.New(execUsage)
+	}
+
+	instance, err := a.loadSSHReadyInstance(id)
+	if err != nil {
+		return err
+	}
+	remoteCommand, err := execRemoteCommand(instance, options, command)
+	if err != nil {
+		return err
+	}
+	sshArgs := sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath)
+	sshArgs = append(sshArgs, "-T", sshDestination(instance.SSHUser()), remoteCommand)
+	return a.runSSHSession(id, sshArgs)
+}
+
+func (o *execOptions) set(name string, value string) error {
+	switch name {
+	case "-u", "--user":
+		user, err := normalizeRunAs(value)
+		if err != nil {
+			return fmt.Errorf("invalid --user %q: expected root or claw", value)
+		}
+		o.User = user
+	case "-w", "--workdir":
+		o.Workdir = strings.TrimSpace(value)
+		if o.Workdir == "" {
+			return errors.New("--workdir requires a value")
+		}
+	default:
+		key, _, ok := strings.Cut(value, "=")
+		if !ok || !execEnvNamePattern.MatchString(key) {
+			return fmt.Errorf("invalid --env %q: expected KEY=VALUE", value)
+		}
+		o.Env = append(o.Env, value)
+	}
+	return nil
+}
+
+func execRemoteCommand(instance state.Instance, options execOptions, command []string) (string, error) {
+	quoted := make([]string, 0, len(options.Env)+len(command)+1)
+	if len(options.Env) > 0 {
+		quoted = append(quoted, "env")
+		for _, entry := range options.Env {
+			quoted = append(quoted, shellSingleQuote(entry))
+		}
+	}
+	for _, arg := range command {
+		quoted = append(quoted, shellSingleQuote(arg))
+	}
+	script := "exec " + strings.Join(quoted, " ")
+	if options.Workdir != "" {
+		script = "cd " + shellSingleQuote(options.Workdir) + " && " + script
+	}
+	if options.User == runAsRoot {
+		return guestSudoCommand(instance, "bash -lc "+shellSingleQuote(script))
+	}
+	return "bash -lc " + shellSingleQuote(script), nil
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/fetch"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const preparationLockPollInterval = 200 * time.Millisecond
+
+// applyDownloadLimits configures the shared bandwidth limit and per-host
+// download cap for the duration of one command.
+func applyDownloadLimits(settings config.DownloadSettings) (func(), error) {
+	bandwidth, err := settings.ResolveLimit()
+	if err != nil {
+		return nil, err
+	}
+	perHost, err := config.DownloadsPerHost()
+	if err != nil {
+		return nil, err
+	}
+	restoreBandwidth := fetch.SetBandwidthLimit(bandwidth)
+	restoreHosts := fetch.SetHostConcurrency(perHost)
+	return func() {
+		restoreHosts()
+		restoreBandwidth()
+	}, nil
+}
+
+func ensureSpecArtifacts(ctx context.Context, root string, artifacts []runArtifact, out io.Writer) ([]string, error) {
+	paths := make([]string, len(artifacts))
+	checksums := make([]string, len(artifacts))
+	pending := make([]int, 0, len(artifacts))
+	for index, artifact := range artifacts {
+		path, checksum, cached, err := checkSpecArtifact(root, artifact, out)
+		if err != nil {
+			return nil, err
+		}
+		paths[index] = path
+		checksums[index] = checksum
+		if !cached {
+			pending = append(pending, index)
+		}
+	}
+	if len(pending) == 0 {
+		return paths, nil
+	}
+
+	labels := make([]string, len(pending))
+	for slot, index := range pending {
+		labels[slot] = strings.TrimSpace(artifacts[index].Label)
+		if labels[slot] == "" {
+			labels[slot] = "artifact"
+		}
+	}
+	board := newDownloadProgressBoard(out, labels)
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var waitGroup sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for slot, index := range pending {
+		waitGroup.Add(1)
+		go func(slot int, index int) {
+			defer waitGroup.Done()
+			err := prepareSpecArtifact(fetchCtx, artifacts[index], paths[index], checksums[index], labels[slot], board, slot)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(slot, index)
+	}
+	waitGroup.Wait()
+	board.finish()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return paths, nil
+}
+
+func prepareSpecArtifact(ctx context.Context, artifact runArtifact, path string, checksum string, label string, board *downloadProgressBoard, slot int) error {
+	handle, err := acquirePreparationLock(ctx, path+".lock", func() {
+		board.printf("waiting for another clawfarm process preparing %s\n", label)
+	})
+	if err != nil {
+		return fmt.Errorf("lock %s: %w", label, err)
+	}
+	defer handle.Unlock()
+
+	if fileExistsAndNonEmpty(path) && diskutil.VerifySHA256(path, checksum) == nil {
+		board.printf("using %s prepared by another run %s\n", label, path)
+		return nil
+	}
+	_ = os.Remove(path + ".tmp.download")
+	return downloadSpecArtifact(ctx, artifact, path, checksum, board.reporter(slot))
+}
+
+func acquirePreparationLock(ctx context.Context, lockPath string, onWait func()) (state.LockHandle, error) {
+	locker := state.NewFlockLocker()
+	waited := false
+	for {
+		handle, ok, err := locker.TryLock(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return handle, nil
+		}
+		if !waited && onWait != nil {
+			onWait()
+		}
+		waited = true
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(preparationLockPollInterval):
+		}
+	}
+}
+
+type downloadProgressBoard struct {
+	mu         sync.Mutex
+	out        io.Writer
+	labels     []string
+	downloaded []int64
+	totals     []int64
+	lastRender time.Time
+}
+
+func newDownloadProgressBoard(out io.Writer, labels []string) *downloadProgressBoard {
+	return &downloadProgressBoard{
+		out:        out,
+		labels:     labels,
+		downloaded: make([]int64, len(labels)),
+		totals:     make([]int64, len(labels)),
+	}
+}
+
+func (b *downloadProgressBoard) reporter(slot int) func(downloaded int64, total int64) {
+	if b.out == nil {
+		return nil
+	}
+	return func(downloaded int64, total int64) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.downloaded[slot] = downloaded
+		b.totals[slot] = total
+		if !b.lastRender.IsZero() && time.Since(b.lastRender) < 120*time.Millisecond {
+			return
+		}
+		b.lastRender = time.Now()
+		b.render()
+	}
+}
+
+func (b *downloadProgressBoard) printf(format string, args ...any) {
+	if b.out == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprintf(b.out, format, args...)
+}
+
+func (b *downloadProgressBoard) finish() {
+	if b.out == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lastRender.IsZero() {
+		return
+	}
+	b.render()
+	fmt.Fprintln(b.out)
+}
+
+func (b *downloadProgressBoard) render() {
+	if len(b.labels) == 1 {
+		fetch.RenderProgress(b.out, b.labels[0], b.downloaded[0], b.totals[0])
+		return
+	}
+
+	var downloaded int64
+	var total int64
+	for slot := range b.labels {
+		downloaded += b.downloaded[slot]
+		if total >= 0 && b.totals[slot] > 0 {
+			total += b.totals[slot]
+		} else {
+			total = -1
+		}
+	}
+	fetch.RenderProgress(b.out, fmt.Sprintf("%d files", len(b.labels)), downloaded, total)
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+type gatewayList struct {
+	Values   []string
+	Gateways []state.Gateway
+}
+
+func (l *gatewayList) String() string {
+	return strings.Join(l.Values, ",")
+}
+
+func (l *gatewayList) Set(value string) error {
+	gateway, err := parseGatewayMapping(value)
+	if err != nil {
+		return err
+	}
+	for _, existing := range l.Gateways {
+		if existing.Name == gateway.Name {
+			return fmt.Errorf("duplicate --gateway name %q", gateway.Name)
+		}
+	}
+	l.Values = append(l.Values, value)
+	l.Gateways = append(l.Gateways, gateway)
+	return nil
+}
+
+func parseGatewayMapping(input string) (state.Gateway, error) {
+	trimmed := strings.TrimSpace(input)
+	invalid := fmt.Errorf("invalid --gateway value %q: expected name=host:guest[/path]", input)
+	name, mapping, found := strings.Cut(trimmed, "=")
+	if !found {
+		return state.Gateway{}, invalid
+	}
+	name = strings.TrimSpace(name)
+	if !runNamePattern.MatchString(name) || name == state.PrimaryGatewayName {
+		return state.Gateway{}, fmt.Errorf("invalid --gateway name %q: use lowercase letters, digits, and '-' (and not %q)", name, state.PrimaryGatewayName)
+	}
+	path := "/"
+	if index := strings.Index(mapping, "/"); index >= 0 {
+		path = mapping[index:]
+		mapping = mapping[:index]
+	}
+	hostValue, guestValue, found := strings.Cut(mapping, ":")
+	if !found {
+		return state.Gateway{}, invalid
+	}
+	hostPort, hostErr := strconv.Atoi(strings.TrimSpace(hostValue))
+	guestPort, guestErr := strconv.Atoi(strings.TrimSpace(guestValue))
+	if hostErr != nil || guestErr != nil {
+		return state.Gateway{}, invalid
+	}
+	if hostPort < 1 || hostPort > 65535 || guestPort < 1 || guestPort > 65535 {
+		return state.Gateway{}, fmt.Errorf("invalid --gateway value %q: ports must be within 1-65535", input)
+	}
+	return state.Gateway{Name: name, HostPort: hostPort, GuestPort: guestPort, Path: path}, nil
+}
+
+func gatewayURL(gateway state.Gateway) string {
+	path := gateway.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d%s", gateway.HostPort, path)
+}
+
+func formatGatewayColumn(instance state.Instance) string {
+	column := fmt.Sprintf("127.0.0.1:%d", instance.GatewayPort())
+	for _, gateway := range extraGateways(instance) {
+		column += fmt.Sprintf(",%s=%d", gateway.Name, gateway.HostPort)
+		if gateway.Status != "" && gateway.Status != "ready" {
+			column += "(" + gateway.Status + ")"
+		}
+	}
+	return column
+}
+
+func extraGateways(instance state.Instance) []state.Gateway {
+	if len(instance.Gateways) <= 1 {
+		return nil
+	}
+	return instance.Gateways[1:]
+}
+
+func waitForExtraGateways(ctx context.Context, instance *state.Instance) error {
+	for index := 1; index < len(instance.Gateways); index++ {
+		gateway := &instance.Gateways[index]
+		url := gatewayURL(*gateway)
+		if err := vm.WaitForHTTP(ctx, url); err != nil {
+			gateway.Status = "unhealthy"
+			gateway.LastError = err.Error()
+			return fmt.Errorf("gateway %s is not reachable yet at %s (%v); check %s", gateway.Name, url, err, instance.SerialLogPath)
+		}
+		gateway.Status = "ready"
+		gateway.LastError = ""
+	}
+	return nil
+}
+
+func reconcileExtraGateways(instance *state.Instance, timeout time.Duration) (string, bool) {
+	changed := false
+	firstError := ""
+	for index := 1; index < len(instance.Gateways); index++ {
+		gateway := &instance.Gateways[index]
+		status := "ready"
+		lastError := ""
+		if !vm.IsHTTPReachable(gatewayURL(*gateway), timeout) {
+			status = "unhealthy"
+			lastError = fmt.Sprintf("gateway %s is unreachable at %s", gateway.Name, gatewayURL(*gateway))
+			if firstError == "" {
+				firstError = lastError
+			}
+		}
+		if gateway.Status != status || gateway.LastError != lastError {
+			gateway.Status = status
+			gateway.LastError = lastError
+			changed = true
+		}
+	}
+	return firstError, changed
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type actionsRunReport struct {
+	started           time.Time
+	phases            map[string]time.Duration
+	previousBootPhase func(phase string)
+	clawID            string
+	imageRef          string
+	gatewayURL        string
+	sshHostPort       int
+	sshUser           string
+}
+
+func githubActionsEnabled() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("GITHUB_ACTIONS")), "true")
+}
+
+func (a *App) actionsGroup(title string) func() {
+	if !githubActionsEnabled() {
+		return func() {}
+	}
+	fmt.Fprintf(a.out, "::group::%s\n", strings.ReplaceAll(title, "\n", " "))
+	return func() {
+		fmt.Fprintln(a.out, "::endgroup::")
+	}
+}
+
+func (a *App) beginActionsRun() *actionsRunReport {
+	if !githubActionsEnabled() {
+		return nil
+	}
+	report := &actionsRunReport{started: time.Now(), phases: map[string]time.Duration{}, previousBootPhase: a.bootPhase}
+	a.bootPhase = func(phase string) {
+		if _, seen := report.phases[phase]; !seen {
+			report.phases[phase] = time.Since(report.started)
+		}
+		if report.previousBootPhase != nil {
+			report.previousBootPhase(phase)
+		}
+	}
+	return report
+}
+
+func (r *actionsRunReport) setInstance(clawID string, imageRef string, gatewayPort int, sshHostPort int, sshUser string) {
+	if r == nil {
+		return
+	}
+	r.clawID = clawID
+	r.imageRef = imageRef
+	r.gatewayURL = fmt.Sprintf("http://127.0.0.1:%d/", gatewayPort)
+	r.sshHostPort = sshHostPort
+	r.sshUser = sshUser
+}
+
+func (a *App) finishActionsRun(report *actionsRunReport, runErr error) {
+	if report == nil {
+		return
+	}
+	a.bootPhase = report.previousBootPhase
+
+	if outputPath := strings.TrimSpace(os.Getenv("GITHUB_OUTPUT")); outputPath != "" && report.clawID != "" {
+		var outputs strings.Builder
+		fmt.Fprintf(&outputs, "clawid=%s\n", report.clawID)
+		fmt.Fprintf(&outputs, "gateway-url=%s\n", report.gatewayURL)
+		if report.sshHostPort > 0 {
+			fmt.Fprintf(&outputs, "ssh-port=%d\n", report.sshHostPort)
+		}
+		if err := appendFile(outputPath, outputs.String()); err != nil {
+			fmt.Fprintf(a.errOut, "warning: write GITHUB_OUTPUT: %v\n", err)
+		}
+	}
+	if summaryPath := strings.TrimSpace(os.Getenv("GITHUB_STEP_SUMMARY")); summaryPath != "" {
+		if err := appendFile(summaryPath, report.summary(runErr)); err != nil {
+			fmt.Fprintf(a.errOut, "warning: write GITHUB_STEP_SUMMARY: %v\n", err)
+		}
+	}
+	if runErr != nil {
+		fmt.Fprintf(a.out, "::error title=clawfarm run::%s\n", strings.ReplaceAll(runErr.Error(), "\n", "%0A"))
+	}
+}
+
+func (r *actionsRunReport) summary(runErr error) string {
+	var summary strings.Builder
+	title := r.clawID
+	if title == "" {
+		title = r.imageRef
+	}
+	if title == "" {
+		title = "(not started)"
+	}
+	fmt.Fprintf(&summary, "### clawfarm run: %s\n\n", title)
+	summary.WriteString("| | |\n|---|---|\n")
+	if r.clawID != "" {
+		fmt.Fprintf(&summary, "| CLAWID | `%s` |\n", r.clawID)
+		fmt.Fprintf(&summary, "| Image | `%s` |\n", r.imageRef)
+		fmt.Fprintf(&summary, "| Gateway | %s |\n", r.gatewayURL)
+	}
+	if r.sshHostPort > 0 {
+		fmt.Fprintf(&summary, "| SSH | `%s@127.0.0.1:%d` |\n", r.sshUser, r.sshHostPort)
+	}
+	if runErr != nil {
+		fmt.Fprintf(&summary, "| Result | :x: %s |\n", strings.ReplaceAll(strings.ReplaceAll(runErr.Error(), "|", "\\|"), "\n", " "))
+	} else {
+		summary.WriteString("| Result | :white_check_mark: ok |\n")
+	}
+	fmt.Fprintf(&summary, "| Total | %s |\n", formatBenchDuration(time.Since(r.started)))
+
+	observed := false
+	for _, phase := range bootPhases {
+		duration, ok := r.phases[phase]
+		if !ok {
+			continue
+		}
+		if !observed {
+			summary.WriteString("\n| Boot phase | Time since start |\n|---|---|\n")
+			observed = true
+		}
+		fmt.Fprintf(&summary, "| %s | %s |\n", phase, formatBenchDuration(duration))
+	}
+	summary.WriteString("\n")
+	return summary.String()
+}
+
+func appendFile(path string, content string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+// guestPortFor maps a forwarded host port to the guest port behind it. Any
+// other port is taken to be a guest port already.
+func guestPortFor(instance state.Instance, port int) int {
+	for _, gateway := range instance.Gateways {
+		if gateway.HostPort == port {
+			return gateway.GuestPort
+		}
+	}
+	for _, mapping := range instance.PublishedPorts {
+		if mapping.HostPort == port {
+			return mapping.GuestPort
+		}
+	}
+	return port
+}
+
+// guestPortProbe succeeds once something accepts connections on port inside
+// the guest. Dialing the forwarded host port cannot tell: QEMU user
+// networking accepts the host side before any guest service listens.
+func guestPortProbe(port int) string {
+	return "timeout 2 bash -c " + shellSingleQuote(fmt.Sprintf("</dev/tcp/127.0.0.1/%d", port))
+}
+
+func guestPortListening(instance state.Instance, port int) error {
+	if instance.SSHHostPort <= 0 || strings.TrimSpace(instance.SSHKeyPath) == "" {
+		return fmt.Errorf("instance %s has no ssh access", instance.ID)
+	}
+	return runSSHProbeWithCommand(instance.SSHHostPort, instance.SSHKeyPath, instance.SSHUser(), guestPortProbe(port))
+}
+
+func waitForGuestPort(ctx context.Context, instance state.Instance, port int) error {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		if lastErr = guestPortListening(instance, port); lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timeout waiting for guest port %d (last error: %v)", port, lastErr)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
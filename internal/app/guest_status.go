@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	guestStatusFileName    = "status.json"
+	installStatusFileName  = "clawfarm-install.status"
+	installPhaseDone       = "done"
+	installPhaseFailed     = "failed"
+	installExcerptMaxLines = 20
+	guestTaskMaxRunes      = 48
+)
+
+type installProgress struct {
+	Phase     string    `json:"phase"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type guestStatus struct {
+	Task       string    `json:"task"`
+	Model      string    `json:"model"`
+	TokensUsed int64     `json:"tokens_used"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func readGuestStatus(instance state.Instance) (guestStatus, bool) {
+	if strings.TrimSpace(instance.StatePath) == "" {
+		return guestStatus{}, false
+	}
+	payload, err := os.ReadFile(filepath.Join(instance.StatePath, guestStatusFileName))
+	if err != nil {
+		return guestStatus{}, false
+	}
+	var status guestStatus
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return guestStatus{}, false
+	}
+	return status, true
+}
+
+func (status guestStatus) columns() []string {
+	task := printableGuestText(status.Task)
+	if runes := []rune(task); len(runes) > guestTaskMaxRunes {
+		task = string(runes[:guestTaskMaxRunes-3]) + "..."
+	}
+	values := []string{printableGuestText(status.Model), task, "", ""}
+	if status.TokensUsed > 0 {
+		values[2] = strconv.FormatInt(status.TokensUsed, 10)
+	}
+	if !status.UpdatedAt.IsZero() {
+		values[3] = status.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	for index, value := range values {
+		if value == "" {
+			values[index] = "-"
+		}
+	}
+	return values
+}
+
+// printableGuestText makes a string the guest wrote safe for a terminal:
+// control and format characters, which could carry escape sequences or
+// reorder the line, become spaces and whitespace runs fold into one space.
+func printableGuestText(value string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return ' '
+		}
+		return r
+	}, value)
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+func readInstallProgress(instance state.Instance) (installProgress, bool) {
+	if strings.TrimSpace(instance.StatePath) == "" {
+		return installProgress{}, false
+	}
+	path := filepath.Join(instance.StatePath, installStatusFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return installProgress{}, false
+	}
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return installProgress{}, false
+	}
+	phase, excerpt, _ := strings.Cut(string(payload), "\n")
+	phase = strings.TrimSpace(phase)
+	if phase == "" {
+		return installProgress{}, false
+	}
+	progress := installProgress{Phase: phase, UpdatedAt: info.ModTime().UTC()}
+	if phase == installPhaseFailed {
+		progress.Error = strings.TrimSpace(tailLines(strings.TrimSpace(excerpt)+"\n", installExcerptMaxLines))
+	}
+	return progress, true
+}
+
+func installFailedError(instance state.Instance) string {
+	if progress, ok := readInstallProgress(instance); ok && progress.Error != "" {
+		lines := strings.Split(progress.Error, "\n")
+		return printableGuestText(lines[len(lines)-1])
+	}
+	return fmt.Sprintf("OpenClaw install failed; see clawfarm logs %s --source install", instance.ID)
+}
+
+func psStatusColumns(instance state.Instance) (string, string) {
+	status := instance.Status
+	lastError := strings.ReplaceAll(instance.LastError, "\n", " ")
+	switch status {
+	case "booting", instanceStatusInstalling, "unhealthy":
+		progress, ok := readInstallProgress(instance)
+		if !ok || progress.Phase == installPhaseDone {
+			break
+		}
+		if progress.Phase != installPhaseFailed {
+			status += " (install: " + printableGuestText(progress.Phase) + ")"
+			break
+		}
+		status += " (install failed)"
+		if lastError == "" {
+			lastError = installFailedError(instance)
+		}
+	}
+	if lastError == "" {
+		lastError = "-"
+	}
+	return status, lastError
+}
@@ -0,0 +1,21 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+// guestSudoCommand prefixes command with a non-interactive sudo so it runs as
+// root in the guest. Over ssh there is no one to type a password, so only
+// guests created with --guest-sudo nopasswd can do this; instances from
+// before the policy was recorded had no other option.
+func guestSudoCommand(instance state.Instance, command string) (string, error) {
+	switch instance.GuestSudo {
+	case "", vm.GuestSudoNoPassword:
+		return "sudo -n " + command, nil
+	default:
+		return "", fmt.Errorf("%s needs root in the guest, but %s was created with --guest-sudo %s; recreate it with --guest-sudo %s", instance.ID, instance.SSHUser(), instance.GuestSudo, vm.GuestSudoNoPassword)
+	}
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var sshPublicKeyPrefixes = []string{"ssh-", "ecdsa-", "sk-ssh-", "sk-ecdsa-"}
+
+func loadGuestAuthorizedKeys(keyFiles []string, fromAgent bool) ([]string, error) {
+	keys := []string{}
+	for _, path := range keyFiles {
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read --ssh-authorized-key %s: %w", path, err)
+		}
+		parsed := parseAuthorizedKeys(string(payload))
+		if len(parsed) == 0 {
+			return nil, fmt.Errorf("--ssh-authorized-key %s contains no public keys", path)
+		}
+		keys = append(keys, parsed...)
+	}
+	if fromAgent {
+		agentKeys, err := sshAgentPublicKeys()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, agentKeys...)
+	}
+	return dedupeStrings(keys), nil
+}
+
+func sshAgentPublicKeys() ([]string, error) {
+	if strings.TrimSpace(os.Getenv("SSH_AUTH_SOCK")) == "" {
+		return nil, errors.New("--ssh-agent-keys needs a running ssh-agent (SSH_AUTH_SOCK is not set)")
+	}
+	output, err := exec.Command("ssh-add", "-L").Output()
+	keys := parseAuthorizedKeys(string(output))
+	if err != nil || len(keys) == 0 {
+		return nil, errors.New("--ssh-agent-keys: the ssh-agent has no identities (add one with ssh-add)")
+	}
+	return keys, nil
+}
+
+func parseAuthorizedKeys(content string) []string {
+	keys := []string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range sshPublicKeyPrefixes {
+			if strings.HasPrefix(line, prefix) && strings.Contains(line, " ") {
+				keys = append(keys, line)
+				break
+			}
+		}
+	}
+	return keys
+}
+
+func dedupeStrings(values []string) []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
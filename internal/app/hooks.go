@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	hookPreStart  = "pre-start"
+	hookPostReady = "post-ready"
+)
+
+type hookContext struct {
+	ClawID        string
+	InstanceDir   string
+	ImageRef      string
+	WorkspacePath string
+	GatewayPort   int
+	SSHHostPort   int
+	PID           int
+}
+
+func resolveHookCommands(flagValues []string, configDefault string) []string {
+	commands := normalizeProvisionCommands(flagValues)
+	if len(commands) > 0 {
+		return commands
+	}
+	if strings.TrimSpace(configDefault) == "" {
+		return nil
+	}
+	return []string{strings.TrimSpace(configDefault)}
+}
+
+func (a *App) runHooks(ctx context.Context, stage string, commands []string, hook hookContext) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	env := append([]string{}, os.Environ()...)
+	env = append(env,
+		"CLAWFARM_HOOK="+stage,
+		"CLAWFARM_CLAWID="+hook.ClawID,
+		"CLAWFARM_INSTANCE_DIR="+hook.InstanceDir,
+		"CLAWFARM_IMAGE="+hook.ImageRef,
+		"CLAWFARM_WORKSPACE="+hook.WorkspacePath,
+		"CLAWFARM_GATEWAY_PORT="+strconv.Itoa(hook.GatewayPort),
+		fmt.Sprintf("CLAWFARM_GATEWAY_URL=http://127.0.0.1:%d/", hook.GatewayPort),
+	)
+	if hook.SSHHostPort > 0 {
+		env = append(env, "CLAWFARM_SSH_PORT="+strconv.Itoa(hook.SSHHostPort))
+	}
+	if hook.PID > 0 {
+		env = append(env, "CLAWFARM_VM_PID="+strconv.Itoa(hook.PID))
+	}
+
+	for index, command := range commands {
+		fmt.Fprintf(a.out, "%s hook[%d/%d]: %s\n", stage, index+1, len(commands), command)
+		proc := exec.CommandContext(ctx, "sh", "-lc", command)
+		proc.Dir = hook.InstanceDir
+		proc.Env = env
+		proc.Stdout = a.out
+		proc.Stderr = a.errOut
+		if err := proc.Run(); err != nil {
+			return fmt.Errorf("%s hook %d failed: %w", stage, index+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	defaultRequireTimeoutSecs = 60
+	hostRequirementInterval   = time.Second
+	hostRequirementCmdTimeout = 10 * time.Second
+)
+
+type hostRequirement struct {
+	Label   string
+	Address string
+	Command string
+}
+
+type hostRequirementList struct {
+	Values       []string
+	Requirements []hostRequirement
+	kind         string
+}
+
+func (l *hostRequirementList) String() string {
+	return strings.Join(l.Values, ",")
+}
+
+func (l *hostRequirementList) Set(value string) error {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return fmt.Errorf("invalid require-host-%s value: must not be empty", l.kind)
+	}
+	requirement := hostRequirement{Label: fmt.Sprintf("cmd %q", trimmed), Command: trimmed}
+	if l.kind == "port" {
+		parsed, err := parseHostRequirementAddress(trimmed)
+		if err != nil {
+			return err
+		}
+		requirement = hostRequirement{Label: "port " + parsed, Address: parsed}
+	}
+	l.Values = append(l.Values, value)
+	l.Requirements = append(l.Requirements, requirement)
+	return nil
+}
+
+func parseHostRequirementAddress(input string) (string, error) {
+	host := "127.0.0.1"
+	portValue := input
+	if strings.Contains(input, ":") {
+		splitHost, splitPort, err := net.SplitHostPort(input)
+		if err != nil {
+			return "", fmt.Errorf("invalid require-host-port value %q: expected <port> or <host>:<port>", input)
+		}
+		host = splitHost
+		portValue = splitPort
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil || port < 1 || port > 65535 || strings.TrimSpace(host) == "" {
+		return "", fmt.Errorf("invalid require-host-port value %q: expected <port> or <host>:<port>", input)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+func (a *App) checkHostRequirements(requirements []hostRequirement, timeout time.Duration) error {
+	if len(requirements) == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	pending := requirements
+	failures := map[string]string{}
+	announced := false
+	for {
+		remaining := make([]hostRequirement, 0, len(pending))
+		for _, requirement := range pending {
+			if err := checkHostRequirement(requirement); err != nil {
+				failures[requirement.Label] = err.Error()
+				remaining = append(remaining, requirement)
+			}
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+		pending = remaining
+		if !time.Now().Add(hostRequirementInterval).Before(deadline) {
+			break
+		}
+		if !announced {
+			labels := make([]string, 0, len(pending))
+			for _, requirement := range pending {
+				labels = append(labels, requirement.Label)
+			}
+			fmt.Fprintf(a.out, "waiting up to %s for host requirements: %s\n", timeout, strings.Join(labels, ", "))
+			announced = true
+		}
+		time.Sleep(hostRequirementInterval)
+	}
+
+	messages := make([]string, 0, len(pending))
+	for _, requirement := range pending {
+		messages = append(messages, fmt.Sprintf("%s: %s", requirement.Label, failures[requirement.Label]))
+	}
+	return fmt.Errorf("host requirements not met after %s (raise --require-timeout-secs to wait longer):\n  %s", timeout, strings.Join(messages, "\n  "))
+}
+
+func checkHostRequirement(requirement hostRequirement) error {
+	if requirement.Address != "" {
+		if vm.IsTCPReachable(requirement.Address, time.Second) {
+			return nil
+		}
+		return fmt.Errorf("nothing is listening on %s", requirement.Address)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hostRequirementCmdTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "sh", "-c", requirement.Command).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", hostRequirementCmdTimeout)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Errorf("%v (%s)", err, last)
+	}
+	return err
+}
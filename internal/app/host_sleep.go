@@ -0,0 +1,182 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	hostUsage              = "usage: clawfarm host watch [--interval 5s] | host sleep | host wake"
+	hostWatchInterval      = 5 * time.Second
+	hostSleepDetectMinimum = 15 * time.Second
+)
+
+func (a *App) runHost(args []string) error {
+	if len(args) == 0 {
+		return errors.New(hostUsage)
+	}
+	switch args[0] {
+	case "sleep":
+		if len(args) != 1 {
+			return errors.New(hostUsage)
+		}
+		return a.suspendForHostSleep()
+	case "wake":
+		if len(args) != 1 {
+			return errors.New(hostUsage)
+		}
+		return a.recoverFromHostSleep(0)
+	case "watch":
+		interval := hostWatchInterval
+		for index := 1; index < len(args); index++ {
+			name, value, hasValue := strings.Cut(args[index], "=")
+			if name != "--interval" {
+				return errors.New(hostUsage)
+			}
+			if !hasValue {
+				if index+1 >= len(args) {
+					return errors.New("--interval requires a value")
+				}
+				index++
+				value = args[index]
+			}
+			parsed, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil || parsed <= 0 {
+				return fmt.Errorf("invalid --interval %q", value)
+			}
+			interval = parsed
+		}
+		return a.watchHostSleep(interval)
+	default:
+		return fmt.Errorf("unknown host subcommand %q", args[0])
+	}
+}
+
+// watchHostSleep polls for host wake-ups. The monotonic clock stops while the
+// host sleeps and the wall clock does not, so a gap between the two means the
+// host was asleep for that long.
+func (a *App) watchHostSleep(interval time.Duration) error {
+	threshold := 3 * interval
+	if threshold < hostSleepDetectMinimum {
+		threshold = hostSleepDetectMinimum
+	}
+	fmt.Fprintf(a.out, "watching for host sleep every %s\n", interval)
+	last := time.Now()
+	for {
+		time.Sleep(interval)
+		now := time.Now()
+		slept := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+		last = now
+		if slept < threshold {
+			continue
+		}
+		if err := a.recoverFromHostSleep(slept); err != nil {
+			fmt.Fprintf(a.errOut, "recover from host sleep: %v\n", err)
+		}
+	}
+}
+
+// suspendForHostSleep pauses every running instance before the host sleeps,
+// for use from a sleep hook such as sleepwatcher or systemd-sleep. Instances
+// suspended by the user are left alone so wake does not resume them.
+func (a *App) suspendForHostSleep() error {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if instance.PID <= 0 || instance.Status == "suspended" || instance.Status == "rescue" || !a.backend.IsRunning(instance.PID) {
+			continue
+		}
+		if err := a.backend.Suspend(instanceProcess(instance)); err != nil {
+			fmt.Fprintf(a.errOut, "warning: cannot suspend %s for host sleep: %v\n", instance.ID, err)
+			continue
+		}
+		instance.Status = "suspended"
+		instance.SleepSuspended = true
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if err := store.Save(instance); err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "%s -> suspended for host sleep\n", instance.ID)
+	}
+	return nil
+}
+
+// recoverFromHostSleep resumes instances suspended for host sleep, steps guest
+// clocks back to host time and gives every gateway a fresh grace period
+// before ps reports it unhealthy.
+func (a *App) recoverFromHostSleep(slept time.Duration) error {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return err
+	}
+	if slept > 0 {
+		fmt.Fprintf(a.out, "host woke after sleeping about %s\n", slept.Round(time.Second))
+	}
+	for _, instance := range instances {
+		if instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+			continue
+		}
+		if instance.Status == "suspended" && !instance.SleepSuspended {
+			continue
+		}
+		if instance.SleepSuspended {
+			if err := a.backend.Resume(instanceProcess(instance)); err != nil {
+				fmt.Fprintf(a.errOut, "warning: cannot resume %s after host sleep: %v\n", instance.ID, err)
+				continue
+			}
+			instance.SleepSuspended = false
+			instance.Status = "running"
+		}
+		if instance.Status == "unhealthy" {
+			instance.Status = "running"
+			instance.LastError = ""
+		}
+		clock := "clock resynced"
+		if err := a.syncGuestClock(instance); err != nil {
+			clock = fmt.Sprintf("clock not resynced: %v", err)
+		}
+		now := time.Now().UTC()
+		instance.WokeAtUTC = now
+		instance.UpdatedAtUTC = now
+		if err := store.Save(instance); err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "%s -> woke (%s)\n", instance.ID, clock)
+	}
+	return nil
+}
+
+func (a *App) syncGuestClock(instance state.Instance) error {
+	if instance.SSHHostPort <= 0 || strings.TrimSpace(instance.SSHKeyPath) == "" {
+		return errors.New("no ssh access")
+	}
+	script := "date -u -s @" + strconv.FormatInt(time.Now().Unix(), 10) + " >/dev/null && (hwclock --systohc >/dev/null 2>&1 || true)"
+	setClock, err := guestSudoCommand(instance, "sh -c "+shellSingleQuote(script))
+	if err != nil {
+		return err
+	}
+	args := append(sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath), "-T", sshDestination(instance.SSHUser()), setClock)
+	if output, err := exec.Command("ssh", args...).CombinedOutput(); err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return errors.New(message)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/yazhou/krunclaw/internal/state"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const ideUsage = "usage: clawfarm ide <clawid> [--editor vscode|cursor|jetbrains] [--print]"
+
+var ideEditorBinaries = map[string]string{
+	"vscode": "code",
+	"cursor": "cursor",
+}
+
+func (a *App) runIDE(args []string) error {
+	editor := "vscode"
+	printOnly := false
+	id := ""
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--editor":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return errors.New("--editor requires a value")
+				}
+				index++
+				value = args[index]
+			}
+			editor = strings.ToLower(strings.TrimSpace(value))
+		case "--print":
+			printOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") || id != "" {
+				return errors.New(ideUsage)
+			}
+			id = strings.TrimSpace(arg)
+		}
+	}
+	if id == "" {
+		return errors.New(ideUsage)
+	}
+	if _, ok := ideEditorBinaries[editor]; !ok && editor != "jetbrains" {
+		return fmt.Errorf("unsupported --editor %q: expected vscode, cursor, or jetbrains", editor)
+	}
+
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+	if instance.SSHHostPort <= 0 || !fileExistsAndNonEmpty(instance.SSHKeyPath) {
+		return fmt.Errorf("instance %s has no SSH forward; start it with `clawfarm run ... --ssh`", id)
+	}
+
+	configPath, err := a.writeSSHConfig()
+	if err != nil {
+		return err
+	}
+	added, err := ensureSSHConfigInclude(configPath)
+	if err != nil {
+		return err
+	}
+	alias := sshHostAlias(id)
+	if added {
+		fmt.Fprintf(a.out, "added `Include %s` to ~/.ssh/config\n", configPath)
+	}
+	fmt.Fprintf(a.out, "ssh host: %s (%s:%d)\n", alias, sshDestination(instance.SSHUser()), instance.SSHHostPort)
+
+	if editor == "jetbrains" {
+		gatewayURL := jetbrainsGatewayURL(instance.SSHHostPort, instance.SSHUser())
+		fmt.Fprintf(a.out, "open in JetBrains Gateway: %s\n", gatewayURL)
+		fmt.Fprintf(a.out, "  (or add an SSH connection to host %s, project /workspace)\n", alias)
+		if printOnly {
+			return nil
+		}
+		return openURL(gatewayURL)
+	}
+
+	binary := ideEditorBinaries[editor]
+	editorArgs := []string{"--remote", "ssh-remote+" + alias, "/workspace"}
+	if printOnly {
+		fmt.Fprintf(a.out, "%s %s\n", binary, strings.Join(editorArgs, " "))
+		return nil
+	}
+	binaryPath, err := exec.LookPath(binary)
+	if err != nil {
+		return fmt.Errorf("%s not found in PATH; install the %s shell command or run: %s %s", binary, editor, binary, strings.Join(editorArgs, " "))
+	}
+	command := exec.Command(binaryPath, editorArgs...)
+	command.Stdout = a.out
+	command.Stderr = a.errOut
+	return command.Run()
+}
+
+func jetbrainsGatewayURL(sshHostPort int, sshUser string) string {
+	params := url.Values{}
+	params.Set("type", "ssh")
+	params.Set("deploy", "false")
+	params.Set("host", "127.0.0.1")
+	params.Set("port", strconv.Itoa(sshHostPort))
+	params.Set("user", sshUser)
+	params.Set("projectPath", "/workspace")
+	return "jetbrains-gateway://connect#" + params.Encode()
+}
+
+func openURL(target string) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	}
+	openerPath, err := exec.LookPath(opener)
+	if err != nil {
+		return fmt.Errorf("%s not found; open the URL above manually", opener)
+	}
+	return exec.Command(openerPath, target).Run()
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/images"
+)
+
+const imageImportOCIUsage = "usage: clawfarm image import-oci <docker://image[:tag]> [--tag name:tag] [--base ubuntu:24.04]"
+
+func (a *App) runImageImportOCI(manager *images.Manager, args []string) error {
+	options := images.OCIImportOptions{}
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--tag", "--base":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", name)
+				}
+				index++
+				value = args[index]
+			}
+			if name == "--tag" {
+				options.Ref = value
+			} else {
+				options.BaseRef = value
+			}
+		default:
+			if strings.HasPrefix(arg, "-") || options.Source != "" {
+				return errors.New(imageImportOCIUsage)
+			}
+			options.Source = arg
+		}
+	}
+	if options.Source == "" {
+		return errors.New(imageImportOCIUsage)
+	}
+	if options.Ref != "" {
+		if _, err := images.ParseLocalRef(options.Ref); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(a.out, "importing %s\n", options.Source)
+	meta, err := manager.ImportOCI(context.Background(), options)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "imported image %s\n", meta.Ref)
+	fmt.Fprintf(a.out, "  source: %s\n", meta.Source)
+	fmt.Fprintf(a.out, "  base:   %s (kernel and init)\n", meta.Base)
+	fmt.Fprintf(a.out, "  rootfs: %s\n", meta.RootfsTar)
+	fmt.Fprintf(a.out, "run it with: clawfarm run %s\n", meta.Ref)
+	return nil
+}
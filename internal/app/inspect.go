@@ -0,0 +1,150 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+)
+
+type instanceInspection struct {
+	state.Instance
+	Lock        state.LockState   `json:"lock"`
+	Mounts      []mountState      `json:"mounts"`
+	Guest       *guestStatus      `json:"guest,omitempty"`
+	Install     *installProgress  `json:"install,omitempty"`
+	Checkpoints []checkpointState `json:"checkpoints,omitempty"`
+	ClawboxSpec json.RawMessage   `json:"clawbox_spec,omitempty"`
+}
+
+type checkpointState struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	SizeBytes    int64     `json:"size_bytes"`
+	WithMemory   bool      `json:"with_memory,omitempty"`
+	CreatedAtUTC time.Time `json:"created_at_utc"`
+}
+
+type mountState struct {
+	Name       string `json:"name"`
+	HostPath   string `json:"host_path"`
+	GuestPath  string `json:"guest_path"`
+	HostExists bool   `json:"host_exists"`
+}
+
+func parseOutputFormat(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case outputFormatTable:
+		return outputFormatTable, nil
+	case outputFormatJSON:
+		return outputFormatJSON, nil
+	}
+	return "", fmt.Errorf("invalid --format %q: expected table or json", value)
+}
+
+func parseFormatFlag(args []string, index int) (string, int, bool, error) {
+	name, value, hasValue := strings.Cut(args[index], "=")
+	if name != "--format" {
+		return "", index, false, nil
+	}
+	if !hasValue {
+		if index+1 >= len(args) {
+			return "", index, true, errors.New("--format requires a value")
+		}
+		index++
+		value = args[index]
+	}
+	format, err := parseOutputFormat(value)
+	return format, index, true, err
+}
+
+func writeJSON(out io.Writer, value any) error {
+	payload, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(payload, '\n'))
+	return err
+}
+
+func (a *App) runInspect(args []string) error {
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: clawfarm inspect <clawid>")
+	}
+	id := args[0]
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+	if updated, changed := a.reconcileInstanceStatus(instance); changed {
+		updated.UpdatedAtUTC = time.Now().UTC()
+		if err := store.Save(updated); err != nil {
+			return err
+		}
+		instance = updated
+	}
+	inspection := inspectInstance(instance, lockManager)
+	if inspection.Checkpoints, err = listCheckpoints(clawsRoot, id); err != nil {
+		return err
+	}
+	if payload, err := os.ReadFile(filepath.Join(clawsRoot, id, clawboxSpecV2Path)); err == nil && json.Valid(payload) {
+		inspection.ClawboxSpec = payload
+	}
+	return writeJSON(a.out, inspection)
+}
+
+func inspectInstance(instance state.Instance, lockManager *state.LockManager) instanceInspection {
+	inspection := instanceInspection{Instance: instance, Mounts: instanceMounts(instance)}
+	if lockState, err := lockManager.Inspect(instance.ID); err == nil {
+		inspection.Lock = lockState
+	}
+	if guest, ok := readGuestStatus(instance); ok {
+		inspection.Guest = &guest
+	}
+	if progress, ok := readInstallProgress(instance); ok {
+		inspection.Install = &progress
+	}
+	return inspection
+}
+
+func instanceMounts(instance state.Instance) []mountState {
+	mounts := []mountState{}
+	if strings.TrimSpace(instance.WorkspacePath) != "" {
+		mounts = append(mounts, newMountState("workspace", instance.WorkspacePath, "/workspace"))
+	}
+	if strings.TrimSpace(instance.StatePath) != "" && (instance.StateMode == "" || instance.StateMode == vm.StateModeMount) {
+		mounts = append(mounts, newMountState("state", instance.StatePath, "/root/.openclaw"))
+	}
+	for _, volume := range instance.Volumes {
+		mounts = append(mounts, newMountState(volume.Name, volume.HostPath, volume.GuestPath))
+	}
+	return mounts
+}
+
+func newMountState(name string, hostPath string, guestPath string) mountState {
+	_, err := os.Stat(hostPath)
+	return mountState{Name: name, HostPath: hostPath, GuestPath: guestPath, HostExists: err == nil}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/keychain"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+func NewEmbedded(out io.Writer, errOut io.Writer, backend vm.Backend) *App {
+	application := NewWithBackend(out, errOut, backend)
+	application.keychain = keychain.System()
+	application.probeResources = vm.ProbeHostResources
+	return application
+}
+
+// SetDirs pins the data and cache directories this App works in, in place of
+// the global --data-dir, --cache-dir and --context flags.
+func (a *App) SetDirs(dirs config.DirOverrides) {
+	a.dirs = dirs
+}
+
+// RunInstance runs `clawfarm run` with args and returns the created instance.
+// Cancelling ctx aborts image preparation, the VM start and the readiness wait.
+func (a *App) RunInstance(ctx context.Context, args []string) (state.Instance, error) {
+	instance, err := a.runInstance(ctx, args, 0)
+	if err == nil && instance.ID == "" {
+		err = errors.New("run did not create an instance")
+	}
+	return instance, err
+}
+
+func (a *App) LoadInstance(id string) (state.Instance, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		return state.Instance{}, err
+	}
+	if updated, changed := a.reconcileInstanceStatus(instance); changed {
+		return updated, store.Save(updated)
+	}
+	return instance, nil
+}
+
+func (a *App) ListInstances() ([]state.Instance, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	return a.reconciledInstances(store)
+}
+
+func (a *App) FetchImage(ctx context.Context, ref string) (images.Metadata, error) {
+	manager, err := a.imageManager()
+	if err != nil {
+		return images.Metadata{}, err
+	}
+	return manager.Fetch(ctx, ref)
+}
+
+func (a *App) Checkpoint(id string, name string) (string, error) {
+	return a.checkpointInstance(id, name, false)
+}
+
+func (a *App) Restore(id string, name string) (string, error) {
+	return a.restoreInstance(id, name)
+}
+
+func (a *App) Checkpoints(id string) ([]checkpointState, error) {
+	_, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	return listCheckpoints(clawsRoot, id)
+}
@@ -0,0 +1,410 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	instanceStartSpecFile       = "start-spec.json"
+	defaultStopTimeout          = 60 * time.Second
+	stopPollInterval            = 300 * time.Millisecond
+	instanceStatusStopped       = "stopped"
+	instanceStatusInstalling    = "installing"
+	instanceStatusInstallFailed = "install-failed"
+	instanceStopForceLimit      = 40 * time.Second
+)
+
+func writeInstanceStartSpec(instanceDir string, spec vm.StartSpec) error {
+	spec.OpenClawEnvironment = nil
+	spec.UncleanShutdown = false
+	payload, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(instanceDir, instanceStartSpecFile), append(payload, '\n'), 0o600)
+}
+
+func readInstanceStartSpec(instanceDir string) (vm.StartSpec, error) {
+	payload, err := os.ReadFile(filepath.Join(instanceDir, instanceStartSpecFile))
+	if err != nil {
+		return vm.StartSpec{}, err
+	}
+	spec := vm.StartSpec{}
+	if err := json.Unmarshal(payload, &spec); err != nil {
+		return vm.StartSpec{}, fmt.Errorf("parse %s: %w", instanceStartSpecFile, err)
+	}
+	return spec, nil
+}
+
+type lifecycleOptions struct {
+	Timeout          time.Duration
+	Force            bool
+	NoWait           bool
+	ReadyTimeoutSecs int
+}
+
+func parseLifecycleArgs(command string, usage string, args []string, stopFlags bool, startFlags bool) (string, lifecycleOptions, error) {
+	options := lifecycleOptions{Timeout: defaultStopTimeout, ReadyTimeoutSecs: defaultReadyTimeoutSecs}
+	positionals := make([]string, 0, len(args))
+	for index := 0; index < len(args); index++ {
+		trimmed := strings.TrimSpace(args[index])
+		name, value, hasValue := strings.Cut(trimmed, "=")
+		if (stopFlags && name == "--timeout" || startFlags && name == "--ready-timeout-secs") && !hasValue {
+			if index+1 >= len(args) {
+				return "", options, fmt.Errorf("missing value for %s", name)
+			}
+			index++
+			value = args[index]
+		}
+		switch {
+		case trimmed == "":
+			continue
+		case stopFlags && trimmed == "--force":
+			options.Force = true
+		case stopFlags && name == "--timeout":
+			parsed, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil || parsed <= 0 {
+				return "", options, fmt.Errorf("invalid --timeout %q: expected a duration like 60s", value)
+			}
+			options.Timeout = parsed
+		case startFlags && trimmed == "--no-wait":
+			options.NoWait = true
+		case startFlags && name == "--ready-timeout-secs":
+			parsed, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || parsed < 1 {
+				return "", options, fmt.Errorf("invalid --ready-timeout-secs %q", value)
+			}
+			options.ReadyTimeoutSecs = parsed
+		case strings.HasPrefix(trimmed, "--"):
+			return "", options, fmt.Errorf("unknown %s flag %q", command, trimmed)
+		default:
+			positionals = append(positionals, trimmed)
+		}
+	}
+	if len(positionals) != 1 {
+		return "", options, errors.New(usage)
+	}
+	return positionals[0], options, nil
+}
+
+func (a *App) runStop(args []string) error {
+	id, options, err := parseLifecycleArgs("stop", "usage: clawfarm stop <clawid> [--timeout 60s] [--force]", args, true, false)
+	if err != nil {
+		return err
+	}
+	graceful, err := a.stopInstance(id, options.Timeout, options.Force)
+	if err != nil {
+		return err
+	}
+	a.reportStoppedInstance(id, graceful)
+	return nil
+}
+
+func (a *App) reportStoppedInstance(id string, graceful bool) {
+	if graceful {
+		fmt.Fprintf(a.out, "%s -> %s\n", id, instanceStatusStopped)
+	} else {
+		fmt.Fprintf(a.out, "%s -> %s (forced)\n", id, instanceStatusStopped)
+	}
+}
+
+func (a *App) stopInstance(id string, timeout time.Duration, force bool) (bool, error) {
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return false, err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return false, err
+	}
+
+	graceful := false
+	err = lockManager.WithInstanceLock(id, func() error {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		if instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+			return fmt.Errorf("instance %s is not running", id)
+		}
+		if instance.Status == "suspended" {
+			if err := a.backend.Resume(instanceProcess(instance)); err != nil {
+				return err
+			}
+		}
+
+		if !force {
+			if err := vm.PowerDown(instance.MonitorPath); err != nil {
+				fmt.Fprintf(a.errOut, "warning: ACPI shutdown unavailable for %s (%v); stopping the VM process\n", id, err)
+			} else {
+				graceful = a.waitForProcessExit(instance.PID, timeout)
+				if !graceful {
+					fmt.Fprintf(a.errOut, "warning: %s did not power off within %s; stopping the VM process\n", id, timeout)
+				}
+			}
+		}
+		if !graceful {
+			stopCtx, cancel := context.WithTimeout(context.Background(), instanceStopForceLimit)
+			defer cancel()
+			if err := a.backend.Stop(stopCtx, instanceProcess(instance)); err != nil {
+				return err
+			}
+		}
+		stopWorkspaceWatcher(filepath.Join(clawsRoot, id), instance)
+		if err := lockManager.ReleaseWhileLocked(context.Background(), state.ReleaseRequest{ClawID: id}); err != nil {
+			return err
+		}
+
+		instance.PID = 0
+		instance.WatchPID = 0
+		instance.Status = instanceStatusStopped
+		instance.DirtyShutdown = !graceful
+		instance.LastError = ""
+		instance.UpdatedAtUTC = time.Now().UTC()
+		return store.Save(instance)
+	})
+	if err != nil {
+		return false, err
+	}
+	a.refreshSSHConfig()
+	return graceful, nil
+}
+
+func (a *App) waitForProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !a.backend.IsRunning(pid) {
+			return true
+		}
+		time.Sleep(stopPollInterval)
+	}
+	return !a.backend.IsRunning(pid)
+}
+
+func (a *App) runStart(args []string) error {
+	id, options, err := parseLifecycleArgs("start", "usage: clawfarm start <clawid> [--no-wait] [--ready-timeout-secs N]", args, false, true)
+	if err != nil {
+		return err
+	}
+	instance, err := a.startInstance(id, options.NoWait)
+	if err != nil {
+		return err
+	}
+	return a.reportStartedInstance(instance, options.NoWait, options.ReadyTimeoutSecs)
+}
+
+func (a *App) runRestart(args []string) error {
+	id, options, err := parseLifecycleArgs("restart", "usage: clawfarm restart <clawid> [--timeout 60s] [--force] [--no-wait] [--ready-timeout-secs N]", args, true, true)
+	if err != nil {
+		return err
+	}
+	running, err := a.instanceRunning(id)
+	if err != nil {
+		return err
+	}
+	if running {
+		graceful, err := a.stopInstance(id, options.Timeout, options.Force)
+		if err != nil {
+			return err
+		}
+		a.reportStoppedInstance(id, graceful)
+	}
+	instance, err := a.startInstance(id, options.NoWait)
+	if err != nil {
+		return err
+	}
+	return a.reportStartedInstance(instance, options.NoWait, options.ReadyTimeoutSecs)
+}
+
+func (a *App) instanceRunning(id string) (bool, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return false, err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return false, fmt.Errorf("instance %s not found", id)
+		}
+		return false, err
+	}
+	return instance.PID > 0 && a.backend.IsRunning(instance.PID), nil
+}
+
+// instanceProcess is the handle the backend needs to stop, suspend or resume
+// the VM recorded for instance.
+func instanceProcess(instance state.Instance) vm.Process {
+	return vm.Process{PID: instance.PID, Backend: instance.Backend, MonitorPath: instance.MonitorPath}
+}
+
+func (a *App) startInstance(id string, noWait bool) (state.Instance, error) {
+	return a.startInstanceFrom(id, noWait, "")
+}
+
+// startInstanceFrom boots a stopped instance. A non-empty incomingStatePath
+// resumes the VM from saved memory instead of booting the guest afresh.
+func (a *App) startInstanceFrom(id string, noWait bool, incomingStatePath string) (state.Instance, error) {
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return state.Instance{}, err
+	}
+
+	var instance state.Instance
+	err = lockManager.WithInstanceLock(id, func() error {
+		loaded, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		instance = loaded
+		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
+			return fmt.Errorf("instance %s is already running", id)
+		}
+		instanceDir := filepath.Join(clawsRoot, id)
+		spec, specErr := readInstanceStartSpec(instanceDir)
+		if specErr != nil {
+			if errors.Is(specErr, os.ErrNotExist) {
+				return fmt.Errorf("instance %s has no recorded start configuration; recreate it with clawfarm run", id)
+			}
+			return specErr
+		}
+		if strings.TrimSpace(instance.DiskPath) != "" {
+			spec.SourceDiskPath = instance.DiskPath
+		}
+		spec.UncleanShutdown = instance.DirtyShutdown || instance.PID > 0
+		if incomingStatePath != "" {
+			spec.IncomingStatePath = incomingStatePath
+			spec.UncleanShutdown = false
+		}
+
+		startResult, startErr := a.backend.Start(context.Background(), spec)
+		if startErr != nil {
+			return startErr
+		}
+		if err := lockManager.AcquireWhileLocked(context.Background(), state.AcquireRequest{
+			ClawID:     id,
+			InstanceID: id,
+			PID:        startResult.PID,
+		}); err != nil {
+			stopCtx, cancel := context.WithTimeout(context.Background(), instanceStopForceLimit)
+			defer cancel()
+			_ = a.backend.Stop(stopCtx, vm.Process{PID: startResult.PID, Backend: spec.Backend, MonitorPath: startResult.MonitorPath})
+			return err
+		}
+
+		now := time.Now().UTC()
+		instance.PID = startResult.PID
+		instance.Status = "booting"
+		if noWait {
+			instance.Status = "running"
+		}
+		instance.DirtyShutdown = spec.UncleanShutdown
+		instance.DiskPath = startResult.DiskPath
+		instance.SeedISOPath = startResult.SeedISOPath
+		instance.SerialLogPath = startResult.SerialLogPath
+		instance.QEMULogPath = startResult.QEMULogPath
+		instance.MonitorPath = startResult.MonitorPath
+		instance.QEMUAccel = startResult.Accel
+		instance.QEMUCommand = startResult.Command
+		instance.BootMemoryMiB = spec.MemoryMiB
+		instance.LastError = ""
+		instance.StartedAtUTC = now
+		instance.UpdatedAtUTC = now
+		instance.WatchPID = 0
+		if err := store.Save(instance); err != nil {
+			return err
+		}
+		if instance.WorkspaceWatch && a.startWatcher != nil {
+			watchPID, watchErr := a.startWatcher(a.dirs, id, instanceDir)
+			if watchErr != nil {
+				fmt.Fprintf(a.errOut, "warning: workspace watch not started: %v\n", watchErr)
+				return nil
+			}
+			instance.WatchPID = watchPID
+			return store.Save(instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return state.Instance{}, err
+	}
+	a.refreshSSHConfig()
+	return instance, nil
+}
+
+func (a *App) reportStartedInstance(instance state.Instance, noWait bool, readyTimeoutSecs int) error {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	httpURL := fmt.Sprintf("http://127.0.0.1:%d/", instance.GatewayPort())
+	fmt.Fprintf(a.out, "CLAWID: %s\n", instance.ID)
+	fmt.Fprintf(a.out, "gateway: %s\n", httpURL)
+	fmt.Fprintf(a.out, "vm pid: %d\n", instance.PID)
+	if instance.SSHHostPort > 0 {
+		fmt.Fprintf(a.out, "ssh: %s:%d\n", sshDestination(instance.SSHUser()), instance.SSHHostPort)
+	}
+	if instance.DirtyShutdown {
+		fmt.Fprintln(a.out, "last shutdown: not graceful (disk checked before boot)")
+	}
+	if noWait {
+		fmt.Fprintln(a.out, "status: running (not waiting for gateway readiness)")
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), time.Duration(readyTimeoutSecs)*time.Second)
+	defer cancel()
+	if err := vm.WaitForHTTPReady(waitCtx, httpURL, gatewayReadiness(instance)); err != nil {
+		instance.Status = "unhealthy"
+		instance.LastError = err.Error()
+		if errors.Is(err, vm.ErrGatewayInstallFailed) {
+			instance.Status = instanceStatusInstallFailed
+			instance.LastError = installFailedError(instance)
+		}
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if saveErr := store.Save(instance); saveErr != nil {
+			return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
+		}
+		return fmt.Errorf("gateway is not reachable yet at %s (%v); check %s", httpURL, err, instance.SerialLogPath)
+	}
+	if err := waitForExtraGateways(waitCtx, &instance); err != nil {
+		instance.Status = "unhealthy"
+		instance.LastError = err.Error()
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if saveErr := store.Save(instance); saveErr != nil {
+			return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
+		}
+		return err
+	}
+	readyTargets := []string{httpURL}
+	for _, gateway := range extraGateways(instance) {
+		readyTargets = append(readyTargets, gateway.Name+" "+gatewayURL(gateway))
+	}
+	instance.Status = "ready"
+	instance.UpdatedAtUTC = time.Now().UTC()
+	if err := store.Save(instance); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "status: ready (%s)\n", strings.Join(readyTargets, ", "))
+	return nil
+}
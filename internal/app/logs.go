@@ -0,0 +1,251 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	logSourceSerial    = "serial"
+	logSourceQEMU      = "qemu"
+	logSourceBootstrap = "bootstrap"
+	logSourceGateway   = "gateway"
+	logSourceInstall   = "install"
+
+	guestBootstrapLogPath = "/var/log/clawfarm-bootstrap.log"
+	guestInstallLogPath   = "/var/log/clawfarm-openclaw-install.log"
+	logsFollowInterval    = 500 * time.Millisecond
+)
+
+const logsUsage = "usage: clawfarm logs <clawid> [--source serial|qemu|bootstrap|gateway|install] [--follow] [--since 10m|RFC3339] [--tail N]"
+
+type logsOptions struct {
+	Source string
+	Follow bool
+	Since  time.Time
+	Tail   int
+}
+
+func (a *App) runLogs(args []string) error {
+	id := ""
+	options := logsOptions{Source: logSourceSerial}
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "-f", "--follow":
+			if hasValue {
+				return errors.New(logsUsage)
+			}
+			options.Follow = true
+		case "--source", "--since", "--tail":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", name)
+				}
+				index++
+				value = args[index]
+			}
+			if err := options.set(name, value); err != nil {
+				return err
+			}
+		default:
+			if strings.HasPrefix(arg, "-") || id != "" {
+				return errors.New(logsUsage)
+			}
+			id = arg
+		}
+	}
+	if id == "" {
+		return errors.New(logsUsage)
+	}
+
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return fmt.Errorf("instance %s not found", id)
+		}
+		return err
+	}
+
+	switch options.Source {
+	case logSourceSerial:
+		return a.streamHostLog(instance, instance.SerialLogPath, options)
+	case logSourceQEMU:
+		return a.streamHostLog(instance, instance.QEMULogPath, options)
+	default:
+		return a.streamGuestLog(instance, options)
+	}
+}
+
+func (o *logsOptions) set(name string, value string) error {
+	value = strings.TrimSpace(value)
+	switch name {
+	case "--source":
+		switch value {
+		case logSourceSerial, logSourceQEMU, logSourceBootstrap, logSourceGateway, logSourceInstall:
+			o.Source = value
+			return nil
+		}
+		return fmt.Errorf("invalid --source %q: expected serial, qemu, bootstrap, gateway, or install", value)
+	case "--since":
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			o.Since = time.Now().Add(-duration)
+			return nil
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid --since %q: expected a duration like 10m or an RFC3339 time", value)
+		}
+		o.Since = parsed
+		return nil
+	default:
+		tail, err := strconv.Atoi(value)
+		if err != nil || tail < 0 {
+			return fmt.Errorf("invalid --tail %q: expected a non-negative line count", value)
+		}
+		o.Tail = tail
+		return nil
+	}
+}
+
+func (a *App) streamHostLog(instance state.Instance, path string, options logsOptions) error {
+	if strings.TrimSpace(path) == "" {
+		return fmt.Errorf("instance %s has no %s log", instance.ID, options.Source)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && options.Follow {
+			file, err = a.waitForLogFile(instance, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	payload, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if options.Since.IsZero() || !info.ModTime().Before(options.Since) {
+		if _, err := io.WriteString(a.out, tailLines(string(payload), options.Tail)); err != nil {
+			return err
+		}
+	}
+	if !options.Follow {
+		return nil
+	}
+
+	offset := int64(len(payload))
+	for {
+		running := instance.PID > 0 && a.backend.IsRunning(instance.PID)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() < offset {
+			offset = 0
+		}
+		if info.Size() > offset {
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			copied, err := io.Copy(a.out, io.LimitReader(file, info.Size()-offset))
+			offset += copied
+			if err != nil {
+				return err
+			}
+		}
+		if !running {
+			fmt.Fprintf(a.errOut, "instance %s is not running; stopped following\n", instance.ID)
+			return nil
+		}
+		time.Sleep(logsFollowInterval)
+	}
+}
+
+func (a *App) waitForLogFile(instance state.Instance, path string) (*os.File, error) {
+	for {
+		file, err := os.Open(path)
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return file, err
+		}
+		if instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+			return nil, err
+		}
+		time.Sleep(logsFollowInterval)
+	}
+}
+
+func (a *App) streamGuestLog(instance state.Instance, options logsOptions) error {
+	if instance.PID <= 0 || !a.backend.IsRunning(instance.PID) {
+		return fmt.Errorf("instance %s is not running; the %s log lives inside the guest", instance.ID, options.Source)
+	}
+	ready, err := a.loadSSHReadyInstance(instance.ID)
+	if err != nil {
+		return err
+	}
+	return a.runSSHCommand(ready, guestLogCommand(options), false, nil)
+}
+
+func guestLogCommand(options logsOptions) string {
+	if options.Source == logSourceGateway {
+		command := "journalctl -u clawfarm-gateway.service --no-pager -o short-iso"
+		if options.Tail > 0 {
+			command += fmt.Sprintf(" -n %d", options.Tail)
+		}
+		if !options.Since.IsZero() {
+			command += fmt.Sprintf(" --since @%d", options.Since.Unix())
+		}
+		if options.Follow {
+			command += " -f"
+		}
+		return command
+	}
+
+	logPath := guestBootstrapLogPath
+	if options.Source == logSourceInstall {
+		logPath = guestInstallLogPath
+	}
+	lines := "+1"
+	if options.Tail > 0 {
+		lines = strconv.Itoa(options.Tail)
+	}
+	command := fmt.Sprintf("tail -n %s", lines)
+	if options.Follow {
+		command += " -F"
+	}
+	command += " " + logPath
+	if !options.Since.IsZero() && !options.Follow {
+		command = fmt.Sprintf("if [ \"$(stat -c %%Y %s)\" -ge %d ]; then %s; fi", logPath, options.Since.Unix(), command)
+	}
+	return command
+}
+
+func tailLines(content string, count int) string {
+	if count <= 0 || content == "" {
+		return content
+	}
+	trimmed := strings.TrimSuffix(content, "\n")
+	lines := strings.Split(trimmed, "\n")
+	if len(lines) <= count {
+		return content
+	}
+	return strings.Join(lines[len(lines)-count:], "\n") + "\n"
+}
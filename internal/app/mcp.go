@@ -0,0 +1,492 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	mcpProtocolVersion     = "2024-11-05"
+	defaultMCPMaxInstances = 4
+	defaultMCPLogLines     = 100
+	maxMCPToolOutputBytes  = 64 * 1024
+	maxMCPRequestLineBytes = 4 * 1024 * 1024
+	mcpUsage               = "usage: clawfarm mcp serve [--allow-image ref]... [--max-instances N] [run flags]"
+	jsonRPCParseError      = -32700
+	jsonRPCInvalidRequest  = -32600
+	jsonRPCMethodNotFound  = -32601
+	jsonRPCInvalidParams   = -32602
+)
+
+// mcpServer exposes clawfarm to an agent. Every tool except clawfarm_run only
+// reaches the instances this server created, so an agent cannot touch VMs
+// the operator or another server started.
+type mcpServer struct {
+	app           *App
+	allowedImages map[string]bool
+	maxInstances  int
+	runArgs       []string
+	owned         map[string]bool
+}
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolArgs struct {
+	ID      string   `json:"id"`
+	Image   string   `json:"image"`
+	Name    string   `json:"name"`
+	Run     []string `json:"run"`
+	SSH     bool     `json:"ssh"`
+	Command string   `json:"command"`
+	Lines   int      `json:"lines"`
+}
+
+type mcpInstance struct {
+	ID          string `json:"id"`
+	Image       string `json:"image"`
+	Status      string `json:"status"`
+	GatewayPort int    `json:"gateway_port"`
+	SSHPort     int    `json:"ssh_port,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+func (a *App) runMCP(args []string) error {
+	if len(args) == 0 || args[0] != "serve" {
+		return errors.New(mcpUsage)
+	}
+	server := &mcpServer{app: a, allowedImages: map[string]bool{}, maxInstances: defaultMCPMaxInstances, owned: map[string]bool{}}
+	for index := 1; index < len(args); index++ {
+		arg := args[index]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch name {
+		case "--allow-image", "--max-instances":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return fmt.Errorf("%s requires a value", name)
+				}
+				index++
+				value = args[index]
+			}
+			value = strings.TrimSpace(value)
+			if name == "--allow-image" {
+				if value == "" {
+					return errors.New("--allow-image requires a non-empty image ref")
+				}
+				server.allowedImages[value] = true
+				continue
+			}
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				return fmt.Errorf("invalid --max-instances %q: expected a positive integer", value)
+			}
+			server.maxInstances = parsed
+		case "--replicas", "--name", "--run", "--devcontainer":
+			return fmt.Errorf("%s cannot be used with mcp serve; the calling agent chooses it per run", name)
+		default:
+			server.runArgs = append(server.runArgs, arg)
+		}
+	}
+	if !hasCLIFlag(server.runArgs, "--workspace") && !hasCLIFlag(server.runArgs, "--no-workspace") {
+		server.runArgs = append(server.runArgs, "--no-workspace")
+	}
+
+	protocolOut := a.out
+	quiet := *a
+	quiet.out = a.errOut
+	quiet.in = nil
+	if _, ok := a.backend.(*vm.HostBackend); ok {
+		quiet.backend = vm.NewHostBackend(a.errOut)
+	}
+	server.app = &quiet
+	if len(server.allowedImages) == 0 {
+		fmt.Fprintln(a.errOut, "mcp: no --allow-image given; agents may run ubuntu and local store images")
+	}
+	fmt.Fprintf(a.errOut, "mcp: serving clawfarm tools on stdin/stdout (max %d instances)\n", server.maxInstances)
+	input := a.in
+	if input == nil {
+		input = os.Stdin
+	}
+	return server.serve(input, protocolOut)
+}
+
+func (s *mcpServer) serve(input io.Reader, output io.Writer) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMCPRequestLineBytes)
+	encoder := json.NewEncoder(output)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		response, ok := s.handle(line)
+		if !ok {
+			continue
+		}
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *mcpServer) handle(line []byte) (jsonRPCResponse, bool) {
+	var request jsonRPCRequest
+	if err := json.Unmarshal(line, &request); err != nil {
+		return jsonRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &jsonRPCError{Code: jsonRPCParseError, Message: err.Error()}}, true
+	}
+	if len(request.ID) == 0 {
+		return jsonRPCResponse{}, false
+	}
+	response := jsonRPCResponse{JSONRPC: "2.0", ID: request.ID}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		response.Error = &jsonRPCError{Code: jsonRPCInvalidRequest, Message: "expected a JSON-RPC 2.0 request"}
+		return response, true
+	}
+
+	switch request.Method {
+	case "initialize":
+		response.Result = map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "clawfarm", "version": Version},
+		}
+	case "ping":
+		response.Result = map[string]any{}
+	case "tools/list":
+		response.Result = map[string]any{"tools": s.tools()}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(request.Params, &params); err != nil {
+			response.Error = &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+			return response, true
+		}
+		var toolArgs mcpToolArgs
+		if len(params.Arguments) > 0 {
+			if err := json.Unmarshal(params.Arguments, &toolArgs); err != nil {
+				response.Error = &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+				return response, true
+			}
+		}
+		text, err := s.callTool(params.Name, toolArgs)
+		if err != nil {
+			response.Result = mcpToolResult{Content: []mcpContent{{Type: "text", Text: truncateMCPOutput(strings.TrimSpace(text + "\n" + err.Error()))}}, IsError: true}
+			return response, true
+		}
+		response.Result = mcpToolResult{Content: []mcpContent{{Type: "text", Text: truncateMCPOutput(text)}}}
+	default:
+		response.Error = &jsonRPCError{Code: jsonRPCMethodNotFound, Message: "method not found: " + request.Method}
+	}
+	return response, true
+}
+
+func (s *mcpServer) tools() []mcpTool {
+	idSchema := map[string]any{"type": "string", "description": "CLAWID of the instance"}
+	objectSchema := func(properties map[string]any, required ...string) map[string]any {
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	imageDescription := "ubuntu or local store image ref to boot, e.g. ubuntu:24.04"
+	if len(s.allowedImages) > 0 {
+		imageDescription += "; allowed: " + strings.Join(s.allowedImageList(), ", ")
+	}
+	return []mcpTool{
+		{Name: "clawfarm_list", Description: "List the instances this server started with their status and forwarded ports.", InputSchema: objectSchema(map[string]any{})},
+		{Name: "clawfarm_run", Description: fmt.Sprintf("Boot a new sandbox VM and wait until it is ready. At most %d instances may exist at once.", s.maxInstances), InputSchema: objectSchema(map[string]any{
+			"image": map[string]any{"type": "string", "description": imageDescription},
+			"name":  map[string]any{"type": "string", "description": "optional instance name"},
+			"run":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "shell commands to run as root after boot"},
+			"ssh":   map[string]any{"type": "boolean", "description": "keep an SSH forward so clawfarm_exec works"},
+		}, "image")},
+		{Name: "clawfarm_exec", Description: "Run a shell command as root inside an instance started with ssh or run commands.", InputSchema: objectSchema(map[string]any{
+			"id":      idSchema,
+			"command": map[string]any{"type": "string", "description": "command passed to bash -lc"},
+		}, "id", "command")},
+		{Name: "clawfarm_logs", Description: "Show the tail of an instance's serial console log.", InputSchema: objectSchema(map[string]any{
+			"id":    idSchema,
+			"lines": map[string]any{"type": "integer", "description": fmt.Sprintf("number of lines (default %d)", defaultMCPLogLines)},
+		}, "id")},
+		{Name: "clawfarm_checkpoint", Description: "Save a named checkpoint of an instance's disk.", InputSchema: objectSchema(map[string]any{
+			"id":   idSchema,
+			"name": map[string]any{"type": "string", "description": "checkpoint name"},
+		}, "id", "name")},
+		{Name: "clawfarm_rm", Description: "Stop and remove an instance.", InputSchema: objectSchema(map[string]any{"id": idSchema}, "id")},
+	}
+}
+
+func (s *mcpServer) callTool(name string, args mcpToolArgs) (string, error) {
+	switch name {
+	case "clawfarm_list":
+		return s.list()
+	case "clawfarm_run":
+		return s.run(args)
+	case "clawfarm_exec":
+		return s.exec(args)
+	case "clawfarm_logs":
+		return s.logs(args)
+	case "clawfarm_checkpoint":
+		if strings.TrimSpace(args.Name) == "" {
+			return "", errors.New("id and name are required")
+		}
+		instance, err := s.loadInstance(args.ID)
+		if err != nil {
+			return "", err
+		}
+		return s.capture(func(child *App) error {
+			return child.runCheckpoint([]string{instance.ID, "--name", args.Name})
+		})
+	case "clawfarm_rm":
+		instance, err := s.loadInstance(args.ID)
+		if err != nil {
+			return "", err
+		}
+		output, err := s.capture(func(child *App) error {
+			return child.runRemove([]string{instance.ID})
+		})
+		if err == nil {
+			delete(s.owned, instance.ID)
+		}
+		return output, err
+	default:
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+}
+
+func (s *mcpServer) capture(call func(child *App) error) (string, error) {
+	var output bytes.Buffer
+	child := *s.app
+	child.out = &output
+	child.errOut = &output
+	child.in = nil
+	err := call(&child)
+	return output.String(), err
+}
+
+func (s *mcpServer) list() (string, error) {
+	instances, err := s.ownedInstances()
+	if err != nil {
+		return "", err
+	}
+	listed := make([]mcpInstance, 0, len(instances))
+	for _, instance := range instances {
+		instance, _ = s.app.reconcileInstanceStatus(instance)
+		listed = append(listed, mcpInstance{
+			ID:          instance.ID,
+			Image:       instance.ImageRef,
+			Status:      instance.Status,
+			GatewayPort: instance.GatewayPort(),
+			SSHPort:     instance.SSHHostPort,
+			LastError:   instance.LastError,
+		})
+	}
+	payload, err := json.MarshalIndent(listed, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+func (s *mcpServer) run(args mcpToolArgs) (string, error) {
+	image := strings.TrimSpace(args.Image)
+	if err := s.checkImage(image); err != nil {
+		return "", err
+	}
+	instances, err := s.ownedInstances()
+	if err != nil {
+		return "", err
+	}
+	if len(instances) >= s.maxInstances {
+		return "", fmt.Errorf("instance limit reached (%d of %d); remove one with clawfarm_rm first", len(instances), s.maxInstances)
+	}
+
+	runArgs := append([]string{}, s.runArgs...)
+	if name := strings.TrimSpace(args.Name); name != "" {
+		runArgs = append(runArgs, "--name", name)
+	}
+	for _, command := range args.Run {
+		runArgs = append(runArgs, "--run", command)
+	}
+	if args.SSH && !hasCLIFlag(runArgs, "--ssh") {
+		runArgs = append(runArgs, "--ssh")
+	}
+	runArgs = append(runArgs, image)
+	return s.capture(func(child *App) error {
+		instance, err := child.runInstance(context.Background(), runArgs, 0)
+		if instance.ID != "" {
+			s.owned[instance.ID] = true
+		}
+		return err
+	})
+}
+
+// checkImage keeps the agent's image inside the operator's policy: the
+// --allow-image list when one was given, otherwise ubuntu and local store
+// refs only, never flags, host paths or clawbox archives.
+func (s *mcpServer) checkImage(image string) error {
+	if image == "" {
+		return errors.New("image is required")
+	}
+	if strings.HasPrefix(image, "-") {
+		return fmt.Errorf("image %q must not start with -", image)
+	}
+	if len(s.allowedImages) > 0 {
+		if !s.allowedImages[image] {
+			return fmt.Errorf("image %q is not allowed; allowed images: %s", image, strings.Join(s.allowedImageList(), ", "))
+		}
+		return nil
+	}
+	if _, err := images.ParseUbuntuRef(image); err == nil {
+		return nil
+	}
+	if _, err := images.ParseLocalRef(image); err == nil && !isClawboxRunInput(image) {
+		return nil
+	}
+	return fmt.Errorf("image %q is not allowed; expected an ubuntu or local store image ref (start the server with --allow-image to permit others)", image)
+}
+
+// ownedInstances lists the instances this server created that still exist.
+func (s *mcpServer) ownedInstances() ([]state.Instance, error) {
+	store, _, err := s.app.instanceStore()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]state.Instance, 0, len(s.owned))
+	for _, instance := range instances {
+		if s.owned[instance.ID] {
+			owned = append(owned, instance)
+		}
+	}
+	return owned, nil
+}
+
+func (s *mcpServer) exec(args mcpToolArgs) (string, error) {
+	if strings.TrimSpace(args.Command) == "" {
+		return "", errors.New("command is required")
+	}
+	instance, err := s.loadInstance(args.ID)
+	if err != nil {
+		return "", err
+	}
+	if instance.SSHHostPort <= 0 || !fileExistsAndNonEmpty(instance.SSHKeyPath) {
+		return "", fmt.Errorf("instance %s has no SSH forward; run it with ssh enabled", instance.ID)
+	}
+	return s.capture(func(child *App) error {
+		return child.runSSHCommand(instance, args.Command, false, nil)
+	})
+}
+
+func (s *mcpServer) logs(args mcpToolArgs) (string, error) {
+	instance, err := s.loadInstance(args.ID)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(instance.SerialLogPath) == "" {
+		return "", fmt.Errorf("instance %s has no serial log", instance.ID)
+	}
+	payload, err := os.ReadFile(instance.SerialLogPath)
+	if err != nil {
+		return "", err
+	}
+	lines := args.Lines
+	if lines <= 0 {
+		lines = defaultMCPLogLines
+	}
+	all := strings.Split(strings.TrimRight(string(payload), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n"), nil
+}
+
+func (s *mcpServer) loadInstance(id string) (state.Instance, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return state.Instance{}, errors.New("id is required")
+	}
+	if !s.owned[id] {
+		return state.Instance{}, fmt.Errorf("instance %s was not started by this mcp server", id)
+	}
+	store, _, err := s.app.instanceStore()
+	if err != nil {
+		return state.Instance{}, err
+	}
+	instance, err := store.Load(id)
+	if err != nil {
+		if errors.Is(err, state.ErrNotFound) {
+			return state.Instance{}, fmt.Errorf("instance %s not found", id)
+		}
+		return state.Instance{}, err
+	}
+	return instance, nil
+}
+
+func (s *mcpServer) allowedImageList() []string {
+	refs := make([]string, 0, len(s.allowedImages))
+	for ref := range s.allowedImages {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+func truncateMCPOutput(text string) string {
+	if len(text) <= maxMCPToolOutputBytes {
+		return text
+	}
+	return "...(truncated)\n" + text[len(text)-maxMCPToolOutputBytes:]
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const (
+	openClawPackageDir     = "openclaw"
+	openClawSourceTarball  = "tarball"
+	openClawSourceBundle   = "bundle"
+	openClawSourceRegistry = "registry"
+)
+
+var openClawExactVersionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+type openClawPin struct {
+	Package     state.OpenClawPackage
+	TarballPath string
+}
+
+func isOpenClawTarballSpec(packageSpec string) bool {
+	lower := strings.ToLower(strings.TrimSpace(packageSpec))
+	return strings.HasSuffix(lower, ".tgz") || strings.HasSuffix(lower, ".tar.gz")
+}
+
+func resolveOpenClawPin(packageSpec string, integrity string) (openClawPin, error) {
+	packageSpec = strings.TrimSpace(packageSpec)
+	integrity = strings.TrimSpace(integrity)
+	if packageSpec == "" {
+		packageSpec = "openclaw@latest"
+	}
+	if integrity != "" && !vm.OpenClawIntegrityPattern.MatchString(integrity) {
+		return openClawPin{}, fmt.Errorf("invalid --openclaw-integrity %q: expected sha512-<base64> as in package-lock.json", integrity)
+	}
+
+	if isOpenClawTarballSpec(packageSpec) {
+		tarballPath := strings.TrimPrefix(packageSpec, "file:")
+		actual, err := fileSHA512Integrity(tarballPath)
+		if err != nil {
+			return openClawPin{}, fmt.Errorf("read openclaw package: %w", err)
+		}
+		if integrity != "" && actual != integrity {
+			return openClawPin{}, fmt.Errorf("openclaw package %s integrity mismatch: expected %s, got %s", tarballPath, integrity, actual)
+		}
+		version, err := openClawTarballVersion(tarballPath)
+		if err != nil {
+			return openClawPin{}, fmt.Errorf("openclaw package %s: %w", tarballPath, err)
+		}
+		return openClawPin{
+			Package:     state.OpenClawPackage{Source: openClawSourceTarball, Spec: filepath.Base(tarballPath), Version: version, Integrity: actual},
+			TarballPath: tarballPath,
+		}, nil
+	}
+
+	version := npmPackageVersion(packageSpec)
+	if integrity != "" && !openClawExactVersionPattern.MatchString(version) {
+		return openClawPin{}, fmt.Errorf("--openclaw-integrity requires an exact version (for example openclaw@1.2.3) or a local .tgz, got %q", packageSpec)
+	}
+	return openClawPin{
+		Package: state.OpenClawPackage{Source: openClawSourceRegistry, Spec: packageSpec, Version: version, Integrity: integrity},
+	}, nil
+}
+
+func (pin openClawPin) stage(instanceDir string) (string, error) {
+	if pin.TarballPath == "" {
+		return "", nil
+	}
+	stagedPath := filepath.Join(instanceDir, openClawPackageDir, filepath.Base(pin.TarballPath))
+	if stagedPath == pin.TarballPath {
+		return stagedPath, nil
+	}
+	if err := ensureDir(filepath.Dir(stagedPath)); err != nil {
+		return "", err
+	}
+	if err := diskutil.CopyFile(pin.TarballPath, stagedPath); err != nil {
+		return "", err
+	}
+	actual, err := fileSHA512Integrity(stagedPath)
+	if err != nil {
+		return "", err
+	}
+	if actual != pin.Package.Integrity {
+		_ = os.Remove(stagedPath)
+		return "", fmt.Errorf("openclaw package %s changed while staging: expected %s, got %s", pin.TarballPath, pin.Package.Integrity, actual)
+	}
+	return stagedPath, nil
+}
+
+func describeOpenClawPackage(pin *state.OpenClawPackage) string {
+	if pin == nil {
+		return ""
+	}
+	if pin.Integrity == "" {
+		return fmt.Sprintf("%s (unpinned; pass --openclaw-integrity with an exact version to verify it)", pin.Spec)
+	}
+	return fmt.Sprintf("%s %s from %s (%s)", pin.Spec, pin.Version, pin.Source, pin.Integrity)
+}
+
+func npmPackageVersion(packageSpec string) string {
+	index := strings.LastIndex(packageSpec, "@")
+	if index <= 0 {
+		return "latest"
+	}
+	return packageSpec[index+1:]
+}
+
+func fileSHA512Integrity(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha512.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return "sha512-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func openClawTarballVersion(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", fmt.Errorf("open npm tarball as gzip stream: %w", err)
+	}
+	defer gzReader.Close()
+
+	tarReader := tar.NewReader(gzReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return "", errors.New("missing package/package.json")
+		}
+		if err != nil {
+			return "", fmt.Errorf("read npm tarball: %w", err)
+		}
+		if normalizedTarPath(header.Name) != "package/package.json" || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		var manifest struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}
+		if err := json.NewDecoder(io.LimitReader(tarReader, 1024*1024)).Decode(&manifest); err != nil {
+			return "", fmt.Errorf("parse package/package.json: %w", err)
+		}
+		if strings.TrimSpace(manifest.Version) == "" {
+			return "", errors.New("package/package.json has no version")
+		}
+		return strings.TrimSpace(manifest.Version), nil
+	}
+}
+
+func extractClawboxEntry(clawboxPath string, entryName string, destinationPath string) error {
+	extractor, err := newClawboxExtractor(nil)
+	if err != nil {
+		return err
+	}
+	stream, err := openClawboxStream(clawboxPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("missing %s in .clawbox", entryName)
+		}
+		if err != nil {
+			return fmt.Errorf("read .clawbox tar stream: %w", err)
+		}
+		if normalizedTarPath(header.Name) != entryName {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%s must be a regular file", entryName)
+		}
+		if err := extractor.checkEntry(entryName, header); err != nil {
+			return err
+		}
+		return extractor.extract(tarReader, header, destinationPath, 0o644, "")
+	}
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+type readinessJSONList struct {
+	Values map[string]string
+}
+
+func (l *readinessJSONList) String() string {
+	return ""
+}
+
+func (l *readinessJSONList) Set(value string) error {
+	field, expected, ok := strings.Cut(value, "=")
+	field = strings.TrimSpace(field)
+	if !ok || field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+		return fmt.Errorf("invalid ready-json %q: expected field.path=value", value)
+	}
+	if l.Values == nil {
+		l.Values = map[string]string{}
+	}
+	l.Values[field] = strings.TrimSpace(expected)
+	return nil
+}
+
+func buildReadiness(path string, status int, fields readinessJSONList) (*state.Readiness, error) {
+	path = strings.TrimSpace(path)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid ready-path %q: must start with /", path)
+	}
+	if status != 0 && (status < 100 || status > 599) {
+		return nil, errors.New("ready-status must be between 100 and 599")
+	}
+	if path == "" && status == 0 && len(fields.Values) == 0 {
+		return nil, nil
+	}
+	return &state.Readiness{Path: path, Status: status, JSON: fields.Values}, nil
+}
+
+func gatewayReadiness(instance state.Instance) vm.HTTPReadiness {
+	readiness := vm.HTTPReadiness{}
+	if instance.Readiness != nil {
+		readiness.Path = instance.Readiness.Path
+		readiness.Status = instance.Readiness.Status
+		readiness.JSON = instance.Readiness.JSON
+		readiness.BearerToken = readGatewayCredential(instance.GatewayCredentialPath)
+	}
+	return readiness
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+type replicaResult struct {
+	Name   string
+	ClawID string
+	Port   int
+	Output string
+	Err    error
+}
+
+func takeCLIFlagValue(args []string, flagName string) (string, []string, bool) {
+	rest := make([]string, 0, len(args))
+	value := ""
+	found := false
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		switch {
+		case arg == flagName && index+1 < len(args):
+			value = args[index+1]
+			found = true
+			index++
+		case strings.HasPrefix(arg, flagName+"="):
+			value = strings.TrimPrefix(arg, flagName+"=")
+			found = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return value, rest, found
+}
+
+func (a *App) runReplicas(args []string, replicas int) error {
+	if hasCLIFlag(args, "--publish") || hasCLIFlag(args, "--port-forward") || hasCLIFlag(args, "--gateway") {
+		return errors.New("--publish and --gateway cannot be combined with --replicas: host ports would collide")
+	}
+	if hasCLIFlag(args, "--save-answers") {
+		return errors.New("--save-answers cannot be combined with --replicas")
+	}
+
+	baseName, args, _ := takeCLIFlagValue(args, "--name")
+	baseName = strings.TrimSpace(baseName)
+	if baseName == "" {
+		baseName = "claw"
+	}
+	basePort := defaultGatewayPort
+	portValue, args, hasPort := takeCLIFlagValue(args, "--port")
+	if hasPort {
+		parsed, err := strconv.Atoi(strings.TrimSpace(portValue))
+		if err != nil {
+			return fmt.Errorf("invalid --port %q: %w", portValue, err)
+		}
+		basePort = parsed
+	}
+	if basePort+replicas-1 > 65535 {
+		return fmt.Errorf("--port %d with --replicas %d exceeds port 65535", basePort, replicas)
+	}
+
+	fmt.Fprintf(a.out, "launching %d replicas of %s (gateway ports %d-%d)\n", replicas, baseName, basePort, basePort+replicas-1)
+	prepareLock := &sync.Mutex{}
+	results := make([]replicaResult, replicas)
+	var waitGroup sync.WaitGroup
+	for index := 0; index < replicas; index++ {
+		name := fmt.Sprintf("%s-%d", baseName, index+1)
+		port := basePort + index
+		replicaArgs := append([]string{"--name", name, "--port", strconv.Itoa(port)}, args...)
+
+		waitGroup.Add(1)
+		go func(index int, name string, port int, replicaArgs []string) {
+			defer waitGroup.Done()
+			var output bytes.Buffer
+			replica := *a
+			replica.out = &output
+			replica.errOut = &output
+			replica.in = nil
+			replica.prepareLock = prepareLock
+			instance, err := replica.runInstance(context.Background(), replicaArgs, index+1)
+			results[index] = replicaResult{
+				Name:   name,
+				ClawID: instance.ID,
+				Port:   port,
+				Output: output.String(),
+				Err:    err,
+			}
+		}(index, name, port, replicaArgs)
+	}
+	waitGroup.Wait()
+
+	failed := 0
+	for _, result := range results {
+		for _, line := range strings.Split(strings.TrimRight(result.Output, "\n"), "\n") {
+			if line != "" {
+				fmt.Fprintf(a.out, "[%s] %s\n", result.Name, line)
+			}
+		}
+		if result.Err != nil {
+			failed++
+		}
+	}
+
+	fmt.Fprintln(a.out, "")
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCLAWID\tGATEWAY\tRESULT")
+	for _, result := range results {
+		clawID := result.ClawID
+		if clawID == "" {
+			clawID = "-"
+		}
+		outcome := "ok"
+		if result.Err != nil {
+			outcome = "failed: " + result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t127.0.0.1:%d\t%s\n", result.Name, clawID, result.Port, outcome)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "replicas: %d/%d started\n", replicas-failed, replicas)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replicas failed", failed, replicas)
+	}
+	return nil
+}
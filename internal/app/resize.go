@@ -0,0 +1,101 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const resizeUsage = "usage: clawfarm resize <clawid> --disk-size <size, e.g. 40G>"
+
+func (a *App) runResize(args []string) error {
+	diskSize := ""
+	positionals := make([]string, 0, len(args))
+	for index := 0; index < len(args); index++ {
+		name, value, hasValue := strings.Cut(args[index], "=")
+		switch {
+		case name == "--disk-size":
+			if !hasValue {
+				if index+1 >= len(args) {
+					return errors.New("--disk-size requires a value")
+				}
+				index++
+				value = args[index]
+			}
+			diskSize = value
+		case strings.HasPrefix(name, "-"):
+			return fmt.Errorf("unknown resize flag %q", args[index])
+		default:
+			positionals = append(positionals, strings.TrimSpace(args[index]))
+		}
+	}
+	if len(positionals) != 1 || strings.TrimSpace(diskSize) == "" {
+		return errors.New(resizeUsage)
+	}
+	sizeBytes, err := diskutil.ParseSize(diskSize)
+	if err != nil {
+		return fmt.Errorf("invalid --disk-size: %w", err)
+	}
+
+	id := positionals[0]
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	err = lockManager.WithInstanceLock(id, func() error {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		if instance.PID > 0 && a.backend.IsRunning(instance.PID) {
+			return fmt.Errorf("instance %s is running; stop it before resizing its disk", id)
+		}
+		if strings.TrimSpace(instance.DiskPath) == "" {
+			return fmt.Errorf("instance %s has no disk path recorded", id)
+		}
+		if instance.DiskKeyPath != "" {
+			return fmt.Errorf("instance %s has an encrypted disk, which cannot be resized", id)
+		}
+		if err := growInstanceDisk(instance.DiskPath, sizeBytes); err != nil {
+			return err
+		}
+
+		instanceDir := filepath.Join(clawsRoot, id)
+		if spec, specErr := readInstanceStartSpec(instanceDir); specErr == nil {
+			spec.DiskSizeBytes = sizeBytes
+			if err := writeInstanceStartSpec(instanceDir, spec); err != nil {
+				return err
+			}
+		}
+		instance.DiskSizeBytes = sizeBytes
+		return store.Save(instance)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "%s disk grown to %s; the guest grows its root filesystem on next start\n", id, diskutil.HumanBytes(sizeBytes))
+	return nil
+}
+
+// growInstanceDisk enlarges an instance disk before boot. The guest's
+// cloud-init growpart then extends the root partition into the new space.
+func growInstanceDisk(diskPath string, sizeBytes int64) error {
+	if err := diskutil.Grow(diskPath, sizeBytes); err != nil {
+		if errors.Is(err, diskutil.ErrQEMUImgMissing) {
+			return errors.New("--disk-size needs qemu-img to grow the instance disk")
+		}
+		return err
+	}
+	return nil
+}
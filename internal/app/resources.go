@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const resourceWaitInterval = 5 * time.Second
+
+func (a *App) checkHostResources(diskPath string, memoryMiB int, wait bool) error {
+	if a.probeResources == nil {
+		return nil
+	}
+	request := vm.ResourceRequest{MemoryMiB: memoryMiB, DiskPath: diskPath}
+	announced := false
+	for {
+		resources, err := a.probeResources(diskPath)
+		if err != nil {
+			fmt.Fprintf(a.errOut, "warning: skip host resource check: %v\n", err)
+			return nil
+		}
+		shortfall := resources.Shortfall(request)
+		if shortfall == nil {
+			return nil
+		}
+		if !wait {
+			return fmt.Errorf("insufficient host resources (%v); lower --memory-mib, free space, or re-run with --wait-for-resources", shortfall)
+		}
+		if !announced {
+			fmt.Fprintf(a.out, "waiting for host resources: %v\n", shortfall)
+			announced = true
+		}
+		time.Sleep(resourceWaitInterval)
+	}
+}
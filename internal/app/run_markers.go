@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	runMarkerDir          = "/var/lib/clawfarm/run"
+	sshConnectionExitCode = 255
+	maxRunReconnects      = 3
+	runAsRoot             = "root"
+	runAsClaw             = "claw"
+)
+
+var errRescueTimeout = errors.New("rescue shell timed out")
+
+type runCommandOptions struct {
+	InstanceDir   string
+	RunAs         string
+	RescueTimeout time.Duration
+	SetRescue     func(active bool)
+	SSHTimeout    time.Duration
+}
+
+var sshReconnectDelays = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second}
+
+func runCommandMarker(index int, command string) string {
+	sum := sha256.Sum256([]byte(command))
+	return fmt.Sprintf("%03d-%s.done", index+1, hex.EncodeToString(sum[:])[:12])
+}
+
+func wrapRunCommand(index int, command string, runAs string) string {
+	body := fmt.Sprintf("(%s)", command)
+	if runAs != runAsRoot {
+		body = fmt.Sprintf("sudo -n -u %s -H bash -lc %s", runAs, shellSingleQuote("if [ -w /workspace ]; then cd /workspace; else cd ~; fi; "+command))
+	}
+	return fmt.Sprintf("mkdir -p %s && %s && touch %s",
+		shellSingleQuote(runMarkerDir),
+		body,
+		shellSingleQuote(runMarkerDir+"/"+runCommandMarker(index, command)),
+	)
+}
+
+func normalizeRunAs(value string) (string, error) {
+	switch strings.TrimSpace(value) {
+	case "", runAsRoot:
+		return runAsRoot, nil
+	case runAsClaw:
+		return runAsClaw, nil
+	default:
+		return "", fmt.Errorf("invalid --run-as %q: expected root or claw", value)
+	}
+}
+
+func parseRunMarkers(output string) map[string]bool {
+	markers := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		name := strings.TrimSpace(line)
+		if strings.HasSuffix(name, ".done") {
+			markers[name] = true
+		}
+	}
+	return markers
+}
+
+func runCommandLogPath(instanceDir string, index int, command string) string {
+	return filepath.Join(instanceDir, "run-logs", fmt.Sprintf("%02d-%s.log", index+1, runCommandSlug(command)))
+}
+
+func runCommandSlug(command string) string {
+	var builder strings.Builder
+	lastDash := true
+	for _, r := range strings.ToLower(command) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+			lastDash = false
+		} else if !lastDash {
+			builder.WriteByte('-')
+			lastDash = true
+		}
+		if builder.Len() >= 40 {
+			break
+		}
+	}
+	slug := strings.Trim(builder.String(), "-")
+	if slug == "" {
+		return "command"
+	}
+	return slug
+}
+
+func (a *App) runLoggedSSHCommand(instance state.Instance, index int, command string, runAs string, logPath string) error {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	fmt.Fprintf(logFile, "# %s run[%d] as %s: %s\n", time.Now().UTC().Format(time.RFC3339), index+1, runAs, command)
+	runErr := a.runSSHCommand(instance, wrapRunCommand(index, command, runAs), true, logFile)
+	if runErr != nil {
+		fmt.Fprintf(logFile, "# exit: %v\n", runErr)
+	} else {
+		fmt.Fprintln(logFile, "# exit: 0")
+	}
+	return runErr
+}
+
+func isSSHConnectionError(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == sshConnectionExitCode
+}
+
+func (a *App) completedRunMarkers(sshHostPort int, sshPrivateKeyPath string, sshUser string) (map[string]bool, error) {
+	listCommand := fmt.Sprintf("ls -1 %s 2>/dev/null || true", shellSingleQuote(runMarkerDir))
+	args := append(sshBaseArgs(sshHostPort, sshPrivateKeyPath), "-T", sshDestination(sshUser), listCommand)
+	output, err := exec.Command("ssh", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("list run markers: %w", err)
+	}
+	return parseRunMarkers(string(output)), nil
+}
+
+func (a *App) reconnectSSH(ctx context.Context, sshHostPort int, sshPrivateKeyPath string, sshUser string) error {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		delay := sshReconnectDelays[len(sshReconnectDelays)-1]
+		if attempt < len(sshReconnectDelays) {
+			delay = sshReconnectDelays[attempt]
+		}
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+		case <-time.After(delay):
+		}
+
+		if err := runSSHProbe(sshHostPort, sshPrivateKeyPath, sshUser); err != nil {
+			lastErr = err
+			fmt.Fprintf(a.out, "run: reconnect attempt %d failed; retrying\n", attempt+1)
+			continue
+		}
+		return nil
+	}
+}
@@ -0,0 +1,494 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+// runLaunch is one `clawfarm run` after its flags, clawbox and image are
+// settled. runInstance fills in the inputs; each phase below reads them and
+// leaves what it built (disk paths, keys, the start spec) for the next one.
+// spec starts with every field known before the instance lock is taken.
+type runLaunch struct {
+	id                string
+	ref               string
+	clawsRoot         string
+	instanceDir       string
+	instanceImagePath string
+	target            runTarget
+	prepared          preparedRunTarget
+	imageMeta         images.Metadata
+	spec              vm.StartSpec
+
+	volumeMappings     []volumeMapping
+	gateways           []state.Gateway
+	readiness          *state.Readiness
+	publishedPorts     []state.PortMapping
+	extraHosts         []state.HostEntry
+	ignoredRequiredEnv []string
+	gatewayAuth        string
+	encryptDisk        bool
+	diskKeyFile        string
+	workspaceWatch     bool
+	preStartHooks      []string
+	postReadyHooks     []string
+
+	openClawPin          openClawPin
+	openClawIntegrity    string
+	openClawBundleSHA256 string
+	clawboxOpenClawEntry string
+
+	needsSSH      bool
+	runCommands   []string
+	runAs         string
+	rescueTimeout time.Duration
+	sshTimeout    time.Duration
+	noWait        bool
+	ciMode        bool
+	readyTimeout  time.Duration
+	waitTargets   []waitTarget
+
+	sshHostPort           int
+	sshKeyPath            string
+	gatewayCredentialPath string
+	startResult           vm.StartResult
+	instance              state.Instance
+}
+
+func (l *runLaunch) hookContext() hookContext {
+	return hookContext{
+		ClawID:        l.id,
+		InstanceDir:   l.instanceDir,
+		ImageRef:      l.ref,
+		WorkspacePath: l.spec.WorkspacePath,
+		GatewayPort:   l.spec.GatewayHostPort,
+		SSHHostPort:   l.sshHostPort,
+		PID:           l.startResult.PID,
+	}
+}
+
+// prepareRunGuest creates the volume directories and, when anything needs
+// ssh, picks the forwarded port and the instance key pair.
+func (a *App) prepareRunGuest(launch *runLaunch) error {
+	launch.spec.VolumeMounts = make([]vm.VolumeMount, 0, len(launch.volumeMappings))
+	for _, volume := range launch.volumeMappings {
+		hostVolumePath := filepath.Join(launch.instanceDir, "volumes", volume.Name)
+		if err := ensureDir(hostVolumePath); err != nil {
+			return err
+		}
+		launch.spec.VolumeMounts = append(launch.spec.VolumeMounts, vm.VolumeMount{
+			Name:      volume.Name,
+			HostPath:  hostVolumePath,
+			GuestPath: volume.GuestPath,
+		})
+	}
+
+	if !launch.needsSSH {
+		return nil
+	}
+	sshHostPort, err := findAvailableLoopbackPort()
+	if err != nil {
+		return err
+	}
+	launch.sshHostPort = sshHostPort
+	launch.spec.PublishedPorts = append(launch.spec.PublishedPorts, vm.PortMapping{HostPort: sshHostPort, GuestPort: 22})
+
+	keyPath, publicKey, err := generateInstanceSSHKeyPair(launch.instanceDir)
+	if err != nil {
+		return err
+	}
+	launch.sshKeyPath = keyPath
+	launch.spec.SSHAuthorizedKeys = append(launch.spec.SSHAuthorizedKeys, publicKey)
+	return nil
+}
+
+// prepareRunImage lays down the instance disk: imported from a v2 clawbox,
+// or an overlay (a full copy when host provisioning will write to it) of the
+// runtime image.
+func (a *App) prepareRunImage(launch *runLaunch) error {
+	launch.spec.SourceDiskPath = launch.instanceImagePath
+	if launch.target.ClawboxV2Mode && launch.target.ClawboxV2Spec != nil {
+		diskPath, err := importRunClawboxV2(launch.target, launch.id, launch.clawsRoot, launch.imageMeta.RuntimeDisk, a.out)
+		if err != nil {
+			return err
+		}
+		launch.spec.SourceDiskPath = diskPath
+		if clawDir := filepath.Join(launch.clawsRoot, launch.id, "claw"); dirExists(clawDir) {
+			launch.spec.ClawPath = clawDir
+		}
+		launch.spec.CloudInitProvision = launch.target.ClawboxV2Spec.provisionScripts()
+		return nil
+	}
+	launch.spec.CloudInitProvision = append([]string{}, launch.prepared.GuestProvisionCommands...)
+	return a.prepareInstanceImage(launch.imageMeta.RuntimeDisk, launch.instanceImagePath, len(launch.prepared.ProvisionCommands) > 0)
+}
+
+// provisionRunDisk runs the host-side provision commands against the disk
+// and grows it to --disk-size.
+func (a *App) provisionRunDisk(ctx context.Context, launch *runLaunch) error {
+	if err := a.runProvisionCommands(ctx, launch.instanceDir, launch.imageMeta.RuntimeDisk, launch.instanceImagePath, launch.prepared.LayerPaths, launch.prepared.ProvisionCommands); err != nil {
+		return err
+	}
+	if launch.spec.DiskSizeBytes > 0 {
+		return growInstanceDisk(launch.spec.SourceDiskPath, launch.spec.DiskSizeBytes)
+	}
+	return nil
+}
+
+func encryptRunDisk(launch *runLaunch) error {
+	if !launch.encryptDisk {
+		return nil
+	}
+	keyPath, err := ensureInstanceDiskKey(launch.clawsRoot, launch.id, launch.diskKeyFile)
+	if err != nil {
+		return err
+	}
+	if err := vm.EncryptDisk(launch.spec.SourceDiskPath, keyPath); err != nil {
+		return err
+	}
+	launch.spec.DiskKeyPath = keyPath
+	return nil
+}
+
+// runPreStartPhase writes the gateway credential the hooks may read and then
+// runs the pre-start hooks.
+func (a *App) runPreStartPhase(ctx context.Context, launch *runLaunch) error {
+	credentialPath, err := writeGatewayCredential(launch.instanceDir, launch.gatewayAuth, launch.spec.OpenClawEnvironment)
+	if err != nil {
+		return err
+	}
+	launch.gatewayCredentialPath = credentialPath
+	return a.runHooks(ctx, hookPreStart, launch.preStartHooks, launch.hookContext())
+}
+
+// stageRunOpenClaw resolves an OpenClaw package shipped inside the clawbox
+// and stages the pinned tarball into the instance directory.
+func stageRunOpenClaw(launch *runLaunch) error {
+	if launch.clawboxOpenClawEntry != "" {
+		extractedPath := filepath.Join(launch.instanceDir, openClawPackageDir, filepath.Base(filepath.FromSlash(launch.clawboxOpenClawEntry)))
+		if err := extractClawboxEntry(launch.target.ClawboxPath, launch.clawboxOpenClawEntry, extractedPath); err != nil {
+			return err
+		}
+		if launch.openClawBundleSHA256 != "" {
+			if err := diskutil.VerifySHA256(extractedPath, launch.openClawBundleSHA256); err != nil {
+				return fmt.Errorf("openclaw.bundle: %w", err)
+			}
+		}
+		pin, err := resolveOpenClawPin(extractedPath, launch.openClawIntegrity)
+		if err != nil {
+			return err
+		}
+		if launch.openClawBundleSHA256 != "" {
+			pin.Package.Source = openClawSourceBundle
+		}
+		launch.openClawPin = pin
+	}
+	tarballPath, err := launch.openClawPin.stage(launch.instanceDir)
+	if err != nil {
+		return err
+	}
+	launch.spec.OpenClawPackage = launch.openClawPin.Package.Spec
+	launch.spec.OpenClawTarballPath = tarballPath
+	launch.spec.OpenClawIntegrity = launch.openClawPin.Package.Integrity
+	launch.spec.OpenClawOffline = launch.openClawBundleSHA256 != ""
+	return nil
+}
+
+// bootRunInstance starts the VM, records its pid on the lease and saves the
+// instance. A VM that started but could not be recorded is stopped again.
+func (a *App) bootRunInstance(ctx context.Context, store *state.Store, lockManager *state.LockManager, launch *runLaunch) error {
+	if launch.workspaceWatch {
+		launch.spec.WatchPath = workspaceWatchDir(launch.instanceDir)
+		if err := ensureDir(launch.spec.WatchPath); err != nil {
+			return err
+		}
+	}
+	if err := stageRunOpenClaw(launch); err != nil {
+		return err
+	}
+
+	spec := launch.spec
+	result, err := a.backend.Start(ctx, spec)
+	if err != nil {
+		return err
+	}
+	launch.startResult = result
+	a.markBootPhase(bootPhasePIDFile)
+	stopVM := func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 40*time.Second)
+		defer cancel()
+		_ = a.backend.Stop(stopCtx, vm.Process{PID: result.PID, Backend: spec.Backend, MonitorPath: result.MonitorPath})
+	}
+	if err := lockManager.AcquireWhileLocked(context.Background(), state.AcquireRequest{
+		ClawID:     launch.id,
+		InstanceID: launch.id,
+		PID:        result.PID,
+	}); err != nil {
+		stopVM()
+		return err
+	}
+
+	hostAlias := vm.HostAlias(spec.ExtraHosts)
+	now := time.Now().UTC()
+	instance := state.Instance{
+		ID:                    launch.id,
+		ImageRef:              launch.ref,
+		WorkspacePath:         spec.WorkspacePath,
+		StatePath:             spec.StatePath,
+		StateMode:             spec.StateMode,
+		Gateways:              launch.gateways,
+		Readiness:             launch.readiness,
+		PublishedPorts:        launch.publishedPorts,
+		ExtraHosts:            launch.extraHosts,
+		HostAlias:             &state.HostEntry{Name: hostAlias.Name, IP: hostAlias.IP},
+		DNSServers:            spec.DNSServers,
+		CronJobs:              spec.CronJobs,
+		Volumes:               persistedVolumeMounts(spec.VolumeMounts),
+		ShareOwnership:        spec.ShareOwnership,
+		InjectedEnv:           sortedEnvKeys(spec.OpenClawEnvironment),
+		IgnoredRequiredEnv:    launch.ignoredRequiredEnv,
+		OpenClawPackage:       &launch.openClawPin.Package,
+		Blobs:                 launch.prepared.BlobDigests,
+		Status:                "booting",
+		Backend:               spec.Backend,
+		PID:                   result.PID,
+		DiskPath:              result.DiskPath,
+		DiskKeyPath:           spec.DiskKeyPath,
+		DiskSizeBytes:         spec.DiskSizeBytes,
+		RootfsMode:            spec.RootfsMode,
+		Hardened:              spec.Hardened,
+		DirtyShutdown:         spec.UncleanShutdown,
+		GatewayAuth:           launch.gatewayAuth,
+		GatewayCredentialPath: launch.gatewayCredentialPath,
+		SeedISOPath:           result.SeedISOPath,
+		SerialLogPath:         result.SerialLogPath,
+		QEMULogPath:           result.QEMULogPath,
+		MonitorPath:           result.MonitorPath,
+		SSHHostPort:           launch.sshHostPort,
+		SSHKeyPath:            launch.sshKeyPath,
+		GuestUser:             spec.GuestUser.Name,
+		GuestSudo:             spec.GuestUser.Sudo,
+		WorkspaceWatch:        launch.workspaceWatch,
+		QEMUAccel:             result.Accel,
+		QEMUCommand:           result.Command,
+		BootMemoryMiB:         spec.MemoryMiB,
+		CreatedAtUTC:          now,
+		UpdatedAtUTC:          now,
+	}
+	if launch.noWait {
+		instance.Status = "running"
+	}
+	if err := store.Save(instance); err != nil {
+		stopVM()
+		return err
+	}
+	launch.instance = instance
+	return nil
+}
+
+// startRunServices runs once the instance is saved: it keeps the start spec
+// for `clawfarm start`, starts the workspace watcher and CI supervisor and
+// runs the --run commands.
+func (a *App) startRunServices(store *state.Store, launch *runLaunch) error {
+	instance := &launch.instance
+	if err := writeInstanceStartSpec(launch.instanceDir, launch.spec); err != nil {
+		fmt.Fprintf(a.errOut, "warning: %s cannot be restarted with clawfarm start: %v\n", launch.id, err)
+	}
+	if launch.workspaceWatch && a.startWatcher != nil {
+		watchPID, watchErr := a.startWatcher(a.dirs, launch.id, launch.instanceDir)
+		if watchErr != nil {
+			fmt.Fprintf(a.errOut, "warning: workspace watch not started: %v\n", watchErr)
+		} else {
+			instance.WatchPID = watchPID
+			if err := store.Save(*instance); err != nil {
+				return err
+			}
+		}
+	}
+	if launch.ciMode && a.startSupervisor != nil {
+		if _, superviseErr := a.startSupervisor(a.dirs, launch.id, launch.instanceDir); superviseErr != nil {
+			fmt.Fprintf(a.errOut, "warning: ci supervisor not started; %s may outlive a killed job: %v\n", launch.id, superviseErr)
+		}
+	}
+
+	if !launch.needsSSH {
+		return nil
+	}
+	statusBeforeRescue := instance.Status
+	setRescue := func(active bool) {
+		if active {
+			statusBeforeRescue = instance.Status
+			instance.Status = "rescue"
+		} else {
+			instance.Status = statusBeforeRescue
+		}
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if saveErr := store.Save(*instance); saveErr != nil {
+			fmt.Fprintf(a.errOut, "warning: save instance status %s: %v\n", instance.Status, saveErr)
+		}
+	}
+	if err := a.runCommandsViaSSH(*instance, launch.runCommands, runCommandOptions{
+		InstanceDir:   launch.instanceDir,
+		RunAs:         launch.runAs,
+		RescueTimeout: launch.rescueTimeout,
+		SetRescue:     setRescue,
+		SSHTimeout:    launch.sshTimeout,
+	}); err != nil {
+		instance.Status = "unhealthy"
+		instance.LastError = err.Error()
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if saveErr := store.Save(*instance); saveErr != nil {
+			return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *App) printRunSummary(launch *runLaunch) {
+	instance := launch.instance
+	spec := launch.spec
+	fmt.Fprintf(a.out, "image: %s (%s)\n", launch.ref, launch.imageMeta.Arch)
+	if spec.NoWorkspace {
+		fmt.Fprintln(a.out, "workspace: none (guest /workspace is empty)")
+	} else {
+		fmt.Fprintf(a.out, "workspace: %s\n", spec.WorkspacePath)
+	}
+	switch spec.StateMode {
+	case vm.StateModeDisk:
+		fmt.Fprintln(a.out, "state: inside the instance disk (/root/.openclaw)")
+	case vm.StateModeNone:
+		fmt.Fprintln(a.out, "state: ephemeral (discarded on shutdown)")
+	default:
+		fmt.Fprintf(a.out, "state: %s\n", spec.StatePath)
+	}
+	if instance.WatchPID > 0 {
+		fmt.Fprintf(a.out, "workspace watch: relaying host changes (pid %d)\n", instance.WatchPID)
+	}
+	fmt.Fprintf(a.out, "gateway: http://127.0.0.1:%d/\n", spec.GatewayHostPort)
+	for _, gateway := range extraGateways(instance) {
+		fmt.Fprintf(a.out, "gateway %s: %s (guest port %d)\n", gateway.Name, gatewayURL(gateway), gateway.GuestPort)
+	}
+	fmt.Fprintf(a.out, "vm pid: %d\n", launch.startResult.PID)
+	fmt.Fprintf(a.out, "serial log: %s\n", launch.startResult.SerialLogPath)
+	if instance.DiskKeyPath != "" {
+		fmt.Fprintf(a.out, "disk: encrypted (key: %s)\n", instance.DiskKeyPath)
+	}
+	if instance.RootfsMode == vm.RootfsReadOnlyOverlay {
+		fmt.Fprintln(a.out, "rootfs: read-only (guest writes are discarded on shutdown)")
+	}
+	if instance.DirtyShutdown {
+		fmt.Fprintln(a.out, "last shutdown: not graceful (disk checked; the guest reports any filesystem errors the kernel recorded)")
+	}
+	if instance.Hardened {
+		if spec.QEMUUser != "" {
+			fmt.Fprintf(a.out, "sandbox: qemu seccomp on, running as %s\n", spec.QEMUUser)
+		} else {
+			fmt.Fprintln(a.out, "sandbox: qemu seccomp on")
+		}
+	}
+	for _, mapping := range instance.PublishedPorts {
+		fmt.Fprintf(a.out, "publish: 127.0.0.1:%d -> %d\n", mapping.HostPort, mapping.GuestPort)
+	}
+	for _, volume := range launch.volumeMappings {
+		hostVolumePath := filepath.Join(launch.instanceDir, "volumes", volume.Name)
+		fmt.Fprintf(a.out, "volume: %s -> %s\n", hostVolumePath, volume.GuestPath)
+	}
+	if instance.HostAlias != nil {
+		fmt.Fprintf(a.out, "host alias: %s -> %s (this machine, from the guest)\n", instance.HostAlias.Name, instance.HostAlias.IP)
+	}
+	if instance.OpenClawPackage != nil {
+		fmt.Fprintf(a.out, "openclaw: %s\n", describeOpenClawPackage(instance.OpenClawPackage))
+	}
+	for _, entry := range instance.ExtraHosts {
+		fmt.Fprintf(a.out, "host: %s -> %s\n", entry.Name, entry.IP)
+	}
+	if len(instance.DNSServers) > 0 {
+		fmt.Fprintf(a.out, "dns: %s\n", strings.Join(instance.DNSServers, ", "))
+	}
+	if launch.needsSSH {
+		fmt.Fprintf(a.out, "ssh: %s:%d\n", sshDestination(spec.GuestUser.Name), launch.sshHostPort)
+	}
+}
+
+// waitForRunReady waits for the gateways and --wait-for targets, marks the
+// instance ready and runs the post-ready hooks. A target that never comes up
+// leaves the instance unhealthy with the reason saved.
+func (a *App) waitForRunReady(ctx context.Context, store *state.Store, launch *runLaunch) error {
+	instance := &launch.instance
+	if launch.noWait {
+		fmt.Fprintln(a.out, "status: running (not waiting for gateway readiness)")
+		if len(launch.postReadyHooks) > 0 {
+			fmt.Fprintln(a.errOut, "warning: post-ready hooks skipped because --no-wait was set")
+		}
+		return nil
+	}
+
+	markUnhealthy := func(status string, lastError string, err error) error {
+		instance.Status = status
+		instance.LastError = lastError
+		instance.UpdatedAtUTC = time.Now().UTC()
+		if saveErr := store.Save(*instance); saveErr != nil {
+			return fmt.Errorf("%w (also failed to save instance state: %v)", err, saveErr)
+		}
+		return nil
+	}
+
+	httpURL := fmt.Sprintf("http://127.0.0.1:%d/", launch.spec.GatewayHostPort)
+	waitCtx, cancel := context.WithTimeout(ctx, launch.readyTimeout)
+	defer cancel()
+	if err := vm.WaitForHTTPReady(waitCtx, httpURL, gatewayReadiness(*instance)); err != nil {
+		status, lastError := "unhealthy", err.Error()
+		if errors.Is(err, vm.ErrGatewayInstallFailed) {
+			status, lastError = instanceStatusInstallFailed, installFailedError(*instance)
+		}
+		if saveErr := markUnhealthy(status, lastError, err); saveErr != nil {
+			return saveErr
+		}
+		return fmt.Errorf("gateway is not reachable yet at %s (%v); check %s", httpURL, err, instance.SerialLogPath)
+	}
+	if err := waitForExtraGateways(waitCtx, instance); err != nil {
+		if saveErr := markUnhealthy("unhealthy", err.Error(), err); saveErr != nil {
+			return saveErr
+		}
+		return err
+	}
+	a.markBootPhase(bootPhaseGateway)
+
+	readyTargets := []string{httpURL}
+	for _, gateway := range extraGateways(*instance) {
+		readyTargets = append(readyTargets, gateway.Name+" "+gatewayURL(gateway))
+	}
+	for _, target := range launch.waitTargets {
+		if err := waitForTarget(waitCtx, *instance, target); err != nil {
+			if saveErr := markUnhealthy("unhealthy", fmt.Sprintf("wait-for %s: %v", target.Label, err), err); saveErr != nil {
+				return saveErr
+			}
+			return fmt.Errorf("readiness target %s is not reachable yet (%v); check %s", target.Label, err, instance.SerialLogPath)
+		}
+		readyTargets = append(readyTargets, target.Label)
+	}
+
+	instance.Status = "ready"
+	instance.LastError = ""
+	instance.UpdatedAtUTC = time.Now().UTC()
+	if err := store.Save(*instance); err != nil {
+		return err
+	}
+	bootDuration := instance.UpdatedAtUTC.Sub(instance.CreatedAtUTC)
+	a.updateStats(func(stats *state.HostStats) {
+		stats.RecordBoot(bootDuration)
+	})
+
+	fmt.Fprintf(a.out, "status: ready (%s)\n", strings.Join(readyTargets, ", "))
+	return a.runHooks(ctx, hookPostReady, launch.postReadyHooks, launch.hookContext())
+}
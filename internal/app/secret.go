@@ -0,0 +1,110 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const secretIndexFileName = "keychain_secrets.json"
+
+func (a *App) runSecret(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: clawfarm secret <ls|rm>")
+	}
+	if a.keychain == nil {
+		return errors.New("no OS keychain available (needs macOS security or Linux secret-tool)")
+	}
+	index, err := a.secretIndex()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "ls":
+		if len(args) != 1 {
+			return errors.New("usage: clawfarm secret ls")
+		}
+		names, err := index.List()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KEY\tSTORE")
+		for _, name := range names {
+			fmt.Fprintf(tw, "%s\t%s\n", name, a.keychain.Name())
+		}
+		return tw.Flush()
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("usage: clawfarm secret rm <KEY>")
+		}
+		name := strings.ToUpper(strings.TrimSpace(args[1]))
+		known, err := index.Remove(name)
+		if err != nil {
+			return err
+		}
+		if err := a.keychain.Delete(name); err != nil && !known {
+			return fmt.Errorf("secret %s not found", name)
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "removed %s from %s\n", name, a.keychain.Name())
+		return nil
+	default:
+		return fmt.Errorf("unknown secret subcommand %q", args[0])
+	}
+}
+
+func (a *App) secretIndex() (*state.SecretIndex, error) {
+	clawsRoot, err := a.clawsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return state.NewSecretIndex(filepath.Join(filepath.Dir(clawsRoot), secretIndexFileName)), nil
+}
+
+func (a *App) lookupKeychainSecret(input openClawInput) (string, bool) {
+	if a.keychain == nil || !input.Secret || input.EnvKey == "" {
+		return "", false
+	}
+	value, found, err := a.keychain.Get(input.EnvKey)
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: read %s from %s: %v\n", input.EnvKey, a.keychain.Name(), err)
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	if !found || value == "" {
+		return "", false
+	}
+	fmt.Fprintf(a.out, "openclaw: using %s from %s\n", input.EnvKey, a.keychain.Name())
+	return value, true
+}
+
+func (a *App) offerKeychainStore(reader *bufio.Reader, input openClawInput, value string) {
+	if a.keychain == nil || !input.Secret || input.EnvKey == "" {
+		return
+	}
+	fmt.Fprintf(a.out, "openclaw> store %s in %s for future runs? [y/N]: ", input.EnvKey, a.keychain.Name())
+	line, _ := reader.ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	if answer != "y" && answer != "yes" {
+		return
+	}
+	if err := a.keychain.Set(input.EnvKey, value); err != nil {
+		fmt.Fprintf(a.errOut, "warning: store %s in %s: %v\n", input.EnvKey, a.keychain.Name(), err)
+		return
+	}
+	index, err := a.secretIndex()
+	if err == nil {
+		err = index.Add(input.EnvKey)
+	}
+	if err != nil {
+		fmt.Fprintf(a.errOut, "warning: record %s in secret index: %v\n", input.EnvKey, err)
+	}
+}
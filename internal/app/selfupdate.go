@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/fetch"
+)
+
+var Version = "dev"
+
+// releasePublicKey is the base64 ed25519 key that signs checksums.txt of every
+// release. Release builds inject it with
+// -X github.com/yazhou/krunclaw/internal/app.releasePublicKey=<key>; builds
+// without it refuse to self-update.
+var releasePublicKey = ""
+
+const (
+	releaseChecksumsAsset = "checksums.txt"
+	releaseSignatureAsset = "checksums.txt.sig"
+	releaseRequestTimeout = 2 * time.Minute
+)
+
+var packageManagedPrefixes = map[string][]string{
+	"Homebrew": {"/opt/homebrew/", "/usr/local/Cellar/", "/home/linuxbrew/.linuxbrew/"},
+	"apt":      {"/usr/bin/", "/usr/sbin/"},
+}
+
+var packageManagerUpgradeHints = map[string]string{
+	"Homebrew": "brew upgrade clawfarm",
+	"apt":      "sudo apt update && sudo apt install --only-upgrade clawfarm",
+}
+
+type releaseInfo struct {
+	TagName string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (release releaseInfo) asset(name string) (releaseAsset, bool) {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+func releaseBinaryAsset() string {
+	return fmt.Sprintf("clawfarm_%s_%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func (a *App) runVersion(args []string) error {
+	check := false
+	for _, arg := range args {
+		if arg != "--check" {
+			return errors.New("usage: clawfarm version [--check]")
+		}
+		check = true
+	}
+	fmt.Fprintf(a.out, "clawfarm %s (%s/%s)\n", Version, runtime.GOOS, runtime.GOARCH)
+	if !check {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseRequestTimeout)
+	defer cancel()
+	release, err := fetchLatestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if !releaseIsNewer(release.TagName, Version) {
+		fmt.Fprintf(a.out, "up to date (latest release %s)\n", release.TagName)
+		return nil
+	}
+	fmt.Fprintf(a.out, "newer release available: %s\n", release.TagName)
+	if release.HTMLURL != "" {
+		fmt.Fprintf(a.out, "  %s\n", release.HTMLURL)
+	}
+	fmt.Fprintln(a.out, "  update with: clawfarm self-update")
+	return nil
+}
+
+func (a *App) runSelfUpdate(args []string) error {
+	force := false
+	for _, arg := range args {
+		if arg != "--force" {
+			return errors.New("usage: clawfarm self-update [--force]")
+		}
+		force = true
+	}
+	if a.executable == nil {
+		return errors.New("self-update is not available in this build")
+	}
+	executablePath, err := a.executable()
+	if err != nil {
+		return err
+	}
+	if resolved, resolveErr := filepath.EvalSymlinks(executablePath); resolveErr == nil {
+		executablePath = resolved
+	}
+	if manager := packageManagerFor(executablePath); manager != "" && !force {
+		return fmt.Errorf("%s is managed by %s; upgrade with `%s` (or pass --force to replace it anyway)", executablePath, manager, packageManagerUpgradeHints[manager])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseRequestTimeout)
+	defer cancel()
+	release, err := fetchLatestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if !releaseIsNewer(release.TagName, Version) && !force {
+		fmt.Fprintf(a.out, "clawfarm %s is up to date\n", Version)
+		return nil
+	}
+
+	binaryName := releaseBinaryAsset()
+	binaryAsset, ok := release.asset(binaryName)
+	if !ok {
+		return fmt.Errorf("release %s has no %s asset", release.TagName, binaryName)
+	}
+	checksumsAsset, ok := release.asset(releaseChecksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s; refusing to install an unverified binary", release.TagName, releaseChecksumsAsset)
+	}
+	checksums, err := fetchReleaseText(ctx, checksumsAsset.URL)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", releaseChecksumsAsset, err)
+	}
+	if err := a.verifyReleaseSignature(ctx, release, checksums); err != nil {
+		return err
+	}
+	expectedSHA, err := releaseChecksum(checksums, binaryName)
+	if err != nil {
+		return err
+	}
+
+	temporary, err := os.CreateTemp(filepath.Dir(executablePath), ".clawfarm-update-*")
+	if err != nil {
+		return fmt.Errorf("prepare update next to %s: %w", executablePath, err)
+	}
+	temporaryPath := temporary.Name()
+	temporary.Close()
+	defer os.Remove(temporaryPath)
+
+	fmt.Fprintf(a.out, "downloading clawfarm %s\n", release.TagName)
+	if err := fetch.Download(ctx, binaryAsset.URL, temporaryPath, fetch.Options{SHA256: expectedSHA, Retries: fetch.DefaultRetries}); err != nil {
+		return fmt.Errorf("download %s: %w", binaryName, err)
+	}
+	if err := os.Chmod(temporaryPath, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(temporaryPath, executablePath); err != nil {
+		return fmt.Errorf("replace %s: %w", executablePath, err)
+	}
+	fmt.Fprintf(a.out, "updated %s: %s -> %s\n", executablePath, Version, release.TagName)
+	return nil
+}
+
+func (a *App) verifyReleaseSignature(ctx context.Context, release releaseInfo, checksums string) error {
+	if releasePublicKey == "" {
+		return fmt.Errorf("this build has no release signing key, so release %s cannot be verified; install it from %s instead", release.TagName, release.HTMLURL)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid release signing key in this build")
+	}
+	signatureAsset, ok := release.asset(releaseSignatureAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s; refusing to install an unsigned release", release.TagName, releaseSignatureAsset)
+	}
+	encodedSignature, err := fetchReleaseText(ctx, signatureAsset.URL)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", releaseSignatureAsset, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedSignature))
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", releaseSignatureAsset, err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), []byte(checksums), signature) {
+		return fmt.Errorf("signature check failed for release %s", release.TagName)
+	}
+	return nil
+}
+
+func fetchLatestRelease(ctx context.Context) (releaseInfo, error) {
+	body, err := fetchReleaseText(ctx, config.ReleaseURL())
+	if err != nil {
+		return releaseInfo{}, fmt.Errorf("check latest release: %w", err)
+	}
+	var release releaseInfo
+	if err := json.Unmarshal([]byte(body), &release); err != nil {
+		return releaseInfo{}, fmt.Errorf("parse latest release: %w", err)
+	}
+	if strings.TrimSpace(release.TagName) == "" {
+		return releaseInfo{}, errors.New("latest release has no tag")
+	}
+	return release, nil
+}
+
+func fetchReleaseText(ctx context.Context, rawURL string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request failed with status %s", response.Status)
+	}
+	payload, err := io.ReadAll(io.LimitReader(response.Body, 4<<20))
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+func releaseChecksum(checksums string, name string) (string, error) {
+	for _, line := range strings.Split(checksums, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s does not list %s", releaseChecksumsAsset, name)
+}
+
+func packageManagerFor(executablePath string) string {
+	for _, manager := range []string{"Homebrew", "apt"} {
+		for _, prefix := range packageManagedPrefixes[manager] {
+			if strings.HasPrefix(executablePath, prefix) {
+				return manager
+			}
+		}
+	}
+	return ""
+}
+
+func releaseIsNewer(latest string, current string) bool {
+	latestParts, ok := parseReleaseVersion(latest)
+	if !ok {
+		return false
+	}
+	currentParts, ok := parseReleaseVersion(current)
+	if !ok {
+		return true
+	}
+	for index := range latestParts {
+		if latestParts[index] != currentParts[index] {
+			return latestParts[index] > currentParts[index]
+		}
+	}
+	return false
+}
+
+func parseReleaseVersion(value string) ([3]int, bool) {
+	var parts [3]int
+	value = strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if cut, _, found := strings.Cut(value, "-"); found {
+		value = cut
+	}
+	fields := strings.Split(value, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+	for index, field := range fields {
+		number, err := strconv.Atoi(field)
+		if err != nil || number < 0 {
+			return parts, false
+		}
+		parts[index] = number
+	}
+	return parts, true
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (a *App) runSSH(args []string) error {
+	forceTTY := false
+	id := ""
+	command := []string{}
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if id != "" {
+			if arg == "--" && len(command) == 0 {
+				continue
+			}
+			command = append(command, arg)
+			continue
+		}
+		switch {
+		case arg == "-t" || arg == "--tty":
+			forceTTY = true
+		case strings.HasPrefix(arg, "-"):
+			return errors.New("usage: clawfarm ssh [-t] <clawid> [command...]")
+		default:
+			id = arg
+		}
+	}
+	if id == "" {
+		return errors.New("usage: clawfarm ssh [-t] <clawid> [command...]")
+	}
+
+	instance, err := a.loadSSHReadyInstance(id)
+	if err != nil {
+		return err
+	}
+
+	sshArgs := sshBaseArgs(instance.SSHHostPort, instance.SSHKeyPath)
+	if forceTTY || (len(command) == 0 && a.promptInputFile() != nil) {
+		sshArgs = append(sshArgs, "-tt")
+	} else {
+		sshArgs = append(sshArgs, "-T")
+	}
+	sshArgs = append(sshArgs, sshDestination(instance.SSHUser()))
+	if len(command) > 0 {
+		sshArgs = append(sshArgs, strings.Join(command, " "))
+	}
+
+	return a.runSSHSession(id, sshArgs)
+}
+
+func (a *App) runSSHSession(id string, sshArgs []string) error {
+	sshCommand := exec.Command("ssh", sshArgs...)
+	sshCommand.Stdin = a.in
+	sshCommand.Stdout = a.out
+	sshCommand.Stderr = a.errOut
+	if err := sshCommand.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() != sshConnectionExitCode {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("ssh to %s failed: %w", id, err)
+	}
+	return nil
+}
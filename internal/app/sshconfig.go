@@ -0,0 +1,148 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	sshConfigFileName  = "ssh_config"
+	sshHostAliasPrefix = "clawfarm-"
+)
+
+func sshHostAlias(clawID string) string {
+	return sshHostAliasPrefix + clawID
+}
+
+func (a *App) runSSHConfig(args []string) error {
+	install := false
+	for _, arg := range args {
+		if arg != "--install" {
+			return errors.New("usage: clawfarm ssh-config [--install]")
+		}
+		install = true
+	}
+	if !install {
+		store, _, err := a.instanceStore()
+		if err != nil {
+			return err
+		}
+		instances, err := store.List()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(a.out, renderSSHConfig(instances))
+		return err
+	}
+
+	configPath, err := a.writeSSHConfig()
+	if err != nil {
+		return err
+	}
+	added, err := ensureSSHConfigInclude(configPath)
+	if err != nil {
+		return err
+	}
+	if added {
+		fmt.Fprintf(a.out, "added `Include %s` to ~/.ssh/config\n", configPath)
+	} else {
+		fmt.Fprintf(a.out, "~/.ssh/config already includes %s\n", configPath)
+	}
+	fmt.Fprintln(a.out, "clawfarm keeps this file up to date as instances are run and removed")
+	return nil
+}
+
+func (a *App) refreshSSHConfig() {
+	dataDir, err := a.dirs.ResolveDataDir()
+	if err != nil || !fileExistsAndNonEmpty(filepath.Join(dataDir, sshConfigFileName)) {
+		return
+	}
+	if _, err := a.writeSSHConfig(); err != nil {
+		fmt.Fprintf(a.errOut, "warning: refresh %s: %v\n", sshConfigFileName, err)
+	}
+}
+
+func (a *App) writeSSHConfig() (string, error) {
+	store, _, err := a.instanceStore()
+	if err != nil {
+		return "", err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return "", err
+	}
+	dataDir, err := a.dirs.ResolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dataDir, sshConfigFileName)
+	temporaryPath := path + ".tmp"
+	if err := os.WriteFile(temporaryPath, []byte(renderSSHConfig(instances)), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(temporaryPath, path); err != nil {
+		_ = os.Remove(temporaryPath)
+		return "", err
+	}
+	return path, nil
+}
+
+func renderSSHConfig(instances []state.Instance) string {
+	sorted := append([]state.Instance(nil), instances...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var builder strings.Builder
+	builder.WriteString("# generated by clawfarm; edits are overwritten\n")
+	for _, instance := range sorted {
+		if instance.SSHHostPort <= 0 || instance.SSHKeyPath == "" {
+			continue
+		}
+		fmt.Fprintf(&builder, "\n# %s (%s)\nHost %s\n", instance.ImageRef, instance.Status, sshHostAlias(instance.ID))
+		builder.WriteString("  HostName 127.0.0.1\n")
+		fmt.Fprintf(&builder, "  Port %d\n", instance.SSHHostPort)
+		fmt.Fprintf(&builder, "  User %s\n", instance.SSHUser())
+		fmt.Fprintf(&builder, "  IdentityFile %q\n", instance.SSHKeyPath)
+		builder.WriteString("  IdentitiesOnly yes\n")
+		builder.WriteString("  StrictHostKeyChecking no\n")
+		builder.WriteString("  UserKnownHostsFile /dev/null\n")
+		builder.WriteString("  LogLevel ERROR\n")
+	}
+	return builder.String()
+}
+
+func ensureSSHConfigInclude(configPath string) (bool, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false, err
+	}
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(sshDir, 0o700); err != nil {
+		return false, err
+	}
+	userConfigPath := filepath.Join(sshDir, "config")
+	existing, err := os.ReadFile(userConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	includeLine := fmt.Sprintf("Include %q", configPath)
+	for _, line := range strings.Split(string(existing), "\n") {
+		if strings.TrimSpace(line) == includeLine {
+			return false, nil
+		}
+	}
+	updated := includeLine + "\n"
+	if len(existing) > 0 {
+		updated += "\n" + string(existing)
+	}
+	if err := os.WriteFile(userConfigPath, []byte(updated), 0o600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,202 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	statsFileName     = "stats.json"
+	crashesDirName    = "crashes"
+	crashPostTimeout  = 10 * time.Second
+	crashReportSchema = 1
+)
+
+type crashReport struct {
+	Schema   int       `json:"schema"`
+	Version  string    `json:"version"`
+	OS       string    `json:"os"`
+	Arch     string    `json:"arch"`
+	Command  string    `json:"command"`
+	Panic    string    `json:"panic"`
+	Stack    string    `json:"stack"`
+	TimeUTC  time.Time `json:"time_utc"`
+	ReportID string    `json:"report_id"`
+}
+
+func (a *App) statsStore() (*state.StatsStore, error) {
+	clawsRoot, err := a.clawsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return state.NewStatsStore(filepath.Join(filepath.Dir(clawsRoot), statsFileName)), nil
+}
+
+func (a *App) updateStats(fn func(stats *state.HostStats)) {
+	store, err := a.statsStore()
+	if err != nil {
+		return
+	}
+	_ = store.Update(fn)
+}
+
+func (a *App) recordRunStats(runErr error) {
+	a.updateStats(func(stats *state.HostStats) {
+		stats.Runs++
+		if runErr != nil {
+			stats.RunFailures++
+		}
+	})
+}
+
+func (a *App) runStats(args []string) error {
+	reset := false
+	for _, arg := range args {
+		switch arg {
+		case "--host":
+		case "--reset":
+			reset = true
+		default:
+			return errors.New("usage: clawfarm stats --host [--reset]")
+		}
+	}
+	store, err := a.statsStore()
+	if err != nil {
+		return err
+	}
+	if reset {
+		if err := store.Reset(); err != nil {
+			return err
+		}
+		fmt.Fprintln(a.out, "host stats reset")
+		return nil
+	}
+	stats, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	since := "-"
+	if !stats.SinceUTC.IsZero() {
+		since = stats.SinceUTC.Format(time.RFC3339)
+	}
+	fmt.Fprintf(a.out, "since: %s\n", since)
+	fmt.Fprintf(a.out, "runs: %d\n", stats.Runs)
+	failureRate := 0.0
+	if stats.Runs > 0 {
+		failureRate = float64(stats.RunFailures) * 100 / float64(stats.Runs)
+	}
+	fmt.Fprintf(a.out, "run failures: %d (%.1f%%)\n", stats.RunFailures, failureRate)
+	if stats.Boots > 0 {
+		average := time.Duration(stats.BootTotalMillis/int64(stats.Boots)) * time.Millisecond
+		fmt.Fprintf(a.out, "boots to ready: %d (avg %s, min %s, max %s, last %s)\n",
+			stats.Boots,
+			average,
+			time.Duration(stats.BootMinMillis)*time.Millisecond,
+			time.Duration(stats.BootMaxMillis)*time.Millisecond,
+			time.Duration(stats.LastBootMillis)*time.Millisecond,
+		)
+	} else {
+		fmt.Fprintln(a.out, "boots to ready: 0")
+	}
+	fmt.Fprintf(a.out, "crashes: %d\n", stats.Crashes)
+	fmt.Fprintln(a.out, "stats are local only and never leave this machine")
+	return nil
+}
+
+func (a *App) captureCrash(command string, errp *error) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	now := time.Now().UTC()
+	report := crashReport{
+		Schema:   crashReportSchema,
+		Version:  Version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Command:  command,
+		Panic:    anonymizeCrashText(fmt.Sprint(recovered)),
+		Stack:    anonymizeCrashText(string(debug.Stack())),
+		TimeUTC:  now,
+		ReportID: fmt.Sprintf("%s-%d", now.Format("20060102T150405Z"), os.Getpid()),
+	}
+	a.updateStats(func(stats *state.HostStats) {
+		stats.Crashes++
+	})
+
+	path, writeErr := a.writeCrashReport(report)
+	if writeErr != nil {
+		*errp = fmt.Errorf("internal error: %s (could not save crash report: %v)", report.Panic, writeErr)
+		return
+	}
+	if endpoint := config.CrashReportURL(); endpoint != "" {
+		if postErr := postCrashReport(endpoint, report); postErr != nil {
+			fmt.Fprintf(a.errOut, "warning: crash report not sent to %s: %v\n", endpoint, postErr)
+		}
+	}
+	*errp = fmt.Errorf("internal error: %s (crash report saved to %s)", report.Panic, path)
+}
+
+func (a *App) writeCrashReport(report crashReport) (string, error) {
+	dataDir, err := a.dirs.ResolveDataDir()
+	if err != nil {
+		return "", err
+	}
+	crashesDir := filepath.Join(dataDir, crashesDirName)
+	if err := os.MkdirAll(crashesDir, 0o700); err != nil {
+		return "", err
+	}
+	payload, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(crashesDir, "crash-"+report.ReportID+".json")
+	if err := os.WriteFile(path, append(payload, '\n'), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func postCrashReport(endpoint string, report crashReport) error {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), crashPostTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", response.Status)
+	}
+	return nil
+}
+
+func anonymizeCrashText(text string) string {
+	if home, err := os.UserHomeDir(); err == nil && len(home) > 1 {
+		text = strings.ReplaceAll(text, home, "~")
+	}
+	return text
+}
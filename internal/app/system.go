@@ -0,0 +1,107 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+)
+
+func (a *App) runSystem(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: clawfarm system df|dirs")
+	}
+	switch args[0] {
+	case "df":
+		if len(args) != 1 {
+			return errors.New("usage: clawfarm system df")
+		}
+		return a.runSystemDF()
+	case "dirs":
+		if len(args) != 1 {
+			return errors.New("usage: clawfarm system dirs")
+		}
+		return a.runSystemDirs()
+	default:
+		return fmt.Errorf("unknown system subcommand %q", args[0])
+	}
+}
+
+func (a *App) runSystemDirs() error {
+	dataDir, err := a.dirs.ResolveDataDir()
+	if err != nil {
+		return err
+	}
+	cacheDir, err := a.dirs.ResolveCacheDir()
+	if err != nil {
+		return err
+	}
+	blobsDir, err := a.dirs.ResolveBlobsDir()
+	if err != nil {
+		return err
+	}
+	context, err := a.dirs.ResolveContext()
+	if err != nil {
+		return err
+	}
+	if context == "" {
+		context = "default"
+	}
+	fmt.Fprintf(a.out, "context: %s\n", context)
+	fmt.Fprintf(a.out, "data: %s\n", dataDir)
+	fmt.Fprintf(a.out, "cache: %s\n", cacheDir)
+	fmt.Fprintf(a.out, "blobs: %s\n", blobsDir)
+	return nil
+}
+
+func (a *App) runSystemDF() error {
+	manager, err := a.imageManager()
+	if err != nil {
+		return err
+	}
+	imageItems, err := manager.List()
+	if err != nil {
+		return err
+	}
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	instances, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tAPPARENT\tALLOCATED")
+	total := diskutil.DiskUsage{}
+	for _, item := range imageItems {
+		usage, usageErr := diskutil.DirUsage(item.ImageDir)
+		if usageErr != nil {
+			continue
+		}
+		total = total.Add(usage)
+		fmt.Fprintf(tw, "image\t%s\t%s\t%s\n", item.Ref, diskutil.HumanBytes(usage.ApparentBytes), diskutil.HumanBytes(usage.AllocatedBytes))
+	}
+	for _, instance := range instances {
+		usage, usageErr := diskutil.DirUsage(filepath.Join(clawsRoot, instance.ID))
+		if usageErr != nil {
+			continue
+		}
+		total = total.Add(usage)
+		fmt.Fprintf(tw, "claw\t%s\t%s\t%s\n", instance.ID, diskutil.HumanBytes(usage.ApparentBytes), diskutil.HumanBytes(usage.AllocatedBytes))
+	}
+	if blobsRoot, rootErr := a.blobsRoot(); rootErr == nil {
+		if _, statErr := os.Stat(blobsRoot); statErr == nil {
+			if usage, usageErr := diskutil.DirUsage(blobsRoot); usageErr == nil {
+				total = total.Add(usage)
+				fmt.Fprintf(tw, "blobs\t%s\t%s\t%s\n", blobsRoot, diskutil.HumanBytes(usage.ApparentBytes), diskutil.HumanBytes(usage.AllocatedBytes))
+			}
+		}
+	}
+	fmt.Fprintf(tw, "total\t-\t%s\t%s\n", diskutil.HumanBytes(total.ApparentBytes), diskutil.HumanBytes(total.AllocatedBytes))
+	return tw.Flush()
+}
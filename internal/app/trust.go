@@ -0,0 +1,102 @@
+package app
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const trustStoreFileName = "trusted_clawboxes.json"
+
+func specSHA256(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func clawboxV2SpecHash(spec runClawboxSpecV2) (string, error) {
+	payload, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return specSHA256(payload), nil
+}
+
+func (a *App) trustStore() (*state.TrustStore, error) {
+	clawsRoot, err := a.clawsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return state.NewTrustStore(filepath.Join(filepath.Dir(clawsRoot), trustStoreFileName)), nil
+}
+
+func (a *App) confirmClawboxTrust(target runTarget, trust bool, gatewayPort int, published []state.PortMapping) error {
+	if target.SpecHash == "" {
+		return nil
+	}
+	store, err := a.trustStore()
+	if err != nil {
+		return err
+	}
+	trusted, err := store.IsTrusted(target.SpecHash)
+	if err != nil {
+		return fmt.Errorf("read clawbox trust store: %w", err)
+	}
+	if trusted {
+		return nil
+	}
+
+	provisionTarget, provisionSteps := clawboxProvisionSteps(target)
+	fmt.Fprintf(a.out, "first run of clawbox %s (spec sha256 %s)\n", target.Input, target.SpecHash)
+	if len(provisionSteps) == 0 {
+		fmt.Fprintln(a.out, "  provision steps: none")
+	} else {
+		fmt.Fprintf(a.out, "  provision steps (run in %s):\n", provisionTarget)
+		for index, step := range provisionSteps {
+			fmt.Fprintf(a.out, "    %d. %s\n", index+1, step)
+		}
+	}
+	if len(target.OpenClawRequiredEnv) > 0 {
+		fmt.Fprintf(a.out, "  required env: %s\n", strings.Join(target.OpenClawRequiredEnv, ", "))
+	}
+	fmt.Fprintf(a.out, "  gateway: 127.0.0.1:%d -> guest %d\n", gatewayPort, gatewayPort)
+	for _, mapping := range published {
+		fmt.Fprintf(a.out, "  publish: 127.0.0.1:%d -> guest %d\n", mapping.HostPort, mapping.GuestPort)
+	}
+	fmt.Fprintln(a.out, "  outbound network: open (qemu user-mode NAT, no egress filtering)")
+
+	if len(provisionSteps) > 0 && !trust {
+		if !a.canPromptForInput() {
+			return fmt.Errorf("clawbox %s is not trusted yet: it runs %d provision step(s); review the summary above and re-run with --trust", target.Input, len(provisionSteps))
+		}
+		fmt.Fprint(a.out, "trust this clawbox and run it? [y/N]: ")
+		line, readErr := bufio.NewReader(a.in).ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer != "y" && answer != "yes" {
+			if readErr != nil && answer == "" {
+				return fmt.Errorf("read trust confirmation: %w", readErr)
+			}
+			return fmt.Errorf("clawbox %s was not trusted", target.Input)
+		}
+	}
+
+	if err := store.Trust(target.SpecHash, target.Input); err != nil {
+		return fmt.Errorf("record clawbox trust: %w", err)
+	}
+	return nil
+}
+
+func clawboxProvisionSteps(target runTarget) (string, []string) {
+	if target.ClawboxV2Mode && target.ClawboxV2Spec != nil {
+		return "guest", target.ClawboxV2Spec.provisionScripts()
+	}
+	if target.SpecProvisionTarget == provisionTargetHost {
+		return "host", target.SpecProvisionCommands
+	}
+	return "guest", target.SpecProvisionCommands
+}
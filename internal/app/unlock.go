@@ -0,0 +1,72 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+func (a *App) runUnlock(args []string) error {
+	force := false
+	positionals := make([]string, 0, 1)
+	for _, arg := range args {
+		switch arg {
+		case "--force":
+			force = true
+		default:
+			positionals = append(positionals, arg)
+		}
+	}
+	if len(positionals) != 1 {
+		return errors.New("usage: clawfarm unlock <clawid> --force")
+	}
+	id := strings.TrimSpace(positionals[0])
+
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	current, err := lockManager.Inspect(id)
+	if err != nil && !errors.Is(err, state.ErrInvalidState) {
+		return err
+	}
+	if !force {
+		fmt.Fprintf(a.out, "lock: %s\n", describeLockState(current))
+		return errors.New("refusing to clear the lock without --force")
+	}
+
+	previous, err := lockManager.ForceUnlock(id, a.backend.IsRunning)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "unlocked %s (was: %s)\n", id, describeLockState(previous))
+	return nil
+}
+
+func describeLockState(lockState state.LockState) string {
+	parts := make([]string, 0, 2)
+	if lockState.Holder != nil {
+		command := lockState.Holder.Command
+		if command == "" {
+			command = "unknown command"
+		}
+		parts = append(parts, fmt.Sprintf("held by pid %d (%s) since %s", lockState.Holder.PID, command, lockState.Holder.AcquiredAtUTC.Format(time.RFC3339)))
+	}
+	if lockState.Active {
+		parts = append(parts, fmt.Sprintf("mounted by pid %d", lockState.PID))
+	}
+	if len(parts) == 0 {
+		return "free"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func lockHolderColumn(lockState state.LockState) string {
+	if lockState.Holder == nil {
+		return "-"
+	}
+	return fmt.Sprintf("pid %d (%s)", lockState.Holder.PID, lockState.Holder.Command)
+}
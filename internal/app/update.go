@@ -0,0 +1,98 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+const updateUsage = "usage: clawfarm update <clawid> [--cpus N] [--memory-mib M]"
+
+func (a *App) runUpdate(args []string) error {
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	flags.SetOutput(a.errOut)
+	cpus := 0
+	memoryMiB := 0
+	flags.IntVar(&cpus, "cpus", 0, "vCPU count")
+	flags.IntVar(&memoryMiB, "memory-mib", 0, "memory size in MiB")
+	if err := flags.Parse(normalizeRunArgs(args)); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || (cpus == 0 && memoryMiB == 0) {
+		return errors.New(updateUsage)
+	}
+	if hasCLIFlag(args, "--cpus") && cpus < 1 {
+		return errors.New("cpus must be >= 1")
+	}
+	if hasCLIFlag(args, "--memory-mib") && memoryMiB < 512 {
+		return errors.New("memory-mib must be >= 512")
+	}
+
+	id := strings.TrimSpace(flags.Arg(0))
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	lockManager, err := a.lockManager()
+	if err != nil {
+		return err
+	}
+	return lockManager.WithInstanceLock(id, func() error {
+		instance, loadErr := store.Load(id)
+		if loadErr != nil {
+			if errors.Is(loadErr, state.ErrNotFound) {
+				return fmt.Errorf("instance %s not found", id)
+			}
+			return loadErr
+		}
+		instanceDir := filepath.Join(clawsRoot, id)
+		spec, err := readInstanceStartSpec(instanceDir)
+		if err != nil {
+			return fmt.Errorf("instance %s has no recorded start spec to update: %w", id, err)
+		}
+		running := instance.PID > 0 && a.backend.IsRunning(instance.PID)
+
+		if cpus > 0 {
+			spec.CPUs = cpus
+			if running {
+				fmt.Fprintf(a.out, "%s: cpus %d takes effect on next start\n", id, cpus)
+			} else {
+				fmt.Fprintf(a.out, "%s: cpus %d\n", id, cpus)
+			}
+		}
+		if memoryMiB > 0 {
+			spec.MemoryMiB = memoryMiB
+			switch {
+			case !running:
+				fmt.Fprintf(a.out, "%s: memory %d MiB\n", id, memoryMiB)
+			case a.applyMemoryLive(instance, memoryMiB):
+				fmt.Fprintf(a.out, "%s: memory %d MiB applied live\n", id, memoryMiB)
+			default:
+				fmt.Fprintf(a.out, "%s: memory %d MiB takes effect on next start\n", id, memoryMiB)
+			}
+		}
+		return writeInstanceStartSpec(instanceDir, spec)
+	})
+}
+
+// applyMemoryLive resizes a running QEMU guest through its virtio balloon.
+// The balloon can only hand back memory the VM booted with, so growing past
+// the boot size deflates it fully and waits for a restart for the rest.
+func (a *App) applyMemoryLive(instance state.Instance, memoryMiB int) bool {
+	if instance.Backend == vm.BackendVZF || strings.TrimSpace(instance.MonitorPath) == "" {
+		return false
+	}
+	if instance.BootMemoryMiB == 0 {
+		return false
+	}
+	if err := vm.SetBalloon(instance.MonitorPath, min(memoryMiB, instance.BootMemoryMiB)); err != nil {
+		fmt.Fprintf(a.errOut, "warning: cannot resize the memory of %s live: %v\n", instance.ID, err)
+		return false
+	}
+	return memoryMiB <= instance.BootMemoryMiB
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const preservedVolumesManifest = "volumes.json"
+
+func preservedVolumesDir(clawsRoot string, id string) string {
+	return filepath.Join(filepath.Dir(clawsRoot), "volumes", id)
+}
+
+func preserveInstanceVolumes(clawsRoot string, instance state.Instance) (string, int, error) {
+	sourceRoot := filepath.Join(clawsRoot, instance.ID, "volumes")
+	entries, err := os.ReadDir(sourceRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+
+	guestPaths := make(map[string]string, len(instance.Volumes))
+	for _, volume := range instance.Volumes {
+		guestPaths[volume.Name] = volume.GuestPath
+	}
+
+	destinationRoot := preservedVolumesDir(clawsRoot, instance.ID)
+	if err := os.MkdirAll(destinationRoot, 0o755); err != nil {
+		return "", 0, err
+	}
+
+	preserved := make([]state.VolumeMount, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		destination := filepath.Join(destinationRoot, entry.Name())
+		if err := os.Rename(filepath.Join(sourceRoot, entry.Name()), destination); err != nil {
+			return "", 0, err
+		}
+		preserved = append(preserved, state.VolumeMount{Name: entry.Name(), HostPath: destination, GuestPath: guestPaths[entry.Name()]})
+	}
+	if len(preserved) == 0 {
+		_ = os.Remove(destinationRoot)
+		return "", 0, nil
+	}
+
+	payload, err := json.MarshalIndent(preserved, "", "  ")
+	if err != nil {
+		return "", 0, err
+	}
+	if err := os.WriteFile(filepath.Join(destinationRoot, preservedVolumesManifest), append(payload, '\n'), 0o644); err != nil {
+		return "", 0, err
+	}
+	return destinationRoot, len(preserved), nil
+}
+
+func loadPreservedVolumes(clawsRoot string, id string) ([]state.VolumeMount, error) {
+	payload, err := os.ReadFile(filepath.Join(preservedVolumesDir(clawsRoot, id), preservedVolumesManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no preserved volumes for %s (remove it with rm --keep-volumes first)", id)
+		}
+		return nil, err
+	}
+	var volumes []state.VolumeMount
+	if err := json.Unmarshal(payload, &volumes); err != nil {
+		return nil, fmt.Errorf("parse preserved volumes for %s: %w", id, err)
+	}
+	return volumes, nil
+}
+
+func mergeVolumeFrom(requested []volumeMapping, preserved []state.VolumeMount, fromID string) ([]volumeMapping, error) {
+	merged := append([]volumeMapping(nil), requested...)
+	seen := make(map[string]bool, len(requested))
+	for _, mapping := range requested {
+		seen[mapping.Name] = true
+	}
+	for _, volume := range preserved {
+		if seen[volume.Name] {
+			continue
+		}
+		if volume.GuestPath == "" {
+			return nil, fmt.Errorf("preserved volume %s from %s has no recorded guest path; pass --volume %s:/guest/abs/path", volume.Name, fromID, volume.Name)
+		}
+		merged = append(merged, volumeMapping{Name: volume.Name, GuestPath: volume.GuestPath})
+	}
+	return merged, nil
+}
+
+// reattachPreservedVolumes moves preserved volumes into instanceDir and
+// returns a func that moves them back. The manifest stays behind until
+// forgetPreservedVolumes, so a run that fails before the VM boots can put the
+// data back and be retried with the same --volume-from.
+func reattachPreservedVolumes(preserved []state.VolumeMount, instanceDir string) (func() error, error) {
+	moved := make([]state.VolumeMount, 0, len(preserved))
+	restore := func() error {
+		var restoreErr error
+		for index := len(moved) - 1; index >= 0; index-- {
+			if err := os.Rename(moved[index].HostPath, preserved[index].HostPath); err != nil && restoreErr == nil {
+				restoreErr = fmt.Errorf("restore preserved volume %s: %w", moved[index].Name, err)
+			}
+		}
+		return restoreErr
+	}
+	for _, volume := range preserved {
+		destination := filepath.Join(instanceDir, "volumes", volume.Name)
+		if _, err := os.Stat(destination); err == nil {
+			return nil, errors.Join(fmt.Errorf("volume %s already exists in %s", volume.Name, instanceDir), restore())
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(err, restore())
+		}
+		if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+			return nil, errors.Join(err, restore())
+		}
+		if err := os.Rename(volume.HostPath, destination); err != nil {
+			return nil, errors.Join(err, restore())
+		}
+		moved = append(moved, state.VolumeMount{Name: volume.Name, HostPath: destination, GuestPath: volume.GuestPath})
+	}
+	return restore, nil
+}
+
+// forgetPreservedVolumes drops the manifest of volumes that now belong to a
+// booted instance.
+func forgetPreservedVolumes(clawsRoot string, fromID string) error {
+	sourceRoot := preservedVolumesDir(clawsRoot, fromID)
+	if err := os.Remove(filepath.Join(sourceRoot, preservedVolumesManifest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(sourceRoot)
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/state"
+)
+
+const (
+	workspaceWatchInterval = 500 * time.Millisecond
+	workspaceWatchMaxBytes = 1 << 20
+)
+
+var workspaceWatchSkipDirs = map[string]bool{".git": true, "node_modules": true}
+
+type workspaceStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+func workspaceWatchDir(instanceDir string) string {
+	return filepath.Join(instanceDir, "watch")
+}
+
+// The watcher holds watch.lock while it runs and writes its pid to watch.pid,
+// so a recorded WatchPID the OS has since handed to another process is never
+// signalled.
+func workspaceWatchLockPath(instanceDir string) string {
+	return filepath.Join(instanceDir, "watch.lock")
+}
+
+func workspaceWatchPIDPath(instanceDir string) string {
+	return filepath.Join(instanceDir, "watch.pid")
+}
+
+func startWorkspaceWatcher(dirs config.DirOverrides, id string, instanceDir string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(instanceDir, "watch.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	dataDir, err := dirs.ResolveDataDir()
+	if err != nil {
+		return 0, err
+	}
+	cacheDir, err := dirs.ResolveCacheDir()
+	if err != nil {
+		return 0, err
+	}
+	command := exec.Command(executable, "--data-dir", dataDir, "--cache-dir", cacheDir, "workspace-watch", id)
+	command.Stdout = logFile
+	command.Stderr = logFile
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := command.Start(); err != nil {
+		return 0, err
+	}
+	pid := command.Process.Pid
+	_ = command.Process.Release()
+	return pid, nil
+}
+
+func stopWorkspaceWatcher(instanceDir string, instance state.Instance) {
+	if instance.WatchPID <= 0 {
+		return
+	}
+	handle, free, err := state.NewFlockLocker().TryLock(workspaceWatchLockPath(instanceDir))
+	if err != nil {
+		return
+	}
+	if free {
+		_ = handle.Unlock()
+		return
+	}
+	payload, err := os.ReadFile(workspaceWatchPIDPath(instanceDir))
+	if err != nil || strings.TrimSpace(string(payload)) != strconv.Itoa(instance.WatchPID) {
+		return
+	}
+	_ = syscall.Kill(instance.WatchPID, syscall.SIGTERM)
+}
+
+func (a *App) runWorkspaceWatch(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: clawfarm workspace-watch <clawid>")
+	}
+	store, clawsRoot, err := a.instanceStore()
+	if err != nil {
+		return err
+	}
+	var instance state.Instance
+	for attempt := 0; attempt < 10; attempt++ {
+		instance, err = store.Load(args[0])
+		if err == nil {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	if instance.WorkspacePath == "" {
+		return fmt.Errorf("instance %s has no workspace to watch", instance.ID)
+	}
+	instanceDir := filepath.Join(clawsRoot, instance.ID)
+	handle, locked, err := state.NewFlockLocker().TryLock(workspaceWatchLockPath(instanceDir))
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("a workspace watcher is already running for %s", instance.ID)
+	}
+	defer handle.Unlock()
+	pidPath := workspaceWatchPIDPath(instanceDir)
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(pidPath)
+
+	eventsPath := filepath.Join(workspaceWatchDir(instanceDir), "events")
+	previous, err := scanWorkspace(instance.WorkspacePath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "watching %s for %s\n", instance.WorkspacePath, instance.ID)
+	for instance.PID > 0 && a.backend.IsRunning(instance.PID) {
+		time.Sleep(workspaceWatchInterval)
+		current, scanErr := scanWorkspace(instance.WorkspacePath)
+		if scanErr != nil {
+			fmt.Fprintf(a.errOut, "scan workspace: %v\n", scanErr)
+			continue
+		}
+		changed := diffWorkspaceSnapshots(previous, current)
+		previous = current
+		if len(changed) == 0 {
+			continue
+		}
+		if err := appendWorkspaceEvents(eventsPath, changed); err != nil {
+			fmt.Fprintf(a.errOut, "relay workspace changes: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func scanWorkspace(root string) (map[string]workspaceStamp, error) {
+	snapshot := map[string]workspaceStamp{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if path == root {
+				return walkErr
+			}
+			return nil
+		}
+		if entry.IsDir() && path != root && workspaceWatchSkipDirs[entry.Name()] {
+			return filepath.SkipDir
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil || relative == "." || strings.ContainsAny(relative, "\n\r") {
+			return nil
+		}
+		snapshot[filepath.ToSlash(relative)] = workspaceStamp{ModTime: info.ModTime(), Size: info.Size()}
+		return nil
+	})
+	return snapshot, err
+}
+
+func diffWorkspaceSnapshots(previous map[string]workspaceStamp, current map[string]workspaceStamp) []string {
+	changed := make([]string, 0)
+	for path, stamp := range current {
+		if old, ok := previous[path]; !ok || !old.ModTime.Equal(stamp.ModTime) || old.Size != stamp.Size {
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func appendWorkspaceEvents(eventsPath string, paths []string) error {
+	if err := os.MkdirAll(filepath.Dir(eventsPath), 0o755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(eventsPath); err == nil && info.Size() > workspaceWatchMaxBytes {
+		if err := os.Truncate(eventsPath, 0); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(eventsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(strings.Join(paths, "\n") + "\n")
+	return err
+}
@@ -45,21 +45,31 @@ type Payload struct {
 }
 
 type RuntimeSpec struct {
-	BaseImage BaseImage    `json:"base_image"`
-	Layers    []Layer      `json:"layers,omitempty"`
-	OpenClaw  OpenClawSpec `json:"openclaw"`
+	BaseImage   BaseImage    `json:"base_image"`
+	Layers      []Layer      `json:"layers,omitempty"`
+	OpenClaw    OpenClawSpec `json:"openclaw"`
+	RunDefaults *RunDefaults `json:"run_defaults,omitempty"`
 }
 
 type BaseImage struct {
-	Ref    string `json:"ref"`
-	URL    string `json:"url"`
-	SHA256 string `json:"sha256"`
+	Ref     string   `json:"ref"`
+	URL     string   `json:"url"`
+	Mirrors []string `json:"mirrors,omitempty"`
+	SHA256  string   `json:"sha256"`
 }
 
 type Layer struct {
-	Ref    string `json:"ref"`
-	URL    string `json:"url"`
-	SHA256 string `json:"sha256"`
+	Ref     string   `json:"ref"`
+	URL     string   `json:"url"`
+	Mirrors []string `json:"mirrors,omitempty"`
+	SHA256  string   `json:"sha256"`
+}
+
+type RunDefaults struct {
+	CPUs      int      `json:"cpus,omitempty"`
+	MemoryMiB int      `json:"memory_mib,omitempty"`
+	Publish   []string `json:"publish,omitempty"`
+	Volumes   []string `json:"volumes,omitempty"`
 }
 
 type OpenClawSpec struct {
@@ -191,28 +201,66 @@ func validatePayload(payload Payload) error {
 }
 
 func validateRuntimeSpec(spec RuntimeSpec) error {
-	if err := validateBlobRef("spec.base_image", spec.BaseImage.Ref, spec.BaseImage.URL, spec.BaseImage.SHA256); err != nil {
+	if err := validateBlobRef("spec.base_image", spec.BaseImage.Ref, spec.BaseImage.URL, spec.BaseImage.Mirrors, spec.BaseImage.SHA256); err != nil {
 		return err
 	}
 	for i, layer := range spec.Layers {
 		field := fmt.Sprintf("spec.layers[%d]", i)
-		if err := validateBlobRef(field, layer.Ref, layer.URL, layer.SHA256); err != nil {
+		if err := validateBlobRef(field, layer.Ref, layer.URL, layer.Mirrors, layer.SHA256); err != nil {
 			return err
 		}
 	}
 	if err := validateOpenClawSpec(spec.OpenClaw); err != nil {
 		return err
 	}
+	if spec.RunDefaults != nil {
+		if err := spec.RunDefaults.Validate("spec.run_defaults"); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func validateBlobRef(prefix string, ref string, url string, sha string) error {
+func (defaults RunDefaults) Validate(prefix string) error {
+	if defaults.CPUs < 0 {
+		return fmt.Errorf("%s.cpus must be >= 1 when set", prefix)
+	}
+	if defaults.MemoryMiB != 0 && defaults.MemoryMiB < 512 {
+		return fmt.Errorf("%s.memory_mib must be >= 512 when set", prefix)
+	}
+	for i, publish := range defaults.Publish {
+		parts := strings.Split(strings.TrimSpace(publish), ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("%s.publish[%d] %q must be host:guest", prefix, i, publish)
+		}
+		for _, part := range parts {
+			port, err := strconv.Atoi(part)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("%s.publish[%d] %q must use ports 1-65535", prefix, i, publish)
+			}
+		}
+	}
+	for i, volume := range defaults.Volumes {
+		parts := strings.SplitN(strings.TrimSpace(volume), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || !strings.HasPrefix(parts[1], "/") {
+			return fmt.Errorf("%s.volumes[%d] %q must be name:/guest/abs/path", prefix, i, volume)
+		}
+	}
+	return nil
+}
+
+func validateBlobRef(prefix string, ref string, url string, mirrors []string, sha string) error {
 	if strings.TrimSpace(ref) == "" {
 		return fmt.Errorf("%s.ref is required", prefix)
 	}
 	if strings.TrimSpace(url) == "" {
 		return fmt.Errorf("%s.url is required", prefix)
 	}
+	for i, mirror := range mirrors {
+		if strings.TrimSpace(mirror) == "" {
+			return fmt.Errorf("%s.mirrors[%d] must not be empty", prefix, i)
+		}
+	}
 	if !sha256Pattern.MatchString(strings.ToLower(sha)) {
 		return fmt.Errorf("%s.sha256 must be lowercase hex sha256", prefix)
 	}
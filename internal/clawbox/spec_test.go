@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -101,7 +102,7 @@ func TestSaveLoadHeaderJSONRoundTrip(t *testing.T) {
 	if output.Payload != input.Payload {
 		t.Fatalf("payload mismatch: got %+v want %+v", output.Payload, input.Payload)
 	}
-	if output.Spec.BaseImage != input.Spec.BaseImage {
+	if !reflect.DeepEqual(output.Spec.BaseImage, input.Spec.BaseImage) {
 		t.Fatalf("base image mismatch: got %+v want %+v", output.Spec.BaseImage, input.Spec.BaseImage)
 	}
 	if len(output.Spec.Layers) != len(input.Spec.Layers) {
@@ -171,9 +172,10 @@ func validHeader() Header {
 		},
 		Spec: RuntimeSpec{
 			BaseImage: BaseImage{
-				Ref:    "ubuntu:24.04",
-				URL:    "https://example.com/base.img",
-				SHA256: testSHA256,
+				Ref:     "ubuntu:24.04",
+				URL:     "https://example.com/base.img",
+				Mirrors: []string{"https://mirror.example.com/base.img"},
+				SHA256:  testSHA256,
 			},
 			Layers: []Layer{
 				{
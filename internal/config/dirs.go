@@ -1,31 +1,118 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 )
 
 const (
 	envClawfarmHome = "CLAWFARM_HOME"
 	envCacheDir     = "CLAWFARM_CACHE_DIR"
 	envDataDir      = "CLAWFARM_DATA_DIR"
+	envContext      = "CLAWFARM_CONTEXT"
+	envXDGDataHome  = "XDG_DATA_HOME"
+	envXDGCacheHome = "XDG_CACHE_HOME"
+	envSharedData   = "CLAWFARM_SHARED_DATA_DIR"
 )
 
+var contextNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,47}$`)
+
+// DirOverrides carries the --data-dir, --cache-dir and --context values of
+// one caller. Empty fields fall back to the environment and the platform
+// defaults, so the zero value resolves like the package-level functions.
+type DirOverrides struct {
+	DataDir  string
+	CacheDir string
+	Context  string
+}
+
 func CacheDir() (string, error) {
+	return DirOverrides{}.ResolveCacheDir()
+}
+
+func DataDir() (string, error) {
+	return DirOverrides{}.ResolveDataDir()
+}
+
+func BlobsDir() (string, error) {
+	return DirOverrides{}.ResolveBlobsDir()
+}
+
+func Context() (string, error) {
+	return DirOverrides{}.ResolveContext()
+}
+
+func (o DirOverrides) ResolveCacheDir() (string, error) {
+	if o.CacheDir != "" {
+		return o.CacheDir, nil
+	}
 	if custom := os.Getenv(envCacheDir); custom != "" {
 		return custom, nil
 	}
-	return baseDir()
+	return o.defaultDir(envXDGCacheHome, ".cache")
 }
 
-func DataDir() (string, error) {
+func (o DirOverrides) ResolveDataDir() (string, error) {
+	if o.DataDir != "" {
+		return o.DataDir, nil
+	}
 	if custom := os.Getenv(envDataDir); custom != "" {
 		return custom, nil
 	}
-	return baseDir()
+	return o.defaultDir(envXDGDataHome, filepath.Join(".local", "share"))
+}
+
+func (o DirOverrides) ResolveBlobsDir() (string, error) {
+	cacheDir, err := o.ResolveCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "blobs"), nil
 }
 
-func baseDir() (string, error) {
+func (o DirOverrides) ResolveContext() (string, error) {
+	name := o.Context
+	if strings.TrimSpace(name) == "" {
+		name = os.Getenv(envContext)
+	}
+	return NormalizeContext(name)
+}
+
+func NormalizeContext(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "default" {
+		return "", nil
+	}
+	if !contextNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid context %q: use lowercase letters, digits, and '-'", name)
+	}
+	return name, nil
+}
+
+func SharedDataDir() bool {
+	return envEnabled(envSharedData)
+}
+
+func (o DirOverrides) defaultDir(xdgEnv string, xdgFallback string) (string, error) {
+	base, err := baseDir(xdgEnv, xdgFallback)
+	if err != nil {
+		return "", err
+	}
+	context, err := o.ResolveContext()
+	if err != nil {
+		return "", err
+	}
+	if context != "" {
+		return filepath.Join(base, "contexts", context), nil
+	}
+	return base, nil
+}
+
+func baseDir(xdgEnv string, xdgFallback string) (string, error) {
 	if custom := os.Getenv(envClawfarmHome); custom != "" {
 		return custom, nil
 	}
@@ -33,5 +120,15 @@ func baseDir() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".clawfarm"), nil
+	legacy := filepath.Join(home, ".clawfarm")
+	if runtime.GOOS != "linux" {
+		return legacy, nil
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	if xdg := os.Getenv(xdgEnv); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "clawfarm"), nil
+	}
+	return filepath.Join(home, xdgFallback, "clawfarm"), nil
 }
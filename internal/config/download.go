@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+)
+
+const (
+	envDownloadLimit    = "CLAWFARM_DOWNLOAD_LIMIT"
+	envDownloadsPerHost = "CLAWFARM_DOWNLOADS_PER_HOST"
+
+	defaultDownloadsPerHost = 4
+)
+
+// DownloadSettings carries the --download-limit value of one caller. An empty
+// Limit falls back to the environment.
+type DownloadSettings struct {
+	Limit string
+}
+
+// ResolveLimit is the combined download bandwidth in bytes per second, from
+// --download-limit or $CLAWFARM_DOWNLOAD_LIMIT. Zero means unlimited.
+func (s DownloadSettings) ResolveLimit() (int64, error) {
+	name, value := "--download-limit", s.Limit
+	if value == "" {
+		name, value = envDownloadLimit, strings.TrimSpace(os.Getenv(envDownloadLimit))
+	}
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := ParseBandwidth(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return limit, nil
+}
+
+// ParseBandwidth parses a rate such as 20MB/s or 512K/s with binary units.
+func ParseBandwidth(value string) (int64, error) {
+	limit, err := diskutil.ParseSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: expected a rate like 20MB/s", value)
+	}
+	return limit, nil
+}
+
+// DownloadsPerHost caps concurrent downloads from a single host.
+func DownloadsPerHost() (int, error) {
+	value := strings.TrimSpace(os.Getenv(envDownloadsPerHost))
+	if value == "" {
+		return defaultDownloadsPerHost, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive number", envDownloadsPerHost, value)
+	}
+	return limit, nil
+}
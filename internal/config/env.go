@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envQEMUUser      = "CLAWFARM_QEMU_USER"
+	envPreStartHook  = "CLAWFARM_PRE_START_HOOK"
+	envPostReadyHook = "CLAWFARM_POST_READY_HOOK"
+	envReleaseURL    = "CLAWFARM_RELEASE_URL"
+	envCrashReports  = "CLAWFARM_CRASH_REPORTS"
+	envCrashURL      = "CLAWFARM_CRASH_REPORT_URL"
+	envGuestUser     = "CLAWFARM_GUEST_USER"
+	envGuestSudo     = "CLAWFARM_GUEST_SUDO"
+	envSSHKeyFiles   = "CLAWFARM_SSH_AUTHORIZED_KEYS"
+	envEventWebhook  = "CLAWFARM_EVENT_WEBHOOK"
+
+	envDiskPressurePercent = "CLAWFARM_DISK_PRESSURE_PERCENT"
+
+	envClawboxMaxEntryBytes = "CLAWFARM_CLAWBOX_MAX_ENTRY_BYTES"
+	envClawboxMaxTotalBytes = "CLAWFARM_CLAWBOX_MAX_TOTAL_BYTES"
+	envBlobCacheMaxBytes    = "CLAWFARM_BLOB_CACHE_MAX_BYTES"
+	envBlobCacheKeepDays    = "CLAWFARM_BLOB_CACHE_KEEP_DAYS"
+)
+
+const (
+	defaultClawboxMaxEntryBytes int64 = 64 << 30
+	defaultClawboxMaxTotalBytes int64 = 128 << 30
+	defaultBlobCacheMaxBytes    int64 = 50 << 30
+	defaultBlobCacheKeepDays          = 7
+	defaultDiskPressurePercent        = 90
+)
+
+const defaultReleaseURL = "https://api.github.com/repos/flaneur2020/clawfarm/releases/latest"
+
+func CrashReportsEnabled() bool {
+	return envEnabled(envCrashReports) || CrashReportURL() != ""
+}
+
+func CrashReportURL() string {
+	return strings.TrimSpace(os.Getenv(envCrashURL))
+}
+
+func envEnabled(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+func ReleaseURL() string {
+	if custom := strings.TrimSpace(os.Getenv(envReleaseURL)); custom != "" {
+		return custom
+	}
+	return defaultReleaseURL
+}
+
+func QEMUUser() string {
+	return strings.TrimSpace(os.Getenv(envQEMUUser))
+}
+
+func GuestUser() string {
+	return strings.TrimSpace(os.Getenv(envGuestUser))
+}
+
+func GuestSudo() string {
+	return strings.TrimSpace(os.Getenv(envGuestSudo))
+}
+
+func SSHAuthorizedKeyFiles() []string {
+	files := []string{}
+	for _, path := range filepath.SplitList(os.Getenv(envSSHKeyFiles)) {
+		if path = strings.TrimSpace(path); path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+func PreStartHook() string {
+	return strings.TrimSpace(os.Getenv(envPreStartHook))
+}
+
+func PostReadyHook() string {
+	return strings.TrimSpace(os.Getenv(envPostReadyHook))
+}
+
+// EventWebhook is the URL instance events such as disk-pressure are POSTed to.
+func EventWebhook() string {
+	return strings.TrimSpace(os.Getenv(envEventWebhook))
+}
+
+// DiskPressurePercent is the guest rootfs usage at which an instance is
+// marked disk-pressure.
+func DiskPressurePercent() (int, error) {
+	value := strings.TrimSpace(os.Getenv(envDiskPressurePercent))
+	if value == "" {
+		return defaultDiskPressurePercent, nil
+	}
+	percent, err := strconv.Atoi(value)
+	if err != nil || percent < 1 || percent > 100 {
+		return 0, fmt.Errorf("invalid %s %q: expected a percentage between 1 and 100", envDiskPressurePercent, value)
+	}
+	return percent, nil
+}
+
+func ClawboxMaxEntryBytes() (int64, error) {
+	return byteLimit(envClawboxMaxEntryBytes, defaultClawboxMaxEntryBytes)
+}
+
+func ClawboxMaxTotalBytes() (int64, error) {
+	return byteLimit(envClawboxMaxTotalBytes, defaultClawboxMaxTotalBytes)
+}
+
+func BlobCacheMaxBytes() (int64, error) {
+	return byteLimit(envBlobCacheMaxBytes, defaultBlobCacheMaxBytes)
+}
+
+func BlobCacheKeepDuration() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(envBlobCacheKeepDays))
+	if value == "" {
+		return defaultBlobCacheKeepDays * 24 * time.Hour, nil
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative number of days", envBlobCacheKeepDays, value)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+func byteLimit(name string, fallback int64) (int64, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a positive number of bytes", name, value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,35 @@
+package diskutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func VerifySHA256(path string, expected string) error {
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("sha256 mismatch for %s: expected %s got %s", path, expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package diskutil
+
+import "golang.org/x/sys/unix"
+
+// cloneFile makes destinationPath a copy-on-write clone of sourcePath with
+// clonefile(2), which APFS supports.
+func cloneFile(sourcePath string, destinationPath string) error {
+	return unix.Clonefile(sourcePath, destinationPath, unix.CLONE_NOFOLLOW)
+}
@@ -0,0 +1,31 @@
+package diskutil
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes destinationPath a copy-on-write clone of sourcePath with
+// FICLONE, which btrfs, XFS and bcachefs support.
+func cloneFile(sourcePath string, destinationPath string) error {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+	targetFile, err := os.OpenFile(destinationPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(targetFile.Fd()), int(sourceFile.Fd())); err != nil {
+		targetFile.Close()
+		_ = os.Remove(destinationPath)
+		return err
+	}
+	return targetFile.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package diskutil
+
+import "errors"
+
+func cloneFile(sourcePath string, destinationPath string) error {
+	return errors.New("file cloning is not supported on this platform")
+}
@@ -0,0 +1,88 @@
+package diskutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var convertProgressPattern = regexp.MustCompile(`\((\d+(?:\.\d+)?)/100%\)`)
+
+// Convert rewrites source into destination in the given format ("raw" or
+// "qcow2"). Progress, when set, receives the converted byte count measured
+// against the source file size. Standalone disks already in the target format
+// are copied; overlays are flattened together with their backing image.
+func Convert(ctx context.Context, source string, destination string, format string, progress func(done int64, total int64)) error {
+	if format != "raw" && format != "qcow2" {
+		return fmt.Errorf("unsupported disk format %q (use raw or qcow2)", format)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if DetectFormat(source) == format && BackingFile(source) == "" {
+		if err := CopyFile(source, destination); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(info.Size(), info.Size())
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		return errors.New("qemu-img is required to convert disk images")
+	}
+
+	temporaryPath := destination + ".tmp"
+	command := exec.CommandContext(ctx, "qemu-img", "convert", "-p", "-O", format, source, temporaryPath)
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Split(scanProgressLines)
+	for scanner.Scan() {
+		if progress == nil {
+			continue
+		}
+		if match := convertProgressPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			percent, _ := strconv.ParseFloat(match[1], 64)
+			progress(int64(percent/100*float64(info.Size())), info.Size())
+		}
+	}
+	if err := command.Wait(); err != nil {
+		_ = os.Remove(temporaryPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("convert disk to %s: %s", format, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(temporaryPath, destination); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
+
+// scanProgressLines splits qemu-img -p output, which redraws with \r.
+func scanProgressLines(data []byte, atEOF bool) (int, []byte, error) {
+	if index := bytes.IndexAny(data, "\r\n"); index >= 0 {
+		return index + 1, data[:index], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
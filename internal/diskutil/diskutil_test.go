@@ -0,0 +1,200 @@
+package diskutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormatByMagic(t *testing.T) {
+	tmpDir := t.TempDir()
+	qcowPath := filepath.Join(tmpDir, "disk.qcow2")
+	rawPath := filepath.Join(tmpDir, "disk.raw")
+
+	if err := os.WriteFile(qcowPath, append([]byte("QFI\xfb"), []byte("rest")...), 0o644); err != nil {
+		t.Fatalf("write qcow2: %v", err)
+	}
+	if err := os.WriteFile(rawPath, []byte("RAW!"), 0o644); err != nil {
+		t.Fatalf("write raw: %v", err)
+	}
+
+	format, err := DetectFormatByMagic(qcowPath)
+	if err != nil {
+		t.Fatalf("detect qcow2 failed: %v", err)
+	}
+	if format != "qcow2" {
+		t.Fatalf("unexpected format: %s", format)
+	}
+
+	format, err = DetectFormatByMagic(rawPath)
+	if err != nil {
+		t.Fatalf("detect raw failed: %v", err)
+	}
+	if format != "raw" {
+		t.Fatalf("unexpected format: %s", format)
+	}
+
+	if format := DetectFormat(filepath.Join(tmpDir, "missing")); format != "unknown" {
+		t.Fatalf("expected unknown format for missing file, got %s", format)
+	}
+}
+
+func TestVerifySHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(path, []byte("payload"), 0o644); err != nil {
+		t.Fatalf("write payload: %v", err)
+	}
+
+	digest, err := FileSHA256(path)
+	if err != nil {
+		t.Fatalf("FileSHA256 failed: %v", err)
+	}
+	if digest != "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5" {
+		t.Fatalf("unexpected digest %s", digest)
+	}
+	if err := VerifySHA256(path, strings.ToUpper(digest)); err != nil {
+		t.Fatalf("VerifySHA256 should ignore case: %v", err)
+	}
+	if err := VerifySHA256(path, strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected sha256 mismatch, got %v", err)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	cases := map[int64]string{
+		0:                "0B",
+		1023:             "1023B",
+		1536:             "1.5KB",
+		5 << 30:          "5.0GB",
+		int64(3)<<50 + 1: "3.0PB",
+	}
+	for value, expected := range cases {
+		if actual := HumanBytes(value); actual != expected {
+			t.Fatalf("HumanBytes(%d) = %s, want %s", value, actual, expected)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"40G":    40 << 30,
+		"512M":   512 << 20,
+		"1.5T":   3 << 39,
+		"20GiB":  20 << 30,
+		"8gb":    8 << 30,
+		"123456": 123456,
+	}
+	for value, expected := range cases {
+		actual, err := ParseSize(value)
+		if err != nil || actual != expected {
+			t.Fatalf("ParseSize(%q) = %d, %v; want %d", value, actual, err, expected)
+		}
+	}
+	for _, value := range []string{"", "G", "-1G", "40X", "0"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Fatalf("expected ParseSize(%q) to fail", value)
+		}
+	}
+}
+
+func TestGrowResizesDiskButRefusesToShrink(t *testing.T) {
+	dir := t.TempDir()
+	disk := filepath.Join(dir, "instance.img")
+	if err := os.WriteFile(disk, []byte("QFI\xfb"), 0o644); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	logPath := filepath.Join(dir, "qemu-img.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\ncase \"$1\" in\n" +
+		"info) echo '{\"format\":\"qcow2\",\"virtual-size\":3221225472}' ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", dir)
+
+	if err := Grow(disk, 1<<30); err == nil || !strings.Contains(err.Error(), "cannot shrink") {
+		t.Fatalf("expected shrinking to be refused, got %v", err)
+	}
+	if err := Grow(disk, 40<<30); err != nil {
+		t.Fatalf("grow disk: %v", err)
+	}
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read qemu-img log: %v", err)
+	}
+	if !strings.Contains(string(logged), "resize -q -f qcow2 "+disk+" 42949672960") {
+		t.Fatalf("unexpected qemu-img invocation:\n%s", logged)
+	}
+}
+
+func TestCopyFileKeepsHolesAndContent(t *testing.T) {
+	directory := t.TempDir()
+	sourcePath := filepath.Join(directory, "source.img")
+	payload := make([]byte, 4*sparseBlockSize+10)
+	copy(payload[sparseBlockSize:], []byte("data"))
+	copy(payload[len(payload)-4:], []byte("tail"))
+	if err := os.WriteFile(sourcePath, payload, 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+
+	destinationPath := filepath.Join(directory, "copy.img")
+	if err := CopyFile(sourcePath, destinationPath); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	copied, err := os.ReadFile(destinationPath)
+	if err != nil {
+		t.Fatalf("read copy: %v", err)
+	}
+	if !bytes.Equal(copied, payload) {
+		t.Fatalf("sparse copy changed file contents")
+	}
+
+	usage, err := FileUsage(destinationPath)
+	if err != nil {
+		t.Fatalf("FileUsage failed: %v", err)
+	}
+	if usage.ApparentBytes != int64(len(payload)) {
+		t.Fatalf("unexpected apparent size %d", usage.ApparentBytes)
+	}
+	if usage.AllocatedBytes > usage.ApparentBytes {
+		t.Fatalf("allocated %d should not exceed apparent %d", usage.AllocatedBytes, usage.ApparentBytes)
+	}
+}
+
+func TestCreateOverlayBacksInstanceDiskWithBaseImage(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.img")
+	if err := os.WriteFile(base, []byte("base"), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	t.Setenv("PATH", dir)
+	if err := CreateOverlay(base, filepath.Join(dir, "instance.img")); err != ErrQEMUImgMissing {
+		t.Fatalf("expected ErrQEMUImgMissing without qemu-img, got %v", err)
+	}
+
+	logPath := filepath.Join(dir, "qemu-img.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\ncase \"$1\" in\n" +
+		"create) eval last=\\${$#}; : > \"$last\" ;;\n" +
+		"info) echo '{\"format\":\"raw\",\"backing-filename\":\"" + base + "\"}' ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, "qemu-img"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake qemu-img: %v", err)
+	}
+	overlay := filepath.Join(dir, "claw", "instance.img")
+	if err := CreateOverlay(base, overlay); err != nil {
+		t.Fatalf("create overlay: %v", err)
+	}
+	if _, err := os.Stat(overlay); err != nil {
+		t.Fatalf("expected overlay file: %v", err)
+	}
+	logged, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read qemu-img log: %v", err)
+	}
+	if !strings.Contains(string(logged), "create -q -f qcow2 -b "+base+" -F raw "+overlay+".tmp") {
+		t.Fatalf("unexpected qemu-img invocation:\n%s", logged)
+	}
+	if backing := BackingFile(overlay); backing != base {
+		t.Fatalf("expected backing file %s, got %q", base, backing)
+	}
+}
@@ -0,0 +1,57 @@
+package diskutil
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+func DetectFormat(imagePath string) string {
+	if qemuImgPath, err := exec.LookPath("qemu-img"); err == nil {
+		if format, detectErr := DetectFormatWithQEMU(qemuImgPath, imagePath); detectErr == nil {
+			return format
+		}
+	}
+	if format, err := DetectFormatByMagic(imagePath); err == nil {
+		return format
+	}
+	return "unknown"
+}
+
+func DetectFormatWithQEMU(qemuImgPath string, imagePath string) (string, error) {
+	output, err := exec.Command(qemuImgPath, "info", "--output=json", imagePath).Output()
+	if err != nil {
+		return "", err
+	}
+
+	var payload struct {
+		Format string `json:"format"`
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return "", err
+	}
+	if payload.Format == "" {
+		return "", errors.New("empty format")
+	}
+	return payload.Format, nil
+}
+
+func DetectFormatByMagic(imagePath string) (string, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return "", err
+	}
+
+	if string(header) == "QFI\xfb" {
+		return "qcow2", nil
+	}
+	return "raw", nil
+}
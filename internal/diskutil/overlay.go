@@ -0,0 +1,67 @@
+package diskutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrQEMUImgMissing = errors.New("qemu-img is not installed")
+
+// CreateOverlay creates overlayPath as a qcow2 image backed by basePath, so
+// the overlay only stores the blocks written after it was created. The base
+// must stay unchanged for as long as the overlay is in use.
+func CreateOverlay(basePath string, overlayPath string) error {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return ErrQEMUImgMissing
+	}
+	absoluteBasePath, err := filepath.Abs(basePath)
+	if err != nil {
+		return err
+	}
+	format := DetectFormat(absoluteBasePath)
+	if format != "raw" && format != "qcow2" {
+		return fmt.Errorf("cannot overlay %s: unsupported base format %s", absoluteBasePath, format)
+	}
+	if err := os.MkdirAll(filepath.Dir(overlayPath), 0o755); err != nil {
+		return err
+	}
+
+	temporaryPath := overlayPath + ".tmp"
+	_ = os.Remove(temporaryPath)
+	output, err := exec.Command(qemuImgPath, "create", "-q", "-f", "qcow2", "-b", absoluteBasePath, "-F", format, temporaryPath).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(temporaryPath)
+		return fmt.Errorf("create overlay of %s: %s", absoluteBasePath, strings.TrimSpace(string(output)))
+	}
+	if err := os.Rename(temporaryPath, overlayPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
+
+// BackingFile returns the backing image of a qcow2 overlay, or "" for a
+// standalone disk or when qemu-img is unavailable.
+func BackingFile(imagePath string) string {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return ""
+	}
+	output, err := exec.Command(qemuImgPath, "info", "--output=json", imagePath).Output()
+	if err != nil {
+		return ""
+	}
+	var payload struct {
+		BackingFilename string `json:"backing-filename"`
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return ""
+	}
+	return payload.BackingFilename
+}
@@ -0,0 +1,57 @@
+package diskutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// VirtualSize returns the size of the disk the guest sees.
+func VirtualSize(imagePath string) (int64, error) {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return 0, ErrQEMUImgMissing
+	}
+	output, err := exec.Command(qemuImgPath, "info", "--output=json", imagePath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("inspect %s: %w", imagePath, err)
+	}
+	var payload struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal(output, &payload); err != nil {
+		return 0, err
+	}
+	return payload.VirtualSize, nil
+}
+
+// Grow enlarges the virtual disk of imagePath to sizeBytes. It never shrinks
+// a disk, since that would cut off the end of the guest filesystem. The
+// partition and filesystem are grown by the guest on its next boot.
+func Grow(imagePath string, sizeBytes int64) error {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return ErrQEMUImgMissing
+	}
+	current, err := VirtualSize(imagePath)
+	if err != nil {
+		return err
+	}
+	if sizeBytes < current {
+		return fmt.Errorf("cannot shrink %s from %s to %s", imagePath, HumanBytes(current), HumanBytes(sizeBytes))
+	}
+	if sizeBytes == current {
+		return nil
+	}
+	format := DetectFormat(imagePath)
+	if format != "raw" && format != "qcow2" {
+		return fmt.Errorf("cannot resize %s: unsupported format %s", imagePath, format)
+	}
+	output, err := exec.Command(qemuImgPath, "resize", "-q", "-f", format, imagePath, strconv.FormatInt(sizeBytes, 10)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("resize %s: %s", imagePath, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package diskutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func HumanBytes(value int64) string {
+	if value < 1024 {
+		return fmt.Sprintf("%dB", value)
+	}
+	units := []string{"KB", "MB", "GB", "TB"}
+	size := float64(value)
+	for _, unit := range units {
+		size /= 1024
+		if size < 1024 {
+			return fmt.Sprintf("%.1f%s", size, unit)
+		}
+	}
+	return fmt.Sprintf("%.1fPB", size/1024)
+}
+
+// ParseSize parses a size such as 40G, 512M or 1.5T with binary units, the
+// way qemu-img does. A bare number is bytes.
+func ParseSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+	trimmed = strings.TrimSuffix(strings.TrimSuffix(trimmed, "IB"), "B")
+	multiplier := int64(1)
+	if trimmed != "" {
+		if exponent := strings.IndexByte("KMGT", trimmed[len(trimmed)-1]); exponent >= 0 {
+			multiplier = int64(1) << (10 * (exponent + 1))
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || number <= 0 || number*float64(multiplier) >= 1<<62 {
+		return 0, fmt.Errorf("invalid size %q: expected a size like 40G", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
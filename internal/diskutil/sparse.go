@@ -0,0 +1,133 @@
+package diskutil
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const sparseBlockSize = 64 * 1024
+
+type DiskUsage struct {
+	ApparentBytes  int64
+	AllocatedBytes int64
+}
+
+func (usage DiskUsage) Add(other DiskUsage) DiskUsage {
+	return DiskUsage{
+		ApparentBytes:  usage.ApparentBytes + other.ApparentBytes,
+		AllocatedBytes: usage.AllocatedBytes + other.AllocatedBytes,
+	}
+}
+
+func FileUsage(path string) (DiskUsage, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	return usageFromInfo(info), nil
+}
+
+func DirUsage(root string) (DiskUsage, error) {
+	total := DiskUsage{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total = total.Add(usageFromInfo(info))
+		return nil
+	})
+	return total, err
+}
+
+func usageFromInfo(info os.FileInfo) DiskUsage {
+	usage := DiskUsage{ApparentBytes: info.Size(), AllocatedBytes: info.Size()}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		usage.AllocatedBytes = int64(stat.Blocks) * 512
+	}
+	return usage
+}
+
+// CopyFile copies sourcePath to destinationPath, as a copy-on-write clone
+// when the filesystem supports it (APFS, btrfs, XFS) and otherwise as a
+// sparse copy that skips zero blocks.
+func CopyFile(sourcePath string, destinationPath string) error {
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(destinationPath), 0o755); err != nil {
+		return err
+	}
+
+	temporaryPath := destinationPath + ".tmp"
+	_ = os.Remove(temporaryPath)
+	if err := cloneFile(sourcePath, temporaryPath); err == nil {
+		if err := os.Rename(temporaryPath, destinationPath); err != nil {
+			_ = os.Remove(temporaryPath)
+			return err
+		}
+		return nil
+	}
+	targetFile, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	written, err := WriteSparse(targetFile, sourceFile)
+	if err == nil {
+		err = targetFile.Truncate(written)
+	}
+	if err != nil {
+		targetFile.Close()
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	if err := targetFile.Close(); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+
+	if err := os.Rename(temporaryPath, destinationPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
+
+func WriteSparse(target *os.File, source io.Reader) (int64, error) {
+	buffer := make([]byte, sparseBlockSize)
+	zeros := make([]byte, sparseBlockSize)
+	var written int64
+	for {
+		readBytes, readErr := io.ReadFull(source, buffer)
+		if readBytes > 0 {
+			block := buffer[:readBytes]
+			if bytes.Equal(block, zeros[:readBytes]) {
+				if _, err := target.Seek(int64(readBytes), io.SeekCurrent); err != nil {
+					return written, err
+				}
+			} else if _, err := target.Write(block); err != nil {
+				return written, err
+			}
+			written += int64(readBytes)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+)
+
+const (
+	DefaultRetries    = 3
+	defaultRetryDelay = time.Second
+	progressInterval  = 120 * time.Millisecond
+)
+
+type Options struct {
+	Out        io.Writer
+	Label      string
+	Progress   func(downloaded int64, total int64)
+	SHA256     string
+	Retries    int
+	RetryDelay time.Duration
+	Client     *http.Client
+}
+
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+func Download(ctx context.Context, rawURL string, destination string, options Options) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+		return err
+	}
+	delay := options.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		release, err := AcquireHost(ctx, rawURL)
+		if err != nil {
+			return err
+		}
+		err = downloadOnce(ctx, rawURL, destination, options)
+		release()
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) || attempt >= options.Retries || ctx.Err() != nil {
+			return err
+		}
+		if options.Out != nil {
+			fmt.Fprintf(options.Out, "%s: %v; retrying (%d/%d)\n", labelOrURL(options.Label, rawURL), err, attempt+1, options.Retries)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func downloadOnce(ctx context.Context, rawURL string, destination string, options Options) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return &retryableError{err: err}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("request failed with status %s", response.Status)
+		if response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests {
+			return &retryableError{err: err}
+		}
+		return err
+	}
+
+	return Save(ctx, Throttle(ctx, response.Body), response.ContentLength, destination, options)
+}
+
+// Save streams reader into destination through a temporary file, reporting
+// progress and verifying options.SHA256 before the file is renamed into
+// place. Read failures are retryable when Save runs under Download.
+func Save(ctx context.Context, reader io.Reader, total int64, destination string, options Options) error {
+	temporaryPath := destination + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		file.Close()
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+
+	var hasher hash.Hash
+	writer := io.Writer(file)
+	if options.SHA256 != "" {
+		hasher = sha256.New()
+		writer = io.MultiWriter(file, hasher)
+	}
+
+	progress := newProgress(options, total)
+	if _, err := io.Copy(writer, io.TeeReader(reader, progress)); err != nil {
+		progress.finish()
+		if ctx.Err() != nil {
+			return fail(err)
+		}
+		return fail(&retryableError{err: err})
+	}
+	progress.finish()
+
+	if hasher != nil {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(actual, strings.TrimSpace(options.SHA256)) {
+			return fail(fmt.Errorf("sha256 mismatch for %s: expected %s got %s", destination, options.SHA256, actual))
+		}
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	if err := os.Rename(temporaryPath, destination); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
+
+func RenderProgress(out io.Writer, label string, downloaded int64, total int64) {
+	if total > 0 {
+		percent := float64(downloaded) / float64(total) * 100
+		if percent > 100 {
+			percent = 100
+		}
+		barWidth := 28
+		filled := int(float64(downloaded) / float64(total) * float64(barWidth))
+		if filled > barWidth {
+			filled = barWidth
+		}
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+		fmt.Fprintf(out, "\r%-8s [%s] %5.1f%% %s/%s", label, bar, percent, diskutil.HumanBytes(downloaded), diskutil.HumanBytes(total))
+		return
+	}
+	fmt.Fprintf(out, "\r%-8s downloaded %s", label, diskutil.HumanBytes(downloaded))
+}
+
+type progressWriter struct {
+	options    Options
+	total      int64
+	downloaded int64
+	lastRender time.Time
+}
+
+func newProgress(options Options, total int64) *progressWriter {
+	return &progressWriter{options: options, total: total}
+}
+
+func (p *progressWriter) Write(payload []byte) (int, error) {
+	p.downloaded += int64(len(payload))
+	if p.options.Progress != nil {
+		p.options.Progress(p.downloaded, p.total)
+	}
+	if p.options.Out != nil && time.Since(p.lastRender) >= progressInterval {
+		p.lastRender = time.Now()
+		RenderProgress(p.options.Out, p.options.Label, p.downloaded, p.total)
+	}
+	return len(payload), nil
+}
+
+func (p *progressWriter) finish() {
+	if p.options.Out != nil {
+		RenderProgress(p.options.Out, p.options.Label, p.downloaded, p.total)
+		fmt.Fprintln(p.options.Out)
+	}
+}
+
+func labelOrURL(label string, rawURL string) string {
+	if strings.TrimSpace(label) != "" {
+		return label
+	}
+	return rawURL
+}
@@ -0,0 +1,189 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadWritesFileAndReportsProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Length", "7")
+		_, _ = writer.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "nested", "artifact")
+	var output strings.Builder
+	var reported int64
+	options := Options{
+		Out:      &output,
+		Label:    "image",
+		Progress: func(downloaded int64, total int64) { reported = downloaded },
+		SHA256:   "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5",
+	}
+	if err := Download(context.Background(), server.URL, path, options); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read downloaded file: %v", err)
+	}
+	if string(body) != "payload" {
+		t.Fatalf("unexpected body: %q", string(body))
+	}
+	if reported != 7 {
+		t.Fatalf("expected progress callback to reach 7 bytes, got %d", reported)
+	}
+	if !strings.Contains(output.String(), "100.0%") {
+		t.Fatalf("expected progress output, got %q", output.String())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary file to be removed, got %v", err)
+	}
+}
+
+func TestDownloadRetriesTransientFailures(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if requests.Add(1) < 3 {
+			http.Error(writer, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = writer.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "artifact")
+	var output strings.Builder
+	options := Options{Out: &output, Label: "image", Retries: 2, RetryDelay: time.Millisecond}
+	if err := Download(context.Background(), server.URL, path, options); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests.Load())
+	}
+	if !strings.Contains(output.String(), "retrying (2/2)") {
+		t.Fatalf("expected retry notice, got %q", output.String())
+	}
+}
+
+func TestDownloadDoesNotRetryClientErrorsOrChecksumMismatch(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests.Add(1)
+		if request.URL.Path == "/missing" {
+			http.NotFound(writer, request)
+			return
+		}
+		_, _ = writer.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "artifact")
+	options := Options{Retries: 3, RetryDelay: time.Millisecond}
+	if err := Download(context.Background(), server.URL+"/missing", path, options); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected 404 error, got %v", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("expected a single request for 404, got %d", requests.Load())
+	}
+
+	options.SHA256 = strings.Repeat("0", 64)
+	if err := Download(context.Background(), server.URL, path, options); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected sha256 mismatch, got %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("expected a single request for checksum mismatch, got %d", requests.Load()-1)
+	}
+	for _, leftover := range []string{path, path + ".tmp"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be absent, got %v", leftover, err)
+		}
+	}
+}
+
+func TestDownloadStopsRetryingWhenContextIsCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "busy", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err := Download(ctx, server.URL, filepath.Join(t.TempDir(), "artifact"), Options{Retries: 10, RetryDelay: time.Second})
+	if err == nil {
+		t.Fatal("expected canceled download to fail")
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatalf("download kept retrying after cancellation: %v", err)
+	}
+}
+
+func TestDownloadHonorsBandwidthLimitAndHostConcurrency(t *testing.T) {
+	payload := strings.Repeat("x", 64<<10)
+	var active, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		current := active.Add(1)
+		defer active.Add(-1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, _ = writer.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	defer SetHostConcurrency(1)()
+	defer SetBandwidthLimit(256 << 10)()
+	dir := t.TempDir()
+	started := time.Now()
+	errs := make(chan error, 3)
+	for index := 0; index < 3; index++ {
+		go func(index int) {
+			errs <- Download(context.Background(), server.URL, filepath.Join(dir, strconv.Itoa(index)), Options{})
+		}(index)
+	}
+	for index := 0; index < 3; index++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+	}
+	if peak.Load() != 1 {
+		t.Fatalf("expected one download per host at a time, saw %d", peak.Load())
+	}
+	if elapsed := time.Since(started); elapsed < 500*time.Millisecond {
+		t.Fatalf("expected 192KiB at 256KiB/s to take at least 500ms, took %s", elapsed)
+	}
+}
+
+func TestRankMirrorsPutsResponsiveMirrorsFirstByLatency(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	defer fast.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.NotFound(writer, request)
+	}))
+	defer broken.Close()
+
+	candidates := []string{broken.URL + "/base.img", "s3://bucket/base.img", slow.URL + "/base.img", fast.URL + "/base.img"}
+	ranked := RankMirrors(context.Background(), candidates, nil)
+	expected := []string{fast.URL + "/base.img", slow.URL + "/base.img", broken.URL + "/base.img", "s3://bucket/base.img"}
+	if strings.Join(ranked, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected mirror order %v", ranked)
+	}
+}
@@ -0,0 +1,140 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHostConcurrency = 4
+	throttleChunkBytes     = 32 << 10
+)
+
+var (
+	limitsMu        sync.Mutex
+	bandwidth       *rateLimiter
+	hostConcurrency = DefaultHostConcurrency
+	hostSlots       = map[string]chan struct{}{}
+)
+
+// SetBandwidthLimit caps the combined throughput of every download in the
+// process at bytesPerSecond; zero removes the cap. It returns a function
+// restoring the previous limit.
+func SetBandwidthLimit(bytesPerSecond int64) func() {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	previous := bandwidth
+	bandwidth = nil
+	if bytesPerSecond > 0 {
+		bandwidth = &rateLimiter{bytesPerSecond: bytesPerSecond}
+	}
+	return func() {
+		limitsMu.Lock()
+		defer limitsMu.Unlock()
+		bandwidth = previous
+	}
+}
+
+// SetHostConcurrency caps how many downloads may talk to one host at a time.
+// It returns a function restoring the previous cap.
+func SetHostConcurrency(limit int) func() {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	previous := hostConcurrency
+	hostConcurrency = max(limit, 1)
+	hostSlots = map[string]chan struct{}{}
+	return func() {
+		limitsMu.Lock()
+		defer limitsMu.Unlock()
+		hostConcurrency = previous
+		hostSlots = map[string]chan struct{}{}
+	}
+}
+
+// AcquireHost waits for a free download slot on the host of rawURL and
+// returns the function releasing it.
+func AcquireHost(ctx context.Context, rawURL string) (func(), error) {
+	host := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Host != "" {
+		host = strings.ToLower(parsed.Host)
+	}
+	limitsMu.Lock()
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, hostConcurrency)
+		hostSlots[host] = slots
+	}
+	limitsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Throttle wraps reader so it draws from the process-wide bandwidth limit.
+// It returns reader unchanged when no limit is set.
+func Throttle(ctx context.Context, reader io.Reader) io.Reader {
+	limitsMu.Lock()
+	limiter := bandwidth
+	limitsMu.Unlock()
+	if limiter == nil {
+		return reader
+	}
+	return &throttledReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *throttledReader) Read(payload []byte) (int, error) {
+	if len(payload) > throttleChunkBytes {
+		payload = payload[:throttleChunkBytes]
+	}
+	count, err := r.reader.Read(payload)
+	if count > 0 {
+		if waitErr := r.limiter.wait(r.ctx, count); waitErr != nil {
+			return count, waitErr
+		}
+	}
+	return count, err
+}
+
+// rateLimiter spaces reads out so that, across all readers sharing it, bytes
+// arrive no faster than bytesPerSecond.
+type rateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	next           time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context, count int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(count) * int64(time.Second) / l.bytesPerSecond))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const mirrorProbeTimeout = 3 * time.Second
+
+// RankMirrors orders candidate URLs fastest-first. Each http(s) candidate is
+// timed with a HEAD request; those that answer come first by latency, and the
+// rest, including other schemes, follow in their listed order so they are
+// still tried as fallbacks.
+func RankMirrors(ctx context.Context, candidates []string, client *http.Client) []string {
+	if len(candidates) < 2 {
+		return candidates
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	latencies := make([]time.Duration, len(candidates))
+	var waitGroup sync.WaitGroup
+	for index, candidate := range candidates {
+		parsed, err := url.Parse(candidate)
+		if err != nil || (!strings.EqualFold(parsed.Scheme, "http") && !strings.EqualFold(parsed.Scheme, "https")) {
+			continue
+		}
+		waitGroup.Add(1)
+		go func(index int, candidate string) {
+			defer waitGroup.Done()
+			latencies[index] = probeMirror(ctx, candidate, client)
+		}(index, candidate)
+	}
+	waitGroup.Wait()
+
+	order := make([]int, len(candidates))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(left int, right int) bool {
+		a, b := latencies[order[left]], latencies[order[right]]
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+	ranked := make([]string, len(candidates))
+	for slot, index := range order {
+		ranked[slot] = candidates[index]
+	}
+	return ranked
+}
+
+func probeMirror(ctx context.Context, candidate string, client *http.Client) time.Duration {
+	probeCtx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(probeCtx, http.MethodHead, candidate, nil)
+	if err != nil {
+		return 0
+	}
+	started := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		return 0
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0
+	}
+	return max(time.Since(started), time.Nanosecond)
+}
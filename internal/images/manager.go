@@ -6,13 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/fetch"
 )
 
 const (
@@ -22,6 +23,10 @@ const (
 
 var ErrImageNotFetched = errors.New("image not fetched")
 
+// ErrImageInUse is returned when a commit or import would replace an image
+// disk that instance overlays still read their unchanged blocks from.
+var ErrImageInUse = errors.New("image is in use")
+
 type Metadata struct {
 	Ref          string    `json:"ref"`
 	Version      string    `json:"version"`
@@ -32,19 +37,40 @@ type Metadata struct {
 	RuntimeDisk  string    `json:"runtime_disk"`
 	Ready        bool      `json:"ready"`
 	DiskFormat   string    `json:"disk_format"`
+	Source       string    `json:"source,omitempty"`
+	Base         string    `json:"base,omitempty"`
+	RootfsTar    string    `json:"rootfs_tar,omitempty"`
 	FetchedAtUTC time.Time `json:"fetched_at_utc"`
 	UpdatedAtUTC time.Time `json:"updated_at_utc"`
 }
 
 type Manager struct {
-	root   string
-	stdout io.Writer
+	root       string
+	stdout     io.Writer
+	progress   func(downloaded int64, total int64)
+	dependents func(diskPath string) ([]string, error)
 }
 
 func NewManager(root string, stdout io.Writer) *Manager {
 	return &Manager{root: root, stdout: stdout}
 }
 
+// WithProgress returns a copy of the manager that reports image download
+// progress to the callback.
+func (m *Manager) WithProgress(progress func(downloaded int64, total int64)) *Manager {
+	copied := *m
+	copied.progress = progress
+	return &copied
+}
+
+// WithDependents returns a copy of the manager that refuses to replace an
+// image disk for which dependents reports overlays backed by it.
+func (m *Manager) WithDependents(dependents func(diskPath string) ([]string, error)) *Manager {
+	copied := *m
+	copied.dependents = dependents
+	return &copied
+}
+
 func (m *Manager) List() ([]Metadata, error) {
 	imagesRoot := m.imagesRoot()
 	if err := os.MkdirAll(imagesRoot, 0o755); err != nil {
@@ -132,12 +158,11 @@ func (m *Manager) ListAvailable() ([]Metadata, error) {
 }
 
 func (m *Manager) Resolve(ref string) (Metadata, error) {
-	parsed, err := ParseUbuntuRef(ref)
+	imageDir, err := m.imageDirForRef(ref)
 	if err != nil {
 		return Metadata{}, err
 	}
 
-	imageDir := filepath.Join(m.imagesRoot(), parsed.ImageDirName())
 	metaPath := filepath.Join(imageDir, metadataFileName)
 	meta, err := readMetadata(metaPath)
 	if err != nil {
@@ -153,12 +178,20 @@ func (m *Manager) Resolve(ref string) (Metadata, error) {
 	}
 	meta.Ready = true
 	if meta.DiskFormat == "" {
-		meta.DiskFormat = detectDownloadedDiskFormat(meta.RuntimeDisk)
+		meta.DiskFormat = diskutil.DetectFormat(meta.RuntimeDisk)
 	}
 	return meta, nil
 }
 
 func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
+	if IsLocalRef(ref) {
+		meta, err := m.Resolve(ref)
+		if errors.Is(err, ErrImageNotFetched) {
+			return Metadata{}, fmt.Errorf("local image %s not found: create it with `clawfarm commit`", ref)
+		}
+		return meta, err
+	}
+
 	parsed, err := ParseUbuntuRef(ref)
 	if err != nil {
 		return Metadata{}, err
@@ -179,7 +212,7 @@ func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
 			cachedMeta.RuntimeDisk = diskPath
 			cachedMeta.Ready = true
 			if cachedMeta.DiskFormat == "" {
-				cachedMeta.DiskFormat = detectDownloadedDiskFormat(diskPath)
+				cachedMeta.DiskFormat = diskutil.DetectFormat(diskPath)
 			}
 			if m.stdout != nil {
 				fmt.Fprintf(m.stdout, "using cached image %s\n", cachedMeta.Ref)
@@ -200,7 +233,7 @@ func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
 			ImageDir:     imageDir,
 			RuntimeDisk:  diskPath,
 			Ready:        true,
-			DiskFormat:   detectDownloadedDiskFormat(diskPath),
+			DiskFormat:   diskutil.DetectFormat(diskPath),
 			FetchedAtUTC: now,
 			UpdatedAtUTC: now,
 		}
@@ -213,7 +246,7 @@ func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
 		return generatedMeta, nil
 	}
 
-	if err := ensureDownloadedFile(ctx, parsed.BaseImageURL(), diskPath, m.stdout, "image"); err != nil {
+	if err := ensureDownloadedFile(ctx, parsed.BaseImageURL(), diskPath, fetch.Options{Out: m.stdout, Label: "image", Progress: m.progress}); err != nil {
 		return Metadata{}, fmt.Errorf("download image: %w", err)
 	}
 
@@ -227,7 +260,7 @@ func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
 		ImageDir:     imageDir,
 		RuntimeDisk:  diskPath,
 		Ready:        true,
-		DiskFormat:   detectDownloadedDiskFormat(diskPath),
+		DiskFormat:   diskutil.DetectFormat(diskPath),
 		FetchedAtUTC: now,
 		UpdatedAtUTC: now,
 	}
@@ -239,208 +272,113 @@ func (m *Manager) Fetch(ctx context.Context, ref string) (Metadata, error) {
 	return meta, nil
 }
 
-func (m *Manager) imagesRoot() string {
-	return filepath.Join(m.root, "images")
-}
+func (m *Manager) Commit(ref string, sourceDisk string, arch string, source string) (Metadata, error) {
+	parsed, err := ParseLocalRef(ref)
+	if err != nil {
+		return Metadata{}, err
+	}
 
-func normalizeMetadata(imageDir string, meta Metadata) Metadata {
-	if meta.ImageDir == "" {
-		meta.ImageDir = imageDir
+	imageDir := filepath.Join(m.imagesRoot(), parsed.ImageDirName())
+	if err := os.MkdirAll(imageDir, 0o755); err != nil {
+		return Metadata{}, err
 	}
-	meta.RuntimeDisk = filepath.Join(imageDir, imageFileName)
-	if meta.Arch == "" && meta.Ref != "" {
-		if parsed, err := ParseUbuntuRef(meta.Ref); err == nil {
-			meta.Arch = parsed.Arch
-			if meta.Version == "" {
-				meta.Version = parsed.Version
-			}
-			if meta.Codename == "" {
-				meta.Codename = parsed.Codename
-			}
-			if meta.Date == "" {
-				meta.Date = parsed.Date
-			}
-		}
+	diskPath := filepath.Join(imageDir, imageFileName)
+	if err := m.ensureReplaceable(parsed.Original, diskPath); err != nil {
+		return Metadata{}, err
 	}
-	return meta
-}
-
-func ensureDownloadedFile(ctx context.Context, url string, destination string, out io.Writer, label string) error {
-	if fileExistsAndNonEmpty(destination) {
-		return nil
+	if diskutil.BackingFile(sourceDisk) != "" {
+		err = diskutil.Convert(context.Background(), sourceDisk, diskPath, "qcow2", nil)
+	} else {
+		err = diskutil.CopyFile(sourceDisk, diskPath)
 	}
-	return downloadFile(ctx, url, destination, out, label)
-}
-
-func downloadFile(ctx context.Context, url string, destination string, out io.Writer, label string) error {
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return Metadata{}, err
 	}
 
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return err
+	now := time.Now().UTC()
+	meta := Metadata{
+		Ref:          parsed.Original,
+		Arch:         arch,
+		ImageDir:     imageDir,
+		RuntimeDisk:  diskPath,
+		Ready:        true,
+		DiskFormat:   diskutil.DetectFormat(diskPath),
+		Source:       source,
+		FetchedAtUTC: now,
+		UpdatedAtUTC: now,
 	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed with status %s", response.Status)
+	if err := writeMetadata(filepath.Join(imageDir, metadataFileName), meta); err != nil {
+		return Metadata{}, err
 	}
+	return meta, nil
+}
 
-	tempFile := destination + ".tmp"
-	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
-		return err
+// ensureReplaceable refuses to overwrite diskPath while instance overlays use
+// it as their backing file: they would silently read blocks of the new image.
+func (m *Manager) ensureReplaceable(ref string, diskPath string) error {
+	if m.dependents == nil || !fileExistsAndNonEmpty(diskPath) {
+		return nil
 	}
-
-	file, err := os.Create(tempFile)
+	users, err := m.dependents(diskPath)
 	if err != nil {
 		return err
 	}
-
-	if out == nil {
-		if _, err := io.Copy(file, response.Body); err != nil {
-			file.Close()
-			_ = os.Remove(tempFile)
-			return err
-		}
-	} else {
-		buffer := make([]byte, 1024*1024)
-		total := response.ContentLength
-		var downloaded int64
-		lastRender := time.Time{}
-		render := func(force bool) {
-			if !force && !lastRender.IsZero() && time.Since(lastRender) < 120*time.Millisecond {
-				return
-			}
-			lastRender = time.Now()
-			renderDownloadProgress(out, label, downloaded, total)
-		}
-
-		for {
-			readBytes, readErr := response.Body.Read(buffer)
-			if readBytes > 0 {
-				writtenBytes, writeErr := file.Write(buffer[:readBytes])
-				if writeErr != nil {
-					file.Close()
-					_ = os.Remove(tempFile)
-					return writeErr
-				}
-				if writtenBytes != readBytes {
-					file.Close()
-					_ = os.Remove(tempFile)
-					return io.ErrShortWrite
-				}
-				downloaded += int64(readBytes)
-				render(false)
-			}
-
-			if readErr == io.EOF {
-				render(true)
-				fmt.Fprintln(out)
-				break
-			}
-			if readErr != nil {
-				file.Close()
-				_ = os.Remove(tempFile)
-				return readErr
-			}
-		}
-	}
-
-	if err := file.Close(); err != nil {
-		_ = os.Remove(tempFile)
-		return err
-	}
-
-	if err := os.Rename(tempFile, destination); err != nil {
-		_ = os.Remove(tempFile)
-		return err
+	if len(users) > 0 {
+		return fmt.Errorf("%w: %s backs the disks of %s; use a new tag or remove them first", ErrImageInUse, ref, strings.Join(users, ", "))
 	}
-
 	return nil
 }
 
-func renderDownloadProgress(out io.Writer, label string, downloaded int64, total int64) {
-	if total > 0 {
-		percent := float64(downloaded) / float64(total) * 100
-		if percent > 100 {
-			percent = 100
-		}
-		barWidth := 28
-		filled := int(float64(downloaded) / float64(total) * float64(barWidth))
-		if filled > barWidth {
-			filled = barWidth
+func (m *Manager) imageDirForRef(ref string) (string, error) {
+	if IsLocalRef(ref) {
+		parsed, err := ParseLocalRef(ref)
+		if err != nil {
+			return "", err
 		}
-		bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
-		fmt.Fprintf(out, "\r%-6s [%s] %5.1f%% %s/%s", label, bar, percent, humanBytes(downloaded), humanBytes(total))
-		return
-	}
-	fmt.Fprintf(out, "\r%-6s downloaded %s", label, humanBytes(downloaded))
-}
-
-func humanBytes(value int64) string {
-	if value < 1024 {
-		return fmt.Sprintf("%dB", value)
+		return filepath.Join(m.imagesRoot(), parsed.ImageDirName()), nil
 	}
-	units := []string{"KB", "MB", "GB", "TB"}
-	size := float64(value)
-	for _, unit := range units {
-		size /= 1024
-		if size < 1024 {
-			return fmt.Sprintf("%.1f%s", size, unit)
-		}
+	parsed, err := ParseUbuntuRef(ref)
+	if err != nil {
+		return "", err
 	}
-	return fmt.Sprintf("%.1fPB", size/1024)
+	return filepath.Join(m.imagesRoot(), parsed.ImageDirName()), nil
 }
 
-func detectDownloadedDiskFormat(imagePath string) string {
-	if qemuImgPath, err := exec.LookPath("qemu-img"); err == nil {
-		if format, detectErr := detectDiskFormat(qemuImgPath, imagePath); detectErr == nil {
-			return format
-		}
-	}
-	if format, err := detectDiskFormatByMagic(imagePath); err == nil {
-		return format
-	}
-	return "unknown"
+func (m *Manager) imagesRoot() string {
+	return filepath.Join(m.root, "images")
 }
 
-func detectDiskFormat(qemuBinary, imagePath string) (string, error) {
-	cmd := exec.Command(qemuBinary, "info", "--output=json", imagePath)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-
-	var payload struct {
-		Format string `json:"format"`
+func normalizeMetadata(imageDir string, meta Metadata) Metadata {
+	if meta.ImageDir == "" {
+		meta.ImageDir = imageDir
 	}
-	if err := json.Unmarshal(output, &payload); err != nil {
-		return "", err
+	meta.RuntimeDisk = filepath.Join(imageDir, imageFileName)
+	if meta.RootfsTar != "" {
+		meta.RootfsTar = filepath.Join(imageDir, ociRootfsDirName, ociRootfsFileName)
 	}
-	if payload.Format == "" {
-		return "", errors.New("empty format")
+	if meta.Arch == "" && meta.Ref != "" {
+		if parsed, err := ParseUbuntuRef(meta.Ref); err == nil {
+			meta.Arch = parsed.Arch
+			if meta.Version == "" {
+				meta.Version = parsed.Version
+			}
+			if meta.Codename == "" {
+				meta.Codename = parsed.Codename
+			}
+			if meta.Date == "" {
+				meta.Date = parsed.Date
+			}
+		}
 	}
-	return payload.Format, nil
+	return meta
 }
 
-func detectDiskFormatByMagic(imagePath string) (string, error) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	header := make([]byte, 4)
-	if _, err := io.ReadFull(file, header); err != nil {
-		return "", err
-	}
-
-	if string(header) == "QFI\xfb" {
-		return "qcow2", nil
+func ensureDownloadedFile(ctx context.Context, url string, destination string, options fetch.Options) error {
+	if fileExistsAndNonEmpty(destination) {
+		return nil
 	}
-	return "raw", nil
+	options.Retries = fetch.DefaultRetries
+	return fetch.Download(ctx, url, destination, options)
 }
 
 func writeMetadata(path string, metadata Metadata) error {
@@ -2,8 +2,7 @@ package images
 
 import (
 	"context"
-	"net/http"
-	"net/http/httptest"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -12,35 +11,6 @@ import (
 	"time"
 )
 
-func TestDetectDiskFormatByMagic(t *testing.T) {
-	tmpDir := t.TempDir()
-	qcowPath := filepath.Join(tmpDir, "disk.qcow2")
-	rawPath := filepath.Join(tmpDir, "disk.raw")
-
-	if err := os.WriteFile(qcowPath, append([]byte("QFI\xfb"), []byte("rest")...), 0o644); err != nil {
-		t.Fatalf("write qcow2: %v", err)
-	}
-	if err := os.WriteFile(rawPath, []byte("RAW!"), 0o644); err != nil {
-		t.Fatalf("write raw: %v", err)
-	}
-
-	format, err := detectDiskFormatByMagic(qcowPath)
-	if err != nil {
-		t.Fatalf("detect qcow2 failed: %v", err)
-	}
-	if format != "qcow2" {
-		t.Fatalf("unexpected format: %s", format)
-	}
-
-	format, err = detectDiskFormatByMagic(rawPath)
-	if err != nil {
-		t.Fatalf("detect raw failed: %v", err)
-	}
-	if format != "raw" {
-		t.Fatalf("unexpected format: %s", format)
-	}
-}
-
 func TestManagerListAndResolve(t *testing.T) {
 	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
 		t.Skip("unsupported architecture in test environment")
@@ -143,44 +113,6 @@ func TestListAvailableIncludesDownloadedMarker(t *testing.T) {
 	}
 }
 
-func TestDownloadFile(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		_, _ = writer.Write([]byte("payload"))
-	}))
-	defer server.Close()
-
-	tmpDir := t.TempDir()
-	path := filepath.Join(tmpDir, "artifact")
-	if err := downloadFile(context.Background(), server.URL, path, nil, ""); err != nil {
-		t.Fatalf("downloadFile failed: %v", err)
-	}
-	body, err := os.ReadFile(path)
-	if err != nil {
-		t.Fatalf("read downloaded file: %v", err)
-	}
-	if string(body) != "payload" {
-		t.Fatalf("unexpected body: %q", string(body))
-	}
-}
-
-func TestDownloadFileWithProgress(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		writer.Header().Set("Content-Length", "7")
-		_, _ = writer.Write([]byte("payload"))
-	}))
-	defer server.Close()
-
-	tmpDir := t.TempDir()
-	path := filepath.Join(tmpDir, "artifact")
-	var output strings.Builder
-	if err := downloadFile(context.Background(), server.URL, path, &output, "image"); err != nil {
-		t.Fatalf("downloadFile failed: %v", err)
-	}
-	if !strings.Contains(output.String(), "100.0%") {
-		t.Fatalf("expected progress output, got %q", output.String())
-	}
-}
-
 func TestFetchUsesCachedArtifactsWithoutDownloading(t *testing.T) {
 	tmpDir := t.TempDir()
 	var output strings.Builder
@@ -237,3 +169,75 @@ func TestFetchUsesCachedArtifactsWithoutDownloading(t *testing.T) {
 		t.Fatalf("expected cached artifact unchanged")
 	}
 }
+
+func TestManagerCommitCreatesResolvableLocalImage(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := NewManager(tmpDir, nil)
+
+	sourceDisk := filepath.Join(tmpDir, "instance.img")
+	if err := os.WriteFile(sourceDisk, []byte("QFI\xfbprepared"), 0o644); err != nil {
+		t.Fatalf("write source disk: %v", err)
+	}
+
+	committed, err := manager.Commit("mydev:v1", sourceDisk, "arm64", "commit:claw-1234")
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if committed.DiskFormat != "qcow2" {
+		t.Fatalf("unexpected disk format: %s", committed.DiskFormat)
+	}
+
+	resolved, err := manager.Resolve("mydev:v1")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if resolved.Arch != "arm64" || resolved.Source != "commit:claw-1234" {
+		t.Fatalf("unexpected resolved metadata: %#v", resolved)
+	}
+	payload, err := os.ReadFile(resolved.RuntimeDisk)
+	if err != nil || string(payload) != "QFI\xfbprepared" {
+		t.Fatalf("unexpected committed disk %q err=%v", payload, err)
+	}
+
+	if _, err := manager.Resolve("mydev:v2"); err != ErrImageNotFetched {
+		t.Fatalf("expected ErrImageNotFetched for missing local image, got %v", err)
+	}
+	if _, err := manager.Fetch(context.Background(), "mydev:v2"); err == nil || !strings.Contains(err.Error(), "clawfarm commit") {
+		t.Fatalf("expected commit hint when fetching missing local image, got %v", err)
+	}
+}
+
+func TestManagerCommitRefusesToReplaceImageBackingOverlays(t *testing.T) {
+	tmpDir := t.TempDir()
+	sourceDisk := filepath.Join(tmpDir, "instance.img")
+	if err := os.WriteFile(sourceDisk, []byte("QFI\xfbv1"), 0o644); err != nil {
+		t.Fatalf("write source disk: %v", err)
+	}
+	manager := NewManager(tmpDir, nil)
+	committed, err := manager.Commit("mydev:v1", sourceDisk, "arm64", "commit:claw-1234")
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	var checked string
+	guarded := manager.WithDependents(func(diskPath string) ([]string, error) {
+		checked = diskPath
+		return []string{"claw-5678"}, nil
+	})
+	if err := os.WriteFile(sourceDisk, []byte("QFI\xfbv2"), 0o644); err != nil {
+		t.Fatalf("rewrite source disk: %v", err)
+	}
+	if _, err := guarded.Commit("mydev:v1", sourceDisk, "arm64", "commit:claw-1234"); !errors.Is(err, ErrImageInUse) || !strings.Contains(err.Error(), "claw-5678") {
+		t.Fatalf("expected ErrImageInUse naming the dependent instance, got %v", err)
+	}
+	if checked != committed.RuntimeDisk {
+		t.Fatalf("dependents checked %q, want %q", checked, committed.RuntimeDisk)
+	}
+	if payload, err := os.ReadFile(committed.RuntimeDisk); err != nil || string(payload) != "QFI\xfbv1" {
+		t.Fatalf("image disk changed despite dependents: %q err=%v", payload, err)
+	}
+
+	if _, err := guarded.Commit("mydev:v2", sourceDisk, "arm64", "commit:claw-1234"); err != nil {
+		t.Fatalf("a new tag has no dependents and should commit: %v", err)
+	}
+}
@@ -0,0 +1,553 @@
+package images
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/fetch"
+)
+
+const (
+	ociRootfsDirName    = "rootfs"
+	ociRootfsFileName   = "rootfs.tar"
+	ociEnvProfilePath   = "etc/profile.d/clawfarm-oci.sh"
+	maxOCIDocumentBytes = 4 << 20
+	ociWhiteoutPrefix   = ".wh."
+	ociOpaqueWhiteout   = ".wh..wh..opq"
+)
+
+var ociManifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var authChallengeParamPattern = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+
+type OCIImportOptions struct {
+	Source  string
+	Ref     string
+	BaseRef string
+}
+
+type ociDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Size      int64        `json:"size"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociImageConfig struct {
+	Config struct {
+		Env []string `json:"Env"`
+	} `json:"config"`
+}
+
+type ociRegistry struct {
+	ref    OCIRef
+	client *http.Client
+	token  string
+}
+
+func (m *Manager) ImportOCI(ctx context.Context, options OCIImportOptions) (Metadata, error) {
+	source, err := ParseOCIRef(options.Source)
+	if err != nil {
+		return Metadata{}, err
+	}
+	ref := strings.TrimSpace(options.Ref)
+	if ref == "" {
+		ref = source.DefaultLocalRef()
+	}
+	parsed, err := ParseLocalRef(ref)
+	if err != nil {
+		return Metadata{}, err
+	}
+	baseRef := strings.TrimSpace(options.BaseRef)
+	if baseRef == "" {
+		baseRef = SupportedRefs()[0]
+	}
+	base, err := m.Fetch(ctx, baseRef)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("fetch helper base %s: %w", baseRef, err)
+	}
+	if base.RootfsTar != "" {
+		return Metadata{}, fmt.Errorf("helper base %s is itself an OCI import; use a bootable base such as %s", baseRef, SupportedRefs()[0])
+	}
+	arch := base.Arch
+	if arch == "" {
+		if arch, err = hostArch(); err != nil {
+			return Metadata{}, err
+		}
+	}
+
+	imageDir := filepath.Join(m.imagesRoot(), parsed.ImageDirName())
+	diskPath := filepath.Join(imageDir, imageFileName)
+	if err := m.ensureReplaceable(parsed.Original, diskPath); err != nil {
+		return Metadata{}, err
+	}
+	rootfsDir := filepath.Join(imageDir, ociRootfsDirName)
+	if err := os.MkdirAll(rootfsDir, 0o755); err != nil {
+		return Metadata{}, err
+	}
+	layersDir, err := os.MkdirTemp(rootfsDir, ".layers-")
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer os.RemoveAll(layersDir)
+
+	registry := &ociRegistry{ref: source, client: http.DefaultClient}
+	manifest, manifestDigest, err := registry.resolveManifest(ctx, arch)
+	if err != nil {
+		return Metadata{}, err
+	}
+	configPath := filepath.Join(layersDir, "config.json")
+	if err := registry.downloadBlob(ctx, manifest.Config, configPath); err != nil {
+		return Metadata{}, fmt.Errorf("download image config: %w", err)
+	}
+	var imageConfig ociImageConfig
+	if payload, readErr := os.ReadFile(configPath); readErr != nil {
+		return Metadata{}, readErr
+	} else if err := json.Unmarshal(payload, &imageConfig); err != nil {
+		return Metadata{}, fmt.Errorf("parse image config: %w", err)
+	}
+
+	layerPaths := make([]string, 0, len(manifest.Layers))
+	for index, layer := range manifest.Layers {
+		if m.stdout != nil {
+			fmt.Fprintf(m.stdout, "layer %d/%d %s (%s)\n", index+1, len(manifest.Layers), shortDigest(layer.Digest), diskutil.HumanBytes(layer.Size))
+		}
+		layerPath := filepath.Join(layersDir, fmt.Sprintf("layer-%03d", index+1))
+		if err := registry.downloadBlob(ctx, layer, layerPath); err != nil {
+			return Metadata{}, fmt.Errorf("download layer %s: %w", shortDigest(layer.Digest), err)
+		}
+		layerPaths = append(layerPaths, layerPath)
+	}
+
+	rootfsPath := filepath.Join(rootfsDir, ociRootfsFileName)
+	temporaryRootfs := rootfsPath + ".tmp"
+	if err := flattenOCILayers(layerPaths, imageConfig.Config.Env, temporaryRootfs); err != nil {
+		_ = os.Remove(temporaryRootfs)
+		return Metadata{}, fmt.Errorf("flatten layers: %w", err)
+	}
+	if err := os.Rename(temporaryRootfs, rootfsPath); err != nil {
+		_ = os.Remove(temporaryRootfs)
+		return Metadata{}, err
+	}
+
+	if err := diskutil.CopyFile(base.RuntimeDisk, diskPath); err != nil {
+		return Metadata{}, err
+	}
+
+	now := time.Now().UTC()
+	meta := Metadata{
+		Ref:          parsed.Original,
+		Arch:         arch,
+		ImageDir:     imageDir,
+		RuntimeDisk:  diskPath,
+		Ready:        true,
+		DiskFormat:   base.DiskFormat,
+		Source:       fmt.Sprintf("docker://%s/%s@%s", source.Registry, source.Repository, manifestDigest),
+		Base:         base.Ref,
+		RootfsTar:    rootfsPath,
+		FetchedAtUTC: now,
+		UpdatedAtUTC: now,
+	}
+	if err := writeMetadata(filepath.Join(imageDir, metadataFileName), meta); err != nil {
+		return Metadata{}, err
+	}
+	return meta, nil
+}
+
+func (r *ociRegistry) resolveManifest(ctx context.Context, arch string) (ociManifest, string, error) {
+	manifest, digest, err := r.fetchManifest(ctx, r.ref.Reference())
+	if err != nil {
+		return ociManifest{}, "", err
+	}
+	if len(manifest.Manifests) > 0 {
+		selected := ""
+		for _, candidate := range manifest.Manifests {
+			if candidate.Platform != nil && candidate.Platform.OS == "linux" && candidate.Platform.Architecture == arch {
+				selected = candidate.Digest
+				break
+			}
+		}
+		if selected == "" {
+			return ociManifest{}, "", fmt.Errorf("%s has no linux/%s image", r.ref.Original, arch)
+		}
+		if manifest, digest, err = r.fetchManifest(ctx, selected); err != nil {
+			return ociManifest{}, "", err
+		}
+	}
+	if manifest.Config.Digest == "" || len(manifest.Layers) == 0 {
+		return ociManifest{}, "", fmt.Errorf("%s: manifest has no config or layers", r.ref.Original)
+	}
+	return manifest, digest, nil
+}
+
+func (r *ociRegistry) fetchManifest(ctx context.Context, reference string) (ociManifest, string, error) {
+	response, err := r.get(ctx, "/manifests/"+reference, strings.Join(ociManifestMediaTypes, ", "))
+	if err != nil {
+		return ociManifest{}, "", err
+	}
+	defer response.Body.Close()
+	payload, err := io.ReadAll(io.LimitReader(response.Body, maxOCIDocumentBytes))
+	if err != nil {
+		return ociManifest{}, "", err
+	}
+	sum := sha256.Sum256(payload)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(reference, "sha256:") && digest != reference {
+		return ociManifest{}, "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", reference, digest)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return ociManifest{}, "", fmt.Errorf("parse manifest: %w", err)
+	}
+	return manifest, digest, nil
+}
+
+func (r *ociRegistry) downloadBlob(ctx context.Context, descriptor ociDescriptor, destination string) error {
+	if !ociDigestPattern.MatchString(descriptor.Digest) {
+		return fmt.Errorf("unsupported digest %q", descriptor.Digest)
+	}
+	response, err := r.get(ctx, "/blobs/"+descriptor.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	file, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), response.Body); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != descriptor.Digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", descriptor.Digest, actual)
+	}
+	return nil
+}
+
+// DownloadOCIBlob fetches a single blob referenced as
+// oci://<registry>/<repository>@sha256:<digest> into destination, for spec
+// artifacts published to a container registry.
+func DownloadOCIBlob(ctx context.Context, rawRef string, destination string, options fetch.Options) error {
+	ref, err := ParseOCIBlobRef(rawRef)
+	if err != nil {
+		return err
+	}
+	release, err := fetch.AcquireHost(ctx, ref.RegistryURL())
+	if err != nil {
+		return err
+	}
+	defer release()
+	registry := &ociRegistry{ref: ref, client: http.DefaultClient}
+	response, err := registry.get(ctx, "/blobs/"+ref.Digest, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if options.SHA256 == "" {
+		options.SHA256 = strings.TrimPrefix(ref.Digest, "sha256:")
+	}
+	return fetch.Save(ctx, fetch.Throttle(ctx, response.Body), response.ContentLength, destination, options)
+}
+
+func (r *ociRegistry) get(ctx context.Context, endpoint string, accept string) (*http.Response, error) {
+	requestURL := r.ref.RegistryURL() + "/v2/" + r.ref.Repository + endpoint
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		if r.token != "" {
+			request.Header.Set("Authorization", "Bearer "+r.token)
+		}
+		response, err := r.client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := response.Header.Get("WWW-Authenticate")
+			response.Body.Close()
+			if err := r.authenticate(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, fmt.Errorf("registry %s returned %s for %s", r.ref.Registry, response.Status, endpoint)
+		}
+		return response, nil
+	}
+}
+
+func (r *ociRegistry) authenticate(ctx context.Context, challenge string) error {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("registry %s requires %q authentication; only anonymous pulls are supported", r.ref.Registry, scheme)
+	}
+	params := map[string]string{}
+	for _, match := range authChallengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("registry %s sent a bearer challenge without a realm", r.ref.Registry)
+	}
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + r.ref.Repository + ":pull"
+	}
+	query := tokenURL.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("anonymous token request to %s failed with status %s", tokenURL.Host, response.Status)
+	}
+	var payload struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxOCIDocumentBytes)).Decode(&payload); err != nil {
+		return fmt.Errorf("parse registry token: %w", err)
+	}
+	r.token = payload.Token
+	if r.token == "" {
+		r.token = payload.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("registry %s returned an empty token", r.ref.Registry)
+	}
+	return nil
+}
+
+func flattenOCILayers(layerPaths []string, env []string, destination string) error {
+	file, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+
+	emitted := map[string]bool{}
+	removed := map[string]bool{}
+	opaque := map[string]bool{}
+	deferredLinks := make([]*tar.Header, 0)
+	if len(env) > 0 {
+		profile := renderOCIEnvProfile(env)
+		if err := writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./" + ociEnvProfilePath, Mode: 0o644, Size: int64(len(profile)), ModTime: time.Now().UTC()}); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, profile); err != nil {
+			return err
+		}
+		emitted[ociEnvProfilePath] = false
+	}
+
+	for index := len(layerPaths) - 1; index >= 0; index-- {
+		layerRemoved := map[string]bool{}
+		layerOpaque := map[string]bool{}
+		err := forEachLayerEntry(layerPaths[index], func(header *tar.Header, content io.Reader) error {
+			name, ok := cleanLayerPath(header.Name)
+			if !ok {
+				return nil
+			}
+			base := path.Base(name)
+			if base == ociOpaqueWhiteout {
+				layerOpaque[path.Dir(name)] = true
+				return nil
+			}
+			if strings.HasPrefix(base, ociWhiteoutPrefix) {
+				layerRemoved[path.Join(path.Dir(name), strings.TrimPrefix(base, ociWhiteoutPrefix))] = true
+				return nil
+			}
+			if _, seen := emitted[name]; seen || hiddenByUpperLayer(name, emitted, removed, opaque) {
+				return nil
+			}
+			emitted[name] = header.Typeflag == tar.TypeDir
+			header.Name = "./" + name
+			if header.Typeflag == tar.TypeLink {
+				target, ok := cleanLayerPath(header.Linkname)
+				if !ok {
+					return nil
+				}
+				header.Linkname = "./" + target
+				deferredLinks = append(deferredLinks, header)
+				return nil
+			}
+			if err := writer.WriteHeader(header); err != nil {
+				return err
+			}
+			if header.Typeflag == tar.TypeReg {
+				_, err := io.Copy(writer, content)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", index+1, err)
+		}
+		for name := range layerRemoved {
+			removed[name] = true
+		}
+		for name := range layerOpaque {
+			opaque[name] = true
+		}
+	}
+
+	for _, header := range deferredLinks {
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func forEachLayerEntry(layerPath string, visit func(header *tar.Header, content io.Reader) error) error {
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	var stream io.Reader = buffered
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		stream = decompressed
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return errors.New("zstd-compressed layers are not supported; re-push the image with gzip layers")
+	}
+
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader:
+			continue
+		}
+		if err := visit(header, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func hiddenByUpperLayer(name string, emitted map[string]bool, removed map[string]bool, opaque map[string]bool) bool {
+	if removed[name] {
+		return true
+	}
+	for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+		if removed[parent] || opaque[parent] {
+			return true
+		}
+		if isDir, seen := emitted[parent]; seen && !isDir {
+			return true
+		}
+	}
+	return false
+}
+
+func cleanLayerPath(name string) (string, bool) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleaned == "" {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func renderOCIEnvProfile(env []string) string {
+	sorted := append([]string(nil), env...)
+	sort.Strings(sorted)
+	var builder strings.Builder
+	builder.WriteString("# generated by clawfarm image import-oci\n")
+	for _, entry := range sorted {
+		key, value, found := strings.Cut(entry, "=")
+		if !found || !regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`).MatchString(key) {
+			continue
+		}
+		fmt.Fprintf(&builder, "export %s='%s'\n", key, strings.ReplaceAll(value, "'", `'"'"'`))
+	}
+	return builder.String()
+}
+
+func shortDigest(digest string) string {
+	trimmed := strings.TrimPrefix(digest, "sha256:")
+	if len(trimmed) > 12 {
+		trimmed = trimmed[:12]
+	}
+	return trimmed
+}
@@ -0,0 +1,249 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type testLayerEntry struct {
+	name     string
+	body     string
+	typeflag byte
+	linkname string
+}
+
+func buildTestLayer(t *testing.T, compress bool, entries ...testLayerEntry) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: entry.typeflag, Linkname: entry.linkname}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.body))
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := writer.Write([]byte(entry.body)); err != nil {
+				t.Fatalf("write body: %v", err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	if !compress {
+		return archive.Bytes()
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(archive.Bytes()); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	return compressed.Bytes()
+}
+
+func readTestRootfs(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open rootfs: %v", err)
+	}
+	defer file.Close()
+	entries := map[string]string{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("read rootfs: %v", err)
+		}
+		body, _ := io.ReadAll(reader)
+		value := string(body)
+		if header.Typeflag == tar.TypeLink {
+			value = "link:" + header.Linkname
+		}
+		if header.Typeflag == tar.TypeDir {
+			value = "dir"
+		}
+		entries[header.Name] = value
+	}
+}
+
+func TestFlattenOCILayersAppliesWhiteouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	lower := filepath.Join(tmpDir, "lower")
+	upper := filepath.Join(tmpDir, "upper")
+	if err := os.WriteFile(lower, buildTestLayer(t, true,
+		testLayerEntry{name: "etc/", typeflag: tar.TypeDir},
+		testLayerEntry{name: "etc/os-release", body: "lower"},
+		testLayerEntry{name: "usr/bin/tool", body: "tool"},
+		testLayerEntry{name: "opt/old/a", body: "a"},
+		testLayerEntry{name: "opt/keep", body: "keep"},
+		testLayerEntry{name: "../escape", body: "x"},
+	), 0o644); err != nil {
+		t.Fatalf("write lower: %v", err)
+	}
+	if err := os.WriteFile(upper, buildTestLayer(t, false,
+		testLayerEntry{name: "./etc/os-release", body: "upper"},
+		testLayerEntry{name: "usr/bin/.wh.tool"},
+		testLayerEntry{name: "opt/old/.wh..wh..opq"},
+		testLayerEntry{name: "opt/old/b", body: "b"},
+		testLayerEntry{name: "usr/bin/release", typeflag: tar.TypeLink, linkname: "etc/os-release"},
+	), 0o644); err != nil {
+		t.Fatalf("write upper: %v", err)
+	}
+
+	destination := filepath.Join(tmpDir, "rootfs.tar")
+	if err := flattenOCILayers([]string{lower, upper}, []string{"PATH=/usr/local/bin:/usr/bin", "GREETING=it's here"}, destination); err != nil {
+		t.Fatalf("flattenOCILayers failed: %v", err)
+	}
+	entries := readTestRootfs(t, destination)
+	expected := map[string]string{
+		"./etc":             "dir",
+		"./etc/os-release":  "upper",
+		"./opt/old/b":       "b",
+		"./opt/keep":        "keep",
+		"./escape":          "x",
+		"./usr/bin/release": "link:./etc/os-release",
+	}
+	for name, value := range expected {
+		if entries[name] != value {
+			t.Fatalf("expected %s=%q, got %q (entries: %v)", name, value, entries[name], entries)
+		}
+	}
+	for _, hidden := range []string{"./usr/bin/tool", "./opt/old/a", "./usr/bin/.wh.tool", "./opt/old/.wh..wh..opq"} {
+		if _, ok := entries[hidden]; ok {
+			t.Fatalf("expected %s to be hidden, got entries: %v", hidden, entries)
+		}
+	}
+	profile := entries["./"+ociEnvProfilePath]
+	if !strings.Contains(profile, "export PATH='/usr/local/bin:/usr/bin'") || !strings.Contains(profile, `export GREETING='it'"'"'s here'`) {
+		t.Fatalf("unexpected env profile: %q", profile)
+	}
+}
+
+func TestImportOCIPullsFromRegistryOntoHelperBase(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("unsupported architecture in test environment")
+	}
+
+	digestOf := func(payload []byte) string {
+		sum := sha256.Sum256(payload)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	layer := buildTestLayer(t, true, testLayerEntry{name: "usr/local/bin/agent", body: "#!/bin/sh\necho agent\n"})
+	config := []byte(`{"config":{"Env":["AGENT_HOME=/opt/agent"]}}`)
+	manifest, _ := json.Marshal(ociManifest{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Config:    ociDescriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: digestOf(config), Size: int64(len(config))},
+		Layers:    []ociDescriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer), Size: int64(len(layer))}},
+	})
+	index, _ := json.Marshal(ociManifest{
+		MediaType: "application/vnd.oci.image.index.v1+json",
+		Manifests: []ociDescriptor{
+			{Digest: digestOf([]byte("other")), Platform: &ociPlatform{OS: "linux", Architecture: "s390x"}},
+			{Digest: digestOf(manifest), Platform: &ociPlatform{OS: "linux", Architecture: runtime.GOARCH}},
+		},
+	})
+	blobs := map[string][]byte{
+		"/v2/tools/agent/manifests/1.0":                   index,
+		"/v2/tools/agent/manifests/" + digestOf(manifest): manifest,
+		"/v2/tools/agent/blobs/" + digestOf(config):       config,
+		"/v2/tools/agent/blobs/" + digestOf(layer):        layer,
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:tools/agent:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"anonymous-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer anonymous-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test-registry",scope="repository:tools/agent:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		payload, ok := blobs[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(payload)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	baseDir := filepath.Join(tmpDir, "images", "ubuntu_24.04")
+	if err := os.MkdirAll(baseDir, 0o755); err != nil {
+		t.Fatalf("mkdir base: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, imageFileName), []byte("QFI\xfbbase"), 0o644); err != nil {
+		t.Fatalf("write base disk: %v", err)
+	}
+	if err := writeMetadata(filepath.Join(baseDir, metadataFileName), Metadata{Ref: "ubuntu:24.04", Arch: runtime.GOARCH, DiskFormat: "qcow2"}); err != nil {
+		t.Fatalf("write base metadata: %v", err)
+	}
+
+	var output bytes.Buffer
+	manager := NewManager(tmpDir, &output)
+	host := strings.TrimPrefix(server.URL, "http://")
+	meta, err := manager.ImportOCI(context.Background(), OCIImportOptions{Source: "docker://" + host + "/tools/agent:1.0"})
+	if err != nil {
+		t.Fatalf("ImportOCI failed: %v\n%s", err, output.String())
+	}
+	if meta.Ref != "oci-agent:1.0" || meta.Base != "ubuntu:24.04" || meta.DiskFormat != "qcow2" {
+		t.Fatalf("unexpected metadata: %+v", meta)
+	}
+	if meta.Source != "docker://"+host+"/tools/agent@"+digestOf(manifest) {
+		t.Fatalf("expected source pinned to manifest digest, got %s", meta.Source)
+	}
+	if !strings.Contains(output.String(), "layer 1/1 "+shortDigest(digestOf(layer))) {
+		t.Fatalf("expected layer progress, got %q", output.String())
+	}
+
+	resolved, err := manager.Resolve("oci-agent:1.0")
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	disk, _ := os.ReadFile(resolved.RuntimeDisk)
+	if string(disk) != "QFI\xfbbase" {
+		t.Fatalf("expected helper base disk copy, got %q", disk)
+	}
+	entries := readTestRootfs(t, resolved.RootfsTar)
+	if entries["./usr/local/bin/agent"] != "#!/bin/sh\necho agent\n" || !strings.Contains(entries["./"+ociEnvProfilePath], "AGENT_HOME='/opt/agent'") {
+		t.Fatalf("unexpected rootfs entries: %v", entries)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(resolved.ImageDir, ociRootfsDirName, ".layers-*")); len(matches) != 0 {
+		t.Fatalf("expected layer downloads to be cleaned up, found %v", matches)
+	}
+}
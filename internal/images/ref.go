@@ -68,6 +68,40 @@ func (r UbuntuRef) BaseImageURL() string {
 	return fmt.Sprintf("https://cloud-images.ubuntu.com/%s/%s/%s-server-cloudimg-%s.img", r.Codename, r.Date, r.Codename, r.Arch)
 }
 
+type LocalRef struct {
+	Original string
+	Name     string
+	Tag      string
+}
+
+var localRefPattern = regexp.MustCompile(`^([a-z0-9][a-z0-9._-]*):([A-Za-z0-9][A-Za-z0-9._-]*)$`)
+
+func IsLocalRef(ref string) bool {
+	return !strings.HasPrefix(ref, "ubuntu:")
+}
+
+func ParseLocalRef(ref string) (LocalRef, error) {
+	matches := localRefPattern.FindStringSubmatch(strings.TrimSpace(ref))
+	if matches == nil {
+		return LocalRef{}, fmt.Errorf("invalid image ref %q: expected name:tag", ref)
+	}
+	if matches[1] == "ubuntu" {
+		return LocalRef{}, fmt.Errorf("image name %q is reserved", matches[1])
+	}
+	return LocalRef{Original: matches[0], Name: matches[1], Tag: matches[2]}, nil
+}
+
+// ImageDirName joins name and tag with a single "_" after doubling any "_"
+// inside them. Neither part may start with "_", so the separator is always
+// the last "_" of the first odd-length run and a:b_c never shares a
+// directory with a_b:c. Refs without underscores keep their old names.
+func (r LocalRef) ImageDirName() string {
+	escape := func(part string) string {
+		return strings.ReplaceAll(part, "_", "__")
+	}
+	return "local_" + escape(r.Name) + "_" + escape(r.Tag)
+}
+
 func normalizeUbuntuChannel(channel string) (string, string, error) {
 	channel = strings.TrimSpace(channel)
 	switch channel {
@@ -89,3 +123,112 @@ func hostArch() (string, error) {
 		return "", fmt.Errorf("unsupported host architecture %q", runtime.GOARCH)
 	}
 }
+
+type OCIRef struct {
+	Original   string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+const (
+	dockerHubRegistry    = "docker.io"
+	dockerHubRegistryURL = "https://registry-1.docker.io"
+)
+
+var ociRepositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+var ociTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+
+var ociDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+func ParseOCIRef(ref string) (OCIRef, error) {
+	trimmed := strings.TrimSpace(ref)
+	if !strings.HasPrefix(trimmed, "docker://") {
+		return OCIRef{}, fmt.Errorf("unsupported OCI source %q: expected docker://<image>[:tag|@digest]", ref)
+	}
+	name := strings.TrimPrefix(trimmed, "docker://")
+	parsed := OCIRef{Original: trimmed}
+
+	if before, digest, found := strings.Cut(name, "@"); found {
+		if !ociDigestPattern.MatchString(digest) {
+			return OCIRef{}, fmt.Errorf("invalid digest %q in %s: expected sha256:<64 hex>", digest, ref)
+		}
+		parsed.Digest = digest
+		name = before
+	}
+	if slash, colon := strings.LastIndex(name, "/"), strings.LastIndex(name, ":"); colon > slash {
+		parsed.Tag = name[colon+1:]
+		name = name[:colon]
+		if !ociTagPattern.MatchString(parsed.Tag) {
+			return OCIRef{}, fmt.Errorf("invalid tag %q in %s", parsed.Tag, ref)
+		}
+	}
+	if parsed.Tag == "" && parsed.Digest == "" {
+		parsed.Tag = "latest"
+	}
+
+	parsed.Registry = dockerHubRegistry
+	if first, rest, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		parsed.Registry = first
+		name = rest
+	}
+	if parsed.Registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !ociRepositoryPattern.MatchString(name) {
+		return OCIRef{}, fmt.Errorf("invalid repository %q in %s", name, ref)
+	}
+	parsed.Repository = name
+	return parsed, nil
+}
+
+// ParseOCIBlobRef parses oci://<registry>/<repository>@sha256:<digest>. Blobs
+// are content addressed, so the digest is required.
+func ParseOCIBlobRef(ref string) (OCIRef, error) {
+	trimmed := strings.TrimSpace(ref)
+	if !strings.HasPrefix(trimmed, "oci://") {
+		return OCIRef{}, fmt.Errorf("unsupported OCI blob %q: expected oci://<registry>/<repository>@sha256:<digest>", ref)
+	}
+	parsed, err := ParseOCIRef("docker://" + strings.TrimPrefix(trimmed, "oci://"))
+	if err != nil {
+		return OCIRef{}, err
+	}
+	if parsed.Digest == "" || parsed.Tag != "" {
+		return OCIRef{}, fmt.Errorf("OCI blob %q must be pinned by digest: expected oci://<registry>/<repository>@sha256:<digest>", ref)
+	}
+	parsed.Original = trimmed
+	return parsed, nil
+}
+
+func (r OCIRef) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r OCIRef) RegistryURL() string {
+	if r.Registry == dockerHubRegistry {
+		return dockerHubRegistryURL
+	}
+	host := r.Registry
+	if index := strings.LastIndex(host, ":"); index >= 0 {
+		host = host[:index]
+	}
+	if host == "localhost" || host == "127.0.0.1" {
+		return "http://" + r.Registry
+	}
+	return "https://" + r.Registry
+}
+
+func (r OCIRef) DefaultLocalRef() string {
+	name := r.Repository[strings.LastIndex(r.Repository, "/")+1:]
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9._-]+`).ReplaceAllString(name, "-"), "-._")
+	tag := r.Tag
+	if tag == "" {
+		tag = strings.TrimPrefix(r.Digest, "sha256:")[:12]
+	}
+	return "oci-" + name + ":" + tag
+}
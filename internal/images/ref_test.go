@@ -2,6 +2,7 @@ package images
 
 import (
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -51,3 +52,68 @@ func TestParseUbuntuRefErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLocalRef(t *testing.T) {
+	ref, err := ParseLocalRef("mydev:v1")
+	if err != nil {
+		t.Fatalf("ParseLocalRef failed: %v", err)
+	}
+	if ref.Name != "mydev" || ref.Tag != "v1" {
+		t.Fatalf("unexpected local ref: %#v", ref)
+	}
+	if ref.ImageDirName() != "local_mydev_v1" {
+		t.Fatalf("unexpected image dir name: %s", ref.ImageDirName())
+	}
+
+	dirs := map[string]string{}
+	for _, value := range []string{"a_b:c", "a:b_c", "a_:b", "a__b:c", "a:b__c", "a_b_c:d"} {
+		parsed, err := ParseLocalRef(value)
+		if err != nil {
+			t.Fatalf("ParseLocalRef(%q) failed: %v", value, err)
+		}
+		if previous, ok := dirs[parsed.ImageDirName()]; ok {
+			t.Fatalf("%q and %q share image dir %s", previous, value, parsed.ImageDirName())
+		}
+		dirs[parsed.ImageDirName()] = value
+	}
+
+	for _, invalid := range []string{"mydev", "MyDev:v1", "ubuntu:custom", "my/dev:v1", "mydev:"} {
+		if _, err := ParseLocalRef(invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestParseOCIRef(t *testing.T) {
+	cases := []struct {
+		input      string
+		registry   string
+		repository string
+		reference  string
+		url        string
+		local      string
+	}{
+		{"docker://ubuntu:24.04", "docker.io", "library/ubuntu", "24.04", "https://registry-1.docker.io", "oci-ubuntu:24.04"},
+		{"docker://ghcr.io/acme/tools", "ghcr.io", "acme/tools", "latest", "https://ghcr.io", "oci-tools:latest"},
+		{"docker://localhost:5000/agent:v1", "localhost:5000", "agent", "v1", "http://localhost:5000", "oci-agent:v1"},
+		{"docker://acme/agent@sha256:" + strings.Repeat("a", 64), "docker.io", "acme/agent", "sha256:" + strings.Repeat("a", 64), "https://registry-1.docker.io", "oci-agent:aaaaaaaaaaaa"},
+	}
+	for _, tc := range cases {
+		parsed, err := ParseOCIRef(tc.input)
+		if err != nil {
+			t.Fatalf("ParseOCIRef(%q) failed: %v", tc.input, err)
+		}
+		if parsed.Registry != tc.registry || parsed.Repository != tc.repository || parsed.Reference() != tc.reference {
+			t.Fatalf("ParseOCIRef(%q) = %+v", tc.input, parsed)
+		}
+		if parsed.RegistryURL() != tc.url || parsed.DefaultLocalRef() != tc.local {
+			t.Fatalf("unexpected url/local ref for %q: %s %s", tc.input, parsed.RegistryURL(), parsed.DefaultLocalRef())
+		}
+	}
+
+	for _, input := range []string{"ubuntu:24.04", "docker://Ubuntu", "docker://ubuntu@sha256:abc", "docker://ubuntu:bad tag"} {
+		if _, err := ParseOCIRef(input); err == nil {
+			t.Fatalf("expected ParseOCIRef(%q) to fail", input)
+		}
+	}
+}
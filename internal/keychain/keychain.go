@@ -0,0 +1,131 @@
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const Service = "clawfarm"
+
+type Keychain interface {
+	Name() string
+	Get(account string) (string, bool, error)
+	Set(account string, value string) error
+	Delete(account string) error
+}
+
+func System() Keychain {
+	switch runtime.GOOS {
+	case "darwin":
+		if path, err := exec.LookPath("security"); err == nil {
+			return macKeychain{securityPath: path}
+		}
+	case "linux":
+		if path, err := exec.LookPath("secret-tool"); err == nil {
+			return secretServiceKeychain{secretToolPath: path}
+		}
+	}
+	return nil
+}
+
+type macKeychain struct {
+	securityPath string
+}
+
+func (k macKeychain) Name() string {
+	return "macOS Keychain"
+}
+
+func (k macKeychain) Get(account string) (string, bool, error) {
+	stdout, stderr, err := run(k.securityPath, "", "find-generic-password", "-s", Service, "-a", account, "-w")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("security find-generic-password: %w: %s", err, stderr)
+	}
+	return strings.TrimRight(stdout, "\n"), true, nil
+}
+
+// Set feeds the add command to `security -i` on stdin so the secret never
+// shows up in the argument list other local users can read with ps.
+func (k macKeychain) Set(account string, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("macOS Keychain values must not contain newlines")
+	}
+	command := strings.Join([]string{"add-generic-password", "-U", "-s", securityQuote(Service), "-a", securityQuote(account), "-w", securityQuote(value)}, " ")
+	_, stderr, err := run(k.securityPath, command+"\n", "-i")
+	if err != nil {
+		return fmt.Errorf("security add-generic-password: %w: %s", err, stderr)
+	}
+	// Interactive mode exits 0 even when the command fails.
+	if stderr != "" {
+		return fmt.Errorf("security add-generic-password: %s", stderr)
+	}
+	return nil
+}
+
+// securityQuote quotes one argument for the command parser of `security -i`.
+func securityQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func (k macKeychain) Delete(account string) error {
+	if _, stderr, err := run(k.securityPath, "", "delete-generic-password", "-s", Service, "-a", account); err != nil {
+		return fmt.Errorf("security delete-generic-password: %w: %s", err, stderr)
+	}
+	return nil
+}
+
+type secretServiceKeychain struct {
+	secretToolPath string
+}
+
+func (k secretServiceKeychain) Name() string {
+	return "Secret Service"
+}
+
+func (k secretServiceKeychain) Get(account string) (string, bool, error) {
+	stdout, stderr, err := run(k.secretToolPath, "", "lookup", "service", Service, "account", account)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr == "" {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("secret-tool lookup: %w: %s", err, stderr)
+	}
+	return strings.TrimRight(stdout, "\n"), true, nil
+}
+
+func (k secretServiceKeychain) Set(account string, value string) error {
+	label := fmt.Sprintf("%s %s", Service, account)
+	if _, stderr, err := run(k.secretToolPath, value, "store", "--label", label, "service", Service, "account", account); err != nil {
+		return fmt.Errorf("secret-tool store: %w: %s", err, stderr)
+	}
+	return nil
+}
+
+func (k secretServiceKeychain) Delete(account string) error {
+	if _, stderr, err := run(k.secretToolPath, "", "clear", "service", Service, "account", account); err != nil {
+		return fmt.Errorf("secret-tool clear: %w: %s", err, stderr)
+	}
+	return nil
+}
+
+func run(path string, stdin string, args ...string) (string, string, error) {
+	command := exec.Command(path, args...)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if stdin != "" {
+		command.Stdin = strings.NewReader(stdin)
+	}
+	err := command.Run()
+	return stdout.String(), strings.TrimSpace(stderr.String()), err
+}
@@ -0,0 +1,43 @@
+package keychain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMacKeychainSetKeepsSecretOutOfArguments(t *testing.T) {
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	stdinPath := filepath.Join(dir, "stdin")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" >" + argsPath + "\ncat >" + stdinPath + "\n"
+	securityPath := filepath.Join(dir, "security")
+	if err := os.WriteFile(securityPath, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake security: %v", err)
+	}
+
+	secret := `s3cr"et\value`
+	if err := (macKeychain{securityPath: securityPath}).Set("openai", secret); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	args, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("read args: %v", err)
+	}
+	if strings.Contains(string(args), "s3cr") || strings.TrimSpace(string(args)) != "-i" {
+		t.Fatalf("expected only -i in the security arguments, got %q", args)
+	}
+	stdin, err := os.ReadFile(stdinPath)
+	if err != nil {
+		t.Fatalf("read stdin: %v", err)
+	}
+	if want := `add-generic-password -U -s "clawfarm" -a "openai" -w "s3cr\"et\\value"` + "\n"; string(stdin) != want {
+		t.Fatalf("unexpected security input %q, want %q", stdin, want)
+	}
+
+	if err := (macKeychain{securityPath: securityPath}).Set("openai", "line\nbreak"); err == nil {
+		t.Fatal("expected a multi-line secret to be rejected")
+	}
+}
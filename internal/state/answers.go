@@ -0,0 +1,66 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type AnswerStore struct {
+	root string
+}
+
+func NewAnswerStore(root string) *AnswerStore {
+	return &AnswerStore{root: root}
+}
+
+func (s *AnswerStore) Load(profile string) (map[string]string, error) {
+	path, err := s.profilePath(profile)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	answers := map[string]string{}
+	if strings.TrimSpace(string(payload)) == "" {
+		return answers, nil
+	}
+	if err := json.Unmarshal(payload, &answers); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+func (s *AnswerStore) Save(profile string, answers map[string]string) error {
+	path, err := s.profilePath(profile)
+	if err != nil {
+		return err
+	}
+	payload, err := json.MarshalIndent(answers, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.root, 0o755); err != nil {
+		return err
+	}
+	temporaryPath := path + ".tmp"
+	if err := os.WriteFile(temporaryPath, append(payload, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
+
+func (s *AnswerStore) profilePath(profile string) (string, error) {
+	trimmed := strings.TrimSpace(profile)
+	if trimmed == "" || strings.ContainsAny(trimmed, `/\`) || strings.Contains(trimmed, "..") {
+		return "", fmt.Errorf("invalid answers profile %q", profile)
+	}
+	return filepath.Join(s.root, trimmed+".json"), nil
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -37,11 +39,37 @@ type ReleaseRequest struct {
 }
 
 type LockState struct {
-	Active       bool      `json:"active"`
-	InstanceID   string    `json:"instance_id,omitempty"`
-	PID          int       `json:"pid,omitempty"`
-	SourcePath   string    `json:"source_path,omitempty"`
-	UpdatedAtUTC time.Time `json:"updated_at_utc"`
+	Active       bool        `json:"active"`
+	InstanceID   string      `json:"instance_id,omitempty"`
+	PID          int         `json:"pid,omitempty"`
+	SourcePath   string      `json:"source_path,omitempty"`
+	OwnerUID     int         `json:"owner_uid,omitempty"`
+	OwnerUser    string      `json:"owner_user,omitempty"`
+	Holder       *LockHolder `json:"holder,omitempty"`
+	UpdatedAtUTC time.Time   `json:"updated_at_utc"`
+}
+
+type LockHolder struct {
+	PID           int       `json:"pid"`
+	Command       string    `json:"command,omitempty"`
+	AcquiredAtUTC time.Time `json:"acquired_at_utc"`
+}
+
+type BusyError struct {
+	ClawID string
+	Holder LockHolder
+}
+
+func (e *BusyError) Error() string {
+	command := e.Holder.Command
+	if command == "" {
+		command = "unknown command"
+	}
+	return fmt.Sprintf("%v: %s is locked by pid %d (%s) since %s", ErrBusy, e.ClawID, e.Holder.PID, command, e.Holder.AcquiredAtUTC.Format(time.RFC3339))
+}
+
+func (e *BusyError) Unwrap() error {
+	return ErrBusy
 }
 
 type LockHandle interface {
@@ -53,9 +81,13 @@ type Locker interface {
 }
 
 type LockManager struct {
-	root   string
-	locker Locker
-	now    func() time.Time
+	root         string
+	locker       Locker
+	now          func() time.Time
+	currentUser  func() (int, string)
+	holder       func() LockHolder
+	processAlive func(pid int) bool
+	shared       bool
 }
 
 func NewLockManager(root string, locker Locker) *LockManager {
@@ -68,9 +100,19 @@ func NewLockManager(root string, locker Locker) *LockManager {
 		now: func() time.Time {
 			return time.Now().UTC()
 		},
+		currentUser:  CurrentUser,
+		holder:       currentLockHolder,
+		processAlive: processAlive,
 	}
 }
 
+// WithShared makes the lock and state files the manager writes
+// group-writable, for data dirs shared between users.
+func (m *LockManager) WithShared(shared bool) *LockManager {
+	m.shared = shared
+	return m
+}
+
 func (m *LockManager) Acquire(ctx context.Context, req AcquireRequest) error {
 	normalizedReq, err := normalizeAcquireRequest(req)
 	if err != nil {
@@ -132,6 +174,53 @@ func (m *LockManager) Inspect(clawID string) (LockState, error) {
 	return readState(m.statePath(clawID))
 }
 
+func (m *LockManager) ForceUnlock(clawID string, instanceRunning func(pid int) bool) (LockState, error) {
+	if err := validateClawID(clawID); err != nil {
+		return LockState{}, err
+	}
+	if err := m.ensurePaths(clawID); err != nil {
+		return LockState{}, err
+	}
+
+	statePath := m.statePath(clawID)
+	previous, err := readState(statePath)
+	if err != nil && !errors.Is(err, ErrInvalidState) {
+		return LockState{}, err
+	}
+
+	handle, ok, err := m.locker.TryLock(m.lockPath(clawID))
+	if err != nil {
+		return LockState{}, err
+	}
+	if ok {
+		defer handle.Unlock()
+	} else {
+		if previous.Holder == nil {
+			return previous, fmt.Errorf("%w: %s is locked by a process that left no holder record; refusing to break it", ErrBusy, clawID)
+		}
+		if m.processAlive(previous.Holder.PID) {
+			return previous, fmt.Errorf("%w; stop that process instead of forcing the lock", &BusyError{ClawID: clawID, Holder: *previous.Holder})
+		}
+		if err := os.Remove(m.lockPath(clawID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return previous, err
+		}
+	}
+	if previous.Active && previous.PID > 0 && instanceRunning != nil && instanceRunning(previous.PID) {
+		return previous, fmt.Errorf("%s is still running as pid %d; stop it with `clawfarm rm` instead", clawID, previous.PID)
+	}
+
+	cleared := previous
+	cleared.Active = false
+	cleared.PID = 0
+	cleared.InstanceID = ""
+	cleared.Holder = nil
+	cleared.UpdatedAtUTC = m.now()
+	if err := m.writeState(statePath, cleared); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
 func (m *LockManager) acquireLocked(ctx context.Context, req AcquireRequest) error {
 	statePath := m.statePath(req.ClawID)
 
@@ -150,8 +239,9 @@ func (m *LockManager) acquireLocked(ctx context.Context, req AcquireRequest) err
 	state.Active = true
 	state.InstanceID = req.InstanceID
 	state.PID = req.PID
+	state.OwnerUID, state.OwnerUser = m.currentUser()
 	state.UpdatedAtUTC = m.now()
-	return writeState(statePath, state)
+	return m.writeState(statePath, state)
 }
 
 func (m *LockManager) releaseLocked(ctx context.Context, req ReleaseRequest) error {
@@ -166,7 +256,7 @@ func (m *LockManager) releaseLocked(ctx context.Context, req ReleaseRequest) err
 	state.PID = 0
 	state.InstanceID = ""
 	state.UpdatedAtUTC = m.now()
-	return writeState(statePath, state)
+	return m.writeState(statePath, state)
 }
 
 func normalizeAcquireRequest(req AcquireRequest) (AcquireRequest, error) {
@@ -195,6 +285,9 @@ func (m *LockManager) ensurePaths(clawID string) error {
 	if err := os.MkdirAll(clawDir, 0o755); err != nil {
 		return err
 	}
+	if m.shared {
+		return MakeShared(clawDir)
+	}
 	return nil
 }
 
@@ -208,12 +301,24 @@ func (m *LockManager) withLock(clawID string, fn func() error) error {
 		return err
 	}
 	if !ok {
+		if current, readErr := readState(m.statePath(clawID)); readErr == nil && current.Holder != nil {
+			return &BusyError{ClawID: clawID, Holder: *current.Holder}
+		}
 		return ErrBusy
 	}
+	if m.shared {
+		if err := MakeShared(m.lockPath(clawID)); err != nil {
+			_ = handle.Unlock()
+			return err
+		}
+	}
 
-	if err := fn(); err != nil {
+	m.recordHolder(clawID, true)
+	fnErr := fn()
+	m.recordHolder(clawID, false)
+	if fnErr != nil {
 		_ = handle.Unlock()
-		return err
+		return fnErr
 	}
 	if err := handle.Unlock(); err != nil {
 		return err
@@ -221,6 +326,44 @@ func (m *LockManager) withLock(clawID string, fn func() error) error {
 	return nil
 }
 
+func (m *LockManager) recordHolder(clawID string, held bool) {
+	statePath := m.statePath(clawID)
+	current, err := readState(statePath)
+	if err != nil {
+		return
+	}
+	if !held {
+		if current.Holder == nil {
+			return
+		}
+		current.Holder = nil
+	} else {
+		holder := m.holder()
+		holder.AcquiredAtUTC = m.now()
+		current.Holder = &holder
+	}
+	_ = m.writeState(statePath, current)
+}
+
+func currentLockHolder() LockHolder {
+	command := "clawfarm"
+	if len(os.Args) > 0 {
+		command = filepath.Base(os.Args[0])
+	}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command += " " + os.Args[1]
+	}
+	return LockHolder{PID: os.Getpid(), Command: command}
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
 func (m *LockManager) clawDir(clawID string) string {
 	return filepath.Join(m.root, clawID)
 }
@@ -258,6 +401,16 @@ func readState(path string) (LockState, error) {
 	return state, nil
 }
 
+func (m *LockManager) writeState(path string, state LockState) error {
+	if err := writeState(path, state); err != nil {
+		return err
+	}
+	if m.shared {
+		return MakeShared(path)
+	}
+	return nil
+}
+
 func writeState(path string, state LockState) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -13,6 +14,7 @@ func TestAcquireAndReleaseUpdateState(t *testing.T) {
 	locker := &fakeLocker{ok: true}
 	manager := NewLockManager(root, locker)
 	manager.now = func() time.Time { return time.Date(2026, time.February, 10, 0, 0, 0, 0, time.UTC) }
+	manager.currentUser = func() (int, string) { return 501, "alice" }
 
 	if err := manager.Acquire(context.Background(), AcquireRequest{
 		ClawID:     "demo-123",
@@ -36,6 +38,9 @@ func TestAcquireAndReleaseUpdateState(t *testing.T) {
 	if state.PID != 4321 {
 		t.Fatalf("unexpected pid: %d", state.PID)
 	}
+	if state.OwnerUID != 501 || state.OwnerUser != "alice" {
+		t.Fatalf("unexpected lock owner: %d %q", state.OwnerUID, state.OwnerUser)
+	}
 
 	if err := manager.Release(context.Background(), ReleaseRequest{ClawID: "demo-123"}); err != nil {
 		t.Fatalf("Release failed: %v", err)
@@ -148,6 +153,83 @@ func TestWithInstanceLockFailsWhenBusy(t *testing.T) {
 	}
 }
 
+func TestWithInstanceLockRecordsHolderAndBusyErrorNamesIt(t *testing.T) {
+	root := t.TempDir()
+	acquiredAt := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	manager := NewLockManager(root, &fakeLocker{ok: true})
+	manager.now = func() time.Time { return acquiredAt }
+	manager.holder = func() LockHolder { return LockHolder{PID: 777, Command: "clawfarm run"} }
+
+	err := manager.WithInstanceLock("demo-123", func() error {
+		state, err := manager.Inspect("demo-123")
+		if err != nil {
+			return err
+		}
+		if state.Holder == nil || state.Holder.PID != 777 || state.Holder.Command != "clawfarm run" || !state.Holder.AcquiredAtUTC.Equal(acquiredAt) {
+			t.Fatalf("expected holder to be recorded while locked, got %+v", state.Holder)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithInstanceLock failed: %v", err)
+	}
+	state, err := manager.Inspect("demo-123")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if state.Holder != nil {
+		t.Fatalf("expected holder to be cleared after unlock, got %+v", state.Holder)
+	}
+
+	if err := writeState(filepath.Join(root, "demo-123", stateFileName), LockState{Holder: &LockHolder{PID: 777, Command: "clawfarm run", AcquiredAtUTC: acquiredAt}}); err != nil {
+		t.Fatalf("seed state: %v", err)
+	}
+	busy := NewLockManager(root, &fakeLocker{ok: false})
+	err = busy.WithInstanceLock("demo-123", func() error { return nil })
+	var busyErr *BusyError
+	if !errors.Is(err, ErrBusy) || !errors.As(err, &busyErr) || busyErr.Holder.PID != 777 {
+		t.Fatalf("expected BusyError naming pid 777, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "clawfarm run") {
+		t.Fatalf("expected busy error to name the holder command, got %v", err)
+	}
+}
+
+func TestForceUnlockChecksHolderAndInstance(t *testing.T) {
+	root := t.TempDir()
+	statePath := filepath.Join(root, "demo-123", stateFileName)
+	stale := LockState{Active: true, PID: 4321, Holder: &LockHolder{PID: 999, Command: "clawfarm run"}}
+	if err := writeState(statePath, stale); err != nil {
+		t.Fatalf("seed state: %v", err)
+	}
+
+	manager := NewLockManager(root, &fakeLocker{ok: false})
+	manager.processAlive = func(int) bool { return true }
+	if _, err := manager.ForceUnlock("demo-123", nil); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected live holder to block force unlock, got %v", err)
+	}
+
+	manager.processAlive = func(int) bool { return false }
+	if _, err := manager.ForceUnlock("demo-123", func(pid int) bool { return pid == 4321 }); err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("expected running instance to block force unlock, got %v", err)
+	}
+
+	previous, err := manager.ForceUnlock("demo-123", func(int) bool { return false })
+	if err != nil {
+		t.Fatalf("ForceUnlock failed: %v", err)
+	}
+	if previous.Holder == nil || previous.Holder.PID != 999 {
+		t.Fatalf("expected previous holder to be returned, got %+v", previous)
+	}
+	state, err := manager.Inspect("demo-123")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if state.Active || state.PID != 0 || state.Holder != nil {
+		t.Fatalf("expected cleared lock state, got %+v", state)
+	}
+}
+
 func TestFlockLockerContention(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "instance.flock")
 	locker := NewFlockLocker()
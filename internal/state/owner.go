@@ -0,0 +1,164 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const ownerFileName = "owner.json"
+
+const (
+	sharedDirMode  = 0o775 | os.ModeSetgid
+	sharedFileMode = 0o664
+)
+
+var ErrForeignDataDir = errors.New("data dir is owned by another user")
+
+type DataDirOwner struct {
+	UID          int       `json:"uid"`
+	User         string    `json:"user,omitempty"`
+	Shared       bool      `json:"shared"`
+	CreatedAtUTC time.Time `json:"created_at_utc"`
+}
+
+func CurrentUser() (int, string) {
+	uid := os.Getuid()
+	name := strconv.Itoa(uid)
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		name = current.Username
+	}
+	return uid, name
+}
+
+func ClaimDataDir(root string, shared bool) (DataDirOwner, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return DataDirOwner{}, err
+	}
+	if shared {
+		if err := MakeShared(root); err != nil {
+			return DataDirOwner{}, err
+		}
+	}
+
+	uid, name := CurrentUser()
+	path := filepath.Join(root, ownerFileName)
+	owner := DataDirOwner{UID: uid, User: name, Shared: shared, CreatedAtUTC: time.Now().UTC()}
+	created, err := createDataDirOwner(path, owner)
+	if err != nil {
+		return DataDirOwner{}, err
+	}
+	if created {
+		return owner, nil
+	}
+	owner, err = readDataDirOwner(path)
+	if err != nil {
+		return DataDirOwner{}, err
+	}
+
+	if owner.UID == uid {
+		if owner.Shared != shared {
+			owner.Shared = shared
+			if err := writeDataDirOwner(path, owner); err != nil {
+				return DataDirOwner{}, err
+			}
+		}
+		return owner, nil
+	}
+	if owner.Shared && shared {
+		return owner, nil
+	}
+	if owner.Shared {
+		return DataDirOwner{}, fmt.Errorf("%w: %s is a shared data dir owned by %s (uid %d); enable shared mode to use it", ErrForeignDataDir, root, owner.User, owner.UID)
+	}
+	return DataDirOwner{}, fmt.Errorf("%w: %s belongs to %s (uid %d); use a per-user data dir or ask the owner to enable shared mode", ErrForeignDataDir, root, owner.User, owner.UID)
+}
+
+// createDataDirOwner writes the owner file only if none exists yet, so two
+// users claiming a fresh data dir at once cannot both win. It reports false
+// when someone else got there first.
+func createDataDirOwner(path string, owner DataDirOwner) (bool, error) {
+	payload, err := json.MarshalIndent(owner, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	payload = append(payload, '\n')
+
+	mode := dataDirOwnerMode(owner)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, writeErr := file.Write(payload)
+	closeErr := file.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		_ = os.Remove(path)
+		return false, err
+	}
+	return true, os.Chmod(path, mode)
+}
+
+func readDataDirOwner(path string) (DataDirOwner, error) {
+	data, err := os.ReadFile(path)
+	// A claimer that just created the file may not have written it yet.
+	for attempt := 0; err == nil && len(data) == 0 && attempt < 100; attempt++ {
+		time.Sleep(10 * time.Millisecond)
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return DataDirOwner{}, err
+	}
+	var owner DataDirOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return DataDirOwner{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return owner, nil
+}
+
+func writeDataDirOwner(path string, owner DataDirOwner) error {
+	payload, err := json.MarshalIndent(owner, "", "  ")
+	if err != nil {
+		return err
+	}
+	payload = append(payload, '\n')
+
+	mode := dataDirOwnerMode(owner)
+	if err := os.WriteFile(path, payload, mode); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+func dataDirOwnerMode(owner DataDirOwner) os.FileMode {
+	if owner.Shared {
+		return sharedFileMode
+	}
+	return 0o644
+}
+
+// MakeShared opens path to the group of a shared data dir: files become
+// group-writable and directories also get setgid so new entries keep the
+// group. Paths another user already shared are left alone, since only their
+// owner may chmod them.
+func MakeShared(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(sharedFileMode)
+	if info.IsDir() {
+		mode = sharedDirMode
+	}
+	if info.Mode()&mode == mode {
+		return nil
+	}
+	return os.Chmod(path, info.Mode().Perm()|mode)
+}
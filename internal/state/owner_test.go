@@ -0,0 +1,160 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimDataDirRecordsCurrentUser(t *testing.T) {
+	root := t.TempDir()
+
+	owner, err := ClaimDataDir(root, false)
+	if err != nil {
+		t.Fatalf("ClaimDataDir failed: %v", err)
+	}
+	if owner.UID != os.Getuid() {
+		t.Fatalf("unexpected owner uid: got %d want %d", owner.UID, os.Getuid())
+	}
+	if owner.Shared {
+		t.Fatal("expected private data dir")
+	}
+
+	reloaded, err := readDataDirOwner(filepath.Join(root, ownerFileName))
+	if err != nil {
+		t.Fatalf("read owner file: %v", err)
+	}
+	if reloaded.UID != owner.UID {
+		t.Fatalf("unexpected persisted owner: %+v", reloaded)
+	}
+}
+
+func TestClaimDataDirRejectsForeignPrivateDir(t *testing.T) {
+	root := t.TempDir()
+	foreign := DataDirOwner{UID: os.Getuid() + 1, User: "someone-else", CreatedAtUTC: time.Now().UTC()}
+	if err := writeDataDirOwner(filepath.Join(root, ownerFileName), foreign); err != nil {
+		t.Fatalf("write owner file: %v", err)
+	}
+
+	_, err := ClaimDataDir(root, false)
+	if !errors.Is(err, ErrForeignDataDir) {
+		t.Fatalf("expected ErrForeignDataDir, got %v", err)
+	}
+	_, err = ClaimDataDir(root, true)
+	if !errors.Is(err, ErrForeignDataDir) {
+		t.Fatalf("expected ErrForeignDataDir for non-shared dir in shared mode, got %v", err)
+	}
+}
+
+func TestClaimDataDirAllowsForeignSharedDirInSharedMode(t *testing.T) {
+	root := t.TempDir()
+	foreign := DataDirOwner{UID: os.Getuid() + 1, User: "someone-else", Shared: true, CreatedAtUTC: time.Now().UTC()}
+	if err := writeDataDirOwner(filepath.Join(root, ownerFileName), foreign); err != nil {
+		t.Fatalf("write owner file: %v", err)
+	}
+
+	if _, err := ClaimDataDir(root, false); !errors.Is(err, ErrForeignDataDir) {
+		t.Fatalf("expected ErrForeignDataDir without shared mode, got %v", err)
+	}
+	owner, err := ClaimDataDir(root, true)
+	if err != nil {
+		t.Fatalf("ClaimDataDir in shared mode failed: %v", err)
+	}
+	if owner.UID != foreign.UID {
+		t.Fatalf("shared claim should keep original owner, got %+v", owner)
+	}
+}
+
+func TestSharedStoreAndLocksAreGroupWritable(t *testing.T) {
+	root := t.TempDir()
+	if _, err := ClaimDataDir(root, true); err != nil {
+		t.Fatalf("ClaimDataDir failed: %v", err)
+	}
+	if err := NewStore(root).WithShared(true).Save(Instance{ID: "claw-shared"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := NewLockManager(root, nil).WithShared(true).Acquire(context.Background(), AcquireRequest{ClawID: "claw-shared", InstanceID: "claw-shared"}); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	for _, path := range []string{root, filepath.Join(root, "claw-shared")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		if info.Mode()&sharedDirMode != sharedDirMode {
+			t.Fatalf("expected %s to be a group-writable setgid dir, got %v", path, info.Mode())
+		}
+	}
+	for _, name := range []string{ownerFileName, filepath.Join("claw-shared", metadataFileName), filepath.Join("claw-shared", lockFileName), filepath.Join("claw-shared", stateFileName)} {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if info.Mode().Perm()&sharedFileMode != sharedFileMode {
+			t.Fatalf("expected %s to be group-writable, got %v", name, info.Mode())
+		}
+	}
+}
+
+func TestClaimDataDirConcurrentClaimsAgreeOnOneOwner(t *testing.T) {
+	root := t.TempDir()
+	owners := make([]DataDirOwner, 8)
+	errs := make([]error, len(owners))
+	var wg sync.WaitGroup
+	for index := range owners {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			owners[index], errs[index] = ClaimDataDir(root, false)
+		}(index)
+	}
+	wg.Wait()
+
+	persisted, err := readDataDirOwner(filepath.Join(root, ownerFileName))
+	if err != nil {
+		t.Fatalf("read owner file: %v", err)
+	}
+	for index, owner := range owners {
+		if errs[index] != nil {
+			t.Fatalf("claim %d failed: %v", index, errs[index])
+		}
+		if !owner.CreatedAtUTC.Equal(persisted.CreatedAtUTC) {
+			t.Fatalf("claim %d saw owner created at %v, persisted %v", index, owner.CreatedAtUTC, persisted.CreatedAtUTC)
+		}
+	}
+}
+
+func TestSharedStoreOpensInstanceFilesToTheGroup(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(root).WithShared(true)
+	for _, name := range []string{"instance.img", "serial.log", filepath.Join("checkpoints", "before.qcow2")} {
+		path := filepath.Join(root, "claw-shared", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := store.Save(Instance{ID: "claw-shared"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, name := range []string{"instance.img", "serial.log", filepath.Join("checkpoints", "before.qcow2")} {
+		info, err := os.Stat(filepath.Join(root, "claw-shared", name))
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if info.Mode().Perm()&sharedFileMode != sharedFileMode {
+			t.Fatalf("expected %s to be group-writable, got %v", name, info.Mode())
+		}
+	}
+	info, err := os.Stat(filepath.Join(root, "claw-shared", "checkpoints"))
+	if err != nil || info.Mode()&sharedDirMode != sharedDirMode {
+		t.Fatalf("expected checkpoints dir to be a group-writable setgid dir, got %v (%v)", info, err)
+	}
+}
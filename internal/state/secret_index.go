@@ -0,0 +1,81 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type SecretIndex struct {
+	path string
+}
+
+func NewSecretIndex(path string) *SecretIndex {
+	return &SecretIndex{path: path}
+}
+
+func (s *SecretIndex) List() ([]string, error) {
+	payload, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(payload)) == "" {
+		return nil, nil
+	}
+	var names []string
+	if err := json.Unmarshal(payload, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (s *SecretIndex) Add(name string) error {
+	names, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, existing := range names {
+		if existing == name {
+			return nil
+		}
+	}
+	return s.write(append(names, name))
+}
+
+func (s *SecretIndex) Remove(name string) (bool, error) {
+	names, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	kept := make([]string, 0, len(names))
+	for _, existing := range names {
+		if existing != name {
+			kept = append(kept, existing)
+		}
+	}
+	if len(kept) == len(names) {
+		return false, nil
+	}
+	return true, s.write(kept)
+}
+
+func (s *SecretIndex) write(names []string) error {
+	sort.Strings(names)
+	payload, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	temporaryPath := s.path + ".tmp"
+	if err := os.WriteFile(temporaryPath, append(payload, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, s.path)
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const statsLockAttempts = 25
+
+type HostStats struct {
+	Runs            int       `json:"runs"`
+	RunFailures     int       `json:"run_failures"`
+	Boots           int       `json:"boots"`
+	BootTotalMillis int64     `json:"boot_total_ms"`
+	BootMinMillis   int64     `json:"boot_min_ms"`
+	BootMaxMillis   int64     `json:"boot_max_ms"`
+	LastBootMillis  int64     `json:"last_boot_ms"`
+	Crashes         int       `json:"crashes"`
+	SinceUTC        time.Time `json:"since_utc"`
+	UpdatedAtUTC    time.Time `json:"updated_at_utc"`
+}
+
+func (stats *HostStats) RecordBoot(duration time.Duration) {
+	millis := duration.Milliseconds()
+	stats.Boots++
+	stats.BootTotalMillis += millis
+	stats.LastBootMillis = millis
+	if stats.BootMinMillis == 0 || millis < stats.BootMinMillis {
+		stats.BootMinMillis = millis
+	}
+	if millis > stats.BootMaxMillis {
+		stats.BootMaxMillis = millis
+	}
+}
+
+type StatsStore struct {
+	path   string
+	locker Locker
+}
+
+func NewStatsStore(path string) *StatsStore {
+	return &StatsStore{path: path, locker: NewFlockLocker()}
+}
+
+func (s *StatsStore) Load() (HostStats, error) {
+	payload, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return HostStats{}, nil
+		}
+		return HostStats{}, err
+	}
+	var stats HostStats
+	if strings.TrimSpace(string(payload)) == "" {
+		return stats, nil
+	}
+	if err := json.Unmarshal(payload, &stats); err != nil {
+		return HostStats{}, err
+	}
+	return stats, nil
+}
+
+func (s *StatsStore) Update(fn func(stats *HostStats)) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	var handle LockHandle
+	for attempt := 0; attempt < statsLockAttempts; attempt++ {
+		current, ok, err := s.locker.TryLock(s.path + ".lock")
+		if err != nil {
+			return err
+		}
+		if ok {
+			handle = current
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if handle == nil {
+		return ErrBusy
+	}
+	defer handle.Unlock()
+
+	stats, err := s.Load()
+	if err != nil {
+		stats = HostStats{}
+	}
+	now := time.Now().UTC()
+	if stats.SinceUTC.IsZero() {
+		stats.SinceUTC = now
+	}
+	fn(&stats)
+	stats.UpdatedAtUTC = now
+	return s.write(stats)
+}
+
+func (s *StatsStore) Reset() error {
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *StatsStore) write(stats HostStats) error {
+	payload, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporaryPath := s.path + ".tmp"
+	if err := os.WriteFile(temporaryPath, append(payload, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, s.path)
+}
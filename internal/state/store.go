@@ -3,6 +3,8 @@ package state
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
@@ -11,48 +13,168 @@ import (
 
 const metadataFileName = "instance.json"
 
+// keptBlobsFileName records the blobs a forgotten instance's kept data dir
+// still depends on, so the blob cache does not evict them.
+const keptBlobsFileName = "kept-blobs.json"
+
 var ErrNotFound = errors.New("instance not found")
 
+const PrimaryGatewayName = "gateway"
+
 type PortMapping struct {
 	HostPort  int `json:"host_port"`
 	GuestPort int `json:"guest_port"`
 }
 
+type Gateway struct {
+	Name      string `json:"name"`
+	HostPort  int    `json:"host_port"`
+	GuestPort int    `json:"guest_port"`
+	Path      string `json:"path,omitempty"`
+	Status    string `json:"status,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+type Readiness struct {
+	Path   string            `json:"path,omitempty"`
+	Status int               `json:"status,omitempty"`
+	JSON   map[string]string `json:"json,omitempty"`
+}
+
+type HostEntry struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+type OpenClawPackage struct {
+	Source    string `json:"source"`
+	Spec      string `json:"spec"`
+	Version   string `json:"version,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
+}
+
+type VolumeMount struct {
+	Name      string `json:"name"`
+	HostPath  string `json:"host_path"`
+	GuestPath string `json:"guest_path"`
+}
+
 type Instance struct {
-	ID             string        `json:"id"`
-	ImageRef       string        `json:"image_ref"`
-	WorkspacePath  string        `json:"workspace_path"`
-	StatePath      string        `json:"state_path"`
-	GatewayPort    int           `json:"gateway_port"`
-	PublishedPorts []PortMapping `json:"published_ports"`
-	Status         string        `json:"status"`
-	Backend        string        `json:"backend"`
-	PID            int           `json:"pid,omitempty"`
-	DiskPath       string        `json:"disk_path,omitempty"`
-	SeedISOPath    string        `json:"seed_iso_path,omitempty"`
-	SerialLogPath  string        `json:"serial_log_path,omitempty"`
-	QEMULogPath    string        `json:"qemu_log_path,omitempty"`
-	MonitorPath    string        `json:"monitor_path,omitempty"`
-	QEMUAccel      string        `json:"qemu_accel,omitempty"`
-	LastError      string        `json:"last_error,omitempty"`
-	CreatedAtUTC   time.Time     `json:"created_at_utc"`
-	UpdatedAtUTC   time.Time     `json:"updated_at_utc"`
+	ID                    string           `json:"id"`
+	ImageRef              string           `json:"image_ref"`
+	ClonedFrom            string           `json:"cloned_from,omitempty"`
+	WorkspacePath         string           `json:"workspace_path"`
+	StatePath             string           `json:"state_path"`
+	StateMode             string           `json:"state_mode,omitempty"`
+	Gateways              []Gateway        `json:"gateways"`
+	Readiness             *Readiness       `json:"readiness,omitempty"`
+	PublishedPorts        []PortMapping    `json:"published_ports"`
+	ExtraHosts            []HostEntry      `json:"extra_hosts,omitempty"`
+	CronJobs              []string         `json:"cron_jobs,omitempty"`
+	HostAlias             *HostEntry       `json:"host_alias,omitempty"`
+	DNSServers            []string         `json:"dns_servers,omitempty"`
+	Volumes               []VolumeMount    `json:"volumes,omitempty"`
+	ShareOwnership        string           `json:"share_ownership,omitempty"`
+	WorkspaceWatch        bool             `json:"workspace_watch,omitempty"`
+	WatchPID              int              `json:"watch_pid,omitempty"`
+	InjectedEnv           []string         `json:"injected_env,omitempty"`
+	IgnoredRequiredEnv    []string         `json:"ignored_required_env,omitempty"`
+	OpenClawPackage       *OpenClawPackage `json:"openclaw_package,omitempty"`
+	Blobs                 []string         `json:"blobs,omitempty"`
+	Status                string           `json:"status"`
+	Backend               string           `json:"backend"`
+	PID                   int              `json:"pid,omitempty"`
+	DiskPath              string           `json:"disk_path,omitempty"`
+	DiskKeyPath           string           `json:"disk_key_path,omitempty"`
+	BackingCheckpoint     string           `json:"backing_checkpoint,omitempty"`
+	DiskSizeBytes         int64            `json:"disk_size_bytes,omitempty"`
+	DiskUsedPercent       int              `json:"disk_used_percent,omitempty"`
+	DiskCheckedAtUTC      time.Time        `json:"disk_checked_at_utc"`
+	RootfsMode            string           `json:"rootfs_mode,omitempty"`
+	Hardened              bool             `json:"hardened,omitempty"`
+	DirtyShutdown         bool             `json:"dirty_shutdown,omitempty"`
+	SleepSuspended        bool             `json:"sleep_suspended,omitempty"`
+	GatewayAuth           string           `json:"gateway_auth,omitempty"`
+	GatewayCredentialPath string           `json:"gateway_credential_path,omitempty"`
+	SeedISOPath           string           `json:"seed_iso_path,omitempty"`
+	SerialLogPath         string           `json:"serial_log_path,omitempty"`
+	QEMULogPath           string           `json:"qemu_log_path,omitempty"`
+	MonitorPath           string           `json:"monitor_path,omitempty"`
+	SSHHostPort           int              `json:"ssh_host_port,omitempty"`
+	SSHKeyPath            string           `json:"ssh_key_path,omitempty"`
+	GuestUser             string           `json:"guest_user,omitempty"`
+	GuestSudo             string           `json:"guest_sudo,omitempty"`
+	QEMUAccel             string           `json:"qemu_accel,omitempty"`
+	QEMUCommand           []string         `json:"qemu_command,omitempty"`
+	BootMemoryMiB         int              `json:"boot_memory_mib,omitempty"`
+	LastError             string           `json:"last_error,omitempty"`
+	CreatedAtUTC          time.Time        `json:"created_at_utc"`
+	StartedAtUTC          time.Time        `json:"started_at_utc"`
+	UpdatedAtUTC          time.Time        `json:"updated_at_utc"`
+	WokeAtUTC             time.Time        `json:"woke_at_utc"`
+}
+
+func (i *Instance) UnmarshalJSON(payload []byte) error {
+	type instanceFields Instance
+	var decoded struct {
+		instanceFields
+		LegacyGatewayPort int `json:"gateway_port"`
+	}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return err
+	}
+	*i = Instance(decoded.instanceFields)
+	if len(i.Gateways) == 0 && decoded.LegacyGatewayPort > 0 {
+		i.Gateways = []Gateway{{Name: PrimaryGatewayName, HostPort: decoded.LegacyGatewayPort, GuestPort: decoded.LegacyGatewayPort}}
+	}
+	return nil
+}
+
+func (i Instance) BootedAt() time.Time {
+	if i.StartedAtUTC.After(i.CreatedAtUTC) {
+		return i.StartedAtUTC
+	}
+	return i.CreatedAtUTC
+}
+
+func (i Instance) SSHUser() string {
+	if i.GuestUser == "" {
+		return "claw"
+	}
+	return i.GuestUser
+}
+
+func (i Instance) GatewayPort() int {
+	if len(i.Gateways) == 0 {
+		return 0
+	}
+	return i.Gateways[0].HostPort
 }
 
 type Store struct {
-	root string
+	root   string
+	shared bool
 }
 
 func NewStore(root string) *Store {
 	return &Store{root: root}
 }
 
+// WithShared makes the instance directories, their metadata and the files
+// other commands leave in them group-writable, for data dirs shared between
+// users.
+func (s *Store) WithShared(shared bool) *Store {
+	s.shared = shared
+	return s
+}
+
 func (s *Store) Save(instance Instance) error {
 	directory := filepath.Join(s.root, instance.ID)
 	if err := os.MkdirAll(directory, 0o755); err != nil {
 		return err
 	}
-	file, err := os.Create(filepath.Join(directory, metadataFileName))
+	path := filepath.Join(directory, metadataFileName)
+	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
@@ -60,7 +182,32 @@ func (s *Store) Save(instance Instance) error {
 
 	encoder := json.NewEncoder(file)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(instance)
+	if err := encoder.Encode(instance); err != nil {
+		return err
+	}
+	return s.ShareFiles(instance.ID)
+}
+
+// ShareFiles opens everything under an instance directory to the group of a
+// shared data dir. Disks, logs, seed ISOs and checkpoints are written by the
+// backend and other commands rather than the store, so Save sweeps them too.
+// Files another user owns are left to them.
+func (s *Store) ShareFiles(id string) error {
+	if !s.shared {
+		return nil
+	}
+	return filepath.WalkDir(filepath.Join(s.root, id), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if err := MakeShared(path); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+		return nil
+	})
 }
 
 func (s *Store) Load(id string) (Instance, error) {
@@ -121,3 +268,63 @@ func (s *Store) Delete(id string) error {
 	}
 	return os.RemoveAll(directory)
 }
+
+func (s *Store) Forget(id string) error {
+	instance, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	if len(instance.Blobs) > 0 {
+		path := filepath.Join(s.root, id, keptBlobsFileName)
+		payload, err := json.Marshal(instance.Blobs)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, payload, 0o644); err != nil {
+			return err
+		}
+		if s.shared {
+			if err := MakeShared(path); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(s.root, id, metadataFileName)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// KeptBlobs returns the blobs still referenced by data dirs that were kept
+// after their instance was forgotten, keyed by instance ID.
+func (s *Store) KeptBlobs() (map[string][]string, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	kept := map[string][]string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		payload, err := os.ReadFile(filepath.Join(s.root, entry.Name(), keptBlobsFileName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var digests []string
+		if err := json.Unmarshal(payload, &digests); err != nil {
+			return nil, fmt.Errorf("read kept blobs of %s: %w", entry.Name(), err)
+		}
+		kept[entry.Name()] = digests
+	}
+	return kept, nil
+}
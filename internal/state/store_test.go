@@ -0,0 +1,47 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreLoadsLegacyGatewayPort(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "claw-legacy"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	legacy := `{"id":"claw-legacy","image_ref":"ubuntu:24.04","gateway_port":18789,"published_ports":[],"status":"ready","backend":"qemu"}`
+	if err := os.WriteFile(filepath.Join(root, "claw-legacy", metadataFileName), []byte(legacy), 0o644); err != nil {
+		t.Fatalf("write legacy metadata: %v", err)
+	}
+
+	store := NewStore(root)
+	instance, err := store.Load("claw-legacy")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if instance.GatewayPort() != 18789 || len(instance.Gateways) != 1 || instance.Gateways[0] != (Gateway{Name: PrimaryGatewayName, HostPort: 18789, GuestPort: 18789}) {
+		t.Fatalf("unexpected gateways from legacy metadata: %+v", instance.Gateways)
+	}
+	if instance.ImageRef != "ubuntu:24.04" || instance.Status != "ready" {
+		t.Fatalf("expected other fields to survive legacy decoding: %+v", instance)
+	}
+
+	instance.Gateways = append(instance.Gateways, Gateway{Name: "ui", HostPort: 18790, GuestPort: 3000, Path: "/health", Status: "ready"})
+	if err := store.Save(instance); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	payload, _ := os.ReadFile(filepath.Join(root, "claw-legacy", metadataFileName))
+	if strings.Contains(string(payload), `"gateway_port"`) {
+		t.Fatalf("expected gateways list to replace gateway_port, got %s", payload)
+	}
+	reloaded, err := store.Load("claw-legacy")
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if len(reloaded.Gateways) != 2 || reloaded.Gateways[1].Name != "ui" || reloaded.GatewayPort() != 18789 {
+		t.Fatalf("unexpected reloaded gateways: %+v", reloaded.Gateways)
+	}
+}
@@ -0,0 +1,80 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type TrustedClawbox struct {
+	SpecHash     string    `json:"spec_hash"`
+	Source       string    `json:"source"`
+	TrustedAtUTC time.Time `json:"trusted_at_utc"`
+}
+
+type TrustStore struct {
+	path string
+}
+
+func NewTrustStore(path string) *TrustStore {
+	return &TrustStore{path: path}
+}
+
+func (s *TrustStore) IsTrusted(specHash string) (bool, error) {
+	entries, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.SpecHash == specHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *TrustStore) Trust(specHash string, source string) error {
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.SpecHash == specHash {
+			return nil
+		}
+	}
+	entries = append(entries, TrustedClawbox{SpecHash: specHash, Source: source, TrustedAtUTC: time.Now().UTC()})
+
+	payload, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	temporaryPath := s.path + ".tmp"
+	if err := os.WriteFile(temporaryPath, append(payload, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, s.path)
+}
+
+func (s *TrustStore) load() ([]TrustedClawbox, error) {
+	payload, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if strings.TrimSpace(string(payload)) == "" {
+		return nil, nil
+	}
+	var entries []TrustedClawbox
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
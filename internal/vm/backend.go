@@ -6,15 +6,44 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"net/http"
+	"regexp"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/yazhou/krunclaw/internal/vm/cloudinitbuilder"
+	"github.com/yazhou/krunclaw/internal/vm/qemuargsbuilder"
 )
 
-type PortMapping struct {
-	HostPort  int
-	GuestPort int
-}
+const (
+	RootfsReadWrite       = "rw"
+	RootfsReadOnlyOverlay = "ro-overlay"
+
+	StateModeMount = "mount"
+	StateModeDisk  = "disk"
+	StateModeNone  = "none"
+
+	ShareOwnershipPassthrough = "passthrough"
+	ShareOwnershipMapped      = "mapped"
+
+	BackendQEMU = "qemu"
+	BackendVZF  = "vzf"
+	BackendTest = "test"
+
+	DefaultGuestUserName = cloudinitbuilder.DefaultGuestUserName
+	GuestSudoNoPassword  = cloudinitbuilder.GuestSudoNoPassword
+	GuestSudoPassword    = cloudinitbuilder.GuestSudoPassword
+	GuestSudoNone        = cloudinitbuilder.GuestSudoNone
+)
+
+var (
+	OpenClawIntegrityPattern = regexp.MustCompile(`^sha512-[A-Za-z0-9+/]{86}==$`)
+	guestUserNamePattern     = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+)
+
+type PortMapping = qemuargsbuilder.PortMapping
+
+type GuestUser = cloudinitbuilder.GuestUser
 
 type VolumeMount struct {
 	Name      string
@@ -22,25 +51,95 @@ type VolumeMount struct {
 	GuestPath string
 }
 
+type HostEntry = cloudinitbuilder.HostEntry
+
+const CrontabPath = cloudinitbuilder.CrontabPath
+
+var (
+	SplitCronJob  = cloudinitbuilder.SplitCronJob
+	RenderCrontab = cloudinitbuilder.RenderCrontab
+
+	EffectiveOpenClawConfig = cloudinitbuilder.EffectiveOpenClawConfig
+)
+
+const (
+	HostAliasName = "host.clawfarm.internal"
+	UserNetHostIP = "10.0.2.2"
+)
+
+func HostAlias(extraHosts []HostEntry) HostEntry {
+	for _, entry := range extraHosts {
+		if strings.EqualFold(strings.TrimSpace(entry.Name), HostAliasName) {
+			return entry
+		}
+	}
+	return HostEntry{Name: HostAliasName, IP: UserNetHostIP}
+}
+
+func WithHostAlias(extraHosts []HostEntry) []HostEntry {
+	for _, entry := range extraHosts {
+		if strings.EqualFold(strings.TrimSpace(entry.Name), HostAliasName) {
+			return extraHosts
+		}
+	}
+	return append([]HostEntry{HostAlias(nil)}, extraHosts...)
+}
+
 type StartSpec struct {
+	Backend             string
 	InstanceID          string
 	InstanceDir         string
 	ImageArch           string
 	SourceDiskPath      string
+	DiskKeyPath         string
+	UncleanShutdown     bool
+	RootfsMode          string
+	Hardened            bool
+	QEMUUser            string
+	ShareOwnership      string
+	WatchPath           string
+	RootfsTarPath       string
 	ClawPath            string
 	WorkspacePath       string
+	NoWorkspace         bool
 	StatePath           string
+	StateMode           string
 	GatewayHostPort     int
 	GatewayGuestPort    int
 	PublishedPorts      []PortMapping
 	VolumeMounts        []VolumeMount
+	DNSServers          []string
+	ExtraHosts          []HostEntry
 	CPUs                int
 	MemoryMiB           int
 	OpenClawPackage     string
+	OpenClawTarballPath string
+	OpenClawIntegrity   string
+	OpenClawOffline     bool
 	OpenClawConfig      string
 	OpenClawEnvironment map[string]string
 	SSHAuthorizedKeys   []string
+	GuestUser           GuestUser
 	CloudInitProvision  []string
+	CronJobs            []string
+	// DiskSizeBytes is the virtual size the instance disk was grown to; the
+	// guest grows its root filesystem to match when it is set.
+	DiskSizeBytes int64
+	// IncomingStatePath resumes the VM from a memory snapshot taken with
+	// SaveMemoryState; the devices must match the ones it was taken from.
+	IncomingStatePath string `json:"-"`
+}
+
+func ValidateGuestUser(user GuestUser) error {
+	if user.Name != "" && (!guestUserNamePattern.MatchString(user.Name) || user.Name == "root") {
+		return fmt.Errorf("invalid guest user %q: use a lowercase login name other than root", user.Name)
+	}
+	switch user.Sudo {
+	case "", GuestSudoNoPassword, GuestSudoPassword, GuestSudoNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid guest sudo policy %q: expected nopasswd, password or none", user.Sudo)
+	}
 }
 
 type StartResult struct {
@@ -56,11 +155,22 @@ type StartResult struct {
 	Command       []string
 }
 
+// Process identifies a VM a Backend started. Callers persist it with the
+// instance and hand it back to Stop, Suspend and Resume, so the backend never
+// has to guess how to reach a PID.
+type Process struct {
+	PID     int
+	Backend string
+	// MonitorPath is the QMP socket of a QEMU VM or the REST socket of a
+	// vfkit VM; empty for instances started before it was recorded.
+	MonitorPath string
+}
+
 type Backend interface {
 	Start(ctx context.Context, spec StartSpec) (StartResult, error)
-	Stop(ctx context.Context, pid int) error
-	Suspend(pid int) error
-	Resume(pid int) error
+	Stop(ctx context.Context, process Process) error
+	Suspend(process Process) error
+	Resume(process Process) error
 	IsRunning(pid int) bool
 }
 
@@ -84,22 +194,7 @@ func WaitForTCP(ctx context.Context, address string) error {
 }
 
 func WaitForHTTP(ctx context.Context, url string) error {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		if IsHTTPReachable(url, 2*time.Second) {
-			return nil
-		}
-		select {
-		case <-ctx.Done():
-			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-				return fmt.Errorf("timeout waiting for %s", url)
-			}
-			return ctx.Err()
-		case <-ticker.C:
-		}
-	}
+	return WaitForHTTPReady(ctx, url, HTTPReadiness{})
 }
 
 func IsTCPReachable(address string, timeout time.Duration) bool {
@@ -112,13 +207,7 @@ func IsTCPReachable(address string, timeout time.Duration) bool {
 }
 
 func IsHTTPReachable(url string, timeout time.Duration) bool {
-	client := &http.Client{Timeout: timeout}
-	response, err := client.Get(url)
-	if err != nil {
-		return false
-	}
-	_ = response.Body.Close()
-	return response.StatusCode >= 100 && response.StatusCode <= 599
+	return CheckHTTPReadiness(url, HTTPReadiness{}, timeout) == nil
 }
 
 func processExists(pid int) bool {
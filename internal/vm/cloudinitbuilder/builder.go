@@ -3,10 +3,10 @@ package cloudinitbuilder
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type CloudInitBuilder struct {
@@ -14,11 +14,55 @@ type CloudInitBuilder struct {
 	InstanceDir         string
 	GatewayGuestPort    int
 	OpenClawPackage     string
+	OpenClawTarballName string
+	OpenClawIntegrity   string
+	OpenClawOffline     bool
 	OpenClawConfig      string
 	OpenClawEnvironment map[string]string
 	SSHAuthorizedKeys   []string
+	GuestUser           GuestUser
 	VolumeMounts        []VolumeMount
+	DNSServers          []string
+	ExtraHosts          []HostEntry
+	RootfsMode          string
+	NoWorkspace         bool
+	StateMode           string
+	FsckOnBoot          bool
+	GrowRootfs          bool
+	WorkspaceWatch      bool
+	RootfsTarName       string
 	CloudInitProvision  []string
+	CronJobs            []string
+	ShareFilesystem     string
+}
+
+const (
+	ShareFilesystem9P       = "9p"
+	ShareFilesystemVirtiofs = "virtiofs"
+
+	ninePMountPrefix = "mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144"
+
+	DefaultGuestUserName = "claw"
+	GuestSudoNoPassword  = "nopasswd"
+	GuestSudoPassword    = "password"
+	GuestSudoNone        = "none"
+)
+
+type GuestUser struct {
+	Name          string
+	Sudo          string
+	PasswordLogin bool
+}
+
+func (user GuestUser) normalized() GuestUser {
+	user.Name = strings.TrimSpace(user.Name)
+	if user.Name == "" {
+		user.Name = DefaultGuestUserName
+	}
+	if user.Sudo == "" {
+		user.Sudo = GuestSudoNoPassword
+	}
+	return user
 }
 
 type VolumeMount struct {
@@ -26,6 +70,11 @@ type VolumeMount struct {
 	GuestPath string
 }
 
+type HostEntry struct {
+	Name string
+	IP   string
+}
+
 func NewCloudInitBuilder() *CloudInitBuilder {
 	return &CloudInitBuilder{}
 }
@@ -46,6 +95,20 @@ func (builder *CloudInitBuilder) WithOpenClawPackage(openClawPackage string) *Cl
 	return builder
 }
 
+func (builder *CloudInitBuilder) WithOpenClawPin(openClawTarballPath string, openClawIntegrity string) *CloudInitBuilder {
+	builder.OpenClawTarballName = ""
+	if strings.TrimSpace(openClawTarballPath) != "" {
+		builder.OpenClawTarballName = filepath.Base(openClawTarballPath)
+	}
+	builder.OpenClawIntegrity = strings.TrimSpace(openClawIntegrity)
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithOpenClawOffline(openClawOffline bool) *CloudInitBuilder {
+	builder.OpenClawOffline = openClawOffline
+	return builder
+}
+
 func (builder *CloudInitBuilder) WithOpenClawConfig(openClawConfig string) *CloudInitBuilder {
 	builder.OpenClawConfig = openClawConfig
 	return builder
@@ -69,53 +132,117 @@ func (builder *CloudInitBuilder) WithSSHAuthorizedKeys(sshAuthorizedKeys []strin
 	return builder
 }
 
+func (builder *CloudInitBuilder) WithGuestUser(guestUser GuestUser) *CloudInitBuilder {
+	builder.GuestUser = guestUser
+	return builder
+}
+
 func (builder *CloudInitBuilder) WithCloudInitProvision(cloudInitProvision []string) *CloudInitBuilder {
 	builder.CloudInitProvision = append([]string(nil), cloudInitProvision...)
 	return builder
 }
 
+func (builder *CloudInitBuilder) WithCronJobs(cronJobs []string) *CloudInitBuilder {
+	builder.CronJobs = append([]string(nil), cronJobs...)
+	return builder
+}
+
 func (builder *CloudInitBuilder) WithVolumeMounts(volumeMounts []VolumeMount) *CloudInitBuilder {
 	builder.VolumeMounts = append([]VolumeMount(nil), volumeMounts...)
 	return builder
 }
 
-func (builder *CloudInitBuilder) CreateNoCloudSeedISO(outputPath string) error {
+func (builder *CloudInitBuilder) WithNetwork(dnsServers []string, extraHosts []HostEntry) *CloudInitBuilder {
+	builder.DNSServers = append([]string(nil), dnsServers...)
+	builder.ExtraHosts = append([]HostEntry(nil), extraHosts...)
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithRootfsMode(rootfsMode string) *CloudInitBuilder {
+	builder.RootfsMode = rootfsMode
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithNoWorkspace(noWorkspace bool) *CloudInitBuilder {
+	builder.NoWorkspace = noWorkspace
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithStateMode(stateMode string) *CloudInitBuilder {
+	builder.StateMode = stateMode
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithFsckOnBoot(fsckOnBoot bool) *CloudInitBuilder {
+	builder.FsckOnBoot = fsckOnBoot
+	return builder
+}
+
+// WithGrowRootfs grows the root partition and filesystem to fill the disk on
+// every boot, after the disk has been enlarged on the host.
+func (builder *CloudInitBuilder) WithGrowRootfs(growRootfs bool) *CloudInitBuilder {
+	builder.GrowRootfs = growRootfs
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithWorkspaceWatch(workspaceWatch bool) *CloudInitBuilder {
+	builder.WorkspaceWatch = workspaceWatch
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithRootfsTar(rootfsTarPath string) *CloudInitBuilder {
+	builder.RootfsTarName = ""
+	if strings.TrimSpace(rootfsTarPath) != "" {
+		builder.RootfsTarName = filepath.Base(rootfsTarPath)
+	}
+	return builder
+}
+
+func (builder *CloudInitBuilder) WithShareFilesystem(shareFilesystem string) *CloudInitBuilder {
+	builder.ShareFilesystem = shareFilesystem
+	return builder
+}
+
+func (builder *CloudInitBuilder) WriteNoCloudSeed() (string, error) {
 	seedDir := filepath.Join(builder.InstanceDir, "seed")
 	if err := os.RemoveAll(seedDir); err != nil {
-		return err
+		return "", err
 	}
 	if err := os.MkdirAll(seedDir, 0o755); err != nil {
-		return err
+		return "", err
 	}
 
 	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", builder.InstanceID, builder.InstanceID)
 	userData := builder.BuildCloudInitUserData()
 
 	if err := os.WriteFile(filepath.Join(seedDir, "meta-data"), []byte(metaData), 0o644); err != nil {
-		return err
+		return "", err
 	}
 	if err := os.WriteFile(filepath.Join(seedDir, "user-data"), []byte(userData), 0o644); err != nil {
-		return err
+		return "", err
 	}
+	return seedDir, nil
+}
 
-	if _, err := exec.LookPath("hdiutil"); err != nil {
-		return fmt.Errorf("hdiutil is required to build cloud-init seed ISO")
-	}
-	if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
+func (builder *CloudInitBuilder) CreateNoCloudSeedISO(outputPath string) error {
+	seedDir, err := builder.WriteNoCloudSeed()
+	if err != nil {
 		return err
 	}
-
-	command := exec.Command(
-		"hdiutil", "makehybrid", "-quiet",
-		"-o", outputPath,
-		seedDir,
-		"-iso",
-		"-joliet",
-		"-default-volume-name", "cidata",
-	)
-	output, err := command.CombinedOutput()
+	entries, err := os.ReadDir(seedDir)
 	if err != nil {
-		return fmt.Errorf("build seed iso: %s", strings.TrimSpace(string(output)))
+		return err
+	}
+	files := make([]isoFile, 0, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(seedDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		files = append(files, isoFile{Name: entry.Name(), Data: data})
+	}
+	if err := writeISO9660(outputPath, "cidata", files, time.Now()); err != nil {
+		return fmt.Errorf("build seed iso: %w", err)
 	}
 	return nil
 }
@@ -123,16 +250,16 @@ func (builder *CloudInitBuilder) CreateNoCloudSeedISO(outputPath string) error {
 func (builder *CloudInitBuilder) BuildCloudInitUserData() string {
 	bootstrapScript := builder.BuildBootstrapScript()
 	sshAuthorizedKeysSection := renderSSHAuthorizedKeysSection(builder.SSHAuthorizedKeys)
+	guestUser := builder.GuestUser.normalized()
 	return fmt.Sprintf(`#cloud-config
 package_update: false
+%sssh_pwauth: %t
 users:
   - default
-  - name: claw
+  - name: %s
     gecos: Claw User
     shell: /bin/bash
-    groups: [sudo]
-    sudo: ["ALL=(ALL) NOPASSWD:ALL"]
-    lock_passwd: true
+%s    lock_passwd: %t
 %s
 write_files:
   - path: /usr/local/bin/clawfarm-bootstrap.sh
@@ -142,7 +269,7 @@ write_files:
 %s
 runcmd:
   - [ bash, -lc, "/usr/local/bin/clawfarm-bootstrap.sh > /var/log/clawfarm-bootstrap.log 2>&1" ]
-`, sshAuthorizedKeysSection, IndentForCloudConfig(bootstrapScript, 6))
+`, renderGrowpartSection(builder.GrowRootfs), guestUser.PasswordLogin, guestUser.Name, renderGuestSudoSection(guestUser.Sudo), !guestUser.PasswordLogin, sshAuthorizedKeysSection, IndentForCloudConfig(bootstrapScript, 6))
 }
 
 func (builder *CloudInitBuilder) BuildBootstrapScript() string {
@@ -151,27 +278,26 @@ func (builder *CloudInitBuilder) BuildBootstrapScript() string {
 		packageName = "openclaw@latest"
 	}
 
-	openClawConfig := strings.TrimSpace(builder.OpenClawConfig)
-	if openClawConfig == "" {
-		openClawConfig = fmt.Sprintf(`{
-  "agents": {
-    "defaults": {
-      "workspace": "/workspace"
-    }
-  },
-  "gateway": {
-    "mode": "local",
-    "port": %d
-  }
-}`, builder.GatewayGuestPort)
-	}
+	openClawConfig := EffectiveOpenClawConfig(builder.OpenClawConfig, builder.GatewayGuestPort)
 
 	openClawEnv := renderOpenClawEnvironment(builder.OpenClawEnvironment)
+	guestUser := builder.GuestUser.normalized()
+	guestUserScript := renderGuestUserScript(guestUser)
 	sshBootstrapScript := renderSSHBootstrapScript(builder.SSHAuthorizedKeys)
 	volumeMountScript := renderVolumeMountScript(builder.VolumeMounts)
+	networkScript := renderNetworkScript(builder.DNSServers, builder.ExtraHosts)
+	rootfsTarScript := renderRootfsTarScript(builder.RootfsTarName)
+	rootfsScript := renderRootfsScript(builder.RootfsMode)
+	workspaceMountScript := renderWorkspaceMountScript(builder.NoWorkspace)
+	stateMountScript := renderStateMountScript(builder.StateMode)
+	fsckScript := renderFsckScript(builder.FsckOnBoot)
+	workspaceWatchScript := renderWorkspaceWatchScript(builder.WorkspaceWatch && !builder.NoWorkspace)
 	provisionScript := renderProvisionScript(builder.CloudInitProvision)
+	cronScript := renderCronScript(builder.CronJobs)
+	openClawPrerequisitesScript := renderOpenClawPrerequisitesScript(builder.OpenClawOffline && builder.OpenClawTarballName != "")
+	openClawInstallScript := renderOpenClawInstallScript(packageName, builder.OpenClawTarballName, builder.OpenClawIntegrity, builder.OpenClawOffline)
 
-	return fmt.Sprintf(`#!/usr/bin/env bash
+	script := fmt.Sprintf(`#!/usr/bin/env bash
 set -euxo pipefail
 
 modprobe 9p 2>/dev/null || true
@@ -180,27 +306,77 @@ modprobe 9pnet_virtio 2>/dev/null || true
 
 mkdir -p /workspace /root/.openclaw /etc/clawfarm
 
-if ! id -u claw >/dev/null 2>&1; then
-  useradd -m -s /bin/bash claw
-fi
-usermod -aG sudo claw || true
-install -d -m 0755 -o claw -g claw /claw
+clawfarm_install_phase() {
+  {
+    echo "$1"
+    if [[ $# -gt 1 ]]; then
+      echo "$2"
+    fi
+  } >/root/.openclaw/clawfarm-install.tmp 2>/dev/null || return 0
+  mv -f /root/.openclaw/clawfarm-install.tmp /root/.openclaw/clawfarm-install.status || true
+}
+
+clawfarm_retry() {
+  local attempt=1 delay=5
+  until "$@"; do
+    if [[ $attempt -ge 4 ]]; then
+      echo "clawfarm: giving up on $* after $attempt attempts"
+      return 1
+    fi
+    echo "clawfarm: attempt $attempt of $* failed; retrying in ${delay}s"
+    sleep "$delay"
+    attempt=$((attempt + 1))
+    delay=$((delay * 2))
+  done
+}
+
+clawfarm_install_node() {
+  if clawfarm_retry bash -c 'curl -fsSL https://deb.nodesource.com/setup_22.x | bash - && apt-get install -y --no-install-recommends nodejs'; then
+    return 0
+  fi
+  echo "clawfarm: nodesource install failed; trying distro nodejs"
+  if clawfarm_retry apt-get install -y --no-install-recommends nodejs npm && command -v npm >/dev/null 2>&1; then
+    return 0
+  fi
+  echo "clawfarm: distro nodejs install failed; trying prebuilt node tarball"
+  local node_arch node_base node_tarball
+  case "$(uname -m)" in
+    x86_64) node_arch=x64 ;;
+    aarch64|arm64) node_arch=arm64 ;;
+    *) return 1 ;;
+  esac
+  node_base=https://nodejs.org/dist/latest-v22.x
+  rm -rf /tmp/clawfarm-node
+  install -d -m 0700 /tmp/clawfarm-node
+  clawfarm_retry curl -fsSL -o /tmp/clawfarm-node/SHASUMS256.txt "$node_base/SHASUMS256.txt" || return 1
+  node_tarball="$(awk -v suffix="-linux-$node_arch.tar.xz" 'substr($2, length($2) - length(suffix) + 1) == suffix { print $2; exit }' /tmp/clawfarm-node/SHASUMS256.txt)"
+  [[ -n "$node_tarball" ]] || return 1
+  clawfarm_retry curl -fsSL -o "/tmp/clawfarm-node/$node_tarball" "$node_base/$node_tarball" || return 1
+  (cd /tmp/clawfarm-node && grep " $node_tarball\$" SHASUMS256.txt | sha256sum -c -) || return 1
+  tar -xJf "/tmp/clawfarm-node/$node_tarball" -C /usr/local --strip-components=1 --no-same-owner
+}
 
 %s
 
-if ! mountpoint -q /workspace; then
-  mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144 workspace /workspace || true
-fi
-if ! mountpoint -q /root/.openclaw; then
-  mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144 state /root/.openclaw || true
-fi
+%s
+
+%s
+
+%s
+
+%s
+
+%s
+
+%s
+%s
 if ! mountpoint -q /claw; then
   mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144 claw /claw || true
 fi
 
 %s
 
-chown -R claw:claw /claw || true
+chown -R %s /claw || true
 
 cat >/etc/clawfarm/openclaw.json <<'CLAWFARM_OPENCLAW_JSON'
 %s
@@ -217,6 +393,7 @@ set -euo pipefail
 
 export HOME=/root
 export OPENCLAW_CONFIG_PATH=/etc/clawfarm/openclaw.json
+export CLAWFARM_STATUS_FILE=/root/.openclaw/status.json
 if [[ -f /etc/clawfarm/openclaw.env ]]; then
   set -a
   source /etc/clawfarm/openclaw.env
@@ -227,12 +404,38 @@ if command -v openclaw >/dev/null 2>&1; then
   exec openclaw gateway --allow-unconfigured --port %d
 fi
 
-exec /usr/bin/python3 -m http.server %d --directory /workspace
+exec /usr/bin/python3 - %d <<'PY'
+import functools
+import http.server
+import sys
+
+
+def gateway_marker():
+    try:
+        with open("/root/.openclaw/clawfarm-install.status") as status:
+            if status.readline().strip() == "failed":
+                return "install-failed"
+    except OSError:
+        pass
+    return "fallback"
+
+
+class FallbackHandler(http.server.SimpleHTTPRequestHandler):
+    def end_headers(self):
+        self.send_header("X-Clawfarm-Gateway", gateway_marker())
+        super().end_headers()
+
+
+handler = functools.partial(FallbackHandler, directory="/workspace")
+http.server.ThreadingHTTPServer(("", int(sys.argv[1])), handler).serve_forever()
+PY
 SCRIPT
 chmod +x /usr/local/bin/clawfarm-gateway.sh
 
 %s
 
+%s
+
 cat >/etc/systemd/system/clawfarm-gateway.service <<'UNIT'
 [Unit]
 Description=clawfarm Gateway Service
@@ -255,25 +458,247 @@ systemctl enable --now clawfarm-gateway.service
 if ! command -v openclaw >/dev/null 2>&1; then
   (
     set +e
-    export DEBIAN_FRONTEND=noninteractive
-    apt-get update
-    apt-get install -y --no-install-recommends ca-certificates curl gnupg bash python3
-    if ! command -v node >/dev/null 2>&1; then
-      curl -fsSL https://deb.nodesource.com/setup_22.x | bash -
-      apt-get install -y --no-install-recommends nodejs
+    (
+    clawfarm_install_phase apt
+%s
+    clawfarm_install_phase npm
+%s
+    )
+    if command -v openclaw >/dev/null 2>&1; then
+      clawfarm_install_phase done
+    else
+      clawfarm_install_phase failed "$(grep -v '^+' /var/log/clawfarm-openclaw-install.log | tail -n 20)"
     fi
-    npm install -g %s
     systemctl restart clawfarm-gateway.service
   ) >/var/log/clawfarm-openclaw-install.log 2>&1 &
+else
+  clawfarm_install_phase done
 fi
 
 if [[ -x /usr/local/bin/clawfarm-provision.sh ]]; then
   /usr/local/bin/clawfarm-provision.sh >/var/log/clawfarm-provision.log 2>&1
 fi
 
+%s
 install -d -m 0755 /var/lib/clawfarm
 touch /var/lib/clawfarm/bootstrap.ready
-`, sshBootstrapScript, volumeMountScript, openClawConfig, openClawEnv, builder.GatewayGuestPort, builder.GatewayGuestPort, provisionScript, packageName)
+`, fsckScript, rootfsScript, networkScript, rootfsTarScript, guestUserScript, sshBootstrapScript, workspaceMountScript, stateMountScript, volumeMountScript, guestUser.Name+":"+guestUser.Name, openClawConfig, openClawEnv, builder.GatewayGuestPort, builder.GatewayGuestPort, provisionScript, workspaceWatchScript, openClawPrerequisitesScript, openClawInstallScript, cronScript)
+	if builder.ShareFilesystem == ShareFilesystemVirtiofs {
+		script = strings.ReplaceAll(script, ninePMountPrefix+",ro ", "mount -t virtiofs -o ro ")
+		script = strings.ReplaceAll(script, ninePMountPrefix+" ", "mount -t virtiofs ")
+	}
+	return script
+}
+
+// EffectiveOpenClawConfig is the /etc/clawfarm/openclaw.json a guest boots
+// with: the given config, or a local gateway on gatewayGuestPort when empty.
+func EffectiveOpenClawConfig(openClawConfig string, gatewayGuestPort int) string {
+	if trimmed := strings.TrimSpace(openClawConfig); trimmed != "" {
+		return trimmed
+	}
+	return fmt.Sprintf(`{
+  "agents": {
+    "defaults": {
+      "workspace": "/workspace"
+    }
+  },
+  "gateway": {
+    "mode": "local",
+    "port": %d
+  }
+}`, gatewayGuestPort)
+}
+
+func renderOpenClawPrerequisitesScript(offline bool) string {
+	prerequisites := `    export DEBIAN_FRONTEND=noninteractive
+    clawfarm_retry apt-get update
+    clawfarm_retry apt-get install -y --no-install-recommends ca-certificates curl gnupg bash python3 xz-utils
+    if ! command -v node >/dev/null 2>&1; then
+      clawfarm_install_phase node
+      clawfarm_install_node
+    fi`
+	if !offline {
+		return prerequisites
+	}
+	return "    if ! command -v node >/dev/null 2>&1; then\n" + IndentForCloudConfig(prerequisites, 2) + "\n    fi"
+}
+
+func renderOpenClawInstallScript(packageName string, tarballName string, integrity string, offline bool) string {
+	if integrity == "" {
+		return "    clawfarm_retry npm install -g " + packageName
+	}
+	fetch := fmt.Sprintf(`    (cd /tmp/clawfarm-openclaw && clawfarm_retry npm pack %s)
+    package_file="$(ls /tmp/clawfarm-openclaw/*.tgz | head -n 1)"`, shellSingleQuote(packageName))
+	if tarballName != "" {
+		fetch = fmt.Sprintf(`    install -d -m 0755 /run/clawfarm-openclaw
+    mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144,ro openclaw-pkg /run/clawfarm-openclaw
+    cp /run/clawfarm-openclaw/%s /tmp/clawfarm-openclaw/openclaw.tgz
+    umount /run/clawfarm-openclaw || true
+    package_file=/tmp/clawfarm-openclaw/openclaw.tgz`, shellSingleQuote(tarballName))
+	}
+	install := `    clawfarm_retry npm install -g "$package_file"`
+	if offline && tarballName != "" {
+		install = `    (cd /tmp/clawfarm-openclaw && npm install -g --offline --no-audit --no-fund ./openclaw.tgz)`
+	}
+	return fmt.Sprintf(`    rm -rf /tmp/clawfarm-openclaw
+    install -d -m 0700 /tmp/clawfarm-openclaw
+%s
+    actual_integrity="sha512-$(openssl dgst -sha512 -binary "$package_file" | base64 -w0)"
+    if [[ "$actual_integrity" != %s ]]; then
+      echo "openclaw package integrity mismatch: expected %s, got $actual_integrity"
+      install -d -m 0755 /var/lib/clawfarm
+      echo "$actual_integrity" >/var/lib/clawfarm/openclaw-integrity.failed
+      exit 1
+    fi
+%s`, fetch, shellSingleQuote(integrity), integrity, install)
+}
+
+func renderWorkspaceMountScript(noWorkspace bool) string {
+	if noWorkspace {
+		return `echo none >/etc/clawfarm/workspace
+chmod 0755 /workspace`
+	}
+	return `if ! mountpoint -q /workspace; then
+  mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144 workspace /workspace || true
+fi`
+}
+
+// renderFsckScript reports the error state ext4 recorded in the superblock.
+// By the time this runs the root filesystem is mounted read-write, and fsck
+// -n on a live filesystem reports in-flight metadata as damage, so only the
+// kernel's own record is trusted here.
+func renderFsckScript(fsckOnBoot bool) string {
+	if !fsckOnBoot {
+		return ""
+	}
+	return `root_device="$(findmnt -no SOURCE / || true)"
+if [[ -n "$root_device" ]]; then
+  echo "clawfarm: previous shutdown was not clean; checking the recorded state of $root_device" | tee /dev/console || true
+  if ! tune2fs -l "$root_device" >/var/log/clawfarm-fsck.log 2>&1; then
+    echo "clawfarm: cannot read the filesystem state of $root_device; see /var/log/clawfarm-fsck.log" | tee /dev/console || true
+  elif grep -Eq '^Filesystem state:.*error' /var/log/clawfarm-fsck.log; then
+    echo "clawfarm: the kernel recorded errors on $root_device; boot with fsck.mode=force fsck.repair=yes to repair" | tee /dev/console || true
+  else
+    echo "clawfarm: no filesystem errors recorded on $root_device" | tee /dev/console || true
+  fi
+fi`
+}
+
+func renderWorkspaceWatchScript(workspaceWatch bool) string {
+	if !workspaceWatch {
+		return ""
+	}
+	return `install -d -m 0755 /run/clawfarm-watch
+if ! mountpoint -q /run/clawfarm-watch; then
+  mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144,ro watch /run/clawfarm-watch || true
+fi
+
+cat >/usr/local/bin/clawfarm-watch-relay.sh <<'SCRIPT'
+#!/usr/bin/env bash
+set -uo pipefail
+
+events=/run/clawfarm-watch/events
+offset=0
+while true; do
+  size="$(stat -c %s "$events" 2>/dev/null || echo 0)"
+  if (( size < offset )); then
+    offset=0
+  fi
+  if (( size > offset )); then
+    tail -c +"$((offset + 1))" "$events" | head -c "$((size - offset))" | while IFS= read -r path; do
+      target="/workspace/$path"
+      if [[ ! -e "$target" ]]; then
+        target="$(dirname "$target")"
+      fi
+      if [[ -e "$target" ]]; then
+        chmod "$(stat -c %a "$target")" "$target" 2>/dev/null || true
+      fi
+    done
+    offset="$size"
+  fi
+  sleep 0.3
+done
+SCRIPT
+chmod +x /usr/local/bin/clawfarm-watch-relay.sh
+
+cat >/etc/systemd/system/clawfarm-watch-relay.service <<'UNIT'
+[Unit]
+Description=clawfarm workspace change relay
+After=local-fs.target
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/clawfarm-watch-relay.sh
+Restart=always
+RestartSec=1
+
+[Install]
+WantedBy=multi-user.target
+UNIT
+
+systemctl daemon-reload
+systemctl enable --now clawfarm-watch-relay.service`
+}
+
+func renderStateMountScript(stateMode string) string {
+	switch stateMode {
+	case "disk":
+		return `echo disk >/etc/clawfarm/state`
+	case "none":
+		return `echo none >/etc/clawfarm/state
+if ! mountpoint -q /root/.openclaw; then
+  mount -t tmpfs -o mode=0700,nosuid,nodev tmpfs /root/.openclaw || true
+fi`
+	default:
+		return `if ! mountpoint -q /root/.openclaw; then
+  mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144 state /root/.openclaw || true
+fi`
+	}
+}
+
+func renderGuestSudoSection(sudo string) string {
+	switch sudo {
+	case GuestSudoNone:
+		return ""
+	case GuestSudoPassword:
+		return "    groups: [sudo]\n"
+	default:
+		return "    groups: [sudo]\n    sudo: [\"ALL=(ALL) NOPASSWD:ALL\"]\n"
+	}
+}
+
+func renderGuestUserScript(guestUser GuestUser) string {
+	name := guestUser.Name
+	lines := []string{
+		fmt.Sprintf("if ! id -u %s >/dev/null 2>&1; then", name),
+		fmt.Sprintf("  useradd -m -s /bin/bash %s", name),
+		"fi",
+	}
+	if guestUser.Sudo != GuestSudoNone {
+		lines = append(lines, fmt.Sprintf("usermod -aG sudo %s || true", name))
+	}
+	if guestUser.Sudo == GuestSudoNoPassword {
+		lines = append(lines,
+			fmt.Sprintf("echo %s >/etc/sudoers.d/90-clawfarm-guest-user", shellSingleQuote(guestUser.Name+" ALL=(ALL) NOPASSWD:ALL")),
+			"chmod 0440 /etc/sudoers.d/90-clawfarm-guest-user",
+		)
+	}
+	if !guestUser.PasswordLogin {
+		lines = append(lines,
+			"if [[ -d /etc/ssh/sshd_config.d ]]; then",
+			"  printf 'PasswordAuthentication no\\nKbdInteractiveAuthentication no\\n' >/etc/ssh/sshd_config.d/60-clawfarm.conf",
+			"fi",
+		)
+	}
+	lines = append(lines, fmt.Sprintf("install -d -m 0755 -o %s -g %s /claw", name, name))
+	return strings.Join(lines, "\n")
+}
+
+func renderGrowpartSection(growRootfs bool) string {
+	if !growRootfs {
+		return ""
+	}
+	return "growpart:\n  mode: auto\n  devices: [\"/\"]\nresize_rootfs: true\n"
 }
 
 func renderSSHAuthorizedKeysSection(sshAuthorizedKeys []string) string {
@@ -339,6 +764,96 @@ func renderVolumeMountScript(volumeMounts []VolumeMount) string {
 	return strings.TrimSpace(scriptBuilder.String())
 }
 
+var rootfsTarExcludes = []string{
+	"./boot", "./dev", "./proc", "./sys", "./run", "./tmp",
+	"./lib/modules", "./usr/lib/modules", "./lib/firmware", "./usr/lib/firmware",
+	"./etc/fstab", "./etc/hostname", "./etc/hosts", "./etc/resolv.conf", "./etc/machine-id",
+	"./etc/passwd", "./etc/group", "./etc/shadow", "./etc/gshadow",
+	"./etc/cloud", "./var/lib/cloud", "./etc/netplan", "./etc/ssh", "./var/lib/dpkg",
+}
+
+func renderRootfsTarScript(rootfsTarName string) string {
+	if rootfsTarName == "" {
+		return ""
+	}
+
+	excludes := make([]string, 0, len(rootfsTarExcludes))
+	for _, exclude := range rootfsTarExcludes {
+		excludes = append(excludes, "--exclude="+exclude)
+	}
+	return fmt.Sprintf(`if [[ ! -f /var/lib/clawfarm/rootfs.applied ]]; then
+  install -d -m 0755 /run/clawfarm-rootfs
+  if ! mountpoint -q /run/clawfarm-rootfs; then
+    mount -t 9p -o trans=virtio,version=9p2000.L,msize=262144,ro rootfs /run/clawfarm-rootfs
+  fi
+  tar -xpf /run/clawfarm-rootfs/%s -C / --numeric-owner --keep-directory-symlink --overwrite %s
+  umount /run/clawfarm-rootfs || true
+  install -d -m 0755 /var/lib/clawfarm
+  touch /var/lib/clawfarm/rootfs.applied
+fi`, shellSingleQuote(rootfsTarName), strings.Join(excludes, " "))
+}
+
+func renderRootfsScript(rootfsMode string) string {
+	if rootfsMode != "ro-overlay" {
+		return ""
+	}
+
+	return `echo ro-overlay >/etc/clawfarm/rootfs
+for scratch in /tmp /var/tmp; do
+  if ! mountpoint -q "$scratch"; then
+    mount -t tmpfs -o mode=1777,nosuid,nodev tmpfs "$scratch" || true
+  fi
+done`
+}
+
+func renderNetworkScript(dnsServers []string, extraHosts []HostEntry) string {
+	var scriptBuilder strings.Builder
+
+	hostLines := make([]string, 0, len(extraHosts))
+	for _, entry := range extraHosts {
+		name := strings.TrimSpace(entry.Name)
+		ip := strings.TrimSpace(entry.IP)
+		if name == "" || ip == "" {
+			continue
+		}
+		hostLines = append(hostLines, fmt.Sprintf("%s %s # clawfarm-host", ip, name))
+	}
+	if len(hostLines) > 0 {
+		scriptBuilder.WriteString("sed -i '/# clawfarm-host$/d' /etc/hosts\n")
+		scriptBuilder.WriteString("cat >>/etc/hosts <<'CLAWFARM_HOSTS'\n")
+		scriptBuilder.WriteString(strings.Join(hostLines, "\n"))
+		scriptBuilder.WriteString("\nCLAWFARM_HOSTS\n")
+	}
+
+	servers := make([]string, 0, len(dnsServers))
+	for _, server := range dnsServers {
+		trimmed := strings.TrimSpace(server)
+		if trimmed == "" {
+			continue
+		}
+		servers = append(servers, trimmed)
+	}
+	if len(servers) > 0 {
+		scriptBuilder.WriteString("if command -v resolvectl >/dev/null 2>&1; then\n")
+		scriptBuilder.WriteString("  install -d -m 0755 /etc/systemd/resolved.conf.d\n")
+		scriptBuilder.WriteString("  cat >/etc/systemd/resolved.conf.d/clawfarm.conf <<'CLAWFARM_RESOLVED'\n")
+		scriptBuilder.WriteString("[Resolve]\n")
+		scriptBuilder.WriteString("DNS=" + strings.Join(servers, " ") + "\n")
+		scriptBuilder.WriteString("CLAWFARM_RESOLVED\n")
+		scriptBuilder.WriteString("  systemctl restart systemd-resolved || true\n")
+		scriptBuilder.WriteString("else\n")
+		scriptBuilder.WriteString("  rm -f /etc/resolv.conf\n")
+		scriptBuilder.WriteString("  cat >/etc/resolv.conf <<'CLAWFARM_RESOLV'\n")
+		for _, server := range servers {
+			scriptBuilder.WriteString("nameserver " + server + "\n")
+		}
+		scriptBuilder.WriteString("CLAWFARM_RESOLV\n")
+		scriptBuilder.WriteString("fi\n")
+	}
+
+	return strings.TrimSpace(scriptBuilder.String())
+}
+
 func renderProvisionScript(commands []string) string {
 	if len(commands) == 0 {
 		return ""
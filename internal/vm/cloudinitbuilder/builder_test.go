@@ -0,0 +1,11 @@
+package cloudinitbuilder
+
+import "testing"
+
+func TestIndentForCloudConfig(t *testing.T) {
+	content := "line1\nline2\n"
+	indented := IndentForCloudConfig(content, 4)
+	if indented != "    line1\n    line2" {
+		t.Fatalf("unexpected indent result: %q", indented)
+	}
+}
@@ -0,0 +1,70 @@
+package cloudinitbuilder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CrontabPath holds the instance's scheduled jobs inside the guest.
+const CrontabPath = "/etc/cron.d/clawfarm"
+
+var (
+	cronFieldPattern    = regexp.MustCompile(`^[0-9A-Za-z*,/-]+$`)
+	cronKeywordSchedule = map[string]bool{
+		"@reboot": true, "@yearly": true, "@annually": true, "@monthly": true,
+		"@weekly": true, "@daily": true, "@midnight": true, "@hourly": true,
+	}
+)
+
+// SplitCronJob separates a job written as "<min> <hour> <dom> <mon> <dow>
+// <command>" (or "@daily <command>") into its schedule and command.
+func SplitCronJob(job string) (string, string, error) {
+	fields := strings.Fields(job)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		if !cronKeywordSchedule[fields[0]] {
+			return "", "", fmt.Errorf("invalid cron job %q: unknown schedule %s", job, fields[0])
+		}
+		if len(fields) < 2 {
+			return "", "", fmt.Errorf("invalid cron job %q: missing command", job)
+		}
+		return fields[0], strings.Join(fields[1:], " "), nil
+	}
+	if len(fields) < 6 {
+		return "", "", fmt.Errorf("invalid cron job %q: expected five schedule fields and a command", job)
+	}
+	for _, field := range fields[:5] {
+		if !cronFieldPattern.MatchString(field) {
+			return "", "", fmt.Errorf("invalid cron job %q: bad schedule field %q", job, field)
+		}
+	}
+	return strings.Join(fields[:5], " "), strings.Join(fields[5:], " "), nil
+}
+
+// RenderCrontab renders jobs as an /etc/cron.d file. Jobs run as root with the
+// OpenClaw environment loaded, from /workspace, logging to
+// /var/log/clawfarm-cron.log.
+func RenderCrontab(jobs []string) string {
+	var crontab strings.Builder
+	crontab.WriteString("# managed by clawfarm; edit with clawfarm cron add/rm\n")
+	crontab.WriteString("SHELL=/bin/bash\n")
+	crontab.WriteString("PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin\n")
+	for _, job := range jobs {
+		schedule, command, err := SplitCronJob(job)
+		if err != nil {
+			continue
+		}
+		script := "set -a; [ -f /etc/clawfarm/openclaw.env ] && . /etc/clawfarm/openclaw.env; set +a; export HOME=/root; cd /workspace && " + command
+		line := schedule + " root bash -lc " + shellSingleQuote(script) + " >>/var/log/clawfarm-cron.log 2>&1"
+		crontab.WriteString(strings.ReplaceAll(line, "%", `\%`))
+		crontab.WriteString("\n")
+	}
+	return crontab.String()
+}
+
+func renderCronScript(jobs []string) string {
+	if len(jobs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("cat >%s <<'CLAWFARM_CRONTAB'\n%sCLAWFARM_CRONTAB\nchmod 0644 %s\n", CrontabPath, RenderCrontab(jobs), CrontabPath)
+}
@@ -0,0 +1,195 @@
+package cloudinitbuilder
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// The NoCloud seed is a flat ISO9660 image with a Joliet tree so that the
+// lowercase, hyphenated names cloud-init looks for (user-data, meta-data)
+// survive. Everything lives in the root directory, which keeps the layout to
+// fixed sectors:
+//
+//	16 primary volume descriptor   21-22 Joliet path tables
+//	17 Joliet volume descriptor    23    primary root directory
+//	18 descriptor set terminator   24    Joliet root directory
+//	19-20 primary path tables      25+   file data
+const (
+	isoSectorSize       = 2048
+	isoPrimaryPathL     = 19
+	isoPrimaryPathM     = 20
+	isoJolietPathL      = 21
+	isoJolietPathM      = 22
+	isoPrimaryRoot      = 23
+	isoJolietRoot       = 24
+	isoFirstFileSector  = 25
+	isoPathTableSize    = 10
+	isoDirectoryFlagDir = 2
+)
+
+type isoFile struct {
+	Name string
+	Data []byte
+	lba  uint32
+}
+
+func writeISO9660(outputPath string, volumeID string, files []isoFile, now time.Time) error {
+	files = append([]isoFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	next := uint32(isoFirstFileSector)
+	for index := range files {
+		files[index].lba = next
+		next += isoSectors(len(files[index].Data))
+	}
+	image := make([]byte, int(next)*isoSectorSize)
+
+	writeISOVolumeDescriptor(isoSector(image, 16), 1, volumeID, next, isoPrimaryPathL, isoPrimaryPathM, isoPrimaryRoot, now)
+	writeISOVolumeDescriptor(isoSector(image, 17), 2, volumeID, next, isoJolietPathL, isoJolietPathM, isoJolietRoot, now)
+	terminator := isoSector(image, 18)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	writeISOPathTable(isoSector(image, isoPrimaryPathL), isoPrimaryRoot, binary.LittleEndian)
+	writeISOPathTable(isoSector(image, isoPrimaryPathM), isoPrimaryRoot, binary.BigEndian)
+	writeISOPathTable(isoSector(image, isoJolietPathL), isoJolietRoot, binary.LittleEndian)
+	writeISOPathTable(isoSector(image, isoJolietPathM), isoJolietRoot, binary.BigEndian)
+
+	writeISORootDirectory(isoSector(image, isoPrimaryRoot), isoPrimaryRoot, files, primaryISOName, now)
+	writeISORootDirectory(isoSector(image, isoJolietRoot), isoJolietRoot, files, jolietISOName, now)
+
+	for _, file := range files {
+		copy(image[int(file.lba)*isoSectorSize:], file.Data)
+	}
+	return os.WriteFile(outputPath, image, 0o644)
+}
+
+func writeISOVolumeDescriptor(sector []byte, kind byte, volumeID string, totalSectors uint32, pathL uint32, pathM uint32, root uint32, now time.Time) {
+	joliet := kind == 2
+	sector[0] = kind
+	copy(sector[1:], "CD001")
+	sector[6] = 1
+	if joliet {
+		fillUCS2Spaces(sector[8:40])
+		copy(sector[40:72], jolietISOName(volumeID))
+		fillUCS2Spaces(sector[40+2*len(volumeID) : 72])
+		copy(sector[88:], "%/E")
+	} else {
+		fillSpaces(sector[8:40])
+		fillSpaces(sector[40:72])
+		copy(sector[40:72], volumeID)
+	}
+	putBothEndian32(sector[80:], totalSectors)
+	putBothEndian16(sector[120:], 1)
+	putBothEndian16(sector[124:], 1)
+	putBothEndian16(sector[128:], isoSectorSize)
+	putBothEndian32(sector[132:], isoPathTableSize)
+	binary.LittleEndian.PutUint32(sector[140:], pathL)
+	binary.BigEndian.PutUint32(sector[148:], pathM)
+	writeISODirectoryRecord(sector[156:], root, isoSectorSize, isoDirectoryFlagDir, []byte{0}, now)
+	if joliet {
+		fillUCS2Spaces(sector[190:813])
+	} else {
+		fillSpaces(sector[190:813])
+	}
+	for _, offset := range []int{813, 830} {
+		copy(sector[offset:], now.UTC().Format("20060102150405")+"00")
+	}
+	for _, offset := range []int{847, 864} {
+		copy(sector[offset:], "0000000000000000")
+	}
+	sector[881] = 1
+}
+
+func writeISOPathTable(sector []byte, root uint32, order binary.ByteOrder) {
+	sector[0] = 1
+	order.PutUint32(sector[2:], root)
+	order.PutUint16(sector[6:], 1)
+}
+
+func writeISORootDirectory(sector []byte, root uint32, files []isoFile, name func(string) []byte, now time.Time) {
+	offset := writeISODirectoryRecord(sector, root, isoSectorSize, isoDirectoryFlagDir, []byte{0}, now)
+	offset += writeISODirectoryRecord(sector[offset:], root, isoSectorSize, isoDirectoryFlagDir, []byte{1}, now)
+	for _, file := range files {
+		offset += writeISODirectoryRecord(sector[offset:], file.lba, uint32(len(file.Data)), 0, name(file.Name), now)
+	}
+}
+
+func writeISODirectoryRecord(buffer []byte, lba uint32, size uint32, flags byte, name []byte, now time.Time) int {
+	length := 33 + len(name)
+	if length%2 == 1 {
+		length++
+	}
+	buffer[0] = byte(length)
+	putBothEndian32(buffer[2:], lba)
+	putBothEndian32(buffer[10:], size)
+	utc := now.UTC()
+	copy(buffer[18:25], []byte{byte(utc.Year() - 1900), byte(utc.Month()), byte(utc.Day()), byte(utc.Hour()), byte(utc.Minute()), byte(utc.Second()), 0})
+	buffer[25] = flags
+	putBothEndian16(buffer[28:], 1)
+	buffer[32] = byte(len(name))
+	copy(buffer[33:], name)
+	return length
+}
+
+// primaryISOName maps a seed file name to a level 2 ISO9660 identifier for
+// readers that ignore Joliet.
+func primaryISOName(name string) []byte {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+	if len(mapped) > 30 {
+		mapped = mapped[:30]
+	}
+	return []byte(mapped + ".;1")
+}
+
+func jolietISOName(name string) []byte {
+	encoded := utf16.Encode([]rune(name))
+	buffer := make([]byte, 2*len(encoded))
+	for index, unit := range encoded {
+		binary.BigEndian.PutUint16(buffer[2*index:], unit)
+	}
+	return buffer
+}
+
+func isoSector(image []byte, index int) []byte {
+	return image[index*isoSectorSize : (index+1)*isoSectorSize]
+}
+
+func isoSectors(size int) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+func putBothEndian16(buffer []byte, value uint16) {
+	binary.LittleEndian.PutUint16(buffer, value)
+	binary.BigEndian.PutUint16(buffer[2:], value)
+}
+
+func putBothEndian32(buffer []byte, value uint32) {
+	binary.LittleEndian.PutUint32(buffer, value)
+	binary.BigEndian.PutUint32(buffer[4:], value)
+}
+
+func fillSpaces(buffer []byte) {
+	for index := range buffer {
+		buffer[index] = ' '
+	}
+}
+
+func fillUCS2Spaces(buffer []byte) {
+	for index := 0; index+1 < len(buffer); index += 2 {
+		buffer[index], buffer[index+1] = 0, ' '
+	}
+}
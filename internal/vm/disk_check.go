@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/vm/qemuargsbuilder"
+)
+
+type DiskCheckResult struct {
+	CheckErrors        int `json:"check-errors"`
+	Corruptions        int `json:"corruptions"`
+	Leaks              int `json:"leaks"`
+	CorruptionsFixed   int `json:"corruptions-fixed"`
+	LeaksFixed         int `json:"leaks-fixed"`
+	FragmentedClusters int `json:"fragmented-clusters"`
+}
+
+func (result DiskCheckResult) Clean() bool {
+	return result.CheckErrors == 0 && result.Corruptions == 0 && result.Leaks == 0
+}
+
+func (result DiskCheckResult) Summary() string {
+	return fmt.Sprintf("%d corruption(s), %d leak(s), %d leak(s) repaired", result.Corruptions, result.Leaks, result.LeaksFixed)
+}
+
+func CheckDisk(ctx context.Context, diskPath string, keyPath string, repairLeaks bool) (DiskCheckResult, error) {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return DiskCheckResult{}, errors.New("qemu-img is required to check instance disks")
+	}
+	output, runErr := exec.CommandContext(ctx, qemuImgPath, checkDiskArgs(diskPath, keyPath, repairLeaks)...).Output()
+	result, parseErr := parseDiskCheckOutput(output)
+	if parseErr != nil {
+		if runErr != nil {
+			var exitErr *exec.ExitError
+			if errors.As(runErr, &exitErr) && len(exitErr.Stderr) > 0 {
+				return DiskCheckResult{}, fmt.Errorf("qemu-img check %s: %s", diskPath, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return DiskCheckResult{}, fmt.Errorf("qemu-img check %s: %w", diskPath, runErr)
+		}
+		return DiskCheckResult{}, fmt.Errorf("parse qemu-img check output for %s: %w", diskPath, parseErr)
+	}
+	return result, nil
+}
+
+func checkDiskArgs(diskPath string, keyPath string, repairLeaks bool) []string {
+	args := []string{"check", "--output=json"}
+	if repairLeaks {
+		args = append(args, "-r", "leaks")
+	}
+	if keyPath == "" {
+		return append(args, "-f", "qcow2", diskPath)
+	}
+	return append(args,
+		"--object", fmt.Sprintf("secret,id=%s,format=raw,file=%s", diskKeySecretID, qemuargsbuilder.EscapeOptionValue(keyPath)),
+		"--image-opts", fmt.Sprintf("driver=qcow2,file.filename=%s,encrypt.key-secret=%s", qemuargsbuilder.EscapeOptionValue(diskPath), diskKeySecretID),
+	)
+}
+
+func parseDiskCheckOutput(output []byte) (DiskCheckResult, error) {
+	var result DiskCheckResult
+	if len(strings.TrimSpace(string(output))) == 0 {
+		return result, errors.New("empty output")
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/yazhou/krunclaw/internal/vm/qemuargsbuilder"
+)
+
+const diskKeySecretID = "disk0-key"
+
+func EncryptDisk(diskPath string, keyPath string) error {
+	qemuImgPath, err := exec.LookPath("qemu-img")
+	if err != nil {
+		return errors.New("qemu-img is required to encrypt instance disks")
+	}
+	temporaryPath := diskPath + ".enc.tmp"
+	_ = os.Remove(temporaryPath)
+	command := exec.Command(qemuImgPath, encryptDiskArgs(diskPath, temporaryPath, keyPath)...)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		_ = os.Remove(temporaryPath)
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Errorf("encrypt disk %s: %s", diskPath, message)
+	}
+
+	if err := os.Rename(temporaryPath, diskPath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return err
+	}
+	return nil
+}
+
+func encryptDiskArgs(sourcePath string, destinationPath string, keyPath string) []string {
+	return []string{
+		"convert",
+		"--object", fmt.Sprintf("secret,id=%s,format=raw,file=%s", diskKeySecretID, qemuargsbuilder.EscapeOptionValue(keyPath)),
+		"-O", "qcow2",
+		"-o", "encrypt.format=luks,encrypt.key-secret=" + diskKeySecretID,
+		sourcePath,
+		destinationPath,
+	}
+}
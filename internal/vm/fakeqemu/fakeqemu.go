@@ -0,0 +1,470 @@
+// Package fakeqemu emulates the parts of qemu-system that clawfarm relies on
+// so CLI flows can be exercised end to end without virtualization.
+//
+// It honors -daemonize, -pidfile, -serial file:, -D, -qmp unix:,
+// -incoming file: and the hostfwd rules of -netdev user, answering every forwarded port with a small
+// HTTP gateway. Behavior can be scripted through environment variables:
+//
+//	CLAWFARM_FAKE_QEMU_FAIL            fail startup with this message
+//	CLAWFARM_FAKE_QEMU_BOOT_DELAY      wait this long before forwarding ports (e.g. 2s)
+//	CLAWFARM_FAKE_QEMU_GATEWAY_STATUS  HTTP status the gateway answers with (default 200)
+//	CLAWFARM_FAKE_QEMU_GATEWAY_HEADER  extra gateway response header as Name=Value
+//	CLAWFARM_FAKE_QEMU_EXIT_AFTER      exit by itself after this long, like a crashed guest
+package fakeqemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	EnvFail          = "CLAWFARM_FAKE_QEMU_FAIL"
+	EnvBootDelay     = "CLAWFARM_FAKE_QEMU_BOOT_DELAY"
+	EnvGatewayStatus = "CLAWFARM_FAKE_QEMU_GATEWAY_STATUS"
+	EnvGatewayHeader = "CLAWFARM_FAKE_QEMU_GATEWAY_HEADER"
+	EnvExitAfter     = "CLAWFARM_FAKE_QEMU_EXIT_AFTER"
+)
+
+var qmpGreeting = map[string]any{"QMP": map[string]any{
+	"version":      map[string]any{"qemu": map[string]int{"major": 8, "minor": 2, "micro": 0}, "package": "fake"},
+	"capabilities": []string{"oob"},
+}}
+
+var hostForwardPattern = regexp.MustCompile(`hostfwd=tcp:([0-9.]*):(\d+)-:(\d+)`)
+
+type options struct {
+	Daemonize     bool
+	PIDFile       string
+	MonitorPath   string
+	SerialLogPath string
+	LogPath       string
+	IncomingPath  string
+	Forwards      []forward
+}
+
+type forward struct {
+	HostAddress string
+	HostPort    int
+	GuestPort   int
+}
+
+type script struct {
+	Fail          string
+	BootDelay     time.Duration
+	GatewayStatus int
+	HeaderName    string
+	HeaderValue   string
+	ExitAfter     time.Duration
+}
+
+func Main(args []string, stderr io.Writer) int {
+	parsed, err := parseArgs(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "fake-qemu: %v\n", err)
+		return 1
+	}
+	behavior, err := loadScript()
+	if err != nil {
+		fmt.Fprintf(stderr, "fake-qemu: %v\n", err)
+		return 1
+	}
+	if behavior.Fail != "" {
+		fmt.Fprintf(stderr, "fake-qemu: %s\n", behavior.Fail)
+		return 1
+	}
+	if parsed.IncomingPath != "" {
+		if _, err := os.Stat(parsed.IncomingPath); err != nil {
+			fmt.Fprintf(stderr, "fake-qemu: incoming migration: %v\n", err)
+			return 1
+		}
+	}
+	if parsed.Daemonize {
+		if err := daemonize(args); err != nil {
+			fmt.Fprintf(stderr, "fake-qemu: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if err := run(parsed, behavior); err != nil {
+		fmt.Fprintf(stderr, "fake-qemu: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func parseArgs(args []string) (options, error) {
+	parsed := options{}
+	for index := 0; index < len(args); index++ {
+		name := args[index]
+		if name == "-daemonize" {
+			parsed.Daemonize = true
+			continue
+		}
+		if !strings.HasPrefix(name, "-") || index+1 >= len(args) {
+			continue
+		}
+		index++
+		value := args[index]
+		switch name {
+		case "-pidfile":
+			parsed.PIDFile = value
+		case "-D":
+			parsed.LogPath = value
+		case "-serial":
+			parsed.SerialLogPath = strings.TrimPrefix(value, "file:")
+		case "-incoming":
+			path, ok := strings.CutPrefix(value, "file:")
+			if !ok {
+				return options{}, fmt.Errorf("unsupported incoming migration %q", value)
+			}
+			parsed.IncomingPath = path
+		case "-qmp":
+			path, ok := strings.CutPrefix(value, "unix:")
+			if !ok {
+				return options{}, fmt.Errorf("unsupported qmp socket %q", value)
+			}
+			path, _, _ = strings.Cut(strings.ReplaceAll(path, ",,", "\x00"), ",")
+			parsed.MonitorPath = strings.ReplaceAll(path, "\x00", ",")
+		case "-netdev":
+			for _, match := range hostForwardPattern.FindAllStringSubmatch(value, -1) {
+				hostPort, _ := strconv.Atoi(match[2])
+				guestPort, _ := strconv.Atoi(match[3])
+				address := match[1]
+				if address == "" {
+					address = "127.0.0.1"
+				}
+				parsed.Forwards = append(parsed.Forwards, forward{HostAddress: address, HostPort: hostPort, GuestPort: guestPort})
+			}
+		}
+	}
+	if parsed.PIDFile == "" {
+		return options{}, errors.New("-pidfile is required")
+	}
+	return parsed, nil
+}
+
+func loadScript() (script, error) {
+	behavior := script{
+		Fail:          strings.TrimSpace(os.Getenv(EnvFail)),
+		GatewayStatus: http.StatusOK,
+	}
+	var err error
+	if behavior.BootDelay, err = durationEnv(EnvBootDelay); err != nil {
+		return script{}, err
+	}
+	if behavior.ExitAfter, err = durationEnv(EnvExitAfter); err != nil {
+		return script{}, err
+	}
+	if value := strings.TrimSpace(os.Getenv(EnvGatewayStatus)); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 100 || status > 599 {
+			return script{}, fmt.Errorf("invalid %s %q", EnvGatewayStatus, value)
+		}
+		behavior.GatewayStatus = status
+	}
+	if value := strings.TrimSpace(os.Getenv(EnvGatewayHeader)); value != "" {
+		name, headerValue, ok := strings.Cut(value, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return script{}, fmt.Errorf("invalid %s %q: expected Name=Value", EnvGatewayHeader, value)
+		}
+		behavior.HeaderName, behavior.HeaderValue = strings.TrimSpace(name), headerValue
+	}
+	return behavior, nil
+}
+
+func durationEnv(name string) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return duration, nil
+}
+
+func daemonize(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	prefix := os.Args[1 : len(os.Args)-len(args)]
+	childArgs := append([]string(nil), prefix...)
+	for _, arg := range args {
+		if arg != "-daemonize" {
+			childArgs = append(childArgs, arg)
+		}
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	command := exec.Command(executable, childArgs...)
+	command.Stdin = devNull
+	command.Stdout = devNull
+	command.Stderr = devNull
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := command.Start(); err != nil {
+		return err
+	}
+	return command.Process.Release()
+}
+
+type machine struct {
+	mu       sync.Mutex
+	resumed  *sync.Cond
+	paused   bool
+	log      io.Writer
+	shutdown chan string
+}
+
+func run(parsed options, behavior script) error {
+	logWriter := io.Discard
+	if parsed.LogPath != "" {
+		logFile, err := os.OpenFile(parsed.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		logWriter = logFile
+	}
+	guest := &machine{log: logWriter, shutdown: make(chan string, 1)}
+	guest.resumed = sync.NewCond(&guest.mu)
+
+	if parsed.MonitorPath != "" {
+		_ = os.Remove(parsed.MonitorPath)
+		listener, err := net.Listen("unix", parsed.MonitorPath)
+		if err != nil {
+			return fmt.Errorf("monitor: %w", err)
+		}
+		defer listener.Close()
+		defer os.Remove(parsed.MonitorPath)
+		go guest.serveMonitor(listener)
+	}
+
+	if err := os.WriteFile(parsed.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(parsed.PIDFile)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	servers := []*http.Server{}
+	defer func() {
+		for _, server := range servers {
+			_ = server.Close()
+		}
+	}()
+	booted := time.After(behavior.BootDelay)
+	var exited <-chan time.Time
+	if behavior.ExitAfter > 0 {
+		exited = time.After(behavior.ExitAfter)
+	}
+	fmt.Fprintf(logWriter, "fake-qemu: started pid=%d forwards=%d\n", os.Getpid(), len(parsed.Forwards))
+	if parsed.IncomingPath != "" {
+		fmt.Fprintf(logWriter, "fake-qemu: restored memory state from %s\n", parsed.IncomingPath)
+	}
+	for {
+		select {
+		case <-booted:
+			booted = nil
+			for _, mapping := range parsed.Forwards {
+				server, err := guest.serveGateway(mapping, behavior)
+				if err != nil {
+					return err
+				}
+				servers = append(servers, server)
+			}
+			appendSerial(parsed.SerialLogPath, "clawfarm-bootstrap: fake guest ready")
+		case reason := <-guest.shutdown:
+			fmt.Fprintf(logWriter, "fake-qemu: %s\n", reason)
+			return nil
+		case received := <-signals:
+			fmt.Fprintf(logWriter, "fake-qemu: terminating on signal: %s\n", received)
+			return nil
+		case <-exited:
+			fmt.Fprintln(logWriter, "fake-qemu: guest exited")
+			return nil
+		}
+	}
+}
+
+func (guest *machine) serveGateway(mapping forward, behavior script) (*http.Server, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(mapping.HostAddress, strconv.Itoa(mapping.HostPort)))
+	if err != nil {
+		return nil, fmt.Errorf("hostfwd %d: %w", mapping.HostPort, err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !guest.waitRunning(request.Context()) {
+			return
+		}
+		if behavior.HeaderName != "" {
+			writer.Header().Set(behavior.HeaderName, behavior.HeaderValue)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(behavior.GatewayStatus)
+		fmt.Fprintf(writer, "{\"status\":\"ok\",\"guest_port\":%d}\n", mapping.GuestPort)
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server, nil
+}
+
+func (guest *machine) waitRunning(ctx context.Context) bool {
+	stop := context.AfterFunc(ctx, func() {
+		guest.mu.Lock()
+		guest.resumed.Broadcast()
+		guest.mu.Unlock()
+	})
+	defer stop()
+	guest.mu.Lock()
+	defer guest.mu.Unlock()
+	for guest.paused && ctx.Err() == nil {
+		guest.resumed.Wait()
+	}
+	return ctx.Err() == nil
+}
+
+func (guest *machine) setPaused(paused bool) {
+	guest.mu.Lock()
+	guest.paused = paused
+	guest.resumed.Broadcast()
+	guest.mu.Unlock()
+}
+
+func (guest *machine) status() string {
+	guest.mu.Lock()
+	defer guest.mu.Unlock()
+	if guest.paused {
+		return "paused"
+	}
+	return "running"
+}
+
+func (guest *machine) serveMonitor(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go guest.handleMonitor(conn)
+	}
+}
+
+func (guest *machine) handleMonitor(conn net.Conn) {
+	defer conn.Close()
+	encoder := json.NewEncoder(conn)
+	_ = encoder.Encode(qmpGreeting)
+	decoder := json.NewDecoder(conn)
+	for {
+		var request struct {
+			Execute   string `json:"execute"`
+			Arguments struct {
+				CommandLine string `json:"command-line"`
+				URI         string `json:"uri"`
+				Value       int64  `json:"value"`
+			} `json:"arguments"`
+		}
+		if err := decoder.Decode(&request); err != nil {
+			return
+		}
+		fmt.Fprintf(guest.log, "fake-qemu: qmp %s\n", request.Execute)
+		var result any = struct{}{}
+		switch request.Execute {
+		case "qmp_capabilities":
+		case "query-status":
+			status := guest.status()
+			result = map[string]any{"status": status, "running": status == "running", "singlestep": false}
+		case "stop":
+			guest.setPaused(true)
+			_ = encoder.Encode(qmpEvent("STOP"))
+		case "cont":
+			guest.setPaused(false)
+			_ = encoder.Encode(qmpEvent("RESUME"))
+		case "human-monitor-command":
+			// savevm, loadvm and delvm have no state to act on here.
+			result = ""
+		case "migrate":
+			path, ok := strings.CutPrefix(request.Arguments.URI, "file:")
+			if !ok {
+				_ = encoder.Encode(map[string]any{"error": map[string]string{
+					"class": "GenericError",
+					"desc":  fmt.Sprintf("unsupported migration uri %q", request.Arguments.URI),
+				}})
+				continue
+			}
+			if err := os.WriteFile(path, []byte("fake-qemu memory state\n"), 0o644); err != nil {
+				_ = encoder.Encode(map[string]any{"error": map[string]string{"class": "GenericError", "desc": err.Error()}})
+				continue
+			}
+		case "balloon":
+			fmt.Fprintf(guest.log, "fake-qemu: balloon target %d MiB\n", request.Arguments.Value>>20)
+		case "query-migrate":
+			result = map[string]string{"status": "completed"}
+		case "migrate_cancel":
+		case "system_powerdown":
+			_ = encoder.Encode(qmpEvent("POWERDOWN"))
+			_ = encoder.Encode(map[string]any{"return": result})
+			guest.requestShutdown("powered down by monitor")
+			continue
+		case "quit":
+			_ = encoder.Encode(map[string]any{"return": result})
+			guest.requestShutdown("quit by monitor")
+			return
+		default:
+			_ = encoder.Encode(map[string]any{"error": map[string]string{
+				"class": "CommandNotFound",
+				"desc":  fmt.Sprintf("The command %s has not been found", request.Execute),
+			}})
+			continue
+		}
+		_ = encoder.Encode(map[string]any{"return": result})
+	}
+}
+
+func qmpEvent(name string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"event":     name,
+		"timestamp": map[string]int64{"seconds": now.Unix(), "microseconds": int64(now.Nanosecond() / 1000)},
+	}
+}
+
+func (guest *machine) requestShutdown(reason string) {
+	select {
+	case guest.shutdown <- reason:
+	default:
+	}
+}
+
+func appendSerial(path string, line string) {
+	if path == "" {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
@@ -0,0 +1,112 @@
+package fakeqemu
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+func TestParseArgsReadsQEMURuntimePaths(t *testing.T) {
+	parsed, err := parseArgs([]string{
+		"-machine", "q35,accel=test",
+		"-netdev", "user,id=net0,hostfwd=tcp:127.0.0.1:18789-:18789,hostfwd=tcp:127.0.0.1:2222-:22",
+		"-serial", "file:/tmp/claw/serial.log",
+		"-qmp", "unix:/tmp/a,,b/qemu-monitor.sock,server,nowait",
+		"-D", "/tmp/claw/qemu.log",
+		"-daemonize",
+		"-pidfile", "/tmp/claw/qemu.pid",
+	})
+	if err != nil {
+		t.Fatalf("parse args: %v", err)
+	}
+	if !parsed.Daemonize || parsed.PIDFile != "/tmp/claw/qemu.pid" || parsed.SerialLogPath != "/tmp/claw/serial.log" || parsed.LogPath != "/tmp/claw/qemu.log" {
+		t.Fatalf("unexpected runtime paths: %+v", parsed)
+	}
+	if parsed.MonitorPath != "/tmp/a,b/qemu-monitor.sock" {
+		t.Fatalf("expected escaped monitor path to be decoded, got %q", parsed.MonitorPath)
+	}
+	if len(parsed.Forwards) != 2 || parsed.Forwards[1] != (forward{HostAddress: "127.0.0.1", HostPort: 2222, GuestPort: 22}) {
+		t.Fatalf("unexpected forwards: %+v", parsed.Forwards)
+	}
+	if _, err := parseArgs([]string{"-m", "1024"}); err == nil {
+		t.Fatal("expected missing -pidfile to be rejected")
+	}
+}
+
+func TestQMPPausesResumesAndQuits(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fakeqemu")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	parsed := options{PIDFile: filepath.Join(dir, "qemu.pid"), MonitorPath: filepath.Join(dir, "monitor.sock")}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(parsed, script{GatewayStatus: 200})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(parsed.PIDFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pidfile was not written")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client, err := vm.DialQMP(parsed.MonitorPath, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial qmp: %v", err)
+	}
+	defer client.Close()
+	queryStatus := func() string {
+		result, err := client.Execute("query-status", nil)
+		if err != nil {
+			t.Fatalf("query-status: %v", err)
+		}
+		var status struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(result, &status); err != nil {
+			t.Fatalf("decode query-status %s: %v", result, err)
+		}
+		return status.Status
+	}
+	if status := queryStatus(); status != "running" {
+		t.Fatalf("expected running status, got %q", status)
+	}
+	if _, err := client.Execute("stop", nil); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if status := queryStatus(); status != "paused" {
+		t.Fatalf("expected paused status after stop, got %q", status)
+	}
+	if _, err := client.Execute("cont", nil); err != nil {
+		t.Fatalf("cont: %v", err)
+	}
+	if _, err := client.Execute("bogus", nil); err == nil || !strings.Contains(err.Error(), "has not been found") {
+		t.Fatalf("expected unknown command error, got %v", err)
+	}
+	if err := vm.RunQMPCommand(parsed.MonitorPath, "quit"); err != nil {
+		t.Fatalf("quit: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake qemu did not quit")
+	}
+	if _, err := os.Stat(parsed.PIDFile); !os.IsNotExist(err) {
+		t.Fatalf("expected pidfile to be removed on exit, got %v", err)
+	}
+}
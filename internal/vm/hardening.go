@@ -0,0 +1,30 @@
+package vm
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func QEMUSystemBinary(arch string) (string, error) {
+	switch arch {
+	case "amd64":
+		return "qemu-system-x86_64", nil
+	case "arm64":
+		return "qemu-system-aarch64", nil
+	default:
+		return "", fmt.Errorf("unsupported image architecture %q", arch)
+	}
+}
+
+func CheckQEMUSandbox(binaryPath string) error {
+	output, err := exec.Command(binaryPath, "-sandbox", "on", "-version").CombinedOutput()
+	if err != nil {
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Errorf("qemu seccomp sandbox unavailable: %s", message)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+type HostBackend struct {
+	qemu *QEMUBackend
+	vzf  *VFKitBackend
+	test *QEMUBackend
+}
+
+func NewHostBackend(out io.Writer) *HostBackend {
+	return &HostBackend{qemu: NewQEMUBackend(out), vzf: NewVFKitBackend(out), test: NewTestBackend(out)}
+}
+
+func ValidateBackendName(name string) error {
+	switch name {
+	case "", BackendQEMU, BackendVZF, BackendTest:
+		return nil
+	}
+	return fmt.Errorf("unsupported backend %q: expected %s, %s or %s", name, BackendQEMU, BackendVZF, BackendTest)
+}
+
+func (b *HostBackend) Start(ctx context.Context, spec StartSpec) (StartResult, error) {
+	if err := ValidateBackendName(spec.Backend); err != nil {
+		return StartResult{}, err
+	}
+	switch spec.Backend {
+	case BackendVZF:
+		return b.vzf.Start(ctx, spec)
+	case BackendTest:
+		return b.test.Start(ctx, spec)
+	}
+	return b.qemu.Start(ctx, spec)
+}
+
+func (b *HostBackend) Stop(ctx context.Context, process Process) error {
+	return b.backendFor(process).Stop(ctx, process)
+}
+
+func (b *HostBackend) Suspend(process Process) error {
+	return b.backendFor(process).Suspend(process)
+}
+
+func (b *HostBackend) Resume(process Process) error {
+	return b.backendFor(process).Resume(process)
+}
+
+func (b *HostBackend) IsRunning(pid int) bool {
+	return processExists(pid)
+}
+
+func (b *HostBackend) backendFor(process Process) Backend {
+	switch process.Backend {
+	case BackendVZF:
+		return b.vzf
+	case BackendTest:
+		return b.test
+	}
+	return b.qemu
+}
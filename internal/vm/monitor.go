@@ -0,0 +1,167 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const monitorDialTimeout = 2 * time.Second
+
+// QMPClient speaks the QEMU Machine Protocol over an instance monitor socket.
+type QMPClient struct {
+	conn    net.Conn
+	decoder *json.Decoder
+	timeout time.Duration
+}
+
+type qmpError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+type qmpMessage struct {
+	QMP    json.RawMessage `json:"QMP"`
+	Return json.RawMessage `json:"return"`
+	Error  *qmpError       `json:"error"`
+	Event  string          `json:"event"`
+}
+
+// DialQMP connects to a QMP socket and negotiates capabilities.
+func DialQMP(monitorPath string, timeout time.Duration) (*QMPClient, error) {
+	if strings.TrimSpace(monitorPath) == "" {
+		return nil, errors.New("instance has no monitor socket")
+	}
+	conn, err := net.DialTimeout("unix", monitorPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &QMPClient{conn: conn, decoder: json.NewDecoder(conn), timeout: timeout}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var greeting qmpMessage
+	if err := client.decoder.Decode(&greeting); err != nil || greeting.QMP == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s did not answer with a QMP greeting", monitorPath)
+	}
+	if _, err := client.Execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Execute runs a QMP command and returns its "return" payload. Asynchronous
+// events received while waiting are skipped.
+func (c *QMPClient) Execute(command string, arguments any) (json.RawMessage, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	request := map[string]any{"execute": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	if err := json.NewEncoder(c.conn).Encode(request); err != nil {
+		return nil, err
+	}
+	for {
+		var message qmpMessage
+		if err := c.decoder.Decode(&message); err != nil {
+			return nil, fmt.Errorf("qmp %s: %w", command, err)
+		}
+		if message.Event != "" {
+			continue
+		}
+		if message.Error != nil {
+			return nil, fmt.Errorf("qmp %s: %s", command, message.Error.Desc)
+		}
+		return message.Return, nil
+	}
+}
+
+func (c *QMPClient) Close() error {
+	return c.conn.Close()
+}
+
+// RunQMPCommand dials the monitor, runs a single argument-less command and
+// hangs up. A connection closed by "quit" counts as success.
+func RunQMPCommand(monitorPath string, command string) error {
+	client, err := DialQMP(monitorPath, monitorDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.Execute(command, nil)
+	if command == "quit" && errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// SaveMemoryState migrates the RAM and device state of a paused VM into
+// statePath with QMP migrate, for a later boot with StartSpec.IncomingStatePath.
+func SaveMemoryState(ctx context.Context, monitorPath string, statePath string) error {
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	client, err := DialQMP(monitorPath, monitorDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if _, err := client.Execute("migrate", map[string]string{"uri": "file:" + statePath}); err != nil {
+		return err
+	}
+	for {
+		result, err := client.Execute("query-migrate", nil)
+		if err != nil {
+			return err
+		}
+		var migration struct {
+			Status    string `json:"status"`
+			ErrorDesc string `json:"error-desc"`
+		}
+		if err := json.Unmarshal(result, &migration); err != nil {
+			return fmt.Errorf("qmp query-migrate: %w", err)
+		}
+		switch migration.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			_ = os.Remove(statePath)
+			return fmt.Errorf("memory snapshot %s: %s", migration.Status, migration.ErrorDesc)
+		}
+		select {
+		case <-ctx.Done():
+			_, _ = client.Execute("migrate_cancel", nil)
+			_ = os.Remove(statePath)
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// SetBalloon asks the guest's virtio balloon to shrink or regrow its usable
+// memory to memoryMiB. The balloon cannot go above the memory the VM booted
+// with.
+func SetBalloon(monitorPath string, memoryMiB int) error {
+	client, err := DialQMP(monitorPath, monitorDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.Execute("balloon", map[string]int64{"value": int64(memoryMiB) << 20})
+	return err
+}
+
+func PowerDown(monitorPath string) error {
+	return RunQMPCommand(monitorPath, "system_powerdown")
+}
@@ -2,7 +2,6 @@ package vm
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -15,6 +14,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/yazhou/krunclaw/internal/diskutil"
 	"github.com/yazhou/krunclaw/internal/vm/cloudinitbuilder"
 	"github.com/yazhou/krunclaw/internal/vm/qemuargsbuilder"
 )
@@ -22,19 +22,23 @@ import (
 const (
 	defaultCPUs      = 2
 	defaultMemoryMiB = 4096
+	qmpQuitTimeout   = 10 * time.Second
 )
 
 type QEMUBackend struct {
-	out io.Writer
+	out      io.Writer
+	fakeQEMU bool
 }
 
 type qemuPlatform struct {
-	Binary    string
-	Machine   string
-	CPU       string
-	NetDevice string
-	Accel     string
-	Firmware  string
+	Binary        string
+	Args          []string
+	Machine       string
+	CPU           string
+	NetDevice     string
+	BalloonDevice string
+	Accel         string
+	Firmware      string
 }
 
 func NewQEMUBackend(out io.Writer) *QEMUBackend {
@@ -57,15 +61,39 @@ func (b *QEMUBackend) Start(ctx context.Context, spec StartSpec) (StartResult, e
 	if strings.ContainsAny(spec.OpenClawPackage, "\n\r") {
 		return StartResult{}, errors.New("invalid OpenClaw package: contains newline")
 	}
-	if err := validatePort(spec.GatewayHostPort); err != nil {
+	if spec.OpenClawIntegrity != "" && !OpenClawIntegrityPattern.MatchString(spec.OpenClawIntegrity) {
+		return StartResult{}, fmt.Errorf("invalid OpenClaw integrity %q: expected sha512-<base64>", spec.OpenClawIntegrity)
+	}
+	if spec.OpenClawTarballPath != "" && spec.OpenClawIntegrity == "" {
+		return StartResult{}, errors.New("OpenClaw tarball requires an integrity hash")
+	}
+	if spec.OpenClawOffline && spec.OpenClawTarballPath == "" {
+		return StartResult{}, errors.New("offline OpenClaw install requires a bundled tarball")
+	}
+	if err := qemuargsbuilder.ValidatePort(spec.GatewayHostPort); err != nil {
 		return StartResult{}, fmt.Errorf("gateway host port: %w", err)
 	}
-	if err := validatePort(spec.GatewayGuestPort); err != nil {
+	if err := qemuargsbuilder.ValidatePort(spec.GatewayGuestPort); err != nil {
 		return StartResult{}, fmt.Errorf("gateway guest port: %w", err)
 	}
 	if _, _, err := buildVolumeMountSpecs(spec.VolumeMounts); err != nil {
 		return StartResult{}, err
 	}
+	if err := ValidateGuestUser(spec.GuestUser); err != nil {
+		return StartResult{}, err
+	}
+	if spec.RootfsMode != "" && spec.RootfsMode != RootfsReadWrite && spec.RootfsMode != RootfsReadOnlyOverlay {
+		return StartResult{}, fmt.Errorf("unsupported rootfs mode %q", spec.RootfsMode)
+	}
+	if spec.StateMode != "" && spec.StateMode != StateModeMount && spec.StateMode != StateModeDisk && spec.StateMode != StateModeNone {
+		return StartResult{}, fmt.Errorf("unsupported state mode %q", spec.StateMode)
+	}
+	if spec.ShareOwnership != "" && spec.ShareOwnership != ShareOwnershipPassthrough && spec.ShareOwnership != ShareOwnershipMapped {
+		return StartResult{}, fmt.Errorf("unsupported share ownership %q", spec.ShareOwnership)
+	}
+	if spec.QEMUUser != "" && os.Geteuid() != 0 {
+		return StartResult{}, fmt.Errorf("running qemu as user %s requires starting clawfarm as root", spec.QEMUUser)
+	}
 
 	if err := os.MkdirAll(spec.InstanceDir, 0o755); err != nil {
 		return StartResult{}, err
@@ -75,13 +103,14 @@ func (b *QEMUBackend) Start(ctx context.Context, spec StartSpec) (StartResult, e
 	if err != nil {
 		return StartResult{}, err
 	}
-
-	seedISO := filepath.Join(spec.InstanceDir, "seed.iso")
-	if err := createNoCloudSeedISO(spec, seedISO); err != nil {
-		return StartResult{}, err
+	if spec.UncleanShutdown && diskFormat == "qcow2" {
+		if err := checkInstanceDisk(ctx, diskPath, spec.DiskKeyPath, b.out); err != nil {
+			return StartResult{}, err
+		}
 	}
 
-	platform, err := resolveQEMUPlatform(spec.ImageArch)
+	seedISO := filepath.Join(spec.InstanceDir, "seed.iso")
+	platform, err := b.prepareBoot(spec, seedISO)
 	if err != nil {
 		return StartResult{}, err
 	}
@@ -100,6 +129,7 @@ func (b *QEMUBackend) Start(ctx context.Context, spec StartSpec) (StartResult, e
 		return StartResult{}, err
 	}
 
+	args = append(append([]string(nil), platform.Args...), args...)
 	command := exec.CommandContext(ctx, platform.Binary, args...)
 	output, err := command.CombinedOutput()
 	if err != nil {
@@ -131,7 +161,33 @@ func (b *QEMUBackend) Start(ctx context.Context, spec StartSpec) (StartResult, e
 	}, nil
 }
 
-func (b *QEMUBackend) Stop(ctx context.Context, pid int) error {
+// Stop asks QEMU to quit over QMP so it flushes its disks, and falls back to
+// SIGTERM/SIGKILL when the monitor is unreachable or QEMU does not exit.
+func (b *QEMUBackend) Stop(ctx context.Context, process Process) error {
+	if monitorReachable(process) && processExists(process.PID) {
+		if err := RunQMPCommand(process.MonitorPath, "quit"); err == nil {
+			waitForExit(ctx, process.PID, qmpQuitTimeout)
+		}
+	}
+	return terminateProcess(ctx, process.PID)
+}
+
+func waitForExit(ctx context.Context, pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processExists(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func terminateProcess(ctx context.Context, pid int) error {
 	if pid <= 0 || !processExists(pid) {
 		return nil
 	}
@@ -171,55 +227,81 @@ func (b *QEMUBackend) Stop(ctx context.Context, pid int) error {
 	return fmt.Errorf("process %d did not exit after kill", pid)
 }
 
-func (b *QEMUBackend) Suspend(pid int) error {
-	if pid <= 0 {
-		return errors.New("invalid process id")
+// Suspend pauses the guest vCPUs over the QMP socket recorded for the
+// instance. Instances started before QMP was used, or whose socket is gone,
+// fall back to signals.
+func (b *QEMUBackend) Suspend(process Process) error {
+	if err := checkProcess(process.PID); err != nil {
+		return err
 	}
-	if !processExists(pid) {
-		return fmt.Errorf("process %d is not running", pid)
+	if monitorReachable(process) {
+		return RunQMPCommand(process.MonitorPath, "stop")
+	}
+	return syscall.Kill(process.PID, syscall.SIGSTOP)
+}
+
+func (b *QEMUBackend) Resume(process Process) error {
+	if err := checkProcess(process.PID); err != nil {
+		return err
+	}
+	if monitorReachable(process) {
+		return RunQMPCommand(process.MonitorPath, "cont")
 	}
-	return syscall.Kill(pid, syscall.SIGSTOP)
+	return syscall.Kill(process.PID, syscall.SIGCONT)
 }
 
-func (b *QEMUBackend) Resume(pid int) error {
+func monitorReachable(process Process) bool {
+	if process.MonitorPath == "" {
+		return false
+	}
+	_, err := os.Stat(process.MonitorPath)
+	return err == nil
+}
+
+func checkProcess(pid int) error {
 	if pid <= 0 {
 		return errors.New("invalid process id")
 	}
 	if !processExists(pid) {
 		return fmt.Errorf("process %d is not running", pid)
 	}
-	return syscall.Kill(pid, syscall.SIGCONT)
+	return nil
 }
 
 func (b *QEMUBackend) IsRunning(pid int) bool {
 	return processExists(pid)
 }
 
+func (b *QEMUBackend) prepareBoot(spec StartSpec, seedISO string) (qemuPlatform, error) {
+	if err := newCloudInitBuilder(spec).CreateNoCloudSeedISO(seedISO); err != nil {
+		return qemuPlatform{}, err
+	}
+	if b.fakeQEMU {
+		return fakeQEMUPlatform()
+	}
+	return resolveQEMUPlatform(spec.ImageArch)
+}
+
 func resolveQEMUPlatform(imageArch string) (qemuPlatform, error) {
 	platform := qemuPlatform{}
-	hostArch := detectHostArch()
-	if hostArch == imageArch {
-		platform.Accel = "hvf"
-		platform.CPU = "host"
-	} else {
-		platform.Accel = "tcg"
-		platform.CPU = "max"
+	platform.Accel, platform.CPU = selectQEMUAccel(runtime.GOOS, detectHostArch(), imageArch, kvmAvailable())
+
+	binaryName, err := QEMUSystemBinary(imageArch)
+	if err != nil {
+		return qemuPlatform{}, err
+	}
+	binary, err := exec.LookPath(binaryName)
+	if err != nil {
+		return qemuPlatform{}, fmt.Errorf("%s is required", binaryName)
 	}
 
 	switch imageArch {
 	case "amd64":
-		binary, err := exec.LookPath("qemu-system-x86_64")
-		if err != nil {
-			return qemuPlatform{}, errors.New("qemu-system-x86_64 is required")
-		}
 		platform.Binary = binary
 		platform.Machine = "q35"
 		platform.NetDevice = "virtio-net-pci"
+		platform.BalloonDevice = "virtio-balloon-pci"
 	case "arm64":
-		binary, err := exec.LookPath("qemu-system-aarch64")
-		if err != nil {
-			return qemuPlatform{}, errors.New("qemu-system-aarch64 is required")
-		}
 		firmwarePath, err := findAArch64Firmware()
 		if err != nil {
 			return qemuPlatform{}, err
@@ -227,14 +309,49 @@ func resolveQEMUPlatform(imageArch string) (qemuPlatform, error) {
 		platform.Binary = binary
 		platform.Machine = "virt"
 		platform.NetDevice = "virtio-net-device"
+		platform.BalloonDevice = "virtio-balloon-device"
 		platform.Firmware = firmwarePath
-	default:
-		return qemuPlatform{}, fmt.Errorf("unsupported image architecture %q", imageArch)
 	}
 
 	return platform, nil
 }
 
+// selectQEMUAccel picks hardware acceleration when the guest matches the host
+// architecture: hvf on macOS, kvm on Linux when /dev/kvm is usable. Anything
+// else falls back to tcg emulation.
+func selectQEMUAccel(hostOS string, hostArch string, imageArch string, kvm bool) (string, string) {
+	if hostArch == imageArch {
+		switch {
+		case hostOS == "darwin":
+			return "hvf", "host"
+		case hostOS == "linux" && kvm:
+			return "kvm", "host"
+		}
+	}
+	return "tcg", "max"
+}
+
+var kvmDevicePath = "/dev/kvm"
+
+func kvmAvailable() bool {
+	return runtime.GOOS == "linux" && KVMStatus() == nil
+}
+
+// KVMStatus reports whether QEMU can use KVM on this host.
+func KVMStatus() error {
+	device, err := os.OpenFile(kvmDevicePath, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s not found; enable virtualization or load the kvm module", kvmDevicePath)
+		}
+		if os.IsPermission(err) {
+			return fmt.Errorf("%s is not accessible; add your user to the kvm group", kvmDevicePath)
+		}
+		return err
+	}
+	return device.Close()
+}
+
 func buildQEMUArgs(
 	spec StartSpec,
 	platform qemuPlatform,
@@ -246,45 +363,50 @@ func buildQEMUArgs(
 	pidFilePath string,
 	monitorPath string,
 ) ([]string, error) {
-	published := make([]qemuargsbuilder.PortMapping, 0, len(spec.PublishedPorts))
-	for _, mapping := range spec.PublishedPorts {
-		published = append(published, qemuargsbuilder.PortMapping{HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
-	}
 	qemuVolumeMounts, _, err := buildVolumeMountSpecs(spec.VolumeMounts)
 	if err != nil {
 		return nil, err
 	}
 
 	builder := qemuargsbuilder.NewQemuArgsBuilder().
-		WithPlatform(platform.Machine, platform.CPU, platform.Accel, platform.NetDevice, platform.Firmware).
+		WithPlatform(platform.Machine, platform.CPU, platform.Accel, platform.NetDevice, platform.BalloonDevice, platform.Firmware).
 		WithDisk(diskPath, diskFormat, seedISO).
+		WithDiskEncryption(spec.DiskKeyPath).
+		WithDiskSnapshot(spec.RootfsMode == RootfsReadOnlyOverlay).
+		WithSandbox(spec.Hardened, spec.QEMUUser).
 		WithRuntimePaths(spec.WorkspacePath, spec.StatePath, spec.ClawPath, serialLogPath, qemuLogPath, pidFilePath, monitorPath).
-		WithPorts(spec.GatewayHostPort, spec.GatewayGuestPort, published).
+		WithNoWorkspace(spec.NoWorkspace).
+		WithNoStateShare(spec.StateMode == StateModeDisk || spec.StateMode == StateModeNone).
+		WithPorts(spec.GatewayHostPort, spec.GatewayGuestPort, spec.PublishedPorts).
 		WithVolumeMounts(qemuVolumeMounts).
+		WithShareSecurityModel(shareSecurityModel(spec.ShareOwnership)).
+		WithWatchShare(spec.WatchPath).
+		WithRootfsShare(rootfsSharePath(spec.RootfsTarPath)).
+		WithOpenClawPackageShare(openClawSharePath(spec.OpenClawTarballPath)).
+		WithIncomingState(spec.IncomingStatePath).
 		WithResources(spec.CPUs, spec.MemoryMiB)
 	return builder.Build()
 }
 
-func normalizePortForwards(gatewayHostPort int, gatewayGuestPort int, published []PortMapping) ([]PortMapping, error) {
-	mappings := make([]qemuargsbuilder.PortMapping, 0, len(published))
-	for _, mapping := range published {
-		mappings = append(mappings, qemuargsbuilder.PortMapping{HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
-	}
-
-	resolved, err := qemuargsbuilder.NormalizePortForwards(gatewayHostPort, gatewayGuestPort, mappings)
-	if err != nil {
-		return nil, err
+func rootfsSharePath(rootfsTarPath string) string {
+	if rootfsTarPath == "" {
+		return ""
 	}
+	return filepath.Dir(rootfsTarPath)
+}
 
-	result := make([]PortMapping, 0, len(resolved))
-	for _, mapping := range resolved {
-		result = append(result, PortMapping{HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
+func openClawSharePath(openClawTarballPath string) string {
+	if openClawTarballPath == "" {
+		return ""
 	}
-	return result, nil
+	return filepath.Dir(openClawTarballPath)
 }
 
-func validatePort(port int) error {
-	return qemuargsbuilder.ValidatePort(port)
+func shareSecurityModel(ownership string) string {
+	if ownership == ShareOwnershipMapped {
+		return "mapped-xattr"
+	}
+	return "none"
 }
 
 func prepareInstanceDisk(sourceDiskPath string, instanceDir string, out io.Writer) (string, string, error) {
@@ -298,15 +420,7 @@ func prepareInstanceDisk(sourceDiskPath string, instanceDir string, out io.Write
 		return "", "", fmt.Errorf("source disk not found: %w", err)
 	}
 
-	format := "raw"
-	if qemuImgPath, err := exec.LookPath("qemu-img"); err == nil {
-		if detectedFormat, detectErr := detectSourceDiskFormat(qemuImgPath, absoluteSourceDiskPath); detectErr == nil {
-			format = detectedFormat
-		}
-	} else if detectedFormat, detectErr := detectDiskFormatByMagic(absoluteSourceDiskPath); detectErr == nil {
-		format = detectedFormat
-	}
-
+	format := diskutil.DetectFormat(absoluteSourceDiskPath)
 	if format != "raw" && format != "qcow2" {
 		format = "raw"
 	}
@@ -315,41 +429,17 @@ func prepareInstanceDisk(sourceDiskPath string, instanceDir string, out io.Write
 	return absoluteSourceDiskPath, format, nil
 }
 
-func detectSourceDiskFormat(qemuImgPath string, imagePath string) (string, error) {
-	command := exec.Command(qemuImgPath, "info", "--output=json", imagePath)
-	output, err := command.Output()
-	if err != nil {
-		return "", err
-	}
-
-	var payload struct {
-		Format string `json:"format"`
-	}
-	if err := json.Unmarshal(output, &payload); err != nil {
-		return "", err
-	}
-	if payload.Format == "" {
-		return "", errors.New("empty format")
-	}
-	return payload.Format, nil
-}
-
-func detectDiskFormatByMagic(imagePath string) (string, error) {
-	file, err := os.Open(imagePath)
+func checkInstanceDisk(ctx context.Context, diskPath string, keyPath string, out io.Writer) error {
+	writeLine(out, "previous shutdown was not clean; checking %s", diskPath)
+	result, err := CheckDisk(ctx, diskPath, keyPath, true)
 	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	header := make([]byte, 4)
-	if _, err := io.ReadFull(file, header); err != nil {
-		return "", err
+		return err
 	}
-
-	if string(header) == "QFI\xfb" {
-		return "qcow2", nil
+	writeLine(out, "disk check: %s", result.Summary())
+	if result.Corruptions > 0 || result.CheckErrors > 0 {
+		return fmt.Errorf("instance disk %s is corrupted (%s); restore a checkpoint with `clawfarm restore`, or inspect a copy with `qemu-img check -r all`", diskPath, result.Summary())
 	}
-	return "raw", nil
+	return nil
 }
 
 func findAArch64Firmware() (string, error) {
@@ -358,6 +448,7 @@ func findAArch64Firmware() (string, error) {
 		"/usr/local/share/qemu/edk2-aarch64-code.fd",
 		"/usr/share/qemu/edk2-aarch64-code.fd",
 		"/usr/share/qemu-efi-aarch64/QEMU_EFI.fd",
+		"/usr/share/AAVMF/AAVMF_CODE.fd",
 		"/usr/share/edk2/aarch64/QEMU_EFI.fd",
 	}
 	for _, candidate := range candidates {
@@ -368,37 +459,29 @@ func findAArch64Firmware() (string, error) {
 	return "", errors.New("aarch64 firmware is required (missing edk2-aarch64-code.fd / QEMU_EFI.fd)")
 }
 
-func createNoCloudSeedISO(spec StartSpec, outputPath string) error {
-	builder := newCloudInitBuilder(spec)
-	return builder.CreateNoCloudSeedISO(outputPath)
-}
-
-func buildCloudInitUserData(spec StartSpec) string {
-	builder := newCloudInitBuilder(spec)
-	return builder.BuildCloudInitUserData()
-}
-
-func buildBootstrapScript(spec StartSpec) string {
-	builder := newCloudInitBuilder(spec)
-	return builder.BuildBootstrapScript()
-}
-
-func indentForCloudConfig(content string, spaces int) string {
-	return cloudinitbuilder.IndentForCloudConfig(content, spaces)
-}
-
 func newCloudInitBuilder(spec StartSpec) *cloudinitbuilder.CloudInitBuilder {
 	_, cloudInitVolumeMounts, _ := buildVolumeMountSpecs(spec.VolumeMounts)
-
 	return cloudinitbuilder.NewCloudInitBuilder().
 		WithInstance(spec.InstanceID, spec.InstanceDir).
 		WithGatewayGuestPort(spec.GatewayGuestPort).
 		WithOpenClawPackage(spec.OpenClawPackage).
+		WithOpenClawPin(spec.OpenClawTarballPath, spec.OpenClawIntegrity).
+		WithOpenClawOffline(spec.OpenClawOffline).
 		WithOpenClawConfig(spec.OpenClawConfig).
 		WithOpenClawEnvironment(spec.OpenClawEnvironment).
 		WithSSHAuthorizedKeys(spec.SSHAuthorizedKeys).
+		WithGuestUser(spec.GuestUser).
 		WithVolumeMounts(cloudInitVolumeMounts).
-		WithCloudInitProvision(spec.CloudInitProvision)
+		WithNetwork(spec.DNSServers, WithHostAlias(spec.ExtraHosts)).
+		WithRootfsMode(spec.RootfsMode).
+		WithNoWorkspace(spec.NoWorkspace).
+		WithStateMode(spec.StateMode).
+		WithFsckOnBoot(spec.UncleanShutdown).
+		WithWorkspaceWatch(spec.WatchPath != "").
+		WithRootfsTar(spec.RootfsTarPath).
+		WithCloudInitProvision(spec.CloudInitProvision).
+		WithCronJobs(spec.CronJobs).
+		WithGrowRootfs(spec.DiskSizeBytes > 0)
 }
 
 func buildVolumeMountSpecs(volumeMounts []VolumeMount) ([]qemuargsbuilder.VolumeMount, []cloudinitbuilder.VolumeMount, error) {
@@ -432,13 +515,8 @@ func buildVolumeMountSpecs(volumeMounts []VolumeMount) ([]qemuargsbuilder.Volume
 func waitForPIDFile(path string, timeout time.Duration) (int, error) {
 	deadline := time.Now().Add(timeout)
 	for {
-		contents, err := os.ReadFile(path)
-		if err == nil {
-			value := strings.TrimSpace(string(contents))
-			pid, parseErr := strconv.Atoi(value)
-			if parseErr == nil && pid > 0 {
-				return pid, nil
-			}
+		if pid, err := readPIDFile(path); err == nil {
+			return pid, nil
 		}
 		if time.Now().After(deadline) {
 			return 0, fmt.Errorf("timed out waiting for qemu pid file at %s", path)
@@ -447,37 +525,19 @@ func waitForPIDFile(path string, timeout time.Duration) (int, error) {
 	}
 }
 
-func copyFile(sourcePath string, destinationPath string) error {
-	sourceFile, err := os.Open(sourcePath)
+func readPIDFile(path string) (int, error) {
+	contents, err := os.ReadFile(path)
 	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
-
-	if err := os.MkdirAll(filepath.Dir(destinationPath), 0o755); err != nil {
-		return err
+		return 0, err
 	}
-
-	temporaryPath := destinationPath + ".tmp"
-	targetFile, err := os.Create(temporaryPath)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
 	if err != nil {
-		return err
-	}
-	if _, err := io.Copy(targetFile, sourceFile); err != nil {
-		targetFile.Close()
-		_ = os.Remove(temporaryPath)
-		return err
-	}
-	if err := targetFile.Close(); err != nil {
-		_ = os.Remove(temporaryPath)
-		return err
+		return 0, err
 	}
-
-	if err := os.Rename(temporaryPath, destinationPath); err != nil {
-		_ = os.Remove(temporaryPath)
-		return err
+	if pid <= 0 {
+		return 0, fmt.Errorf("invalid pid %d in %s", pid, path)
 	}
-	return nil
+	return pid, nil
 }
 
 func detectHostArch() string {
@@ -1,59 +1,196 @@
 package vm
 
 import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/yazhou/krunclaw/internal/vm/cloudinitbuilder"
 )
 
-func TestNormalizePortForwards(t *testing.T) {
-	forwards, err := normalizePortForwards(18789, 18789, []PortMapping{{HostPort: 8080, GuestPort: 80}, {HostPort: 18789, GuestPort: 18789}})
-	if err != nil {
-		t.Fatalf("normalizePortForwards failed: %v", err)
+func TestBuildCloudInitUserData(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest", CloudInitProvision: []string{"echo setup"}}
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
+
+	for _, expected := range []string{
+		"#cloud-config",
+		"name: claw",
+		"NOPASSWD:ALL",
+		"/usr/local/bin/clawfarm-bootstrap.sh",
+		"npm install -g openclaw@latest",
+		"openclaw gateway --allow-unconfigured --port 18789",
+		"/usr/local/bin/clawfarm-provision.sh",
+	} {
+		if !strings.Contains(userData, expected) {
+			t.Fatalf("cloud-init user-data missing %q", expected)
+		}
 	}
-	if len(forwards) != 2 {
-		t.Fatalf("unexpected forward count: %d", len(forwards))
+}
+
+func TestBuildCloudInitUserDataRunsFsckOnlyAfterUncleanShutdown(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}
+	if strings.Contains(newCloudInitBuilder(spec).BuildCloudInitUserData(), "tune2fs -l") {
+		t.Fatalf("did not expect fsck after a graceful shutdown")
 	}
-	if forwards[0].HostPort != 18789 || forwards[0].GuestPort != 18789 {
-		t.Fatalf("unexpected gateway mapping: %+v", forwards[0])
+
+	spec.UncleanShutdown = true
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
+	for _, expected := range []string{"tune2fs -l", "/var/log/clawfarm-fsck.log"} {
+		if !strings.Contains(userData, expected) {
+			t.Fatalf("cloud-init user-data missing %q", expected)
+		}
 	}
-	if forwards[1].HostPort != 8080 || forwards[1].GuestPort != 80 {
-		t.Fatalf("unexpected publish mapping: %+v", forwards[1])
+	if strings.Contains(userData, "fsck -n") {
+		t.Fatalf("did not expect fsck -n on the mounted root filesystem")
 	}
 }
 
-func TestNormalizePortForwardsRejectsConflict(t *testing.T) {
-	_, err := normalizePortForwards(18789, 18789, []PortMapping{{HostPort: 8080, GuestPort: 80}, {HostPort: 8080, GuestPort: 81}})
-	if err == nil {
-		t.Fatalf("expected conflict error")
+func TestBuildCloudInitUserDataGrowsRootfsOnlyWithDiskSize(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}
+	if strings.Contains(newCloudInitBuilder(spec).BuildCloudInitUserData(), "growpart:") {
+		t.Fatalf("did not expect growpart without --disk-size")
 	}
-	if !strings.Contains(err.Error(), "duplicate host port") {
-		t.Fatalf("unexpected error: %v", err)
+
+	spec.DiskSizeBytes = 40 << 30
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
+	for _, expected := range []string{"growpart:\n  mode: auto\n  devices: [\"/\"]", "resize_rootfs: true"} {
+		if !strings.Contains(userData, expected) {
+			t.Fatalf("cloud-init user-data missing %q", expected)
+		}
 	}
 }
 
-func TestBuildCloudInitUserData(t *testing.T) {
-	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest", CloudInitProvision: []string{"echo setup"}}
-	userData := buildCloudInitUserData(spec)
+func TestBuildCloudInitUserDataInstallsWatchRelay(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}
+	if strings.Contains(newCloudInitBuilder(spec).BuildCloudInitUserData(), "clawfarm-watch-relay") {
+		t.Fatalf("did not expect watch relay without --workspace-watch")
+	}
+
+	spec.WatchPath = "/tmp/instance/watch"
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
+	for _, expected := range []string{"mount -t 9p", "watch /run/clawfarm-watch", "clawfarm-watch-relay.service"} {
+		if !strings.Contains(userData, expected) {
+			t.Fatalf("cloud-init user-data missing %q", expected)
+		}
+	}
+}
+
+func TestRootfsTarIsSharedReadOnlyAndUnpackedOnce(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}
+	if strings.Contains(newCloudInitBuilder(spec).BuildCloudInitUserData(), "clawfarm-rootfs") {
+		t.Fatalf("did not expect rootfs unpack without an OCI image")
+	}
 
+	spec.WorkspacePath = "/tmp/workspace"
+	spec.StatePath = "/tmp/state"
+	spec.GatewayHostPort = 18789
+	spec.RootfsTarPath = "/cache/images/local_oci-agent_1.0/rootfs/rootfs.tar"
+	args, err := buildQEMUArgs(spec, qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "kvm"},
+		"/tmp/disk.qcow2", "qcow2", "/tmp/seed.iso", "/tmp/serial.log", "/tmp/qemu.log", "/tmp/qemu.pid", "/tmp/qemu.sock")
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "local,path=/cache/images/local_oci-agent_1.0/rootfs,mount_tag=rootfs,security_model=none,readonly=on") {
+		t.Fatalf("expected read-only rootfs virtfs, got args: %s", joined)
+	}
+
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
 	for _, expected := range []string{
-		"#cloud-config",
-		"name: claw",
-		"NOPASSWD:ALL",
-		"/usr/local/bin/clawfarm-bootstrap.sh",
-		"npm install -g openclaw@latest",
-		"openclaw gateway --allow-unconfigured --port 18789",
-		"/usr/local/bin/clawfarm-provision.sh",
+		"if [[ ! -f /var/lib/clawfarm/rootfs.applied ]]",
+		"ro rootfs /run/clawfarm-rootfs",
+		"tar -xpf /run/clawfarm-rootfs/'rootfs.tar' -C /",
+		"--exclude=./boot",
+		"--exclude=./etc/passwd",
 	} {
 		if !strings.Contains(userData, expected) {
 			t.Fatalf("cloud-init user-data missing %q", expected)
 		}
 	}
+	if strings.Index(userData, "rootfs.applied") > strings.Index(userData, "useradd -m -s /bin/bash claw") {
+		t.Fatalf("expected rootfs to be unpacked before the claw user is created")
+	}
+}
+
+func TestPinnedOpenClawInstallVerifiesIntegrity(t *testing.T) {
+	integrity := "sha512-" + strings.Repeat("A", 86) + "=="
+	spec := StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@1.4.2", OpenClawIntegrity: integrity}
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	for _, expected := range []string{
+		"npm pack 'openclaw@1.4.2'",
+		`openssl dgst -sha512 -binary "$package_file" | base64 -w0`,
+		"!= '" + integrity + "'",
+		`npm install -g "$package_file"`,
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("bootstrap script missing %q", expected)
+		}
+	}
+	if strings.Contains(script, "npm install -g openclaw@1.4.2") {
+		t.Fatalf("did not expect an unverified registry install")
+	}
+
+	spec.OpenClawTarballPath = "/claws/claw-1/openclaw/openclaw-1.4.2.tgz"
+	spec.WorkspacePath = "/tmp/workspace"
+	spec.StatePath = "/tmp/state"
+	spec.GatewayHostPort = 18789
+	args, err := buildQEMUArgs(spec, qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "kvm"},
+		"/tmp/disk.qcow2", "qcow2", "/tmp/seed.iso", "/tmp/serial.log", "/tmp/qemu.log", "/tmp/qemu.pid", "/tmp/qemu.sock")
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "local,path=/claws/claw-1/openclaw,mount_tag=openclaw-pkg,security_model=none,readonly=on") {
+		t.Fatalf("expected read-only openclaw package virtfs, got args: %s", joined)
+	}
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "-device virtio-balloon-pci,id=balloon0") {
+		t.Fatalf("expected the platform balloon device, got args: %s", joined)
+	}
+	script = newCloudInitBuilder(spec).BuildBootstrapScript()
+	for _, expected := range []string{
+		"ro openclaw-pkg /run/clawfarm-openclaw",
+		"cp /run/clawfarm-openclaw/'openclaw-1.4.2.tgz' /tmp/clawfarm-openclaw/openclaw.tgz",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("bootstrap script missing %q", expected)
+		}
+	}
+	if strings.Contains(script, "npm pack") {
+		t.Fatalf("did not expect a registry fetch for a bundled tarball")
+	}
+}
+
+func TestOfflineOpenClawBundleSkipsNetworkWhenNodeIsPresent(t *testing.T) {
+	spec := StartSpec{
+		GatewayGuestPort:    18789,
+		OpenClawPackage:     "bundle.tgz",
+		OpenClawTarballPath: "/claws/claw-1/openclaw/bundle.tgz",
+		OpenClawIntegrity:   "sha512-" + strings.Repeat("A", 86) + "==",
+		OpenClawOffline:     true,
+	}
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	if !strings.Contains(script, "npm install -g --offline --no-audit --no-fund ./openclaw.tgz") {
+		t.Fatalf("expected offline bundle install, got:\n%s", script)
+	}
+	if !strings.Contains(script, "    if ! command -v node >/dev/null 2>&1; then\n      export DEBIAN_FRONTEND=noninteractive\n      clawfarm_retry apt-get update") {
+		t.Fatalf("expected apt-get to run only when node is missing, got:\n%s", script)
+	}
 }
 
 func TestBuildCloudInitUserDataIncludesSSHAuthorizedKeys(t *testing.T) {
 	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAITestKey clawfarm"
 	spec := StartSpec{GatewayGuestPort: 18789, SSHAuthorizedKeys: []string{key}}
-	userData := buildCloudInitUserData(spec)
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
 
 	for _, expected := range []string{
 		"ssh_authorized_keys:",
@@ -74,7 +211,7 @@ func TestBuildBootstrapScriptWithConfigAndEnv(t *testing.T) {
 		ClawPath:            "/tmp/claw",
 		CloudInitProvision:  []string{"echo setup"},
 	}
-	script := buildBootstrapScript(spec)
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
 
 	for _, expected := range []string{
 		"/etc/clawfarm/openclaw.env",
@@ -103,7 +240,7 @@ func TestBuildQEMUArgsIncludesClawVirtfs(t *testing.T) {
 			CPUs:             2,
 			MemoryMiB:        2048,
 		},
-		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", Accel: "hvf"},
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
 		"/tmp/disk.qcow2",
 		"qcow2",
 		"/tmp/seed.iso",
@@ -121,6 +258,35 @@ func TestBuildQEMUArgsIncludesClawVirtfs(t *testing.T) {
 	}
 }
 
+func TestBuildQEMUArgsMapsShareOwnership(t *testing.T) {
+	spec := StartSpec{
+		WorkspacePath:    "/tmp/workspace",
+		StatePath:        "/tmp/state",
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	build := func() string {
+		args, err := buildQEMUArgs(spec, qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"}, "/tmp/disk.qcow2", "qcow2", "/tmp/seed.iso", "/tmp/serial.log", "/tmp/qemu.log", "/tmp/qemu.pid", "/tmp/qemu.sock")
+		if err != nil {
+			t.Fatalf("buildQEMUArgs failed: %v", err)
+		}
+		return strings.Join(args, " ")
+	}
+
+	if joined := build(); !strings.Contains(joined, "mount_tag=workspace,security_model=none") {
+		t.Fatalf("expected passthrough ownership by default, got args: %s", joined)
+	}
+	spec.ShareOwnership = ShareOwnershipMapped
+	joined := build()
+	for _, expected := range []string{"mount_tag=workspace,security_model=mapped-xattr", "mount_tag=state,security_model=mapped-xattr"} {
+		if !strings.Contains(joined, expected) {
+			t.Fatalf("expected %q, got args: %s", expected, joined)
+		}
+	}
+}
+
 func TestBuildQEMUArgsIncludesVolumeVirtfs(t *testing.T) {
 	args, err := buildQEMUArgs(
 		StartSpec{
@@ -134,7 +300,7 @@ func TestBuildQEMUArgsIncludesVolumeVirtfs(t *testing.T) {
 			CPUs:      2,
 			MemoryMiB: 2048,
 		},
-		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", Accel: "hvf"},
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
 		"/tmp/disk.qcow2",
 		"qcow2",
 		"/tmp/seed.iso",
@@ -155,6 +321,253 @@ func TestBuildQEMUArgsIncludesVolumeVirtfs(t *testing.T) {
 	}
 }
 
+func TestBuildQEMUArgsIncludesDiskEncryptionSecret(t *testing.T) {
+	args, err := buildQEMUArgs(
+		StartSpec{
+			WorkspacePath:    "/tmp/workspace",
+			StatePath:        "/tmp/state",
+			DiskKeyPath:      "/tmp/instance/disk.key",
+			GatewayHostPort:  18789,
+			GatewayGuestPort: 18789,
+			CPUs:             2,
+			MemoryMiB:        2048,
+		},
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
+		"/tmp/disk.qcow2",
+		"qcow2",
+		"/tmp/seed.iso",
+		"/tmp/serial.log",
+		"/tmp/qemu.log",
+		"/tmp/qemu.pid",
+		"/tmp/qemu.sock",
+	)
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-object secret,id=disk0-key,format=raw,file=/tmp/instance/disk.key") {
+		t.Fatalf("expected disk key secret object, got args: %s", joined)
+	}
+	if !strings.Contains(joined, "file=/tmp/disk.qcow2,encrypt.format=luks,encrypt.key-secret=disk0-key") {
+		t.Fatalf("expected encrypted disk drive, got args: %s", joined)
+	}
+}
+
+func TestBuildQEMUArgsHardenedEnablesSandbox(t *testing.T) {
+	spec := StartSpec{
+		WorkspacePath:    "/tmp/workspace",
+		StatePath:        "/tmp/state",
+		Hardened:         true,
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	platform := qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"}
+	args, err := buildQEMUArgs(spec, platform, "/tmp/disk.qcow2", "qcow2", "/tmp/seed.iso", "/tmp/serial.log", "/tmp/qemu.log", "/tmp/qemu.pid", "/tmp/qemu.sock")
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-sandbox on,obsolete=deny,spawn=deny,resourcecontrol=deny,elevateprivileges=deny") {
+		t.Fatalf("expected seccomp sandbox args, got: %s", joined)
+	}
+	if strings.Contains(joined, "-runas") {
+		t.Fatalf("did not expect -runas without a qemu user: %s", joined)
+	}
+
+	spec.QEMUUser = "clawfarm-qemu"
+	args, err = buildQEMUArgs(spec, platform, "/tmp/disk.qcow2", "qcow2", "/tmp/seed.iso", "/tmp/serial.log", "/tmp/qemu.log", "/tmp/qemu.pid", "/tmp/qemu.sock")
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	joined = strings.Join(args, " ")
+	if !strings.Contains(joined, "-runas clawfarm-qemu") {
+		t.Fatalf("expected -runas for qemu user, got: %s", joined)
+	}
+	if strings.Contains(joined, "elevateprivileges=deny") {
+		t.Fatalf("expected privilege changes allowed for -runas, got: %s", joined)
+	}
+}
+
+func TestReadOnlyOverlayRootfsUsesSnapshotDiskAndTmpfs(t *testing.T) {
+	spec := StartSpec{
+		WorkspacePath:    "/tmp/workspace",
+		StatePath:        "/tmp/state",
+		RootfsMode:       RootfsReadOnlyOverlay,
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	args, err := buildQEMUArgs(
+		spec,
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
+		"/tmp/disk.qcow2",
+		"qcow2",
+		"/tmp/seed.iso",
+		"/tmp/serial.log",
+		"/tmp/qemu.log",
+		"/tmp/qemu.pid",
+		"/tmp/qemu.sock",
+	)
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "file=/tmp/disk.qcow2,snapshot=on") {
+		t.Fatalf("expected snapshot root disk, got args: %s", joined)
+	}
+
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	if !strings.Contains(script, "echo ro-overlay >/etc/clawfarm/rootfs") {
+		t.Fatalf("expected rootfs marker in bootstrap script: %s", script)
+	}
+	if !strings.Contains(script, "mount -t tmpfs") {
+		t.Fatalf("expected tmpfs scratch mounts in bootstrap script: %s", script)
+	}
+
+	spec.RootfsMode = RootfsReadWrite
+	if script := newCloudInitBuilder(spec).BuildBootstrapScript(); strings.Contains(script, "mount -t tmpfs") {
+		t.Fatalf("did not expect tmpfs mounts for rw rootfs: %s", script)
+	}
+}
+
+func TestNoWorkspaceSkipsWorkspaceShare(t *testing.T) {
+	spec := StartSpec{
+		StatePath:        "/tmp/state",
+		NoWorkspace:      true,
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	buildArgs := func(spec StartSpec) ([]string, error) {
+		return buildQEMUArgs(
+			spec,
+			qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
+			"/tmp/disk.qcow2",
+			"qcow2",
+			"/tmp/seed.iso",
+			"/tmp/serial.log",
+			"/tmp/qemu.log",
+			"/tmp/qemu.pid",
+			"/tmp/qemu.sock",
+		)
+	}
+	args, err := buildArgs(spec)
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	if joined := strings.Join(args, " "); strings.Contains(joined, "mount_tag=workspace") {
+		t.Fatalf("did not expect workspace virtfs, got args: %s", joined)
+	}
+
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	if strings.Contains(script, "workspace /workspace") {
+		t.Fatalf("did not expect workspace 9p mount in bootstrap script: %s", script)
+	}
+	if !strings.Contains(script, "echo none >/etc/clawfarm/workspace") {
+		t.Fatalf("expected workspace marker in bootstrap script: %s", script)
+	}
+
+	spec.WorkspacePath = "/tmp/workspace"
+	if _, err := buildArgs(spec); err == nil {
+		t.Fatal("expected error when a workspace path is set with the share disabled")
+	}
+	spec.NoWorkspace = false
+	spec.WorkspacePath = ""
+	if _, err := buildArgs(spec); err == nil {
+		t.Fatal("expected error when the workspace path is missing")
+	}
+}
+
+func TestStateModeControlsStateShare(t *testing.T) {
+	spec := StartSpec{
+		WorkspacePath:    "/tmp/workspace",
+		StateMode:        StateModeNone,
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	args, err := buildQEMUArgs(
+		spec,
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
+		"/tmp/disk.qcow2",
+		"qcow2",
+		"/tmp/seed.iso",
+		"/tmp/serial.log",
+		"/tmp/qemu.log",
+		"/tmp/qemu.pid",
+		"/tmp/qemu.sock",
+	)
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	if joined := strings.Join(args, " "); strings.Contains(joined, "mount_tag=state") {
+		t.Fatalf("did not expect state virtfs, got args: %s", joined)
+	}
+	if script := newCloudInitBuilder(spec).BuildBootstrapScript(); !strings.Contains(script, "mount -t tmpfs -o mode=0700,nosuid,nodev tmpfs /root/.openclaw") {
+		t.Fatalf("expected tmpfs state mount in bootstrap script: %s", script)
+	}
+
+	spec.StateMode = StateModeDisk
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	if !strings.Contains(script, "echo disk >/etc/clawfarm/state") || strings.Contains(script, "state /root/.openclaw") {
+		t.Fatalf("expected disk state without 9p mount in bootstrap script: %s", script)
+	}
+
+	spec.StateMode = StateModeMount
+	if script := newCloudInitBuilder(spec).BuildBootstrapScript(); !strings.Contains(script, "state /root/.openclaw") {
+		t.Fatalf("expected 9p state mount in bootstrap script: %s", script)
+	}
+}
+
+func TestBuildQEMUArgsEscapesCommasInPaths(t *testing.T) {
+	spec := StartSpec{
+		WorkspacePath:    "/Users/a,b/my workspace",
+		StatePath:        "/Users/a,b/state",
+		ClawPath:         "/Users/a,b/claw",
+		DiskKeyPath:      "/Users/a,b/disk.key",
+		GatewayHostPort:  18789,
+		GatewayGuestPort: 18789,
+		VolumeMounts:     []VolumeMount{{Name: "cache", HostPath: "/Users/a,b/volumes/cache", GuestPath: "/cache"}},
+		CPUs:             2,
+		MemoryMiB:        2048,
+	}
+	args, err := buildQEMUArgs(
+		spec,
+		qemuPlatform{Machine: "q35", CPU: "host", NetDevice: "virtio-net-pci", BalloonDevice: "virtio-balloon-pci", Accel: "hvf"},
+		"/Users/a,b/disk.qcow2",
+		"qcow2",
+		"/Users/a,b/seed.iso",
+		"/Users/a,b/serial.log",
+		"/Users/a,b/qemu.log",
+		"/Users/a,b/qemu.pid",
+		"/Users/a,b/qemu.sock",
+	)
+	if err != nil {
+		t.Fatalf("buildQEMUArgs failed: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	for _, expected := range []string{
+		"file=/Users/a,,b/disk.qcow2",
+		"file=/Users/a,,b/seed.iso",
+		"secret,id=disk0-key,format=raw,file=/Users/a,,b/disk.key",
+		"local,path=/Users/a,,b/my workspace,mount_tag=workspace",
+		"local,path=/Users/a,,b/state,mount_tag=state",
+		"local,path=/Users/a,,b/claw,mount_tag=claw",
+		"local,path=/Users/a,,b/volumes/cache,mount_tag=",
+		"unix:/Users/a,,b/qemu.sock,server,nowait",
+		"file:/Users/a,b/serial.log",
+		"-pidfile /Users/a,b/qemu.pid",
+	} {
+		if !strings.Contains(joined, expected) {
+			t.Fatalf("expected %q in args: %s", expected, joined)
+		}
+	}
+}
+
 func TestBuildBootstrapScriptIncludesVolumeMount(t *testing.T) {
 	spec := StartSpec{
 		GatewayGuestPort: 18789,
@@ -162,7 +575,7 @@ func TestBuildBootstrapScriptIncludesVolumeMount(t *testing.T) {
 			{Name: ".openclaw", HostPath: "/tmp/instance/volumes/.openclaw", GuestPath: "/root/.openclaw"},
 		},
 	}
-	script := buildBootstrapScript(spec)
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
 
 	for _, expected := range []string{
 		"install -d -m 0755 '/root/.openclaw'",
@@ -180,7 +593,7 @@ func TestBuildBootstrapScriptIncludesSSHBootstrap(t *testing.T) {
 		GatewayGuestPort:  18789,
 		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAITestKey clawfarm"},
 	}
-	script := buildBootstrapScript(spec)
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
 
 	for _, expected := range []string{
 		"apt-get install -y --no-install-recommends openssh-server",
@@ -193,10 +606,434 @@ func TestBuildBootstrapScriptIncludesSSHBootstrap(t *testing.T) {
 	}
 }
 
-func TestIndentForCloudConfig(t *testing.T) {
-	content := "line1\nline2\n"
-	indented := indentForCloudConfig(content, 4)
-	if indented != "    line1\n    line2" {
-		t.Fatalf("unexpected indent result: %q", indented)
+func TestBuildBootstrapScriptIncludesHostsAndDNS(t *testing.T) {
+	spec := StartSpec{
+		GatewayGuestPort: 18789,
+		DNSServers:       []string{"10.0.0.2"},
+		ExtraHosts:       []HostEntry{{Name: "db.internal", IP: "10.0.0.5"}},
+	}
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+
+	for _, expected := range []string{
+		"10.0.2.2 host.clawfarm.internal # clawfarm-host",
+		"10.0.0.5 db.internal # clawfarm-host",
+		"DNS=10.0.0.2",
+		"nameserver 10.0.0.2",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("bootstrap script missing %q", expected)
+		}
+	}
+
+	spec.ExtraHosts = []HostEntry{{Name: HostAliasName, IP: "192.168.64.1"}}
+	script = newCloudInitBuilder(spec).BuildBootstrapScript()
+	if !strings.Contains(script, "192.168.64.1 host.clawfarm.internal # clawfarm-host") || strings.Contains(script, "10.0.2.2 host.clawfarm.internal") {
+		t.Fatalf("expected --add-host to override the host alias, got:\n%s", script)
+	}
+}
+
+func TestParseHostMemoryAndShortfall(t *testing.T) {
+	vmStat := "Mach Virtual Memory Statistics: (page size of 16384 bytes)\nPages free:                               65536.\nPages active:                            99999.\nPages inactive:                          32768.\nPages speculative:                           0.\n"
+	memory, err := parseVMStat(vmStat)
+	if err != nil {
+		t.Fatalf("parseVMStat failed: %v", err)
+	}
+	if memory != 1536 {
+		t.Fatalf("unexpected vm_stat memory: %d", memory)
+	}
+
+	available, err := parseMemAvailable(bufio.NewScanner(strings.NewReader("MemTotal: 8000000 kB\nMemAvailable: 2097152 kB\n")))
+	if err != nil {
+		t.Fatalf("parseMemAvailable failed: %v", err)
+	}
+	if available != 2048 {
+		t.Fatalf("unexpected MemAvailable: %d", available)
+	}
+
+	resources := HostResources{AvailableMemoryMiB: available, FreeDiskMiB: 512}
+	shortfall := resources.Shortfall(ResourceRequest{MemoryMiB: 4096, DiskPath: "/data"})
+	if shortfall == nil {
+		t.Fatal("expected shortfall for 4096 MiB on a 2048 MiB host")
+	}
+	for _, expected := range []string{"need 4352 MiB", "2048 MiB available", "need 1024 MiB free under /data"} {
+		if !strings.Contains(shortfall.Error(), expected) {
+			t.Fatalf("expected %q in %v", expected, shortfall)
+		}
+	}
+	if err := (HostResources{AvailableMemoryMiB: 8192, FreeDiskMiB: 4096}).Shortfall(ResourceRequest{MemoryMiB: 4096}); err != nil {
+		t.Fatalf("unexpected shortfall: %v", err)
+	}
+}
+
+func TestCheckDiskArgsAndOutput(t *testing.T) {
+	args := strings.Join(checkDiskArgs("/data/claw,1/instance.img", "", true), " ")
+	if args != "check --output=json -r leaks -f qcow2 /data/claw,1/instance.img" {
+		t.Fatalf("unexpected plain check args: %s", args)
+	}
+	args = strings.Join(checkDiskArgs("/data/claw,1/instance.img", "/data/disk.key", false), " ")
+	for _, expected := range []string{
+		"--object secret,id=disk0-key,format=raw,file=/data/disk.key",
+		"--image-opts driver=qcow2,file.filename=/data/claw,,1/instance.img,encrypt.key-secret=disk0-key",
+	} {
+		if !strings.Contains(args, expected) {
+			t.Fatalf("expected %q in encrypted check args: %s", expected, args)
+		}
+	}
+	if strings.Contains(args, "-r leaks") {
+		t.Fatalf("unexpected repair flag: %s", args)
+	}
+
+	result, err := parseDiskCheckOutput([]byte(`{"image-end-offset": 262144, "total-clusters": 16384, "check-errors": 0, "leaks": 0, "leaks-fixed": 3, "corruptions": 2, "filename": "instance.img", "format": "qcow2"}`))
+	if err != nil {
+		t.Fatalf("parseDiskCheckOutput failed: %v", err)
+	}
+	if result.Clean() || result.Corruptions != 2 || result.LeaksFixed != 3 {
+		t.Fatalf("unexpected check result: %+v", result)
+	}
+	if result.Summary() != "2 corruption(s), 0 leak(s), 3 leak(s) repaired" {
+		t.Fatalf("unexpected summary: %s", result.Summary())
+	}
+	if _, err := parseDiskCheckOutput(nil); err == nil {
+		t.Fatal("expected empty output to fail")
+	}
+}
+
+func TestCheckHTTPReadinessMatchesPathStatusAndJSONFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/healthz":
+			if request.Header.Get("Authorization") != "Bearer secret" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = writer.Write([]byte(`{"status":"ok","model":{"loaded":true,"name":"gpt-5"},"uptime":12}`))
+		default:
+			_, _ = writer.Write([]byte("<html>fallback</html>"))
+		}
+	}))
+	defer server.Close()
+
+	readiness := HTTPReadiness{Path: "healthz", Status: http.StatusOK, JSON: map[string]string{"status": "ok", "model.loaded": "true", "model.name": "*", "uptime": "12"}, BearerToken: "secret"}
+	if target := readiness.URL(server.URL + "/"); target != server.URL+"/healthz" {
+		t.Fatalf("unexpected readiness url %s", target)
+	}
+	if err := CheckHTTPReadiness(readiness.URL(server.URL), readiness, time.Second); err != nil {
+		t.Fatalf("expected readiness to pass: %v", err)
+	}
+
+	unauthorized := readiness
+	unauthorized.BearerToken = ""
+	if err := CheckHTTPReadiness(readiness.URL(server.URL), unauthorized, time.Second); err == nil || !strings.Contains(err.Error(), "expected HTTP 200, got 401") {
+		t.Fatalf("expected status mismatch, got %v", err)
+	}
+	if err := CheckHTTPReadiness(server.URL, HTTPReadiness{JSON: map[string]string{"status": "ok"}}, time.Second); err == nil || !strings.Contains(err.Error(), "not JSON") {
+		t.Fatalf("expected fallback page to fail the json matcher, got %v", err)
+	}
+	missing := HTTPReadiness{JSON: map[string]string{"model.version": "*"}, BearerToken: "secret"}
+	if err := CheckHTTPReadiness(server.URL+"/healthz", missing, time.Second); err == nil || !strings.Contains(err.Error(), "json field model.version is missing") {
+		t.Fatalf("expected missing field error, got %v", err)
+	}
+	if err := CheckHTTPReadiness(server.URL, HTTPReadiness{}, time.Second); err != nil {
+		t.Fatalf("expected any response to count without matchers: %v", err)
+	}
+}
+
+func TestFallbackGatewayIsMarkedAndNotReady(t *testing.T) {
+	script := newCloudInitBuilder(StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}).BuildBootstrapScript()
+	if !strings.Contains(script, `self.send_header("X-Clawfarm-Gateway", gateway_marker())`) || strings.Contains(script, "-m http.server") {
+		t.Fatalf("expected fallback server to send the gateway marker, got:\n%s", script)
+	}
+	for _, marker := range []string{"clawfarm_install_phase apt", "clawfarm_install_phase node", "clawfarm_install_phase npm", "clawfarm_install_phase done", `clawfarm_install_phase failed "$(grep`, "/root/.openclaw/clawfarm-install.status"} {
+		if !strings.Contains(script, marker) {
+			t.Fatalf("expected bootstrap to record install phase %q, got:\n%s", marker, script)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set(GatewayMarkerHeader, GatewayMarkerFallback)
+		_, _ = writer.Write([]byte("<html>workspace</html>"))
+	}))
+	defer server.Close()
+
+	if err := CheckHTTPReadiness(server.URL, HTTPReadiness{}, time.Second); !errors.Is(err, ErrGatewayInstalling) {
+		t.Fatalf("expected fallback gateway to report installing, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := WaitForHTTP(ctx, server.URL); err == nil || !strings.Contains(err.Error(), "still installing") {
+		t.Fatalf("expected wait to time out while installing, got %v", err)
+	}
+}
+
+func TestInstallFailureRetriesAlternateNodeSourcesAndStopsWaiting(t *testing.T) {
+	script := newCloudInitBuilder(StartSpec{GatewayGuestPort: 18789, OpenClawPackage: "openclaw@latest"}).BuildBootstrapScript()
+	for _, marker := range []string{"clawfarm_retry()", "clawfarm_install_node()", "--no-install-recommends nodejs npm", "https://nodejs.org/dist/latest-v22.x", "SHASUMS256.txt", `"install-failed"`} {
+		if !strings.Contains(script, marker) {
+			t.Fatalf("expected bootstrap to contain %q, got:\n%s", marker, script)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set(GatewayMarkerHeader, GatewayMarkerInstallFailed)
+		_, _ = writer.Write([]byte("<html>workspace</html>"))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := WaitForHTTPReady(ctx, server.URL, HTTPReadiness{}); !errors.Is(err, ErrGatewayInstallFailed) {
+		t.Fatalf("expected install-failed to end the wait, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected install-failed to return immediately, took %s", elapsed)
+	}
+}
+
+func TestCloudInitRendersConfiguredGuestUser(t *testing.T) {
+	spec := StartSpec{
+		GatewayGuestPort:  18789,
+		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAATEST dev@laptop"},
+		GuestUser:         GuestUser{Name: "dev", Sudo: GuestSudoNone},
+	}
+	userData := newCloudInitBuilder(spec).BuildCloudInitUserData()
+	for _, expected := range []string{"ssh_pwauth: false", "  - name: dev\n", "lock_passwd: true", "useradd -m -s /bin/bash dev", "install -d -m 0755 -o dev -g dev /claw", "chown -R dev:dev /claw", "PasswordAuthentication no"} {
+		if !strings.Contains(userData, expected) {
+			t.Fatalf("cloud-init user-data missing %q:\n%s", expected, userData)
+		}
+	}
+	for _, unexpected := range []string{"NOPASSWD", "groups: [sudo]", "usermod -aG sudo", "name: claw"} {
+		if strings.Contains(userData, unexpected) {
+			t.Fatalf("cloud-init user-data should not contain %q with sudo none:\n%s", unexpected, userData)
+		}
+	}
+
+	spec.GuestUser = GuestUser{Name: "dev", Sudo: GuestSudoPassword, PasswordLogin: true}
+	userData = newCloudInitBuilder(spec).BuildCloudInitUserData()
+	if !strings.Contains(userData, "ssh_pwauth: true") || !strings.Contains(userData, "lock_passwd: false") || !strings.Contains(userData, "groups: [sudo]") || strings.Contains(userData, "NOPASSWD") {
+		t.Fatalf("expected password sudo and password login, got:\n%s", userData)
+	}
+	if err := ValidateGuestUser(GuestUser{Name: "Dev User"}); err == nil {
+		t.Fatal("expected invalid guest user name to be rejected")
+	}
+}
+
+func TestSelectQEMUAccelUsesKVMOnLinux(t *testing.T) {
+	cases := []struct {
+		hostOS, hostArch, imageArch string
+		kvm                         bool
+		accel, cpu                  string
+	}{
+		{"darwin", "arm64", "arm64", false, "hvf", "host"},
+		{"linux", "amd64", "amd64", true, "kvm", "host"},
+		{"linux", "amd64", "amd64", false, "tcg", "max"},
+		{"linux", "amd64", "arm64", true, "tcg", "max"},
+		{"darwin", "arm64", "amd64", false, "tcg", "max"},
+	}
+	for _, tc := range cases {
+		accel, cpu := selectQEMUAccel(tc.hostOS, tc.hostArch, tc.imageArch, tc.kvm)
+		if accel != tc.accel || cpu != tc.cpu {
+			t.Fatalf("%s/%s guest %s kvm=%t: got %s/%s, want %s/%s", tc.hostOS, tc.hostArch, tc.imageArch, tc.kvm, accel, cpu, tc.accel, tc.cpu)
+		}
+	}
+}
+
+func TestCreateNoCloudSeedISOWritesJolietSeedWithoutExternalTools(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	instanceDir := t.TempDir()
+	spec := StartSpec{InstanceID: "claw-1", InstanceDir: instanceDir, GatewayGuestPort: 18789}
+	seedISO := filepath.Join(instanceDir, "seed.iso")
+	if err := newCloudInitBuilder(spec).CreateNoCloudSeedISO(seedISO); err != nil {
+		t.Fatalf("create seed iso: %v", err)
+	}
+	image, err := os.ReadFile(seedISO)
+	if err != nil {
+		t.Fatalf("read seed iso: %v", err)
+	}
+	sector := func(index uint32) []byte { return image[index*2048 : (index+1)*2048] }
+
+	primary, joliet := sector(16), sector(17)
+	if string(primary[1:6]) != "CD001" || strings.TrimSpace(string(primary[40:72])) != "cidata" {
+		t.Fatalf("unexpected primary volume descriptor %q", primary[:72])
+	}
+	if joliet[0] != 2 || string(joliet[88:91]) != "%/E" {
+		t.Fatalf("expected a Joliet supplementary volume descriptor, got %q", joliet[:91])
+	}
+
+	found := map[string]string{}
+	root := sector(binary.LittleEndian.Uint32(joliet[156+2:]))
+	for offset := 0; offset < len(root) && root[offset] != 0; offset += int(root[offset]) {
+		record := root[offset:]
+		nameLength := int(record[32])
+		if nameLength == 1 {
+			continue
+		}
+		units := make([]uint16, nameLength/2)
+		for index := range units {
+			units[index] = binary.BigEndian.Uint16(record[33+2*index:])
+		}
+		lba, size := binary.LittleEndian.Uint32(record[2:]), binary.LittleEndian.Uint32(record[10:])
+		found[string(utf16.Decode(units))] = string(image[lba*2048 : lba*2048+size])
+	}
+	for _, name := range []string{"meta-data", "user-data"} {
+		expected, err := os.ReadFile(filepath.Join(instanceDir, "seed", name))
+		if err != nil {
+			t.Fatalf("read seed %s: %v", name, err)
+		}
+		if found[name] != string(expected) {
+			t.Fatalf("seed iso %s does not match seed dir: %q", name, found[name])
+		}
+	}
+}
+
+func TestQEMUBackendSuspendsAndResumesOverQMP(t *testing.T) {
+	socketDir, err := os.MkdirTemp("", "clawqmp")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(socketDir)
+	monitorPath := filepath.Join(socketDir, "qmp.sock")
+	listener, err := net.Listen("unix", monitorPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			encoder, decoder := json.NewEncoder(conn), json.NewDecoder(conn)
+			_ = encoder.Encode(map[string]any{"QMP": map[string]any{}})
+			for {
+				var request struct {
+					Execute string `json:"execute"`
+				}
+				if decoder.Decode(&request) != nil {
+					break
+				}
+				if request.Execute != "qmp_capabilities" {
+					commands <- request.Execute
+					_ = encoder.Encode(map[string]any{"event": "STOP"})
+				}
+				_ = encoder.Encode(map[string]any{"return": map[string]any{}})
+			}
+			conn.Close()
+		}
+	}()
+
+	backend := &QEMUBackend{out: io.Discard}
+	process := Process{PID: os.Getpid(), MonitorPath: monitorPath}
+	if err := backend.Suspend(process); err != nil {
+		t.Fatalf("suspend: %v", err)
+	}
+	if err := backend.Resume(process); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	for _, expected := range []string{"stop", "cont"} {
+		if command := <-commands; command != expected {
+			t.Fatalf("expected QMP %s, got %s", expected, command)
+		}
+	}
+
+	if err := os.Remove(monitorPath); err != nil {
+		t.Fatalf("remove socket: %v", err)
+	}
+	if monitorReachable(process) {
+		t.Fatal("expected a missing QMP socket to fall back to signals")
+	}
+}
+
+func TestBootstrapScriptInstallsCronJobs(t *testing.T) {
+	spec := StartSpec{GatewayGuestPort: 18789, CronJobs: []string{"@hourly openclaw run reindex --since '1 hour'"}}
+	script := newCloudInitBuilder(spec).BuildBootstrapScript()
+	for _, expected := range []string{
+		"cat >/etc/cron.d/clawfarm <<'CLAWFARM_CRONTAB'",
+		"@hourly root bash -lc 'set -a;",
+		`openclaw run reindex --since '"'"'1 hour'"'"''`,
+		"chmod 0644 /etc/cron.d/clawfarm",
+	} {
+		if !strings.Contains(script, expected) {
+			t.Fatalf("expected bootstrap script to contain %q, got:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(newCloudInitBuilder(StartSpec{GatewayGuestPort: 18789}).BuildBootstrapScript(), "/etc/cron.d/clawfarm") {
+		t.Fatal("expected no crontab without cron jobs")
+	}
+}
+
+func TestBuildVFKitArgsSharesDirectoriesOverVirtiofs(t *testing.T) {
+	spec := StartSpec{
+		Backend:         BackendVZF,
+		GatewayHostPort: 18789,
+		WorkspacePath:   "/host/workspace",
+		StatePath:       "/host/state",
+		StateMode:       StateModeMount,
+		ClawPath:        "/host/claw",
+		VolumeMounts:    []VolumeMount{{HostPath: "/host/cache", GuestPath: "/cache"}},
+		PublishedPorts:  []PortMapping{{HostPort: 2222, GuestPort: 22}},
+		OpenClawPackage: "openclaw@latest",
+	}
+	if err := validateVFKitSpec(&spec); err != nil {
+		t.Fatalf("validate spec: %v", err)
+	}
+	if spec.ExtraHosts[0].Name != HostAliasName || spec.ExtraHosts[0].IP != GVProxyHostIP {
+		t.Fatalf("expected host alias to point at gvproxy, got %+v", spec.ExtraHosts)
+	}
+
+	paths := newVFKitPaths("/instance")
+	args, err := buildVFKitArgs(spec, "/instance/rootfs.raw", "/instance/seed.iso", paths)
+	if err != nil {
+		t.Fatalf("build args: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	for _, expected := range []string{
+		"--cpus 2 --memory 4096",
+		"--bootloader efi,variable-store=/instance/efi-variables.fd,create",
+		"--restful-uri unix:///instance/vfkit-rest.sock",
+		"virtio-blk,path=/instance/rootfs.raw",
+		"virtio-net,unixSocketPath=/instance/gvproxy-net.sock,mac=" + gvproxyGuestMAC,
+		"virtio-fs,sharedDir=/host/workspace,mountTag=workspace",
+		"virtio-fs,sharedDir=/host/state,mountTag=state",
+		"virtio-fs,sharedDir=/host/claw,mountTag=claw",
+		"virtio-fs,sharedDir=/host/cache,mountTag=volume1",
+	} {
+		if !strings.Contains(joined, expected) {
+			t.Fatalf("expected %q in vfkit args, got %s", expected, joined)
+		}
+	}
+	forwards := vfkitPortForwards(spec)
+	if len(forwards) != 2 || forwards[0] != (PortMapping{HostPort: 18789, GuestPort: 18789}) || forwards[1].GuestPort != 22 {
+		t.Fatalf("unexpected port forwards: %+v", forwards)
+	}
+
+	script := newCloudInitBuilder(spec).WithShareFilesystem(cloudinitbuilder.ShareFilesystemVirtiofs).BuildBootstrapScript()
+	if strings.Contains(script, "mount -t 9p") || !strings.Contains(script, "mount -t virtiofs workspace /workspace") {
+		t.Fatalf("expected virtiofs mounts in bootstrap, got:\n%s", script)
+	}
+
+	for _, invalid := range []StartSpec{
+		{GatewayHostPort: 18789, DiskKeyPath: "/key"},
+		{GatewayHostPort: 18789, Hardened: true},
+		{GatewayHostPort: 18789, RootfsMode: RootfsReadOnlyOverlay},
+	} {
+		if err := validateVFKitSpec(&invalid); err == nil || !strings.Contains(err.Error(), "vzf does not support") {
+			t.Fatalf("expected unsupported option error for %+v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestHostBackendRoutesProcessesByRecordedBackend(t *testing.T) {
+	backend := NewHostBackend(nil)
+	if _, ok := backend.backendFor(Process{PID: 424242, Backend: BackendVZF}).(*VFKitBackend); !ok {
+		t.Fatal("expected a vzf process to route to the vfkit backend")
+	}
+	if _, ok := backend.backendFor(Process{PID: 424243}).(*QEMUBackend); !ok {
+		t.Fatal("expected a process without a backend to route to the qemu backend")
+	}
+	if _, err := backend.Start(context.Background(), StartSpec{Backend: "firecracker"}); err == nil || !strings.Contains(err.Error(), "unsupported backend") {
+		t.Fatalf("expected unsupported backend error, got %v", err)
 	}
 }
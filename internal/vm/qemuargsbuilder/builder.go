@@ -18,38 +18,51 @@ type VolumeMount struct {
 }
 
 type QemuArgsBuilder struct {
-	Machine          string
-	CPU              string
-	Accel            string
-	NetDevice        string
-	Firmware         string
-	DiskPath         string
-	DiskFormat       string
-	SeedISOPath      string
-	WorkspacePath    string
-	StatePath        string
-	ClawPath         string
-	SerialLogPath    string
-	QEMULogPath      string
-	PIDFilePath      string
-	MonitorPath      string
-	GatewayHostPort  int
-	GatewayGuestPort int
-	PublishedPorts   []PortMapping
-	VolumeMounts     []VolumeMount
-	CPUs             int
-	MemoryMiB        int
+	Machine           string
+	CPU               string
+	Accel             string
+	NetDevice         string
+	BalloonDevice     string
+	Firmware          string
+	DiskPath          string
+	DiskFormat        string
+	DiskKeyPath       string
+	DiskSnapshot      bool
+	Sandbox           bool
+	RunAsUser         string
+	SeedISOPath       string
+	WorkspacePath     string
+	NoWorkspace       bool
+	StatePath         string
+	NoStateShare      bool
+	ClawPath          string
+	WatchPath         string
+	RootfsSharePath   string
+	OpenClawSharePath string
+	SerialLogPath     string
+	QEMULogPath       string
+	PIDFilePath       string
+	MonitorPath       string
+	IncomingStatePath string
+	GatewayHostPort   int
+	GatewayGuestPort  int
+	PublishedPorts    []PortMapping
+	VolumeMounts      []VolumeMount
+	SecurityModel     string
+	CPUs              int
+	MemoryMiB         int
 }
 
 func NewQemuArgsBuilder() *QemuArgsBuilder {
 	return &QemuArgsBuilder{}
 }
 
-func (builder *QemuArgsBuilder) WithPlatform(machine string, cpu string, accel string, netDevice string, firmware string) *QemuArgsBuilder {
+func (builder *QemuArgsBuilder) WithPlatform(machine string, cpu string, accel string, netDevice string, balloonDevice string, firmware string) *QemuArgsBuilder {
 	builder.Machine = machine
 	builder.CPU = cpu
 	builder.Accel = accel
 	builder.NetDevice = netDevice
+	builder.BalloonDevice = balloonDevice
 	builder.Firmware = firmware
 	return builder
 }
@@ -61,6 +74,22 @@ func (builder *QemuArgsBuilder) WithDisk(diskPath string, diskFormat string, see
 	return builder
 }
 
+func (builder *QemuArgsBuilder) WithDiskEncryption(keyPath string) *QemuArgsBuilder {
+	builder.DiskKeyPath = keyPath
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithDiskSnapshot(snapshot bool) *QemuArgsBuilder {
+	builder.DiskSnapshot = snapshot
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithSandbox(enabled bool, runAsUser string) *QemuArgsBuilder {
+	builder.Sandbox = enabled
+	builder.RunAsUser = runAsUser
+	return builder
+}
+
 func (builder *QemuArgsBuilder) WithRuntimePaths(
 	workspacePath string,
 	statePath string,
@@ -80,6 +109,16 @@ func (builder *QemuArgsBuilder) WithRuntimePaths(
 	return builder
 }
 
+func (builder *QemuArgsBuilder) WithNoWorkspace(noWorkspace bool) *QemuArgsBuilder {
+	builder.NoWorkspace = noWorkspace
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithNoStateShare(noStateShare bool) *QemuArgsBuilder {
+	builder.NoStateShare = noStateShare
+	return builder
+}
+
 func (builder *QemuArgsBuilder) WithPorts(gatewayHostPort int, gatewayGuestPort int, published []PortMapping) *QemuArgsBuilder {
 	builder.GatewayHostPort = gatewayHostPort
 	builder.GatewayGuestPort = gatewayGuestPort
@@ -98,27 +137,52 @@ func (builder *QemuArgsBuilder) WithVolumeMounts(volumeMounts []VolumeMount) *Qe
 	return builder
 }
 
+func (builder *QemuArgsBuilder) WithWatchShare(watchPath string) *QemuArgsBuilder {
+	builder.WatchPath = watchPath
+	return builder
+}
+
+// WithIncomingState boots from a memory state saved by a QMP migrate to file
+// instead of cold-booting the disk.
+func (builder *QemuArgsBuilder) WithIncomingState(statePath string) *QemuArgsBuilder {
+	builder.IncomingStatePath = statePath
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithOpenClawPackageShare(openClawSharePath string) *QemuArgsBuilder {
+	builder.OpenClawSharePath = openClawSharePath
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithRootfsShare(rootfsSharePath string) *QemuArgsBuilder {
+	builder.RootfsSharePath = rootfsSharePath
+	return builder
+}
+
+func (builder *QemuArgsBuilder) WithShareSecurityModel(securityModel string) *QemuArgsBuilder {
+	builder.SecurityModel = securityModel
+	return builder
+}
+
 func (builder *QemuArgsBuilder) Build() ([]string, error) {
-	paths := []string{
-		builder.DiskPath,
-		builder.SeedISOPath,
-		builder.WorkspacePath,
-		builder.StatePath,
-		builder.SerialLogPath,
-		builder.QEMULogPath,
-		builder.PIDFilePath,
-		builder.MonitorPath,
+	securityModel := builder.SecurityModel
+	if securityModel == "" {
+		securityModel = "none"
 	}
-	if builder.Firmware != "" {
-		paths = append(paths, builder.Firmware)
+	if securityModel != "none" && securityModel != "mapped-xattr" && securityModel != "mapped-file" {
+		return nil, fmt.Errorf("unsupported 9p security model %q", securityModel)
 	}
-	for _, mount := range builder.VolumeMounts {
-		paths = append(paths, mount.HostPath)
+	if builder.NoWorkspace && builder.WorkspacePath != "" {
+		return nil, fmt.Errorf("workspace path %s must be empty when the workspace share is disabled", builder.WorkspacePath)
 	}
-	for _, path := range paths {
-		if strings.Contains(path, ",") {
-			return nil, fmt.Errorf("path contains unsupported comma: %s", path)
-		}
+	if !builder.NoWorkspace && strings.TrimSpace(builder.WorkspacePath) == "" {
+		return nil, errors.New("workspace path is required unless the workspace share is disabled")
+	}
+	if builder.NoStateShare && builder.StatePath != "" {
+		return nil, fmt.Errorf("state path %s must be empty when the state share is disabled", builder.StatePath)
+	}
+	if !builder.NoStateShare && strings.TrimSpace(builder.StatePath) == "" {
+		return nil, errors.New("state path is required unless the state share is disabled")
 	}
 
 	for _, mount := range builder.VolumeMounts {
@@ -151,17 +215,50 @@ func (builder *QemuArgsBuilder) Build() ([]string, error) {
 		args = append(args, "-bios", builder.Firmware)
 	}
 
+	if builder.Sandbox {
+		sandbox := "on,obsolete=deny,spawn=deny,resourcecontrol=deny"
+		if builder.RunAsUser == "" {
+			sandbox += ",elevateprivileges=deny"
+		}
+		args = append(args, "-sandbox", sandbox)
+	}
+	if builder.RunAsUser != "" {
+		if strings.ContainsAny(builder.RunAsUser, ", ") {
+			return nil, fmt.Errorf("invalid qemu user %q", builder.RunAsUser)
+		}
+		args = append(args, "-runas", builder.RunAsUser)
+	}
+
+	diskDrive := fmt.Sprintf("if=virtio,format=%s,file=%s", builder.DiskFormat, EscapeOptionValue(builder.DiskPath))
+	if builder.DiskKeyPath != "" {
+		if builder.DiskFormat != "qcow2" {
+			return nil, fmt.Errorf("encrypted disk requires qcow2 format, got %s", builder.DiskFormat)
+		}
+		args = append(args, "-object", fmt.Sprintf("secret,id=disk0-key,format=raw,file=%s", EscapeOptionValue(builder.DiskKeyPath)))
+		diskDrive += ",encrypt.format=luks,encrypt.key-secret=disk0-key"
+	}
+	if builder.DiskSnapshot {
+		diskDrive += ",snapshot=on"
+	}
+
 	args = append(args,
 		"-boot", "order=c",
-		"-drive", fmt.Sprintf("if=virtio,format=%s,file=%s", builder.DiskFormat, builder.DiskPath),
-		"-drive", fmt.Sprintf("if=virtio,format=raw,readonly=on,file=%s", builder.SeedISOPath),
-		"-virtfs", fmt.Sprintf("local,path=%s,mount_tag=workspace,security_model=none,id=workspace", builder.WorkspacePath),
-		"-virtfs", fmt.Sprintf("local,path=%s,mount_tag=state,security_model=none,id=state", builder.StatePath),
+		"-drive", diskDrive,
+		"-drive", fmt.Sprintf("if=virtio,format=raw,readonly=on,file=%s", EscapeOptionValue(builder.SeedISOPath)),
+	)
+	if !builder.NoWorkspace {
+		args = append(args, "-virtfs", fmt.Sprintf("local,path=%s,mount_tag=workspace,security_model=%s,id=workspace", EscapeOptionValue(builder.WorkspacePath), securityModel))
+	}
+	if !builder.NoStateShare {
+		args = append(args, "-virtfs", fmt.Sprintf("local,path=%s,mount_tag=state,security_model=%s,id=state", EscapeOptionValue(builder.StatePath), securityModel))
+	}
+	args = append(args,
 		"-netdev", netdev,
 		"-device", fmt.Sprintf("%s,netdev=net0", builder.NetDevice),
+		"-device", builder.BalloonDevice+",id=balloon0",
 		"-display", "none",
 		"-serial", "file:"+builder.SerialLogPath,
-		"-monitor", "unix:"+builder.MonitorPath+",server,nowait",
+		"-qmp", "unix:"+EscapeOptionValue(builder.MonitorPath)+",server,nowait",
 		"-D", builder.QEMULogPath,
 		"-daemonize",
 		"-pidfile", builder.PIDFilePath,
@@ -170,20 +267,49 @@ func (builder *QemuArgsBuilder) Build() ([]string, error) {
 	if strings.TrimSpace(builder.ClawPath) != "" {
 		args = append(args,
 			"-virtfs",
-			fmt.Sprintf("local,path=%s,mount_tag=claw,security_model=none,id=claw", builder.ClawPath),
+			fmt.Sprintf("local,path=%s,mount_tag=claw,security_model=%s,id=claw", EscapeOptionValue(builder.ClawPath), securityModel),
+		)
+	}
+
+	if strings.TrimSpace(builder.WatchPath) != "" {
+		args = append(args,
+			"-virtfs",
+			fmt.Sprintf("local,path=%s,mount_tag=watch,security_model=none,readonly=on,id=watch", EscapeOptionValue(builder.WatchPath)),
+		)
+	}
+
+	if strings.TrimSpace(builder.RootfsSharePath) != "" {
+		args = append(args,
+			"-virtfs",
+			fmt.Sprintf("local,path=%s,mount_tag=rootfs,security_model=none,readonly=on,id=rootfs", EscapeOptionValue(builder.RootfsSharePath)),
+		)
+	}
+
+	if strings.TrimSpace(builder.OpenClawSharePath) != "" {
+		args = append(args,
+			"-virtfs",
+			fmt.Sprintf("local,path=%s,mount_tag=openclaw-pkg,security_model=none,readonly=on,id=openclaw-pkg", EscapeOptionValue(builder.OpenClawSharePath)),
 		)
 	}
 
 	for index, mount := range builder.VolumeMounts {
 		args = append(args,
 			"-virtfs",
-			fmt.Sprintf("local,path=%s,mount_tag=%s,security_model=none,id=volume%d", mount.HostPath, mount.Tag, index+1),
+			fmt.Sprintf("local,path=%s,mount_tag=%s,security_model=%s,id=volume%d", EscapeOptionValue(mount.HostPath), mount.Tag, securityModel, index+1),
 		)
 	}
 
+	if strings.TrimSpace(builder.IncomingStatePath) != "" {
+		args = append(args, "-incoming", "file:"+builder.IncomingStatePath)
+	}
+
 	return args, nil
 }
 
+func EscapeOptionValue(value string) string {
+	return strings.ReplaceAll(value, ",", ",,")
+}
+
 func NormalizePortForwards(gatewayHostPort int, gatewayGuestPort int, published []PortMapping) ([]PortMapping, error) {
 	if err := ValidatePort(gatewayHostPort); err != nil {
 		return nil, err
@@ -0,0 +1,163 @@
+package vm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	readinessBodyLimit = 1 << 20
+
+	GatewayMarkerHeader        = "X-Clawfarm-Gateway"
+	GatewayMarkerFallback      = "fallback"
+	GatewayMarkerInstallFailed = "install-failed"
+)
+
+var (
+	ErrGatewayInstalling    = errors.New("OpenClaw is still installing (fallback server is answering)")
+	ErrGatewayInstallFailed = errors.New("OpenClaw install failed inside the guest")
+)
+
+type HTTPReadiness struct {
+	Path        string
+	Status      int
+	JSON        map[string]string
+	BearerToken string
+}
+
+func WaitForHTTPReady(ctx context.Context, url string, readiness HTTPReadiness) error {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	target := readiness.URL(url)
+	for {
+		err := CheckHTTPReadiness(target, readiness, 2*time.Second)
+		if err == nil || errors.Is(err, ErrGatewayInstallFailed) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				if readiness.hasMatchers() || errors.Is(err, ErrGatewayInstalling) {
+					return fmt.Errorf("timeout waiting for %s (last check: %v)", target, err)
+				}
+				return fmt.Errorf("timeout waiting for %s", target)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r HTTPReadiness) URL(base string) string {
+	path := strings.TrimSpace(r.Path)
+	if path == "" {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+func (r HTTPReadiness) hasMatchers() bool {
+	return r.Status != 0 || len(r.JSON) > 0
+}
+
+func CheckHTTPReadiness(url string, readiness HTTPReadiness, timeout time.Duration) error {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if readiness.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+readiness.BearerToken)
+	}
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if IsInstallFailedGateway(response.Header) {
+		return ErrGatewayInstallFailed
+	}
+	if IsFallbackGateway(response.Header) {
+		return ErrGatewayInstalling
+	}
+	if readiness.Status != 0 && response.StatusCode != readiness.Status {
+		return fmt.Errorf("expected HTTP %d, got %d", readiness.Status, response.StatusCode)
+	}
+	if response.StatusCode < 100 || response.StatusCode > 599 {
+		return fmt.Errorf("unexpected HTTP status %d", response.StatusCode)
+	}
+	if len(readiness.JSON) == 0 {
+		return nil
+	}
+
+	var payload any
+	if err := json.NewDecoder(io.LimitReader(response.Body, readinessBodyLimit)).Decode(&payload); err != nil {
+		return fmt.Errorf("response is not JSON: %v", err)
+	}
+	keys := make([]string, 0, len(readiness.JSON))
+	for key := range readiness.JSON {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := matchJSONField(payload, key, readiness.JSON[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchJSONField(payload any, key string, expected string) error {
+	value := payload
+	for _, segment := range strings.Split(key, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("json field %s is missing", key)
+		}
+		if value, ok = object[segment]; !ok {
+			return fmt.Errorf("json field %s is missing", key)
+		}
+	}
+
+	actual := ""
+	switch typed := value.(type) {
+	case nil:
+	case string:
+		actual = typed
+	case bool:
+		actual = strconv.FormatBool(typed)
+	case float64:
+		actual = strconv.FormatFloat(typed, 'f', -1, 64)
+	default:
+		encoded, _ := json.Marshal(typed)
+		actual = string(encoded)
+	}
+	if expected == "*" {
+		if actual == "" || actual == "false" {
+			return fmt.Errorf("json field %s is empty", key)
+		}
+		return nil
+	}
+	if actual != expected {
+		return fmt.Errorf("json field %s is %q, expected %q", key, actual, expected)
+	}
+	return nil
+}
+
+func IsFallbackGateway(header http.Header) bool {
+	return strings.EqualFold(header.Get(GatewayMarkerHeader), GatewayMarkerFallback)
+}
+
+func IsInstallFailedGateway(header http.Header) bool {
+	return strings.EqualFold(header.Get(GatewayMarkerHeader), GatewayMarkerInstallFailed)
+}
@@ -0,0 +1,132 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	QEMUMemoryOverheadMiB = 256
+	MinFreeDiskMiB        = 1024
+)
+
+type HostResources struct {
+	AvailableMemoryMiB int64
+	FreeDiskMiB        int64
+}
+
+type ResourceRequest struct {
+	MemoryMiB int
+	DiskPath  string
+}
+
+func ProbeHostResources(diskPath string) (HostResources, error) {
+	memory, err := availableMemoryMiB()
+	if err != nil {
+		return HostResources{}, fmt.Errorf("read available host memory: %w", err)
+	}
+	disk, err := freeDiskMiB(diskPath)
+	if err != nil {
+		return HostResources{}, fmt.Errorf("read free disk space for %s: %w", diskPath, err)
+	}
+	return HostResources{AvailableMemoryMiB: memory, FreeDiskMiB: disk}, nil
+}
+
+func (resources HostResources) Shortfall(request ResourceRequest) error {
+	problems := make([]string, 0, 2)
+	neededMemory := int64(request.MemoryMiB + QEMUMemoryOverheadMiB)
+	if resources.AvailableMemoryMiB < neededMemory {
+		problems = append(problems, fmt.Sprintf("memory: need %d MiB (%d + %d QEMU overhead), %d MiB available", neededMemory, request.MemoryMiB, QEMUMemoryOverheadMiB, resources.AvailableMemoryMiB))
+	}
+	if resources.FreeDiskMiB < MinFreeDiskMiB {
+		problems = append(problems, fmt.Sprintf("disk: need %d MiB free under %s, %d MiB free", MinFreeDiskMiB, request.DiskPath, resources.FreeDiskMiB))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func freeDiskMiB(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail * uint64(stat.Bsize) / (1024 * 1024)), nil
+}
+
+func availableMemoryMiB() (int64, error) {
+	switch runtime.GOOS {
+	case "linux":
+		file, err := os.Open("/proc/meminfo")
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		return parseMemAvailable(bufio.NewScanner(file))
+	case "darwin":
+		output, err := exec.Command("vm_stat").Output()
+		if err != nil {
+			return 0, err
+		}
+		return parseVMStat(string(output))
+	default:
+		return 0, fmt.Errorf("unsupported host OS %s", runtime.GOOS)
+	}
+}
+
+func parseMemAvailable(scanner *bufio.Scanner) (int64, error) {
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kib, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse MemAvailable %q: %w", fields[1], err)
+		}
+		return kib / 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
+
+var vmStatPageSizePattern = regexp.MustCompile(`page size of (\d+) bytes`)
+
+func parseVMStat(output string) (int64, error) {
+	match := vmStatPageSizePattern.FindStringSubmatch(output)
+	if match == nil {
+		return 0, errors.New("page size not found in vm_stat output")
+	}
+	pageSize, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	pages := int64(0)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "Pages free", "Pages inactive", "Pages speculative":
+			count, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), "."), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse vm_stat %s: %w", strings.TrimSpace(name), err)
+			}
+			pages += count
+		}
+	}
+	return pages * pageSize / (1024 * 1024), nil
+}
@@ -0,0 +1,39 @@
+package vm
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	FakeQEMUSubcommand = "fake-qemu"
+	envFakeQEMU        = "CLAWFARM_FAKE_QEMU"
+)
+
+func NewTestBackend(out io.Writer) *QEMUBackend {
+	backend := NewQEMUBackend(out)
+	backend.fakeQEMU = true
+	return backend
+}
+
+func fakeQEMUPlatform() (qemuPlatform, error) {
+	platform := qemuPlatform{
+		Machine:       "q35",
+		CPU:           "max",
+		NetDevice:     "virtio-net-pci",
+		BalloonDevice: "virtio-balloon-pci",
+		Accel:         "test",
+	}
+	if binary := strings.TrimSpace(os.Getenv(envFakeQEMU)); binary != "" {
+		platform.Binary = binary
+		return platform, nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return qemuPlatform{}, err
+	}
+	platform.Binary = executable
+	platform.Args = []string{FakeQEMUSubcommand}
+	return platform, nil
+}
@@ -0,0 +1,383 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/vm/cloudinitbuilder"
+	"github.com/yazhou/krunclaw/internal/vm/qemuargsbuilder"
+)
+
+const (
+	GVProxyHostIP  = "192.168.127.254"
+	gvproxyGuestIP = "192.168.127.2"
+	// gvproxy leases gvproxyGuestIP to this MAC address.
+	gvproxyGuestMAC = "5a:94:ef:e4:0c:ee"
+
+	vfkitSocketTimeout = 10 * time.Second
+)
+
+type VFKitBackend struct {
+	out io.Writer
+}
+
+func NewVFKitBackend(out io.Writer) *VFKitBackend {
+	return &VFKitBackend{out: out}
+}
+
+func (b *VFKitBackend) Start(ctx context.Context, spec StartSpec) (StartResult, error) {
+	if runtime.GOOS != "darwin" {
+		return StartResult{}, errors.New("the vzf backend requires macOS (Virtualization.framework)")
+	}
+	if err := validateVFKitSpec(&spec); err != nil {
+		return StartResult{}, err
+	}
+	vfkitBinary, err := exec.LookPath("vfkit")
+	if err != nil {
+		return StartResult{}, errors.New("vfkit is required for --backend vzf (brew install vfkit)")
+	}
+	gvproxyBinary, err := exec.LookPath("gvproxy")
+	if err != nil {
+		return StartResult{}, errors.New("gvproxy is required for --backend vzf networking (brew install gvproxy)")
+	}
+	if err := os.MkdirAll(spec.InstanceDir, 0o755); err != nil {
+		return StartResult{}, err
+	}
+
+	diskPath, err := prepareVFKitDisk(ctx, spec.SourceDiskPath, spec.InstanceDir, b.out)
+	if err != nil {
+		return StartResult{}, err
+	}
+	seedISO := filepath.Join(spec.InstanceDir, "seed.iso")
+	if err := newCloudInitBuilder(spec).WithShareFilesystem(cloudinitbuilder.ShareFilesystemVirtiofs).CreateNoCloudSeedISO(seedISO); err != nil {
+		return StartResult{}, err
+	}
+
+	paths := newVFKitPaths(spec.InstanceDir)
+	for _, path := range []string{paths.NetSocket, paths.APISocket, paths.RESTSocket} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return StartResult{}, err
+		}
+	}
+
+	gvproxyArgs := []string{"-listen-vfkit", "unixgram://" + paths.NetSocket, "-listen", "unix://" + paths.APISocket, "-ssh-port", "-1"}
+	gvproxyPID, err := startDetached(gvproxyBinary, gvproxyArgs, paths.GVProxyLog)
+	if err != nil {
+		return StartResult{}, fmt.Errorf("start gvproxy failed: %w", err)
+	}
+	if err := os.WriteFile(paths.GVProxyPID, []byte(strconv.Itoa(gvproxyPID)+"\n"), 0o644); err != nil {
+		_ = terminateProcess(ctx, gvproxyPID)
+		return StartResult{}, err
+	}
+	if err := waitForSocket(paths.APISocket, vfkitSocketTimeout); err != nil {
+		_ = terminateProcess(ctx, gvproxyPID)
+		return StartResult{}, err
+	}
+	for _, mapping := range vfkitPortForwards(spec) {
+		if err := exposeGVProxyPort(paths.APISocket, mapping); err != nil {
+			_ = terminateProcess(ctx, gvproxyPID)
+			return StartResult{}, err
+		}
+	}
+
+	args, err := buildVFKitArgs(spec, diskPath, seedISO, paths)
+	if err != nil {
+		_ = terminateProcess(ctx, gvproxyPID)
+		return StartResult{}, err
+	}
+	pid, err := startDetached(vfkitBinary, args, paths.VFKitLog)
+	if err != nil {
+		_ = terminateProcess(ctx, gvproxyPID)
+		return StartResult{}, fmt.Errorf("start vfkit failed: %w", err)
+	}
+
+	writeLine(b.out, "vfkit started: pid=%d gvproxy=%d", pid, gvproxyPID)
+
+	return StartResult{
+		PID:           pid,
+		DiskPath:      diskPath,
+		DiskFormat:    "raw",
+		SeedISOPath:   seedISO,
+		SerialLogPath: paths.SerialLog,
+		QEMULogPath:   paths.VFKitLog,
+		MonitorPath:   paths.RESTSocket,
+		Accel:         BackendVZF,
+		Command:       append([]string{vfkitBinary}, args...),
+	}, nil
+}
+
+// Stop terminates vfkit and then the gvproxy started alongside it, whose PID
+// sits next to the REST socket in the instance directory.
+func (b *VFKitBackend) Stop(ctx context.Context, process Process) error {
+	if err := terminateProcess(ctx, process.PID); err != nil {
+		return err
+	}
+	if process.MonitorPath == "" {
+		return nil
+	}
+	paths := newVFKitPaths(filepath.Dir(process.MonitorPath))
+	gvproxyPID, err := readPIDFile(paths.GVProxyPID)
+	if err != nil {
+		return nil
+	}
+	if err := terminateProcess(ctx, gvproxyPID); err != nil {
+		return err
+	}
+	_ = os.Remove(paths.GVProxyPID)
+	return nil
+}
+
+func (b *VFKitBackend) Suspend(process Process) error {
+	return b.changeState(process, "Pause")
+}
+
+func (b *VFKitBackend) Resume(process Process) error {
+	return b.changeState(process, "Resume")
+}
+
+func (b *VFKitBackend) IsRunning(pid int) bool {
+	return processExists(pid)
+}
+
+func (b *VFKitBackend) changeState(process Process, target string) error {
+	if err := checkProcess(process.PID); err != nil {
+		return err
+	}
+	if process.MonitorPath == "" {
+		return fmt.Errorf("process %d has no vfkit REST socket recorded", process.PID)
+	}
+	payload, err := json.Marshal(map[string]string{"state": target})
+	if err != nil {
+		return err
+	}
+	return postUnixJSON(process.MonitorPath, "/vm/state", payload)
+}
+
+type vfkitPaths struct {
+	NetSocket  string
+	APISocket  string
+	RESTSocket string
+	EFIStore   string
+	SerialLog  string
+	VFKitLog   string
+	GVProxyLog string
+	GVProxyPID string
+}
+
+func newVFKitPaths(instanceDir string) vfkitPaths {
+	return vfkitPaths{
+		NetSocket:  filepath.Join(instanceDir, "gvproxy-net.sock"),
+		APISocket:  filepath.Join(instanceDir, "gvproxy-api.sock"),
+		RESTSocket: filepath.Join(instanceDir, "vfkit-rest.sock"),
+		EFIStore:   filepath.Join(instanceDir, "efi-variables.fd"),
+		SerialLog:  filepath.Join(instanceDir, "serial.log"),
+		VFKitLog:   filepath.Join(instanceDir, "vfkit.log"),
+		GVProxyLog: filepath.Join(instanceDir, "gvproxy.log"),
+		GVProxyPID: filepath.Join(instanceDir, "gvproxy.pid"),
+	}
+}
+
+func validateVFKitSpec(spec *StartSpec) error {
+	if spec.CPUs <= 0 {
+		spec.CPUs = defaultCPUs
+	}
+	if spec.MemoryMiB <= 0 {
+		spec.MemoryMiB = defaultMemoryMiB
+	}
+	if spec.GatewayGuestPort <= 0 {
+		spec.GatewayGuestPort = spec.GatewayHostPort
+	}
+	if spec.OpenClawPackage == "" {
+		spec.OpenClawPackage = "openclaw@latest"
+	}
+	if err := qemuargsbuilder.ValidatePort(spec.GatewayHostPort); err != nil {
+		return fmt.Errorf("gateway host port: %w", err)
+	}
+	if err := qemuargsbuilder.ValidatePort(spec.GatewayGuestPort); err != nil {
+		return fmt.Errorf("gateway guest port: %w", err)
+	}
+	if _, _, err := buildVolumeMountSpecs(spec.VolumeMounts); err != nil {
+		return err
+	}
+	if err := ValidateGuestUser(spec.GuestUser); err != nil {
+		return err
+	}
+	switch {
+	case spec.DiskKeyPath != "":
+		return errors.New("--backend vzf does not support --encrypt-disk")
+	case spec.IncomingStatePath != "":
+		return errors.New("--backend vzf does not support memory snapshots")
+	case spec.Hardened || spec.QEMUUser != "":
+		return errors.New("--backend vzf does not support --hardened")
+	case spec.RootfsMode == RootfsReadOnlyOverlay:
+		return errors.New("--backend vzf does not support --rootfs ro-overlay")
+	case spec.ShareOwnership == ShareOwnershipMapped:
+		return errors.New("--backend vzf does not support --share-ownership mapped")
+	case spec.ImageArch != "" && spec.ImageArch != detectHostArch():
+		return fmt.Errorf("--backend vzf cannot emulate %s on a %s host; use the qemu backend", spec.ImageArch, detectHostArch())
+	}
+
+	hasAlias := false
+	for _, entry := range spec.ExtraHosts {
+		if strings.EqualFold(strings.TrimSpace(entry.Name), HostAliasName) {
+			hasAlias = true
+		}
+	}
+	if !hasAlias {
+		spec.ExtraHosts = append([]HostEntry{{Name: HostAliasName, IP: GVProxyHostIP}}, spec.ExtraHosts...)
+	}
+	return nil
+}
+
+func buildVFKitArgs(spec StartSpec, diskPath string, seedISO string, paths vfkitPaths) ([]string, error) {
+	qemuVolumeMounts, _, err := buildVolumeMountSpecs(spec.VolumeMounts)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--cpus", strconv.Itoa(spec.CPUs),
+		"--memory", strconv.Itoa(spec.MemoryMiB),
+		"--bootloader", "efi,variable-store=" + paths.EFIStore + ",create",
+		"--restful-uri", "unix://" + paths.RESTSocket,
+		"--device", "virtio-blk,path=" + diskPath,
+		"--device", "virtio-blk,path=" + seedISO,
+		"--device", "virtio-net,unixSocketPath=" + paths.NetSocket + ",mac=" + gvproxyGuestMAC,
+		"--device", "virtio-serial,logFilePath=" + paths.SerialLog,
+		"--device", "virtio-rng",
+	}
+	share := func(hostPath string, tag string) {
+		args = append(args, "--device", "virtio-fs,sharedDir="+hostPath+",mountTag="+tag)
+	}
+	if !spec.NoWorkspace && spec.WorkspacePath != "" {
+		share(spec.WorkspacePath, "workspace")
+	}
+	if spec.StatePath != "" && spec.StateMode != StateModeDisk && spec.StateMode != StateModeNone {
+		share(spec.StatePath, "state")
+	}
+	if spec.ClawPath != "" {
+		share(spec.ClawPath, "claw")
+	}
+	if spec.WatchPath != "" {
+		share(spec.WatchPath, "watch")
+	}
+	if path := rootfsSharePath(spec.RootfsTarPath); path != "" {
+		share(path, "rootfs")
+	}
+	if path := openClawSharePath(spec.OpenClawTarballPath); path != "" {
+		share(path, "openclaw-pkg")
+	}
+	for _, volume := range qemuVolumeMounts {
+		share(volume.HostPath, volume.Tag)
+	}
+	return args, nil
+}
+
+func vfkitPortForwards(spec StartSpec) []PortMapping {
+	forwards := []PortMapping{{HostPort: spec.GatewayHostPort, GuestPort: spec.GatewayGuestPort}}
+	return append(forwards, spec.PublishedPorts...)
+}
+
+func prepareVFKitDisk(ctx context.Context, sourceDiskPath string, instanceDir string, out io.Writer) (string, error) {
+	diskPath, format, err := prepareInstanceDisk(sourceDiskPath, instanceDir, out)
+	if err != nil || format == "raw" {
+		return diskPath, err
+	}
+	rawPath := filepath.Join(instanceDir, "rootfs.raw")
+	if _, err := os.Stat(rawPath); err == nil {
+		return rawPath, nil
+	}
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		return "", errors.New("qemu-img is required to convert the qcow2 image to raw for --backend vzf")
+	}
+	writeLine(out, "converting %s to raw for Virtualization.framework", diskPath)
+	if err := diskutil.Convert(ctx, diskPath, rawPath, "raw", nil); err != nil {
+		return "", err
+	}
+	return rawPath, nil
+}
+
+func startDetached(binary string, args []string, logPath string) (int, error) {
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	command := exec.Command(binary, args...)
+	command.Stdout = logFile
+	command.Stderr = logFile
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := command.Start(); err != nil {
+		return 0, err
+	}
+	pid := command.Process.Pid
+	go func() {
+		_ = command.Wait()
+	}()
+	return pid, nil
+}
+
+func waitForSocket(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func exposeGVProxyPort(apiSocket string, mapping PortMapping) error {
+	payload, err := json.Marshal(map[string]string{
+		"local":  fmt.Sprintf("127.0.0.1:%d", mapping.HostPort),
+		"remote": fmt.Sprintf("%s:%d", gvproxyGuestIP, mapping.GuestPort),
+	})
+	if err != nil {
+		return err
+	}
+	if err := postUnixJSON(apiSocket, "/services/forwarder/expose", payload); err != nil {
+		return fmt.Errorf("forward 127.0.0.1:%d: %w", mapping.HostPort, err)
+	}
+	return nil
+}
+
+func postUnixJSON(socketPath string, path string, payload []byte) error {
+	client := &http.Client{
+		Timeout: vfkitSocketTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	response, err := client.Post("http://unix"+path, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", path, response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+// Package clawfarm embeds clawfarm in Go programs: it runs OpenClaw
+// instances, lists them, fetches base images and manages checkpoints
+// without shelling out to the clawfarm CLI.
+package clawfarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/app"
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/images"
+	"github.com/yazhou/krunclaw/internal/state"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrBusy     = state.ErrBusy
+)
+
+type Options struct {
+	DataDir  string
+	CacheDir string
+	Context  string
+	Out      io.Writer
+	ErrOut   io.Writer
+}
+
+type Client struct {
+	app *app.App
+}
+
+func New(options Options) *Client {
+	out, errOut := options.writers()
+	return newClient(options, app.NewEmbedded(out, errOut, vm.NewHostBackend(out)))
+}
+
+func newClient(options Options, application *app.App) *Client {
+	application.SetDirs(config.DirOverrides{
+		DataDir:  options.DataDir,
+		CacheDir: options.CacheDir,
+		Context:  options.Context,
+	})
+	return &Client{app: application}
+}
+
+func (o Options) writers() (io.Writer, io.Writer) {
+	out, errOut := o.Out, o.ErrOut
+	if out == nil {
+		out = io.Discard
+	}
+	if errOut == nil {
+		errOut = io.Discard
+	}
+	return out, errOut
+}
+
+type PortMapping struct {
+	HostPort  int
+	GuestPort int
+}
+
+// Volume mounts the named clawfarm volume at GuestPath. Volumes live under the
+// instance directory, like `clawfarm run --volume name:/guest/path`; Name is
+// not a host path.
+type Volume struct {
+	Name      string
+	GuestPath string
+}
+
+type RunOptions struct {
+	// Target is an image ref (ubuntu:24.04), a .clawbox file or a spec JSON.
+	Target       string
+	Name         string
+	Workspace    string
+	NoWorkspace  bool
+	GatewayPort  int
+	CPUs         int
+	MemoryMiB    int
+	NoWait       bool
+	ReadyTimeout time.Duration
+	Publish      []PortMapping
+	Volumes      []Volume
+	Env          map[string]string
+	// ExtraArgs are appended verbatim to the equivalent `clawfarm run` flags.
+	ExtraArgs []string
+}
+
+func (o RunOptions) args() ([]string, error) {
+	target := strings.TrimSpace(o.Target)
+	if target == "" {
+		return nil, errors.New("run target is required")
+	}
+	args := []string{target}
+	if o.Name != "" {
+		args = append(args, "--name", o.Name)
+	}
+	if o.NoWorkspace {
+		args = append(args, "--no-workspace")
+	} else if o.Workspace != "" {
+		args = append(args, "--workspace", o.Workspace)
+	}
+	if o.GatewayPort > 0 {
+		args = append(args, "--port", strconv.Itoa(o.GatewayPort))
+	}
+	if o.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(o.CPUs))
+	}
+	if o.MemoryMiB > 0 {
+		args = append(args, "--memory-mib", strconv.Itoa(o.MemoryMiB))
+	}
+	if o.NoWait {
+		args = append(args, "--no-wait")
+	}
+	if o.ReadyTimeout > 0 {
+		seconds := int((o.ReadyTimeout + time.Second - 1) / time.Second)
+		args = append(args, "--ready-timeout-secs", strconv.Itoa(seconds))
+	}
+	for _, mapping := range o.Publish {
+		args = append(args, "--publish", fmt.Sprintf("%d:%d", mapping.HostPort, mapping.GuestPort))
+	}
+	for _, volume := range o.Volumes {
+		args = append(args, "--volume", volume.Name+":"+volume.GuestPath)
+	}
+	keys := make([]string, 0, len(o.Env))
+	for key := range o.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--openclaw-env", key+"="+o.Env[key])
+	}
+	return append(args, o.ExtraArgs...), nil
+}
+
+type Instance struct {
+	ID             string
+	ImageRef       string
+	Status         string
+	GatewayURL     string
+	PublishedPorts []PortMapping
+	PID            int
+	SSHPort        int
+	WorkspacePath  string
+	LastError      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type Image struct {
+	Ref        string
+	Arch       string
+	DiskPath   string
+	DiskFormat string
+	FetchedAt  time.Time
+}
+
+type Checkpoint struct {
+	Name      string
+	Path      string
+	SizeBytes int64
+	CreatedAt time.Time
+}
+
+func (c *Client) RunInstance(ctx context.Context, options RunOptions) (Instance, error) {
+	args, err := options.args()
+	if err != nil {
+		return Instance{}, err
+	}
+	var instance state.Instance
+	err = c.do(ctx, func() error {
+		var runErr error
+		instance, runErr = c.app.RunInstance(ctx, args)
+		return runErr
+	})
+	if instance.ID == "" {
+		return Instance{}, err
+	}
+	return newInstance(instance), err
+}
+
+func (c *Client) Instance(ctx context.Context, id string) (Instance, error) {
+	var instance state.Instance
+	err := c.do(ctx, func() error {
+		var loadErr error
+		instance, loadErr = c.loadInstance(id)
+		return loadErr
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+	return newInstance(instance), nil
+}
+
+func (c *Client) ListInstances(ctx context.Context) ([]Instance, error) {
+	var instances []state.Instance
+	err := c.do(ctx, func() error {
+		var listErr error
+		instances, listErr = c.app.ListInstances()
+		return listErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Instance, 0, len(instances))
+	for _, instance := range instances {
+		result = append(result, newInstance(instance))
+	}
+	return result, nil
+}
+
+func (c *Client) FetchImage(ctx context.Context, ref string) (Image, error) {
+	var meta images.Metadata
+	err := c.do(ctx, func() error {
+		var fetchErr error
+		meta, fetchErr = c.app.FetchImage(ctx, ref)
+		return fetchErr
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{
+		Ref:        meta.Ref,
+		Arch:       meta.Arch,
+		DiskPath:   meta.RuntimeDisk,
+		DiskFormat: meta.DiskFormat,
+		FetchedAt:  meta.FetchedAtUTC,
+	}, nil
+}
+
+func (c *Client) Checkpoint(ctx context.Context, id string, name string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	err := c.do(ctx, func() error {
+		if _, err := c.loadInstance(id); err != nil {
+			return err
+		}
+		if _, err := c.app.Checkpoint(id, name); err != nil {
+			return err
+		}
+		var err error
+		checkpoint, err = c.findCheckpoint(id, name)
+		return err
+	})
+	return checkpoint, err
+}
+
+func (c *Client) ListCheckpoints(ctx context.Context, id string) ([]Checkpoint, error) {
+	var checkpoints []Checkpoint
+	err := c.do(ctx, func() error {
+		if _, err := c.loadInstance(id); err != nil {
+			return err
+		}
+		var err error
+		checkpoints, err = c.checkpoints(id)
+		return err
+	})
+	return checkpoints, err
+}
+
+func (c *Client) Restore(ctx context.Context, id string, name string) error {
+	return c.do(ctx, func() error {
+		if _, err := c.loadInstance(id); err != nil {
+			return err
+		}
+		if _, err := c.findCheckpoint(id, name); err != nil {
+			return err
+		}
+		_, err := c.app.Restore(id, name)
+		return err
+	})
+}
+
+func (c *Client) do(ctx context.Context, operation func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return operation()
+}
+
+func (c *Client) loadInstance(id string) (state.Instance, error) {
+	instance, err := c.app.LoadInstance(id)
+	if errors.Is(err, state.ErrNotFound) {
+		return state.Instance{}, fmt.Errorf("%w: instance %s", ErrNotFound, id)
+	}
+	return instance, err
+}
+
+func newInstance(instance state.Instance) Instance {
+	result := Instance{
+		ID:            instance.ID,
+		ImageRef:      instance.ImageRef,
+		Status:        instance.Status,
+		PID:           instance.PID,
+		SSHPort:       instance.SSHHostPort,
+		WorkspacePath: instance.WorkspacePath,
+		LastError:     instance.LastError,
+		CreatedAt:     instance.CreatedAtUTC,
+		UpdatedAt:     instance.UpdatedAtUTC,
+	}
+	if port := instance.GatewayPort(); port > 0 {
+		result.GatewayURL = fmt.Sprintf("http://127.0.0.1:%d/", port)
+	}
+	for _, mapping := range instance.PublishedPorts {
+		result.PublishedPorts = append(result.PublishedPorts, PortMapping{HostPort: mapping.HostPort, GuestPort: mapping.GuestPort})
+	}
+	return result
+}
+
+func (c *Client) checkpoints(id string) ([]Checkpoint, error) {
+	listed, err := c.app.Checkpoints(id)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make([]Checkpoint, 0, len(listed))
+	for _, checkpoint := range listed {
+		checkpoints = append(checkpoints, Checkpoint{
+			Name:      checkpoint.Name,
+			Path:      checkpoint.Path,
+			SizeBytes: checkpoint.SizeBytes,
+			CreatedAt: checkpoint.CreatedAtUTC,
+		})
+	}
+	return checkpoints, nil
+}
+
+func (c *Client) findCheckpoint(id string, name string) (Checkpoint, error) {
+	checkpoints, err := c.checkpoints(id)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	name = strings.TrimSuffix(name, ".qcow2")
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Name == name {
+			return checkpoint, nil
+		}
+	}
+	return Checkpoint{}, fmt.Errorf("%w: checkpoint %s for %s", ErrNotFound, name, id)
+}
@@ -0,0 +1,225 @@
+package clawfarm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/app"
+	"github.com/yazhou/krunclaw/internal/vm"
+)
+
+type fakeBackend struct {
+	mu      sync.Mutex
+	nextPID int
+	running map[int]bool
+	// starting, when set, is signalled and Start then blocks until ctx ends.
+	starting chan struct{}
+}
+
+func (f *fakeBackend) Start(ctx context.Context, spec vm.StartSpec) (vm.StartResult, error) {
+	if f.starting != nil {
+		close(f.starting)
+		<-ctx.Done()
+		return vm.StartResult{}, ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextPID++
+	f.running[f.nextPID] = true
+	return vm.StartResult{
+		PID:           f.nextPID,
+		DiskPath:      filepath.Join(spec.InstanceDir, "rootfs.qcow2"),
+		DiskFormat:    "qcow2",
+		SerialLogPath: filepath.Join(spec.InstanceDir, "serial.log"),
+		QEMULogPath:   filepath.Join(spec.InstanceDir, "qemu.log"),
+		PIDFilePath:   filepath.Join(spec.InstanceDir, "qemu.pid"),
+	}, nil
+}
+
+func (f *fakeBackend) Stop(_ context.Context, process vm.Process) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.running, process.PID)
+	return nil
+}
+
+func (f *fakeBackend) Suspend(vm.Process) error { return nil }
+
+func (f *fakeBackend) Resume(vm.Process) error { return nil }
+
+func (f *fakeBackend) IsRunning(pid int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running[pid]
+}
+
+func newTestClient(t *testing.T) (*Client, string) {
+	t.Helper()
+	return newTestClientWithBackend(t, &fakeBackend{nextPID: 7000, running: map[int]bool{}})
+}
+
+func newTestClientWithBackend(t *testing.T, backend *fakeBackend) (*Client, string) {
+	t.Helper()
+	cacheDir := t.TempDir()
+	dataDir := t.TempDir()
+	imageDir := filepath.Join(cacheDir, "images", "ubuntu_24.04")
+	if err := os.MkdirAll(imageDir, 0o755); err != nil {
+		t.Fatalf("mkdir image dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imageDir, "image.img"), []byte("x"), 0o644); err != nil {
+		t.Fatalf("write image: %v", err)
+	}
+	metadata := `{"ref":"ubuntu:24.04","version":"24.04","codename":"noble","arch":"amd64","image_dir":"` + imageDir + `","runtime_disk":"` + filepath.Join(imageDir, "image.img") + `","ready":true,"disk_format":"raw","fetched_at_utc":"2026-02-08T00:00:00Z","updated_at_utc":"2026-02-08T00:00:00Z"}`
+	if err := os.WriteFile(filepath.Join(imageDir, "image.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatalf("write image metadata: %v", err)
+	}
+	application := app.NewWithBackend(io.Discard, io.Discard, backend)
+	return newClient(Options{DataDir: dataDir, CacheDir: cacheDir}, application), dataDir
+}
+
+func TestRunOptionsArgs(t *testing.T) {
+	args, err := RunOptions{
+		Target:       "ubuntu:24.04",
+		Name:         "demo",
+		Workspace:    "/src",
+		GatewayPort:  18800,
+		CPUs:         4,
+		MemoryMiB:    2048,
+		NoWait:       true,
+		ReadyTimeout: 1500 * time.Millisecond,
+		Publish:      []PortMapping{{HostPort: 8080, GuestPort: 80}},
+		Volumes:      []Volume{{Name: "cache", GuestPath: "/cache"}},
+		Env:          map[string]string{"B": "2", "A": "1"},
+		ExtraArgs:    []string{"--ssh"},
+	}.args()
+	if err != nil {
+		t.Fatalf("args: %v", err)
+	}
+	expected := []string{"ubuntu:24.04", "--name", "demo", "--workspace", "/src", "--port", "18800", "--cpus", "4", "--memory-mib", "2048", "--no-wait", "--ready-timeout-secs", "2", "--publish", "8080:80", "--volume", "cache:/cache", "--openclaw-env", "A=1", "--openclaw-env", "B=2", "--ssh"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected args:\n got %v\nwant %v", args, expected)
+	}
+	if _, err := (RunOptions{}).args(); err == nil {
+		t.Fatal("expected an error without a run target")
+	}
+}
+
+func TestClientRunsListsCheckpointsAndRestores(t *testing.T) {
+	client, dataDir := newTestClient(t)
+	ctx := context.Background()
+
+	image, err := client.FetchImage(ctx, "ubuntu:24.04")
+	if err != nil {
+		t.Fatalf("fetch image: %v", err)
+	}
+	if image.Ref != "ubuntu:24.04" || image.Arch != "amd64" {
+		t.Fatalf("unexpected image: %+v", image)
+	}
+
+	instance, err := client.RunInstance(ctx, RunOptions{
+		Target:      "ubuntu:24.04",
+		Workspace:   t.TempDir(),
+		GatewayPort: 65532,
+		NoWait:      true,
+		ExtraArgs:   []string{"--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"},
+	})
+	if err != nil {
+		t.Fatalf("run instance: %v", err)
+	}
+	if instance.ID == "" || instance.PID == 0 || instance.GatewayURL != "http://127.0.0.1:65532/" {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "claws", instance.ID, "instance.json")); err != nil {
+		t.Fatalf("expected instance state under the configured data dir: %v", err)
+	}
+
+	instances, err := client.ListInstances(ctx)
+	if err != nil {
+		t.Fatalf("list instances: %v", err)
+	}
+	if len(instances) != 1 || instances[0].ID != instance.ID {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+
+	diskPath := filepath.Join(dataDir, "claws", instance.ID, "rootfs.qcow2")
+	if err := os.WriteFile(diskPath, []byte("before"), 0o644); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	checkpoint, err := client.Checkpoint(ctx, instance.ID, "base")
+	if err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	if checkpoint.Name != "base" || checkpoint.SizeBytes != int64(len("before")) {
+		t.Fatalf("unexpected checkpoint: %+v", checkpoint)
+	}
+	if err := os.WriteFile(diskPath, []byte("after"), 0o644); err != nil {
+		t.Fatalf("rewrite disk: %v", err)
+	}
+	if err := client.Restore(ctx, instance.ID, "base"); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	restored, err := os.ReadFile(diskPath)
+	if err != nil {
+		t.Fatalf("read disk: %v", err)
+	}
+	if string(restored) != "before" {
+		t.Fatalf("expected restored disk, got %q", restored)
+	}
+
+	if err := client.Restore(ctx, instance.ID, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing checkpoint, got %v", err)
+	}
+	if _, err := client.Instance(ctx, "claw-missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a missing instance, got %v", err)
+	}
+}
+
+func TestRunInstanceStopsWhenContextIsCancelled(t *testing.T) {
+	backend := &fakeBackend{nextPID: 7000, running: map[int]bool{}, starting: make(chan struct{})}
+	client, _ := newTestClientWithBackend(t, backend)
+	other, otherDataDir := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.RunInstance(ctx, RunOptions{
+			Target:      "ubuntu:24.04",
+			Workspace:   t.TempDir(),
+			GatewayPort: 65531,
+			NoWait:      true,
+			ExtraArgs:   []string{"--openclaw-model-primary", "openai/gpt-5", "--openclaw-openai-api-key", "test-key"},
+		})
+		done <- err
+	}()
+
+	select {
+	case <-backend.starting:
+	case err := <-done:
+		t.Fatalf("run returned before starting the VM: %v", err)
+	}
+	instances, err := other.ListInstances(context.Background())
+	if err != nil {
+		t.Fatalf("a second client should not wait for the first one's run: %v", err)
+	}
+	if len(instances) != 0 {
+		t.Fatalf("expected no instances under %s, got %+v", otherDataDir, instances)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not stop after its context was cancelled")
+	}
+}
@@ -0,0 +1,124 @@
+// Package images lists, fetches, resolves and converts the VM base images
+// that clawfarm runs, for tools that build on clawfarm's image cache.
+//
+// The exported API follows semantic versioning as reported by APIVersion and
+// is kept independent of CLI flags and output: breaking changes only land
+// with a major version bump.
+package images
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/yazhou/krunclaw/internal/config"
+	"github.com/yazhou/krunclaw/internal/diskutil"
+	"github.com/yazhou/krunclaw/internal/images"
+)
+
+const APIVersion = "1.0.0"
+
+// ErrNotFetched is returned by Resolve for images that are not in the cache.
+var ErrNotFetched = images.ErrImageNotFetched
+
+// Progress receives byte counts while an image downloads or converts. Total
+// is zero when the size is not known up front.
+type Progress func(done int64, total int64)
+
+type Options struct {
+	// CacheDir defaults to the clawfarm cache directory
+	// (CLAWFARM_CACHE_DIR or the XDG cache home).
+	CacheDir string
+	// Context selects a named clawfarm context when CacheDir is empty,
+	// like the --context flag; it defaults to $CLAWFARM_CONTEXT.
+	Context string
+	// Out receives human-readable status lines; nil discards them.
+	Out io.Writer
+}
+
+type Image struct {
+	Ref        string
+	Version    string
+	Codename   string
+	Arch       string
+	DiskPath   string
+	DiskFormat string
+	Source     string
+	Ready      bool
+	FetchedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+type Store struct {
+	manager *images.Manager
+}
+
+func Open(options Options) (*Store, error) {
+	cacheDir, err := config.DirOverrides{CacheDir: options.CacheDir, Context: options.Context}.ResolveCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{manager: images.NewManager(cacheDir, options.Out)}, nil
+}
+
+// List returns the images present in the cache, most recently updated first.
+func (s *Store) List(ctx context.Context) ([]Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	listed, err := s.manager.List()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Image, 0, len(listed))
+	for _, meta := range listed {
+		result = append(result, newImage(meta))
+	}
+	return result, nil
+}
+
+// Fetch downloads ref into the cache unless it is already there.
+func (s *Store) Fetch(ctx context.Context, ref string, progress Progress) (Image, error) {
+	manager := s.manager
+	if progress != nil {
+		manager = manager.WithProgress(progress)
+	}
+	meta, err := manager.Fetch(ctx, ref)
+	if err != nil {
+		return Image{}, err
+	}
+	return newImage(meta), nil
+}
+
+// Resolve returns the cached image for ref, or ErrNotFetched.
+func (s *Store) Resolve(ctx context.Context, ref string) (Image, error) {
+	if err := ctx.Err(); err != nil {
+		return Image{}, err
+	}
+	meta, err := s.manager.Resolve(ref)
+	if err != nil {
+		return Image{}, err
+	}
+	return newImage(meta), nil
+}
+
+// Convert writes source to destination as a "raw" or "qcow2" disk. It needs
+// qemu-img unless source is already in the requested format.
+func Convert(ctx context.Context, source string, destination string, format string, progress Progress) error {
+	return diskutil.Convert(ctx, source, destination, format, progress)
+}
+
+func newImage(meta images.Metadata) Image {
+	return Image{
+		Ref:        meta.Ref,
+		Version:    meta.Version,
+		Codename:   meta.Codename,
+		Arch:       meta.Arch,
+		DiskPath:   meta.RuntimeDisk,
+		DiskFormat: meta.DiskFormat,
+		Source:     meta.Source,
+		Ready:      meta.Ready,
+		FetchedAt:  meta.FetchedAtUTC,
+		UpdatedAt:  meta.UpdatedAtUTC,
+	}
+}
@@ -0,0 +1,126 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStoreListsResolvesAndFetchesCachedImages(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("unsupported architecture in test environment")
+	}
+	cacheDir := t.TempDir()
+	store, err := Open(Options{CacheDir: cacheDir})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := store.Resolve(ctx, "ubuntu:24.04"); !errors.Is(err, ErrNotFetched) {
+		t.Fatalf("expected ErrNotFetched before fetch, got %v", err)
+	}
+
+	imageDir := filepath.Join(cacheDir, "images", "ubuntu_24.04")
+	if err := os.MkdirAll(imageDir, 0o755); err != nil {
+		t.Fatalf("mkdir image dir: %v", err)
+	}
+	diskPath := filepath.Join(imageDir, "image.img")
+	if err := os.WriteFile(diskPath, []byte("disk"), 0o644); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	payload, _ := json.Marshal(map[string]string{"ref": "ubuntu:24.04", "version": "24.04", "codename": "noble", "arch": runtime.GOARCH, "disk_format": "raw"})
+	if err := os.WriteFile(filepath.Join(imageDir, "image.json"), payload, 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	listed, err := store.List(ctx)
+	if err != nil || len(listed) != 1 || listed[0].Ref != "ubuntu:24.04" || !listed[0].Ready || listed[0].DiskPath != diskPath {
+		t.Fatalf("unexpected list result %+v (err %v)", listed, err)
+	}
+	resolved, err := store.Resolve(ctx, "ubuntu:24.04")
+	if err != nil || resolved.Codename != "noble" || resolved.DiskFormat != "raw" {
+		t.Fatalf("unexpected resolve result %+v (err %v)", resolved, err)
+	}
+	fetched, err := store.Fetch(ctx, "ubuntu:24.04", func(int64, int64) {
+		t.Fatal("cached fetch should not report download progress")
+	})
+	if err != nil || fetched.DiskPath != diskPath {
+		t.Fatalf("unexpected fetch result %+v (err %v)", fetched, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := store.List(cancelled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled list, got %v", err)
+	}
+}
+
+func TestOpenResolvesTheCacheOfTheRequestedContext(t *testing.T) {
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("unsupported architecture in test environment")
+	}
+	home := t.TempDir()
+	t.Setenv("CLAWFARM_HOME", home)
+	t.Setenv("CLAWFARM_CACHE_DIR", "")
+	t.Setenv("CLAWFARM_CONTEXT", "")
+
+	imageDir := filepath.Join(home, "contexts", "staging", "images", "ubuntu_24.04")
+	if err := os.MkdirAll(imageDir, 0o755); err != nil {
+		t.Fatalf("mkdir image dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imageDir, "image.img"), []byte("disk"), 0o644); err != nil {
+		t.Fatalf("write disk: %v", err)
+	}
+	payload, _ := json.Marshal(map[string]string{"ref": "ubuntu:24.04", "version": "24.04", "codename": "noble", "arch": runtime.GOARCH, "disk_format": "raw"})
+	if err := os.WriteFile(filepath.Join(imageDir, "image.json"), payload, 0o644); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	for name, expected := range map[string]int{"": 0, "staging": 1} {
+		store, err := Open(Options{Context: name})
+		if err != nil {
+			t.Fatalf("open store for context %q: %v", name, err)
+		}
+		listed, err := store.List(context.Background())
+		if err != nil || len(listed) != expected {
+			t.Fatalf("context %q: expected %d image(s), got %+v (err %v)", name, expected, listed, err)
+		}
+	}
+	if _, err := Open(Options{Context: "Not Valid"}); err == nil {
+		t.Fatal("expected an invalid context to be rejected")
+	}
+}
+
+func TestConvertCopiesDisksAlreadyInTargetFormat(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.img")
+	if err := os.WriteFile(source, []byte("raw disk bytes"), 0o644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	t.Setenv("PATH", dir)
+
+	var done, total int64
+	destination := filepath.Join(dir, "converted.raw")
+	if err := Convert(context.Background(), source, destination, "raw", func(d int64, tt int64) { done, total = d, tt }); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	copied, err := os.ReadFile(destination)
+	if err != nil || string(copied) != "raw disk bytes" {
+		t.Fatalf("unexpected converted disk %q (err %v)", copied, err)
+	}
+	if done != int64(len(copied)) || total != done {
+		t.Fatalf("expected completed progress, got %d/%d", done, total)
+	}
+
+	if err := Convert(context.Background(), source, filepath.Join(dir, "out.vmdk"), "vmdk", nil); err == nil {
+		t.Fatal("expected unsupported format to be rejected")
+	}
+	if err := Convert(context.Background(), source, filepath.Join(dir, "out.qcow2"), "qcow2", nil); err == nil {
+		t.Fatal("expected qcow2 conversion without qemu-img to fail")
+	}
+}